@@ -0,0 +1,630 @@
+// activitypub.go - Minimal ActivityPub actor for the blog
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apDomain      = "zachkp.dev"
+	apBaseURL     = "https://" + apDomain
+	apUsername    = "blog"
+	apActorURL    = apBaseURL + "/ap/actor"
+	apContentType = "application/activity+json"
+	apPublic      = "https://www.w3.org/ns/activitystreams#Public"
+
+	apMaxDeliveryAttempts = 6
+)
+
+var (
+	apPrivateKey  *rsa.PrivateKey
+	apPublicPEM   string
+	apHTTPClient  = newHTTPClient("ActivityPub", 15*time.Second) // from httpclient.go
+	apDeliverWake = make(chan struct{}, 1)
+)
+
+// An inbox activity signed by someone other than its actor
+var errAPActorMismatch = errors.New("activity actor isn't the signer")
+
+// Initialize ActivityPub storage, signing key and delivery worker
+func initActivityPub() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS ap_keys (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		private_pem TEXT NOT NULL,
+		public_pem TEXT NOT NULL
+	)`, `
+	CREATE TABLE IF NOT EXISTS ap_followers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor TEXT NOT NULL UNIQUE,
+		inbox TEXT NOT NULL,
+		shared_inbox TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS ap_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		inbox TEXT NOT NULL,
+		activity TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create ActivityPub tables:", err)
+		}
+	}
+
+	loadOrCreateAPKey()
+	registerPublishHook("activitypub", federatePost)
+	startWorker("activitypub-delivery", apDeliveryWorker) // from safego.go
+}
+
+// The actor key must be stable across restarts or followers break
+func loadOrCreateAPKey() {
+	var privatePEM string
+	err := db.QueryRow("SELECT private_pem, public_pem FROM ap_keys WHERE id = 1").Scan(&privatePEM, &apPublicPEM)
+	if err == nil {
+		block, _ := pem.Decode([]byte(privatePEM))
+		if block == nil {
+			log.Fatal("Stored ActivityPub key is not valid PEM")
+		}
+		apPrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			log.Fatal("Failed to parse ActivityPub key:", err)
+		}
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Fatal("Failed to load ActivityPub key:", err)
+	}
+
+	apPrivateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("Failed to generate ActivityPub key:", err)
+	}
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(apPrivateKey)}))
+	publicDER, err := x509.MarshalPKIXPublicKey(&apPrivateKey.PublicKey)
+	if err != nil {
+		log.Fatal("Failed to encode ActivityPub public key:", err)
+	}
+	apPublicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	_, err = db.Exec("INSERT INTO ap_keys (id, private_pem, public_pem) VALUES (1, ?, ?)", privatePEM, apPublicPEM)
+	if err != nil {
+		log.Fatal("Failed to store ActivityPub key:", err)
+	}
+	log.Println("Generated ActivityPub actor key")
+}
+
+func apJSON(c *gin.Context, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, apContentType+"; charset=utf-8", body)
+}
+
+func apActorDocument() gin.H {
+	return gin.H{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                apActorURL,
+		"type":              "Service",
+		"preferredUsername": apUsername,
+		"name":              "Zach-Dev Blog",
+		"summary":           "New posts from zachkp.dev",
+		"url":               apBaseURL + "/blog",
+		"inbox":             apBaseURL + "/ap/inbox",
+		"outbox":            apBaseURL + "/ap/outbox",
+		"followers":         apBaseURL + "/ap/followers",
+		"icon":              gin.H{"type": "Image", "url": apBaseURL + "/images/zach.jpg"},
+		"publicKey": gin.H{
+			"id":           apActorURL + "#main-key",
+			"owner":        apActorURL,
+			"publicKeyPem": apPublicPEM,
+		},
+	}
+}
+
+// Create activity for a blog post
+func apCreateActivity(post Post) gin.H {
+	published := post.Date().UTC().Format(time.RFC3339)
+	return gin.H{
+		"@context":  "https://www.w3.org/ns/activitystreams",
+		"id":        post.Permalink() + "#create",
+		"type":      "Create",
+		"actor":     apActorURL,
+		"published": published,
+		"to":        []string{apPublic},
+		"cc":        []string{apBaseURL + "/ap/followers"},
+		"object": gin.H{
+			"id":           post.Permalink(),
+			"type":         "Article",
+			"attributedTo": apActorURL,
+			"name":         post.Title,
+			"summary":      post.Summary,
+			"content":      string(post.HTML()),
+			"url":          post.Permalink(),
+			"published":    published,
+			"to":           []string{apPublic},
+			"cc":           []string{apBaseURL + "/ap/followers"},
+		},
+	}
+}
+
+// Queue an activity for every follower inbox (shared inboxes deduplicated)
+func enqueueAPActivity(ctx context.Context, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(ctx, `
+		INSERT INTO ap_deliveries (inbox, activity)
+		SELECT DISTINCT COALESCE(NULLIF(shared_inbox, ''), inbox), ? FROM ap_followers
+	`, string(body))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case apDeliverWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Queue a single activity for one inbox
+func enqueueAPDelivery(ctx context.Context, inbox string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	if _, err := dbExec(ctx, "INSERT INTO ap_deliveries (inbox, activity) VALUES (?, ?)", inbox, string(body)); err != nil {
+		return err
+	}
+	select {
+	case apDeliverWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Federate a newly published post to followers
+func federatePost(post Post) {
+	ctx := context.Background()
+	if err := enqueueAPActivity(ctx, apCreateActivity(post)); err != nil {
+		log.Printf("ActivityPub: could not queue post %d: %v", post.ID, err)
+	}
+}
+
+// Background worker delivering queued activities with exponential backoff
+func apDeliveryWorker() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		runRecovered("activitypub-delivery", processAPDeliveries)
+		select {
+		case <-ticker.C:
+		case <-apDeliverWake:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+func processAPDeliveries() {
+	ctx := context.Background()
+	rows, err := dbQuery(ctx, `
+		SELECT id, inbox, activity, attempts FROM ap_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY id LIMIT 50
+	`, time.Now())
+	if err != nil {
+		log.Printf("ActivityPub: error loading delivery queue: %v", err)
+		recordJobRun("activitypub-delivery", time.Minute, err)
+		return
+	}
+	defer recordJobRun("activitypub-delivery", time.Minute, nil)
+
+	type delivery struct {
+		id       int
+		inbox    string
+		activity string
+		attempts int
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.inbox, &d.activity, &d.attempts); err == nil {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range due {
+		err := apSignedPost(d.inbox, []byte(d.activity))
+		if err == nil {
+			dbExec(ctx, "UPDATE ap_deliveries SET status = 'delivered', attempts = attempts + 1, last_error = NULL WHERE id = ?", d.id)
+			continue
+		}
+
+		attempts := d.attempts + 1
+		status := "pending"
+		if attempts >= apMaxDeliveryAttempts {
+			status = "failed"
+		}
+		backoff := time.Duration(1<<attempts) * time.Minute
+		dbExec(ctx, "UPDATE ap_deliveries SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+			status, attempts, err.Error(), time.Now().Add(backoff), d.id)
+		log.Printf("ActivityPub: delivery %d to %s failed (attempt %d): %v", d.id, d.inbox, attempts, err)
+	}
+}
+
+// POST a body to an inbox with an HTTP signature (draft-cavage, rsa-sha256)
+func apSignedPost(inbox string, body []byte) error {
+	target, err := url.Parse(inbox)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	req.Header.Set("Content-Type", apContentType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", target.Host)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+
+	signingString := fmt.Sprintf("(request-target): post %s\nhost: %s\ndate: %s\ndigest: %s",
+		target.RequestURI(), target.Host, req.Header.Get("Date"), req.Header.Get("Digest"))
+	hashed := sha256.Sum256([]byte(signingString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, apPrivateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",algorithm="rsa-sha256",headers="(request-target) host date digest",signature="%s"`,
+		apActorURL, base64.StdEncoding.EncodeToString(signature)))
+
+	resp, err := apHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("inbox returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Fetch a remote actor document
+func fetchAPActor(actorURL string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, actorURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", apContentType)
+
+	resp, err := apHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("actor fetch returned %d", resp.StatusCode)
+	}
+
+	var actor map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// Headers an inbox request's signature must cover, so neither the body nor
+// the time it was sent can be swapped
+var apRequiredSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// How far an inbox request's Date may be from now, so a captured request
+// (a Follow undone since, say) can't be replayed later
+const apSignatureMaxSkew = 12 * time.Hour
+
+// Parsed Signature header of an inbox request
+type apSignature struct {
+	KeyID     string
+	Headers   []string
+	Signature []byte
+}
+
+// Parse a Signature header, requiring every header in apRequiredSignedHeaders
+// to be signed
+func parseAPSignature(header string) (apSignature, error) {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return apSignature{}, errors.New("missing signature")
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && algorithm != "rsa-sha256" && algorithm != "hs2019" {
+		return apSignature{}, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	sig := apSignature{KeyID: params["keyId"], Headers: strings.Fields(strings.ToLower(params["headers"]))}
+	for _, required := range apRequiredSignedHeaders {
+		signed := false
+		for _, h := range sig.Headers {
+			signed = signed || h == required
+		}
+		if !signed {
+			return apSignature{}, fmt.Errorf("signature doesn't cover %s", required)
+		}
+	}
+
+	var err error
+	if sig.Signature, err = base64.StdEncoding.DecodeString(params["signature"]); err != nil {
+		return apSignature{}, fmt.Errorf("signature isn't base64: %w", err)
+	}
+	return sig, nil
+}
+
+// Check an inbox request's Digest matches the body and its Date is recent
+func checkAPRequest(r *http.Request, body []byte, now time.Time) error {
+	digest := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]) {
+		return errors.New("missing or mismatched digest")
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return errors.New("missing or invalid date")
+	}
+	if skew := now.Sub(date); skew > apSignatureMaxSkew || skew < -apSignatureMaxSkew {
+		return fmt.Errorf("date %s is too far from now", r.Header.Get("Date"))
+	}
+	return nil
+}
+
+// The string the sender signed: the signed headers, in order
+func apSigningString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.RequestURI()))
+		case "host":
+			lines = append(lines, "host: "+r.Host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Verify a parsed signature over the request with the signer's key
+func verifyAPRequestSignature(r *http.Request, sig apSignature, key *rsa.PublicKey) error {
+	hashed := sha256.Sum256([]byte(apSigningString(r, sig.Headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig.Signature); err != nil {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// An actor document's RSA public key
+func apActorPublicKey(actor map[string]interface{}) (*rsa.PublicKey, error) {
+	publicKey, _ := actor["publicKey"].(map[string]interface{})
+	publicPEM, _ := publicKey["publicKeyPem"].(string)
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, errors.New("actor has no public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return rsaKey, nil
+}
+
+// Verify the HTTP signature on an incoming inbox request, and that the
+// activity's actor signed it, returning the actor. Everything that can be
+// checked without the actor's key is checked first, so unsigned, stale or
+// misattributed requests never make us fetch anything.
+func verifyAPSignature(c *gin.Context, body []byte) (string, map[string]interface{}, error) {
+	sig, err := parseAPSignature(c.GetHeader("Signature"))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkAPRequest(c.Request, body, time.Now()); err != nil {
+		return "", nil, err
+	}
+
+	actorURL := strings.SplitN(sig.KeyID, "#", 2)[0]
+	var activity struct {
+		Actor string `json:"actor"`
+	}
+	if json.Unmarshal(body, &activity) != nil || activity.Actor != actorURL {
+		return "", nil, errAPActorMismatch
+	}
+	if !isPublicLongURL(actorURL) { // from main.go
+		return "", nil, fmt.Errorf("key %q isn't on a public http(s) host", sig.KeyID)
+	}
+	actor, err := fetchAPActor(actorURL) // through the guarded apHTTPClient
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := apActorPublicKey(actor)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := verifyAPRequestSignature(c.Request, sig, key); err != nil {
+		return "", nil, err
+	}
+	return actorURL, actor, nil
+}
+
+// Setup WebFinger and ActivityPub routes
+func setupActivityPubRoutes(r *gin.Engine) {
+	// The actor is the primary site's owner; tenants have no fediverse presence
+	ap := r.Group("/", primarySiteOnlyMiddleware()) // from sites.go
+
+	ap.GET("/.well-known/webfinger", func(c *gin.Context) {
+		resource := c.Query("resource")
+		if resource != "acct:"+apUsername+"@"+apDomain && resource != apActorURL {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown resource"})
+			return
+		}
+		c.Header("Content-Type", "application/jrd+json")
+		c.JSON(http.StatusOK, gin.H{
+			"subject": "acct:" + apUsername + "@" + apDomain,
+			"aliases": []string{apActorURL},
+			"links": []gin.H{
+				{"rel": "self", "type": apContentType, "href": apActorURL},
+				{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": apBaseURL + "/blog"},
+			},
+		})
+	})
+
+	ap.GET("/ap/actor", func(c *gin.Context) {
+		apJSON(c, http.StatusOK, apActorDocument())
+	})
+
+	ap.GET("/ap/outbox", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		posts, err := getPublishedPosts(ctx)
+		if err != nil {
+			log.Printf("ActivityPub: error loading posts: %v", err)
+		}
+		items := make([]gin.H, 0, len(posts))
+		for _, post := range posts {
+			items = append(items, apCreateActivity(post))
+		}
+		apJSON(c, http.StatusOK, gin.H{
+			"@context":     "https://www.w3.org/ns/activitystreams",
+			"id":           apBaseURL + "/ap/outbox",
+			"type":         "OrderedCollection",
+			"totalItems":   len(items),
+			"orderedItems": items,
+		})
+	})
+
+	// Follower identities are not published, only the count
+	ap.GET("/ap/followers", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var count int
+		dbQueryRow(ctx, "SELECT COUNT(*) FROM ap_followers").Scan(&count)
+		apJSON(c, http.StatusOK, gin.H{
+			"@context":   "https://www.w3.org/ns/activitystreams",
+			"id":         apBaseURL + "/ap/followers",
+			"type":       "OrderedCollection",
+			"totalItems": count,
+		})
+	})
+
+	ap.POST("/ap/inbox", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		var activity map[string]interface{}
+		if err := json.Unmarshal(body, &activity); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		signer, actor, err := verifyAPSignature(c, body)
+		if errors.Is(err, errAPActorMismatch) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("ActivityPub: rejected inbox request: %v", err)
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+
+		switch activity["type"] {
+		case "Follow":
+			inbox, _ := actor["inbox"].(string)
+			sharedInbox := ""
+			if endpoints, ok := actor["endpoints"].(map[string]interface{}); ok {
+				sharedInbox, _ = endpoints["sharedInbox"].(string)
+			}
+			if inbox == "" {
+				c.Status(http.StatusBadRequest)
+				return
+			}
+
+			_, err := dbExec(ctx, `
+				INSERT INTO ap_followers (actor, inbox, shared_inbox) VALUES (?, ?, ?)
+				ON CONFLICT(actor) DO UPDATE SET inbox = excluded.inbox, shared_inbox = excluded.shared_inbox
+			`, signer, inbox, sharedInbox)
+			if err != nil {
+				log.Printf("ActivityPub: error saving follower: %v", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+
+			accept := gin.H{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id":       fmt.Sprintf("%s#accepts/%d", apActorURL, time.Now().UnixNano()),
+				"type":     "Accept",
+				"actor":    apActorURL,
+				"object":   activity,
+			}
+			if err := enqueueAPDelivery(ctx, inbox, accept); err != nil {
+				log.Printf("ActivityPub: error queueing Accept: %v", err)
+			}
+			log.Printf("ActivityPub: new follower %s", signer)
+
+		case "Undo":
+			if object, ok := activity["object"].(map[string]interface{}); ok && object["type"] == "Follow" {
+				dbExec(ctx, "DELETE FROM ap_followers WHERE actor = ?", signer)
+				log.Printf("ActivityPub: %s unfollowed", signer)
+			}
+
+		case "Delete":
+			// Account deletions arrive signed by the deleted actor
+			dbExec(ctx, "DELETE FROM ap_followers WHERE actor = ?", signer)
+		}
+
+		c.Status(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,184 @@
+// activitypub_test.go - Inbox HTTP signature checks
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testActorURL      = "https://remote.example/users/alice"
+	testInboxActivity = `{"type":"Follow","actor":"https://remote.example/users/alice"}`
+)
+
+// An inbox request signed over headers the way apSignedPost signs
+func signedInboxRequest(t *testing.T, key *rsa.PrivateKey, keyID, body string, date time.Time, headers string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/ap/inbox", strings.NewReader(body))
+	digest := sha256.Sum256([]byte(body))
+	req.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+
+	hashed := sha256.Sum256([]byte(apSigningString(req, strings.Fields(headers))))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, headers, base64.StdEncoding.EncodeToString(signature)))
+	return req
+}
+
+type apStubTransport func(*http.Request) (*http.Response, error)
+
+func (f apStubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Serve testActorURL's actor document, with key, in place of the network;
+// returns a count of the fetches made
+func stubAPActor(t *testing.T, key *rsa.PublicKey) *int {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor, _ := json.Marshal(map[string]any{
+		"id":        testActorURL,
+		"inbox":     testActorURL + "/inbox",
+		"publicKey": map[string]any{"id": testActorURL + "#main-key", "publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+	})
+
+	fetches := 0
+	previous := apHTTPClient
+	apHTTPClient = &http.Client{Transport: apStubTransport(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		if req.URL.String() != testActorURL {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(actor)), Request: req}, nil
+	})}
+	t.Cleanup(func() { apHTTPClient = previous })
+	return &fetches
+}
+
+func TestParseAPSignature(t *testing.T) {
+	const sig = "c2lnbmF0dXJl"
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"valid", `keyId="https://a.example/u#k",algorithm="rsa-sha256",headers="(request-target) host date digest",signature="` + sig + `"`, true},
+		{"hs2019", `keyId="https://a.example/u#k",algorithm="hs2019",headers="(request-target) host date digest content-type",signature="` + sig + `"`, true},
+		{"empty", ``, false},
+		{"no headers", `keyId="https://a.example/u#k",signature="` + sig + `"`, false},
+		{"date only", `keyId="https://a.example/u#k",headers="date",signature="` + sig + `"`, false},
+		{"no digest", `keyId="https://a.example/u#k",headers="(request-target) host date",signature="` + sig + `"`, false},
+		{"no keyId", `headers="(request-target) host date digest",signature="` + sig + `"`, false},
+		{"no signature", `keyId="https://a.example/u#k",headers="(request-target) host date digest"`, false},
+		{"bad base64", `keyId="https://a.example/u#k",headers="(request-target) host date digest",signature="!!"`, false},
+		{"other algorithm", `keyId="https://a.example/u#k",algorithm="hmac-sha256",headers="(request-target) host date digest",signature="` + sig + `"`, false},
+	}
+	for _, tt := range tests {
+		_, err := parseAPSignature(tt.header)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: parseAPSignature error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestVerifyAPSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	const signed = "(request-target) host date digest"
+	const keyID = testActorURL + "#main-key"
+	sign := func(body string, date time.Time) func() *http.Request {
+		return func() *http.Request { return signedInboxRequest(t, key, keyID, body, date, signed) }
+	}
+
+	tests := []struct {
+		name      string
+		req       func() *http.Request
+		body      string
+		actorKey  *rsa.PublicKey // the key the actor document serves
+		ok        bool
+		wantFetch bool
+	}{
+		{"valid", sign(testInboxActivity, now), testInboxActivity, &key.PublicKey, true, true},
+		{"date a little off", sign(testInboxActivity, now.Add(-time.Hour)), testInboxActivity, &key.PublicKey, true, true},
+		{"wrong key", sign(testInboxActivity, now), testInboxActivity, &otherKey.PublicKey, false, true},
+		{"tampered date", func() *http.Request {
+			req := sign(testInboxActivity, now.Add(-time.Hour))()
+			req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+			return req
+		}, testInboxActivity, &key.PublicKey, false, true},
+		{"unknown actor", func() *http.Request {
+			return signedInboxRequest(t, key, "https://remote.example/users/carol#main-key", `{"type":"Follow","actor":"https://remote.example/users/carol"}`, now, signed)
+		}, `{"type":"Follow","actor":"https://remote.example/users/carol"}`, &key.PublicKey, false, true},
+
+		// Refused before the actor is fetched
+		{"tampered body", sign(testInboxActivity, now), `{"type":"Delete","actor":"https://remote.example/users/alice"}`, &key.PublicKey, false, false},
+		{"missing digest", func() *http.Request {
+			req := sign(testInboxActivity, now)()
+			req.Header.Del("Digest")
+			return req
+		}, testInboxActivity, &key.PublicKey, false, false},
+		{"replayed", sign(testInboxActivity, now.Add(-13*time.Hour)), testInboxActivity, &key.PublicKey, false, false},
+		{"future date", sign(testInboxActivity, now.Add(13*time.Hour)), testInboxActivity, &key.PublicKey, false, false},
+		{"digest not signed", func() *http.Request {
+			return signedInboxRequest(t, key, keyID, testInboxActivity, now, "(request-target) host date")
+		}, testInboxActivity, &key.PublicKey, false, false},
+		{"another actor's activity", sign(`{"type":"Delete","actor":"https://remote.example/users/bob"}`, now), `{"type":"Delete","actor":"https://remote.example/users/bob"}`, &key.PublicKey, false, false},
+		{"key on an internal host", func() *http.Request {
+			return signedInboxRequest(t, key, "http://127.0.0.1/users/alice#main-key", `{"type":"Follow","actor":"http://127.0.0.1/users/alice"}`, now, signed)
+		}, `{"type":"Follow","actor":"http://127.0.0.1/users/alice"}`, &key.PublicKey, false, false},
+	}
+	for _, tt := range tests {
+		fetches := stubAPActor(t, tt.actorKey)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = tt.req()
+		signer, _, err := verifyAPSignature(c, []byte(tt.body))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if tt.ok && signer != testActorURL {
+			t.Errorf("%s: signer = %q, want %q", tt.name, signer, testActorURL)
+		}
+		if (*fetches > 0) != tt.wantFetch {
+			t.Errorf("%s: actor fetched %d times, want fetched %v", tt.name, *fetches, tt.wantFetch)
+		}
+	}
+}
+
+func TestAPActorPublicKeyRequiresRSA(t *testing.T) {
+	if _, err := apActorPublicKey(map[string]interface{}{}); err == nil {
+		t.Error("actor without a key accepted")
+	}
+	if _, err := apActorPublicKey(map[string]interface{}{"publicKey": map[string]interface{}{"publicKeyPem": "nope"}}); err == nil {
+		t.Error("malformed key accepted")
+	}
+}
@@ -2,14 +2,20 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
+	"database/sql"
 	"encoding/hex"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -43,17 +49,48 @@ type AdminStats struct {
 	VisitorsThisWeek int64           `json:"visitors_this_week"`
 }
 
+// Stats queries that grow with the visitors and urls tables. Conditions are
+// written as ranges on indexed columns, and /admin/diagnostics flags any of
+// them that fall back to a full table scan.
+const (
+	statsUniqueVisitorsQuery = `SELECT COUNT(DISTINCT hashed_ip) FROM visitors`
+	statsVisitorsTodayQuery  = `SELECT COUNT(*) FROM visitors WHERE timestamp >= DATE('now')`
+	statsVisitorsWeekQuery   = `SELECT COUNT(*) FROM visitors WHERE timestamp >= datetime('now', '-7 days')`
+	statsTopURLsQuery        = `
+		SELECT short_code, original_url, created_at, COALESCE(clicks, 0)
+		FROM urls
+		ORDER BY urls.clicks DESC, urls.created_at DESC
+		LIMIT 10`
+	statsRecentVisitorsQuery = `
+		SELECT id, hashed_ip, user_agent, path, timestamp
+		FROM visitors
+		ORDER BY timestamp DESC
+		LIMIT 50`
+)
+
+// Session secret and IP-hash salt, persisted and rotated by rotation.go
 var adminToken string
 var hashingSalt string
 
+// How long an admin session lasts
+const adminSessionLifetime = 24 * time.Hour
+
 // Initialize admin system with privacy considerations
 func initAdminToken() {
-	adminToken = generateAdminToken()
-	hashingSalt = generateAdminToken() // Use for IP hashing
+	createTable := `
+	CREATE TABLE IF NOT EXISTS admin_session_revocations (
+		session_id TEXT PRIMARY KEY,
+		revoked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := db.Exec(createTable); err != nil {
+		log.Fatal("Failed to create admin_session_revocations table:", err)
+	}
+
+	initKeyRotation() // from rotation.go
 
 	log.Printf("Admin access available at: /admin/login")
 	if gin.Mode() == gin.DebugMode {
-		log.Printf("Admin token (dev only): %s", adminToken)
+		log.Printf("Admin token (dev only): %s", currentAdminToken())
 	}
 
 	log.Println("Privacy: Visitor tracking enabled with hashed IP addresses")
@@ -67,10 +104,12 @@ func generateAdminToken() string {
 	return hex.EncodeToString(bytes)
 }
 
-// Hash IP address for privacy compliance (consistent per IP)
+// Hash IP address for security features and logs (consistent per IP
+// within a UTC day; the salt rotates at midnight, see rotation.go). Page
+// views use the configured strategy from anonymize.go instead.
 func hashIP(ip string) string {
 	hash := sha256.New()
-	hash.Write([]byte(ip + hashingSalt))
+	hash.Write([]byte(ip + currentIPSalt()))
 	return hex.EncodeToString(hash.Sum(nil))[:16] // Truncate for storage efficiency
 }
 
@@ -78,7 +117,9 @@ func hashIP(ip string) string {
 func adminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, err := c.Cookie("admin_token")
-		if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		site := siteFromContext(c.Request.Context())
+		id, ok := adminSessionFromCookie(site, token)
+		if err != nil || !ok || adminSessionRevoked(site, id) {
 			c.Redirect(http.StatusFound, "/admin/login")
 			c.Abort()
 			return
@@ -87,45 +128,196 @@ func adminAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// Admin session cookie: a random session id, when it was issued, and a
+// MAC over both keyed by the site's admin token (from sites.go), so each
+// session can be revoked on its own and none outlives adminSessionLifetime
+func newAdminSessionCookie(s *Site) (id, cookie string) {
+	id = generateAdminToken()[:16]
+	payload := id + "." + strconv.FormatInt(time.Now().Unix(), 10)
+	return id, payload + "." + adminSessionMAC(siteAdminToken(s), payload)
+}
+
+func adminSessionMAC(key, payload string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("admin-session:" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Session id from a genuine, unexpired cookie. Cookies signed before the
+// session secret rotated still count during the overlap (from rotation.go).
+func adminSessionFromCookie(s *Site, cookie string) (string, bool) {
+	i := strings.LastIndex(cookie, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, signature := cookie[:i], cookie[i+1:]
+	id, issued, _ := strings.Cut(payload, ".")
+	issuedAt, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil || time.Since(time.Unix(issuedAt, 0)) > adminSessionLifetime {
+		return "", false
+	}
+	for _, key := range siteAdminTokens(s) {
+		if subtle.ConstantTimeCompare([]byte(signature), []byte(adminSessionMAC(key, payload))) == 1 {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// End one session early. Revocations are kept until the session would
+// have ended anyway (see purgeSessionRevocations).
+func revokeAdminSession(s *Site, id string) {
+	ctx := withSite(context.Background(), s) // from sites.go
+	if _, err := dbExec(ctx, "INSERT OR IGNORE INTO admin_session_revocations (session_id) VALUES (?)", id); err != nil {
+		log.Printf("Error revoking admin session: %v", err)
+	}
+}
+
+func adminSessionRevoked(s *Site, id string) bool {
+	var revoked int
+	err := dbQueryRow(withSite(context.Background(), s), "SELECT 1 FROM admin_session_revocations WHERE session_id = ?", id).Scan(&revoked)
+	return err == nil
+}
+
+// Sign the admin in with a new session (24 hours), record it, and check
+// where it comes from (from loginalerts.go)
+func startAdminSession(c *gin.Context, site *Site, ipHash, detail string) {
+	id, cookie := newAdminSessionCookie(site)
+	c.SetCookie("admin_token", cookie, int(adminSessionLifetime.Seconds()), "/admin", "", false, true)
+	recordSecurityEvent(c.Request.Context(), eventSessionCreated, ipHash, detail)
+	checkLoginCountry(c, site, id, ipHash)
+}
+
 // Privacy-conscious visitor tracking middleware
 func visitorTrackingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Skip tracking for static files and admin pages
+		// Skip tracking for static files, admin pages, token links, beacons
+		// and the rest of the paths routePolicies marks untracked
 		path := c.Request.URL.Path
-		if strings.HasPrefix(path, "/static/") ||
-			strings.HasPrefix(path, "/images/") ||
-			strings.HasPrefix(path, "/admin/") ||
-			strings.HasPrefix(path, "/favicon") ||
-			strings.HasPrefix(path, "/privacy") {
+		if routePolicyFor(path).Untracked { // from routepolicy.go
 			c.Next()
 			return
 		}
 
-		// Respect Do Not Track header
-		if c.GetHeader("DNT") == "1" {
+		// Respect Do Not Track and the opt-out cookie, don't track admin
+		// previews or requests the admin's rules exclude, and count nothing
+		// while the database is read-only
+		if trackingStatus(c) != trackingTracked || isPreview(c) || degradedMode(c.Request.Context()) != nil ||
+			trackingExcluded(c, path) { // from trackingexclusions.go
+			c.Next()
+			return
+		}
+
+		// Tagged inbound links count toward their campaign in every mode (from campaigns.go)
+		if campaign, ok := campaignFromQuery(c.Request.URL.Query()); ok {
+			countCampaignVisit(c.Request.Context(), campaign, path)
+		}
+
+		// Country and strict modes keep only aggregate counts (from analyticsmode.go)
+		if mode := analyticsMode(); aggregateOnlyMode(mode) {
+			countPageView(c.Request.Context(), mode, requestCountry(c), path)
 			c.Next()
 			return
 		}
 
 		// Track visitor with hashed IP in background
-		go trackVisitorPrivacy(c.ClientIP(), c.GetHeader("User-Agent"), path)
+		queueVisit(c.Request.Context(), c.ClientIP(), c.GetHeader("User-Agent"), path)
 		c.Next()
 	}
 }
 
+// Cookie set by the opt-out button on /privacy
+const (
+	noTrackCookie       = "no-track"
+	noTrackCookieMaxAge = 2 * 365 * 24 * 60 * 60 // 2 years
+)
+
+// Whether this browser's page views are recorded
+const (
+	trackingTracked    = "tracked"
+	trackingOptedOut   = "opted-out"
+	trackingDoNotTrack = "dnt"
+)
+
+func trackingStatus(c *gin.Context) string {
+	if _, err := c.Cookie(noTrackCookie); err == nil {
+		return trackingOptedOut
+	}
+	if c.GetHeader("DNT") == "1" {
+		return trackingDoNotTrack
+	}
+	return trackingTracked
+}
+
+// Page view waiting to be recorded
+type visitorEvent struct {
+	site      *Site // from sites.go
+	ip        string
+	userAgent string
+	path      string
+}
+
+// Page views queued for the tracking worker; when it falls behind (e.g. the
+// database is locked) new views are dropped rather than piling up goroutines
+const visitorQueueSize = 1024
+
+var (
+	visitorEvents  = make(chan visitorEvent, visitorQueueSize)
+	visitorDropped atomic.Int64
+)
+
+// Queue a page view without blocking the request
+func queueVisit(ctx context.Context, ip, userAgent, path string) {
+	select {
+	case visitorEvents <- visitorEvent{site: siteFromContext(ctx), ip: ip, userAgent: userAgent, path: path}:
+	default:
+		if visitorDropped.Add(1) == 1 {
+			log.Printf("Visitor tracking queue full, dropping page views")
+		}
+	}
+}
+
+// Record queued page views one at a time, finishing the queue at shutdown
+func visitorTrackingWorker() {
+	for {
+		select {
+		case event := <-visitorEvents:
+			recordVisit(event)
+		case <-shuttingDown:
+			for {
+				select {
+				case event := <-visitorEvents:
+					recordVisit(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func recordVisit(event visitorEvent) {
+	runRecovered("visitor-tracking", func() {
+		trackVisitorPrivacy(withSite(context.Background(), event.site), event.ip, event.userAgent, event.path)
+	})
+	if dropped := visitorDropped.Swap(0); dropped > 0 {
+		log.Printf("Visitor tracking dropped %d page views while the queue was full", dropped)
+	}
+}
+
 // Track visitor with privacy protections
-func trackVisitorPrivacy(ip, userAgent, path string) {
-	hashedIP := hashIP(ip)
+func trackVisitorPrivacy(ctx context.Context, ip, userAgent, path string) {
+	hashedIP := anonymizeVisitorIP(ip) // from anonymize.go
 
 	// Try the new schema first (hashed_ip column)
-	_, err := db.Exec(`
+	_, err := dbExec(ctx, `
 		INSERT INTO visitors (hashed_ip, user_agent, path, timestamp) 
 		VALUES (?, ?, ?, ?)
 	`, hashedIP, userAgent, path, time.Now())
 
 	if err != nil {
 		// If that fails, try the old schema (ip column) for backwards compatibility
-		_, fallbackErr := db.Exec(`
+		_, fallbackErr := dbExec(ctx, `
 			INSERT INTO visitors (ip, user_agent, path, timestamp) 
 			VALUES (?, ?, ?, ?)
 		`, hashedIP, userAgent, path, time.Now())
@@ -134,6 +326,9 @@ func trackVisitorPrivacy(ip, userAgent, path string) {
 			log.Printf("Error recording visitor (tried both schemas): %v | %v", err, fallbackErr)
 		}
 	}
+
+	// Copy to the external analytics provider, if one is set (from analyticsexport.go)
+	exportPageView(ctx, hashedIP, userAgent, path)
 }
 
 // Initialize privacy-conscious visitor tracking
@@ -177,8 +372,13 @@ func initVisitorTracking() {
 	addClicksColumn := `ALTER TABLE urls ADD COLUMN clicks INTEGER DEFAULT 0`
 	db.Exec(addClicksColumn) // Ignore error if column already exists
 
-	// Clean up old visitor data for privacy compliance (run in background)
-	go cleanupOldVisitorData()
+	// Indexes for the stats queries (query plans are checked on /admin/diagnostics)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_visitors_timestamp ON visitors (timestamp)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_visitors_hashed_ip ON visitors (hashed_ip)`) // Fails harmlessly on the old schema
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_urls_clicks ON urls (clicks, created_at)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_urls_created ON urls (created_at)`)
+
+	startWorker("visitor-tracking", visitorTrackingWorker) // from safego.go
 
 	log.Println("Privacy-conscious visitor tracking initialized")
 }
@@ -202,7 +402,12 @@ func createNewVisitorTable() {
 	log.Println("Created new privacy-conscious visitors table")
 }
 
-// Migrate existing visitor table to new schema
+// Migrate existing visitor table to new schema. Visits keep their identity:
+// raw IPs in a preserved ip column are hashed with the configured strategy
+// (from anonymize.go), and values that were already hashes are kept, so
+// unique counts carry on. Rows without an ip each get a placeholder of their
+// own, so old history counts a visitor per visit rather than one visitor in
+// all. The old table is only dropped once every row has been copied.
 func migrateVisitorTable() {
 	// Step 1: Rename old table
 	_, err := db.Exec(`ALTER TABLE visitors RENAME TO visitors_old`)
@@ -228,37 +433,11 @@ func migrateVisitorTable() {
 			WHERE name='ip'
 		`).Scan(&hasIPColumn)
 
-		var migrateQuery string
-		if hasIPColumn {
-			// Old table has ip column - hash the existing IPs
-			migrateQuery = `
-			INSERT INTO visitors (hashed_ip, user_agent, path, timestamp, country)
-			SELECT 
-				printf('%016x', abs(random()) % 1000000000) as hashed_ip,
-				user_agent, 
-				path, 
-				timestamp, 
-				COALESCE(country, '') 
-			FROM visitors_old`
-		} else {
-			// Very old table without ip column
-			migrateQuery = `
-			INSERT INTO visitors (hashed_ip, user_agent, path, timestamp, country)
-			SELECT 
-				printf('%016x', abs(random()) % 1000000000) as hashed_ip,
-				user_agent, 
-				path, 
-				timestamp, 
-				COALESCE(country, '') 
-			FROM visitors_old`
-		}
-
-		_, err = db.Exec(migrateQuery)
-		if err != nil {
-			log.Printf("Error migrating visitor data: %v", err)
-		} else {
-			log.Printf("Successfully migrated %d visitor records", count)
+		if err := copyLegacyVisitors(hasIPColumn); err != nil {
+			log.Printf("Error migrating visitor data, keeping visitors_old: %v", err)
+			return
 		}
+		log.Printf("Successfully migrated %d visitor records", count)
 	}
 
 	// Step 4: Drop old table
@@ -270,87 +449,113 @@ func migrateVisitorTable() {
 	}
 }
 
-// Cleanup old visitor data for privacy compliance
-func cleanupOldVisitorData() {
-	result, err := db.Exec(`
-		DELETE FROM visitors 
-		WHERE timestamp < datetime('now', '-12 months')
-	`)
+// Copy visitors_old into the new table in one transaction
+func copyLegacyVisitors(hasIPColumn bool) error {
+	ipColumn := "''"
+	if hasIPColumn {
+		ipColumn = "COALESCE(ip, '')"
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		log.Printf("Error cleaning up old visitor data: %v", err)
-		return
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO visitors (hashed_ip, user_agent, path, timestamp, country) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	rows, err := tx.Query(`SELECT rowid, ` + ipColumn + `, user_agent, path, timestamp, COALESCE(country, '') FROM visitors_old ORDER BY rowid`)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	rowsDeleted, _ := result.RowsAffected()
-	if rowsDeleted > 0 {
-		log.Printf("Privacy cleanup: Removed %d visitor records older than 12 months", rowsDeleted)
+	for rows.Next() {
+		var rowID int64
+		var ip, country string
+		var userAgent, path, timestamp sql.NullString
+		if err := rows.Scan(&rowID, &ip, &userAgent, &path, &timestamp, &country); err != nil {
+			return err
+		}
+		// The old schema's fallback stored hashes in the ip column too
+		hashedIP := ip
+		if net.ParseIP(ip) != nil {
+			hashedIP = anonymizeVisitorIP(ip) // from anonymize.go
+		} else if ip == "" {
+			hashedIP = hashIP("legacy-visit:" + strconv.FormatInt(rowID, 10))
+		}
+		if _, err := insert.Exec(hashedIP, userAgent, path, timestamp, country); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
+	return tx.Commit()
 }
 
-// Get admin stats with flexible schema support
-func getAdminStats() (*AdminStats, error) {
+// Get admin stats, shared between concurrent dashboards (from statscache.go)
+func getAdminStats(ctx context.Context) (*AdminStats, error) {
+	return cachedDashboardQuery(ctx, "admin-stats", loadAdminStats)
+}
+
+// Query admin stats with flexible schema support
+func loadAdminStats(ctx context.Context) (*AdminStats, error) {
 	stats := &AdminStats{}
 
 	// Total visitors
-	err := db.QueryRow("SELECT COUNT(*) FROM visitors").Scan(&stats.TotalVisitors)
+	err := dbQueryRow(ctx, "SELECT COUNT(*) FROM visitors").Scan(&stats.TotalVisitors)
 	if err != nil {
 		return nil, err
 	}
 
 	// Unique visitors - check which IP column exists
 	var hasHashedIP bool
-	db.QueryRow(`
+	dbQueryRow(ctx, `
 		SELECT COUNT(*) > 0 FROM pragma_table_info('visitors') 
 		WHERE name='hashed_ip'
 	`).Scan(&hasHashedIP)
 
 	if hasHashedIP {
-		err = db.QueryRow("SELECT COUNT(DISTINCT hashed_ip) FROM visitors").Scan(&stats.UniqueVisitors)
+		err = dbQueryRow(ctx, statsUniqueVisitorsQuery).Scan(&stats.UniqueVisitors)
 	} else {
 		// Fallback to old ip column
-		err = db.QueryRow("SELECT COUNT(DISTINCT ip) FROM visitors").Scan(&stats.UniqueVisitors)
+		err = dbQueryRow(ctx, "SELECT COUNT(DISTINCT ip) FROM visitors").Scan(&stats.UniqueVisitors)
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Total URLs
-	err = db.QueryRow("SELECT COUNT(*) FROM urls").Scan(&stats.TotalURLs)
+	err = dbQueryRow(ctx, "SELECT COUNT(*) FROM urls").Scan(&stats.TotalURLs)
 	if err != nil {
 		return nil, err
 	}
 
 	// Total clicks
-	err = db.QueryRow("SELECT COALESCE(SUM(clicks), 0) FROM urls").Scan(&stats.TotalClicks)
+	err = dbQueryRow(ctx, "SELECT COALESCE(SUM(clicks), 0) FROM urls").Scan(&stats.TotalClicks)
 	if err != nil {
 		return nil, err
 	}
 
 	// Visitors today
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM visitors 
-		WHERE DATE(timestamp) = DATE('now')
-	`).Scan(&stats.VisitorsToday)
+	err = dbQueryRow(ctx, statsVisitorsTodayQuery).Scan(&stats.VisitorsToday)
 	if err != nil {
 		return nil, err
 	}
 
 	// Visitors this week
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM visitors 
-		WHERE timestamp >= datetime('now', '-7 days')
-	`).Scan(&stats.VisitorsThisWeek)
+	err = dbQueryRow(ctx, statsVisitorsWeekQuery).Scan(&stats.VisitorsThisWeek)
 	if err != nil {
 		return nil, err
 	}
 
 	// Top URLs by clicks
-	rows, err := db.Query(`
-		SELECT short_code, original_url, created_at, COALESCE(clicks, 0) as clicks
-		FROM urls 
-		ORDER BY clicks DESC, created_at DESC 
-		LIMIT 10
-	`)
+	rows, err := dbQuery(ctx, statsTopURLsQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -368,11 +573,7 @@ func getAdminStats() (*AdminStats, error) {
 	// Recent visitors - flexible query based on schema
 	var recentVisitorsQuery string
 	if hasHashedIP {
-		recentVisitorsQuery = `
-			SELECT id, hashed_ip, user_agent, path, timestamp
-			FROM visitors 
-			ORDER BY timestamp DESC 
-			LIMIT 50`
+		recentVisitorsQuery = statsRecentVisitorsQuery
 	} else {
 		recentVisitorsQuery = `
 			SELECT id, ip, user_agent, path, timestamp
@@ -381,7 +582,7 @@ func getAdminStats() (*AdminStats, error) {
 			LIMIT 50`
 	}
 
-	rows, err = db.Query(recentVisitorsQuery)
+	rows, err = dbQuery(ctx, recentVisitorsQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -404,12 +605,43 @@ func setupAdminRoutes(r *gin.Engine) {
 	// Privacy policy route
 	r.GET("/privacy", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "privacy.html", gin.H{
-			"title": "Privacy Policy",
+			"title":          "Privacy Policy",
+			"trackingStatus": trackingStatus(c),
+			"analyticsMode":  analyticsMode(),
+			"anonymizer":     visitorAnonymizerStrategy(), // from anonymize.go
+			"exportProvider": analyticsExportProvider(),   // from analyticsexport.go
+		})
+	})
+
+	// Analytics opt-out, remembered in a long-lived cookie
+	r.POST("/privacy/opt-out", func(c *gin.Context) {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(noTrackCookie, "1", noTrackCookieMaxAge, "/", "", gin.Mode() == gin.ReleaseMode, true)
+		c.HTML(http.StatusOK, "privacy-tracking", gin.H{
+			"trackingStatus": trackingOptedOut,
+			"analyticsMode":  analyticsMode(),
+		})
+	})
+
+	r.POST("/privacy/opt-in", func(c *gin.Context) {
+		c.SetCookie(noTrackCookie, "", -1, "/", "", gin.Mode() == gin.ReleaseMode, true)
+		status := trackingTracked
+		if c.GetHeader("DNT") == "1" {
+			status = trackingDoNotTrack
+		}
+		c.HTML(http.StatusOK, "privacy-tracking", gin.H{
+			"trackingStatus": status,
+			"analyticsMode":  analyticsMode(),
 		})
 	})
 
 	// Admin login page
 	r.GET("/admin/login", func(c *gin.Context) {
+		// A fresh deployment has no admin yet (from setup.go)
+		if setupNeeded() && siteFromContext(c.Request.Context()).Primary() {
+			c.Redirect(http.StatusFound, "/setup")
+			return
+		}
 		c.HTML(http.StatusOK, "admin-login.html", gin.H{
 			"title": "Admin Login",
 		})
@@ -417,34 +649,62 @@ func setupAdminRoutes(r *gin.Engine) {
 
 	// Admin login handler
 	r.POST("/admin/login", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		if loginLockedOut(ctx, ipHash) {
+			log.Printf("Locked out admin login attempt from %s", ipHash)
+			c.HTML(http.StatusTooManyRequests, "admin-login.html", gin.H{
+				"error": "Too many failed attempts, try again later",
+			})
+			return
+		}
+
+		if setupNeeded() && siteFromContext(ctx).Primary() { // from setup.go
+			c.Redirect(http.StatusSeeOther, "/setup")
+			return
+		}
+
 		username := c.PostForm("username")
 		password := c.PostForm("password")
 
-		// Get credentials from environment variables
-		adminUsername := os.Getenv("ADMIN_USERNAME")
-		adminPassword := os.Getenv("ADMIN_PASSWORD")
-
-		// Default credentials for development (remove in production)
-		if adminUsername == "" {
-			adminUsername = "admin"
-			if gin.Mode() == gin.DebugMode {
-				log.Println("WARNING: Using default admin username. Set ADMIN_USERNAME environment variable.")
+		// Tenant sites have their own admin account (from sites.go), and
+		// without credentials in the environment the primary site uses the
+		// one saved by setup (from setup.go)
+		site := siteFromContext(ctx)
+		var loggedIn bool
+		switch {
+		case !site.Primary():
+			loggedIn = site.checkAdminLogin(username, password)
+		case usesSetupCredentials():
+			loggedIn = checkSetupAdminLogin(username, password)
+		default:
+			// Get credentials from environment variables
+			adminUsername := os.Getenv("ADMIN_USERNAME")
+			adminPassword := os.Getenv("ADMIN_PASSWORD")
+
+			// Default credentials for development (remove in production)
+			if adminUsername == "" {
+				adminUsername = "admin"
+				if gin.Mode() == gin.DebugMode {
+					log.Println("WARNING: Using default admin username. Set ADMIN_USERNAME environment variable.")
+				}
 			}
-		}
-		if adminPassword == "" {
-			adminPassword = "admin123"
-			if gin.Mode() == gin.DebugMode {
-				log.Println("WARNING: Using default admin password. Set ADMIN_PASSWORD environment variable.")
+			if adminPassword == "" {
+				adminPassword = "admin123"
+				if gin.Mode() == gin.DebugMode {
+					log.Println("WARNING: Using default admin password. Set ADMIN_PASSWORD environment variable.")
+				}
 			}
+			loggedIn = username == adminUsername && password == adminPassword
 		}
 
-		if username == adminUsername && password == adminPassword {
-			// Set secure cookie (24 hours)
-			c.SetCookie("admin_token", adminToken, 3600*24, "/admin", "", false, true)
-			log.Printf("Admin login successful from %s", hashIP(c.ClientIP()))
+		if loggedIn {
+			startAdminSession(c, site, ipHash, "")
+			log.Printf("Admin login successful from %s", ipHash)
 			c.Redirect(http.StatusFound, "/admin/dashboard")
 		} else {
-			log.Printf("Failed admin login attempt from %s", hashIP(c.ClientIP()))
+			recordFailedLogin(ctx, ipHash)
+			log.Printf("Failed admin login attempt from %s", ipHash)
 			c.HTML(http.StatusUnauthorized, "admin-login.html", gin.H{
 				"error": "Invalid credentials",
 			})
@@ -453,8 +713,17 @@ func setupAdminRoutes(r *gin.Engine) {
 
 	// Admin logout
 	r.GET("/admin/logout", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		// End the session itself too, in case the cookie was copied
+		if token, err := c.Cookie("admin_token"); err == nil {
+			if id, ok := adminSessionFromCookie(siteFromContext(ctx), token); ok {
+				revokeAdminSession(siteFromContext(ctx), id)
+			}
+		}
 		c.SetCookie("admin_token", "", -1, "/admin", "", false, true)
-		log.Printf("Admin logout from %s", hashIP(c.ClientIP()))
+		ipHash := hashIP(c.ClientIP())
+		recordSecurityEvent(ctx, eventLogout, ipHash, "")
+		log.Printf("Admin logout from %s", ipHash)
 		c.Redirect(http.StatusFound, "/admin/login")
 	})
 
@@ -464,7 +733,8 @@ func setupAdminRoutes(r *gin.Engine) {
 
 	// Admin dashboard
 	adminGroup.GET("/dashboard", func(c *gin.Context) {
-		stats, err := getAdminStats()
+		ctx := c.Request.Context()
+		stats, err := getAdminStats(ctx)
 		if err != nil {
 			log.Printf("Error loading admin stats: %v", err)
 			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
@@ -473,14 +743,93 @@ func setupAdminRoutes(r *gin.Engine) {
 			return
 		}
 
+		// Aggregate-only modes have no visitor rows for new views, so the
+		// dashboard shows the counters instead (from analyticsmode.go)
+		mode := analyticsMode()
+		var aggregates *AggregateStats
+		if aggregateOnlyMode(mode) {
+			if aggregates, err = getAggregateStats(ctx); err != nil {
+				log.Printf("Error loading aggregate stats: %v", err)
+				c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+					"error": "Failed to load statistics",
+				})
+				return
+			}
+		}
+
+		// Week-on-week card; missing snapshots just hide it (from snapshots.go)
+		var weekly []SnapshotDelta
+		latest, previous, err := getLatestSnapshots(ctx)
+		if err != nil {
+			log.Printf("Error loading weekly snapshots: %v", err)
+		} else if latest != nil {
+			weekly = snapshotDeltas(latest, previous)
+		}
+
+		// Per-talk views and downloads (from talks.go)
+		talks, err := getTalks(ctx)
+		if err != nil {
+			log.Printf("Error loading talks: %v", err)
+		}
+
+		// Clicks on external links (from outbound.go)
+		outbound, err := getOutboundStats(ctx, 10)
+		if err != nil {
+			log.Printf("Error loading outbound clicks: %v", err)
+		}
+
+		// Custom events sent by page scripts (from events.go)
+		events, err := getEventCounts(ctx, 7)
+		if err != nil {
+			log.Printf("Error loading custom events: %v", err)
+		}
+
+		// Widgets for the admin's saved reports (from savedreports.go)
+		reports, err := getDashboardReports(ctx)
+		if err != nil {
+			log.Printf("Error loading saved reports: %v", err)
+		}
+
+		// Average time and scroll depth per page, while pings are on (from engagement.go)
+		var engagement []PageEngagement
+		if engagementEnabled() {
+			if engagement, err = getEngagementStats(ctx, 7); err != nil {
+				log.Printf("Error loading engagement: %v", err)
+			}
+		}
+
+		// Certificates and registrations about to expire; they belong to the
+		// deployment, so only the primary dashboard shows them (from domains.go)
+		var domainWarnings []MonitoredDomain
+		var release *ReleaseCheck
+		if siteFromContext(ctx).Primary() {
+			if domainWarnings, err = getDomainWarnings(ctx); err != nil {
+				log.Printf("Error loading domain warnings: %v", err)
+			}
+			release = latestReleaseCheck() // from version.go
+		}
+
 		c.HTML(http.StatusOK, "admin-dashboard.html", gin.H{
-			"stats": stats,
+			"stats":          stats,
+			"mode":           mode,
+			"aggregates":     aggregates,
+			"weekly":         weekly,
+			"weeklySnapshot": latest,
+			"weeklyCompared": previous != nil,
+			"talks":          talks,
+			"outbound":       outbound,
+			"events":         events,
+			"reports":        reports,
+			"engagement":     engagement,
+			"domainWarnings": domainWarnings,
+			"release":        release,
 		})
 	})
 
 	// Admin API endpoints for HTMX/AJAX
 	adminGroup.GET("/api/stats", func(c *gin.Context) {
-		stats, err := getAdminStats()
+		ctx := c.Request.Context()
+		stats, err := getAdminStats(ctx)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -488,13 +837,18 @@ func setupAdminRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, stats)
 	})
 
-	// View all URLs
+	// View all URLs, newest first, a page at a time
 	adminGroup.GET("/urls", func(c *gin.Context) {
-		rows, err := db.Query(`
-			SELECT short_code, original_url, created_at, COALESCE(clicks, 0) as clicks
-			FROM urls 
-			ORDER BY created_at DESC
-		`)
+		ctx := c.Request.Context()
+		// Keyset paging on the stored created_at text, tied by short code (from pagination.go)
+		afterCreated, afterCode, paged := cursorParam(c)
+		rows, err := dbQuery(ctx, `
+			SELECT short_code, original_url, created_at, COALESCE(clicks, 0) as clicks, CAST(created_at AS TEXT)
+			FROM urls
+			WHERE NOT ? OR (created_at, short_code) < (?, ?)
+			ORDER BY created_at DESC, short_code DESC
+			LIMIT ?
+		`, paged, afterCreated, afterCode, adminPageSize+1)
 		if err != nil {
 			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
 				"error": "Failed to load URLs",
@@ -504,28 +858,42 @@ func setupAdminRoutes(r *gin.Engine) {
 		defer rows.Close()
 
 		var urls []URLStat
+		var keys []string
 		for rows.Next() {
 			var url URLStat
-			err := rows.Scan(&url.ShortCode, &url.OriginalURL, &url.CreatedAt, &url.Clicks)
+			var key string
+			err := rows.Scan(&url.ShortCode, &url.OriginalURL, &url.CreatedAt, &url.Clicks, &key)
 			if err != nil {
 				continue
 			}
 			urls = append(urls, url)
+			keys = append(keys, key)
+		}
+
+		next := ""
+		if len(urls) > adminPageSize {
+			urls = urls[:adminPageSize]
+			next = encodeCursor(keys[adminPageSize-1], urls[adminPageSize-1].ShortCode)
 		}
 
 		c.HTML(http.StatusOK, "admin-urls.html", gin.H{
-			"urls": urls,
+			"urls":  urls,
+			"pager": cursorPager(c, next),
 		})
 	})
 
-	// View visitors
+	// View visitors, newest first, a page at a time
 	adminGroup.GET("/visitors", func(c *gin.Context) {
-		rows, err := db.Query(`
-			SELECT id, hashed_ip, user_agent, path, timestamp
-			FROM visitors 
-			ORDER BY timestamp DESC 
-			LIMIT 200
-		`)
+		ctx := c.Request.Context()
+		afterTimestamp, afterID, paged := cursorParam(c) // from pagination.go
+		afterVisitor, _ := strconv.Atoi(afterID)
+		rows, err := dbQuery(ctx, `
+			SELECT id, hashed_ip, user_agent, path, timestamp, CAST(timestamp AS TEXT)
+			FROM visitors
+			WHERE NOT ? OR (timestamp, id) < (?, ?)
+			ORDER BY timestamp DESC, id DESC
+			LIMIT ?
+		`, paged, afterTimestamp, afterVisitor, adminPageSize+1)
 		if err != nil {
 			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
 				"error": "Failed to load visitors",
@@ -535,62 +903,155 @@ func setupAdminRoutes(r *gin.Engine) {
 		defer rows.Close()
 
 		var visitors []VisitorMetric
+		var keys []string
 		for rows.Next() {
 			var visitor VisitorMetric
-			err := rows.Scan(&visitor.ID, &visitor.HashedIP, &visitor.UserAgent, &visitor.Path, &visitor.Timestamp)
+			var key string
+			err := rows.Scan(&visitor.ID, &visitor.HashedIP, &visitor.UserAgent, &visitor.Path, &visitor.Timestamp, &key)
 			if err != nil {
 				continue
 			}
 			visitors = append(visitors, visitor)
+			keys = append(keys, key)
+		}
+
+		next := ""
+		if len(visitors) > adminPageSize {
+			visitors = visitors[:adminPageSize]
+			next = encodeCursor(keys[adminPageSize-1], strconv.Itoa(visitors[adminPageSize-1].ID))
+		}
+
+		// Monthly history of archived visitor data (from archive.go)
+		archive, err := getVisitorArchive(ctx)
+		if err != nil {
+			log.Printf("Error loading visitor archive: %v", err)
+		}
+
+		// Strict-mode aggregate counts (from analyticsmode.go)
+		pageCounts, err := getPageCounts(ctx, 7)
+		if err != nil {
+			log.Printf("Error loading page counts: %v", err)
 		}
 
 		c.HTML(http.StatusOK, "admin-visitors.html", gin.H{
-			"visitors": visitors,
+			"visitors":        visitors,
+			"pager":           cursorPager(c, next),
+			"archive":         archive,
+			"retentionMonths": visitorRetentionMonths,
+			"aggregateOnly":   aggregateOnlyMode(analyticsMode()),
+			"pageCounts":      pageCounts,
 		})
 	})
 
-	// Delete URL (with confirmation)
+	// Move URL to the trash (with confirmation)
 	adminGroup.DELETE("/urls/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		shortCode := c.Param("code")
 
-		result, err := db.Exec("DELETE FROM urls WHERE short_code = ?", shortCode)
+		ipHash := hashIP(c.ClientIP())
+		err := moveToTrash(ctx, "url", shortCode, ipHash)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+			return
+		}
 		if err != nil {
 			log.Printf("Error deleting URL %s: %v", shortCode, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete URL"})
 			return
 		}
 
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
-			return
-		}
-
-		log.Printf("URL %s deleted by admin from %s", shortCode, hashIP(c.ClientIP()))
-		c.JSON(http.StatusOK, gin.H{"message": "URL deleted successfully"})
+		log.Printf("URL %s moved to trash by admin from %s", shortCode, ipHash)
+		c.JSON(http.StatusOK, gin.H{"message": "URL moved to trash"})
 	})
 
 	// Privacy compliance endpoint - allow users to request data deletion
 	adminGroup.POST("/privacy/delete-visitor-data", func(c *gin.Context) {
 		// This would require the user to provide their IP or some identifier
 		// For now, just clean up old data
-		go cleanupOldVisitorData()
+		safeGo("visitor-cleanup", archiveOldVisitorData) // from archive.go
 		c.JSON(http.StatusOK, gin.H{"message": "Privacy cleanup initiated"})
 	})
 
+	// API token management (from api.go)
+	setupAPITokenAdminRoutes(adminGroup)
+
+	// Resume PDF versions (from resumepdf.go)
+	setupResumeAdminRoutes(adminGroup)
+
+	// Blog editor and webmention moderation (from blog.go, webmention.go)
+	setupBlogAdminRoutes(adminGroup)
+	setupReactionAdminRoutes(adminGroup)
+	setupNowAdminRoutes(adminGroup)
+	setupBookmarkAdminRoutes(adminGroup)
+	setupReadingAdminRoutes(adminGroup)
+	setupTalkAdminRoutes(adminGroup)
+	setupChangelogAdminRoutes(adminGroup)
+	setupIconAdminRoutes(adminGroup)
+	setupStatusAdminRoutes(adminGroup)
+	setupKeepAliveAdminRoutes(adminGroup)
+	setupDomainAdminRoutes(adminGroup)
+	setupWebmentionAdminRoutes(adminGroup)
+	setupSyndicationAdminRoutes(adminGroup)
+	setupRedirectAdminRoutes(adminGroup)
+	setupTrackingExclusionAdminRoutes(adminGroup)
+	setupNotFoundAdminRoutes(adminGroup)
+	setupSEOAdminRoutes(adminGroup)
+	setupLinkAdminRoutes(adminGroup)
+	setupLinkScheduleAdminRoutes(adminGroup)
+	setupLinkVariantAdminRoutes(adminGroup)
+	setupDiagnosticsAdminRoutes(adminGroup)
+	setupSQLConsoleAdminRoutes(adminGroup)
+	setupPreviewAdminRoutes(adminGroup)
+	setupContentAdminRoutes(adminGroup)
+	setupSkillAdminRoutes(adminGroup)
+	setupTestimonialAdminRoutes(adminGroup)
+	setupRevisionAdminRoutes(adminGroup)
+	setupTrashAdminRoutes(adminGroup)
+	setupSecurityAdminRoutes(adminGroup)
+	setupEmailLogAdminRoutes(adminGroup)
+	setupMessageAdminRoutes(adminGroup)
+	setupContactRoutingAdminRoutes(adminGroup)
+	setupLeadAdminRoutes(adminGroup)
+	setupInvoiceAdminRoutes(adminGroup)
+	setupStripeAdminRoutes(adminGroup)
+	setupClientPortalAdminRoutes(adminGroup)
+	setupFileShareAdminRoutes(adminGroup)
+	setupImageProxyAdminRoutes(adminGroup)
+	setupSettingsAdminRoutes(adminGroup)
+	setupEngagementAdminRoutes(adminGroup)
+	setupViewCountAdminRoutes(adminGroup)
+	setupKeyRotationAdminRoutes(adminGroup)
+	setupAnonymizerAdminRoutes(adminGroup)
+	setupReanonymizeAdminRoutes(adminGroup)
+	setupCampaignAdminRoutes(adminGroup)
+	setupReportAdminRoutes(adminGroup)
+	setupSavedReportAdminRoutes(adminGroup)
+	setupShareLinkAdminRoutes(adminGroup)
+	setupExportLinkAdminRoutes(adminGroup)
+	setupSiteAdminRoutes(adminGroup)
+	setupThemeAdminRoutes(adminGroup)
+	setupSnippetAdminRoutes(adminGroup)
+	setupPollAdminRoutes(adminGroup)
+	setupBannerAdminRoutes(adminGroup)
+	setupAvailabilityAdminRoutes(adminGroup)
+
 	// Admin statistics export (for backups or analysis)
-	adminGroup.GET("/export/stats", func(c *gin.Context) {
-		stats, err := getAdminStats()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	adminGroup.GET("/export/stats", serveStatsExport)
+}
 
-		// Set headers for file download
-		c.Header("Content-Type", "application/json")
-		c.Header("Content-Disposition", "attachment; filename=admin-stats.json")
+// Download the admin statistics as JSON; also served by export links
+func serveStatsExport(c *gin.Context) {
+	ctx := c.Request.Context()
+	stats, err := getAdminStats(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		log.Printf("Admin stats exported by %s", hashIP(c.ClientIP()))
-		c.JSON(http.StatusOK, stats)
-	})
+	// Set headers for file download
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", "attachment; filename=admin-stats.json")
+
+	log.Printf("Admin stats exported by %s", hashIP(c.ClientIP()))
+	c.JSON(http.StatusOK, stats)
 }
@@ -0,0 +1,66 @@
+// admin_test.go - Visitor table migration
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// Point db at an in-memory database holding a visitors table in the old
+// schema, with or without its ip column
+func withLegacyVisitors(t *testing.T, withIP bool, ips ...string) {
+	t.Helper()
+	testDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB.SetMaxOpenConns(1) // each connection would get its own database
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+		testDB.Close()
+	})
+
+	schema := `CREATE TABLE visitors (user_agent TEXT, path TEXT, timestamp DATETIME, country TEXT)`
+	if withIP {
+		schema = `CREATE TABLE visitors (ip TEXT, user_agent TEXT, path TEXT, timestamp DATETIME, country TEXT)`
+	}
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range ips {
+		query, args := `INSERT INTO visitors (path, timestamp) VALUES ('/', CURRENT_TIMESTAMP)`, []any{}
+		if withIP {
+			query, args = `INSERT INTO visitors (ip, path, timestamp) VALUES (?, '/', CURRENT_TIMESTAMP)`, []any{ip}
+		}
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateVisitorTableUniqueCounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		withIP     bool
+		ips        []string
+		wantUnique int
+	}{
+		{"no ip column", false, []string{"", "", ""}, 3},
+		{"ips kept", true, []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"}, 2},
+		{"missing ips", true, []string{"192.0.2.1", "", ""}, 3},
+	}
+	for _, tt := range tests {
+		withLegacyVisitors(t, tt.withIP, tt.ips...)
+		migrateVisitorTable()
+
+		var rows, unique int
+		if err := db.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT hashed_ip) FROM visitors`).Scan(&rows, &unique); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if rows != len(tt.ips) || unique != tt.wantUnique {
+			t.Errorf("%s: %d rows, %d unique visitors; want %d, %d", tt.name, rows, unique, len(tt.ips), tt.wantUnique)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// analyticsexport.go - Page view forwarding to Plausible or Umami
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Page views are still stored locally; the provider gets a copy, so its
+// numbers can be compared with the dashboard's. Only the primary site is
+// exported, as ANALYTICS_EXPORT_SITE names one site at the provider.
+
+// Providers, chosen by ANALYTICS_EXPORT_PROVIDER, with their hosted URLs
+// used when ANALYTICS_EXPORT_URL isn't set
+var analyticsExportProviders = map[string]string{
+	"plausible": "https://plausible.io",
+	"umami":     "https://cloud.umami.is",
+}
+
+// Views are queued and sent every analyticsExportEvery, at most
+// analyticsExportBatch at a time; ones the provider fails to take go first in
+// the next batch, up to analyticsExportAttempts times
+const (
+	analyticsExportEvery     = 10 * time.Second
+	analyticsExportBatch     = 100
+	analyticsExportQueueSize = 2048
+	analyticsExportAttempts  = 3
+	analyticsExportTimeout   = 30 * time.Second
+	// A batch stops after this many failures in a row, so a provider that
+	// is down isn't sent every view
+	analyticsExportMaxFailures = 3
+	// The last batch at shutdown gets this long, within shutdownTimeout (from safego.go)
+	analyticsExportShutdownGrace = 5 * time.Second
+)
+
+// Page view as forwarded: the stored identifier, never the IP
+type exportedPageView struct {
+	VisitorID string
+	UserAgent string
+	Path      string
+	attempts  int
+}
+
+// Where page views are forwarded
+type analyticsExporter struct {
+	provider string
+	endpoint string // the provider's event API
+	site     string // domain (Plausible) or website ID (Umami)
+	siteURL  string // primary site's base URL, for the page URLs
+	host     string
+	client   *http.Client
+}
+
+var (
+	pageViewExporter *analyticsExporter // nil when exporting is off
+	exportQueue      = make(chan exportedPageView, analyticsExportQueueSize)
+	exportDropped    atomic.Int64
+)
+
+// Errors the provider won't accept on a retry either
+var errExportRejected = errors.New("rejected by the analytics provider")
+
+// Set up the exporter from the environment and start sending
+func initAnalyticsExport() {
+	provider := strings.ToLower(os.Getenv("ANALYTICS_EXPORT_PROVIDER"))
+	if provider == "" {
+		return
+	}
+	exporter, err := newAnalyticsExporter(provider, os.Getenv("ANALYTICS_EXPORT_URL"), os.Getenv("ANALYTICS_EXPORT_SITE"))
+	if err != nil {
+		log.Printf("Analytics export: %v; page views are only stored locally", err)
+		return
+	}
+	pageViewExporter = exporter
+	log.Printf("Analytics export: forwarding page views to %s (%s)", exporter.provider, exporter.endpoint)
+	startWorker("analytics-export", analyticsExportWorker) // from safego.go
+}
+
+func newAnalyticsExporter(provider, baseURL, site string) (*analyticsExporter, error) {
+	hosted, ok := analyticsExportProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, use plausible or umami", provider)
+	}
+	if site == "" {
+		return nil, errors.New("ANALYTICS_EXPORT_SITE isn't set")
+	}
+	if baseURL == "" {
+		baseURL = hosted
+	}
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+		return nil, fmt.Errorf("ANALYTICS_EXPORT_URL %q isn't an http(s) URL", baseURL)
+	}
+	endpoint := base.String() + "/api/event"
+	if provider == "umami" {
+		endpoint = base.String() + "/api/send"
+	}
+
+	siteURL := siteBaseURL() // from seo.go
+	host := siteURL
+	if parsed, err := url.Parse(siteURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return &analyticsExporter{
+		provider: provider,
+		endpoint: endpoint,
+		site:     site,
+		siteURL:  siteURL,
+		host:     host,
+		client:   newTrustedHTTPClient("Analytics Export", analyticsExportTimeout), // from httpclient.go; may be self-hosted nearby
+	}, nil
+}
+
+// Name of the provider page views go to, or "" when they stay here; for
+// the privacy page
+func analyticsExportProvider() string {
+	if pageViewExporter == nil {
+		return ""
+	}
+	return pageViewExporter.provider
+}
+
+// Queue a stored page view for the provider without blocking tracking
+func exportPageView(ctx context.Context, visitorID, userAgent, path string) {
+	if pageViewExporter == nil || !siteFromContext(ctx).Primary() { // from sites.go
+		return
+	}
+	select {
+	case exportQueue <- exportedPageView{VisitorID: visitorID, UserAgent: userAgent, Path: path}:
+	default:
+		if exportDropped.Add(1) == 1 {
+			log.Printf("Analytics export queue full, dropping page views")
+		}
+	}
+}
+
+func analyticsExportWorker() {
+	ticker := time.NewTicker(analyticsExportEvery)
+	defer ticker.Stop()
+
+	var retry []exportedPageView
+	for {
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			// One last batch, so a deploy doesn't lose what was queued
+			pageViewExporter.sendBatch(retry, time.Now().Add(analyticsExportShutdownGrace))
+			return
+		}
+		var err error
+		retry, err = pageViewExporter.sendBatch(retry, time.Time{})
+		recordJobRun("analytics-export", analyticsExportEvery, err) // from diagnostics.go
+	}
+}
+
+// Send the views waiting for a retry, then queued ones, up to a batch,
+// stopping at deadline unless it's zero. Returns the views to try again and
+// the last error.
+func (e *analyticsExporter) sendBatch(retry []exportedPageView, deadline time.Time) ([]exportedPageView, error) {
+	batch := retry
+fill:
+	for len(batch) < analyticsExportBatch {
+		select {
+		case view := <-exportQueue:
+			batch = append(batch, view)
+		default:
+			break fill
+		}
+	}
+	if dropped := exportDropped.Swap(0); dropped > 0 {
+		log.Printf("Analytics export dropped %d page views while the queue was full", dropped)
+	}
+
+	var (
+		again    []exportedPageView
+		lastErr  error
+		failures int
+		sent     int
+	)
+	for i, view := range batch {
+		if failures >= analyticsExportMaxFailures || (!deadline.IsZero() && time.Now().After(deadline)) {
+			// Keep the rest for later without counting an attempt
+			again = append(again, batch[i:]...)
+			break
+		}
+		err := e.send(view)
+		if err == nil {
+			failures = 0
+			sent++
+			continue
+		}
+		lastErr = err
+		failures++
+		view.attempts++
+		if !errors.Is(err, errExportRejected) && view.attempts < analyticsExportAttempts {
+			again = append(again, view)
+		}
+	}
+	if lastErr != nil {
+		log.Printf("Analytics export: sent %d of %d page views, %d kept to retry: %v", sent, len(batch), len(again), lastErr)
+	}
+	return again, lastErr
+}
+
+// Post one page view in the provider's format
+func (e *analyticsExporter) send(view exportedPageView) error {
+	var payload any
+	switch e.provider {
+	case "plausible":
+		payload = map[string]string{
+			"name":   "pageview",
+			"domain": e.site,
+			"url":    e.siteURL + view.Path,
+		}
+	case "umami":
+		payload = map[string]any{
+			"type": "event",
+			"payload": map[string]string{
+				"website":  e.site,
+				"hostname": e.host,
+				"url":      view.Path,
+			},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Providers tell visitors apart by user agent and address
+	if view.UserAgent != "" {
+		req.Header.Set("User-Agent", view.UserAgent)
+	}
+	if ip := exportVisitorIP(view.VisitorID); ip != "" {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", errExportRejected, resp.Status)
+	default:
+		return fmt.Errorf("provider answered %s", resp.Status)
+	}
+}
+
+// Stand-in address for a stored visitor identifier: a unique local IPv6
+// address (fd00::/8) made from it, so the provider counts the same
+// visitors without learning where they are. Empty when nothing derived
+// from the IP is stored.
+func exportVisitorIP(visitorID string) string {
+	id, err := hex.DecodeString(visitorID)
+	if err != nil || len(id) == 0 {
+		return ""
+	}
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	copy(ip[1:], id)
+	return ip.String()
+}
@@ -0,0 +1,335 @@
+// analyticsmode.go - Analytics mode switch
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Analytics modes, stored in the analytics_mode setting
+const (
+	analyticsStandard = "standard" // a visitors row per page view
+	analyticsCountry  = "country"  // no per-request rows; daily counts per country and page
+	analyticsStrict   = "strict"   // no per-request rows; hourly page counts only
+)
+
+const analyticsModeSetting = "analytics_mode"
+
+// Current analytics mode
+func analyticsMode() string {
+	switch mode := getSetting(analyticsModeSetting, analyticsStandard); mode { // from settings.go
+	case analyticsCountry, analyticsStrict:
+		return mode
+	}
+	return analyticsStandard
+}
+
+// Whether the mode keeps aggregate counters instead of visitor rows
+func aggregateOnlyMode(mode string) bool {
+	return mode == analyticsCountry || mode == analyticsStrict
+}
+
+// What an aggregate-mode view is counted under
+type pageCounterKey struct {
+	SiteID  int // from sites.go
+	Mode    string
+	Period  string // strict: YYYY-MM-DD HH:00, country: YYYY-MM-DD (UTC)
+	Country string // country mode only; "" when unknown
+	Path    string
+}
+
+// Aggregate views for one path
+type PageCount struct {
+	Path  string
+	Views int64
+}
+
+// Aggregate views from one country
+type CountryCount struct {
+	Country string
+	Views   int64
+}
+
+// Counts not yet flushed to the database
+var (
+	pageCountersMu sync.Mutex
+	pageCounters   = map[pageCounterKey]int64{}
+)
+
+// Initialize aggregate page counters
+func initPageCounters() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS page_view_counters (
+		hour TEXT NOT NULL,
+		path TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, path)
+	)`, `
+	CREATE TABLE IF NOT EXISTS country_page_counts (
+		day TEXT NOT NULL,
+		country TEXT NOT NULL,
+		path TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, country, path)
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create page counter tables:", err)
+		}
+	}
+
+	startWorker("page-counter-flush", pageCounterWorker) // from safego.go
+}
+
+// Count a page view in memory, keeping nothing about the visitor beyond
+// the country in country mode
+func countPageView(ctx context.Context, mode, country, path string) {
+	key := pageCounterKey{
+		SiteID: siteFromContext(ctx).ID,
+		Mode:   mode,
+		Period: time.Now().UTC().Format("2006-01-02 15:00"),
+		Path:   path,
+	}
+	if mode == analyticsCountry {
+		key.Period = time.Now().UTC().Format("2006-01-02")
+		key.Country = country
+	}
+	pageCountersMu.Lock()
+	pageCounters[key]++
+	pageCountersMu.Unlock()
+}
+
+// Flush counters hourly, and once more at shutdown
+func pageCounterWorker() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			flushPageCounters()
+			return
+		}
+		flushPageCounters()
+	}
+}
+
+// Add the in-memory counts to the database; counts are put back if it fails
+func flushPageCounters() {
+	pageCountersMu.Lock()
+	counts := pageCounters
+	pageCounters = map[pageCounterKey]int64{}
+	pageCountersMu.Unlock()
+
+	// Each site's counts go to its own database; counts for a site removed
+	// since are dropped
+	bySite := map[int]map[pageCounterKey]int64{}
+	for key, views := range counts {
+		if bySite[key.SiteID] == nil {
+			bySite[key.SiteID] = map[pageCounterKey]int64{}
+		}
+		bySite[key.SiteID][key] = views
+	}
+
+	var err error
+	for siteID, siteCounts := range bySite {
+		site := siteByID(siteID)
+		if site == nil {
+			continue
+		}
+		if siteErr := writePageCounters(withSite(context.Background(), site), siteCounts); siteErr != nil {
+			log.Printf("Error flushing page counters for %s, will retry: %v", site.Name, siteErr)
+			err = siteErr
+			pageCountersMu.Lock()
+			for key, views := range siteCounts {
+				pageCounters[key] += views
+			}
+			pageCountersMu.Unlock()
+		}
+	}
+	recordJobRun("page-counter-flush", time.Hour, err) // from diagnostics.go
+}
+
+func writePageCounters(ctx context.Context, counts map[pageCounterKey]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	tx, cancel, err := dbBegin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	for key, views := range counts {
+		if key.Mode == analyticsCountry {
+			_, err = tx.Exec(`
+				INSERT INTO country_page_counts (day, country, path, views) VALUES (?, ?, ?, ?)
+				ON CONFLICT(day, country, path) DO UPDATE SET views = views + excluded.views
+			`, key.Period, key.Country, key.Path, views)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO page_view_counters (hour, path, views) VALUES (?, ?, ?)
+				ON CONFLICT(hour, path) DO UPDATE SET views = views + excluded.views
+			`, key.Period, key.Path, views)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Flushed aggregate views per path over the last days, from both modes
+func getPageCounts(ctx context.Context, days int) ([]PageCount, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	rows, err := dbQuery(ctx, `
+		SELECT path, SUM(views) FROM (
+			SELECT path, views FROM page_view_counters WHERE hour >= ?
+			UNION ALL
+			SELECT path, views FROM country_page_counts WHERE day >= ?
+		) GROUP BY path ORDER BY SUM(views) DESC LIMIT 50`,
+		since.Format("2006-01-02 15:00"), since.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []PageCount
+	for rows.Next() {
+		var p PageCount
+		if rows.Scan(&p.Path, &p.Views) == nil {
+			counts = append(counts, p)
+		}
+	}
+	return counts, rows.Err()
+}
+
+// Flushed country-mode views per country over the last days
+func getCountryCounts(ctx context.Context, days int) ([]CountryCount, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := dbQuery(ctx, `
+		SELECT country, SUM(views) FROM country_page_counts
+		WHERE day >= ? GROUP BY country ORDER BY SUM(views) DESC LIMIT 20`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []CountryCount
+	for rows.Next() {
+		var cc CountryCount
+		if rows.Scan(&cc.Country, &cc.Views) == nil {
+			counts = append(counts, cc)
+		}
+	}
+	return counts, rows.Err()
+}
+
+// Dashboard figures when only aggregates are kept
+type AggregateStats struct {
+	ViewsToday    int64
+	ViewsThisWeek int64
+	TopPaths      []PageCount
+	TopCountries  []CountryCount
+}
+
+// Shared between concurrent dashboards like getAdminStats (from statscache.go)
+func getAggregateStats(ctx context.Context) (*AggregateStats, error) {
+	return cachedDashboardQuery(ctx, "aggregate-stats", loadAggregateStats)
+}
+
+func loadAggregateStats(ctx context.Context) (*AggregateStats, error) {
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	weekAgo := now.AddDate(0, 0, -7)
+
+	stats := &AggregateStats{}
+	err := dbQueryRow(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(views), 0) FROM page_view_counters WHERE hour >= ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM country_page_counts WHERE day >= ?)`,
+		today+" 00:00", today).Scan(&stats.ViewsToday)
+	if err != nil {
+		return nil, err
+	}
+	err = dbQueryRow(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(views), 0) FROM page_view_counters WHERE hour >= ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM country_page_counts WHERE day >= ?)`,
+		weekAgo.Format("2006-01-02 15:00"), weekAgo.Format("2006-01-02")).Scan(&stats.ViewsThisWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.TopPaths, err = getPageCounts(ctx, 7); err != nil {
+		return nil, err
+	}
+	if len(stats.TopPaths) > 10 {
+		stats.TopPaths = stats.TopPaths[:10]
+	}
+	if stats.TopCountries, err = getCountryCounts(ctx, 7); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Setup admin settings routes
+func setupSettingsAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/settings", func(c *gin.Context) {
+		previewTheme, _ := previewThemeName(c)                 // from themes.go
+		jobs, err := getAnonymizationJobs(c.Request.Context()) // from reanonymize.go
+		if err != nil {
+			log.Printf("Error loading anonymization jobs: %v", err)
+		}
+		c.HTML(http.StatusOK, "admin-settings.html", gin.H{
+			"analyticsMode": analyticsMode(),
+			"engagement":    engagementEnabled(),                   // from engagement.go
+			"viewCounts":    viewCountsEnabled(),                   // from viewcounts.go
+			"imageHosts":    strings.Join(imageProxyHosts(), "\n"), // from imageproxy.go
+			"canEdit":       siteFromContext(c.Request.Context()).Primary(),
+			"themes":        listThemes(),
+			"activeTheme":   activeThemeName(c.Request.Context()),
+			"previewTheme":  previewTheme,
+			"rotation":      keyRotationStatus(),              // from rotation.go
+			"anonymizer":    visitorAnonymizerStrategy().Name, // from anonymize.go
+			"anonymizers":   anonymizerStrategies,
+			"jobs":          jobs,
+			"jobActions":    reanonymizeActions,
+			"message":       c.Query("message"),
+		})
+	})
+
+	// The mode applies to every site, so only the primary admin may change it
+	adminGroup.POST("/settings/analytics-mode", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		mode := c.PostForm("mode")
+		if mode != analyticsStandard && !aggregateOnlyMode(mode) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown analytics mode",
+			})
+			return
+		}
+
+		if err := setSetting(ctx, analyticsModeSetting, mode); err != nil {
+			log.Printf("Error saving analytics mode: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save analytics mode",
+			})
+			return
+		}
+
+		log.Printf("Analytics mode set to %s by admin from %s", mode, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Analytics mode set to "+mode))
+	})
+}
@@ -0,0 +1,158 @@
+// anonymize.go - Visitor IP anonymization strategies
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Turns an IP into what is stored in visitors.hashed_ip. Security features
+// (lockouts, rate limits, the audit log) always use hashIP (from admin.go),
+// so they keep working whichever strategy visitors get.
+type Anonymizer interface {
+	Anonymize(ip string) string
+}
+
+// Strategies, stored in the visitor_anonymizer setting
+const (
+	anonymizerSalted = "salted" // SHA-256 with a salt that never changes: a visitor is recognised across days
+	anonymizerDaily  = "daily"  // HMAC keyed by the salt that rotates at midnight UTC (from rotation.go)
+	anonymizerSubnet = "subnet" // the /24 (IPv6 /48) network, keyed like daily: visitors share a bucket
+	anonymizerOff    = "off"    // nothing derived from the IP is stored; unique counts stop meaning anything
+)
+
+const (
+	anonymizerSetting       = "visitor_anonymizer"
+	visitorHashSaltSetting  = "visitor_hash_salt" // for the salted strategy
+	defaultAnonymizerChoice = anonymizerDaily
+)
+
+// A strategy as offered in the admin and described on the privacy page
+type AnonymizerStrategy struct {
+	Name        string
+	Label       string
+	Description string
+	anonymizer  Anonymizer
+}
+
+var anonymizerStrategies = []AnonymizerStrategy{
+	{anonymizerSalted, "Salted SHA-256",
+		"IP addresses are hashed with SHA-256 and a fixed secret salt, so repeat visits can be counted. The original IP is never stored.",
+		saltedAnonymizer{}},
+	{anonymizerDaily, "Daily-rotating HMAC",
+		"IP addresses are hashed with a secret key that is replaced every day, so a visitor can't be recognised from one day to the next. The original IP is never stored.",
+		dailyAnonymizer{}},
+	{anonymizerSubnet, "Subnet buckets",
+		"Only the network part of the IP address (the first three parts of an IPv4 address) is kept, hashed with a key replaced every day, so visits can't be told apart from others on the same network.",
+		subnetAnonymizer{}},
+	{anonymizerOff, "Disabled",
+		"Nothing derived from the IP address is stored with page views.",
+		offAnonymizer{}},
+}
+
+// Make sure the salted strategy has its salt, so switching to it never
+// starts from an empty one
+func initAnonymizer() {
+	if getSetting(visitorHashSaltSetting, "") == "" { // from settings.go
+		if err := setSetting(context.Background(), visitorHashSaltSetting, generateAdminToken()); err != nil {
+			log.Fatal("Failed to store visitor hash salt:", err)
+		}
+	}
+}
+
+// Strategy in use, the default when the setting is unknown
+func visitorAnonymizerStrategy() AnonymizerStrategy {
+	name := getSetting(anonymizerSetting, defaultAnonymizerChoice)
+	if s, ok := findAnonymizerStrategy(name); ok {
+		return s
+	}
+	s, _ := findAnonymizerStrategy(defaultAnonymizerChoice)
+	return s
+}
+
+func findAnonymizerStrategy(name string) (AnonymizerStrategy, bool) {
+	for _, s := range anonymizerStrategies {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return AnonymizerStrategy{}, false
+}
+
+// Identifier to store for a visitor's IP
+func anonymizeVisitorIP(ip string) string {
+	return visitorAnonymizerStrategy().anonymizer.Anonymize(ip)
+}
+
+type saltedAnonymizer struct{}
+
+func (saltedAnonymizer) Anonymize(ip string) string {
+	sum := sha256.Sum256([]byte(ip + getSetting(visitorHashSaltSetting, "")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+type dailyAnonymizer struct{}
+
+func (dailyAnonymizer) Anonymize(ip string) string {
+	mac := hmac.New(sha256.New, []byte(currentIPSalt())) // from rotation.go
+	mac.Write([]byte("visitor:" + ip))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+type subnetAnonymizer struct{}
+
+func (subnetAnonymizer) Anonymize(ip string) string {
+	return dailyAnonymizer{}.Anonymize(ipSubnet(ip))
+}
+
+// Network an address belongs to: /24 for IPv4, /48 for IPv6
+func ipSubnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+type offAnonymizer struct{}
+
+func (offAnonymizer) Anonymize(string) string {
+	return ""
+}
+
+// Setup the admin switch for the visitor anonymization strategy
+func setupAnonymizerAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Like the analytics mode, the strategy applies to every site
+	adminGroup.POST("/settings/anonymizer", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		strategy, ok := findAnonymizerStrategy(c.PostForm("strategy"))
+		if !ok {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown anonymization strategy",
+			})
+			return
+		}
+
+		if err := setSetting(ctx, anonymizerSetting, strategy.Name); err != nil {
+			log.Printf("Error saving anonymization strategy: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save anonymization strategy",
+			})
+			return
+		}
+
+		log.Printf("Visitor anonymization set to %s by admin from %s", strategy.Name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Visitor anonymization set to "+strategy.Label))
+	})
+}
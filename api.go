@@ -0,0 +1,524 @@
+// api.go - Token-authenticated API
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API token as shown in the admin (the raw token is never stored)
+type APIToken struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	RateLimit  int          `json:"rate_limit"` // Requests per minute
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt sql.NullTime `json:"-"`
+	ExpiresAt  sql.NullTime `json:"-"` // unset for tokens that never expire
+}
+
+const defaultTokenRateLimit = 30
+
+// Fixed-window rate limiter, per API token or per client
+type rateLimiter[K comparable] struct {
+	mu      sync.Mutex
+	windows map[K]*rateWindow
+}
+
+// Token ids are per site database, so windows are keyed by both
+type tokenRateKey struct {
+	siteID  int
+	tokenID int
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// Windows kept before expired ones are swept
+const rateLimiterSweepSize = 10000
+
+func newRateLimiter[K comparable]() *rateLimiter[K] {
+	return &rateLimiter[K]{windows: make(map[K]*rateWindow)}
+}
+
+var apiRateLimiter = newRateLimiter[tokenRateKey]()
+
+// Allow reports whether the key may make another request this minute
+func (l *rateLimiter[K]) Allow(key K, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.windows) >= rateLimiterSweepSize {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= time.Minute {
+				delete(l.windows, k)
+			}
+		}
+	}
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		l.windows[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Initialize API token storage
+func initAPITokens() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		rate_limit INTEGER DEFAULT 30,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create api_tokens table:", err)
+	}
+	db.Exec(`ALTER TABLE api_tokens ADD COLUMN expires_at DATETIME`) // Ignore error if column already exists
+}
+
+// Tokens are stored as unsalted hashes so they survive restarts
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Look up an unexpired token, returning its id and rate limit
+func lookupAPIToken(ctx context.Context, token string) (int, int, bool) {
+	if token == "" {
+		return 0, 0, false
+	}
+
+	var id, rateLimit int
+	err := dbQueryRow(ctx, "SELECT id, COALESCE(rate_limit, ?) FROM api_tokens WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)",
+		defaultTokenRateLimit, hashAPIToken(token), time.Now()).Scan(&id, &rateLimit)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up API token: %v", err)
+		}
+		return 0, 0, false
+	}
+
+	usedAt := time.Now()
+	safeGoRetry("token-usage", 3, func() error {
+		_, err := dbExec(context.WithoutCancel(ctx), "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt, id)
+		if err != nil {
+			log.Printf("Error updating token usage: %v", err)
+		}
+		return err
+	})
+
+	return id, rateLimit, true
+}
+
+// Read the API token from the Authorization header. It's never taken from
+// the URL or form, where it would end up in logs, Referers and visitors.path
+func requestAPIToken(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// Answer with an error in the form the client sent: a JSON body to JSON
+// requests, plain text to the bookmarklets' query strings and forms
+func abortAPIError(c *gin.Context, status int, message string) {
+	if c.ContentType() == "application/json" || strings.Contains(c.GetHeader("Accept"), "application/json") {
+		c.AbortWithStatusJSON(status, gin.H{"error": message})
+		return
+	}
+	c.String(status, message)
+	c.Abort()
+}
+
+// Middleware requiring a valid API token within its rate limit
+func apiTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		token := requestAPIToken(c)
+		tokenID, rateLimit, ok := lookupAPIToken(ctx, token)
+		if !ok {
+			if token != "" {
+				ipHash := hashIP(c.ClientIP())
+				recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, c.Request.URL.Path, ipHash, time.Minute)
+			}
+			abortAPIError(c, http.StatusUnauthorized, "invalid or missing API token")
+			return
+		}
+
+		if !apiRateLimiter.Allow(tokenRateKey{siteFromContext(ctx).ID, tokenID}, rateLimit) {
+			recordSecurityEventThrottled(ctx, eventTokenRateLimited, hashIP(c.ClientIP()),
+				"token "+strconv.Itoa(tokenID), strconv.Itoa(tokenID), time.Minute)
+			c.Header("Retry-After", "60")
+			abortAPIError(c, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		c.Set("api_token_id", tokenID)
+		c.Next()
+	}
+}
+
+// Quick-create a short URL and reply in plaintext
+func quickShortenHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	originalURL := strings.TrimSpace(c.Query("url"))
+	if originalURL == "" {
+		originalURL = strings.TrimSpace(c.PostForm("url"))
+	}
+
+	if !isPublicLongURL(originalURL) {
+		c.String(http.StatusBadRequest, "a valid public http:// or https:// url is required")
+		return
+	}
+
+	shortCode, err := generateShortCode()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "could not generate short code")
+		return
+	}
+
+	if err := saveURL(ctx, shortCode, originalURL); err != nil {
+		log.Printf("Error saving URL from API: %v", err)
+		c.String(http.StatusInternalServerError, "could not save short url")
+		return
+	}
+
+	shortURL := buildShortURL(c, shortCode)
+
+	// Bookmarklets can ask to be sent straight back to the page they came from
+	if c.Query("redirect") == "1" {
+		c.Header("X-Short-URL", shortURL)
+		c.Redirect(http.StatusSeeOther, originalURL)
+		return
+	}
+
+	c.String(http.StatusOK, shortURL)
+}
+
+// Create a short URL from a JSON request and reply with the link. With an
+// Idempotency-Key header a retry of the same request gets the link the
+// first one created (from idempotency.go) instead of a second one.
+func createLinkHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !isPublicLongURL(strings.TrimSpace(req.URL)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid public http:// or https:// url is required"})
+		return
+	}
+	originalURL := strings.TrimSpace(req.URL)
+	key := c.GetHeader("Idempotency-Key")
+	if len(key) > idempotencyKeyMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+
+	shortCode, err := generateShortCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate short code"})
+		return
+	}
+
+	replayed := false
+	if key == "" {
+		err = saveURL(ctx, shortCode, originalURL)
+	} else {
+		shortCode, replayed, err = saveURLIdempotent(ctx, c.GetInt("api_token_id"), key, shortCode, originalURL)
+	}
+	if err == errIdempotencyMismatch {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving URL from API: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save short url"})
+		return
+	}
+
+	link, err := getLinkForAPI(ctx, shortCode)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusGone, gin.H{"error": "the link created with this Idempotency-Key has been deleted"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading URL for API: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load short url"})
+		return
+	}
+
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"short_code":   link.ShortCode,
+		"short_url":    buildShortURL(c, link.ShortCode),
+		"original_url": link.OriginalURL,
+		"created_at":   link.CreatedAt,
+	})
+}
+
+// Custom short codes: the characters generated codes use (from main.go)
+var shortAliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+var errShortCodeTaken = errors.New("short code is already taken")
+
+// Save a short link with its expiry and read it back, in one transaction
+// so a link is never briefly live without the expiry it was created with
+func insertShortLink(ctx context.Context, shortCode, originalURL string, expiresAt sql.NullTime) (URLStat, error) {
+	link := URLStat{ShortCode: shortCode}
+	tx, cancel, err := dbBegin(ctx) // from dbctx.go
+	if err != nil {
+		return link, err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO urls (short_code, original_url, expires_at) VALUES (?, ?, ?) ON CONFLICT(short_code) DO NOTHING",
+		shortCode, originalURL, expiresAt)
+	if err != nil {
+		return link, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return link, errShortCodeTaken
+	}
+	err = tx.QueryRow("SELECT original_url, created_at FROM urls WHERE short_code = ?", shortCode).Scan(&link.OriginalURL, &link.CreatedAt)
+	if err != nil {
+		return link, err
+	}
+	return link, tx.Commit()
+}
+
+// Create a short URL from JSON with an optional alias and lifetime, for
+// scripts and terminal tools. Errors are JSON too:
+//
+//	{"original_url": "https://example.com", "alias": "demo", "expires_in_days": 7}
+func shortenHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req struct {
+		OriginalURL   string `json:"original_url"`
+		Alias         string `json:"alias"`
+		ExpiresInDays int    `json:"expires_in_days"` // one of linkExpiryDays (from linkexpiry.go); 0 never expires
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the body must be a JSON object"})
+		return
+	}
+	originalURL := strings.TrimSpace(req.OriginalURL)
+	if !isPublicLongURL(originalURL) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "original_url must be a valid public http:// or https:// url", "field": "original_url"})
+		return
+	}
+	alias := strings.TrimSpace(req.Alias)
+	if alias != "" && !shortAliasPattern.MatchString(alias) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "alias must be 3 to 32 letters, digits, - or _", "field": "alias"})
+		return
+	}
+	expiresAt, ok := linkExpiryFromForm(strconv.Itoa(req.ExpiresInDays))
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "expires_in_days must be one of " + strings.Trim(fmt.Sprint(linkExpiryDays), "[]"), "field": "expires_in_days"})
+		return
+	}
+
+	shortCode := alias
+	if shortCode == "" {
+		var err error
+		if shortCode, err = generateShortCode(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate short code"})
+			return
+		}
+	}
+
+	link, err := insertShortLink(ctx, shortCode, originalURL, expiresAt)
+	if errors.Is(err, errShortCodeTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "alias " + shortCode + " is already taken", "field": "alias"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving URL from API: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save short url"})
+		return
+	}
+
+	shortURL := buildShortURL(c, link.ShortCode)
+	response := gin.H{
+		"short_code":   link.ShortCode,
+		"short_url":    shortURL,
+		"original_url": link.OriginalURL,
+		"created_at":   link.CreatedAt,
+		"expires_at":   nil,
+	}
+	if expiresAt.Valid {
+		response["expires_at"] = expiresAt.Time
+	}
+	c.Header("Location", shortURL)
+	c.JSON(http.StatusCreated, response)
+}
+
+// Setup public API routes
+func setupAPIRoutes(r *gin.Engine) {
+	api := r.Group("/api/v1")
+
+	// CORS for these routes is handled by corsMiddleware (from cors.go)
+	quick := api.Group("/quick")
+	quick.Use(apiTokenMiddleware())
+	quick.GET("", quickShortenHandler)
+	quick.POST("", quickShortenHandler)
+
+	api.POST("/links", apiTokenMiddleware(), createLinkHandler)
+	api.POST("/shorten", apiTokenMiddleware(), shortenHandler)
+
+	// Linkblog submissions (from bookmarks.go)
+	bookmarks := api.Group("/bookmarks")
+	bookmarks.Use(apiTokenMiddleware())
+	bookmarks.GET("", quickBookmarkHandler)
+	bookmarks.POST("", quickBookmarkHandler)
+
+	// Headless content API (from contentapi.go)
+	api.GET("/content", contentAPIHandler)
+	api.GET("/content/:section", contentAPIHandler)
+
+	// Reading list (from reading.go)
+	api.GET("/reading", readingAPIHandler)
+
+	// Read-only GraphQL API (from graphql.go)
+	api.GET("/graphql", graphQLHandler)
+	api.POST("/graphql", graphQLHandler)
+}
+
+// Setup admin API token management routes
+func setupAPITokenAdminRoutes(adminGroup *gin.RouterGroup) {
+	// List tokens
+	adminGroup.GET("/api-tokens", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		rows, err := dbQuery(ctx, `
+			SELECT id, name, COALESCE(rate_limit, 30), created_at, last_used_at, expires_at
+			FROM api_tokens
+			ORDER BY created_at DESC
+		`)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load API tokens"})
+			return
+		}
+		defer rows.Close()
+
+		var tokens []gin.H
+		for rows.Next() {
+			var t APIToken
+			if err := rows.Scan(&t.ID, &t.Name, &t.RateLimit, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+				continue
+			}
+			entry := gin.H{"id": t.ID, "name": t.Name, "rate_limit": t.RateLimit, "created_at": t.CreatedAt}
+			if t.LastUsedAt.Valid {
+				entry["last_used_at"] = t.LastUsedAt.Time
+			}
+			if t.ExpiresAt.Valid {
+				entry["expires_at"] = t.ExpiresAt.Time
+				entry["expired"] = !time.Now().Before(t.ExpiresAt.Time)
+			}
+			tokens = append(tokens, entry)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+	})
+
+	// Create a token - the raw value is only ever returned here
+	adminGroup.POST("/api-tokens", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is required"})
+			return
+		}
+
+		rateLimit := defaultTokenRateLimit
+		if v := c.PostForm("rate_limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rate_limit must be a positive integer"})
+				return
+			}
+			rateLimit = n
+		}
+
+		// Tokens live as long as the policy allows (from rotation.go), or
+		// less if asked; a policy of 0 lets them live until revoked
+		lifetime := policyDays(apiTokenLifetimeSetting, defaultAPITokenLifetimeDays)
+		if v := c.PostForm("expires_days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || (lifetime > 0 && n > lifetime) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expires_days must be a positive number of days within the token lifetime policy"})
+				return
+			}
+			lifetime = n
+		}
+		var expiresAt sql.NullTime
+		if lifetime > 0 {
+			expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, lifetime).Truncate(time.Second), Valid: true}
+		}
+
+		token := generateAdminToken()
+		_, err := dbExec(ctx, "INSERT INTO api_tokens (name, token_hash, rate_limit, expires_at) VALUES (?, ?, ?, ?)",
+			name, hashAPIToken(token), rateLimit, expiresAt)
+		if err != nil {
+			log.Printf("Error creating API token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API token"})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		recordSecurityEvent(ctx, eventTokenCreated, ipHash, name)
+		log.Printf("API token %q created by admin from %s", name, ipHash)
+		response := gin.H{"name": name, "token": token, "rate_limit": rateLimit}
+		if expiresAt.Valid {
+			response["expires_at"] = expiresAt.Time
+		}
+		c.JSON(http.StatusCreated, response)
+	})
+
+	// Revoke a token
+	adminGroup.DELETE("/api-tokens/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM api_tokens WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API token"})
+			return
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		recordSecurityEvent(ctx, eventTokenRevoked, ipHash, "token "+c.Param("id"))
+		log.Printf("API token %s revoked by admin from %s", c.Param("id"), ipHash)
+		c.JSON(http.StatusOK, gin.H{"message": "API token revoked"})
+	})
+}
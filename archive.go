@@ -0,0 +1,454 @@
+// archive.go - Visitor data retention and archiving
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Visitor rows older than this are archived and deleted
+const visitorRetentionMonths = 12
+
+// Whole archival run, across all months; each query still has its own timeout
+const visitorArchiveTimeout = 10 * time.Minute
+
+// Archived month of visitor data
+type VisitorArchiveMonth struct {
+	Month          string // YYYY-MM
+	Views          int64
+	UniqueVisitors int64
+	ArchivedRows   int64
+	ExportLocation string
+	ArchivedAt     time.Time
+	TopPaths       []PathViews
+}
+
+// Views of one path in an archived month
+type PathViews struct {
+	Path  string
+	Views int64
+}
+
+// Where raw rows are exported before deletion; both are optional
+type archiveSettings struct {
+	Dir        string // VISITOR_ARCHIVE_DIR
+	S3Bucket   string // VISITOR_ARCHIVE_S3_BUCKET
+	S3Region   string // VISITOR_ARCHIVE_S3_REGION
+	S3Endpoint string // VISITOR_ARCHIVE_S3_ENDPOINT, for S3-compatible stores
+	S3Prefix   string // VISITOR_ARCHIVE_S3_PREFIX
+	AccessKey  string // AWS_ACCESS_KEY_ID
+	SecretKey  string // AWS_SECRET_ACCESS_KEY
+}
+
+func loadArchiveSettings() archiveSettings {
+	settings := archiveSettings{
+		Dir:        os.Getenv("VISITOR_ARCHIVE_DIR"),
+		S3Bucket:   os.Getenv("VISITOR_ARCHIVE_S3_BUCKET"),
+		S3Region:   os.Getenv("VISITOR_ARCHIVE_S3_REGION"),
+		S3Endpoint: strings.TrimRight(os.Getenv("VISITOR_ARCHIVE_S3_ENDPOINT"), "/"),
+		S3Prefix:   os.Getenv("VISITOR_ARCHIVE_S3_PREFIX"),
+		AccessKey:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:  os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if settings.S3Region == "" {
+		settings.S3Region = "us-east-1"
+	}
+	return settings
+}
+
+// Initialize the visitor archive tables
+func initVisitorArchive() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS visitor_archive (
+		month TEXT PRIMARY KEY,
+		views INTEGER NOT NULL DEFAULT 0,
+		unique_visitors INTEGER NOT NULL DEFAULT 0,
+		archived_rows INTEGER NOT NULL DEFAULT 0,
+		export_location TEXT,
+		archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS visitor_archive_paths (
+		month TEXT NOT NULL,
+		path TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (month, path)
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create visitor archive tables:", err)
+		}
+	}
+
+	startWorker("visitor-retention", visitorRetentionWorker) // from safego.go
+}
+
+// Archive expired visitor data daily
+func visitorRetentionWorker() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		archiveOldVisitorData()
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Fold visitor rows past retention into monthly summaries, export them if
+// configured, then delete them. A month whose export fails keeps its rows
+// for the next run rather than losing them.
+func archiveOldVisitorData() {
+	var err error
+	forEachSite(func(ctx context.Context) { // from sites.go
+		if siteErr := archiveSiteVisitorData(ctx); siteErr != nil {
+			err = siteErr
+		}
+	})
+	recordJobRun("visitor-cleanup", 24*time.Hour, err) // from diagnostics.go
+}
+
+func archiveSiteVisitorData(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, visitorArchiveTimeout)
+	defer cancel()
+
+	cutoff := time.Now().UTC().AddDate(0, -visitorRetentionMonths, 0).Format(sqliteTimestamp)
+	months, err := expiredVisitorMonths(ctx, cutoff)
+	var archived int64
+	for _, month := range months {
+		if err != nil {
+			break
+		}
+		var rows int64
+		rows, err = archiveVisitorMonth(ctx, month, cutoff)
+		archived += rows
+	}
+
+	site := siteFromContext(ctx).Name
+	if err != nil {
+		log.Printf("Error archiving old visitor data for %s: %v", site, err)
+	}
+	if archived > 0 {
+		log.Printf("Privacy cleanup: archived and removed %d visitor records older than %d months from %s", archived, visitorRetentionMonths, site)
+	}
+	return err
+}
+
+// Months (YYYY-MM) with visitor rows older than the cutoff. Timestamps are
+// stored as text starting YYYY-MM-DD HH:MM:SS, so they compare as strings.
+func expiredVisitorMonths(ctx context.Context, cutoff string) ([]string, error) {
+	rows, err := dbQuery(ctx, "SELECT DISTINCT substr(timestamp, 1, 7) FROM visitors WHERE timestamp < ? ORDER BY 1", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []string
+	for rows.Next() {
+		var month string
+		if rows.Scan(&month) == nil {
+			months = append(months, month)
+		}
+	}
+	return months, rows.Err()
+}
+
+// Archive one month's expired rows, returning how many were removed.
+// Runs several times for the current month at the cutoff, so a returning
+// visitor may be counted as unique once per run.
+func archiveVisitorMonth(ctx context.Context, month, cutoff string) (int64, error) {
+	start := month + "-01 00:00:00"
+	monthStart, err := time.Parse("2006-01", month)
+	if err != nil {
+		return 0, fmt.Errorf("bad month %q: %w", month, err)
+	}
+	end := monthStart.AddDate(0, 1, 0).Format(sqliteTimestamp)
+	if cutoff < end {
+		end = cutoff
+	}
+
+	export, err := os.CreateTemp("", "visitors-"+month+"-*.jsonl.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(export.Name())
+	defer export.Close()
+
+	// Stream rows into the export while totalling them
+	rows, err := dbQuery(ctx, `
+		SELECT id, hashed_ip, COALESCE(user_agent, ''), COALESCE(path, ''), timestamp, COALESCE(country, '')
+		FROM visitors WHERE timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp`, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(export)
+	encoder := json.NewEncoder(gz)
+	pathViews := map[string]int64{}
+	visitors := map[string]bool{}
+	var count int64
+	for rows.Next() {
+		var v VisitorMetric
+		if err := rows.Scan(&v.ID, &v.HashedIP, &v.UserAgent, &v.Path, &v.Timestamp, &v.Country); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := encoder.Encode(v); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pathViews[v.Path]++
+		visitors[v.HashedIP] = true
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	location, err := exportVisitorArchive(ctx, loadArchiveSettings(), export, month)
+	if err != nil {
+		return 0, fmt.Errorf("exporting %s: %w", month, err)
+	}
+
+	tx, cancel, err := dbBegin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO visitor_archive (month, views, unique_visitors, archived_rows, export_location)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(month) DO UPDATE SET
+			views = views + excluded.views,
+			unique_visitors = unique_visitors + excluded.unique_visitors,
+			archived_rows = archived_rows + excluded.archived_rows,
+			export_location = COALESCE(excluded.export_location, export_location),
+			archived_at = CURRENT_TIMESTAMP
+	`, month, count, len(visitors), count, sql.NullString{String: location, Valid: location != ""})
+	if err != nil {
+		return 0, err
+	}
+	for path, views := range pathViews {
+		_, err = tx.Exec(`
+			INSERT INTO visitor_archive_paths (month, path, views) VALUES (?, ?, ?)
+			ON CONFLICT(month, path) DO UPDATE SET views = views + excluded.views
+		`, month, path, views)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM visitors WHERE timestamp >= ? AND timestamp < ?", start, end)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// Copy a finished export to the configured destinations, returning where
+// it went (empty when exports are not configured)
+func exportVisitorArchive(ctx context.Context, settings archiveSettings, export *os.File, month string) (string, error) {
+	name := fmt.Sprintf("visitors-%s-%s.jsonl.gz", month, time.Now().UTC().Format("20060102T150405"))
+	if site := siteFromContext(ctx); !site.Primary() {
+		name = site.Host + "-" + name // tenants share the export destinations
+	}
+	var locations []string
+
+	if settings.Dir != "" {
+		if err := os.MkdirAll(settings.Dir, 0o700); err != nil {
+			return "", err
+		}
+		path := filepath.Join(settings.Dir, name)
+		if err := copyArchiveFile(export, path); err != nil {
+			return "", err
+		}
+		locations = append(locations, path)
+	}
+
+	if settings.S3Bucket != "" {
+		if settings.AccessKey == "" || settings.SecretKey == "" {
+			return "", fmt.Errorf("VISITOR_ARCHIVE_S3_BUCKET is set but AWS credentials are not")
+		}
+		key := strings.TrimLeft(settings.S3Prefix+"/"+name, "/")
+		if err := putS3Object(ctx, settings, key, export); err != nil {
+			return "", err
+		}
+		locations = append(locations, "s3://"+settings.S3Bucket+"/"+key)
+	}
+
+	return strings.Join(locations, ", "), nil
+}
+
+// Write the export to path, through a temp file so a partial copy never
+// looks finished
+func copyArchiveFile(export *os.File, path string) error {
+	if _, err := export.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := path + ".partial"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, export); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Upload a file with a SigV4-signed PutObject. Uses virtual-hosted AWS URLs,
+// or path-style URLs under VISITOR_ARCHIVE_S3_ENDPOINT (MinIO, R2, ...).
+func putS3Object(ctx context.Context, settings archiveSettings, key string, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	endpoint := "https://" + settings.S3Bucket + ".s3." + settings.S3Region + ".amazonaws.com/" + key
+	if settings.S3Endpoint != "" {
+		endpoint = settings.S3Endpoint + "/" + settings.S3Bucket + "/" + key
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	signS3Request(req, settings, payloadHash, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 upload failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Add AWS Signature Version 4 headers to an S3 request
+func signS3Request(req *http.Request, settings archiveSettings, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + settings.S3Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + settings.SecretKey)
+	for _, part := range []string{day, settings.S3Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+settings.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Archived months, newest first, with their most viewed paths
+func getVisitorArchive(ctx context.Context) ([]VisitorArchiveMonth, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT month, views, unique_visitors, archived_rows, COALESCE(export_location, ''), archived_at
+		FROM visitor_archive ORDER BY month DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []VisitorArchiveMonth
+	for rows.Next() {
+		var m VisitorArchiveMonth
+		if err := rows.Scan(&m.Month, &m.Views, &m.UniqueVisitors, &m.ArchivedRows, &m.ExportLocation, &m.ArchivedAt); err != nil {
+			continue
+		}
+		months = append(months, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range months {
+		pathRows, err := dbQuery(ctx, `
+			SELECT path, views FROM visitor_archive_paths
+			WHERE month = ? ORDER BY views DESC LIMIT 5`, months[i].Month)
+		if err != nil {
+			return nil, err
+		}
+		for pathRows.Next() {
+			var p PathViews
+			if pathRows.Scan(&p.Path, &p.Views) == nil {
+				months[i].TopPaths = append(months[i].TopPaths, p)
+			}
+		}
+		pathRows.Close()
+	}
+	return months, nil
+}
@@ -0,0 +1,137 @@
+// availability.go - "Hire me" availability status with change history
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Availability states, in the order the admin offers them
+const (
+	availableForHire    = "available"
+	availableOpenOffers = "open"
+	availableNotLooking = "not-looking"
+)
+
+var availabilityLabels = map[string]string{
+	availableForHire:    "Available for hire",
+	availableOpenOffers: "Open to offers",
+	availableNotLooking: "Not looking",
+}
+
+// Availability as of a change; the latest change is the current state
+type Availability struct {
+	Status    string    `json:"status"`
+	Label     string    `json:"label"`
+	Note      string    `json:"note,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Initialize availability history storage
+func initAvailability() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS availability_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		status TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		ip_hash TEXT,
+		changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create availability_changes table:", err)
+	}
+}
+
+// Most recent changes first
+func getAvailabilityHistory(ctx context.Context, limit int) ([]Availability, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT status, note, changed_at FROM availability_changes
+		ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Availability
+	for rows.Next() {
+		var a Availability
+		if err := rows.Scan(&a.Status, &a.Note, &a.ChangedAt); err != nil {
+			continue
+		}
+		a.Label = availabilityLabels[a.Status]
+		history = append(history, a)
+	}
+	return history, rows.Err()
+}
+
+// Current availability; nil until it has been set
+func getAvailability(ctx context.Context) (*Availability, error) {
+	history, err := getAvailabilityHistory(ctx, 1)
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	return &history[0], nil
+}
+
+// Description for link previews, led by the availability so it shows in
+// shared links
+func availabilityDescription(a *Availability, description string) string {
+	if a == nil {
+		return description
+	}
+	lead := a.Label
+	if a.Note != "" {
+		lead += ": " + a.Note
+	}
+	if description == "" {
+		return lead
+	}
+	return lead + ". " + description
+}
+
+// Setup admin availability routes
+func setupAvailabilityAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/availability", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		status := c.PostForm("status")
+		if _, ok := availabilityLabels[status]; !ok {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown availability status",
+			})
+			return
+		}
+		note := strings.TrimSpace(c.PostForm("note"))
+
+		// Saving the same state again would only add noise to the history
+		current, err := getAvailability(ctx)
+		if err != nil {
+			log.Printf("Error loading availability: %v", err)
+		}
+		if current != nil && current.Status == status && current.Note == note {
+			c.Redirect(http.StatusSeeOther, "/admin/content")
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		_, err = dbExec(ctx, "INSERT INTO availability_changes (status, note, ip_hash, changed_at) VALUES (?, ?, ?, ?)",
+			status, note, ipHash, time.Now())
+		if err != nil {
+			log.Printf("Error saving availability: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save availability",
+			})
+			return
+		}
+
+		log.Printf("Availability set to %s by admin from %s", status, ipHash)
+		c.Redirect(http.StatusSeeOther, "/admin/content")
+	})
+}
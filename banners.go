@@ -0,0 +1,259 @@
+// banners.go - Scheduled announcement banners
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Banner shown on matching pages between its start and end times
+type Banner struct {
+	ID          int          `json:"id"`
+	Message     string       `json:"message"` // Markdown source
+	Pages       string       `json:"pages"`   // one path per line; "/blog*" matches a prefix; empty for every page
+	Dismissible bool         `json:"dismissible"`
+	StartsAt    time.Time    `json:"starts_at"`
+	EndsAt      sql.NullTime `json:"-"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// Cookie listing the banners a visitor dismissed, as dot-separated ids
+const dismissedBannersCookie = "dismissed_banners"
+
+type bannersContextKey struct{}
+
+// Whether the banner is showing at the given time
+func (b Banner) ActiveAt(now time.Time) bool {
+	return !now.Before(b.StartsAt) && (!b.EndsAt.Valid || now.Before(b.EndsAt.Time))
+}
+
+// Whether the banner targets the page
+func (b Banner) ShowsOn(path string) bool {
+	if strings.TrimSpace(b.Pages) == "" {
+		return true
+	}
+	for _, page := range strings.Fields(b.Pages) {
+		if prefix, ok := strings.CutSuffix(page, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if normalizeRedirectPath(page) == normalizeRedirectPath(path) { // from redirects.go
+			return true
+		}
+	}
+	return false
+}
+
+// Rendered message, sanitized like snippets (from snippets.go)
+func (b Banner) HTML() template.HTML {
+	return template.HTML(renderSnippet(snippetMarkdown, b.Message))
+}
+
+// Initialize banner storage
+func initBanners() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS banners (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message TEXT NOT NULL,
+		pages TEXT NOT NULL DEFAULT '',
+		dismissible INTEGER NOT NULL DEFAULT 1,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create banners table:", err)
+	}
+}
+
+// All banners in start order
+func getBanners(ctx context.Context) ([]Banner, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, message, pages, dismissible, starts_at, ends_at, created_at
+		FROM banners
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var banners []Banner
+	for rows.Next() {
+		var b Banner
+		if err := rows.Scan(&b.ID, &b.Message, &b.Pages, &b.Dismissible, &b.StartsAt, &b.EndsAt, &b.CreatedAt); err != nil {
+			continue
+		}
+		banners = append(banners, b)
+	}
+
+	// Timestamps are stored as text, so sort here rather than in SQL
+	sort.Slice(banners, func(i, j int) bool {
+		return banners[i].StartsAt.Before(banners[j].StartsAt)
+	})
+	return banners, rows.Err()
+}
+
+// Find the banners for the page and attach them to the request for the
+// renderer to place at the top of the body (see themes.go)
+func bannerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if c.Request.Method != http.MethodGet || c.GetHeader("HX-Request") == "true" ||
+			routePolicyFor(path).NoBanners { // from routepolicy.go
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		banners, err := getBanners(ctx)
+		if err != nil {
+			log.Printf("Error loading banners: %v", err)
+			c.Next()
+			return
+		}
+
+		dismissed := map[string]bool{}
+		if value, err := c.Cookie(dismissedBannersCookie); err == nil {
+			for _, id := range strings.Split(value, ".") {
+				dismissed[id] = true
+			}
+		}
+
+		now := time.Now()
+		var showing []Banner
+		for _, b := range banners {
+			if b.ActiveAt(now) && b.ShowsOn(path) && !(b.Dismissible && dismissed[strconv.Itoa(b.ID)]) {
+				showing = append(showing, b)
+			}
+		}
+		if len(showing) > 0 {
+			c.Request = c.Request.WithContext(context.WithValue(ctx, bannersContextKey{}, showing))
+		}
+		c.Next()
+	}
+}
+
+// Render the request's banners with the "banners" template and put them
+// just inside <body>; pages without a body (HTMX partials) are left alone
+func insertBanners(ctx context.Context, tmpl *template.Template, page []byte) []byte {
+	banners, _ := ctx.Value(bannersContextKey{}).([]Banner)
+	if len(banners) == 0 {
+		return page
+	}
+	start := bytes.Index(page, []byte("<body"))
+	if start < 0 {
+		return page
+	}
+	end := bytes.IndexByte(page[start:], '>')
+	if end < 0 {
+		return page
+	}
+	at := start + end + 1
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "banners", banners); err != nil {
+		log.Printf("Error rendering banners: %v", err)
+		return page
+	}
+	out := make([]byte, 0, len(page)+buf.Len())
+	out = append(out, page[:at]...)
+	out = append(out, buf.Bytes()...)
+	return append(out, page[at:]...)
+}
+
+// Setup admin banner routes
+func setupBannerAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/banners", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		banners, err := getBanners(ctx)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load banners",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "admin-banners.html", gin.H{
+			"banners": banners,
+			"now":     time.Now(),
+		})
+	})
+
+	adminGroup.POST("/banners", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		message := strings.TrimSpace(c.PostForm("message"))
+		if message == "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A banner needs a message",
+			})
+			return
+		}
+
+		// Times are entered in the server's local time zone; no start means now
+		startsAt := time.Now()
+		if value := c.PostForm("starts_at"); value != "" {
+			var err error
+			startsAt, err = time.ParseInLocation(datetimeLocalLayout, value, time.Local) // from linkschedule.go
+			if err != nil {
+				c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+					"error": "Invalid start time",
+				})
+				return
+			}
+		}
+		var endsAt sql.NullTime
+		if value := c.PostForm("ends_at"); value != "" {
+			var err error
+			endsAt.Time, err = time.ParseInLocation(datetimeLocalLayout, value, time.Local)
+			endsAt.Valid = err == nil
+			if !endsAt.Valid || !endsAt.Time.After(startsAt) {
+				c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+					"error": "The end time must be after the start time",
+				})
+				return
+			}
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO banners (message, pages, dismissible, starts_at, ends_at) VALUES (?, ?, ?, ?, ?)
+		`, message, strings.TrimSpace(c.PostForm("pages")), c.PostForm("dismissible") == "1", startsAt, endsAt)
+		if err != nil {
+			log.Printf("Error saving banner: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save banner",
+			})
+			return
+		}
+
+		log.Printf("Banner added by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/banners")
+	})
+
+	adminGroup.DELETE("/banners/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM banners WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete banner"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Banner not found"})
+			return
+		}
+
+		log.Printf("Banner %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Banner deleted"})
+	})
+}
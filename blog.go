@@ -0,0 +1,397 @@
+// blog.go - Markdown blog with admin editor
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Blog post
+type Post struct {
+	ID              int          `json:"id"`
+	Slug            string       `json:"slug"`
+	Title           string       `json:"title"`
+	Summary         string       `json:"summary"`
+	Body            string       `json:"body"` // Markdown source
+	Status          string       `json:"status"`
+	MetaDescription string       `json:"meta_description"`
+	CanonicalURL    string       `json:"canonical_url"`
+	NoIndex         bool         `json:"noindex"`
+	PublishedAt     sql.NullTime `json:"-"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+const (
+	postDraft     = "draft"
+	postPublished = "published"
+)
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Rendered HTML body (Markdown, raw HTML escaped)
+func (p Post) HTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(p.Body), &buf); err != nil {
+		log.Printf("Error rendering post %s: %v", p.Slug, err)
+		return template.HTML(template.HTMLEscapeString(p.Body))
+	}
+	return template.HTML(buf.String())
+}
+
+// Publication date for templates
+func (p Post) Date() time.Time {
+	if p.PublishedAt.Valid {
+		return p.PublishedAt.Time
+	}
+	return p.CreatedAt
+}
+
+// Public URL of the post
+func (p Post) Permalink() string {
+	return siteBaseURL() + "/blog/" + p.Slug // from seo.go
+}
+
+// Initialize blog storage
+func initBlog() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT NOT NULL UNIQUE,
+		title TEXT NOT NULL,
+		summary TEXT,
+		body TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'draft',
+		meta_description TEXT,
+		canonical_url TEXT,
+		noindex INTEGER DEFAULT 0,
+		published_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create posts table:", err)
+	}
+
+	// Add SEO columns to posts tables created before they existed
+	db.Exec(`ALTER TABLE posts ADD COLUMN meta_description TEXT`) // Ignore error if column already exists
+	db.Exec(`ALTER TABLE posts ADD COLUMN canonical_url TEXT`)
+	db.Exec(`ALTER TABLE posts ADD COLUMN noindex INTEGER DEFAULT 0`)
+}
+
+// Turn a title into a URL slug
+func slugify(title string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(title), "-"), "-")
+}
+
+const postColumns = `id, slug, title, COALESCE(summary, ''), body, status,
+	COALESCE(meta_description, ''), COALESCE(canonical_url, ''), COALESCE(noindex, 0),
+	published_at, created_at, updated_at`
+
+func scanPost(row interface{ Scan(...interface{}) error }) (Post, error) {
+	var p Post
+	err := row.Scan(&p.ID, &p.Slug, &p.Title, &p.Summary, &p.Body, &p.Status,
+		&p.MetaDescription, &p.CanonicalURL, &p.NoIndex, &p.PublishedAt, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func queryPosts(ctx context.Context, query string, args ...interface{}) ([]Post, error) {
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			continue
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// Get published posts, newest first
+func getPublishedPosts(ctx context.Context) ([]Post, error) {
+	return queryPosts(ctx, `SELECT `+postColumns+` FROM posts
+		WHERE status = 'published'
+		ORDER BY published_at DESC`)
+}
+
+// Get one page of published posts, newest first
+func getPublishedPostsPage(ctx context.Context, limit, offset int) ([]Post, error) {
+	return queryPosts(ctx, `SELECT `+postColumns+` FROM posts
+		WHERE status = 'published'
+		ORDER BY published_at DESC, id DESC
+		LIMIT ? OFFSET ?`, limit, offset)
+}
+
+func countPublishedPosts(ctx context.Context) (int, error) {
+	var count int
+	err := dbQueryRow(ctx, `SELECT COUNT(*) FROM posts WHERE status = 'published'`).Scan(&count)
+	return count, err
+}
+
+// Get a published post by slug
+func getPublishedPost(ctx context.Context, slug string) (Post, error) {
+	return scanPost(dbQueryRow(ctx, `SELECT `+postColumns+` FROM posts
+		WHERE slug = ? AND status = 'published'`, slug))
+}
+
+// Save a post from the admin form; returns the post id and whether
+// this save published it for the first time
+func savePostFromForm(ctx context.Context, c *gin.Context, id string) (int64, bool, error) {
+	title := strings.TrimSpace(c.PostForm("title"))
+	slug := slugify(c.PostForm("slug"))
+	if slug == "" {
+		slug = slugify(title)
+	}
+	summary := strings.TrimSpace(c.PostForm("summary"))
+	body := c.PostForm("body")
+	status := postDraft
+	if c.PostForm("status") == postPublished {
+		status = postPublished
+	}
+	metaDescription := strings.TrimSpace(c.PostForm("meta_description"))
+	canonicalURL := validCanonicalURL(c.PostForm("canonical_url"))
+	noIndex := c.PostForm("noindex") == "on"
+
+	if id == "" {
+		result, err := dbExec(ctx, `
+			INSERT INTO posts (slug, title, summary, body, status, meta_description, canonical_url, noindex, published_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, CASE WHEN ? = 'published' THEN ? END)
+		`, slug, title, summary, body, status, metaDescription, canonicalURL, noIndex, status, time.Now())
+		if err != nil {
+			return 0, false, err
+		}
+		postID, err := result.LastInsertId()
+		return postID, status == postPublished, err
+	}
+
+	var previouslyPublished sql.NullTime
+	dbQueryRow(ctx, "SELECT published_at FROM posts WHERE id = ?", id).Scan(&previouslyPublished)
+
+	// Keep the original publish date when re-saving a published post
+	result, err := dbExec(ctx, `
+		UPDATE posts SET slug = ?, title = ?, summary = ?, body = ?, status = ?,
+			meta_description = ?, canonical_url = ?, noindex = ?,
+			published_at = CASE WHEN ? = 'published' THEN COALESCE(published_at, ?) END,
+			updated_at = ?
+		WHERE id = ?
+	`, slug, title, summary, body, status, metaDescription, canonicalURL, noIndex, status, time.Now(), time.Now(), id)
+	if err != nil {
+		return 0, false, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, false, sql.ErrNoRows
+	}
+	postID, err := strconv.ParseInt(id, 10, 64)
+	return postID, status == postPublished && !previouslyPublished.Valid, err
+}
+
+// Hook run when a post is published for the first time
+type publishHook struct {
+	name string
+	run  func(post Post)
+}
+
+var publishHooks []publishHook
+
+// Register a function to run whenever a post goes live
+func registerPublishHook(name string, run func(post Post)) {
+	publishHooks = append(publishHooks, publishHook{name: name, run: run})
+}
+
+// Run every publish hook in the background
+func onPostPublished(ctx context.Context, postID int64) {
+	// Syndication and ActivityPub accounts belong to the primary site
+	if !siteFromContext(ctx).Primary() {
+		return
+	}
+
+	post, err := scanPost(dbQueryRow(ctx, `SELECT `+postColumns+` FROM posts WHERE id = ?`, postID))
+	if err != nil {
+		log.Printf("Error loading published post %d: %v", postID, err)
+		return
+	}
+
+	for _, hook := range publishHooks {
+		safeGo("publish-hook-"+hook.name, func() { hook.run(post) })
+	}
+}
+
+// Setup public blog routes
+func setupBlogRoutes(r *gin.Engine) {
+	r.GET("/blog", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		page := pageNumber(c) // from pagination.go
+		total, err := countPublishedPosts(ctx)
+		if err != nil {
+			log.Printf("Error counting posts: %v", err)
+		}
+		pager := numberedPager(c, page, blogPostsPerPage, total)
+		if page > pager.TotalPages {
+			recordNotFound(c)
+			c.HTML(http.StatusNotFound, "404.html", gin.H{
+				"message": "Page not found",
+			})
+			return
+		}
+		posts, err := getPublishedPostsPage(ctx, blogPostsPerPage, (page-1)*blogPostsPerPage)
+		if err != nil {
+			log.Printf("Error loading posts: %v", err)
+		}
+
+		// Later pages are their own canonical pages, linked to their neighbours
+		seo := getPageSEO(ctx, "/blog")
+		if page > 1 {
+			seo.Canonical = siteBaseURL() + "/blog?page=" + strconv.Itoa(page)
+		}
+		if pager.PrevURL != "" {
+			seo.PrevURL = siteBaseURL() + pager.PrevURL
+		}
+		if pager.NextURL != "" {
+			seo.NextURL = siteBaseURL() + pager.NextURL
+		}
+
+		c.HTML(http.StatusOK, "blog.html", gin.H{
+			"title": "Blog",
+			"posts": posts,
+			"pager": pager,
+			"seo":   seo,
+		})
+	})
+
+	r.GET("/blog/:slug", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		post, err := getPublishedPost(ctx, c.Param("slug"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading post: %v", err)
+			}
+			recordNotFound(c)
+			c.HTML(http.StatusNotFound, "404.html", gin.H{
+				"message": "Post not found",
+			})
+			return
+		}
+
+		// Approved webmentions (from webmention.go)
+		mentions, err := getApprovedWebmentions(ctx, post.Permalink())
+		if err != nil {
+			log.Printf("Error loading webmentions: %v", err)
+		}
+
+		c.Header("Link", `<https://zachkp.dev/webmention>; rel="webmention"`)
+		c.HTML(http.StatusOK, "blog-post.html", gin.H{
+			"title":      post.Title,
+			"post":       post,
+			"mentions":   mentions,
+			"seo":        post.SEO(),
+			"viewCounts": viewCountsEnabled(), // from viewcounts.go
+		})
+	})
+}
+
+// Setup admin blog editor routes
+func setupBlogAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/posts", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		posts, err := queryPosts(ctx, `SELECT `+postColumns+` FROM posts ORDER BY created_at DESC`)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load posts",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-posts.html", gin.H{
+			"posts": posts,
+		})
+	})
+
+	adminGroup.GET("/posts/new", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "admin-post-edit.html", gin.H{
+			"post": Post{Status: postDraft},
+		})
+	})
+
+	adminGroup.GET("/posts/:id/edit", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		post, err := scanPost(dbQueryRow(ctx, `SELECT `+postColumns+` FROM posts WHERE id = ?`, c.Param("id")))
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Post not found",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-post-edit.html", gin.H{
+			"post": post,
+		})
+	})
+
+	savePost := func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := c.Param("id")
+		if strings.TrimSpace(c.PostForm("title")) == "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A post needs a title",
+			})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		if id != "" {
+			recordRevision(ctx, "post", id, ipHash)
+		}
+		postID, published, err := savePostFromForm(ctx, c, id)
+		if err != nil {
+			log.Printf("Error saving post: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save post (is the slug already taken?)",
+			})
+			return
+		}
+
+		recordRevision(ctx, "post", strconv.FormatInt(postID, 10), ipHash)
+		log.Printf("Post %d saved by admin from %s", postID, ipHash)
+		if published {
+			onPostPublished(ctx, postID)
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/posts")
+	}
+	adminGroup.POST("/posts", savePost)
+	adminGroup.POST("/posts/:id", savePost)
+
+	adminGroup.DELETE("/posts/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		err := moveToTrash(ctx, "post", c.Param("id"), ipHash)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error deleting post %s: %v", c.Param("id"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
+			return
+		}
+
+		log.Printf("Post %s moved to trash by admin from %s", c.Param("id"), ipHash)
+		c.JSON(http.StatusOK, gin.H{"message": "Post moved to trash"})
+	})
+}
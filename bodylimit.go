@@ -0,0 +1,60 @@
+// bodylimit.go - Per-route request body size limits
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit for routes whose policy doesn't set one (from routepolicy.go)
+const defaultBodyLimit = 1 << 20
+
+// Reject oversized bodies with 413 before any handler parses them
+func bodyLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		policy := routePolicyFor(c.Request.URL.Path)
+		limit := policy.BodyLimit
+		if limit == 0 {
+			limit = defaultBodyLimit
+		}
+		tooLarge := c.Request.ContentLength > limit
+
+		// Content-Length can be absent (chunked) or wrong, so buffer up to the limit
+		var body []byte
+		if !tooLarge {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			tooLarge = int64(len(body)) > limit
+		}
+
+		if tooLarge {
+			log.Printf("Rejected oversized request to %s from %s", c.Request.URL.Path, hashIP(c.ClientIP()))
+			c.Header("Connection", "close")
+			message := fmt.Sprintf("Request is too large (limit %s).", formatBytes(limit)) // from diagnostics.go
+			if policy.ErrorTemplate != "" && c.GetHeader("HX-Request") == "true" {
+				c.HTML(http.StatusRequestEntityTooLarge, policy.ErrorTemplate, gin.H{"error": message})
+			} else {
+				abortAPIError(c, http.StatusRequestEntityTooLarge, message) // from api.go
+			}
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
@@ -0,0 +1,226 @@
+// bookmarks.go - Linkblog of bookmarked pages with short commentary
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Bookmarked page; title and image come from the page unless given
+type Bookmark struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	Commentary string    `json:"commentary"` // Markdown source
+	ImageURL   string    `json:"image_url,omitempty"`
+	Summary    string    `json:"summary,omitempty"` // the page's own description, from the metadata cache
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Rendered commentary (Markdown, raw HTML escaped)
+func (b Bookmark) CommentaryHTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(b.Commentary), &buf); err != nil {
+		log.Printf("Error rendering bookmark %d: %v", b.ID, err)
+		return template.HTML(template.HTMLEscapeString(b.Commentary))
+	}
+	return template.HTML(buf.String())
+}
+
+// Public URL of the bookmark on the linkblog
+func (b Bookmark) Permalink() string {
+	return siteBaseURL() + "/bookmarks#bookmark-" + strconv.Itoa(b.ID) // from seo.go
+}
+
+// Host of the bookmarked page, shown next to the title
+func (b Bookmark) Host() string {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// Initialize bookmark storage
+func initBookmarks() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS bookmarks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		title TEXT NOT NULL,
+		commentary TEXT NOT NULL DEFAULT '',
+		image_url TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create bookmarks table:", err)
+	}
+}
+
+// Newest bookmarks first, with the page's current image and description
+// when the metadata cache has them
+func getBookmarks(ctx context.Context, limit int) ([]Bookmark, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT b.id, b.url, b.title, b.commentary, COALESCE(NULLIF(o.image, ''), b.image_url), COALESCE(o.description, ''), b.created_at
+		FROM bookmarks b
+		LEFT JOIN og_cache o ON o.url = b.url
+		ORDER BY b.id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookmarks []Bookmark
+	for rows.Next() {
+		var b Bookmark
+		if err := rows.Scan(&b.ID, &b.URL, &b.Title, &b.Commentary, &b.ImageURL, &b.Summary, &b.CreatedAt); err != nil {
+			continue
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// Save a bookmark, filling in the title and image from the page. A page
+// that cannot be fetched is still bookmarked, titled by its URL.
+func saveBookmark(ctx context.Context, pageURL, title, commentary string) (Bookmark, error) {
+	b := Bookmark{URL: pageURL, Title: title, Commentary: commentary, CreatedAt: time.Now()}
+
+	meta, err := cachedPageMetadata(ctx, pageURL) // from ogcache.go
+	if err != nil {
+		log.Printf("Error fetching metadata for bookmark %s: %v", pageURL, err)
+	}
+	if b.Title == "" {
+		b.Title = meta.Title
+	}
+	if b.Title == "" {
+		b.Title = pageURL
+	}
+	b.ImageURL = meta.Image
+
+	result, err := dbExec(ctx, `
+		INSERT INTO bookmarks (url, title, commentary, image_url, created_at) VALUES (?, ?, ?, ?, ?)
+	`, b.URL, b.Title, b.Commentary, b.ImageURL, b.CreatedAt)
+	if err != nil {
+		return b, err
+	}
+	id, _ := result.LastInsertId()
+	b.ID = int(id)
+	return b, nil
+}
+
+// Setup public linkblog routes
+func setupBookmarkRoutes(r *gin.Engine) {
+	r.GET("/bookmarks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		bookmarks, err := getBookmarks(ctx, 100)
+		if err != nil {
+			log.Printf("Error loading bookmarks: %v", err)
+		}
+		c.HTML(http.StatusOK, "bookmarks.html", gin.H{
+			"title":     "Bookmarks",
+			"bookmarks": bookmarks,
+			"seo":       getPageSEO(ctx, "/bookmarks"),
+		})
+	})
+}
+
+// Bookmark a page from a bookmarklet or script and reply in plaintext
+func quickBookmarkHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	pageURL := strings.TrimSpace(c.Query("url"))
+	if pageURL == "" {
+		pageURL = strings.TrimSpace(c.PostForm("url"))
+	}
+	if !isPublicLongURL(pageURL) { // from main.go
+		c.String(http.StatusBadRequest, "a valid public http:// or https:// url is required")
+		return
+	}
+	title := strings.TrimSpace(c.DefaultQuery("title", c.PostForm("title")))
+	commentary := strings.TrimSpace(c.DefaultQuery("commentary", c.PostForm("commentary")))
+
+	b, err := saveBookmark(ctx, pageURL, title, commentary)
+	if err != nil {
+		log.Printf("Error saving bookmark from API: %v", err)
+		c.String(http.StatusInternalServerError, "could not save bookmark")
+		return
+	}
+
+	// Bookmarklets can ask to be sent straight back to the page they came from
+	if c.Query("redirect") == "1" {
+		c.Header("X-Bookmark-URL", b.Permalink())
+		c.Redirect(http.StatusSeeOther, pageURL)
+		return
+	}
+
+	c.String(http.StatusOK, b.Permalink())
+}
+
+// Setup admin linkblog routes
+func setupBookmarkAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/bookmarks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		bookmarks, err := getBookmarks(ctx, 200)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load bookmarks",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-bookmarks.html", gin.H{
+			"bookmarks": bookmarks,
+		})
+	})
+
+	adminGroup.POST("/bookmarks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		pageURL := strings.TrimSpace(c.PostForm("url"))
+		if !isPublicLongURL(pageURL) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A bookmark needs a valid public http:// or https:// URL",
+			})
+			return
+		}
+
+		_, err := saveBookmark(ctx, pageURL, strings.TrimSpace(c.PostForm("title")), strings.TrimSpace(c.PostForm("commentary")))
+		if err != nil {
+			log.Printf("Error saving bookmark: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save bookmark",
+			})
+			return
+		}
+
+		log.Printf("Bookmark added by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/bookmarks")
+	})
+
+	adminGroup.DELETE("/bookmarks/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM bookmarks WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete bookmark"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bookmark not found"})
+			return
+		}
+
+		log.Printf("Bookmark %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Bookmark deleted"})
+	})
+}
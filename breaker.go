@@ -0,0 +1,207 @@
+// breaker.go - Circuit breakers around external services
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Breaker states
+const (
+	breakerClosed   = "closed"    // calls go through
+	breakerOpen     = "open"      // calls fail fast until the cooldown ends
+	breakerHalfOpen = "half-open" // one call is let through to test recovery
+)
+
+// How often open breakers with a probe are checked
+const breakerProbeInterval = time.Minute
+
+// Returned instead of calling a service whose breaker is open
+var errCircuitOpen = errors.New("circuit open")
+
+// Breaker around one external service, so an outage fails fast instead of
+// piling up timeouts. After threshold consecutive failures it opens; once
+// the cooldown has passed, the next call (or the probe, if there is one) is
+// let through, closing it again on success.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	probe     func() error // cheap reachability check; nil to wait for a real call
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+	trips     int
+}
+
+// Breaker state as shown in diagnostics
+type BreakerStatus struct {
+	Name      string
+	State     string
+	Failures  int
+	Trips     int
+	LastError string
+	RetryAt   time.Time // when an open breaker is next tried
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   []*circuitBreaker
+)
+
+// Create a breaker and register it for diagnostics and probing
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration, probe func() error) *circuitBreaker {
+	b := &circuitBreaker{name: name, threshold: threshold, cooldown: cooldown, probe: probe, state: breakerClosed}
+	breakersMu.Lock()
+	breakers = append(breakers, b)
+	breakersMu.Unlock()
+	return b
+}
+
+// Run fn unless the breaker is open, recording how it went. Callers keep
+// their own fallbacks; an open breaker returns an error wrapping
+// errCircuitOpen without calling fn. A panic in fn counts as a failure and
+// is passed on, so a half-open breaker isn't left waiting on it forever.
+func (b *circuitBreaker) Do(fn func() error) (err error) {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b.record(fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+		b.record(err)
+	}()
+	return fn()
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%s unavailable after repeated failures, retrying after %s: %w",
+				b.name, b.openedAt.Add(b.cooldown).Format("15:04"), errCircuitOpen)
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// Another call is already testing the service
+		return fmt.Errorf("%s unavailable, recovery being tested: %w", b.name, errCircuitOpen)
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Printf("Circuit %s closed: service recovered", b.name)
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state == breakerClosed {
+			b.trips++
+			log.Printf("Circuit %s opened after %d failures: %v", b.name, b.failures, err)
+		}
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// Test an open breaker's service once its cooldown has passed
+func (b *circuitBreaker) runProbe() {
+	b.mu.Lock()
+	due := b.probe != nil && b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown
+	if due {
+		b.state = breakerHalfOpen
+	}
+	b.mu.Unlock()
+	if due {
+		b.record(b.probe())
+	}
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{Name: b.name, State: b.state, Failures: b.failures, Trips: b.trips, LastError: b.lastError}
+	if b.state == breakerOpen {
+		s.RetryAt = b.openedAt.Add(b.cooldown)
+	}
+	return s
+}
+
+// Start probing open breakers
+func initBreakers() {
+	startWorker("circuit-probe", breakerProbeWorker) // from safego.go
+}
+
+func breakerProbeWorker() {
+	ticker := time.NewTicker(breakerProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+		breakersMu.Lock()
+		all := append([]*circuitBreaker(nil), breakers...)
+		breakersMu.Unlock()
+		for _, b := range all {
+			b.runProbe()
+		}
+	}
+}
+
+// Every breaker's state, by name
+func breakerStatuses() []BreakerStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Diagnostics entries for the breakers
+func checkBreakers() []DiagnosticCheck {
+	var checks []DiagnosticCheck
+	for _, s := range breakerStatuses() {
+		check := DiagnosticCheck{Name: "Circuit: " + s.Name, Status: checkPass, Detail: s.State}
+		if s.Trips > 0 {
+			check.Detail += fmt.Sprintf(", opened %d time(s)", s.Trips)
+		}
+		switch s.State {
+		case breakerOpen:
+			check.Status = checkFail
+			check.Detail += "; failing fast until " + s.RetryAt.Format("15:04") + "; last error: " + s.LastError
+		case breakerHalfOpen:
+			check.Detail += "; testing recovery"
+		default:
+			if s.Failures > 0 {
+				check.Detail += fmt.Sprintf("; %d recent failure(s), last: %s", s.Failures, s.LastError)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,46 @@
+// breaker_test.go - Circuit breaker tests
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerRecordsPanics(t *testing.T) {
+	b := newCircuitBreaker("panic test", 1, time.Millisecond, nil)
+	failure := errors.New("down")
+
+	if err := b.Do(func() error { return failure }); !errors.Is(err, failure) {
+		t.Fatalf("first call: got %v", err)
+	}
+	if err := b.Do(func() error { return nil }); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("call while open: got %v, want errCircuitOpen", err)
+	}
+
+	// The call testing recovery panics: it has to count as a failure and
+	// reopen the breaker rather than leave it half-open
+	time.Sleep(2 * time.Millisecond)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic wasn't passed on")
+			}
+		}()
+		b.Do(func() error { panic("boom") })
+	}()
+	b.mu.Lock()
+	state, lastError := b.state, b.lastError
+	b.mu.Unlock()
+	if state != breakerOpen || lastError != "panic: boom" {
+		t.Errorf("after panic: state %v, last error %q; want open after the panic", state, lastError)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("call after cooldown: got %v, want it tried", err)
+	}
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("call after recovery: got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// campaigns.go - UTM campaign visit counts
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest tag value kept
+const campaignTagMaxLength = 100
+
+// Report periods offered on the campaigns page, in days
+var campaignReportPeriods = []int{7, 30, 90, 365}
+
+// Tags from an inbound link like /?utm_source=acme&utm_medium=application
+type Campaign struct {
+	Source   string
+	Medium   string
+	Campaign string
+}
+
+// Visits from one campaign over the report period
+type CampaignReport struct {
+	Source       string
+	Medium       string
+	Campaign     string
+	Visits       int64
+	LandingPages int
+	TopLanding   string
+	FirstSeen    string // YYYY-MM-DD (UTC)
+	LastSeen     string
+}
+
+// Initialize campaign visit storage
+func initCampaigns() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS campaign_visits (
+		day TEXT NOT NULL,
+		source TEXT NOT NULL,
+		medium TEXT NOT NULL DEFAULT '',
+		campaign TEXT NOT NULL DEFAULT '',
+		landing_path TEXT NOT NULL,
+		visits INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, source, medium, campaign, landing_path)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create campaign_visits table:", err)
+	}
+}
+
+// Normalize a tag value: trimmed, lowercased and capped
+func campaignTag(query url.Values, name string) string {
+	value := strings.ToLower(strings.TrimSpace(query.Get(name)))
+	if len(value) > campaignTagMaxLength {
+		value = value[:campaignTagMaxLength]
+	}
+	return value
+}
+
+// Campaign tags on a request; links without a utm_source aren't campaigns
+func campaignFromQuery(query url.Values) (Campaign, bool) {
+	campaign := Campaign{
+		Source:   campaignTag(query, "utm_source"),
+		Medium:   campaignTag(query, "utm_medium"),
+		Campaign: campaignTag(query, "utm_campaign"),
+	}
+	return campaign, campaign.Source != ""
+}
+
+// Count a tagged visit in the background. Only the tags and landing path
+// are kept; visitor rows and page counters store the path without its query.
+func countCampaignVisit(ctx context.Context, campaign Campaign, path string) {
+	day := time.Now().UTC().Format("2006-01-02")
+	safeGoRetry("campaign-visit", 3, func() error {
+		_, err := dbExec(context.WithoutCancel(ctx), `
+			INSERT INTO campaign_visits (day, source, medium, campaign, landing_path, visits) VALUES (?, ?, ?, ?, ?, 1)
+			ON CONFLICT(day, source, medium, campaign, landing_path) DO UPDATE SET visits = visits + 1
+		`, day, campaign.Source, campaign.Medium, campaign.Campaign, path)
+		if err != nil {
+			log.Printf("Error counting campaign visit: %v", err)
+		}
+		return err
+	})
+}
+
+// Campaigns with visits over the last days, most visits first
+func getCampaignReport(ctx context.Context, days int) ([]CampaignReport, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := dbQuery(ctx, `
+		SELECT source, medium, campaign, SUM(visits), MIN(day), MAX(day), COUNT(DISTINCT landing_path),
+			(SELECT landing_path FROM campaign_visits l
+			 WHERE l.source = c.source AND l.medium = c.medium AND l.campaign = c.campaign AND l.day >= ?
+			 GROUP BY landing_path ORDER BY SUM(visits) DESC LIMIT 1)
+		FROM campaign_visits c WHERE day >= ?
+		GROUP BY source, medium, campaign ORDER BY SUM(visits) DESC LIMIT 200
+	`, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []CampaignReport
+	for rows.Next() {
+		var r CampaignReport
+		err := rows.Scan(&r.Source, &r.Medium, &r.Campaign, &r.Visits, &r.FirstSeen, &r.LastSeen, &r.LandingPages, &r.TopLanding)
+		if err != nil {
+			continue
+		}
+		report = append(report, r)
+	}
+	return report, rows.Err()
+}
+
+// Setup admin campaign report routes
+func setupCampaignAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/campaigns", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		days := 30
+		if requested, err := strconv.Atoi(c.Query("days")); err == nil {
+			for _, period := range campaignReportPeriods {
+				if requested == period {
+					days = period
+				}
+			}
+		}
+
+		report, err := getCampaignReport(ctx, days)
+		if err != nil {
+			log.Printf("Error loading campaign report: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load campaign report",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "admin-campaigns.html", gin.H{
+			"campaigns": report,
+			"days":      days,
+			"periods":   campaignReportPeriods,
+			"siteURL":   siteBaseURL(), // from seo.go
+		})
+	})
+}
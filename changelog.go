@@ -0,0 +1,329 @@
+// changelog.go - Public changelog of the site
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Where /changelog comes from, in the changelog_source setting
+const (
+	changelogSourceSetting = "changelog_source"
+	changelogManual        = "manual" // entries written in the admin
+	changelogGitHub        = "github" // release notes of the repository (from version.go)
+)
+
+const (
+	changelogSyncEvery    = 6 * time.Hour
+	changelogReleaseLimit = 50
+	changelogPageLimit    = 100
+)
+
+// One changelog entry; GitHub entries keep the release id they came from
+type ChangelogEntry struct {
+	ID          int
+	Version     string
+	Title       string
+	Body        string // Markdown source
+	URL         string // release page, for GitHub entries
+	Source      string
+	PublishedAt time.Time
+}
+
+// Rendered body (Markdown, raw HTML escaped)
+func (e ChangelogEntry) HTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(e.Body), &buf); err != nil {
+		log.Printf("Error rendering changelog entry %d: %v", e.ID, err)
+		return template.HTML(template.HTMLEscapeString(e.Body))
+	}
+	return template.HTML(buf.String())
+}
+
+// Public URL of the entry
+func (e ChangelogEntry) Permalink() string {
+	return siteBaseURL() + "/changelog#changelog-" + strconv.Itoa(e.ID) // from seo.go
+}
+
+// Initialize changelog storage and the GitHub release sync
+func initChangelog() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS changelog_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		version TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT 'manual',
+		github_release_id INTEGER UNIQUE,
+		published_at DATETIME NOT NULL
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create changelog_entries table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_changelog_published ON changelog_entries (source, published_at)`)
+
+	startWorker("changelog-sync", changelogSyncWorker) // from safego.go
+}
+
+// Source shown on /changelog
+func changelogSource() string {
+	if getSetting(changelogSourceSetting, changelogManual) == changelogGitHub { // from settings.go
+		return changelogGitHub
+	}
+	return changelogManual
+}
+
+func changelogSyncWorker() {
+	ticker := time.NewTicker(changelogSyncEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		if changelogSource() == changelogGitHub {
+			forEachSite(func(ctx context.Context) { // from sites.go
+				if siteErr := syncChangelogReleases(ctx); siteErr != nil {
+					err = siteErr
+				}
+			})
+		}
+		recordJobRun("changelog-sync", changelogSyncEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Copy published releases into the changelog, updating edited notes.
+// Drafts and releases deleted on GitHub aren't shown.
+func syncChangelogReleases(ctx context.Context) error {
+	var releases []struct {
+		ID          int64     `json:"id"`
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		Body        string    `json:"body"`
+		HTMLURL     string    `json:"html_url"`
+		Draft       bool      `json:"draft"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+	if err := githubGet(ctx, "/releases?per_page="+strconv.Itoa(changelogReleaseLimit), &releases); err != nil { // from version.go
+		log.Printf("Error fetching releases for the changelog: %v", err)
+		return err
+	}
+
+	tx, err := dbFor(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keep := []string{"0"}
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+		title := strings.TrimSpace(r.Name)
+		if title == "" {
+			title = r.TagName
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO changelog_entries (version, title, body, url, source, github_release_id, published_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (github_release_id) DO UPDATE SET
+				version = excluded.version, title = excluded.title, body = excluded.body,
+				url = excluded.url, published_at = excluded.published_at
+		`, r.TagName, title, r.Body, r.HTMLURL, changelogGitHub, r.ID, r.PublishedAt)
+		if err != nil {
+			return err
+		}
+		keep = append(keep, strconv.FormatInt(r.ID, 10))
+	}
+	// Ids are GitHub's integers, so they can go in the statement as they are
+	_, err = tx.ExecContext(ctx, "DELETE FROM changelog_entries WHERE source = ? AND github_release_id NOT IN ("+strings.Join(keep, ",")+")", changelogGitHub)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Entries from a source, newest first
+func getChangelogEntries(ctx context.Context, source string, limit int) ([]ChangelogEntry, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, version, title, body, url, source, published_at FROM changelog_entries
+		WHERE source = ? ORDER BY published_at DESC, id DESC LIMIT ?
+	`, source, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ChangelogEntry
+	for rows.Next() {
+		var e ChangelogEntry
+		if err := rows.Scan(&e.ID, &e.Version, &e.Title, &e.Body, &e.URL, &e.Source, &e.PublishedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Setup the public changelog page and its feed
+func setupChangelogRoutes(r *gin.Engine) {
+	r.GET("/changelog", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getChangelogEntries(ctx, changelogSource(), changelogPageLimit)
+		if err != nil {
+			log.Printf("Error loading changelog: %v", err)
+		}
+		c.HTML(http.StatusOK, "changelog.html", gin.H{
+			"title":   "Changelog",
+			"entries": entries,
+			"seo":     getPageSEO(ctx, "/changelog"),
+		})
+	})
+
+	r.GET("/changelog/feed.xml", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getChangelogEntries(ctx, changelogSource(), feedItemLimit) // from feed.go
+		if err != nil {
+			log.Printf("Error loading changelog for feed: %v", err)
+		}
+		var items []rssItem
+		for _, e := range entries {
+			title := e.Title
+			if e.Version != "" && e.Version != e.Title {
+				title = e.Version + ": " + e.Title
+			}
+			items = append(items, rssItem{
+				Title:       title,
+				Link:        e.Permalink(),
+				GUID:        rssGUID{Value: e.Permalink(), IsPermaLink: true},
+				Description: string(e.HTML()),
+				Category:    "changelog",
+				date:        e.PublishedAt,
+			})
+		}
+		writeFeed(c, rssChannel{
+			Title:       "Zach-Dev Changelog",
+			Link:        siteBaseURL() + "/changelog",
+			Description: "How this site changes over time",
+			Items:       items,
+		})
+	})
+}
+
+// Setup admin changelog routes
+func setupChangelogAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/changelog", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getChangelogEntries(ctx, changelogManual, changelogPageLimit)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load changelog",
+			})
+			return
+		}
+		releases, err := getChangelogEntries(ctx, changelogGitHub, changelogPageLimit)
+		if err != nil {
+			log.Printf("Error loading synced releases: %v", err)
+		}
+		c.HTML(http.StatusOK, "admin-changelog.html", gin.H{
+			"entries":  entries,
+			"releases": releases,
+			"source":   changelogSource(),
+			"repo":     releaseRepo(), // from version.go
+			"primary":  siteFromContext(ctx).Primary(),
+			"message":  c.Query("message"),
+			"today":    time.Now().Format("2006-01-02"),
+		})
+	})
+
+	adminGroup.POST("/changelog", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		title := strings.TrimSpace(c.PostForm("title"))
+		version := strings.TrimSpace(c.PostForm("version"))
+		body := strings.TrimSpace(c.PostForm("body"))
+		publishedAt, err := time.ParseInLocation("2006-01-02", c.PostForm("published_at"), time.Local)
+		if title == "" || err != nil {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A changelog entry needs a title and a date",
+			})
+			return
+		}
+
+		_, err = dbExec(ctx, "INSERT INTO changelog_entries (version, title, body, source, published_at) VALUES (?, ?, ?, ?, ?)",
+			version, title, body, changelogManual, publishedAt)
+		if err != nil {
+			log.Printf("Error saving changelog entry: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save changelog entry",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/changelog?message="+url.QueryEscape("Changelog entry added"))
+	})
+
+	adminGroup.POST("/changelog/:id/delete", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		_, err := dbExec(ctx, "DELETE FROM changelog_entries WHERE id = ? AND source = ?", c.Param("id"), changelogManual)
+		if err != nil {
+			log.Printf("Error deleting changelog entry: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete changelog entry",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/changelog?message="+url.QueryEscape("Changelog entry deleted"))
+	})
+
+	// Like other deployment-wide settings, only the primary admin picks the source
+	adminGroup.POST("/changelog/source", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		source := c.PostForm("source")
+		if source != changelogManual && source != changelogGitHub {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown changelog source",
+			})
+			return
+		}
+		if err := setSetting(ctx, changelogSourceSetting, source); err != nil {
+			log.Printf("Error saving changelog source: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save changelog source",
+			})
+			return
+		}
+
+		message := "The changelog now shows entries written here"
+		if source == changelogGitHub {
+			message = "The changelog now shows GitHub releases"
+			if err := syncChangelogReleases(ctx); err != nil {
+				message += ", but they couldn't be fetched yet: " + err.Error()
+			}
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/changelog?message="+url.QueryEscape(message))
+	})
+
+	adminGroup.POST("/changelog/sync", func(c *gin.Context) {
+		message := "Releases synced from GitHub"
+		if err := syncChangelogReleases(c.Request.Context()); err != nil {
+			message = "Couldn't fetch releases: " + err.Error()
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/changelog?message="+url.QueryEscape(message))
+	})
+}
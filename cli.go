@@ -0,0 +1,395 @@
+// cli.go - Command-line operations
+//
+//	zach-dev setup
+//	zach-dev migrate
+//	zach-dev create-admin -host friend.example.com -name "Friend" -username admin
+//	zach-dev export -table urls [-site friend.example.com] [-o urls.csv]
+//	zach-dev shorten [-site friend.example.com] https://example.com/long/path
+//	zach-dev cleanup
+//
+// Commands use DATABASE_PATH and SITES_DATA_DIR like the server, and don't
+// start its background workers.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Command run from the command line
+type cliCommand struct {
+	usage string
+	run   func(args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"migrate":      {"create or update the database schema", runMigrateCommand},
+	"create-admin": {"create a tenant site's admin, or reset its credentials", runCreateAdminCommand},
+	"export":       {"write a table as CSV", runExportCommand},
+	"shorten":      {"create a short link", runShortenCommand},
+	"cleanup":      {"run the retention, trash and expired secret cleanup jobs once", runCleanupCommand},
+	"setup":        {"configure a new deployment, like the /setup wizard", runSetupCommand},
+}
+
+// Tables never exported: they hold password hashes
+var cliExportExcluded = map[string]bool{"sites": true}
+
+// Run the command named in args, if any; false means start the server
+func runCLI(args []string) bool {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false
+	}
+
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printCLIUsage()
+		os.Exit(2)
+	}
+
+	workersDisabled = true // from safego.go
+	initApp()
+	var err error
+	if state := degradedMode(context.Background()); state != nil { // from degraded.go
+		err = fmt.Errorf("the database can't be written: %s", state.Reason)
+	} else {
+		err = cmd.run(args[1:])
+	}
+	db.Close()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func printCLIUsage() {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: zach-dev [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nWith no command the web server starts. Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, cliCommands[name].usage)
+	}
+}
+
+// Site named by a -site flag; empty means the primary site
+func cliSite(host string) (*Site, error) {
+	host = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(host)), "www.")
+	if host == "" {
+		return primarySite, nil
+	}
+	s := siteForHost(host) // from sites.go
+	if s.Primary() {
+		return nil, fmt.Errorf("no tenant site with host %s", host)
+	}
+	return s, nil
+}
+
+// initApp has already brought the schema up to date by the time this runs
+func runMigrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fmt.Printf("Database %s is up to date (%d tenant sites)\n", databasePath(), len(allSites())-1)
+	return nil
+}
+
+func runCreateAdminCommand(args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	host := fs.String("host", "", "tenant site host, e.g. friend.example.com")
+	name := fs.String("name", "", "site name, for a new site (defaults to the host)")
+	username := fs.String("username", "admin", "admin username")
+	password := fs.String("password", "", "admin password (read from stdin when empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	siteHost := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(*host)), "www.")
+	if siteHost == "" {
+		return errors.New("-host is required; the primary site's admin comes from ADMIN_USERNAME and ADMIN_PASSWORD, or setup")
+	}
+	if !siteHostPattern.MatchString(siteHost) {
+		return fmt.Errorf("%q is not a host name such as friend.example.com", siteHost)
+	}
+	if strings.TrimSpace(*username) == "" {
+		return errors.New("-username can't be empty")
+	}
+
+	pass := *password
+	if pass == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		pass = strings.TrimRight(line, "\r\n")
+	}
+	if len(pass) < 12 {
+		return errors.New("the admin password must be at least 12 characters")
+	}
+
+	ctx := context.Background()
+	if existing := siteForHost(siteHost); !existing.Primary() {
+		passwordHash, err := hashSitePassword(pass)
+		if err != nil {
+			return err
+		}
+		_, err = dbExec(ctx, "UPDATE sites SET admin_username = ?, admin_password_hash = ? WHERE id = ?",
+			strings.TrimSpace(*username), passwordHash, existing.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Updated the admin for %s; restart the server to use the new credentials\n", siteHost)
+		return nil
+	}
+
+	siteName := strings.TrimSpace(*name)
+	if siteName == "" {
+		siteName = siteHost
+	}
+	s, err := createSite(ctx, siteHost, siteName, strings.TrimSpace(*username), pass)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created site %s (%d) with admin %s; restart the server to serve it\n", s.Host, s.ID, s.AdminUsername)
+	return nil
+}
+
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	table := fs.String("table", "", "table to export, e.g. urls")
+	siteHost := fs.String("site", "", "tenant site host (the primary site when empty)")
+	output := fs.String("o", "", "file to write (stdout when empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := cliSite(*siteHost)
+	if err != nil {
+		return err
+	}
+	ctx := withSite(context.Background(), s)
+
+	// Table names can't be bound as parameters, so only known tables are used
+	var exists int
+	dbQueryRow(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", *table).Scan(&exists)
+	if exists == 0 || cliExportExcluded[*table] || strings.HasPrefix(*table, "sqlite_") {
+		return fmt.Errorf("no exportable table named %q", *table)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	rows, err := dbQuery(ctx, fmt.Sprintf("SELECT * FROM %q", *table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(out)
+	w.Write(columns)
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	exported := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		record := make([]string, len(columns))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+			case []byte:
+				record[i] = string(v)
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+		exported++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("Exported %d rows from %s to %s\n", exported, *table, *output)
+	}
+	return nil
+}
+
+func runShortenCommand(args []string) error {
+	fs := flag.NewFlagSet("shorten", flag.ContinueOnError)
+	siteHost := fs.String("site", "", "tenant site host (the primary site when empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: zach-dev shorten [-site host] <url>")
+	}
+	longURL := strings.TrimSpace(fs.Arg(0))
+	if !isPublicLongURL(longURL) { // from main.go
+		return errors.New("URL must be an absolute http(s) URL on a public host")
+	}
+
+	s, err := cliSite(*siteHost)
+	if err != nil {
+		return err
+	}
+	ctx := withSite(context.Background(), s)
+
+	shortCode, err := generateShortCode()
+	if err != nil {
+		return err
+	}
+	if err := saveURL(ctx, shortCode, longURL); err != nil {
+		return err
+	}
+	fmt.Println(siteShortURL(s, shortCode))
+	return nil
+}
+
+// The same jobs the workers run on their own schedules
+func runCleanupCommand(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	archiveOldVisitorData() // from archive.go
+	cleanupEmailLog()       // from maillog.go
+	cleanupEngagement()     // from engagement.go
+
+	var err error
+	forEachSite(func(ctx context.Context) {
+		if siteErr := purgeTrash(ctx); siteErr != nil { // from trash.go
+			err = fmt.Errorf("purging trash for %s: %w", siteFromContext(ctx).Name, siteErr)
+		}
+		if siteErr := purgeExpiredSecrets(ctx); siteErr != nil { // from secrets.go
+			err = fmt.Errorf("purging secrets for %s: %w", siteFromContext(ctx).Name, siteErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The jobs record their outcome for the diagnostics page
+	for _, job := range []string{"visitor-cleanup", "email-log-cleanup", "engagement-cleanup"} {
+		jobStatusMu.Lock() // from diagnostics.go
+		status := jobStatuses[job]
+		jobStatusMu.Unlock()
+		if status != nil && status.LastErr != "" {
+			return fmt.Errorf("%s: %s", job, status.LastErr)
+		}
+	}
+	fmt.Println("Cleanup finished")
+	return nil
+}
+
+// Ask for a value on stderr, reading the answer from stdin; an empty answer
+// keeps the fallback
+func cliPrompt(in *bufio.Reader, label, fallback string) (string, error) {
+	if fallback != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, fallback)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return fallback, nil
+}
+
+// The /setup wizard's questions, asked on the terminal (from setup.go)
+func runSetupCommand(args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !setupNeeded() {
+		return errors.New("this deployment is already set up")
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	smtp := loadSMTPSettings()
+	cfg := SetupConfig{AnalyticsMode: analyticsMode()}
+	questions := []struct {
+		label, fallback string
+		value           *string
+	}{
+		{"Base URL", defaultSiteBaseURL, &cfg.BaseURL},
+		{"Admin username", "admin", &cfg.AdminUsername},
+		{"Admin password (12+ characters)", "", &cfg.AdminPassword},
+		{"SMTP host", smtp.Host, &cfg.SMTPHost},
+		{"SMTP port", smtp.Port, &cfg.SMTPPort},
+		{"SMTP username (empty to skip email)", smtp.User, &cfg.SMTPUser},
+		{"SMTP password", "", &cfg.SMTPPass},
+		{"Send notifications to", envOrSetting("TO_EMAIL", smtpToSetting), &cfg.ToEmail},
+		{"Analytics mode (standard, country or strict)", cfg.AnalyticsMode, &cfg.AnalyticsMode},
+	}
+	for _, q := range questions {
+		if q.value == &cfg.SMTPPass && cfg.SMTPUser == "" {
+			continue
+		}
+		answer, err := cliPrompt(in, q.label, q.fallback)
+		if err != nil {
+			return err
+		}
+		*q.value = answer
+	}
+	engagement, err := cliPrompt(in, "Engagement tracking (y/n)", "n")
+	if err != nil {
+		return err
+	}
+	cfg.Engagement = strings.HasPrefix(strings.ToLower(engagement), "y")
+
+	if problem := cfg.normalize(); problem != "" {
+		return errors.New(problem)
+	}
+	if err := applySetup(context.Background(), cfg); err != nil {
+		return err
+	}
+	fmt.Printf("Setup complete; sign in at %s/admin/login as %s\n", cfg.BaseURL, cfg.AdminUsername)
+	return nil
+}
@@ -0,0 +1,318 @@
+// clickevents.go - Short link click events and daily aggregates
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Raw events are kept this long after their day is compacted, for
+// drill-downs; the daily aggregates are kept for good
+const clickEventRetentionDays = 90
+
+const (
+	clickCompactionEvery = time.Hour
+	clickCompactionDelay = time.Hour // a day is compacted this long after it ends, so clicks still being saved make it in
+)
+
+// Initialize click storage and the compaction job. urls.clicks stays as a
+// cache of each link's total: clicks_baseline holds what it counted before
+// events existed, taken the first time a link is clicked with events on.
+func initClickEvents() {
+	createEvents := `
+	CREATE TABLE IF NOT EXISTS click_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		short_code TEXT NOT NULL,
+		clicked_at TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT '',
+		referrer_domain TEXT NOT NULL DEFAULT '',
+		device TEXT NOT NULL DEFAULT ''
+	)`
+	createDaily := `
+	CREATE TABLE IF NOT EXISTS click_daily (
+		day TEXT NOT NULL,
+		short_code TEXT NOT NULL,
+		country TEXT NOT NULL,
+		referrer_domain TEXT NOT NULL,
+		device TEXT NOT NULL,
+		clicks INTEGER NOT NULL,
+		PRIMARY KEY (day, short_code, country, referrer_domain, device)
+	)`
+
+	for _, createTable := range []string{createEvents, createDaily} {
+		if _, err := db.Exec(createTable); err != nil {
+			log.Fatal("Failed to create click tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_click_events_code ON click_events (short_code, clicked_at)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_click_events_time ON click_events (clicked_at)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_click_daily_code ON click_daily (short_code, day)`)
+	db.Exec(`ALTER TABLE urls ADD COLUMN clicks_baseline INTEGER`) // Ignore error if column already exists
+
+	startWorker("click-compaction", clickCompactionWorker) // from safego.go
+}
+
+// Record a click on a short link, with where it came from, in the
+// background; the urls.clicks cache is bumped in the same transaction
+func recordClick(c *gin.Context, shortCode string) {
+	ctx := context.WithoutCancel(c.Request.Context())
+	clickedAt := time.Now().UTC().Format(sqliteTimestamp) // from security.go
+	country := requestCountry(c)                          // from linkacl.go
+	referrer := referrerDomain(c.Request.Referer())
+	device := deviceClass(c.GetHeader("User-Agent")) // from linkdevice.go
+
+	safeGoRetry("url-click", 3, func() error {
+		tx, err := dbFor(ctx).BeginTx(ctx, nil) // from sites.go
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO click_events (short_code, clicked_at, country, referrer_domain, device) VALUES (?, ?, ?, ?, ?)",
+			shortCode, clickedAt, country, referrer, device)
+		if err == nil {
+			// SET sees the row as it was, so the baseline excludes this click
+			_, err = tx.ExecContext(ctx, `
+				UPDATE urls SET clicks_baseline = COALESCE(clicks_baseline, COALESCE(clicks, 0)), clicks = COALESCE(clicks, 0) + 1
+				WHERE short_code = ?`, shortCode)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Error recording click: %v", err)
+		}
+		return err
+	})
+}
+
+// Host a click came from, without "www.", or "" for direct visits
+func referrerDomain(referrer string) string {
+	ref, err := url.Parse(referrer)
+	if err != nil || ref.Hostname() == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+}
+
+func clickCompactionWorker() {
+	ticker := time.NewTicker(clickCompactionEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := compactClickEvents(ctx, time.Now()); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("click-compaction", clickCompactionEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Last day folded into click_daily, "" before the first compaction. Events
+// after it are still only in click_events.
+func lastCompactedClickDay(ctx context.Context) (string, error) {
+	var day string
+	err := dbQueryRow(ctx, "SELECT COALESCE(MAX(day), '') FROM click_daily").Scan(&day)
+	return day, err
+}
+
+// Fold every finished day not yet compacted into click_daily, a day per
+// transaction, then drop raw events past retention and refresh the
+// urls.clicks cache from the result
+func compactClickEvents(ctx context.Context, now time.Time) error {
+	last, err := lastCompactedClickDay(ctx)
+	if err != nil {
+		return err
+	}
+	today := now.UTC().Add(-clickCompactionDelay).Format("2006-01-02")
+
+	rows, err := dbQuery(ctx, `
+		SELECT DISTINCT substr(clicked_at, 1, 10) FROM click_events
+		WHERE clicked_at >= ? AND clicked_at < ?
+		ORDER BY 1
+	`, nextDayStart(last), today)
+	if err != nil {
+		return err
+	}
+	var days []string
+	for rows.Next() {
+		var day string
+		if rows.Scan(&day) == nil {
+			days = append(days, day)
+		}
+	}
+	rows.Close()
+
+	for _, day := range days {
+		_, err := dbExec(ctx, `
+			INSERT INTO click_daily (day, short_code, country, referrer_domain, device, clicks)
+			SELECT ?, short_code, country, referrer_domain, device, COUNT(*) FROM click_events
+			WHERE clicked_at >= ? AND clicked_at < ?
+			GROUP BY short_code, country, referrer_domain, device
+			ON CONFLICT DO UPDATE SET clicks = excluded.clicks
+		`, day, day, nextDayStart(day))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Past retention, but never events whose day isn't compacted
+	if last, err = lastCompactedClickDay(ctx); err != nil {
+		return err
+	}
+	cutoff := min(now.UTC().AddDate(0, 0, -clickEventRetentionDays).Format("2006-01-02"), nextDayStart(last))
+	result, err := dbExec(ctx, "DELETE FROM click_events WHERE clicked_at < ?", cutoff)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 || len(days) > 0 {
+		log.Printf("Click compaction: %d days compacted, %d old events removed", len(days), n)
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	return refreshClickCounts(ctx, last)
+}
+
+// First moment after a day, as stored in clicked_at; "" sorts before all
+func nextDayStart(day string) string {
+	if day == "" {
+		return ""
+	}
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// Rebuild urls.clicks from the baseline, the daily aggregates and events
+// not compacted yet, for links clicked since events began
+func refreshClickCounts(ctx context.Context, lastCompacted string) error {
+	_, err := dbExec(ctx, `
+		UPDATE urls SET clicks = clicks_baseline
+			+ (SELECT COALESCE(SUM(clicks), 0) FROM click_daily WHERE click_daily.short_code = urls.short_code)
+			+ (SELECT COUNT(*) FROM click_events WHERE click_events.short_code = urls.short_code AND clicked_at >= ?)
+		WHERE clicks_baseline IS NOT NULL
+	`, nextDayStart(lastCompacted))
+	return err
+}
+
+// One value of a click breakdown, e.g. a country and its clicks
+type ClickCount struct {
+	Key    string
+	Clicks int
+}
+
+// A link's clicks over recent days: per day (oldest first, days without
+// clicks included) and by country, referrer and device (most first)
+type ClickBreakdown struct {
+	Days      int
+	Total     int
+	PerDay    []ClickCount
+	Countries []ClickCount
+	Referrers []ClickCount
+	Devices   []ClickCount
+}
+
+func getClickBreakdown(ctx context.Context, shortCode string, days int) (*ClickBreakdown, error) {
+	last, err := lastCompactedClickDay(ctx)
+	if err != nil {
+		return nil, err
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	rawFrom := max(since, nextDayStart(last))
+
+	rows, err := dbQuery(ctx, `
+		SELECT day, country, referrer_domain, device, clicks FROM click_daily
+		WHERE short_code = ? AND day >= ?
+		UNION ALL
+		SELECT substr(clicked_at, 1, 10), country, referrer_domain, device, 1 FROM click_events
+		WHERE short_code = ? AND clicked_at >= ?
+	`, shortCode, since, shortCode, rawFrom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perDay := map[string]int{}
+	countries, referrers, devices := map[string]int{}, map[string]int{}, map[string]int{}
+	b := &ClickBreakdown{Days: days}
+	for rows.Next() {
+		var day, country, referrer, device string
+		var clicks int
+		if err := rows.Scan(&day, &country, &referrer, &device, &clicks); err != nil {
+			return nil, err
+		}
+		b.Total += clicks
+		perDay[day] += clicks
+		countries[orUnknown(country)] += clicks
+		referrers[orDirect(referrer)] += clicks
+		devices[device] += clicks
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	start, _ := time.Parse("2006-01-02", since)
+	for i := 0; i < days; i++ {
+		day := start.AddDate(0, 0, i).Format("2006-01-02")
+		b.PerDay = append(b.PerDay, ClickCount{day, perDay[day]})
+	}
+	b.Countries, b.Referrers, b.Devices = topClickCounts(countries), topClickCounts(referrers), topClickCounts(devices)
+	return b, nil
+}
+
+// Height of a day's bar in the chart, as a percentage of the busiest day
+func (b *ClickBreakdown) BarHeight(clicks int) int {
+	peak := 0
+	for _, day := range b.PerDay {
+		peak = max(peak, day.Clicks)
+	}
+	if peak == 0 {
+		return 0
+	}
+	return clicks * 100 / peak
+}
+
+func orUnknown(country string) string {
+	if country == "" {
+		return "unknown"
+	}
+	return country
+}
+
+func orDirect(referrer string) string {
+	if referrer == "" {
+		return "direct"
+	}
+	return referrer
+}
+
+// Counts sorted most first, at most ten
+func topClickCounts(counts map[string]int) []ClickCount {
+	var list []ClickCount
+	for key, clicks := range counts {
+		list = append(list, ClickCount{key, clicks})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Clicks != list[j].Clicks {
+			return list[i].Clicks > list[j].Clicks
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list[:min(len(list), 10)]
+}
@@ -0,0 +1,556 @@
+// clientportal.go - Private portal for freelance clients
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sign-in links are good for this long, and only once
+const clientLoginLinkLifetime = 15 * time.Minute
+
+// Client sessions last this long; they're separate from admin sessions
+const clientSessionLifetime = 30 * 24 * time.Hour
+
+const clientSessionCookie = "client_session"
+
+// Largest file that can be shared with a client
+const clientFileMaxBytes = 25 << 20
+
+// Sign-in emails each visitor may request per minute
+const clientLoginRateLimit = 3
+
+var clientLoginLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// A client with access to the portal
+type Client struct {
+	ID          int
+	Email       string
+	Name        string
+	LastLoginAt sql.NullTime
+	CreatedAt   time.Time
+	Files       []ClientFile
+	Notes       []ClientNote
+}
+
+// A file shared with a client
+type ClientFile struct {
+	ID        int
+	Filename  string
+	Size      int
+	CreatedAt time.Time
+}
+
+// Size for display, like "1.2 MB"
+func (f ClientFile) SizeText() string {
+	switch {
+	case f.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(f.Size)/(1<<20))
+	case f.Size >= 1<<10:
+		return fmt.Sprintf("%d KB", f.Size/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", f.Size)
+}
+
+// A project status update for a client
+type ClientNote struct {
+	ID        int
+	Project   string
+	Body      string
+	CreatedAt time.Time
+}
+
+// Initialize client portal storage
+func initClientPortal() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS clients (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		name TEXT NOT NULL,
+		login_generation INTEGER NOT NULL DEFAULT 0,
+		last_login_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS client_sessions (
+		token_hash TEXT PRIMARY KEY,
+		client_id INTEGER NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`, `
+	CREATE TABLE IF NOT EXISTS client_files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_id INTEGER NOT NULL,
+		filename TEXT NOT NULL,
+		data BLOB NOT NULL,
+		size INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS client_notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_id INTEGER NOT NULL,
+		project TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create client portal tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_client_files_client ON client_files (client_id)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_client_notes_client ON client_notes (client_id)`)
+}
+
+func getClients(ctx context.Context) ([]Client, error) {
+	rows, err := dbQuery(ctx, `SELECT id, email, name, last_login_at, created_at FROM clients ORDER BY name COLLATE NOCASE, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		var cl Client
+		if err := rows.Scan(&cl.ID, &cl.Email, &cl.Name, &cl.LastLoginAt, &cl.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, cl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if err := loadClientDetails(ctx, &clients[i]); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+func getClient(ctx context.Context, id int) (Client, error) {
+	var cl Client
+	err := dbQueryRow(ctx, `SELECT id, email, name, last_login_at, created_at FROM clients WHERE id = ?`, id).
+		Scan(&cl.ID, &cl.Email, &cl.Name, &cl.LastLoginAt, &cl.CreatedAt)
+	if err != nil {
+		return cl, err
+	}
+	return cl, loadClientDetails(ctx, &cl)
+}
+
+// Fill in a client's files and notes, newest first
+func loadClientDetails(ctx context.Context, cl *Client) error {
+	rows, err := dbQuery(ctx, `SELECT id, filename, size, created_at FROM client_files WHERE client_id = ? ORDER BY id DESC`, cl.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ClientFile
+		if err := rows.Scan(&f.ID, &f.Filename, &f.Size, &f.CreatedAt); err != nil {
+			return err
+		}
+		cl.Files = append(cl.Files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	notes, err := dbQuery(ctx, `SELECT id, project, body, created_at FROM client_notes WHERE client_id = ? ORDER BY id DESC`, cl.ID)
+	if err != nil {
+		return err
+	}
+	defer notes.Close()
+	for notes.Next() {
+		var n ClientNote
+		if err := notes.Scan(&n.ID, &n.Project, &n.Body, &n.CreatedAt); err != nil {
+			return err
+		}
+		cl.Notes = append(cl.Notes, n)
+	}
+	return notes.Err()
+}
+
+// A client's invoices, matched on their email address, with their links
+func getClientInvoices(c *gin.Context, cl Client) ([]Invoice, error) {
+	ctx := c.Request.Context()
+	invoices, err := getInvoices(ctx) // from invoices.go
+	if err != nil {
+		return nil, err
+	}
+	var theirs []Invoice
+	for _, inv := range invoices {
+		if !strings.EqualFold(inv.ClientEmail, cl.Email) || !time.Now().Before(inv.LinkExpiresAt) {
+			continue
+		}
+		token := invoiceLinkToken(ctx, inv.ID, inv.LinkExpiresAt)
+		inv.URL = buildInvoiceURL(c, token)
+		inv.PayURL = invoicePayURL(c, inv, token) // from stripe.go
+		theirs = append(theirs, inv)
+	}
+	return theirs, nil
+}
+
+// Sign-in link token: the client id and expiry, signed together with the
+// client's login generation, which each sign-in bumps so a link works once
+func clientLoginToken(ctx context.Context, clientID, generation int) string {
+	payload := fmt.Sprintf("%d.%d", clientID, time.Now().Add(clientLoginLinkLifetime).Unix())
+	return payload + "." + signedLinkMAC(ctx, clientLoginPrefix(generation), payload) // from sharelinks.go
+}
+
+func clientLoginPrefix(generation int) string {
+	return "client-login:" + strconv.Itoa(generation) + ":"
+}
+
+// Use up a sign-in link, returning the client it's for
+func redeemClientLoginToken(ctx context.Context, token string) (int, bool) {
+	idText, _, _ := strings.Cut(token, ".")
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return 0, false
+	}
+	var generation int
+	if err := dbQueryRow(ctx, "SELECT login_generation FROM clients WHERE id = ?", id).Scan(&generation); err != nil {
+		return 0, false
+	}
+	if parsed, ok := parseSignedLinkToken(ctx, clientLoginPrefix(generation), token); !ok || parsed != id {
+		return 0, false
+	}
+	// Only one of two simultaneous redemptions bumps the generation
+	result, err := dbExec(ctx, "UPDATE clients SET login_generation = login_generation + 1, last_login_at = ? WHERE id = ? AND login_generation = ?",
+		time.Now(), id, generation)
+	if err != nil {
+		log.Printf("Error redeeming client sign-in link: %v", err)
+		return 0, false
+	}
+	n, _ := result.RowsAffected()
+	return id, n == 1
+}
+
+// Start a portal session for a client and set its cookie
+func startClientSession(c *gin.Context, clientID int) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	ctx := c.Request.Context()
+	// Expired sessions are only cleared here; there are never many
+	dbExec(ctx, "DELETE FROM client_sessions WHERE expires_at <= ?", time.Now())
+	_, err := dbExec(ctx, "INSERT INTO client_sessions (token_hash, client_id, expires_at) VALUES (?, ?, ?)",
+		hashAPIToken(token), clientID, time.Now().Add(clientSessionLifetime)) // from api.go
+	if err != nil {
+		return err
+	}
+	c.SetCookie(clientSessionCookie, token, int(clientSessionLifetime.Seconds()), "/client", "", gin.Mode() == gin.ReleaseMode, true)
+	return nil
+}
+
+// The signed-in client, if the session cookie is genuine and current
+func clientFromSession(c *gin.Context) (Client, bool) {
+	token, err := c.Cookie(clientSessionCookie)
+	if err != nil || token == "" {
+		return Client{}, false
+	}
+	var clientID int
+	err = dbQueryRow(c.Request.Context(), "SELECT client_id FROM client_sessions WHERE token_hash = ? AND expires_at > ?",
+		hashAPIToken(token), time.Now()).Scan(&clientID)
+	if err != nil {
+		return Client{}, false
+	}
+	cl, err := getClient(c.Request.Context(), clientID)
+	return cl, err == nil
+}
+
+// Middleware for portal pages; nothing here accepts an admin session
+func clientAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, ok := clientFromSession(c)
+		if !ok {
+			c.Redirect(http.StatusFound, "/client/login")
+			c.Abort()
+			return
+		}
+		c.Set("client", cl)
+		c.Next()
+	}
+}
+
+// Email a sign-in link, if the address belongs to a client
+func sendClientLoginLink(c *gin.Context, email string) error {
+	ctx := c.Request.Context()
+	var id, generation int
+	var name string
+	err := dbQueryRow(ctx, "SELECT id, name, login_generation FROM clients WHERE email = ?", email).Scan(&id, &name, &generation)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/client/auth/" + clientLoginToken(ctx, id, generation) // from main.go
+	body := fmt.Sprintf("Hi %s,\n\nHere's your link to sign in to the client area:\n\n%s\n\n"+
+		"It works once, for the next %d minutes. If you didn't ask for it, you can ignore this email.\n",
+		name, link, int(clientLoginLinkLifetime.Minutes()))
+	return sendLoggedEmail(ctx, emailKindClientLogin, email, "Your sign-in link", loadSMTPSettings().To, body) // from maillog.go
+}
+
+// Setup the client portal: sign-in by email link, then the client's page
+// with their invoices, files and project notes
+func setupClientPortalRoutes(r *gin.Engine) {
+	noindex := func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+	}
+	client := r.Group("/client", noindex)
+
+	client.GET("/login", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "client-login.html", gin.H{"sent": c.Query("sent") == "1"})
+	})
+
+	client.POST("/login", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		if !clientLoginLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, clientLoginRateLimit) {
+			c.Header("Retry-After", "60")
+			c.HTML(http.StatusTooManyRequests, "client-login.html", gin.H{
+				"problem": "Too many requests; please try again in a minute.",
+			})
+			return
+		}
+		email := strings.TrimSpace(c.PostForm("email"))
+		if !isValidEmail(email) { // from main.go
+			c.HTML(http.StatusBadRequest, "client-login.html", gin.H{
+				"problem": "Please enter a valid email address.",
+			})
+			return
+		}
+		// The reply is the same whether or not the address is a client's
+		if err := sendClientLoginLink(c, email); err != nil {
+			log.Printf("Error sending client sign-in link: %v", err)
+		}
+		c.Redirect(http.StatusSeeOther, "/client/login?sent=1")
+	})
+
+	// Opening the link only shows a button; signing in takes a POST, so
+	// mail scanners that follow links don't use it up
+	client.GET("/auth/:token", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "client-login.html", gin.H{"token": c.Param("token")})
+	})
+
+	client.POST("/auth/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id, ok := redeemClientLoginToken(ctx, c.Param("token"))
+		if !ok {
+			ipHash := hashIP(c.ClientIP())
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "client sign-in link", ipHash, time.Minute) // from security.go
+			c.HTML(http.StatusGone, "client-login.html", gin.H{
+				"problem": "That sign-in link has expired or was already used. Enter your email for a new one.",
+			})
+			return
+		}
+		if err := startClientSession(c, id); err != nil {
+			log.Printf("Error starting client session: %v", err)
+			c.HTML(http.StatusInternalServerError, "client-login.html", gin.H{
+				"problem": "Sorry, signing in failed. Please try again later.",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/client")
+	})
+
+	client.POST("/logout", func(c *gin.Context) {
+		if token, err := c.Cookie(clientSessionCookie); err == nil {
+			if _, err := dbExec(c.Request.Context(), "DELETE FROM client_sessions WHERE token_hash = ?", hashAPIToken(token)); err != nil {
+				log.Printf("Error ending client session: %v", err)
+			}
+		}
+		c.SetCookie(clientSessionCookie, "", -1, "/client", "", gin.Mode() == gin.ReleaseMode, true)
+		c.Redirect(http.StatusSeeOther, "/client/login")
+	})
+
+	portal := client.Group("", clientAuthMiddleware())
+
+	portal.GET("", func(c *gin.Context) {
+		cl := c.MustGet("client").(Client)
+		invoices, err := getClientInvoices(c, cl)
+		if err != nil {
+			log.Printf("Error loading invoices for client %d: %v", cl.ID, err)
+		}
+		c.HTML(http.StatusOK, "client-portal.html", gin.H{
+			"client":   cl,
+			"invoices": invoices,
+		})
+	})
+
+	portal.GET("/files/:id", func(c *gin.Context) {
+		cl := c.MustGet("client").(Client)
+		var filename string
+		var data []byte
+		err := dbQueryRow(c.Request.Context(), "SELECT filename, data FROM client_files WHERE id = ? AND client_id = ?",
+			c.Param("id"), cl.ID).Scan(&filename, &data)
+		if err != nil {
+			c.String(http.StatusNotFound, "File not found")
+			return
+		}
+		serveDownload(c, filename, data) // from uploads.go
+	})
+}
+
+// Setup admin client management: who can sign in, and what they see
+func setupClientPortalAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminError := func(c *gin.Context, status int, message string) {
+		c.HTML(status, "admin-error.html", gin.H{"error": message})
+	}
+	done := func(c *gin.Context, message, anchor string) {
+		c.Redirect(http.StatusSeeOther, "/admin/clients?message="+url.QueryEscape(message)+anchor)
+	}
+
+	adminGroup.GET("/clients", func(c *gin.Context) {
+		clients, err := getClients(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading clients: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to load clients")
+			return
+		}
+		c.HTML(http.StatusOK, "admin-clients.html", gin.H{
+			"clients":  clients,
+			"loginURL": strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/client/login",
+			"message":  c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/clients", func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("name"))
+		email := strings.TrimSpace(c.PostForm("email"))
+		if name == "" || !isValidEmail(email) {
+			adminError(c, http.StatusBadRequest, "A client needs a name and a valid email address")
+			return
+		}
+		result, err := dbExec(c.Request.Context(), "INSERT INTO clients (email, name) VALUES (?, ?)", email, name)
+		if err != nil {
+			log.Printf("Error saving client: %v", err)
+			adminError(c, http.StatusBadRequest, "Failed to add the client; is the email address already a client's?")
+			return
+		}
+		id, _ := result.LastInsertId()
+		done(c, name+" can now sign in", "#client-"+strconv.FormatInt(id, 10))
+	})
+
+	adminGroup.POST("/clients/:id/delete", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		tx, cancel, err := dbBegin(ctx) // from dbctx.go
+		if err == nil {
+			defer cancel()
+			defer tx.Rollback()
+			for _, stmt := range []string{
+				"DELETE FROM client_sessions WHERE client_id = ?",
+				"DELETE FROM client_files WHERE client_id = ?",
+				"DELETE FROM client_notes WHERE client_id = ?",
+				"DELETE FROM clients WHERE id = ?",
+			} {
+				if _, err = tx.ExecContext(ctx, stmt, c.Param("id")); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+		}
+		if err != nil {
+			log.Printf("Error deleting client: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to delete client")
+			return
+		}
+		done(c, "Client deleted", "")
+	})
+
+	// Sign the client out everywhere, and void any link already sent
+	adminGroup.POST("/clients/:id/sessions/delete", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		_, err := dbExec(ctx, "DELETE FROM client_sessions WHERE client_id = ?", c.Param("id"))
+		if err == nil {
+			_, err = dbExec(ctx, "UPDATE clients SET login_generation = login_generation + 1 WHERE id = ?", c.Param("id"))
+		}
+		if err != nil {
+			log.Printf("Error signing client out: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to sign the client out")
+			return
+		}
+		done(c, "Client signed out everywhere", "#client-"+c.Param("id"))
+	})
+
+	adminGroup.POST("/clients/:id/files", func(c *gin.Context) {
+		data, filename, problem := uploadFromForm(c, "file", clientFileMaxBytes) // from uploads.go
+		if problem != "" {
+			adminError(c, http.StatusBadRequest, problem)
+			return
+		}
+		_, err := dbExec(c.Request.Context(), "INSERT INTO client_files (client_id, filename, data, size) SELECT id, ?, ?, ? FROM clients WHERE id = ?",
+			filename, data, len(data), c.Param("id"))
+		if err != nil {
+			log.Printf("Error saving client file: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to save the file")
+			return
+		}
+		done(c, filename+" shared", "#client-"+c.Param("id"))
+	})
+
+	adminGroup.GET("/clients/files/:id", func(c *gin.Context) {
+		var filename string
+		var data []byte
+		err := dbQueryRow(c.Request.Context(), "SELECT filename, data FROM client_files WHERE id = ?", c.Param("id")).Scan(&filename, &data)
+		if err != nil {
+			adminError(c, http.StatusNotFound, "File not found")
+			return
+		}
+		serveDownload(c, filename, data) // from uploads.go
+	})
+
+	adminGroup.POST("/clients/files/:id/delete", func(c *gin.Context) {
+		if _, err := dbExec(c.Request.Context(), "DELETE FROM client_files WHERE id = ?", c.Param("id")); err != nil {
+			log.Printf("Error deleting client file: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to delete the file")
+			return
+		}
+		done(c, "File deleted", "")
+	})
+
+	adminGroup.POST("/clients/:id/notes", func(c *gin.Context) {
+		project := strings.TrimSpace(c.PostForm("project"))
+		body := strings.TrimSpace(c.PostForm("body"))
+		if project == "" || body == "" {
+			adminError(c, http.StatusBadRequest, "A status note needs a project and some text")
+			return
+		}
+		_, err := dbExec(c.Request.Context(), "INSERT INTO client_notes (client_id, project, body) SELECT id, ?, ? FROM clients WHERE id = ?",
+			project, body, c.Param("id"))
+		if err != nil {
+			log.Printf("Error saving client note: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to save the note")
+			return
+		}
+		done(c, "Status note posted", "#client-"+c.Param("id"))
+	})
+
+	adminGroup.POST("/clients/notes/:id/delete", func(c *gin.Context) {
+		if _, err := dbExec(c.Request.Context(), "DELETE FROM client_notes WHERE id = ?", c.Param("id")); err != nil {
+			log.Printf("Error deleting client note: %v", err)
+			adminError(c, http.StatusInternalServerError, "Failed to delete the note")
+			return
+		}
+		done(c, "Note deleted", "")
+	})
+}
@@ -0,0 +1,62 @@
+// conditional.go - Conditional GET for JSON endpoints and feeds
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versions remembered before the list is started over
+const bodyVersionLimit = 1000
+
+// When each response body, by ETag, was first served. Bodies are built from
+// several tables, so this stands in for a modification time: it is when
+// the content last changed, or when the server started if it hasn't since.
+var (
+	bodyVersionsMu sync.Mutex
+	bodyVersions   = make(map[string]time.Time)
+)
+
+// First time a body version was served, to the second as HTTP dates are
+func bodyVersionSeenAt(etag string) time.Time {
+	bodyVersionsMu.Lock()
+	defer bodyVersionsMu.Unlock()
+
+	if seen, ok := bodyVersions[etag]; ok {
+		return seen
+	}
+	if len(bodyVersions) >= bodyVersionLimit {
+		clear(bodyVersions)
+	}
+	seen := time.Now().UTC().Truncate(time.Second)
+	bodyVersions[etag] = seen
+	return seen
+}
+
+// Write a body with ETag, Last-Modified and cache headers, answering 304
+// when the client's copy is current. If-None-Match wins over
+// If-Modified-Since when both are sent, as RFC 9110 asks.
+func writeConditional(c *gin.Context, contentType string, body []byte, cacheControl string) {
+	etag := contentETag(body) // from contentapi.go
+	lastModified := bodyVersionSeenAt(etag)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Header("Cache-Control", cacheControl)
+
+	if notModified(c, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	return err == nil && !lastModified.After(since)
+}
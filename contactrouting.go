@@ -0,0 +1,184 @@
+// contactrouting.go - Contact form inquiry types and routing
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What a visitor is getting in touch about, picked on the contact form
+type InquiryType struct {
+	Name  string
+	Label string
+}
+
+var inquiryTypes = []InquiryType{
+	{"hiring", "Hiring"},
+	{"freelance", "Freelance project"},
+	{"other", "Something else"},
+}
+
+// Inquiry type used when the form doesn't say
+const defaultInquiryType = "other"
+
+// The inquiry type named, or the default for anything unknown
+func inquiryTypeFor(name string) InquiryType {
+	for _, t := range inquiryTypes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return inquiryTypeFor(defaultInquiryType)
+}
+
+// Auto-replies each client may trigger per minute, so the form can't be
+// used to send mail to strangers in bulk
+const contactAutoReplyRateLimit = 3
+
+var contactAutoReplyLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+var contactWebhookClient = newTrustedHTTPClient("contact notifications", 10*time.Second) // from httpclient.go; the owner sets the webhooks
+
+// Where one inquiry type's messages are announced, and what the sender
+// hears back. Empty fields fall back to the owner's address, no webhook
+// and no auto-reply.
+type ContactRoute struct {
+	InquiryType
+	Email     string
+	Webhook   string // Slack- or Discord-style incoming webhook URL
+	AutoReply string
+}
+
+// Settings keys for a route, like "contact_route_hiring_email"
+func contactRouteSetting(inquiry, field string) string {
+	return "contact_route_" + inquiry + "_" + field
+}
+
+func getContactRoute(inquiry string) ContactRoute {
+	t := inquiryTypeFor(inquiry)
+	return ContactRoute{
+		InquiryType: t,
+		Email:       getSetting(contactRouteSetting(t.Name, "email"), ""), // from settings.go
+		Webhook:     getSetting(contactRouteSetting(t.Name, "webhook"), ""),
+		AutoReply:   getSetting(contactRouteSetting(t.Name, "auto_reply"), ""),
+	}
+}
+
+func getContactRoutes() []ContactRoute {
+	routes := make([]ContactRoute, len(inquiryTypes))
+	for i, t := range inquiryTypes {
+		routes[i] = getContactRoute(t.Name)
+	}
+	return routes
+}
+
+// Announce a contact form message where its inquiry type goes, and send
+// the type's auto-reply. The error is the email notification's; the
+// webhook and the auto-reply are extras.
+func routeContactMessage(ctx context.Context, threadID int64, inquiry, name, email, message, ipHash string) error {
+	route := getContactRoute(inquiry)
+
+	if route.Webhook != "" {
+		text := fmt.Sprintf("New %s inquiry from %s (%s):\n%s", strings.ToLower(route.Label), name, email, message)
+		safeGo("contact-webhook", func() { // from safego.go
+			if err := postContactWebhook(context.WithoutCancel(ctx), route.Webhook, text); err != nil {
+				log.Printf("Error posting contact message to the %s webhook: %v", route.Name, err)
+			}
+		})
+	}
+
+	if route.AutoReply != "" {
+		if contactAutoReplyLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, contactAutoReplyRateLimit) {
+			err := sendLoggedEmail(ctx, emailKindAutoReply, email, "Thanks for getting in touch", loadSMTPSettings().To, route.AutoReply) // from maillog.go
+			if err != nil {
+				log.Printf("Error sending contact auto-reply: %v", err)
+			}
+		} else {
+			log.Printf("Contact auto-reply skipped for %s: rate limited", ipHash)
+		}
+	}
+
+	return sendContactEmail(ctx, threadID, route, name, email, message) // from main.go
+}
+
+// Post a message to an incoming webhook. Slack reads "text" and Discord
+// "content", so both are sent.
+func postContactWebhook(ctx context.Context, webhook, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text, "content": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := contactWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// Setup the admin form for each inquiry type's route
+func setupContactRoutingAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Like the SMTP settings, routes belong to the primary site
+	adminGroup.POST("/messages/routes/:type", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		t := inquiryTypeFor(c.Param("type"))
+		if t.Name != c.Param("type") {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Unknown inquiry type",
+			})
+			return
+		}
+
+		route := ContactRoute{
+			InquiryType: t,
+			Email:       strings.TrimSpace(c.PostForm("email")),
+			Webhook:     strings.TrimSpace(c.PostForm("webhook")),
+			AutoReply:   strings.TrimSpace(c.PostForm("auto_reply")),
+		}
+		if route.Email != "" && !isValidEmail(route.Email) { // from main.go
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Please enter a valid notification address, or leave it empty",
+			})
+			return
+		}
+		if route.Webhook != "" && !strings.HasPrefix(route.Webhook, "https://") {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "The webhook URL must start with https://",
+			})
+			return
+		}
+
+		for field, value := range map[string]string{"email": route.Email, "webhook": route.Webhook, "auto_reply": route.AutoReply} {
+			if err := setSetting(ctx, contactRouteSetting(t.Name, field), value); err != nil {
+				log.Printf("Error saving contact route: %v", err)
+				c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+					"error": "Failed to save routing for " + t.Label,
+				})
+				return
+			}
+		}
+
+		log.Printf("Contact routing for %s changed by admin from %s", t.Name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/messages?message="+url.QueryEscape("Routing for "+t.Label+" saved")+"#routing")
+	})
+}
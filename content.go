@@ -0,0 +1,234 @@
+// content.go - Database-backed portfolio content
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// Portfolio project card
+type Project struct {
+	ID         int      `json:"id"`
+	Slug       string   `json:"slug"`
+	Title      string   `json:"title"`
+	Summary    string   `json:"summary"`
+	ImagePath  string   `json:"image_path"`
+	URL        string   `json:"url,omitempty"`
+	Tech       []string `json:"tech"`
+	LaunchedOn string   `json:"launched_on,omitempty"` // display date like "Aug 2023"
+	SortOrder  int      `json:"sort_order"`
+}
+
+// Work or education entry
+type Experience struct {
+	ID            int      `json:"id"`
+	Kind          string   `json:"kind"` // "work" or "education"
+	Title         string   `json:"title"`
+	Organization  string   `json:"organization"`
+	StartDate     string   `json:"start_date"`
+	EndDate       string   `json:"end_date"`
+	LogoPath      string   `json:"logo_path"`
+	URL           string   `json:"url,omitempty"`
+	Bullets       []string `json:"bullets"`
+	Certification bool     `json:"certification,omitempty"` // education entries only
+	SortOrder     int      `json:"sort_order"`
+}
+
+const (
+	experienceWork      = "work"
+	experienceEducation = "education"
+)
+
+// Initialize content tables and seed them from text.go on first run
+func initContent() {
+	createProjects := `
+	CREATE TABLE IF NOT EXISTS projects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT NOT NULL UNIQUE,
+		title TEXT NOT NULL,
+		summary TEXT,
+		image_path TEXT,
+		url TEXT,
+		tech TEXT,
+		sort_order INTEGER DEFAULT 0
+	)`
+
+	createExperiences := `
+	CREATE TABLE IF NOT EXISTS experiences (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		title TEXT NOT NULL,
+		organization TEXT,
+		start_date TEXT,
+		end_date TEXT,
+		logo_path TEXT,
+		url TEXT,
+		bullets TEXT,
+		sort_order INTEGER DEFAULT 0
+	)`
+
+	createBlocks := `
+	CREATE TABLE IF NOT EXISTS content_blocks (
+		key TEXT PRIMARY KEY,
+		body TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	for _, stmt := range []string{createProjects, createExperiences, createBlocks} {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create content tables:", err)
+		}
+	}
+	db.Exec(`ALTER TABLE projects ADD COLUMN launched_on TEXT`) // Ignore error if column already exists
+	if _, err := db.Exec(`ALTER TABLE experiences ADD COLUMN certification INTEGER DEFAULT 0`); err == nil {
+		// Mark the certification seeded before education entries could be told apart
+		db.Exec(`UPDATE experiences SET certification = 1 WHERE kind = ? AND title = ?`, experienceEducation, certification)
+	}
+
+	seedContent()
+}
+
+// Seed content from the static text the site originally shipped with
+func seedContent() {
+	_, err := db.Exec("INSERT OR IGNORE INTO content_blocks (key, body) VALUES ('about', ?)", AboutMe)
+	if err != nil {
+		log.Printf("Error seeding about content: %v", err)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM projects").Scan(&count)
+	if count == 0 {
+		projects := []Project{
+			{Slug: "gomail", Title: "GoMail", Summary: ProjectOne, ImagePath: "images/GoMail.gif",
+				Tech: []string{"Bubbletea", "Lipgloss", "go-imap", "go-message", "fzf", "godotenv"}},
+			{Slug: "go-ytm", Title: "Go-ytm", Summary: ProjectTwo, ImagePath: "images/go-ytm.gif",
+				Tech: []string{"Cobra-cli", "Bubbletea", "Lipgloss", "yt-dlp", "mpv"}},
+			{Slug: "game-recommender", Title: "Game Recommender", Summary: ProjectThree, ImagePath: "images/GameRecommender.png",
+				Tech: []string{"Python", "Flask", "Pandas", "Scikit-learn", "Matplotlib", "psutil"}},
+			{Slug: "zach-dev", Title: "Zach-Dev Website", Summary: ProjectFour, ImagePath: "images/portfolioSite.png",
+				URL: "https://github.com/Zachkp/zach-dev", Tech: []string{"Golang", "htmx", "alpine.js", "Tailwindcss", "Gin", "HTML"}},
+		}
+		for i, p := range projects {
+			_, err := db.Exec(`
+				INSERT INTO projects (slug, title, summary, image_path, url, tech, sort_order)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, p.Slug, p.Title, p.Summary, p.ImagePath, p.URL, strings.Join(p.Tech, ","), i)
+			if err != nil {
+				log.Printf("Error seeding project %s: %v", p.Slug, err)
+			}
+		}
+		log.Printf("Seeded %d projects", len(projects))
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM experiences").Scan(&count)
+	if count == 0 {
+		experiences := []Experience{
+			{Kind: experienceWork, Title: jobTitle3, Organization: company3, StartDate: startDateWork3, EndDate: endDate3,
+				LogoPath: "images/freelance.png", Bullets: []string{freelanceBullet1, freelanceBullet2, freelanceBullet3}},
+			{Kind: experienceWork, Title: jobTitle, Organization: company, StartDate: startDateWork, EndDate: endDate,
+				LogoPath: "images/TargetLogo.jpg", URL: "https://corporate.target.com/",
+				Bullets: []string{targetBullet1, targetBullet2, targetBullet3}},
+			{Kind: experienceWork, Title: jobTitle2, Organization: company2, StartDate: startDateWork2, EndDate: endDate2,
+				LogoPath: "images/jasonsCateringLogo.png", URL: "https://www.jasonscatering.com/",
+				Bullets: []string{cateringBullet1, cateringBullet2, cateringBullet3}},
+			{Kind: experienceEducation, Title: degree, Organization: institution, StartDate: startDateEdu, EndDate: endDateEdu,
+				LogoPath: "images/WGU-logo.png", URL: "https://www.wgu.edu/", Bullets: []string{eduBullet1, eduBullet2, eduBullet3}},
+			{Kind: experienceEducation, Title: certification, Organization: institution2, StartDate: startDateEdu2, EndDate: endDateEdu2,
+				LogoPath: "images/comptiaCert.png", Certification: true,
+				URL: "https://www.certmetrics.com/comptia/public/verification.aspx/", Bullets: []string{certBullet1, certBullet2, certBullet3}},
+		}
+		for i, e := range experiences {
+			_, err := db.Exec(`
+				INSERT INTO experiences (kind, title, organization, start_date, end_date, logo_path, url, bullets, certification, sort_order)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, e.Kind, e.Title, e.Organization, e.StartDate, e.EndDate, e.LogoPath, e.URL, joinBullets(e.Bullets), e.Certification, i)
+			if err != nil {
+				log.Printf("Error seeding experience %s: %v", e.Title, err)
+			}
+		}
+		log.Printf("Seeded %d experience entries", len(experiences))
+	}
+}
+
+// Bullets are stored one per line; collapse the indentation text.go carries
+func joinBullets(bullets []string) string {
+	cleaned := make([]string, 0, len(bullets))
+	for _, b := range bullets {
+		cleaned = append(cleaned, strings.Join(strings.Fields(b), " "))
+	}
+	return strings.Join(cleaned, "\n")
+}
+
+func splitList(value, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Get a named block of free-form content such as "about"
+func getContentBlock(ctx context.Context, key string) (string, error) {
+	var body string
+	err := dbQueryRow(ctx, "SELECT body FROM content_blocks WHERE key = ?", key).Scan(&body)
+	return body, err
+}
+
+// Get all projects in display order
+func getProjects(ctx context.Context) ([]Project, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, slug, title, COALESCE(summary, ''), COALESCE(image_path, ''), COALESCE(url, ''),
+			COALESCE(tech, ''), COALESCE(launched_on, ''), sort_order
+		FROM projects
+		ORDER BY sort_order, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		var p Project
+		var tech string
+		err := rows.Scan(&p.ID, &p.Slug, &p.Title, &p.Summary, &p.ImagePath, &p.URL, &tech, &p.LaunchedOn, &p.SortOrder)
+		if err != nil {
+			continue
+		}
+		p.Tech = splitList(tech, ",")
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// Get experience entries of one kind in display order
+func getExperiences(ctx context.Context, kind string) ([]Experience, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, kind, title, COALESCE(organization, ''), COALESCE(start_date, ''), COALESCE(end_date, ''),
+			COALESCE(logo_path, ''), COALESCE(url, ''), COALESCE(bullets, ''), IFNULL(certification, 0) = 1, sort_order
+		FROM experiences
+		WHERE kind = ?
+		ORDER BY sort_order, id
+	`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var experiences []Experience
+	for rows.Next() {
+		var e Experience
+		var bullets string
+		err := rows.Scan(&e.ID, &e.Kind, &e.Title, &e.Organization, &e.StartDate, &e.EndDate,
+			&e.LogoPath, &e.URL, &bullets, &e.Certification, &e.SortOrder)
+		if err != nil {
+			continue
+		}
+		e.Bullets = splitList(bullets, "\n")
+		experiences = append(experiences, e)
+	}
+	return experiences, rows.Err()
+}
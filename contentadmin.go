@@ -0,0 +1,142 @@
+// contentadmin.go - Admin editor for portfolio content
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Row listed on the content admin page
+type ContentItem struct {
+	Kind  string
+	ID    string
+	Title string
+	Note  string
+}
+
+// Get every editable portfolio row
+func getContentItems(ctx context.Context) ([]ContentItem, error) {
+	var items []ContentItem
+
+	rows, err := dbQuery(ctx, "SELECT key FROM content_blocks ORDER BY key")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			items = append(items, ContentItem{Kind: "block", ID: key, Title: key, Note: "content block"})
+		}
+	}
+	rows.Close()
+
+	projects, err := getProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		items = append(items, ContentItem{Kind: "project", ID: strconv.Itoa(p.ID), Title: p.Title, Note: "project"})
+	}
+
+	for _, kind := range []string{experienceWork, experienceEducation} {
+		experiences, err := getExperiences(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range experiences {
+			items = append(items, ContentItem{Kind: "experience", ID: strconv.Itoa(e.ID), Title: e.Title, Note: kind + " at " + e.Organization})
+		}
+	}
+
+	return items, nil
+}
+
+// Setup admin content editor routes
+func setupContentAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/content", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		items, err := getContentItems(ctx)
+		if err != nil {
+			log.Printf("Error loading content: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load content",
+			})
+			return
+		}
+		history, err := getAvailabilityHistory(ctx, 20) // from availability.go
+		if err != nil {
+			log.Printf("Error loading availability history: %v", err)
+		}
+		c.HTML(http.StatusOK, "admin-content.html", gin.H{
+			"items":               items,
+			"availabilityHistory": history,
+		})
+	})
+
+	adminGroup.GET("/content/:kind/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		kind, ok := contentKinds[c.Param("kind")]
+		if !ok || kind.Name == "post" {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Unknown content type",
+			})
+			return
+		}
+
+		snapshot, err := loadContentSnapshot(ctx, kind, c.Param("id"))
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": kind.Label + " not found",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "admin-content-edit.html", gin.H{
+			"kind":     kind,
+			"entityID": c.Param("id"),
+			"values":   snapshot,
+		})
+	})
+
+	adminGroup.POST("/content/:kind/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		kind, ok := contentKinds[c.Param("kind")]
+		if !ok || kind.Name == "post" {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Unknown content type",
+			})
+			return
+		}
+		id := c.Param("id")
+
+		snapshot := contentSnapshot{}
+		for _, f := range kind.Fields {
+			snapshot[f.Column] = c.PostForm(f.Column)
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		recordRevision(ctx, kind.Name, id, ipHash)
+		if err := applyContentSnapshot(ctx, kind, id, snapshot); err != nil {
+			if err == sql.ErrNoRows {
+				c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+					"error": kind.Label + " not found",
+				})
+				return
+			}
+			log.Printf("Error saving %s %s: %v", kind.Name, id, err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save " + kind.Label + " (is the slug already taken?)",
+			})
+			return
+		}
+		recordRevision(ctx, kind.Name, id, ipHash)
+
+		log.Printf("%s %s saved by admin from %s", kind.Label, id, ipHash)
+		c.Redirect(http.StatusSeeOther, "/admin/content")
+	})
+}
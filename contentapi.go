@@ -0,0 +1,123 @@
+// contentapi.go - Headless JSON content API with ETag caching
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Everything the public pages render, in one document
+type SiteContent struct {
+	About      string       `json:"about"`
+	Projects   []Project    `json:"projects"`
+	Experience []Experience `json:"experience"`
+	Education  []Experience `json:"education"`
+	Skills     []Skill      `json:"skills"` // from skills.go
+
+	Availability *Availability `json:"availability,omitempty"` // from availability.go
+}
+
+// Load all site content from the content tables
+func loadSiteContent(ctx context.Context) (*SiteContent, error) {
+	var content SiteContent
+	var err error
+
+	if content.About, err = getContentBlock(ctx, "about"); err != nil {
+		return nil, err
+	}
+	if content.Projects, err = getProjects(ctx); err != nil {
+		return nil, err
+	}
+	if content.Experience, err = getExperiences(ctx, experienceWork); err != nil {
+		return nil, err
+	}
+	if content.Education, err = getExperiences(ctx, experienceEducation); err != nil {
+		return nil, err
+	}
+	if content.Skills, err = getSkills(ctx, ""); err != nil {
+		return nil, err
+	}
+	if content.Availability, err = getAvailability(ctx); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// Content version hash used as a strong ETag
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Check an If-None-Match header against the current ETag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a JSON body with validators and cache headers, answering 304 when
+// unchanged (from conditional.go)
+func writeCachedJSON(c *gin.Context, value interface{}, maxAge string) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode content"})
+		return
+	}
+
+	writeConditional(c, "application/json; charset=utf-8", body, "public, max-age="+maxAge+", must-revalidate")
+}
+
+// Serve all content, or a single section of it
+func contentAPIHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	content, err := loadSiteContent(ctx)
+	if err != nil {
+		log.Printf("Error loading site content: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load content"})
+		return
+	}
+
+	var value interface{} = content
+	switch section := c.Param("section"); section {
+	case "":
+	case "about":
+		value = gin.H{"about": content.About}
+	case "projects":
+		value = gin.H{"projects": content.Projects}
+	case "experience":
+		value = gin.H{"experience": content.Experience}
+	case "education":
+		value = gin.H{"education": content.Education}
+	case "skills":
+		// Optionally one category, like the home page filter
+		skills := content.Skills
+		if category := c.Query("category"); category != "" {
+			skills = nil
+			for _, s := range content.Skills {
+				if s.Category == category {
+					skills = append(skills, s)
+				}
+			}
+		}
+		value = gin.H{"skills": skills}
+	case "availability":
+		value = gin.H{"availability": content.Availability}
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown content section"})
+		return
+	}
+
+	writeCachedJSON(c, value, "300")
+}
@@ -0,0 +1,112 @@
+// cors.go - Configurable CORS handling for the public /api/ routes
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS settings loaded from the environment
+type CORSConfig struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         int // Seconds browsers may cache a preflight response
+}
+
+var corsConfig CORSConfig
+
+// Load CORS settings, defaulting to an open read/write API
+//
+//	CORS_ALLOWED_ORIGINS  comma-separated origins, or * (default *)
+//	CORS_ALLOWED_METHODS  comma-separated methods (default GET,POST,OPTIONS)
+//	CORS_ALLOWED_HEADERS  comma-separated headers (default Authorization,Content-Type,Idempotency-Key)
+//	CORS_MAX_AGE          preflight cache lifetime in seconds (default 600)
+func initCORS() {
+	corsConfig = CORSConfig{
+		AllowedOrigins: splitEnvList("CORS_ALLOWED_ORIGINS", "*"),
+		AllowedMethods: splitEnvList("CORS_ALLOWED_METHODS", "GET,POST,OPTIONS"),
+		AllowedHeaders: splitEnvList("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,Idempotency-Key"),
+		MaxAge:         600,
+	}
+
+	if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil || maxAge < 0 {
+			log.Printf("Ignoring invalid CORS_MAX_AGE %q", v)
+		} else {
+			corsConfig.MaxAge = maxAge
+		}
+	}
+
+	log.Printf("CORS: allowing origins %s on /api/", strings.Join(corsConfig.AllowedOrigins, ", "))
+}
+
+// Read a comma-separated environment variable
+func splitEnvList(key, fallback string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = fallback
+	}
+	return splitList(value, ",")
+}
+
+// Match a request origin against the allow list
+func (cfg CORSConfig) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// CORS middleware - only acts on /api/ paths and answers preflight requests
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !routePolicyFor(c.Request.URL.Path).CORS { // from routepolicy.go
+			c.Next()
+			return
+		}
+
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		allowedOrigin, ok := corsConfig.allowOrigin(origin)
+		if !ok {
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", allowedOrigin)
+		if allowedOrigin != "*" {
+			c.Header("Vary", "Origin")
+		}
+
+		// Preflight request
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", strings.Join(corsConfig.AllowedMethods, ", "))
+			c.Header("Access-Control-Allow-Headers", strings.Join(corsConfig.AllowedHeaders, ", "))
+			c.Header("Access-Control-Max-Age", strconv.Itoa(corsConfig.MaxAge))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+// dbctx.go - Context-aware database helpers with per-query timeouts
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Longest any single query may run, so a held SQLite lock can't pile up
+// goroutines waiting on it. Schema setup in the init functions runs before
+// the server accepts requests and uses db directly, without a timeout.
+// Queries go to the database of the site attached to ctx (see sites.go).
+const queryTimeout = 5 * time.Second
+
+// Rows that release their timeout when closed
+type timedRows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *timedRows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// Row that releases its timeout once scanned
+type timedRow struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r timedRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// Run a statement with the per-query timeout
+func dbExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	result, err := dbFor(ctx).ExecContext(ctx, query, args...)
+	noteDBError(ctx, err) // from degraded.go
+	return result, err
+}
+
+// Run a query with the per-query timeout; the timeout covers reading the rows
+func dbQuery(ctx context.Context, query string, args ...interface{}) (*timedRows, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	rows, err := dbFor(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &timedRows{Rows: rows, cancel: cancel}, nil
+}
+
+// Run a single-row query with the per-query timeout
+func dbQueryRow(ctx context.Context, query string, args ...interface{}) timedRow {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	return timedRow{Row: dbFor(ctx).QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+// Start a transaction; the timeout covers the whole transaction
+func dbBegin(ctx context.Context) (*sql.Tx, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	tx, err := dbFor(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return tx, cancel, nil
+}
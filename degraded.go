@@ -0,0 +1,234 @@
+// degraded.go - Read-only mode when the database can't be written
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"modernc.org/sqlite"
+)
+
+// How often a database that went read-only while running is tried again
+const degradedProbeInterval = time.Minute
+
+// SQLite result codes that mean the database can't take writes
+// (SQLITE_IOERR, SQLITE_FULL, SQLITE_CANTOPEN, SQLITE_READONLY)
+var degradedErrorCodes = map[int]bool{10: true, 13: true, 14: true, 8: true}
+
+// Why a site is read-only. With a snapshot the server runs on a temporary
+// copy of the last readable data, which only a restart can replace.
+type DegradedState struct {
+	Reason   string
+	Since    time.Time
+	Snapshot bool
+}
+
+// Read-only states by site ID; each site has its own database (from
+// sites.go), so one tenant's full disk doesn't stop the others
+var (
+	degradedMu     sync.RWMutex
+	degradedStates = map[int]*DegradedState{}
+)
+
+// The context's site's read-only state, or nil while its database is
+// writable
+func degradedMode(ctx context.Context) *DegradedState {
+	degradedMu.RLock()
+	defer degradedMu.RUnlock()
+	return degradedStates[siteFromContext(ctx).ID]
+}
+
+func enterDegradedMode(site *Site, reason string, snapshot bool) {
+	degradedMu.Lock()
+	defer degradedMu.Unlock()
+	if degradedStates[site.ID] != nil {
+		return
+	}
+	degradedStates[site.ID] = &DegradedState{Reason: reason, Since: time.Now(), Snapshot: snapshot}
+	log.Printf("Database for %s unavailable, serving read-only: %s", site.Name, reason)
+}
+
+// Switch the context's site to read-only when a write fails because its
+// database can't be written; called by the db helpers in dbctx.go
+func noteDBError(ctx context.Context, err error) {
+	var sqliteErr *sqlite.Error
+	if err == nil || !errors.As(err, &sqliteErr) || !degradedErrorCodes[sqliteErr.Code()&0xff] {
+		return
+	}
+	enterDegradedMode(siteFromContext(ctx), err.Error(), false)
+}
+
+// Whether the database takes writes. Setting user_version to its own value
+// changes nothing but still needs a write transaction.
+func checkDBWritable(conn *sql.DB) error {
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	_, err := conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
+
+// Database to run on when the real one can't be written: a temporary copy
+// of it when it can still be read, otherwise an empty one. Either way the
+// init functions can set up their tables and the public pages render.
+func openSnapshotDB(cause error) *sql.DB {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("zach-dev-snapshot-%d.db", os.Getpid()))
+	os.Remove(path)
+
+	reason := cause.Error()
+	source, err := sql.Open("sqlite", "file:"+databasePath()+"?mode=ro&immutable=1")
+	if err == nil {
+		_, err = source.Exec("VACUUM INTO ?", path)
+		source.Close()
+	}
+	if err != nil {
+		log.Printf("Can't read %s either (%v); starting with no data", databasePath(), err)
+		reason += "; serving without stored content"
+		os.Remove(path)
+	}
+
+	snapshot, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err == nil {
+		err = snapshot.Ping()
+	}
+	if err != nil {
+		log.Fatal("Failed to open a temporary database:", err)
+	}
+	enterDegradedMode(primarySite, reason, true) // from sites.go
+	return snapshot
+}
+
+// Try databases that went read-only while running until they take writes
+// again, each site's own
+func degradedProbeWorker() {
+	ticker := time.NewTicker(degradedProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+		degradedMu.RLock()
+		states := make(map[int]*DegradedState, len(degradedStates))
+		for id, state := range degradedStates {
+			states[id] = state
+		}
+		degradedMu.RUnlock()
+
+		for id, state := range states {
+			if state.Snapshot {
+				continue
+			}
+			site := siteByID(id) // from sites.go
+			if site != nil && checkDBWritable(dbFor(withSite(context.Background(), site))) != nil {
+				continue
+			}
+			// Writable again, or the site was deleted
+			degradedMu.Lock()
+			delete(degradedStates, id)
+			degradedMu.Unlock()
+			if site != nil {
+				log.Printf("Database for %s writable again after %s", site.Name, time.Since(state.Since).Round(time.Second))
+			}
+		}
+	}
+}
+
+// Start watching for recovery
+func initDegradedMode() {
+	startWorker("database-probe", degradedProbeWorker) // from safego.go
+}
+
+// Turn away requests that would write while the database is read-only,
+// in the form each caller expects
+func degradedMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		path := c.Request.URL.Path
+		policy := routePolicyFor(path) // from routepolicy.go
+		state := degradedMode(c.Request.Context())
+		if state == nil || method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions ||
+			policy.ReadOnlyAllowed {
+			c.Next()
+			return
+		}
+
+		const message = "The site is in read-only mode for maintenance, so this can't be saved right now. Please try again later."
+		c.Header("Retry-After", "300")
+		switch {
+		// htmx fragments only swap in on 200, like the forms' other errors
+		case policy.ErrorTemplate != "":
+			c.HTML(http.StatusOK, policy.ErrorTemplate, gin.H{"error": message})
+		case strings.HasPrefix(path, "/admin/"):
+			c.HTML(http.StatusServiceUnavailable, "admin-error.html", gin.H{
+				"error": "The database is read-only, so changes can't be saved: " + state.Reason,
+			})
+		case strings.HasPrefix(path, "/api/"):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "read-only mode"})
+		default:
+			c.String(http.StatusServiceUnavailable, message)
+		}
+		c.Abort()
+	}
+}
+
+// Notice at the top of every page while the site is read-only
+func insertDegradedNotice(ctx context.Context, page []byte, admin bool) []byte {
+	state := degradedMode(ctx)
+	if state == nil {
+		return page
+	}
+	i := bytes.Index(page, []byte("<body"))
+	if i < 0 {
+		return page
+	}
+	end := bytes.IndexByte(page[i:], '>')
+	if end < 0 {
+		return page
+	}
+	i += end + 1
+
+	text := "The site is in read-only mode for maintenance. The link shortener and contact form are temporarily unavailable."
+	if admin {
+		text = "The database is read-only since " + state.Since.Format("Jan 2 15:04") + ": " + state.Reason + ". Changes can't be saved"
+		if state.Snapshot {
+			text += "; the site is serving a copy of its data until the server is restarted with a writable database"
+		}
+		text += "."
+	}
+	notice := `<div role="status" class="bg-yellow-900/80 border-b border-yellow-500/50 text-yellow-100 text-sm text-center px-4 py-2">` +
+		template.HTMLEscapeString(text) + `</div>`
+
+	out := make([]byte, 0, len(page)+len(notice))
+	out = append(out, page[:i]...)
+	out = append(out, notice...)
+	return append(out, page[i:]...)
+}
+
+// Diagnostics entry for the site's database mode
+func checkDegradedMode(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Database writes", Status: checkPass, Detail: "writable"}
+	if state := degradedMode(ctx); state != nil {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("read-only since %s: %s", state.Since.Format("Jan 2 15:04"), state.Reason)
+		if state.Snapshot {
+			check.Detail += " (serving a temporary copy until restart)"
+		}
+	}
+	return check
+}
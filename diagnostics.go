@@ -0,0 +1,379 @@
+// diagnostics.go - On-demand health checks for the admin
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Result of one diagnostic check
+type DiagnosticCheck struct {
+	Name   string
+	Status string // pass, fail or skip
+	Detail string
+}
+
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// Background job health, reported by the jobs themselves
+type JobStatus struct {
+	Name     string
+	Interval time.Duration // 0 for jobs that only run at startup
+	LastRun  time.Time
+	LastErr  string
+	Runs     int
+}
+
+var (
+	jobStatusMu sync.Mutex
+	jobStatuses = map[string]*JobStatus{}
+)
+
+// Record a background job run
+func recordJobRun(name string, interval time.Duration, err error) {
+	jobStatusMu.Lock()
+	defer jobStatusMu.Unlock()
+
+	status, ok := jobStatuses[name]
+	if !ok {
+		status = &JobStatus{Name: name}
+		jobStatuses[name] = status
+	}
+	status.Interval = interval
+	status.LastRun = time.Now()
+	status.Runs++
+	status.LastErr = ""
+	if err != nil {
+		status.LastErr = err.Error()
+	}
+}
+
+// Largest age of a GeoIP database before it counts as stale
+const geoIPMaxAge = 35 * 24 * time.Hour
+
+func checkDatabaseIntegrity(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Database integrity"}
+	rows, err := dbQuery(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if rows.Scan(&line) == nil && line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if len(problems) > 0 {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%d problem(s), first: %s", len(problems), problems[0])
+		return check
+	}
+	check.Status, check.Detail = checkPass, "integrity_check returned ok"
+	return check
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func checkDatabaseSize(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Database disk usage"}
+	path := databasePath() // from main.go
+	info, err := os.Stat(path)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+
+	size := info.Size()
+	detail := filepath.Base(path) + " " + formatBytes(size)
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		size += wal.Size()
+		detail += ", WAL " + formatBytes(wal.Size())
+	}
+
+	var pageSize, freePages int64
+	dbQueryRow(ctx, "PRAGMA page_size").Scan(&pageSize)
+	dbQueryRow(ctx, "PRAGMA freelist_count").Scan(&freePages)
+	if freePages > 0 {
+		detail += fmt.Sprintf(", %s reclaimable by VACUUM", formatBytes(freePages*pageSize))
+	}
+
+	check.Status, check.Detail = checkPass, detail
+	return check
+}
+
+// Stats queries whose plans are checked (from admin.go)
+var queryPlanChecks = []struct {
+	name  string
+	query string
+}{
+	{"unique visitors", statsUniqueVisitorsQuery},
+	{"visitors today", statsVisitorsTodayQuery},
+	{"visitors this week", statsVisitorsWeekQuery},
+	{"top URLs", statsTopURLsQuery},
+	{"recent visitors", statsRecentVisitorsQuery},
+}
+
+// Flag stats queries that SQLite runs as a full table scan (a missing or
+// unusable index); scans of a covering index are fine
+func checkQueryPlans(ctx context.Context) []DiagnosticCheck {
+	var checks []DiagnosticCheck
+	for _, q := range queryPlanChecks {
+		check := DiagnosticCheck{Name: "Query plan: " + q.name}
+		rows, err := dbQuery(ctx, "EXPLAIN QUERY PLAN "+q.query)
+		if err != nil {
+			check.Status, check.Detail = checkFail, err.Error()
+			checks = append(checks, check)
+			continue
+		}
+
+		var steps, scans []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if rows.Scan(&id, &parent, &unused, &detail) != nil {
+				continue
+			}
+			steps = append(steps, detail)
+			if strings.HasPrefix(detail, "SCAN ") && !strings.Contains(detail, "INDEX") {
+				scans = append(scans, detail)
+			}
+		}
+		rows.Close()
+
+		if len(scans) > 0 {
+			check.Status, check.Detail = checkFail, "full table scan: "+strings.Join(scans, "; ")
+		} else {
+			check.Status, check.Detail = checkPass, strings.Join(steps, "; ")
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Parse the templates the way pages get them: with the site's theme
+// overrides and the helper functions they call (from themes.go)
+func checkTemplates(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Templates"}
+	theme, _ := themeByName(activeThemeName(ctx))
+	tmpl, err := parseThemeTemplates(theme)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+
+	// Escaping errors only surface on execution, so run each template
+	// with no data and keep just the escaping errors
+	var failures []string
+	for _, t := range tmpl.Templates() {
+		if err := t.Execute(io.Discard, nil); err != nil {
+			if _, ok := err.(*template.Error); ok {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	if len(failures) > 0 {
+		check.Status, check.Detail = checkFail, failures[0]
+		return check
+	}
+	check.Status, check.Detail = checkPass, fmt.Sprintf("%d templates parsed", len(tmpl.Templates()))
+	return check
+}
+
+func checkSMTP() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "SMTP connectivity"}
+	settings := loadSMTPSettings()
+	if settings.User == "" || settings.Pass == "" {
+		check.Status, check.Detail = checkSkip, "SMTP_USER / SMTP_PASS not configured"
+		return check
+	}
+
+	address := net.JoinHostPort(settings.Host, settings.Port)
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: settings.Host}); err != nil {
+			check.Status, check.Detail = checkFail, "STARTTLS: "+err.Error()
+			return check
+		}
+	}
+	if err := client.Auth(smtp.PlainAuth("", settings.User, settings.Pass, settings.Host)); err != nil {
+		check.Status, check.Detail = checkFail, "auth: "+err.Error()
+		return check
+	}
+	client.Quit()
+
+	check.Status, check.Detail = checkPass, "connected and authenticated to "+address
+	return check
+}
+
+func checkDKIM() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "DKIM signing"}
+	if dkim == nil {
+		check.Status, check.Detail = checkSkip, "DKIM_PRIVATE_KEY not configured; mail is sent unsigned"
+		return check
+	}
+	check.Status = checkPass
+	check.Detail = fmt.Sprintf("signing as %s with selector %s (publish the key at %s._domainkey.%s)",
+		dkim.Domain, dkim.Selector, dkim.Selector, dkim.Domain)
+	return check
+}
+
+func checkGeoIP() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "GeoIP database"}
+	path := os.Getenv("GEOIP_DB_PATH")
+	if path == "" {
+		check.Status, check.Detail = checkSkip, "GEOIP_DB_PATH not set; countries come from the COUNTRY_HEADER header only"
+		return check
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	if loadGeoIP() == nil { // from geoip.go
+		check.Status, check.Detail = checkFail, path+" couldn't be opened as a MaxMind database; see the log"
+		return check
+	}
+	age := time.Since(info.ModTime())
+	check.Detail = fmt.Sprintf("%s updated %s (%d days ago)", path, info.ModTime().Format("Jan 2, 2006"), int(age.Hours()/24))
+	check.Status = checkPass
+	if age > geoIPMaxAge {
+		check.Status = checkFail
+		check.Detail += "; older than 35 days"
+	}
+	return check
+}
+
+func checkJobs() []DiagnosticCheck {
+	jobStatusMu.Lock()
+	defer jobStatusMu.Unlock()
+
+	var checks []DiagnosticCheck
+	for _, job := range jobStatuses {
+		check := DiagnosticCheck{Name: "Job: " + job.Name, Status: checkPass}
+		check.Detail = fmt.Sprintf("%d run(s), last %s ago", job.Runs, time.Since(job.LastRun).Round(time.Second))
+		switch {
+		case job.LastErr != "":
+			check.Status = checkFail
+			check.Detail += "; last error: " + job.LastErr
+		case job.Interval > 0 && time.Since(job.LastRun) > 3*job.Interval:
+			check.Status = checkFail
+			check.Detail += fmt.Sprintf("; expected every %s", job.Interval)
+		}
+		checks = append(checks, check)
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	if len(checks) == 0 {
+		checks = append(checks, DiagnosticCheck{Name: "Scheduled jobs", Status: checkSkip, Detail: "no job has reported yet"})
+	}
+	return checks
+}
+
+// Run every diagnostic check
+func runDiagnostics(ctx context.Context) []DiagnosticCheck {
+	checks := []DiagnosticCheck{
+		checkDatabaseIntegrity(ctx),
+		checkDegradedMode(ctx), // from degraded.go
+		checkDatabaseSize(ctx),
+		checkVisitorDataQuality(ctx), // from visitorquality.go
+		checkTemplates(ctx),
+		checkTemplateErrors(), // from rendererrors.go
+		checkSMTP(),
+		checkDKIM(),
+		checkGeoIP(),
+		checkBuild(),             // from version.go
+		checkEnvironmentConfig(), // from envcheck.go
+	}
+	checks = append(checks, checkBreakers()...) // from breaker.go
+	checks = append(checks, checkQueryPlans(ctx)...)
+	return append(checks, checkJobs()...)
+}
+
+// Setup admin diagnostics routes
+func setupDiagnosticsAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Checks cover the whole deployment, so only the primary admin sees them
+	diagnostics := adminGroup.Group("/diagnostics", superAdminMiddleware()) // from sites.go
+
+	diagnostics.GET("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		started := time.Now()
+		checks := runDiagnostics(ctx)
+
+		failed := 0
+		for _, check := range checks {
+			if check.Status == checkFail {
+				failed++
+			}
+		}
+
+		c.HTML(http.StatusOK, "admin-diagnostics.html", gin.H{
+			"checks":       checks,
+			"failed":       failed,
+			"environment":  environmentStatus(),    // from envcheck.go
+			"renderErrors": recentTemplateErrors(), // from rendererrors.go
+			"duration":     time.Since(started).Round(time.Millisecond),
+			"message":      c.Query("message"),
+		})
+	})
+
+	diagnostics.POST("/test-email", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		err := sendOwnerEmail(ctx, emailKindDiagnostics, "zachkp.dev diagnostics test", loadSMTPSettings().User,
+			"This is a test email sent from the admin diagnostics page at "+time.Now().Format(time.RFC1123)+".")
+		message := "Test email sent to " + loadSMTPSettings().To
+		if err != nil {
+			log.Printf("Diagnostics test email failed: %v", err)
+			message = "Test email failed: " + err.Error()
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/diagnostics?message="+url.QueryEscape(message))
+	})
+}
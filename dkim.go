@@ -0,0 +1,143 @@
+// dkim.go - DKIM signing for outbound mail
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Loaded DKIM configuration; nil when signing is disabled
+type dkimConfig struct {
+	Domain   string
+	Selector string
+	Key      *rsa.PrivateKey
+}
+
+var dkim *dkimConfig
+
+// Header fields included in the signature, in signing order
+var dkimSignedHeaders = []string{"From", "To", "Subject", "Date", "Reply-To"}
+
+// Load the DKIM key from DKIM_PRIVATE_KEY (PEM) or DKIM_PRIVATE_KEY_FILE
+func initDKIM() {
+	keyPEM := os.Getenv("DKIM_PRIVATE_KEY")
+	if path := os.Getenv("DKIM_PRIVATE_KEY_FILE"); keyPEM == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("DKIM: could not read key file: %v; mail will be sent unsigned", err)
+			return
+		}
+		keyPEM = string(data)
+	}
+	if keyPEM == "" {
+		return
+	}
+
+	// Env files often hold the PEM on one line with literal \n
+	key, err := parseDKIMKey(strings.ReplaceAll(keyPEM, `\n`, "\n"))
+	if err != nil {
+		log.Printf("DKIM: %v; mail will be sent unsigned", err)
+		return
+	}
+
+	domain := os.Getenv("DKIM_DOMAIN")
+	if domain == "" {
+		if at := strings.LastIndex(loadSMTPSettings().User, "@"); at >= 0 {
+			domain = loadSMTPSettings().User[at+1:]
+		}
+	}
+	selector := os.Getenv("DKIM_SELECTOR")
+	if selector == "" {
+		selector = "default"
+	}
+	if domain == "" {
+		log.Printf("DKIM: set DKIM_DOMAIN (or an SMTP_USER address); mail will be sent unsigned")
+		return
+	}
+
+	dkim = &dkimConfig{Domain: domain, Selector: selector, Key: key}
+	log.Printf("DKIM signing enabled for %s with selector %s", domain, selector)
+}
+
+func parseDKIMKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key must be RSA")
+	}
+	return key, nil
+}
+
+var dkimWhitespace = regexp.MustCompile(`[ \t]+`)
+
+// Relaxed header canonicalization (RFC 6376 3.4.2)
+func dkimCanonicalHeader(name, value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = dkimWhitespace.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// Relaxed body canonicalization (RFC 6376 3.4.4); body lines end in CRLF
+func dkimCanonicalBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimWhitespace.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// Build the DKIM-Signature header line (with trailing CRLF) for a message.
+// headers maps field names to values; body must already use CRLF line endings.
+func dkimSign(config *dkimConfig, headers map[string]string, body string) (string, error) {
+	bodyHash := sha256.Sum256([]byte(dkimCanonicalBody(body)))
+
+	var signed []string
+	var canonical strings.Builder
+	for _, name := range dkimSignedHeaders {
+		if value, ok := headers[name]; ok {
+			signed = append(signed, strings.ToLower(name))
+			canonical.WriteString(dkimCanonicalHeader(name, value))
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		config.Domain, config.Selector, time.Now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	// The signature header itself is signed with an empty b= and no trailing CRLF
+	canonical.WriteString(strings.TrimSuffix(dkimCanonicalHeader("DKIM-Signature", value), "\r\n"))
+	digest := sha256.Sum256([]byte(canonical.String()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, config.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return "DKIM-Signature: " + value + base64.StdEncoding.EncodeToString(signature) + "\r\n", nil
+}
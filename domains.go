@@ -0,0 +1,375 @@
+// domains.go - Certificate and domain expiry monitoring
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/publicsuffix"
+)
+
+// How often every domain is checked
+const domainCheckInterval = 12 * time.Hour
+
+// Days left when the dashboard starts warning
+const domainWarnDays = 30
+
+// Days left at which the owner is emailed, once each
+var domainAlertThresholds = []int{30, 14, 7, 1}
+
+// RDAP bootstrap service; redirects to the registry for the domain's TLD
+const rdapBaseURL = "https://rdap.org/domain/"
+
+var rdapClient = newHTTPClient("domain monitor", 20*time.Second) // from httpclient.go
+
+// Trips when rdap.org keeps failing; domains keep their last known expiry meanwhile
+var rdapBreaker = newCircuitBreaker("rdap", 3, 30*time.Minute, nil)
+
+// Monitored domain and its latest results
+type MonitoredDomain struct {
+	ID              int
+	Domain          string
+	CertExpiresAt   *time.Time
+	CertIssuer      string
+	CertError       string
+	DomainExpiresAt *time.Time
+	DomainError     string
+	CheckedAt       *time.Time
+	CertAlerted     int // smallest threshold already alerted, 0 for none
+	DomainAlerted   int
+}
+
+// Whole days until t; negative once it has passed
+func daysUntil(t *time.Time) int {
+	if t == nil {
+		return 0
+	}
+	return int(time.Until(*t).Hours() / 24)
+}
+
+func (d MonitoredDomain) CertDaysLeft() int   { return daysUntil(d.CertExpiresAt) }
+func (d MonitoredDomain) DomainDaysLeft() int { return daysUntil(d.DomainExpiresAt) }
+
+// Whether the dashboard should show the domain: something expires soon or
+// a check failed
+func (d MonitoredDomain) NeedsAttention() bool {
+	if d.CheckedAt == nil {
+		return false
+	}
+	return d.CertError != "" || d.DomainError != "" ||
+		(d.CertExpiresAt != nil && d.CertDaysLeft() <= domainWarnDays) ||
+		(d.DomainExpiresAt != nil && d.DomainDaysLeft() <= domainWarnDays)
+}
+
+// Initialize domain monitoring, watching the site's own domain to start with
+func initDomains() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS monitored_domains (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain TEXT NOT NULL UNIQUE,
+		cert_expires_at DATETIME,
+		cert_issuer TEXT NOT NULL DEFAULT '',
+		cert_error TEXT NOT NULL DEFAULT '',
+		domain_expires_at DATETIME,
+		domain_error TEXT NOT NULL DEFAULT '',
+		checked_at DATETIME,
+		cert_alerted INTEGER NOT NULL DEFAULT 0,
+		domain_alerted INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create monitored_domains table:", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM monitored_domains").Scan(&count); err == nil && count == 0 {
+		if own, err := url.Parse(siteBaseURL()); err == nil { // from seo.go
+			db.Exec("INSERT INTO monitored_domains (domain) VALUES (?)", own.Hostname())
+		}
+	}
+
+	startWorker("domain-expiry", domainWorker) // from safego.go
+}
+
+// Check domains twice a day. They belong to the deployment, so only the
+// primary site's list is used.
+func domainWorker() {
+	ticker := time.NewTicker(domainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := runDomainChecks(context.Background())
+		if err != nil {
+			log.Printf("Error checking domains: %v", err)
+		}
+		recordJobRun("domain-expiry", domainCheckInterval, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Monitored domains, soonest certificate expiry first
+func getMonitoredDomains(ctx context.Context) ([]MonitoredDomain, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, domain, cert_expires_at, cert_issuer, cert_error, domain_expires_at, domain_error,
+			checked_at, cert_alerted, domain_alerted
+		FROM monitored_domains ORDER BY cert_expires_at IS NULL, cert_expires_at, domain
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []MonitoredDomain
+	for rows.Next() {
+		var d MonitoredDomain
+		err := rows.Scan(&d.ID, &d.Domain, &d.CertExpiresAt, &d.CertIssuer, &d.CertError, &d.DomainExpiresAt,
+			&d.DomainError, &d.CheckedAt, &d.CertAlerted, &d.DomainAlerted)
+		if err != nil {
+			continue
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// Certificate served on port 443. A certificate that fails verification
+// is still read for its expiry, with the verification error returned.
+func checkCertificate(domain string) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", domain+":443", &tls.Config{ServerName: domain})
+	if err == nil {
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	insecure, insecureErr := tls.DialWithDialer(dialer, "tcp", domain+":443", &tls.Config{ServerName: domain, InsecureSkipVerify: true})
+	if insecureErr != nil {
+		return nil, err
+	}
+	defer insecure.Close()
+	return insecure.ConnectionState().PeerCertificates[0], err
+}
+
+// Registration expiry from the registry's RDAP record
+func checkDomainExpiry(ctx context.Context, domain string) (time.Time, error) {
+	registered, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rdapBaseURL+registered, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	// Only outages count towards the breaker, not unknown domains
+	var resp *http.Response
+	err = rdapBreaker.Do(func() error { // from breaker.go
+		resp, err = rdapClient.Do(req)
+		if err == nil && resp.StatusCode >= 500 {
+			resp.Body.Close()
+			return fmt.Errorf("RDAP lookup for %s returned status %d", registered, resp.StatusCode)
+		}
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("RDAP lookup for %s returned status %d", registered, resp.StatusCode)
+	}
+
+	var record struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return time.Time{}, err
+	}
+	for _, event := range record.Events {
+		if event.Action == "expiration" {
+			return event.Date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("RDAP record for %s has no expiration date", registered)
+}
+
+// Threshold a countdown has reached, or 0 while it's further out
+func domainAlertThreshold(daysLeft int) int {
+	reached := 0
+	for _, threshold := range domainAlertThresholds {
+		if daysLeft <= threshold {
+			reached = threshold
+		}
+	}
+	return reached
+}
+
+// Email the owner when a countdown reaches a threshold it hasn't been
+// alerted at; returns the threshold to store
+func alertDomainExpiry(ctx context.Context, domain, what string, expires *time.Time, alerted int) int {
+	if expires == nil {
+		return alerted
+	}
+	reached := domainAlertThreshold(daysUntil(expires))
+	if reached == 0 || (alerted != 0 && reached >= alerted) {
+		return reached
+	}
+
+	subject := fmt.Sprintf("%s for %s expires in %d days", what, domain, daysUntil(expires))
+	body := fmt.Sprintf("The %s for %s expires on %s.\n", strings.ToLower(what), domain, expires.UTC().Format("January 2, 2006 15:04 UTC"))
+	if err := sendOwnerEmail(ctx, emailKindDomain, subject, loadSMTPSettings().User, body); err != nil { // from main.go
+		log.Printf("Error sending domain alert for %s: %v", domain, err)
+		return alerted
+	}
+	return reached
+}
+
+// Check every domain's certificate and registration
+func runDomainChecks(ctx context.Context) error {
+	domains, err := getMonitoredDomains(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range domains {
+		d.CertError, d.DomainError = "", ""
+		cert, err := checkCertificate(d.Domain)
+		if cert != nil {
+			d.CertExpiresAt = &cert.NotAfter
+			d.CertIssuer = cert.Issuer.CommonName
+			if len(cert.Issuer.Organization) > 0 {
+				d.CertIssuer = cert.Issuer.Organization[0]
+			}
+		}
+		if err != nil {
+			d.CertError = err.Error()
+		}
+
+		expires, err := checkDomainExpiry(ctx, d.Domain)
+		if err != nil {
+			d.DomainError = err.Error()
+		} else {
+			d.DomainExpiresAt = &expires
+		}
+
+		d.CertAlerted = alertDomainExpiry(ctx, d.Domain, "TLS certificate", d.CertExpiresAt, d.CertAlerted)
+		d.DomainAlerted = alertDomainExpiry(ctx, d.Domain, "Domain registration", d.DomainExpiresAt, d.DomainAlerted)
+
+		_, err = dbExec(ctx, `
+			UPDATE monitored_domains SET cert_expires_at = ?, cert_issuer = ?, cert_error = ?, domain_expires_at = ?,
+				domain_error = ?, checked_at = ?, cert_alerted = ?, domain_alerted = ?
+			WHERE id = ?
+		`, d.CertExpiresAt, d.CertIssuer, d.CertError, d.DomainExpiresAt, d.DomainError, time.Now(),
+			d.CertAlerted, d.DomainAlerted, d.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Domains the primary dashboard should warn about
+func getDomainWarnings(ctx context.Context) ([]MonitoredDomain, error) {
+	domains, err := getMonitoredDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []MonitoredDomain
+	for _, d := range domains {
+		if d.NeedsAttention() {
+			warnings = append(warnings, d)
+		}
+	}
+	return warnings, nil
+}
+
+// Setup admin domain monitoring routes
+func setupDomainAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Domains cover the whole deployment, so only the primary admin sees them
+	domains := adminGroup.Group("/domains", superAdminMiddleware()) // from sites.go
+
+	domains.GET("", func(c *gin.Context) {
+		list, err := getMonitoredDomains(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading monitored domains: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load domains",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-domains.html", gin.H{
+			"domains":    list,
+			"warnDays":   domainWarnDays,
+			"thresholds": domainAlertThresholds,
+			"message":    c.Query("message"),
+		})
+	})
+
+	domains.POST("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		domain := strings.ToLower(strings.TrimSpace(c.PostForm("domain")))
+		if parsed, err := url.Parse(domain); err == nil && parsed.Host != "" {
+			domain = parsed.Hostname() // a pasted URL
+		}
+		if _, err := publicsuffix.EffectiveTLDPlusOne(domain); err != nil || strings.ContainsAny(domain, "/: ") {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": "Enter a domain name like example.com"})
+			return
+		}
+
+		if _, err := dbExec(ctx, "INSERT OR IGNORE INTO monitored_domains (domain) VALUES (?)", domain); err != nil {
+			log.Printf("Error saving monitored domain: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save domain",
+			})
+			return
+		}
+
+		log.Printf("Domain %s added to monitoring by admin from %s", domain, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/domains?message="+url.QueryEscape("Added "+domain+"; it's checked on the next run or with Check now"))
+	})
+
+	domains.POST("/check", func(c *gin.Context) {
+		message := "Checked every domain"
+		if err := runDomainChecks(c.Request.Context()); err != nil {
+			log.Printf("Error checking domains: %v", err)
+			message = "Checks failed: " + err.Error()
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/domains?message="+url.QueryEscape(message))
+	})
+
+	domains.DELETE("/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM monitored_domains WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+			return
+		}
+
+		log.Printf("Monitored domain %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
+	})
+}
@@ -0,0 +1,207 @@
+// engagement.go - Optional engagement pings
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether pages include the engagement script, "on" or unset
+const engagementSetting = "engagement_tracking"
+
+// Pings each client may send per minute; the script pings every 15 seconds
+// and whenever the tab is hidden
+const engagementRateLimit = 30
+
+// Longest visible time one ping may report, in seconds
+const engagementMaxSeconds = 4 * 60 * 60
+
+// Per-session rows are summarized on the dashboard, then removed
+const engagementRetentionDays = 30
+
+// Random id the script keeps for the tab's session
+var engagementSessionRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var engagementRateLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// Script tag added to public pages while engagement tracking is on
+var engagementScriptTag = []byte(`<script src="/static/engagement.js" defer></script>`)
+
+// Average engagement with one path
+type PageEngagement struct {
+	Path           string
+	Sessions       int64
+	AverageSeconds float64
+	AverageScroll  float64
+}
+
+// Initialize engagement storage
+func initEngagement() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS page_engagement (
+		day TEXT NOT NULL,
+		session TEXT NOT NULL,
+		path TEXT NOT NULL,
+		visible_seconds INTEGER NOT NULL DEFAULT 0,
+		scroll_depth INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, session, path)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create page_engagement table:", err)
+	}
+
+	startWorker("engagement-cleanup", cleanupEngagement) // from safego.go
+}
+
+// Whether engagement tracking is on
+func engagementEnabled() bool {
+	return getSetting(engagementSetting, "") == "on" // from settings.go
+}
+
+// Remove session rows past retention
+func cleanupEngagement() {
+	ctx := context.Background()
+	cutoff := time.Now().UTC().AddDate(0, 0, -engagementRetentionDays).Format("2006-01-02")
+	result, err := dbExec(ctx, "DELETE FROM page_engagement WHERE day < ?", cutoff)
+	recordJobRun("engagement-cleanup", 0, err) // from diagnostics.go
+	if err != nil {
+		log.Printf("Error cleaning up engagement: %v", err)
+		return
+	}
+	if rowsDeleted, _ := result.RowsAffected(); rowsDeleted > 0 {
+		log.Printf("Engagement cleanup: removed %d sessions older than %d days", rowsDeleted, engagementRetentionDays)
+	}
+}
+
+// Add the engagement script before </body> while tracking is on
+func insertEngagementScript(page []byte) []byte {
+	if !engagementEnabled() {
+		return page
+	}
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return page
+	}
+	out := make([]byte, 0, len(page)+len(engagementScriptTag))
+	out = append(out, page[:i]...)
+	out = append(out, engagementScriptTag...)
+	return append(out, page[i:]...)
+}
+
+// Average engagement per path over the last days, most visited first
+func getEngagementStats(ctx context.Context, days int) ([]PageEngagement, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := dbQuery(ctx, `
+		SELECT path, COUNT(*), AVG(visible_seconds), AVG(scroll_depth) FROM page_engagement
+		WHERE day >= ? GROUP BY path ORDER BY COUNT(*) DESC LIMIT 10
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []PageEngagement
+	for rows.Next() {
+		var p PageEngagement
+		if rows.Scan(&p.Path, &p.Sessions, &p.AverageSeconds, &p.AverageScroll) == nil {
+			stats = append(stats, p)
+		}
+	}
+	return stats, rows.Err()
+}
+
+// Visible time as "1m 05s"
+func (p PageEngagement) AverageTime() string {
+	seconds := int(p.AverageSeconds + 0.5)
+	if seconds < 60 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	return fmt.Sprintf("%dm %02ds", seconds/60, seconds%60)
+}
+
+// Setup the engagement ping route
+func setupEngagementRoutes(r *gin.Engine) {
+	r.POST("/api/engagement", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if !engagementEnabled() {
+			c.String(http.StatusNotFound, "engagement tracking is off")
+			return
+		}
+		if !engagementRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, hashIP(c.ClientIP())}, engagementRateLimit) {
+			c.Header("Retry-After", "60")
+			c.String(http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		session := c.PostForm("session")
+		path := eventPath(c, c.PostForm("path")) // from events.go
+		visible, err := strconv.Atoi(c.PostForm("visible"))
+		scroll, scrollErr := strconv.Atoi(c.PostForm("scroll"))
+		if !engagementSessionRegex.MatchString(session) || path == "" || err != nil || visible < 0 ||
+			scrollErr != nil || scroll < 0 || scroll > 100 || scroll%25 != 0 {
+			c.String(http.StatusBadRequest, "invalid ping")
+			return
+		}
+		if visible > engagementMaxSeconds {
+			visible = engagementMaxSeconds
+		}
+
+		// Pings carry running totals, so each keeps the largest seen
+		if trackingStatus(c) == trackingTracked && !isPreview(c) { // from admin.go
+			day := time.Now().UTC().Format("2006-01-02")
+			safeGoRetry("engagement-ping", 3, func() error {
+				_, err := dbExec(context.WithoutCancel(ctx), `
+					INSERT INTO page_engagement (day, session, path, visible_seconds, scroll_depth) VALUES (?, ?, ?, ?, ?)
+					ON CONFLICT(day, session, path) DO UPDATE SET
+						visible_seconds = MAX(visible_seconds, excluded.visible_seconds),
+						scroll_depth = MAX(scroll_depth, excluded.scroll_depth)
+				`, day, session, path, visible, scroll)
+				if err != nil {
+					log.Printf("Error recording engagement ping: %v", err)
+				}
+				return err
+			})
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// Setup the admin switch for engagement tracking
+func setupEngagementAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Like the analytics mode, the switch applies to every site
+	adminGroup.POST("/settings/engagement", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		value := ""
+		if c.PostForm("enabled") == "on" {
+			value = "on"
+		}
+
+		if err := setSetting(ctx, engagementSetting, value); err != nil {
+			log.Printf("Error saving engagement setting: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save engagement setting",
+			})
+			return
+		}
+
+		state := "off"
+		if value != "" {
+			state = value
+		}
+		log.Printf("Engagement tracking turned %s by admin from %s", state, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Engagement tracking turned "+state))
+	})
+}
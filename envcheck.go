@@ -0,0 +1,307 @@
+// envcheck.go - Startup environment validation
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Environment variable the app reads
+type EnvVar struct {
+	Name        string
+	Description string
+	Secret      bool // value never shown
+}
+
+// Variables shown on the diagnostics page, roughly by importance
+var knownEnvVars = []EnvVar{
+	{Name: "GIN_MODE", Description: "release in production"},
+	{Name: "PORT", Description: "HTTP port, 8080 by default"},
+	{Name: "BASE_URL", Description: "public URL for canonical links, feeds and short links"},
+	{Name: "DATABASE_PATH", Description: "SQLite file"},
+	{Name: "SITES_DATA_DIR", Description: "tenant databases"},
+	{Name: "ADMIN_USERNAME", Description: "primary admin; overrides the account saved by setup"},
+	{Name: "ADMIN_PASSWORD", Description: "primary admin password", Secret: true},
+	{Name: "SMTP_HOST", Description: "outgoing mail server"},
+	{Name: "SMTP_PORT", Description: "outgoing mail port"},
+	{Name: "SMTP_USER", Description: "mail account"},
+	{Name: "SMTP_PASS", Description: "mail password", Secret: true},
+	{Name: "TO_EMAIL", Description: "where owner notifications go"},
+	{Name: "DKIM_PRIVATE_KEY", Description: "DKIM signing key", Secret: true},
+	{Name: "DKIM_PRIVATE_KEY_FILE", Description: "DKIM signing key file"},
+	{Name: "DKIM_DOMAIN", Description: "DKIM signing domain"},
+	{Name: "GEOIP_DB_PATH", Description: "MaxMind country database"},
+	{Name: "COUNTRY_HEADER", Description: "CDN header with the visitor's country, e.g. CF-IPCountry"},
+	{Name: "THEMES_DIR", Description: "extra themes"},
+	{Name: "TRASH_RETENTION_DAYS", Description: "days trashed items are kept"},
+	{Name: "CORS_ALLOWED_ORIGINS", Description: "origins allowed on /api/"},
+	{Name: "CORS_MAX_AGE", Description: "preflight cache seconds"},
+	{Name: "VISITOR_ARCHIVE_DIR", Description: "local export of archived visitor data"},
+	{Name: "VISITOR_ARCHIVE_S3_BUCKET", Description: "S3 export of archived visitor data"},
+	{Name: "AWS_ACCESS_KEY_ID", Description: "S3 credentials", Secret: true},
+	{Name: "AWS_SECRET_ACCESS_KEY", Description: "S3 credentials", Secret: true},
+	{Name: "ANALYTICS_EXPORT_PROVIDER", Description: "plausible or umami, to copy page views there"},
+	{Name: "ANALYTICS_EXPORT_URL", Description: "self-hosted analytics provider"},
+	{Name: "ANALYTICS_EXPORT_SITE", Description: "domain or website ID at the analytics provider"},
+	{Name: "INBOUND_EMAIL_TOKEN", Description: "inbound email webhook token", Secret: true},
+	{Name: "MAILGUN_WEBHOOK_SIGNING_KEY", Description: "Mailgun webhook signing key", Secret: true},
+	{Name: "MASTODON_INSTANCE", Description: "Mastodon syndication"},
+	{Name: "MASTODON_TOKEN", Description: "Mastodon syndication", Secret: true},
+	{Name: "BLUESKY_HANDLE", Description: "Bluesky syndication"},
+	{Name: "BLUESKY_APP_PASSWORD", Description: "Bluesky syndication", Secret: true},
+	{Name: "DEVTO_API_KEY", Description: "DEV syndication", Secret: true},
+	{Name: "GITHUB_REPO", Description: "repository checked for releases"},
+}
+
+// Misconfiguration found in the environment. Errors stop the server at
+// startup; warnings are logged and shown in diagnostics.
+type EnvIssue struct {
+	Name    string
+	Message string
+	Fatal   bool
+}
+
+// Variable as shown on the diagnostics page
+type EnvVarStatus struct {
+	EnvVar
+	Value  string // "" when unset, masked for secrets
+	Set    bool
+	Issues []EnvIssue
+}
+
+// Check the environment for settings that would otherwise only fail when a
+// request or job first needs them
+func checkEnvironment() []EnvIssue {
+	var issues []EnvIssue
+	fail := func(name, format string, args ...interface{}) {
+		issues = append(issues, EnvIssue{Name: name, Message: fmt.Sprintf(format, args...), Fatal: true})
+	}
+	warn := func(name, format string, args ...interface{}) {
+		issues = append(issues, EnvIssue{Name: name, Message: fmt.Sprintf(format, args...)})
+	}
+	release := gin.Mode() == gin.ReleaseMode
+
+	if os.Getenv("RENDER") != "" && !release {
+		warn("GIN_MODE", "running on Render in %s mode; set GIN_MODE=release so HTTPS is enforced and debug output is off", gin.Mode())
+	}
+
+	if v := os.Getenv("PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			fail("PORT", "%q is not a port number between 1 and 65535", v)
+		}
+	}
+
+	if v := os.Getenv("BASE_URL"); v != "" {
+		if parsed, err := url.Parse(v); err != nil || !isValidLongURL(v) || strings.Trim(parsed.Path, "/") != "" { // from main.go
+			fail("BASE_URL", "%q should be an absolute URL without a path, such as https://example.com", v)
+		}
+	} else if release && getSetting(baseURLSetting, "") == "" { // from setup.go
+		warn("BASE_URL", "not set in release mode, so canonical links, feeds and short links point at %s; set BASE_URL to this site's public URL", defaultSiteBaseURL)
+	}
+
+	// The site still starts, read-only (from degraded.go)
+	if err := checkWritableDir(filepath.Dir(databasePath())); err != nil {
+		warn("DATABASE_PATH", "%s isn't writable (%v), so the site runs read-only; point DATABASE_PATH at a persistent, writable volume", filepath.Dir(databasePath()), err)
+	} else if f, err := os.OpenFile(databasePath(), os.O_WRONLY, 0); err == nil {
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		warn("DATABASE_PATH", "can't write %s (%v), so the site runs read-only; check the file's owner and permissions", databasePath(), err)
+	}
+	if dir := os.Getenv("SITES_DATA_DIR"); dir != "" {
+		if err := checkWritableDir(dir); err != nil {
+			fail("SITES_DATA_DIR", "%s isn't writable (%v)", dir, err)
+		}
+	}
+
+	adminUser, adminPass := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	switch {
+	case release && adminUser != "" && adminPass == "":
+		fail("ADMIN_PASSWORD", "ADMIN_USERNAME is set without ADMIN_PASSWORD, so the password would be the development default; set both, or neither to use /setup")
+	case release && adminPass != "" && adminUser == "":
+		warn("ADMIN_USERNAME", "not set, so the admin username is \"admin\"")
+	case release && adminPass != "" && len(adminPass) < 12:
+		warn("ADMIN_PASSWORD", "shorter than 12 characters")
+	}
+
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			fail("SMTP_PORT", "%q is not a port number between 1 and 65535", v)
+		}
+	}
+	if (os.Getenv("SMTP_USER") == "") != (os.Getenv("SMTP_PASS") == "") {
+		fail("SMTP_PASS", "SMTP_USER and SMTP_PASS must be set together; with only one, every email fails")
+	}
+	if v := os.Getenv("TO_EMAIL"); v != "" {
+		if _, err := mail.ParseAddress(v); err != nil {
+			fail("TO_EMAIL", "%q isn't an email address", v)
+		}
+	}
+
+	if path := os.Getenv("DKIM_PRIVATE_KEY_FILE"); path != "" && os.Getenv("DKIM_PRIVATE_KEY") == "" {
+		if _, err := os.Stat(path); err != nil {
+			fail("DKIM_PRIVATE_KEY_FILE", "can't read the key (%v); mail would be sent unsigned", err)
+		}
+	}
+	if key := os.Getenv("DKIM_PRIVATE_KEY"); key != "" {
+		if _, err := parseDKIMKey(strings.ReplaceAll(key, `\n`, "\n")); err != nil { // from dkim.go
+			fail("DKIM_PRIVATE_KEY", "%v; mail would be sent unsigned", err)
+		}
+	}
+
+	if path := os.Getenv("GEOIP_DB_PATH"); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			fail("GEOIP_DB_PATH", "%v; download the database or unset GEOIP_DB_PATH", err)
+		}
+	}
+	if dir := os.Getenv("THEMES_DIR"); dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fail("THEMES_DIR", "%s isn't a directory", dir)
+		}
+	}
+
+	for _, name := range []string{"TRASH_RETENTION_DAYS", "CORS_MAX_AGE"} {
+		if v := os.Getenv(name); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 || (name == "TRASH_RETENTION_DAYS" && n < 1) {
+				fail(name, "%q isn't a valid number; it would be ignored", v)
+			}
+		}
+	}
+
+	if dir := os.Getenv("IMAGE_CACHE_DIR"); dir != "" {
+		if err := checkWritableDir(dir); err != nil {
+			fail("IMAGE_CACHE_DIR", "%s isn't writable (%v); resized images would be made again on every request", dir, err)
+		}
+	}
+	if dir := os.Getenv("VISITOR_ARCHIVE_DIR"); dir != "" {
+		if err := checkWritableDir(dir); err != nil {
+			fail("VISITOR_ARCHIVE_DIR", "%s isn't writable (%v); archived months would be kept in the database", dir, err)
+		}
+	}
+	if os.Getenv("VISITOR_ARCHIVE_S3_BUCKET") != "" && (os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "") {
+		fail("VISITOR_ARCHIVE_S3_BUCKET", "set without AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, so every export would fail")
+	}
+
+	if key := os.Getenv("SETTINGS_ENCRYPTION_KEY"); key != "" && len(key) < 16 {
+		warn("SETTINGS_ENCRYPTION_KEY", "shorter than 16 characters; use a long random value")
+	}
+	if os.Getenv("STRIPE_SECRET_KEY") != "" && os.Getenv("STRIPE_WEBHOOK_SECRET") == "" && getSetting(stripeWebhookSecretSetting, "") == "" { // from stripe.go
+		warn("STRIPE_WEBHOOK_SECRET", "not set, so invoices paid online must be marked paid by hand")
+	}
+
+	if provider := os.Getenv("ANALYTICS_EXPORT_PROVIDER"); provider != "" {
+		if _, err := newAnalyticsExporter(strings.ToLower(provider), os.Getenv("ANALYTICS_EXPORT_URL"), os.Getenv("ANALYTICS_EXPORT_SITE")); err != nil { // from analyticsexport.go
+			fail("ANALYTICS_EXPORT_PROVIDER", "%v; page views would only be stored locally", err)
+		}
+	}
+
+	for _, pair := range [][2]string{{"MASTODON_INSTANCE", "MASTODON_TOKEN"}, {"BLUESKY_HANDLE", "BLUESKY_APP_PASSWORD"}} {
+		if (os.Getenv(pair[0]) == "") != (os.Getenv(pair[1]) == "") {
+			warn(pair[0], "%s and %s must be set together; syndication to it stays off", pair[0], pair[1])
+		}
+	}
+	return issues
+}
+
+// Whether a file can be created in dir, creating dir if needed
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Log every issue and stop on errors; runs first in initApp, before
+// anything touches the database
+func validateEnvironment() {
+	fatal := 0
+	for _, issue := range checkEnvironment() {
+		if issue.Fatal {
+			fatal++
+			log.Printf("Config error: %s: %s", issue.Name, issue.Message)
+		} else {
+			log.Printf("Config warning: %s: %s", issue.Name, issue.Message)
+		}
+	}
+	if fatal > 0 {
+		log.Fatalf("Found %d configuration error(s); fix them and restart", fatal)
+	}
+}
+
+// Check the SMTP server in the background once settings are loaded
+func initEnvironmentCheck() {
+	startWorker("smtp-reachability", checkSMTPReachable) // from safego.go
+}
+
+// Report an SMTP server that can't be reached, which otherwise only shows
+// when the first email fails. Firewalls often block outgoing mail ports, so
+// this is a warning rather than an error.
+func checkSMTPReachable() {
+	settings := loadSMTPSettings() // from main.go
+	if settings.User == "" || settings.Pass == "" {
+		return
+	}
+	address := net.JoinHostPort(settings.Host, settings.Port)
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		log.Printf("Config warning: SMTP_PORT: can't reach %s (%v); the host may block outgoing mail on port %s, try 465 or 2525", address, err, settings.Port)
+		return
+	}
+	conn.Close()
+}
+
+// Every known variable with its value and issues, for diagnostics
+func environmentStatus() []EnvVarStatus {
+	issues := map[string][]EnvIssue{}
+	for _, issue := range checkEnvironment() {
+		issues[issue.Name] = append(issues[issue.Name], issue)
+	}
+
+	statuses := make([]EnvVarStatus, 0, len(knownEnvVars))
+	for _, v := range knownEnvVars {
+		status := EnvVarStatus{EnvVar: v, Issues: issues[v.Name]}
+		if value, ok := os.LookupEnv(v.Name); ok && value != "" {
+			status.Set = true
+			status.Value = value
+			if v.Secret {
+				status.Value = "set (hidden)"
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Diagnostics summary of the environment check
+func checkEnvironmentConfig() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Environment", Status: checkPass, Detail: "no problems found"}
+	var errs, warnings int
+	for _, issue := range checkEnvironment() {
+		if issue.Fatal {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	if errs+warnings > 0 {
+		check.Detail = fmt.Sprintf("%d error(s), %d warning(s); see the environment section", errs, warnings)
+	}
+	if errs > 0 {
+		check.Status = checkFail
+	}
+	return check
+}
@@ -0,0 +1,148 @@
+// events.go - Custom interaction events
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Events each client may send per minute
+const eventRateLimit = 60
+
+// Longest label kept with an event
+const eventLabelMaxLength = 100
+
+// Event names like "resume-button-clicked" or "project-modal:opened"
+var eventNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]{0,63}$`)
+
+// What event sends are rate limited under
+type eventRateKey struct {
+	SiteID int // from sites.go
+	IPHash string
+}
+
+var eventRateLimiter = newRateLimiter[eventRateKey]() // from api.go
+
+// Custom event count for the dashboard
+type EventCount struct {
+	Name  string
+	Label string
+	Count int64
+}
+
+// Body of an event send, as a form or JSON
+type eventRequest struct {
+	Name  string `form:"name" json:"name"`
+	Label string `form:"label" json:"label"`
+	Path  string `form:"path" json:"path"`
+}
+
+// Initialize custom event storage
+func initEvents() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS events (
+		day TEXT NOT NULL,
+		name TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, name, label, path)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create events table:", err)
+	}
+}
+
+// Page an event happened on: the path sent with it, else the referring page
+// on this host. Query strings are dropped.
+func eventPath(c *gin.Context, sent string) string {
+	if sent == "" {
+		ref, err := url.Parse(c.GetHeader("Referer"))
+		if err != nil || ref.Host != c.Request.Host {
+			return ""
+		}
+		sent = ref.Path
+	}
+	if i := strings.IndexAny(sent, "?#"); i >= 0 {
+		sent = sent[:i]
+	}
+	if !strings.HasPrefix(sent, "/") || len(sent) > 200 {
+		return ""
+	}
+	return sent
+}
+
+// Events over the last days, most frequent first
+func getEventCounts(ctx context.Context, days int) ([]EventCount, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	rows, err := dbQuery(ctx, `
+		SELECT name, label, SUM(count) FROM events WHERE day >= ?
+		GROUP BY name, label ORDER BY SUM(count) DESC LIMIT 20
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []EventCount
+	for rows.Next() {
+		var e EventCount
+		if rows.Scan(&e.Name, &e.Label, &e.Count) == nil {
+			counts = append(counts, e)
+		}
+	}
+	return counts, rows.Err()
+}
+
+// Setup the event collection route
+func setupEventRoutes(r *gin.Engine) {
+	r.POST("/api/event", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if !eventRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, hashIP(c.ClientIP())}, eventRateLimit) {
+			c.Header("Retry-After", "60")
+			c.String(http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		var req eventRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.String(http.StatusBadRequest, "invalid event")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Label = strings.TrimSpace(req.Label)
+		if !eventNameRegex.MatchString(req.Name) || len(req.Label) > eventLabelMaxLength {
+			c.String(http.StatusBadRequest, "invalid event")
+			return
+		}
+
+		// Sent events are accepted either way, but only counted like page
+		// views: not for opted-out visitors or admin previews
+		if trackingStatus(c) == trackingTracked && !isPreview(c) { // from admin.go
+			day := time.Now().UTC().Format("2006-01-02")
+			path := eventPath(c, req.Path)
+			safeGoRetry("custom-event", 3, func() error {
+				_, err := dbExec(context.WithoutCancel(ctx), `
+					INSERT INTO events (day, name, label, path, count) VALUES (?, ?, ?, ?, 1)
+					ON CONFLICT(day, name, label, path) DO UPDATE SET count = count + 1
+				`, day, req.Name, req.Label, path)
+				if err != nil {
+					log.Printf("Error recording event %s: %v", req.Name, err)
+				}
+				return err
+			})
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusNoContent)
+	})
+}
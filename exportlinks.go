@@ -0,0 +1,251 @@
+// exportlinks.go - Signed download links for admin exports
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// An export a download link can be issued for, so it can be fetched (by a
+// backup job, say) without logging in
+type ExportDownload struct {
+	Name  string // in links and the audit log
+	Label string
+	Serve gin.HandlerFunc
+}
+
+// Exports links can be issued for; each link covers exactly one. Apart
+// from the backup they are served by their admin routes' handlers (in
+// admin.go, report.go and security.go).
+var exportDownloads = []ExportDownload{
+	{Name: "backup", Label: "Database backup (SQLite)", Serve: serveDatabaseBackup},
+	{Name: "stats", Label: "Stats (JSON)", Serve: serveStatsExport},
+	{Name: "report", Label: "Last month's report (PDF)", Serve: serveMonthlyReport},
+	{Name: "security-events", Label: "Security events (CSV)", Serve: serveSecurityEventsExport},
+}
+
+// Expiry choices offered in the admin, in hours; kept short as the links
+// open the data itself
+var exportLinkDurations = []int{1, 24, 72}
+
+// Export link as shown in the admin
+type ExportLink struct {
+	ID         int
+	Export     string
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+	Downloads  int
+	URL        string
+}
+
+// Whether the link still downloads
+func (l ExportLink) Active() bool {
+	return !l.RevokedAt.Valid && time.Now().Before(l.ExpiresAt)
+}
+
+// Label of the link's export
+func (l ExportLink) Label() string {
+	if export, ok := findExportDownload(l.Export); ok {
+		return export.Label
+	}
+	return l.Export
+}
+
+func findExportDownload(name string) (ExportDownload, bool) {
+	for _, export := range exportDownloads {
+		if export.Name == name {
+			return export, true
+		}
+	}
+	return ExportDownload{}, false
+}
+
+// Initialize export link storage; tokens are signed with the share link
+// secret (from sharelinks.go)
+func initExportLinks() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS export_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		export TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		downloads INTEGER DEFAULT 0
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create export_links table:", err)
+	}
+}
+
+func exportLinkToken(ctx context.Context, id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expiresAt.Unix())
+	return payload + "." + signedLinkMAC(ctx, "export-link:", payload)
+}
+
+// Check a token and that its link wasn't revoked; returns the link's id
+// and export
+func verifyExportLinkToken(ctx context.Context, token string) (int, string, bool) {
+	id, ok := parseSignedLinkToken(ctx, "export-link:", token)
+	if !ok {
+		return 0, "", false
+	}
+
+	var export string
+	var revoked int
+	err := dbQueryRow(ctx, "SELECT export, revoked_at IS NOT NULL FROM export_links WHERE id = ?", id).Scan(&export, &revoked)
+	if err != nil || revoked == 1 {
+		return 0, "", false
+	}
+	return id, export, true
+}
+
+// Full URL for an export token, on the same host as short links
+func buildExportURL(c *gin.Context, token string) string {
+	return strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/download/" + token // from main.go
+}
+
+// All export links, newest first
+func getExportLinks(ctx context.Context, c *gin.Context) ([]ExportLink, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, export, expires_at, revoked_at, created_at, last_used_at, COALESCE(downloads, 0)
+		FROM export_links
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ExportLink
+	for rows.Next() {
+		var l ExportLink
+		if err := rows.Scan(&l.ID, &l.Export, &l.ExpiresAt, &l.RevokedAt, &l.CreatedAt, &l.LastUsedAt, &l.Downloads); err != nil {
+			continue
+		}
+		if l.Active() {
+			l.URL = buildExportURL(c, exportLinkToken(ctx, l.ID, l.ExpiresAt))
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Download a consistent copy of the site's database. VACUUM INTO writes a
+// snapshot without blocking writers, which a plain file copy can't promise.
+func serveDatabaseBackup(c *gin.Context) {
+	ctx := c.Request.Context()
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("zach-dev-backup-%d-%d.db", os.Getpid(), time.Now().UnixNano()))
+	defer os.Remove(path)
+
+	if _, err := dbFor(ctx).ExecContext(ctx, "VACUUM INTO ?", path); err != nil { // from sites.go
+		log.Printf("Error creating backup: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to create backup")
+		return
+	}
+
+	log.Printf("Database backup downloaded by %s", hashIP(c.ClientIP()))
+	c.FileAttachment(path, "backup-"+time.Now().UTC().Format("2006-01-02")+".db")
+}
+
+// Setup the public download route for export links
+func setupExportLinkRoutes(r *gin.Engine) {
+	r.GET("/download/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+		ipHash := hashIP(c.ClientIP())
+
+		id, name, ok := verifyExportLinkToken(ctx, c.Param("token"))
+		export, known := findExportDownload(name)
+		if !ok || !known {
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "export link", ipHash, time.Minute)
+			c.String(http.StatusNotFound, "This download link has expired or been revoked.")
+			return
+		}
+
+		recordSecurityEvent(ctx, eventExportLinkUsed, ipHash, fmt.Sprintf("link %d: %s", id, name))
+		usedAt := time.Now()
+		safeGoRetry("export-link-usage", 3, func() error {
+			_, err := dbExec(context.WithoutCancel(ctx), "UPDATE export_links SET last_used_at = ?, downloads = COALESCE(downloads, 0) + 1 WHERE id = ?", usedAt, id)
+			return err
+		})
+
+		// Links download the export as configured, without admin options
+		c.Request.URL.RawQuery = ""
+		export.Serve(c)
+	})
+}
+
+// Setup admin export link management routes
+func setupExportLinkAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/export/backup.db", serveDatabaseBackup)
+
+	adminGroup.POST("/export-links", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		export, known := findExportDownload(c.PostForm("export"))
+		hours, err := strconv.Atoi(c.PostForm("hours"))
+		if !known || err != nil || hours < 1 || hours > exportLinkDurations[len(exportLinkDurations)-1] {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "An export and a valid expiry are required",
+			})
+			return
+		}
+
+		expiresAt := time.Now().Add(time.Duration(hours) * time.Hour).Truncate(time.Second)
+		result, err := dbExec(ctx, "INSERT INTO export_links (export, expires_at) VALUES (?, ?)", export.Name, expiresAt)
+		var id int64
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+		if err != nil {
+			log.Printf("Error creating export link: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to create download link",
+			})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		recordSecurityEvent(ctx, eventExportLinkIssued, ipHash,
+			fmt.Sprintf("link %d: %s, expires %s", id, export.Name, expiresAt.UTC().Format(time.RFC3339)))
+		log.Printf("Export link for %s created by admin from %s", export.Name, ipHash)
+		c.Redirect(http.StatusSeeOther, "/admin/share-links?message="+url.QueryEscape("Download link created for "+export.Label))
+	})
+
+	adminGroup.POST("/export-links/:id/revoke", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "UPDATE export_links SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", c.Param("id"))
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to revoke download link",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Download link not found or already revoked",
+			})
+			return
+		}
+
+		recordSecurityEvent(ctx, eventTokenRevoked, hashIP(c.ClientIP()), "export link "+c.Param("id"))
+		c.Redirect(http.StatusSeeOther, "/admin/share-links?message="+url.QueryEscape("Download link revoked"))
+	})
+}
@@ -0,0 +1,146 @@
+// feed.go - RSS feed of blog posts, now page updates and bookmarks
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Items in the feed, newest first
+const feedItemLimit = 30
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category,omitempty"`
+	date        time.Time
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// Setup the RSS feed route
+func setupFeedRoutes(r *gin.Engine) {
+	r.GET("/feed.xml", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var items []rssItem
+
+		posts, err := getPublishedPosts(ctx) // from blog.go
+		if err != nil {
+			log.Printf("Error loading posts for feed: %v", err)
+		}
+		for _, p := range posts {
+			if p.NoIndex {
+				continue
+			}
+			items = append(items, rssItem{
+				Title:       p.Title,
+				Link:        p.Permalink(),
+				GUID:        rssGUID{Value: p.Permalink(), IsPermaLink: true},
+				Description: p.Summary,
+				Category:    "blog",
+				date:        p.Date(),
+			})
+		}
+
+		entries, err := getNowEntries(ctx, feedItemLimit) // from now.go
+		if err != nil {
+			log.Printf("Error loading now entries for feed: %v", err)
+		}
+		for _, e := range entries {
+			items = append(items, rssItem{
+				Title:       e.Title(),
+				Link:        e.Permalink(),
+				GUID:        rssGUID{Value: e.Permalink(), IsPermaLink: true},
+				Description: string(e.HTML()),
+				Category:    "now",
+				date:        e.CreatedAt,
+			})
+		}
+
+		items = append(items, bookmarkFeedItems(ctx)...)
+
+		writeFeed(c, rssChannel{
+			Title:       "Zach-Dev",
+			Link:        siteBaseURL(), // from seo.go
+			Description: "Blog posts, now page updates and bookmarks",
+			Items:       items,
+		})
+	})
+
+	// Linkblog subscribers can follow the bookmarks alone
+	r.GET("/bookmarks/feed.xml", func(c *gin.Context) {
+		writeFeed(c, rssChannel{
+			Title:       "Zach-Dev Bookmarks",
+			Link:        siteBaseURL() + "/bookmarks",
+			Description: "Links worth reading, with commentary",
+			Items:       bookmarkFeedItems(c.Request.Context()),
+		})
+	})
+}
+
+// Linkblog style items: each links to the bookmarked page
+func bookmarkFeedItems(ctx context.Context) []rssItem {
+	bookmarks, err := getBookmarks(ctx, feedItemLimit) // from bookmarks.go
+	if err != nil {
+		log.Printf("Error loading bookmarks for feed: %v", err)
+	}
+	var items []rssItem
+	for _, b := range bookmarks {
+		items = append(items, rssItem{
+			Title:       b.Title,
+			Link:        b.URL,
+			GUID:        rssGUID{Value: b.Permalink(), IsPermaLink: true},
+			Description: string(b.CommentaryHTML()),
+			Category:    "bookmarks",
+			date:        b.CreatedAt,
+		})
+	}
+	return items
+}
+
+// Write the newest items of a channel as RSS
+func writeFeed(c *gin.Context, channel rssChannel) {
+	items := channel.Items
+	sort.Slice(items, func(i, j int) bool { return items[i].date.After(items[j].date) })
+	if len(items) > feedItemLimit {
+		items = items[:feedItemLimit]
+	}
+	for i := range items {
+		items[i].PubDate = items[i].date.UTC().Format(time.RFC1123Z)
+	}
+	channel.Items = items
+
+	body, err := xml.MarshalIndent(rssFeed{Version: "2.0", Channel: channel}, "", "  ")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to build feed")
+		return
+	}
+
+	// Feed readers poll, so unchanged feeds get a 304 (from conditional.go)
+	writeConditional(c, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), body...), "public, max-age=600")
+}
@@ -0,0 +1,362 @@
+// fileshares.go - Expiring file download links
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest file that can be shared
+const fileShareMaxBytes = 100 << 20
+
+// Lifetime choices, in days
+var fileShareDurations = []int{1, 7, 30}
+
+const fileShareCleanupEvery = time.Hour
+
+// Password attempts and downloads each visitor may make per minute
+const fileShareRateLimit = 5
+
+var fileShareLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// Downloads listed with each share in the admin
+const fileShareRecentDownloads = 5
+
+// A shared file, without its contents
+type FileShare struct {
+	ID             int
+	Filename       string
+	Note           string // shown on the download page
+	Size           int
+	Protected      bool
+	ExpiresAt      time.Time
+	Downloads      int
+	LastDownloadAt sql.NullTime
+	CreatedAt      time.Time
+	URL            string      // set for the admin while the link works
+	Recent         []time.Time // latest downloads, for the admin
+}
+
+func (s FileShare) Expired() bool {
+	return !time.Now().Before(s.ExpiresAt)
+}
+
+// Size for display, like "1.2 MB"
+func (s FileShare) SizeText() string {
+	return ClientFile{Size: s.Size}.SizeText() // from clientportal.go
+}
+
+// Initialize file share storage and the expiry cleanup
+func initFileShares() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS file_shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		data BLOB NOT NULL,
+		size INTEGER NOT NULL,
+		password_hash TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		downloads INTEGER NOT NULL DEFAULT 0,
+		last_download_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS file_share_downloads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		share_id INTEGER NOT NULL,
+		ip_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create file share tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_file_share_downloads_share ON file_share_downloads (share_id)`)
+
+	startWorker("file-share-cleanup", fileShareCleanupWorker) // from safego.go
+}
+
+func fileShareCleanupWorker() {
+	ticker := time.NewTicker(fileShareCleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeExpiredFileShares(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("file-share-cleanup", fileShareCleanupEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Delete shares a day after they expire; the admin sees them expired
+// until then
+func purgeExpiredFileShares(ctx context.Context) error {
+	cutoff := time.Now().Add(-24 * time.Hour)
+	_, err := dbExec(ctx, "DELETE FROM file_share_downloads WHERE share_id IN (SELECT id FROM file_shares WHERE expires_at <= ?)", cutoff)
+	if err != nil {
+		log.Printf("Error purging expired file shares: %v", err)
+		return err
+	}
+	result, err := dbExec(ctx, "DELETE FROM file_shares WHERE expires_at <= ?", cutoff)
+	if err != nil {
+		log.Printf("Error purging expired file shares: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("File share cleanup: removed %d expired shares", n)
+	}
+	return nil
+}
+
+const fileShareColumns = `id, filename, note, size, password_hash != '', expires_at, downloads, last_download_at, created_at`
+
+// Scan fileShareColumns, then any extra columns selected after them
+func scanFileShare(row interface{ Scan(...interface{}) error }, extra ...interface{}) (FileShare, error) {
+	var s FileShare
+	dest := []interface{}{&s.ID, &s.Filename, &s.Note, &s.Size, &s.Protected, &s.ExpiresAt, &s.Downloads, &s.LastDownloadAt, &s.CreatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return s, err
+}
+
+// All shares with their latest downloads, newest first
+func getFileShares(ctx context.Context) ([]FileShare, error) {
+	rows, err := dbQuery(ctx, `SELECT `+fileShareColumns+` FROM file_shares ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []FileShare
+	for rows.Next() {
+		s, err := scanFileShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range shares {
+		recent, err := dbQuery(ctx, `SELECT created_at FROM file_share_downloads WHERE share_id = ? ORDER BY id DESC LIMIT ?`,
+			shares[i].ID, fileShareRecentDownloads)
+		if err != nil {
+			return nil, err
+		}
+		for recent.Next() {
+			var at time.Time
+			if err := recent.Scan(&at); err != nil {
+				recent.Close()
+				return nil, err
+			}
+			shares[i].Recent = append(shares[i].Recent, at)
+		}
+		recent.Close()
+	}
+	return shares, nil
+}
+
+// A share and its password hash, "" when it has no password
+func getFileShare(ctx context.Context, id int) (FileShare, string, error) {
+	var passwordHash string
+	s, err := scanFileShare(dbQueryRow(ctx, `SELECT `+fileShareColumns+`, password_hash FROM file_shares WHERE id = ?`, id), &passwordHash)
+	return s, passwordHash, err
+}
+
+func fileShareToken(ctx context.Context, id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expiresAt.Unix())
+	return payload + "." + signedLinkMAC(ctx, "file-share:", payload) // from sharelinks.go
+}
+
+// Full URL for a share token, on the same host as short links
+func buildFileShareURL(c *gin.Context, token string) string {
+	return strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/files/" + token // from main.go
+}
+
+// Setup the public download page for a share link
+func setupFileShareRoutes(r *gin.Engine) {
+	// The share from the link, or a page saying it's gone
+	load := func(c *gin.Context) (FileShare, string, bool) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+
+		id, ok := parseSignedLinkToken(ctx, "file-share:", c.Param("token")) // from sharelinks.go
+		var share FileShare
+		var passwordHash string
+		var err error
+		if ok {
+			share, passwordHash, err = getFileShare(ctx, id)
+		}
+		if !ok || err != nil || share.Expired() {
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Error loading file share %d: %v", id, err)
+			}
+			ipHash := hashIP(c.ClientIP())
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "file share link", ipHash, time.Minute) // from security.go
+			c.HTML(http.StatusNotFound, "file-share.html", gin.H{
+				"problem": "This download link has expired or the file was removed.",
+			})
+			return share, "", false
+		}
+		return share, passwordHash, true
+	}
+
+	r.GET("/files/:token", func(c *gin.Context) {
+		share, _, ok := load(c)
+		if !ok {
+			return
+		}
+		c.HTML(http.StatusOK, "file-share.html", gin.H{"share": share, "token": c.Param("token")})
+	})
+
+	// Downloads are a POST from the page, so link previews and mail
+	// scanners don't count as downloads
+	r.POST("/files/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		share, passwordHash, ok := load(c)
+		if !ok {
+			return
+		}
+		ipHash := hashIP(c.ClientIP())
+		if !fileShareLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, fileShareRateLimit) {
+			c.Header("Retry-After", "60")
+			c.HTML(http.StatusTooManyRequests, "file-share.html", gin.H{
+				"share":   share,
+				"token":   c.Param("token"),
+				"problem": "Too many attempts; please try again in a minute.",
+			})
+			return
+		}
+		if share.Protected && !checkSitePassword(passwordHash, c.PostForm("password")) { // from sites.go
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "file share password", ipHash, time.Minute)
+			c.HTML(http.StatusForbidden, "file-share.html", gin.H{
+				"share":   share,
+				"token":   c.Param("token"),
+				"problem": "That password isn't right.",
+			})
+			return
+		}
+
+		var data []byte
+		if err := dbQueryRow(ctx, "SELECT data FROM file_shares WHERE id = ?", share.ID).Scan(&data); err != nil {
+			log.Printf("Error reading file share %d: %v", share.ID, err)
+			c.String(http.StatusInternalServerError, "Failed to read the file")
+			return
+		}
+		downloadedAt := time.Now()
+		safeGoRetry("file-share-downloads", 3, func() error {
+			ctx := context.WithoutCancel(ctx)
+			if _, err := dbExec(ctx, "UPDATE file_shares SET downloads = downloads + 1, last_download_at = ? WHERE id = ?", downloadedAt, share.ID); err != nil {
+				return err
+			}
+			_, err := dbExec(ctx, "INSERT INTO file_share_downloads (share_id, ip_hash, created_at) VALUES (?, ?, ?)", share.ID, ipHash, downloadedAt)
+			return err
+		})
+		serveDownload(c, share.Filename, data) // from uploads.go
+	})
+}
+
+// Setup admin file share routes
+func setupFileShareAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/files", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		shares, err := getFileShares(ctx)
+		if err != nil {
+			log.Printf("Error loading file shares: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load file shares",
+			})
+			return
+		}
+		for i, s := range shares {
+			if !s.Expired() {
+				shares[i].URL = buildFileShareURL(c, fileShareToken(ctx, s.ID, s.ExpiresAt))
+			}
+		}
+		c.HTML(http.StatusOK, "admin-files.html", gin.H{
+			"shares":    shares,
+			"durations": fileShareDurations,
+			"message":   c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/files", func(c *gin.Context) {
+		days, _ := strconv.Atoi(c.PostForm("days"))
+		valid := false
+		for _, d := range fileShareDurations {
+			valid = valid || d == days
+		}
+		if !valid {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": "Choose how long the link works"})
+			return
+		}
+		data, filename, problem := uploadFromForm(c, "file", fileShareMaxBytes) // from uploads.go
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		passwordHash := ""
+		if password := c.PostForm("password"); password != "" {
+			var err error
+			if passwordHash, err = hashSitePassword(password); err != nil { // from sites.go
+				log.Printf("Error hashing file share password: %v", err)
+				c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{"error": "Failed to save the file"})
+				return
+			}
+		}
+
+		result, err := dbExec(c.Request.Context(), `
+			INSERT INTO file_shares (filename, note, data, size, password_hash, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, filename, strings.TrimSpace(c.PostForm("note")), data, len(data), passwordHash,
+			time.Now().AddDate(0, 0, days).Truncate(time.Second))
+		if err != nil {
+			log.Printf("Error saving file share: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{"error": "Failed to save the file"})
+			return
+		}
+		id, _ := result.LastInsertId()
+		log.Printf("File share %d created by admin from %s", id, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/files?message="+url.QueryEscape(filename+" shared")+"#share-"+strconv.FormatInt(id, 10))
+	})
+
+	// Deleting a share also stops its link working
+	adminGroup.POST("/files/:id/delete", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		_, err := dbExec(ctx, "DELETE FROM file_share_downloads WHERE share_id = ?", c.Param("id"))
+		if err == nil {
+			_, err = dbExec(ctx, "DELETE FROM file_shares WHERE id = ?", c.Param("id"))
+		}
+		if err != nil {
+			log.Printf("Error deleting file share: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete file share",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/files?message="+url.QueryEscape("File share deleted"))
+	})
+}
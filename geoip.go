@@ -0,0 +1,348 @@
+// geoip.go - Country lookups in a MaxMind database
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MaxMind DB (.mmdb) file, like GeoLite2-Country, read into memory. Only
+// what a country lookup needs is decoded: the search tree, and maps,
+// strings and numbers in the data section.
+type geoIPDatabase struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint // data section offset
+	ipv4Start  uint // node reached after the 96 zero bits of ::/96
+}
+
+// The database at GEOIP_DB_PATH, reopened when the file changes, since it's
+// meant to be replaced as MaxMind updates it (checked by diagnostics.go)
+var (
+	geoIPMu       sync.Mutex
+	geoIPDB       *geoIPDatabase
+	geoIPModTime  time.Time
+	geoIPFailedAt time.Time
+)
+
+// How often a database that fails to open is retried
+const geoIPRetryEvery = time.Minute
+
+var geoIPMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Two-letter country code for an IP, or "" when there's no database or no
+// match
+func geoIPCountry(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	geoDB := loadGeoIP()
+	if geoDB == nil {
+		return ""
+	}
+	record, err := geoDB.lookup(parsed)
+	if err != nil {
+		log.Printf("GeoIP lookup of %s failed: %v", hashIP(ip), err)
+		return ""
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]any)
+		if code, _ := country["iso_code"].(string); len(code) == 2 {
+			return strings.ToUpper(code)
+		}
+	}
+	return ""
+}
+
+// The configured database, opening it on first use and again after the
+// file is replaced
+func loadGeoIP() *geoIPDatabase {
+	path := os.Getenv("GEOIP_DB_PATH")
+	if path == "" {
+		return nil
+	}
+	geoIPMu.Lock()
+	defer geoIPMu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return geoIPDB // keep using what was loaded while the file is swapped
+	}
+	if geoIPDB != nil && info.ModTime().Equal(geoIPModTime) {
+		return geoIPDB
+	}
+	if time.Since(geoIPFailedAt) < geoIPRetryEvery {
+		return geoIPDB
+	}
+	geoDB, err := openGeoIP(path)
+	if err != nil {
+		log.Printf("Error opening GeoIP database %s: %v", path, err)
+		geoIPFailedAt = time.Now()
+		return geoIPDB
+	}
+	geoIPDB, geoIPModTime = geoDB, info.ModTime()
+	return geoIPDB
+}
+
+func openGeoIP(path string) (*geoIPDatabase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoIP(data)
+}
+
+// Read a database's metadata and find its sections
+func parseGeoIP(data []byte) (*geoIPDatabase, error) {
+	marker := bytes.LastIndex(data, geoIPMetadataMarker)
+	if marker < 0 {
+		return nil, errors.New("not a MaxMind database")
+	}
+	metaStart := uint(marker + len(geoIPMetadataMarker))
+	meta, _, err := (&geoIPDatabase{data: data}).decode(metaStart, metaStart, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	metadata, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata isn't a map")
+	}
+	number := func(key string) uint {
+		n, _ := metadata[key].(uint64)
+		return uint(n)
+	}
+
+	geoDB := &geoIPDatabase{
+		data:       data[:marker],
+		nodeCount:  number("node_count"),
+		recordSize: number("record_size"),
+		ipVersion:  number("ip_version"),
+	}
+	if geoDB.recordSize != 24 && geoDB.recordSize != 28 && geoDB.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", geoDB.recordSize)
+	}
+	treeSize := geoDB.nodeCount * geoDB.recordSize / 4
+	geoDB.dataStart = treeSize + 16 // the tree ends with 16 zero bytes
+	if geoDB.nodeCount == 0 || geoDB.dataStart > uint(len(geoDB.data)) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+
+	if geoDB.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < geoDB.nodeCount; i++ {
+			if node, err = geoDB.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		geoDB.ipv4Start = node
+	}
+	return geoDB, nil
+}
+
+// One of a search tree node's two records
+func (g *geoIPDatabase) record(node uint, bit uint) (uint, error) {
+	nodeBytes := g.recordSize / 4
+	offset := node * nodeBytes
+	if offset+nodeBytes > g.dataStart {
+		return 0, errors.New("search tree node out of range")
+	}
+	b := g.data[offset : offset+nodeBytes]
+	switch g.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// The data record for an IP, or nil when the database has none
+func (g *geoIPDatabase) lookup(ip net.IP) (map[string]any, error) {
+	node := uint(0)
+	bits := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = g.ipv4Start
+	} else if g.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < g.nodeCount; i++ {
+		var err error
+		if node, err = g.record(node, uint(bits[i/8]>>(7-i%8)&1)); err != nil {
+			return nil, err
+		}
+	}
+	if node <= g.nodeCount {
+		return nil, nil // no data, or the tree is deeper than the address
+	}
+
+	offset := node - g.nodeCount - 16 + g.dataStart
+	value, _, err := g.decode(offset, g.dataStart, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// Data field types, from the MaxMind DB format spec
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBool    = 14
+	mmdbFloat   = 15
+)
+
+// Decode the field at offset, returning it and the offset after it.
+// Pointers are relative to base. Unsigned numbers come back as uint64
+// (uint128s as their low 64 bits); depth guards against pointer loops.
+func (g *geoIPDatabase) decode(offset, base uint, depth int) (any, uint, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	next := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(g.data)) {
+			return nil, errors.New("data field out of range")
+		}
+		b := g.data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	ctrl, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind := uint(ctrl[0] >> 5)
+
+	if kind == mmdbPointer {
+		size := uint(ctrl[0]>>3) & 3
+		b, err := next(size + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		target := uint(0)
+		if size < 3 {
+			target = uint(ctrl[0] & 7)
+		}
+		for _, c := range b {
+			target = target<<8 | uint(c)
+		}
+		target += []uint{0, 2048, 526336, 0}[size]
+		value, _, err := g.decode(base+target, base, depth+1)
+		return value, offset, err
+	}
+
+	if kind == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+	}
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		b, err := next(size - 28)
+		if err != nil {
+			return nil, 0, err
+		}
+		extra := uint(0)
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[size-29] + extra
+	}
+
+	switch kind {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			var key, value any
+			if key, offset, err = g.decode(offset, base, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = g.decode(offset, base, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key isn't a string")
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var value any
+			if value, offset, err = g.decode(offset, base, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := next(size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch kind {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("bad double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("bad float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128, mmdbInt32:
+		if len(b) > 8 {
+			b = b[len(b)-8:]
+		}
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if kind == mmdbInt32 {
+			return int64(int32(uint32(n))), offset, nil
+		}
+		return n, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
@@ -0,0 +1,142 @@
+// geoip_test.go - GeoIP lookup tests
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Encode a data field header in the MaxMind DB format
+func mmdbHeader(kind, size int) []byte {
+	if kind <= 7 {
+		return []byte{byte(kind<<5 | size)}
+	}
+	return []byte{byte(size), byte(kind - 7)}
+}
+
+func mmdbStringField(s string) []byte {
+	return append(mmdbHeader(mmdbString, len(s)), s...)
+}
+
+func mmdbUintField(kind int, n uint64) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append(mmdbHeader(kind, len(b)), b...)
+}
+
+// Encode a map; values are already-encoded fields
+func mmdbMapField(keys []string, values [][]byte) []byte {
+	out := mmdbHeader(mmdbMap, len(keys))
+	for i, key := range keys {
+		out = append(out, mmdbStringField(key)...)
+		out = append(out, values[i]...)
+	}
+	return out
+}
+
+// A database with 24-bit records mapping one network to a country: a chain
+// of nodes down the network's bits, with every other branch empty
+func buildTestGeoIP(t *testing.T, network string, country string, ipVersion int) []byte {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, _ := ipNet.Mask.Size()
+	bits := []byte(ipNet.IP)
+	if ipVersion == 6 && len(bits) == net.IPv4len {
+		bits = append(make([]byte, 12), bits...)
+		prefix += 96
+	}
+
+	nodeCount := prefix
+	var tree []byte
+	for i := 0; i < nodeCount; i++ {
+		records := [2]int{nodeCount, nodeCount}
+		next := i + 1
+		if next == nodeCount {
+			next = nodeCount + 16 // data at offset 0
+		}
+		records[bits[i/8]>>(7-i%8)&1] = next
+		for _, r := range records {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	data := mmdbMapField([]string{"country"}, [][]byte{
+		mmdbMapField([]string{"iso_code"}, [][]byte{mmdbStringField(country)}),
+	})
+	metadata := mmdbMapField(
+		[]string{"node_count", "record_size", "ip_version"},
+		[][]byte{mmdbUintField(mmdbUint32, uint64(nodeCount)), mmdbUintField(mmdbUint16, 24), mmdbUintField(mmdbUint16, uint64(ipVersion))},
+	)
+
+	var file bytes.Buffer
+	file.Write(tree)
+	file.Write(make([]byte, 16))
+	file.Write(data)
+	file.Write(geoIPMetadataMarker)
+	file.Write(metadata)
+	return file.Bytes()
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		network   string
+		ipVersion int
+		ip        string
+		want      string
+	}{
+		{"v4 database", "203.0.113.0/24", 4, "203.0.113.9", "de"},
+		{"v4 database, other network", "203.0.113.0/24", 4, "198.51.100.1", ""},
+		{"v4 database, v6 address", "203.0.113.0/24", 4, "2001:db8::1", ""},
+		{"v6 database, v4 address", "203.0.113.0/24", 6, "203.0.113.200", "de"},
+		{"v6 database, v6 address", "2001:db8::/32", 6, "2001:db8:1::5", "de"},
+		{"v6 database, other v6 address", "2001:db8::/32", 6, "2001:db9::5", ""},
+	}
+	for _, tt := range tests {
+		geoDB, err := parseGeoIP(buildTestGeoIP(t, tt.network, "de", tt.ipVersion))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		record, err := geoDB.lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := ""
+		if country, ok := record["country"].(map[string]any); ok {
+			got, _ = country["iso_code"].(string)
+		}
+		if got != tt.want {
+			t.Errorf("%s: lookup(%s) country = %q, want %q", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGeoIPCountryFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, buildTestGeoIP(t, "203.0.113.0/24", "nz", 6), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GEOIP_DB_PATH", path)
+	if got := geoIPCountry("203.0.113.7"); got != "NZ" {
+		t.Errorf("geoIPCountry = %q, want NZ", got)
+	}
+	if got := geoIPCountry("192.0.2.1"); got != "" {
+		t.Errorf("geoIPCountry outside the network = %q, want none", got)
+	}
+}
+
+func TestParseGeoIPRejectsGarbage(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a database"), append([]byte{0xff}, geoIPMetadataMarker...)} {
+		if _, err := parseGeoIP(data); err == nil {
+			t.Errorf("parseGeoIP(%q) accepted", data)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+// graphql.go - Read-only GraphQL API for portfolio content
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Deepest selection nesting a query may use
+const graphQLMaxDepth = 6
+
+// Most a query may cost (see graphQLQueryCost). Top-level fields load from
+// the database and posts render their markdown, so they cost more than the
+// fields picked out of them; every field, once, costs 135.
+const (
+	graphQLMaxCost       = 250
+	graphQLRootFieldCost = 10
+)
+
+var graphQLSchema graphql.Schema
+
+// GraphQL request body (Apollo-compatible, including persisted queries)
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    struct {
+		PersistedQuery *struct {
+			Version    int    `json:"version"`
+			SHA256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// Build the schema and persisted query storage
+func initGraphQL() {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS persisted_queries (
+		hash TEXT PRIMARY KEY,
+		query TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatal("Failed to create persisted_queries table:", err)
+	}
+
+	projectType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Project",
+		Fields: graphql.Fields{
+			"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"slug":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"title":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"summary":   &graphql.Field{Type: graphql.String},
+			"imagePath": &graphql.Field{Type: graphql.String},
+			"url":       &graphql.Field{Type: graphql.String},
+			"tech":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+
+	experienceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Experience",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"kind":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"title":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"organization": &graphql.Field{Type: graphql.String},
+			"startDate":    &graphql.Field{Type: graphql.String},
+			"endDate":      &graphql.Field{Type: graphql.String},
+			"logoPath":     &graphql.Field{Type: graphql.String},
+			"url":          &graphql.Field{Type: graphql.String},
+			"bullets":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+
+	postType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"slug":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"title":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"summary":     &graphql.Field{Type: graphql.String},
+			"body":        &graphql.Field{Type: graphql.String},
+			"html":        &graphql.Field{Type: graphql.String},
+			"url":         &graphql.Field{Type: graphql.String},
+			"publishedAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	// Only aggregate and per-code numbers - destinations stay private
+	linkType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Link",
+		Fields: graphql.Fields{
+			"code":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"clicks":    &graphql.Field{Type: graphql.Int},
+			"createdAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	linkStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LinkStats",
+		Fields: graphql.Fields{
+			"totalLinks":  &graphql.Field{Type: graphql.Int},
+			"totalClicks": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"projects": &graphql.Field{
+				Type: graphql.NewList(projectType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					projects, err := getProjects(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					var out []map[string]interface{}
+					for _, project := range projects {
+						out = append(out, projectToGraphQL(project))
+					}
+					return out, nil
+				},
+			},
+			"project": &graphql.Field{
+				Type: projectType,
+				Args: graphql.FieldConfigArgument{
+					"slug": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					slug, _ := p.Args["slug"].(string)
+					projects, err := getProjects(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					for _, project := range projects {
+						if project.Slug == slug {
+							return projectToGraphQL(project), nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			"posts": &graphql.Field{
+				Type: graphql.NewList(postType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					posts, err := getPublishedPosts(p.Context)
+					if err != nil {
+						return nil, err
+					}
+					var out []map[string]interface{}
+					for _, post := range posts {
+						out = append(out, postToGraphQL(post))
+					}
+					return out, nil
+				},
+			},
+			"post": &graphql.Field{
+				Type: postType,
+				Args: graphql.FieldConfigArgument{
+					"slug": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					slug, _ := p.Args["slug"].(string)
+					post, err := getPublishedPost(p.Context, slug)
+					if err == sql.ErrNoRows {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return postToGraphQL(post), nil
+				},
+			},
+			"workExperience": &graphql.Field{
+				Type:    graphql.NewList(experienceType),
+				Resolve: experienceResolver(experienceWork),
+			},
+			"education": &graphql.Field{
+				Type:    graphql.NewList(experienceType),
+				Resolve: experienceResolver(experienceEducation),
+			},
+			"linkStats": &graphql.Field{
+				Type: linkStatsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var totalLinks, totalClicks int
+					err := dbQueryRow(p.Context, "SELECT COUNT(*), COALESCE(SUM(clicks), 0) FROM urls").Scan(&totalLinks, &totalClicks)
+					if err != nil {
+						return nil, err
+					}
+					return map[string]interface{}{"totalLinks": totalLinks, "totalClicks": totalClicks}, nil
+				},
+			},
+			"link": &graphql.Field{
+				Type: linkType,
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					code, _ := p.Args["code"].(string)
+					var link URLStat
+					err := dbQueryRow(p.Context, `
+						SELECT short_code, created_at, COALESCE(clicks, 0)
+						FROM urls WHERE short_code = ?
+					`, code).Scan(&link.ShortCode, &link.CreatedAt, &link.Clicks)
+					if err == sql.ErrNoRows {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return map[string]interface{}{"code": link.ShortCode, "clicks": link.Clicks, "createdAt": link.CreatedAt}, nil
+				},
+			},
+		},
+	})
+
+	graphQLSchema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		log.Fatal("Failed to build GraphQL schema:", err)
+	}
+}
+
+func projectToGraphQL(p Project) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        p.ID,
+		"slug":      p.Slug,
+		"title":     p.Title,
+		"summary":   p.Summary,
+		"imagePath": p.ImagePath,
+		"url":       p.URL,
+		"tech":      p.Tech,
+	}
+}
+
+func postToGraphQL(p Post) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          p.ID,
+		"slug":        p.Slug,
+		"title":       p.Title,
+		"summary":     p.Summary,
+		"body":        p.Body,
+		"html":        string(p.HTML()),
+		"url":         p.Permalink(),
+		"publishedAt": p.Date(),
+	}
+}
+
+func experienceResolver(kind string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		experiences, err := getExperiences(p.Context, kind)
+		if err != nil {
+			return nil, err
+		}
+		var out []map[string]interface{}
+		for _, e := range experiences {
+			out = append(out, map[string]interface{}{
+				"id":           e.ID,
+				"kind":         e.Kind,
+				"title":        e.Title,
+				"organization": e.Organization,
+				"startDate":    e.StartDate,
+				"endDate":      e.EndDate,
+				"logoPath":     e.LogoPath,
+				"url":          e.URL,
+				"bullets":      e.Bullets,
+			})
+		}
+		return out, nil
+	}
+}
+
+// Measure how deeply a query nests selections, following fragments
+func graphQLQueryDepth(doc *ast.Document) int {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+
+	var depthOf func(set *ast.SelectionSet, visited map[string]bool) int
+	depthOf = func(set *ast.SelectionSet, visited map[string]bool) int {
+		if set == nil {
+			return 0
+		}
+		max := 0
+		for _, sel := range set.Selections {
+			d := 0
+			switch s := sel.(type) {
+			case *ast.Field:
+				d = 1 + depthOf(s.SelectionSet, visited)
+			case *ast.InlineFragment:
+				d = depthOf(s.SelectionSet, visited)
+			case *ast.FragmentSpread:
+				name := s.Name.Value
+				if frag, ok := fragments[name]; ok && !visited[name] {
+					visited[name] = true
+					d = depthOf(frag.SelectionSet, visited)
+					delete(visited, name)
+				}
+			}
+			if d > max {
+				max = d
+			}
+		}
+		return max
+	}
+
+	max := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			if d := depthOf(op.SelectionSet, make(map[string]bool)); d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// Add up a query's cost: graphQLRootFieldCost per top-level field and one
+// per field below, counting every alias and every use of a fragment.
+// Counting stops once past limit, so fragments spread inside fragments
+// can't make it slow.
+func graphQLQueryCost(doc *ast.Document, limit int) int {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+
+	cost := 0
+	var add func(set *ast.SelectionSet, root bool, visited map[string]bool)
+	add = func(set *ast.SelectionSet, root bool, visited map[string]bool) {
+		if set == nil {
+			return
+		}
+		for _, sel := range set.Selections {
+			if cost > limit {
+				return
+			}
+			switch s := sel.(type) {
+			case *ast.Field:
+				if root {
+					cost += graphQLRootFieldCost
+				} else {
+					cost++
+				}
+				add(s.SelectionSet, false, visited)
+			case *ast.InlineFragment:
+				add(s.SelectionSet, root, visited)
+			case *ast.FragmentSpread:
+				name := s.Name.Value
+				if frag, ok := fragments[name]; ok && !visited[name] {
+					visited[name] = true
+					add(frag.SelectionSet, root, visited)
+					delete(visited, name)
+				}
+			}
+		}
+	}
+
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			add(op.SelectionSet, true, make(map[string]bool))
+		}
+	}
+	return cost
+}
+
+// Resolve the query text, loading persisted queries. Only clients with an
+// API token (from api.go) register new ones, so anonymous clients can't
+// fill the table; their hashed queries still run, just unsaved.
+func resolvePersistedQuery(ctx context.Context, req *graphQLRequest, canRegister bool) (string, error) {
+	pq := req.Extensions.PersistedQuery
+	if pq == nil {
+		return req.Query, nil
+	}
+
+	if req.Query != "" {
+		sum := sha256.Sum256([]byte(req.Query))
+		if hex.EncodeToString(sum[:]) != pq.SHA256Hash {
+			return "", fmt.Errorf("provided sha does not match query")
+		}
+		if !canRegister {
+			return req.Query, nil
+		}
+		_, err := dbExec(ctx, "INSERT OR IGNORE INTO persisted_queries (hash, query) VALUES (?, ?)", pq.SHA256Hash, req.Query)
+		if err != nil {
+			log.Printf("Error saving persisted query: %v", err)
+		}
+		return req.Query, nil
+	}
+
+	var query string
+	err := dbQueryRow(ctx, "SELECT query FROM persisted_queries WHERE hash = ?", pq.SHA256Hash).Scan(&query)
+	if err != nil {
+		return "", fmt.Errorf("PersistedQueryNotFound")
+	}
+	return query, nil
+}
+
+// Handle GraphQL over GET (query string) or POST (JSON body)
+func graphQLHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req graphQLRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if v := c.Query("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "variables must be JSON"}}})
+				return
+			}
+		}
+		if v := c.Query("extensions"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Extensions); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "extensions must be JSON"}}})
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "invalid request body"}}})
+		return
+	}
+
+	canRegister := false
+	if req.Extensions.PersistedQuery != nil && req.Query != "" {
+		_, _, canRegister = lookupAPIToken(ctx, requestAPIToken(c)) // from api.go
+	}
+	query, err := resolvePersistedQuery(ctx, &req, canRegister)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"errors": []gin.H{{"message": err.Error()}}})
+		return
+	}
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "query is required"}}})
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": err.Error()}}})
+		return
+	}
+	if depth := graphQLQueryDepth(doc); depth > graphQLMaxDepth {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{
+			"message": fmt.Sprintf("query depth %d exceeds maximum of %d", depth, graphQLMaxDepth),
+		}}})
+		return
+	}
+	if cost := graphQLQueryCost(doc, graphQLMaxCost); cost > graphQLMaxCost {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{
+			"message": fmt.Sprintf("query cost exceeds maximum of %d; ask for fewer fields or aliases", graphQLMaxCost),
+		}}})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphQLSchema,
+		RequestString:  query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        c.Request.Context(),
+	})
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,269 @@
+// httpclient.go - Shared client for outgoing requests
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Outgoing request limits
+const (
+	httpMaxRedirects   = 5
+	httpMaxRetries     = 2                      // retries after the first attempt, for GET and HEAD only
+	httpRetryBaseDelay = 500 * time.Millisecond // doubled per retry, plus up to as much again in jitter
+	httpMaxRetryAfter  = 10 * time.Second       // longest Retry-After honoured before giving up
+	httpHostInterval   = 500 * time.Millisecond // minimum gap between requests to one host
+)
+
+// Transports shared by every client, so connections to a host are reused.
+// The guarded one is the default: it refuses to connect to internal
+// addresses (see publicIP), checked on the resolved address at dial time so
+// redirects and DNS rebinding can't get around it. It ignores proxy
+// settings, as a proxy would do the connecting instead.
+var (
+	httpTransport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	}
+	guardedTransport = &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: refuseInternalDial}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	}
+)
+
+// Returned when a request would reach an internal address
+var errInternalAddress = errors.New("refusing to connect to an internal address")
+
+// Ranges publicIP refuses beyond what net.IP reports as loopback, private,
+// link-local, multicast or unspecified
+var internalNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this" network
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved, and broadcast
+		"64:ff9b::/96",  // NAT64, which can reach IPv4 internal ranges
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// Whether an address is on the public internet, rather than this machine,
+// the local network or a cloud metadata service (169.254.169.254 and
+// fd00:ec2::254 are link-local and private)
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dialer hook for guardedTransport; address is already resolved
+func refuseInternalDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicIP(net.ParseIP(host)) {
+		return fmt.Errorf("%w: %s", errInternalAddress, host)
+	}
+	return nil
+}
+
+// Whether a URL's host obviously names an internal machine: an internal IP
+// literal or a name reserved for local use. Names that only resolve to one
+// are caught when guardedTransport dials.
+func internalHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return !publicIP(ip)
+	}
+	if host == "localhost" || !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal", ".home.arpa"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refuse a URL before fetching it when its host is plainly internal, so the
+// caller gets a clear error rather than a failed dial
+func checkPublicURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if internalHost(parsed.Hostname()) {
+		return fmt.Errorf("%w: %s", errInternalAddress, parsed.Hostname())
+	}
+	return nil
+}
+
+// Client for one feature's outgoing requests, to URLs visitors or other
+// sites may choose, so internal addresses are refused. purpose goes in the
+// User-Agent, so site owners can tell what is fetching from them; timeout
+// covers the whole request, retries included.
+func newHTTPClient(purpose string, timeout time.Duration) *http.Client {
+	return newClient(purpose, timeout, guardedTransport)
+}
+
+// Client for endpoints the owner configured, like a self-hosted webhook,
+// which may well be on the local network
+func newTrustedHTTPClient(purpose string, timeout time.Duration) *http.Client {
+	return newClient(purpose, timeout, httpTransport)
+}
+
+func newClient(purpose string, timeout time.Duration, transport *http.Transport) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &outboundTransport{purpose: purpose, transport: transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= httpMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", httpMaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+type outboundTransport struct {
+	purpose   string
+	transport *http.Transport
+}
+
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", "Zach-Dev "+t.purpose+" (+"+siteBaseURL()+")") // from seo.go
+	}
+	// Requests with bodies may not be safe to repeat
+	retries := httpMaxRetries
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := waitForHost(req.Context(), req.URL.Host); err != nil {
+			return nil, err
+		}
+		resp, err := t.transport.RoundTrip(req)
+		if attempt >= retries || !retryable(resp, err) || errors.Is(err, errInternalAddress) {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > httpMaxRetryAfter {
+					return resp, nil
+				}
+				delay = after
+			}
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Whether an attempt failed in a way worth repeating: a network error,
+// rate limiting, or a server error
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+}
+
+// Exponential backoff with jitter, so retries from several callers spread out
+func retryDelay(attempt int) time.Duration {
+	delay := httpRetryBaseDelay << attempt
+	return delay + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// Delay asked for by a Retry-After header, in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+var (
+	hostSlotsMu sync.Mutex
+	hostSlots   = map[string]time.Time{} // host -> earliest next request
+)
+
+// Wait for the host's next free slot, so no feature hammers one site
+func waitForHost(ctx context.Context, host string) error {
+	hostSlotsMu.Lock()
+	now := time.Now()
+	slot := hostSlots[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	hostSlots[host] = slot.Add(httpHostInterval)
+	// Forget hosts that haven't been contacted for a while
+	if len(hostSlots) > 1000 {
+		for h, next := range hostSlots {
+			if next.Before(now) {
+				delete(hostSlots, h)
+			}
+		}
+	}
+	hostSlotsMu.Unlock()
+
+	wait := time.Until(slot)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,77 @@
+// httpclient_test.go - Internal address refusal tests
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fd00:ec2::254", false},
+		{"100.64.0.1", false}, // carrier-grade NAT
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestInternalHost(t *testing.T) {
+	for host, internal := range map[string]bool{
+		"example.com":     false,
+		"93.184.216.34":   false,
+		"localhost":       true,
+		"LOCALHOST.":      true,
+		"app.localhost":   true,
+		"printer.local":   true,
+		"db.internal":     true,
+		"intranet":        true,
+		"127.0.0.1":       true,
+		"[::1]":           true,
+		"169.254.169.254": true,
+	} {
+		if got := internalHost(host); got != internal {
+			t.Errorf("internalHost(%q) = %v, want %v", host, got, internal)
+		}
+	}
+}
+
+func TestGuardedClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	_, err := newHTTPClient("test", 5*time.Second).Get(server.URL)
+	if !errors.Is(err, errInternalAddress) {
+		t.Fatalf("guarded client: got %v, want errInternalAddress", err)
+	}
+
+	resp, err := newTrustedHTTPClient("test", 5*time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("trusted client: %v", err)
+	}
+	resp.Body.Close()
+}
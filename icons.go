@@ -0,0 +1,351 @@
+// icons.go - Favicon and PWA icons generated from one uploaded image
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	_ "image/gif" // accepted source formats
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+)
+
+// Generated icon: square PNG of the given size, or the multi-size ICO
+type iconSpec struct {
+	Name     string
+	Size     int
+	Maskable bool // drawn inside the safe zone on the background color
+	InICO    bool // also packed into favicon.ico
+}
+
+var iconSpecs = []iconSpec{
+	{Name: "favicon-16x16.png", Size: 16, InICO: true},
+	{Name: "favicon-32x32.png", Size: 32, InICO: true},
+	{Name: "favicon-48x48.png", Size: 48, InICO: true},
+	{Name: "apple-touch-icon.png", Size: 180},
+	{Name: "icon-192.png", Size: 192},
+	{Name: "icon-512.png", Size: 512},
+	{Name: "icon-maskable-512.png", Size: 512, Maskable: true},
+}
+
+// Smallest source that scales down well to every size
+const iconSourceMinSize = 512
+
+// Fallback served at /favicon.ico until icons are generated
+const defaultFaviconPath = "./images/favicon.ico"
+
+// Default background for maskable icons and the browser theme color
+const defaultIconBackground = "#00091d"
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Matches the favicon link each page template starts with
+var faviconLinkPattern = regexp.MustCompile(`<link rel="icon"[^>]*>`)
+
+// Initialize icon storage
+func initIcons() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS site_icons (
+		name TEXT PRIMARY KEY,
+		content_type TEXT NOT NULL,
+		data BLOB NOT NULL,
+		version TEXT NOT NULL,
+		background TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create site_icons table:", err)
+	}
+}
+
+// Version and background color of the generated icons; empty version
+// when the site uses the default favicon
+func iconVersion(ctx context.Context) (string, string, error) {
+	var version, background string
+	err := dbQueryRow(ctx, "SELECT version, background FROM site_icons WHERE name = 'favicon.ico'").Scan(&version, &background)
+	if err == sql.ErrNoRows {
+		return "", defaultIconBackground, nil
+	}
+	return version, background, err
+}
+
+// Crop to the centered square and scale to size
+func scaleIcon(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst
+}
+
+// Maskable icons keep their content in the central 80% circle, so the
+// image is shrunk onto a solid background
+func maskableIcon(src image.Image, size int, background color.Color) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	inset := size / 10
+	draw.Draw(dst, dst.Bounds().Inset(inset), scaleIcon(src, size-2*inset), image.Point{}, draw.Over)
+	return dst
+}
+
+// Pack PNG images into an ICO file; entries point at embedded PNG data,
+// which every current browser reads
+func encodeICO(sizes []int, pngs [][]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(pngs))})
+	offset := 6 + 16*len(pngs)
+	for i, data := range pngs {
+		dim := byte(sizes[i] % 256) // 0 means 256
+		binary.Write(&buf, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved byte
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(data)), uint32(offset)})
+		offset += len(data)
+	}
+	for _, data := range pngs {
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func parseHexColor(value string) color.RGBA {
+	var c color.RGBA
+	fmt.Sscanf(value, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	c.A = 0xff
+	return c
+}
+
+// Generate every icon from the source image and replace the stored set
+func generateIcons(ctx context.Context, source []byte, background string) error {
+	src, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return fmt.Errorf("not a PNG, JPEG or GIF image")
+	}
+	if b := src.Bounds(); min(b.Dx(), b.Dy()) < iconSourceMinSize {
+		return fmt.Errorf("the image must be at least %dx%d pixels", iconSourceMinSize, iconSourceMinSize)
+	}
+
+	hash := sha256.New()
+	hash.Write(source)
+	hash.Write([]byte(background))
+	version := hex.EncodeToString(hash.Sum(nil)[:6])
+
+	icons := map[string][]byte{}
+	var icoSizes []int
+	var icoImages [][]byte
+	for _, spec := range iconSpecs {
+		var img image.Image
+		if spec.Maskable {
+			img = maskableIcon(src, spec.Size, parseHexColor(background))
+		} else {
+			img = scaleIcon(src, spec.Size)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		icons[spec.Name] = buf.Bytes()
+		if spec.InICO {
+			icoSizes = append(icoSizes, spec.Size)
+			icoImages = append(icoImages, buf.Bytes())
+		}
+	}
+
+	tx, cancel, err := dbBegin(ctx) // from dbctx.go
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM site_icons"); err != nil {
+		return err
+	}
+	insert := "INSERT INTO site_icons (name, content_type, data, version, background) VALUES (?, ?, ?, ?, ?)"
+	for name, data := range icons {
+		if _, err := tx.ExecContext(ctx, insert, name, "image/png", data, version, background); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, insert, "favicon.ico", "image/x-icon", encodeICO(icoSizes, icoImages), version, background); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Link tags for the generated icons, cache-busted by version
+func iconLinkTags(version, background string) []byte {
+	v := "?v=" + version
+	return []byte(`<link rel="icon" href="/favicon.ico` + v + `" sizes="48x48">
+    <link rel="icon" type="image/png" sizes="32x32" href="/icons/favicon-32x32.png` + v + `">
+    <link rel="icon" type="image/png" sizes="16x16" href="/icons/favicon-16x16.png` + v + `">
+    <link rel="apple-touch-icon" sizes="180x180" href="/icons/apple-touch-icon.png` + v + `">
+    <link rel="manifest" href="/site.webmanifest` + v + `">
+    <meta name="theme-color" content="` + template.HTMLEscapeString(background) + `">`)
+}
+
+// Swap the templates' default favicon link for the generated icons; pages
+// keep the default until icons have been generated
+func insertIconLinks(ctx context.Context, page []byte) []byte {
+	version, background, err := iconVersion(ctx)
+	if err != nil {
+		log.Printf("Error loading icon version: %v", err)
+		return page
+	}
+	if version == "" {
+		return page
+	}
+	loc := faviconLinkPattern.FindIndex(page)
+	if loc == nil {
+		return page
+	}
+	tags := iconLinkTags(version, background)
+	out := make([]byte, 0, len(page)+len(tags))
+	out = append(out, page[:loc[0]]...)
+	out = append(out, tags...)
+	return append(out, page[loc[1]:]...)
+}
+
+// Serve a stored icon; URLs carry the version, so they never change
+func serveIcon(c *gin.Context, name string) bool {
+	var contentType string
+	var data []byte
+	err := dbQueryRow(c.Request.Context(), "SELECT content_type, data FROM site_icons WHERE name = ?", name).Scan(&contentType, &data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error loading icon %s: %v", name, err)
+		}
+		return false
+	}
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Data(http.StatusOK, contentType, data)
+	return true
+}
+
+// Setup icon routes
+func setupIconRoutes(r *gin.Engine) {
+	r.GET("/icons/:name", func(c *gin.Context) {
+		if !serveIcon(c, c.Param("name")) {
+			c.AbortWithStatus(http.StatusNotFound)
+		}
+	})
+
+	r.GET("/favicon.ico", func(c *gin.Context) {
+		if !serveIcon(c, "favicon.ico") {
+			c.Header("Cache-Control", "public, max-age=86400")
+			c.File(defaultFaviconPath)
+		}
+	})
+
+	r.GET("/site.webmanifest", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		version, background, err := iconVersion(ctx)
+		if err != nil || version == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		v := "?v=" + version
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Header("Content-Type", "application/manifest+json")
+		c.JSON(http.StatusOK, gin.H{
+			"name":             "Zach-Dev",
+			"short_name":       "Zach-Dev",
+			"start_url":        "/",
+			"display":          "standalone",
+			"background_color": background,
+			"theme_color":      background,
+			"icons": []gin.H{
+				{"src": "/icons/icon-192.png" + v, "sizes": "192x192", "type": "image/png"},
+				{"src": "/icons/icon-512.png" + v, "sizes": "512x512", "type": "image/png"},
+				{"src": "/icons/icon-maskable-512.png" + v, "sizes": "512x512", "type": "image/png", "purpose": "maskable"},
+			},
+		})
+	})
+}
+
+// Setup admin icon routes
+func setupIconAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/icons", func(c *gin.Context) {
+		version, background, err := iconVersion(c.Request.Context())
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load icons",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-icons.html", gin.H{
+			"version":    version,
+			"background": background,
+			"specs":      iconSpecs,
+			"minSize":    iconSourceMinSize,
+		})
+	})
+
+	adminGroup.POST("/icons", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		background := strings.TrimSpace(c.DefaultPostForm("background", defaultIconBackground))
+		if !hexColorPattern.MatchString(background) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "The background must be a color like #00091d",
+			})
+			return
+		}
+
+		file, _, err := c.Request.FormFile("source")
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Choose an image to generate the icons from",
+			})
+			return
+		}
+		defer file.Close()
+		var source bytes.Buffer
+		if _, err := source.ReadFrom(file); err != nil {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Could not read the uploaded image",
+			})
+			return
+		}
+
+		if err := generateIcons(ctx, source.Bytes(), strings.ToLower(background)); err != nil {
+			log.Printf("Error generating icons: %v", err)
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Could not generate icons: " + err.Error(),
+			})
+			return
+		}
+
+		log.Printf("Icons regenerated by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/icons")
+	})
+
+	// Go back to the default favicon
+	adminGroup.DELETE("/icons", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if _, err := dbExec(ctx, "DELETE FROM site_icons"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove icons"})
+			return
+		}
+
+		log.Printf("Icons removed by admin from %s", hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Icons removed"})
+	})
+}
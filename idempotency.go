@@ -0,0 +1,141 @@
+// idempotency.go - Idempotency keys for API link creation
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+// A key is remembered this long after its link was created; a retry within
+// it gets the same link back, a later one creates a new link
+const (
+	idempotencyWindow       = 24 * time.Hour
+	idempotencyKeyMaxLength = 255
+	idempotencyCleanupEvery = time.Hour
+)
+
+// The key was used before for a different request
+var errIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+
+// Initialize idempotency key storage and its cleanup. Keys belong to the API
+// token that sent them and point at the link they created.
+func initIdempotencyKeys() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS api_idempotency_keys (
+		token_id INTEGER NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		short_code TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (token_id, idempotency_key)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create api_idempotency_keys table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_idempotency_keys_created ON api_idempotency_keys (created_at)`)
+
+	startWorker("idempotency-cleanup", idempotencyCleanupWorker) // from safego.go
+}
+
+func idempotencyCleanupWorker() {
+	ticker := time.NewTicker(idempotencyCleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeIdempotencyKeys(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("idempotency-cleanup", idempotencyCleanupEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Forget keys past the replay window
+func purgeIdempotencyKeys(ctx context.Context) error {
+	result, err := dbExec(ctx, "DELETE FROM api_idempotency_keys WHERE created_at < ?", time.Now().UTC().Add(-idempotencyWindow))
+	if err != nil {
+		log.Printf("Error purging idempotency keys: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Idempotency cleanup: removed %d expired keys", n)
+	}
+	return nil
+}
+
+// Fingerprint of what a link creation asked for, to tell a retry from a
+// different request sent with the same key
+func idempotencyRequestHash(originalURL string) string {
+	sum := sha256.Sum256([]byte(originalURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// Save a new link under a token's idempotency key, in one transaction so
+// neither is kept without the other. If the key was already used within
+// the window, nothing is saved and the code of the link it created is
+// returned with replayed set, or errIdempotencyMismatch when the earlier
+// request differed.
+func saveURLIdempotent(ctx context.Context, tokenID int, key, shortCode, originalURL string) (code string, replayed bool, err error) {
+	requestHash := idempotencyRequestHash(originalURL)
+	now := time.Now().UTC()
+
+	tx, err := dbFor(ctx).BeginTx(ctx, nil) // from sites.go
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	// An expired key is free to be used again, even before cleanup runs
+	_, err = tx.ExecContext(ctx, "DELETE FROM api_idempotency_keys WHERE token_id = ? AND idempotency_key = ? AND created_at < ?",
+		tokenID, key, now.Add(-idempotencyWindow))
+	if err != nil {
+		return "", false, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO api_idempotency_keys (token_id, idempotency_key, request_hash, short_code, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, tokenID, key, requestHash, shortCode, now)
+	if err != nil {
+		return "", false, err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		var storedHash string
+		err := tx.QueryRowContext(ctx, "SELECT request_hash, short_code FROM api_idempotency_keys WHERE token_id = ? AND idempotency_key = ?",
+			tokenID, key).Scan(&storedHash, &code)
+		if err != nil {
+			return "", false, err
+		}
+		if storedHash != requestHash {
+			return "", false, errIdempotencyMismatch
+		}
+		return code, true, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO urls (short_code, original_url) VALUES (?, ?)", shortCode, originalURL); err != nil {
+		return "", false, err
+	}
+	return shortCode, false, tx.Commit()
+}
+
+// A link as the API returns it; sql.ErrNoRows once it's deleted
+func getLinkForAPI(ctx context.Context, shortCode string) (URLStat, error) {
+	link := URLStat{ShortCode: shortCode}
+	err := dbQueryRow(ctx, "SELECT original_url, created_at, COALESCE(clicks, 0) FROM urls WHERE short_code = ?", shortCode).
+		Scan(&link.OriginalURL, &link.CreatedAt, &link.Clicks)
+	return link, err
+}
@@ -0,0 +1,444 @@
+// imageproxy.go - Resizing image proxy at /img
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // accepted source formats
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+// Widths images are resized to; a requested width rounds up to the next,
+// so the cache holds a few sizes per image rather than one per pixel
+var imageProxyWidths = []int{64, 128, 256, 320, 480, 640, 800, 1024, 1280, 1600, 2048}
+
+// Source limits, against huge downloads and decompression bombs
+const (
+	imageProxyMaxBytes  = 10 << 20
+	imageProxyMaxPixels = 40_000_000
+)
+
+// Resized images are kept this long, and browsers may cache them as long
+const imageCacheTTL = 7 * 24 * time.Hour
+
+const imageCacheCleanupEvery = 24 * time.Hour
+
+// Cache misses each client may cause per minute; resizing isn't cheap
+const imageProxyRateLimit = 30
+
+var imageProxyLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// Remote hosts anyone may proxy, separated by spaces, commas or newlines
+const imageProxyHostsSetting = "image_proxy_hosts"
+
+// Signing key for links to images the owner chose, which may point
+// anywhere public
+const imageProxySecretSetting = "image_proxy_secret"
+
+// Local directories images may come from, as served by main.go
+var imageProxyLocalDirs = []string{"/images/", "/static/"}
+
+// Source types accepted, and the format each is cached as. GIFs lose any
+// animation; WebP becomes JPEG for older browsers.
+var imageProxyTypes = map[string]string{
+	"image/png":  "png",
+	"image/gif":  "png",
+	"image/jpeg": "jpeg",
+	"image/webp": "jpeg",
+}
+
+var (
+	imageProxyClient  = newHTTPClient("image proxy", 15*time.Second) // from httpclient.go
+	imageProxyFlights singleflight.Group
+)
+
+// Initialize the signing key and the cache cleanup
+func initImageProxy() {
+	if getSetting(imageProxySecretSetting, "") == "" { // from settings.go
+		if err := setSetting(context.Background(), imageProxySecretSetting, generateAdminToken()); err != nil {
+			log.Fatal("Failed to store image proxy secret:", err)
+		}
+	}
+	startWorker("image-cache-cleanup", imageCacheCleanupWorker) // from safego.go
+}
+
+// Where resized images are kept: IMAGE_CACHE_DIR, or next to the database
+func imageCacheDir() string {
+	if dir := os.Getenv("IMAGE_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(databasePath()), "image-cache") // from main.go
+}
+
+func imageProxySignature(src string) string {
+	mac := hmac.New(sha256.New, []byte(getSetting(imageProxySecretSetting, "")))
+	mac.Write([]byte("image:" + src))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Signed /img URL for an image at most width wide; the imgURL template
+// helper, for images the owner chose. Empty sources stay empty.
+func imageProxyLink(src string, width int) string {
+	if src == "" {
+		return ""
+	}
+	return "/img?" + url.Values{"src": {src}, "w": {strconv.Itoa(width)}, "s": {imageProxySignature(src)}}.Encode()
+}
+
+// Unsigned /img URL for an image from elsewhere, like a bookmarked page's
+// og:image, or "" unless it's local or on an allowed host; the
+// allowedImgURL template helper. Signing these would let whoever controls
+// the page point the proxy anywhere.
+func imageProxyAllowedLink(src string, width int) string {
+	if _, local := imageProxyLocalPath(src); !local {
+		parsed, err := url.Parse(src)
+		if err != nil || !isValidLongURL(src) || !imageProxyHostAllowed(parsed.Hostname()) { // from main.go
+			return ""
+		}
+	}
+	return "/img?" + url.Values{"src": {src}, "w": {strconv.Itoa(width)}}.Encode()
+}
+
+// Remote hosts allowed without a signature
+func imageProxyHosts() []string {
+	return strings.FieldsFunc(strings.ToLower(getSetting(imageProxyHostsSetting, "")), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+func imageProxyHostAllowed(host string) bool {
+	return oneOf(strings.ToLower(host), imageProxyHosts()) // from leads.go
+}
+
+// Cleaned local path of src, if it's under one of the image directories
+func imageProxyLocalPath(src string) (string, bool) {
+	if !strings.HasPrefix(src, "/") || strings.HasPrefix(src, "//") {
+		return "", false
+	}
+	clean := path.Clean(src)
+	for _, dir := range imageProxyLocalDirs {
+		if strings.HasPrefix(clean, dir) {
+			return "." + clean, true
+		}
+	}
+	return "", false
+}
+
+// Smallest standard width at least as wide as asked for
+func imageProxyWidth(requested int) int {
+	for _, w := range imageProxyWidths {
+		if w >= requested {
+			return w
+		}
+	}
+	return imageProxyWidths[len(imageProxyWidths)-1]
+}
+
+// Cached file for a source and width, by format
+func imageCachePath(src string, width int, format string) string {
+	sum := sha256.Sum256([]byte(src + "|" + strconv.Itoa(width)))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(imageCacheDir(), key[:2], key+"."+format)
+}
+
+// A cached resize that's still fresh, with its content type
+func readImageCache(src string, width int) ([]byte, string, bool) {
+	for _, format := range []string{"png", "jpeg"} {
+		file := imageCachePath(src, width, format)
+		info, err := os.Stat(file)
+		if err != nil || time.Since(info.ModTime()) > imageCacheTTL {
+			continue
+		}
+		if data, err := os.ReadFile(file); err == nil {
+			return data, "image/" + format, true
+		}
+	}
+	return nil, "", false
+}
+
+// Write a resize to the cache, atomically so readers never see half a file
+func writeImageCache(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// An error with the status to answer it with
+type imageProxyError struct {
+	status  int
+	message string
+}
+
+func (e imageProxyError) Error() string { return e.message }
+
+// Check a remote source, and every redirect from it, before it's fetched:
+// it must be a public http(s) URL, and on an allowed host unless signed
+func checkImageSourceURL(u *url.URL, signed bool) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || checkPublicURL(u.String()) != nil { // from httpclient.go
+		return imageProxyError{http.StatusForbidden, "image source not allowed"}
+	}
+	if !signed && !imageProxyHostAllowed(u.Hostname()) {
+		return imageProxyError{http.StatusForbidden, "image host not allowed"}
+	}
+	return nil
+}
+
+// Read the source image, checking it's one of the accepted types. Remote
+// sources are checked on each redirect hop, so an allowed host can't send
+// us somewhere that isn't; the guarded client refuses internal addresses
+// at dial time too.
+func fetchImageSource(ctx context.Context, src string, signed bool) ([]byte, string, error) {
+	if file, ok := imageProxyLocalPath(src); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, "", imageProxyError{http.StatusNotFound, "image not found"}
+		}
+		return checkImageSource(data, "")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", imageProxyError{http.StatusBadRequest, "invalid image URL"}
+	}
+	if err := checkImageSourceURL(req.URL, signed); err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "image/png,image/jpeg,image/gif,image/webp")
+
+	client := *imageProxyClient
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if err := checkImageSourceURL(next.URL, signed); err != nil {
+			return err
+		}
+		return imageProxyClient.CheckRedirect(next, via)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var refused imageProxyError
+		if errors.As(err, &refused) {
+			return nil, "", refused
+		}
+		return nil, "", imageProxyError{http.StatusBadGateway, "failed to fetch image"}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", imageProxyError{http.StatusBadGateway, fmt.Sprintf("image host returned %d", resp.StatusCode)}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, imageProxyMaxBytes+1))
+	if err != nil {
+		return nil, "", imageProxyError{http.StatusBadGateway, "failed to fetch image"}
+	}
+	if len(data) > imageProxyMaxBytes {
+		return nil, "", imageProxyError{http.StatusRequestEntityTooLarge, "image too large"}
+	}
+	declared, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return checkImageSource(data, strings.TrimSpace(strings.ToLower(declared)))
+}
+
+// The content type of an accepted image. The bytes decide; a declared
+// type, when given, has to agree with them.
+func checkImageSource(data []byte, declared string) ([]byte, string, error) {
+	sniffed := http.DetectContentType(data)
+	if _, ok := imageProxyTypes[sniffed]; !ok || (declared != "" && declared != sniffed) {
+		return nil, "", imageProxyError{http.StatusUnsupportedMediaType, "not a PNG, JPEG, GIF or WebP image"}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > imageProxyMaxPixels {
+		return nil, "", imageProxyError{http.StatusUnsupportedMediaType, "image can't be read or is too large"}
+	}
+	return data, sniffed, nil
+}
+
+// Scale an image down to width, keeping its proportions; narrower images
+// are left as they are
+func resizeImage(data []byte, contentType string, width int) ([]byte, string, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	img := src
+	if b := src.Bounds(); b.Dx() > width {
+		height := max(1, b.Dy()*width/b.Dx())
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	format := imageProxyTypes[contentType]
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82})
+	}
+	return buf.Bytes(), format, err
+}
+
+// The resized image, from the cache or made now; concurrent requests for
+// the same one share the work
+func proxiedImage(ctx context.Context, src string, width int, signed bool) ([]byte, string, error) {
+	if data, contentType, ok := readImageCache(src, width); ok {
+		return data, contentType, nil
+	}
+	type result struct {
+		data        []byte
+		contentType string
+	}
+	v, err, _ := imageProxyFlights.Do(src+"|"+strconv.Itoa(width), func() (interface{}, error) {
+		data, contentType, err := fetchImageSource(context.WithoutCancel(ctx), src, signed)
+		if err != nil {
+			return nil, err
+		}
+		resized, format, err := resizeImage(data, contentType, width)
+		if err != nil {
+			return nil, imageProxyError{http.StatusUnsupportedMediaType, "image can't be read"}
+		}
+		if err := writeImageCache(imageCachePath(src, width, format), resized); err != nil {
+			log.Printf("Error caching resized image: %v", err)
+		}
+		return result{resized, "image/" + format}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	r := v.(result)
+	return r.data, r.contentType, nil
+}
+
+func imageCacheCleanupWorker() {
+	ticker := time.NewTicker(imageCacheCleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		recordJobRun("image-cache-cleanup", imageCacheCleanupEvery, purgeImageCache()) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Delete cached images past their lifetime
+func purgeImageCache() error {
+	removed := 0
+	err := filepath.WalkDir(imageCacheDir(), func(file string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > imageCacheTTL {
+			if os.Remove(file) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error cleaning the image cache: %v", err)
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Image cache cleanup: removed %d files", removed)
+	}
+	return nil
+}
+
+// Setup /img?src=...&w=...
+func setupImageProxyRoutes(r *gin.Engine) {
+	r.GET("/img", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		src := c.Query("src")
+		requested, err := strconv.Atoi(c.DefaultQuery("w", "0"))
+		if src == "" || err != nil || requested < 0 {
+			c.String(http.StatusBadRequest, "src and a width are required")
+			return
+		}
+		width := imageProxyWidth(requested)
+
+		signed := hmac.Equal([]byte(c.Query("s")), []byte(imageProxySignature(src)))
+		_, local := imageProxyLocalPath(src)
+		if !signed && !local {
+			parsed, err := url.Parse(src)
+			if err != nil || !isValidLongURL(src) || !imageProxyHostAllowed(parsed.Hostname()) { // from main.go
+				c.String(http.StatusForbidden, "image source not allowed")
+				return
+			}
+		}
+
+		if _, _, cached := readImageCache(src, width); !cached {
+			ipHash := hashIP(c.ClientIP())
+			if !imageProxyLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, imageProxyRateLimit) {
+				c.Header("Retry-After", "60")
+				c.String(http.StatusTooManyRequests, "too many requests")
+				return
+			}
+		}
+
+		data, contentType, err := proxiedImage(ctx, src, width, signed)
+		if err != nil {
+			status := http.StatusBadGateway
+			if e, ok := err.(imageProxyError); ok {
+				status = e.status
+			}
+			c.String(status, err.Error())
+			return
+		}
+		c.Header("X-Content-Type-Options", "nosniff")
+		writeConditional(c, contentType, data, fmt.Sprintf("public, max-age=%d", int(imageCacheTTL.Seconds()))) // from conditional.go
+	})
+}
+
+// Setup the admin form for the allowed hosts
+func setupImageProxyAdminRoutes(adminGroup *gin.RouterGroup) {
+	// The cache and the setting are shared by every site
+	adminGroup.POST("/settings/image-proxy", superAdminMiddleware(), func(c *gin.Context) {
+		hosts := strings.Join(strings.Fields(strings.NewReplacer(",", " ").Replace(strings.ToLower(c.PostForm("hosts")))), "\n")
+		if err := setSetting(c.Request.Context(), imageProxyHostsSetting, hosts); err != nil {
+			log.Printf("Error saving image proxy hosts: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save image proxy hosts",
+			})
+			return
+		}
+		log.Printf("Image proxy hosts changed by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Image proxy hosts saved"))
+	})
+}
@@ -0,0 +1,119 @@
+// inbound.go - Inbound email webhook
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest inbound webhook request accepted (attachments are ignored anyway)
+const inboundMaxBytes = 10 << 20
+
+// Oldest Mailgun signature timestamp accepted, against replays
+const inboundMaxSignatureAge = 5 * time.Minute
+
+// Check the request comes from the mail provider: a Mailgun signature when
+// MAILGUN_WEBHOOK_SIGNING_KEY is set, otherwise ?token=INBOUND_EMAIL_TOKEN
+func inboundAuthorized(c *gin.Context) bool {
+	if key := os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY"); key != "" && c.PostForm("signature") != "" {
+		timestamp := c.PostForm("timestamp")
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > inboundMaxSignatureAge {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(timestamp + c.PostForm("token")))
+		return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(c.PostForm("signature")))
+	}
+
+	token := os.Getenv("INBOUND_EMAIL_TOKEN")
+	return token != "" && subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) == 1
+}
+
+// Fields of an inbound email, from whichever provider posted it
+type inboundEmail struct {
+	From    string
+	Subject string
+	Body    string
+}
+
+// Read the email from a Mailgun or SendGrid form post
+func parseInboundEmail(c *gin.Context) inboundEmail {
+	email := inboundEmail{
+		From:    c.PostForm("from"),
+		Subject: c.PostForm("subject"),
+	}
+
+	// Mailgun: stripped-text drops quoted replies and signatures
+	for _, field := range []string{"stripped-text", "body-plain", "text"} {
+		if body := strings.TrimSpace(c.PostForm(field)); body != "" {
+			email.Body = body
+			break
+		}
+	}
+
+	// Mailgun sends the subject only inside message-headers for some routes
+	if email.Subject == "" {
+		var headers [][2]string
+		if json.Unmarshal([]byte(c.PostForm("message-headers")), &headers) == nil {
+			for _, h := range headers {
+				if strings.EqualFold(h[0], "Subject") {
+					email.Subject = h[1]
+				}
+			}
+		}
+	}
+	return email
+}
+
+// Setup the inbound email webhook
+func setupInboundEmailRoutes(r *gin.Engine) {
+	r.POST("/inbound/email", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, inboundMaxBytes)
+		if err := c.Request.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+			c.String(http.StatusRequestEntityTooLarge, "request too large")
+			return
+		}
+
+		if !inboundAuthorized(c) {
+			c.String(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		email := parseInboundEmail(c)
+		if email.Body == "" {
+			// 406 tells Mailgun not to retry
+			c.String(http.StatusNotAcceptable, "empty message")
+			return
+		}
+
+		name, address := email.From, ""
+		if parsed, err := mail.ParseAddress(email.From); err == nil {
+			name, address = parsed.Name, parsed.Address
+		}
+
+		threadID := threadFromSubject(ctx, email.Subject) // from messages.go
+		threadID, err := saveMessage(ctx, threadID, messageSourceEmail, "", name, address, email.Subject, email.Body)
+		if err != nil {
+			log.Printf("Error saving inbound email: %v", err)
+			c.String(http.StatusInternalServerError, "could not store message")
+			return
+		}
+
+		log.Printf("Inbound email stored in thread %d", threadID)
+		c.String(http.StatusOK, "ok")
+	})
+}
@@ -0,0 +1,515 @@
+//go:build integration
+
+// integration_test.go - End-to-end tests against the full router
+//
+// Run with: go test ./... -tags=integration
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testAdminUser     = "fixture-admin"
+	testAdminPassword = "fixture-password"
+)
+
+var testRouter *gin.Engine
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "zach-dev-integration")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	os.Setenv("ADMIN_USERNAME", testAdminUser)
+	os.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+
+	initApp()
+	fixtures, err := os.ReadFile("testdata/fixtures.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec(string(fixtures)); err != nil {
+		log.Fatal("Failed to load fixtures: ", err)
+	}
+	testRouter = setupRouter()
+
+	code := m.Run()
+
+	stopBackground(context.Background())
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Send a request through the router from the given client IP
+func doRequest(t *testing.T, method, path, ip string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.RemoteAddr = ip + ":40000"
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+// Log in as admin and return the session cookie header
+func adminSession(t *testing.T, ip string) http.Header {
+	t.Helper()
+	w := doRequest(t, "POST", "/admin/login", ip, url.Values{
+		"username": {testAdminUser},
+		"password": {testAdminPassword},
+	}, nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got %d, want %d", w.Code, http.StatusFound)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "admin_token" {
+			return http.Header{"Cookie": {cookie.Name + "=" + cookie.Value}}
+		}
+	}
+	t.Fatal("login: no admin_token cookie set")
+	return nil
+}
+
+func TestShortURLRedirects(t *testing.T) {
+	w := doRequest(t, "GET", "/s/fixone", "192.0.2.10", nil, nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/one" {
+		t.Errorf("/s/fixone: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = doRequest(t, "GET", "/s/missing", "192.0.2.10", nil, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/s/missing: got %d, want 404", w.Code)
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/old-blog", http.StatusMovedPermanently, "/blog"},
+		{"/OLD-BLOG", http.StatusMovedPermanently, "/blog"},
+		{"/temporary?ref=x", http.StatusFound, "https://example.com/elsewhere?ref=x"},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, "GET", tt.path, "192.0.2.11", nil, nil)
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+
+	w := doRequest(t, "GET", "/no-such-page", "192.0.2.11", nil, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/no-such-page: got %d, want 404", w.Code)
+	}
+}
+
+func TestBlog(t *testing.T) {
+	w := doRequest(t, "GET", "/blog", "192.0.2.12", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Hello, fixtures") {
+		t.Errorf("/blog: got %d, published post missing", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "Unfinished draft") {
+		t.Error("/blog lists a draft")
+	}
+
+	w = doRequest(t, "GET", "/blog/hello-fixtures", "192.0.2.12", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("/blog/hello-fixtures: got %d, want 200", w.Code)
+	}
+
+	w = doRequest(t, "GET", "/blog/unfinished-draft", "192.0.2.12", nil, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/blog/unfinished-draft: got %d, want 404", w.Code)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	const ip = "192.0.2.20"
+
+	w := doRequest(t, "GET", "/admin/dashboard", ip, nil, nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/admin/login" {
+		t.Errorf("dashboard without session: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = doRequest(t, "GET", "/admin/dashboard", ip, nil, http.Header{"Cookie": {"admin_token=forged"}})
+	if w.Code != http.StatusFound {
+		t.Errorf("dashboard with forged token: got %d, want 302", w.Code)
+	}
+
+	w = doRequest(t, "POST", "/admin/login", ip, url.Values{
+		"username": {testAdminUser},
+		"password": {"wrong"},
+	}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("bad password: got %d, want 401", w.Code)
+	}
+
+	session := adminSession(t, ip)
+	for _, path := range []string{"/admin/dashboard", "/admin/urls", "/admin/visitors", "/admin/posts", "/admin/redirects", "/admin/security"} {
+		w = doRequest(t, "GET", path, ip, nil, session)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s with session: got %d, want 200", path, w.Code)
+		}
+	}
+
+	w = doRequest(t, "GET", "/admin/logout", ip, nil, session)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/admin/login" {
+		t.Errorf("logout: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestAdminLockout(t *testing.T) {
+	const ip = "192.0.2.21"
+	wrong := url.Values{"username": {testAdminUser}, "password": {"wrong"}}
+
+	for i := 0; i < loginFailureLimit; i++ {
+		doRequest(t, "POST", "/admin/login", ip, wrong, nil)
+	}
+
+	w := doRequest(t, "POST", "/admin/login", ip, url.Values{
+		"username": {testAdminUser},
+		"password": {testAdminPassword},
+	}, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("login after %d failures: got %d, want 429", loginFailureLimit, w.Code)
+	}
+}
+
+func TestHTMXPartials(t *testing.T) {
+	const ip = "192.0.2.30"
+	htmx := http.Header{"Hx-Request": {"true"}}
+
+	for _, path := range []string{"/contact-form", "/url-shortener", "/work-content", "/education-content"} {
+		w := doRequest(t, "GET", path, ip, nil, htmx)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", path, w.Code)
+		}
+		if strings.Contains(strings.ToLower(w.Body.String()), "<html") {
+			t.Errorf("%s: partial rendered a full page", path)
+		}
+	}
+
+	w := doRequest(t, "POST", "/shorten-url", ip, url.Values{"originalUrl": {"https://example.com/new"}}, htmx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/s/") {
+		t.Errorf("shorten valid URL: got %d, no short link in response", w.Code)
+	}
+
+	// The expiry is saved with the link
+	w = doRequest(t, "POST", "/shorten-url", ip, url.Values{"originalUrl": {"https://example.com/expiring"}, "expires": {"7"}}, htmx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/s/") {
+		t.Errorf("shorten with expiry: got %d, no short link in response", w.Code)
+	}
+	var expires sql.NullTime
+	if err := db.QueryRow("SELECT expires_at FROM urls WHERE original_url = 'https://example.com/expiring'").Scan(&expires); err != nil || !expires.Valid {
+		t.Errorf("shortened link's expiry: %v, %v", expires, err)
+	}
+
+	w = doRequest(t, "POST", "/shorten-url", ip, url.Values{"originalUrl": {"javascript:alert(1)"}}, htmx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "valid URL") {
+		t.Errorf("shorten invalid URL: got %d, no error message", w.Code)
+	}
+
+	// Previews would fetch the destination from the server
+	for _, internal := range []string{"http://169.254.169.254/latest/meta-data/", "http://127.0.0.1:8080/admin", "http://localhost/"} {
+		w = doRequest(t, "POST", "/shorten-url", ip, url.Values{"originalUrl": {internal}}, htmx)
+		if strings.Contains(w.Body.String(), "/s/") {
+			t.Errorf("shorten %s: internal destination was accepted", internal)
+		}
+	}
+
+	w = doRequest(t, "POST", "/contact", ip, url.Values{"fullName": {"A"}, "email": {"not-an-email"}, "message": {"hi"}}, htmx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "valid email") {
+		t.Errorf("contact with bad email: got %d, no error message", w.Code)
+	}
+
+	w = doRequest(t, "POST", "/shorten-url", ip, url.Values{"originalUrl": {"https://example.com/" + strings.Repeat("a", 8<<10)}}, htmx)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized shorten request: got %d, want 413", w.Code)
+	}
+}
+
+func TestExports(t *testing.T) {
+	const ip = "192.0.2.40"
+	session := adminSession(t, ip)
+
+	w := doRequest(t, "GET", "/admin/export/stats", ip, nil, session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats export: got %d, want 200", w.Code)
+	}
+	var stats AdminStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("stats export: %v", err)
+	}
+	// Other tests add URLs and visits, so only check the fixtures are counted
+	if stats.TotalURLs < 3 || stats.TotalVisitors < 4 || stats.TotalClicks < 15 {
+		t.Errorf("stats export missing fixtures: %+v", stats)
+	}
+
+	w = doRequest(t, "GET", "/admin/security/export.csv", ip, nil, session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("security export: got %d, want 200", w.Code)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) < 2 {
+		t.Fatalf("security export: %d records, %v", len(records), err)
+	}
+	if strings.Join(records[0], ",") != "time,event,ip_hash,detail" {
+		t.Errorf("security export header: %v", records[0])
+	}
+
+	w = doRequest(t, "GET", "/admin/export/stats", ip, nil, nil)
+	if w.Code != http.StatusFound {
+		t.Errorf("stats export without session: got %d, want 302", w.Code)
+	}
+}
+
+func TestDiagnosticsTemplates(t *testing.T) {
+	if check := checkTemplates(context.Background()); check.Status != checkPass {
+		t.Errorf("template check: %s: %s", check.Status, check.Detail)
+	}
+}
+
+// Create an API token and return headers sending it
+func apiTokenHeader(t *testing.T, name string) http.Header {
+	t.Helper()
+	token := generateAdminToken()
+	if _, err := db.Exec("INSERT INTO api_tokens (name, token_hash, rate_limit) VALUES (?, ?, 1000)", name, hashAPIToken(token)); err != nil {
+		t.Fatal(err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}, "Content-Type": {"application/json"}}
+}
+
+// Send a JSON body through the router
+func doJSONRequest(t *testing.T, method, path, ip, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":40000"
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func TestGraphQLPersistedQueries(t *testing.T) {
+	const ip = "192.0.2.60"
+	register := func(query string, header http.Header) *httptest.ResponseRecorder {
+		sum := sha256.Sum256([]byte(query))
+		body, _ := json.Marshal(map[string]any{
+			"query":      query,
+			"extensions": map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": hex.EncodeToString(sum[:])}},
+		})
+		return doJSONRequest(t, "POST", "/api/v1/graphql", ip, string(body), header)
+	}
+	stored := func(query string) bool {
+		sum := sha256.Sum256([]byte(query))
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM persisted_queries WHERE hash = ?", hex.EncodeToString(sum[:])).Scan(&n)
+		return n > 0
+	}
+
+	anonymous := "{ __typename }"
+	if w := register(anonymous, nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "errors") {
+		t.Errorf("anonymous persisted query: got %d %s, want it run", w.Code, w.Body.String())
+	}
+	if stored(anonymous) {
+		t.Error("anonymous client registered a persisted query")
+	}
+
+	withToken := "{ __typename  }"
+	if w := register(withToken, apiTokenHeader(t, "graphql test")); w.Code != http.StatusOK {
+		t.Errorf("persisted query with token: got %d", w.Code)
+	}
+	if !stored(withToken) {
+		t.Error("token client's persisted query wasn't registered")
+	}
+}
+
+func TestGraphQLQueryCost(t *testing.T) {
+	const ip = "192.0.2.62"
+	run := func(query string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"query": query})
+		return doJSONRequest(t, "POST", "/api/v1/graphql", ip, string(body), nil)
+	}
+
+	everything := `{
+		projects { id slug title summary imagePath url tech }
+		project(slug: "x") { id slug title summary imagePath url tech }
+		posts { id slug title summary body html url publishedAt }
+		post(slug: "x") { id slug title summary body html url publishedAt }
+		workExperience { id kind title organization startDate endDate logoPath url bullets }
+		education { id kind title organization startDate endDate logoPath url bullets }
+		linkStats { totalLinks totalClicks }
+		link(code: "x") { code clicks createdAt }
+	}`
+	if w := run(everything); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "cost") {
+		t.Errorf("query for every field: got %d %s", w.Code, w.Body.String())
+	}
+
+	// Each alias reloads and re-renders every post, as does each use of a
+	// fragment
+	aliases := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString(" p" + strconv.Itoa(i) + ": posts { html }")
+		}
+		return b.String()
+	}
+	aliased := "{" + aliases(30) + " }"
+	spread := "{ ...a ...a ...a } fragment a on Query {" + aliases(10) + " }"
+	for _, query := range []string{aliased, spread} {
+		if w := run(query); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "cost exceeds") {
+			t.Errorf("costly query: got %d %s, want 400", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCrossSiteWritesRefused(t *testing.T) {
+	const ip = "192.0.2.63"
+	session := adminSession(t, ip)
+	form := url.Values{"theme": {"no-such-theme"}}
+
+	crossSite := session.Clone()
+	crossSite.Set("Sec-Fetch-Site", "cross-site")
+	if w := doRequest(t, "POST", "/admin/settings/theme", ip, form, crossSite); w.Code != http.StatusForbidden {
+		t.Errorf("cross-site admin post: got %d, want 403", w.Code)
+	}
+	sameOrigin := session.Clone()
+	sameOrigin.Set("Sec-Fetch-Site", "same-origin")
+	if w := doRequest(t, "POST", "/admin/settings/theme", ip, form, sameOrigin); w.Code != http.StatusBadRequest {
+		t.Errorf("same-origin admin post: got %d, want 400 for the unknown theme", w.Code)
+	}
+
+	// Beacons are posted from pages on other sites
+	w := doRequest(t, "POST", "/api/event", ip, nil, http.Header{"Origin": {"https://elsewhere.example"}, "Sec-Fetch-Site": {"cross-site"}})
+	if w.Code == http.StatusForbidden {
+		t.Error("cross-site beacon refused")
+	}
+}
+
+func TestSyndicationRetryOnlyFailed(t *testing.T) {
+	const ip = "192.0.2.61"
+	session := adminSession(t, ip)
+	var postID int
+	if err := db.QueryRow("SELECT id FROM posts ORDER BY id LIMIT 1").Scan(&postID); err != nil {
+		t.Fatal(err)
+	}
+
+	retry := func(status string) (int, string) {
+		result, err := db.Exec("INSERT INTO syndications (post_id, target, status) VALUES (?, ?, ?)", postID, "retry-test-"+status, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		w := doRequest(t, "POST", "/admin/syndication/"+strconv.FormatInt(id, 10)+"/retry", ip, nil, session)
+		var after string
+		db.QueryRow("SELECT status FROM syndications WHERE id = ?", id).Scan(&after)
+		return w.Code, after
+	}
+
+	for _, status := range []string{"posted", "pending"} {
+		if code, after := retry(status); code != http.StatusConflict || after != status {
+			t.Errorf("retry of %s syndication: got %d, status %s; want 409 and unchanged", status, code, after)
+		}
+	}
+	// The test target isn't configured, so a failed one stays failed
+	if code, after := retry("failed"); code != http.StatusBadRequest || after != "failed" {
+		t.Errorf("retry of failed syndication to unknown target: got %d, status %s; want 400 and failed", code, after)
+	}
+	if w := doRequest(t, "POST", "/admin/syndication/999999/retry", ip, nil, session); w.Code != http.StatusNotFound {
+		t.Errorf("retry of missing syndication: got %d, want 404", w.Code)
+	}
+}
+
+func TestShortenAPI(t *testing.T) {
+	const ip = "192.0.2.62"
+	header := apiTokenHeader(t, "shorten test")
+
+	tests := []struct {
+		name   string
+		body   string
+		header http.Header
+		status int
+		field  string
+	}{
+		{"created", `{"original_url":"https://example.com/api","alias":"api-alias","expires_in_days":7}`, header, http.StatusCreated, ""},
+		{"alias taken", `{"original_url":"https://example.com/other","alias":"api-alias"}`, header, http.StatusConflict, "alias"},
+		{"bad alias", `{"original_url":"https://example.com/api","alias":"a b"}`, header, http.StatusUnprocessableEntity, "alias"},
+		{"bad url", `{"original_url":"javascript:alert(1)"}`, header, http.StatusUnprocessableEntity, "original_url"},
+		{"internal url", `{"original_url":"http://127.0.0.1/admin"}`, header, http.StatusUnprocessableEntity, "original_url"},
+		{"bad expiry", `{"original_url":"https://example.com/api","expires_in_days":3}`, header, http.StatusUnprocessableEntity, "expires_in_days"},
+		{"not JSON", `original_url=https://example.com`, header, http.StatusBadRequest, ""},
+		{"no token", `{"original_url":"https://example.com/api"}`, nil, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		w := doJSONRequest(t, "POST", "/api/v1/shorten", ip, tt.body, tt.header)
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: body isn't JSON: %q", tt.name, w.Body.String())
+			continue
+		}
+		if tt.status >= 400 {
+			if msg, _ := body["error"].(string); msg == "" {
+				t.Errorf("%s: no error message in %v", tt.name, body)
+			}
+			if field, _ := body["field"].(string); field != tt.field {
+				t.Errorf("%s: field = %q, want %q", tt.name, field, tt.field)
+			}
+		}
+	}
+
+	// A token in the URL would be logged and tracked, so it isn't accepted
+	token := strings.TrimPrefix(header.Get("Authorization"), "Bearer ")
+	jsonOnly := http.Header{"Content-Type": {"application/json"}}
+	if w := doJSONRequest(t, "POST", "/api/v1/shorten?token="+token, ip, `{"original_url":"https://example.com/api"}`, jsonOnly); w.Code != http.StatusUnauthorized {
+		t.Errorf("token in query: got %d, want 401", w.Code)
+	}
+
+	// The expiry is saved with the link
+	var expires sql.NullTime
+	if err := db.QueryRow("SELECT expires_at FROM urls WHERE short_code = 'api-alias'").Scan(&expires); err != nil || !expires.Valid {
+		t.Errorf("created link's expiry: %v, %v", expires, err)
+	}
+}
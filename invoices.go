@@ -0,0 +1,592 @@
+// invoices.go - Invoices for freelance clients
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// How long an invoice's link works
+const invoiceLinkDays = 365
+
+// Currencies offered, with the symbol amounts are shown with
+var invoiceCurrencies = []string{"USD", "EUR", "GBP", "CAD"}
+
+var currencySymbols = map[string]string{"USD": "$", "EUR": "€", "GBP": "£", "CAD": "CA$"}
+
+// Date layout of issue and due dates, as the form's date inputs send them
+const invoiceDateLayout = "2006-01-02"
+
+// An invoice and its line items
+type Invoice struct {
+	ID            int
+	ClientName    string
+	ClientEmail   string
+	ClientAddress string
+	Currency      string
+	IssuedOn      string
+	DueOn         string
+	Notes         string // payment instructions and the like
+	Items         []InvoiceItem
+	PaidAt        sql.NullTime
+	PaidVia       string       // "manual", or "stripe" when the webhook confirmed it
+	ViewedAt      sql.NullTime // first time the client opened it
+	Views         int
+	LinkExpiresAt time.Time
+	CreatedAt     time.Time
+	StripeSession string // last Checkout session the client started
+	URL           string // signed link for the client, set for the admin
+	PayURL        string // online payment link, when Stripe is set up
+}
+
+type InvoiceItem struct {
+	Description string
+	Quantity    float64
+	UnitCents   int64
+}
+
+// Line total in cents
+func (i InvoiceItem) AmountCents() int64 {
+	return int64(math.Round(i.Quantity * float64(i.UnitCents)))
+}
+
+// Quantity without trailing zeros
+func (i InvoiceItem) QuantityText() string {
+	return strconv.FormatFloat(i.Quantity, 'f', -1, 64)
+}
+
+// Invoice number shown to the client
+func (inv Invoice) Number() string {
+	return fmt.Sprintf("INV-%04d", inv.ID)
+}
+
+func (inv Invoice) TotalCents() int64 {
+	var total int64
+	for _, item := range inv.Items {
+		total += item.AmountCents()
+	}
+	return total
+}
+
+// Amount in the invoice's currency, like "$1,234.50"
+func (inv Invoice) Money(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	whole := strconv.FormatInt(cents/100, 10)
+	var b strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	amount := fmt.Sprintf("%s.%02d", b.String(), cents%100)
+	if symbol, ok := currencySymbols[inv.Currency]; ok {
+		return sign + symbol + amount
+	}
+	return sign + amount + " " + inv.Currency
+}
+
+// Whether payment is late
+func (inv Invoice) Overdue() bool {
+	due, err := time.ParseInLocation(invoiceDateLayout, inv.DueOn, time.Local)
+	return err == nil && !inv.PaidAt.Valid && time.Now().After(due.AddDate(0, 0, 1))
+}
+
+// Initialize invoice storage
+func initInvoices() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS invoices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_name TEXT NOT NULL,
+		client_email TEXT NOT NULL DEFAULT '',
+		client_address TEXT NOT NULL DEFAULT '',
+		currency TEXT NOT NULL DEFAULT 'USD',
+		issued_on TEXT NOT NULL,
+		due_on TEXT NOT NULL,
+		notes TEXT NOT NULL DEFAULT '',
+		paid_at DATETIME,
+		viewed_at DATETIME,
+		views INTEGER NOT NULL DEFAULT 0,
+		link_expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS invoice_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		invoice_id INTEGER NOT NULL,
+		description TEXT NOT NULL,
+		quantity REAL NOT NULL,
+		unit_cents INTEGER NOT NULL,
+		sort_order INTEGER NOT NULL DEFAULT 0
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create invoice tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice ON invoice_items (invoice_id)`)
+
+	db.Exec(`ALTER TABLE invoices ADD COLUMN paid_via TEXT NOT NULL DEFAULT ''`)          // Ignore error if column already exists
+	db.Exec(`ALTER TABLE invoices ADD COLUMN stripe_session_id TEXT NOT NULL DEFAULT ''`) // Ignore error if column already exists
+}
+
+const invoiceColumns = `id, client_name, client_email, client_address, currency, issued_on, due_on, notes,
+	paid_at, paid_via, stripe_session_id, viewed_at, views, link_expires_at, created_at`
+
+func scanInvoice(row interface{ Scan(...interface{}) error }) (Invoice, error) {
+	var inv Invoice
+	err := row.Scan(&inv.ID, &inv.ClientName, &inv.ClientEmail, &inv.ClientAddress, &inv.Currency, &inv.IssuedOn,
+		&inv.DueOn, &inv.Notes, &inv.PaidAt, &inv.PaidVia, &inv.StripeSession, &inv.ViewedAt, &inv.Views, &inv.LinkExpiresAt, &inv.CreatedAt)
+	return inv, err
+}
+
+// Line items of every invoice, by invoice id
+func getInvoiceItems(ctx context.Context) (map[int][]InvoiceItem, error) {
+	rows, err := dbQuery(ctx, `SELECT invoice_id, description, quantity, unit_cents FROM invoice_items ORDER BY invoice_id, sort_order, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := map[int][]InvoiceItem{}
+	for rows.Next() {
+		var id int
+		var item InvoiceItem
+		if err := rows.Scan(&id, &item.Description, &item.Quantity, &item.UnitCents); err != nil {
+			return nil, err
+		}
+		items[id] = append(items[id], item)
+	}
+	return items, rows.Err()
+}
+
+// All invoices with their items, newest first
+func getInvoices(ctx context.Context) ([]Invoice, error) {
+	rows, err := dbQuery(ctx, `SELECT `+invoiceColumns+` FROM invoices ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items, err := getInvoiceItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range invoices {
+		invoices[i].Items = items[invoices[i].ID]
+	}
+	return invoices, nil
+}
+
+func getInvoice(ctx context.Context, id int) (Invoice, error) {
+	inv, err := scanInvoice(dbQueryRow(ctx, `SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`, id))
+	if err != nil {
+		return inv, err
+	}
+	rows, err := dbQuery(ctx, `SELECT description, quantity, unit_cents FROM invoice_items WHERE invoice_id = ? ORDER BY sort_order, id`, id)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item InvoiceItem
+		if err := rows.Scan(&item.Description, &item.Quantity, &item.UnitCents); err != nil {
+			return inv, err
+		}
+		inv.Items = append(inv.Items, item)
+	}
+	return inv, rows.Err()
+}
+
+func invoiceLinkToken(ctx context.Context, id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expiresAt.Unix())
+	return payload + "." + signedLinkMAC(ctx, "invoice:", payload) // from sharelinks.go
+}
+
+// Full URL for an invoice token, on the same host as short links
+func buildInvoiceURL(c *gin.Context, token string) string {
+	return strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/invoices/" + token // from main.go
+}
+
+// Parse an amount like "1,250.50" into cents
+func parseCents(value string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(value), ",", ""), 64)
+	if err != nil || f < 0 || f > 1e9 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// Parse the admin invoice form. Line items come as parallel lists; rows
+// left blank are skipped.
+func invoiceFromForm(c *gin.Context) (Invoice, string) {
+	inv := Invoice{
+		ClientName:    strings.TrimSpace(c.PostForm("client_name")),
+		ClientEmail:   strings.TrimSpace(c.PostForm("client_email")),
+		ClientAddress: strings.TrimSpace(c.PostForm("client_address")),
+		Currency:      c.PostForm("currency"),
+		IssuedOn:      c.PostForm("issued_on"),
+		DueOn:         c.PostForm("due_on"),
+		Notes:         strings.TrimSpace(c.PostForm("notes")),
+	}
+	if inv.ClientName == "" {
+		return inv, "An invoice needs a client"
+	}
+	if inv.ClientEmail != "" && !isValidEmail(inv.ClientEmail) { // from main.go
+		return inv, "Please enter a valid client email, or leave it empty"
+	}
+	if !oneOf(inv.Currency, invoiceCurrencies) { // from leads.go
+		return inv, "Unknown currency"
+	}
+	issued, err := time.Parse(invoiceDateLayout, inv.IssuedOn)
+	if err != nil {
+		return inv, "Please enter the issue date"
+	}
+	due, err := time.Parse(invoiceDateLayout, inv.DueOn)
+	if err != nil || due.Before(issued) {
+		return inv, "Please enter a due date on or after the issue date"
+	}
+
+	descriptions := c.PostFormArray("item_description")
+	quantities := c.PostFormArray("item_quantity")
+	prices := c.PostFormArray("item_price")
+	for i, description := range descriptions {
+		description = strings.TrimSpace(description)
+		if description == "" || i >= len(quantities) || i >= len(prices) {
+			continue
+		}
+		quantity, err := strconv.ParseFloat(strings.TrimSpace(quantities[i]), 64)
+		if err != nil || quantity <= 0 || quantity > 1e6 {
+			return inv, "Each line needs a quantity above zero (line " + strconv.Itoa(i+1) + ")"
+		}
+		unit, err := parseCents(prices[i])
+		if err != nil {
+			return inv, "Each line needs a price like 1250 or 1,250.50 (line " + strconv.Itoa(i+1) + ")"
+		}
+		inv.Items = append(inv.Items, InvoiceItem{Description: description, Quantity: quantity, UnitCents: unit})
+	}
+	if len(inv.Items) == 0 {
+		return inv, "An invoice needs at least one line item"
+	}
+	return inv, ""
+}
+
+// Store a new invoice and its items; returns its id
+func createInvoice(ctx context.Context, inv Invoice) (int64, error) {
+	tx, cancel, err := dbBegin(ctx) // from dbctx.go
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO invoices (client_name, client_email, client_address, currency, issued_on, due_on, notes, link_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.ClientName, inv.ClientEmail, inv.ClientAddress, inv.Currency, inv.IssuedOn, inv.DueOn, inv.Notes,
+		time.Now().AddDate(0, 0, invoiceLinkDays).Truncate(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	for i, item := range inv.Items {
+		_, err := tx.ExecContext(ctx, `INSERT INTO invoice_items (invoice_id, description, quantity, unit_cents, sort_order) VALUES (?, ?, ?, ?, ?)`,
+			id, item.Description, item.Quantity, item.UnitCents, i)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// Render an invoice as a PDF, from the person on the resume
+func renderInvoicePDF(inv Invoice, from JSONResumeBasics) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetMargins(18, 16, 18)
+	pdf.SetAutoPageBreak(true, 16)
+	pdf.SetTitle("Invoice "+inv.Number(), true)
+	pdf.SetAuthor(from.Name, true)
+	pdf.AddPage()
+
+	// Core fonts are cp1252 - translate UTF-8 text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	muted := func() { pdf.SetTextColor(90, 90, 90) }
+	plain := func() { pdf.SetTextColor(0, 0, 0) }
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(100, 9, tr(from.Name), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 9, "INVOICE", "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	muted()
+	pdf.CellFormat(100, 5, tr(strings.Join([]string{from.Email, from.URL}, "  |  ")), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr(inv.Number()), "", 1, "R", false, 0, "")
+	plain()
+	pdf.Ln(8)
+
+	// Bill to, and the dates
+	top := pdf.GetY()
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(0, 5, "Bill to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	billTo := []string{inv.ClientName}
+	if inv.ClientAddress != "" {
+		billTo = append(billTo, inv.ClientAddress)
+	}
+	if inv.ClientEmail != "" {
+		billTo = append(billTo, inv.ClientEmail)
+	}
+	pdf.MultiCell(100, 5, tr(strings.Join(billTo, "\n")), "", "L", false)
+	bottom := pdf.GetY()
+
+	pdf.SetXY(120, top)
+	for _, row := range [][2]string{{"Issued", inv.IssuedOn}, {"Due", inv.DueOn}} {
+		pdf.SetX(120)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(30, 5, row[0], "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(0, 5, row[1], "", 1, "R", false, 0, "")
+	}
+	pdf.SetY(math.Max(bottom, pdf.GetY()) + 8)
+
+	// Line items
+	widths := []float64{96, 20, 30, 34}
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(235, 235, 235)
+	for i, heading := range []string{"Description", "Qty", "Unit price", "Amount"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 7, heading, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, item := range inv.Items {
+		// Long descriptions wrap; the other cells line up with the first line
+		y := pdf.GetY()
+		pdf.MultiCell(widths[0], 6, tr(item.Description), "", "L", false)
+		next := pdf.GetY()
+		pdf.SetXY(18+widths[0], y)
+		pdf.CellFormat(widths[1], 6, item.QuantityText(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 6, tr(inv.Money(item.UnitCents)), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, tr(inv.Money(item.AmountCents())), "", 1, "R", false, 0, "")
+		pdf.SetY(math.Max(next, pdf.GetY()))
+	}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(widths[0]+widths[1]+widths[2], 8, "Total ("+inv.Currency+")", "T", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], 8, tr(inv.Money(inv.TotalCents())), "T", 1, "R", false, 0, "")
+
+	if inv.PaidAt.Valid {
+		pdf.Ln(2)
+		pdf.SetTextColor(20, 130, 60)
+		pdf.CellFormat(0, 6, "Paid "+inv.PaidAt.Time.Format("January 2, 2006")+" - thank you!", "", 1, "R", false, 0, "")
+		plain()
+	} else if inv.PayURL != "" {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "U", 10)
+		pdf.SetTextColor(40, 80, 200)
+		pdf.CellFormat(0, 6, "Pay online by card", "", 1, "R", false, 0, inv.PayURL)
+		plain()
+	}
+
+	if inv.Notes != "" {
+		pdf.Ln(8)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(0, 5, "Notes", "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 5, tr(inv.Notes), "", "L", false)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Render and send an invoice PDF to open in the browser
+func serveInvoicePDF(c *gin.Context, inv Invoice) {
+	resume, err := buildJSONResume(c.Request.Context()) // from resume.go
+	if err != nil {
+		log.Printf("Error loading resume for invoice %d: %v", inv.ID, err)
+		c.String(http.StatusInternalServerError, "Failed to generate invoice")
+		return
+	}
+	pdfBytes, err := renderInvoicePDF(inv, resume.Basics)
+	if err != nil {
+		log.Printf("Error generating invoice %d: %v", inv.ID, err)
+		c.String(http.StatusInternalServerError, "Failed to generate invoice")
+		return
+	}
+	c.Header("Content-Disposition", "inline; filename="+inv.Number()+".pdf")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
+
+// Setup the signed link clients open their invoice at
+func setupInvoiceRoutes(r *gin.Engine) {
+	r.GET("/invoices/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+		ipHash := hashIP(c.ClientIP())
+
+		id, ok := parseSignedLinkToken(ctx, "invoice:", c.Param("token")) // from sharelinks.go
+		var inv Invoice
+		var err error
+		if ok {
+			inv, err = getInvoice(ctx, id)
+		}
+		if !ok || err != nil {
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Error loading invoice %d: %v", id, err)
+			}
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "invoice link", ipHash, time.Minute) // from security.go
+			c.String(http.StatusNotFound, "This invoice link has expired or the invoice was withdrawn.")
+			return
+		}
+
+		inv.PayURL = invoicePayURL(c, inv, c.Param("token")) // from stripe.go
+		viewedAt := time.Now()
+		safeGoRetry("invoice-views", 3, func() error {
+			_, err := dbExec(context.WithoutCancel(ctx), "UPDATE invoices SET views = views + 1, viewed_at = COALESCE(viewed_at, ?) WHERE id = ?", viewedAt, id)
+			return err
+		})
+		serveInvoicePDF(c, inv)
+	})
+}
+
+// Setup admin invoice routes
+func setupInvoiceAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/invoices", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		invoices, err := getInvoices(ctx)
+		if err != nil {
+			log.Printf("Error loading invoices: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load invoices",
+			})
+			return
+		}
+		for i, inv := range invoices {
+			if time.Now().Before(inv.LinkExpiresAt) {
+				token := invoiceLinkToken(ctx, inv.ID, inv.LinkExpiresAt)
+				invoices[i].URL = buildInvoiceURL(c, token)
+				invoices[i].PayURL = invoicePayURL(c, inv, token)
+			}
+		}
+		today := time.Now()
+		c.HTML(http.StatusOK, "admin-invoices.html", gin.H{
+			"invoices":   invoices,
+			"currencies": invoiceCurrencies,
+			"stripe":     getStripeStatus(c), // from stripe.go
+			"primary":    siteFromContext(ctx).Primary(),
+			"today":      today.Format(invoiceDateLayout),
+			"due":        today.AddDate(0, 0, 30).Format(invoiceDateLayout),
+			"message":    c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/invoices", func(c *gin.Context) {
+		inv, problem := invoiceFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+		id, err := createInvoice(c.Request.Context(), inv)
+		if err != nil {
+			log.Printf("Error saving invoice: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save invoice",
+			})
+			return
+		}
+		log.Printf("Invoice %d created by admin from %s", id, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/invoices?message="+url.QueryEscape(Invoice{ID: int(id)}.Number()+" created")+"#invoice-"+strconv.FormatInt(id, 10))
+	})
+
+	// Preview without counting a client view
+	adminGroup.GET("/invoices/:id/pdf", func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		inv, err := getInvoice(c.Request.Context(), id)
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Invoice not found"})
+			return
+		}
+		inv.PayURL = invoicePayURL(c, inv, invoiceLinkToken(c.Request.Context(), inv.ID, inv.LinkExpiresAt))
+		serveInvoicePDF(c, inv)
+	})
+
+	// Payments outside Stripe are recorded by hand
+	adminGroup.POST("/invoices/:id/paid", func(c *gin.Context) {
+		var paidAt interface{}
+		paidVia, message := "", "marked unpaid"
+		if c.PostForm("paid") == "1" {
+			paidAt, paidVia, message = time.Now(), "manual", "marked paid"
+		}
+		result, err := dbExec(c.Request.Context(), "UPDATE invoices SET paid_at = ?, paid_via = ? WHERE id = ?", paidAt, paidVia, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating invoice: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to update invoice",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Invoice not found"})
+			return
+		}
+		id, _ := strconv.Atoi(c.Param("id"))
+		c.Redirect(http.StatusSeeOther, "/admin/invoices?message="+url.QueryEscape(Invoice{ID: id}.Number()+" "+message))
+	})
+
+	// Deleting an invoice also stops its link working
+	adminGroup.POST("/invoices/:id/delete", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		tx, cancel, err := dbBegin(ctx)
+		if err == nil {
+			defer cancel()
+			defer tx.Rollback()
+			if _, err = tx.ExecContext(ctx, "DELETE FROM invoice_items WHERE invoice_id = ?", c.Param("id")); err == nil {
+				if _, err = tx.ExecContext(ctx, "DELETE FROM invoices WHERE id = ?", c.Param("id")); err == nil {
+					err = tx.Commit()
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Error deleting invoice: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete invoice",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/invoices?message="+url.QueryEscape("Invoice deleted"))
+	})
+}
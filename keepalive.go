@@ -0,0 +1,346 @@
+// keepalive.go - Keep-alive pings for free-tier services
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often the scheduler looks for due pings
+const keepAliveTick = time.Minute
+
+// Interval bounds, in minutes. Render's free tier sleeps after 15 idle
+// minutes, so the default stays under that.
+const (
+	keepAliveMinInterval     = 1
+	keepAliveMaxInterval     = 24 * 60
+	keepAliveDefaultInterval = 10
+)
+
+// Results kept per ping
+const keepAliveHistoryLimit = 50
+
+// Long enough for a sleeping service to cold-start
+var keepAliveClient = &http.Client{Timeout: 90 * time.Second}
+
+// Configured keep-alive ping
+type KeepAlivePing struct {
+	ID             int
+	URL            string
+	Interval       int // minutes
+	ExpectedStatus int
+	Enabled        bool
+	LastRunAt      *time.Time
+	LastOK         bool
+	History        []KeepAliveResult
+}
+
+// One run of a ping
+type KeepAliveResult struct {
+	RanAt      time.Time
+	StatusCode int // 0 when the request failed
+	LatencyMs  int64
+	OK         bool
+	Error      string
+}
+
+// Initialize keep-alive storage and start the scheduler
+func initKeepAlive() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS keepalive_pings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		interval_minutes INTEGER NOT NULL DEFAULT 10,
+		expected_status INTEGER NOT NULL DEFAULT 200,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		next_run_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS keepalive_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ping_id INTEGER NOT NULL,
+		ran_at DATETIME NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		ok INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	)`,
+		`CREATE INDEX IF NOT EXISTS idx_keepalive_results_ping ON keepalive_results(ping_id, ran_at)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create keep-alive tables:", err)
+		}
+	}
+
+	startWorker("keep-alive", keepAliveWorker) // from safego.go
+}
+
+// Run due pings for every site each minute
+func keepAliveWorker() {
+	ticker := time.NewTicker(keepAliveTick)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := runDueKeepAlives(ctx); siteErr != nil {
+				log.Printf("Error running keep-alive pings for %s: %v", siteFromContext(ctx).Name, siteErr)
+				err = siteErr
+			}
+		})
+		recordJobRun("keep-alive", keepAliveTick, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Ping every enabled URL whose next run has come, side by side so one slow
+// cold start doesn't hold up the rest
+func runDueKeepAlives(ctx context.Context) error {
+	rows, err := dbQuery(ctx, `
+		SELECT id, url, interval_minutes, expected_status FROM keepalive_pings
+		WHERE enabled = 1 AND next_run_at <= ?
+	`, time.Now())
+	if err != nil {
+		return err
+	}
+	var due []KeepAlivePing
+	for rows.Next() {
+		var p KeepAlivePing
+		if rows.Scan(&p.ID, &p.URL, &p.Interval, &p.ExpectedStatus) == nil {
+			due = append(due, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Schedule the next runs first, so a ping still waiting on a cold
+	// start isn't picked up again by the next tick
+	for _, p := range due {
+		next := time.Now().Add(time.Duration(p.Interval) * time.Minute)
+		if _, err := dbExec(ctx, "UPDATE keepalive_pings SET next_run_at = ? WHERE id = ?", next, p.ID); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range due {
+		wg.Add(1)
+		go func(p KeepAlivePing) {
+			defer wg.Done()
+			if err := recordKeepAliveResult(ctx, p.ID, runKeepAlive(ctx, p)); err != nil {
+				log.Printf("Error recording keep-alive result for %s: %v", p.URL, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Request the URL once
+func runKeepAlive(ctx context.Context, p KeepAlivePing) KeepAliveResult {
+	result := KeepAliveResult{RanAt: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", "zachkp.dev keep-alive")
+
+	resp, err := keepAliveClient.Do(req)
+	result.LatencyMs = time.Since(result.RanAt).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.OK = resp.StatusCode == p.ExpectedStatus
+	if !result.OK {
+		result.Error = fmt.Sprintf("expected %d", p.ExpectedStatus)
+	}
+	return result
+}
+
+// Store a result and trim the ping's history
+func recordKeepAliveResult(ctx context.Context, pingID int, r KeepAliveResult) error {
+	_, err := dbExec(ctx, `
+		INSERT INTO keepalive_results (ping_id, ran_at, status_code, latency_ms, ok, error) VALUES (?, ?, ?, ?, ?, ?)
+	`, pingID, r.RanAt, r.StatusCode, r.LatencyMs, r.OK, r.Error)
+	if err != nil {
+		return err
+	}
+	_, err = dbExec(ctx, `
+		DELETE FROM keepalive_results WHERE ping_id = ? AND id NOT IN (
+			SELECT id FROM keepalive_results WHERE ping_id = ? ORDER BY ran_at DESC LIMIT ?
+		)
+	`, pingID, pingID, keepAliveHistoryLimit)
+	return err
+}
+
+// Pings with their recent results, newest first
+func getKeepAlivePings(ctx context.Context) ([]KeepAlivePing, error) {
+	rows, err := dbQuery(ctx, "SELECT id, url, interval_minutes, expected_status, enabled FROM keepalive_pings ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var pings []KeepAlivePing
+	for rows.Next() {
+		var p KeepAlivePing
+		if rows.Scan(&p.ID, &p.URL, &p.Interval, &p.ExpectedStatus, &p.Enabled) == nil {
+			pings = append(pings, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range pings {
+		rows, err := dbQuery(ctx, `
+			SELECT ran_at, status_code, latency_ms, ok, error FROM keepalive_results
+			WHERE ping_id = ? ORDER BY ran_at DESC LIMIT 20
+		`, pings[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var r KeepAliveResult
+			if rows.Scan(&r.RanAt, &r.StatusCode, &r.LatencyMs, &r.OK, &r.Error) == nil {
+				pings[i].History = append(pings[i].History, r)
+			}
+		}
+		rows.Close()
+		if len(pings[i].History) > 0 {
+			pings[i].LastRunAt = &pings[i].History[0].RanAt
+			pings[i].LastOK = pings[i].History[0].OK
+		}
+	}
+	return pings, nil
+}
+
+// Ping from the admin form, or a problem to show
+func keepAlivePingFromForm(c *gin.Context) (KeepAlivePing, string) {
+	p := KeepAlivePing{
+		URL:            strings.TrimSpace(c.PostForm("url")),
+		Interval:       keepAliveDefaultInterval,
+		ExpectedStatus: http.StatusOK,
+		Enabled:        c.PostForm("enabled") == "on",
+	}
+	if !isValidLongURL(p.URL) { // from main.go
+		return p, "URL must be an absolute http(s) URL"
+	}
+	if raw := strings.TrimSpace(c.PostForm("interval")); raw != "" {
+		interval, err := strconv.Atoi(raw)
+		if err != nil || interval < keepAliveMinInterval || interval > keepAliveMaxInterval {
+			return p, fmt.Sprintf("Interval must be between %d and %d minutes", keepAliveMinInterval, keepAliveMaxInterval)
+		}
+		p.Interval = interval
+	}
+	if raw := strings.TrimSpace(c.PostForm("expected_status")); raw != "" {
+		code, err := strconv.Atoi(raw)
+		if err != nil || code < 100 || code > 599 {
+			return p, "Expected status must be an HTTP status code"
+		}
+		p.ExpectedStatus = code
+	}
+	return p, ""
+}
+
+// Setup admin keep-alive routes
+func setupKeepAliveAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/keepalive", func(c *gin.Context) {
+		pings, err := getKeepAlivePings(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading keep-alive pings: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load keep-alive pings",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-keepalive.html", gin.H{
+			"pings":           pings,
+			"defaultInterval": keepAliveDefaultInterval,
+		})
+	})
+
+	adminGroup.POST("/keepalive", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		p, problem := keepAlivePingFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, "INSERT INTO keepalive_pings (url, interval_minutes, expected_status, enabled, next_run_at) VALUES (?, ?, ?, 1, ?)",
+			p.URL, p.Interval, p.ExpectedStatus, time.Now())
+		if err != nil {
+			log.Printf("Error saving keep-alive ping: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save keep-alive ping",
+			})
+			return
+		}
+
+		log.Printf("Keep-alive ping for %s added by admin from %s", p.URL, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/keepalive")
+	})
+
+	// Edit a ping; it runs at the next tick so a change is checked right away
+	adminGroup.POST("/keepalive/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		p, problem := keepAlivePingFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, `
+			UPDATE keepalive_pings SET url = ?, interval_minutes = ?, expected_status = ?, enabled = ?, next_run_at = ?
+			WHERE id = ?
+		`, p.URL, p.Interval, p.ExpectedStatus, p.Enabled, time.Now(), c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating keep-alive ping: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save keep-alive ping",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/keepalive")
+	})
+
+	adminGroup.DELETE("/keepalive/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM keepalive_pings WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete keep-alive ping"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Keep-alive ping not found"})
+			return
+		}
+		dbExec(ctx, "DELETE FROM keepalive_results WHERE ping_id = ?", c.Param("id"))
+
+		log.Printf("Keep-alive ping %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Keep-alive ping deleted"})
+	})
+}
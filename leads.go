@@ -0,0 +1,288 @@
+// leads.go - Freelance quote requests and their pipeline
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pipeline stages, in order
+const (
+	leadNew       = "new"
+	leadContacted = "contacted"
+	leadWon       = "won"
+	leadLost      = "lost"
+)
+
+var leadStatuses = []string{leadNew, leadContacted, leadWon, leadLost}
+
+var leadStatusLabels = map[string]string{
+	leadNew:       "New",
+	leadContacted: "Contacted",
+	leadWon:       "Won",
+	leadLost:      "Lost",
+}
+
+// Choices offered on the form; anything else is refused
+var (
+	leadProjectTypes = []string{"Website", "Web application", "API or backend", "Command-line tool", "Something else"}
+	leadBudgets      = []string{"Under $1,000", "$1,000 to $5,000", "$5,000 to $15,000", "Over $15,000", "Not sure yet"}
+	leadTimelines    = []string{"As soon as possible", "Within a month", "1 to 3 months", "Flexible"}
+)
+
+// Quote requests each client may send per minute
+const leadRateLimit = 3
+
+var leadRateLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// A quote request
+type Lead struct {
+	ID          int
+	Name        string
+	Email       string
+	Company     string
+	ProjectType string
+	Budget      string
+	Timeline    string
+	Details     string
+	Status      string
+	Notes       string // mine, never shown to the lead
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// A pipeline column in the admin
+type LeadStage struct {
+	Status string
+	Label  string
+	Leads  []Lead
+}
+
+// Initialize lead storage
+func initLeads() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS leads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		company TEXT NOT NULL DEFAULT '',
+		project_type TEXT NOT NULL,
+		budget TEXT NOT NULL,
+		timeline TEXT NOT NULL,
+		details TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'new',
+		notes TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create leads table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_leads_status ON leads (status)`)
+}
+
+func oneOf(value string, choices []string) bool {
+	for _, choice := range choices {
+		if value == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the /hire form, returning a message for the visitor if invalid
+func leadFromForm(c *gin.Context) (Lead, string) {
+	l := Lead{
+		Name:        strings.TrimSpace(c.PostForm("name")),
+		Email:       strings.TrimSpace(c.PostForm("email")),
+		Company:     strings.TrimSpace(c.PostForm("company")),
+		ProjectType: c.PostForm("project_type"),
+		Budget:      c.PostForm("budget"),
+		Timeline:    c.PostForm("timeline"),
+		Details:     strings.TrimSpace(c.PostForm("details")),
+	}
+	if problem := validateContactForm(l.Name, l.Email, l.Details); problem != "" { // from main.go
+		return l, problem
+	}
+	switch {
+	case len(l.Company) > contactMaxName || strings.ContainsAny(l.Company, "\r\n"):
+		return l, "Please enter a shorter company name on a single line."
+	case !oneOf(l.ProjectType, leadProjectTypes):
+		return l, "Please choose a project type."
+	case !oneOf(l.Budget, leadBudgets):
+		return l, "Please choose a budget range."
+	case !oneOf(l.Timeline, leadTimelines):
+		return l, "Please choose a timeline."
+	}
+	return l, ""
+}
+
+// The request as text, for notifications
+func (l Lead) Summary() string {
+	company := l.Company
+	if company == "" {
+		company = "-"
+	}
+	return fmt.Sprintf("Project type: %s\nBudget: %s\nTimeline: %s\nCompany: %s\n\n%s",
+		l.ProjectType, l.Budget, l.Timeline, company, l.Details)
+}
+
+// All leads grouped into pipeline stages, newest first within each
+func getLeadPipeline(ctx context.Context) ([]LeadStage, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, name, email, company, project_type, budget, timeline, details, status, notes, created_at, updated_at
+		FROM leads ORDER BY created_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byStatus := map[string][]Lead{}
+	for rows.Next() {
+		var l Lead
+		err := rows.Scan(&l.ID, &l.Name, &l.Email, &l.Company, &l.ProjectType, &l.Budget, &l.Timeline,
+			&l.Details, &l.Status, &l.Notes, &l.CreatedAt, &l.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		byStatus[l.Status] = append(byStatus[l.Status], l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stages := make([]LeadStage, len(leadStatuses))
+	for i, status := range leadStatuses {
+		stages[i] = LeadStage{Status: status, Label: leadStatusLabels[status], Leads: byStatus[status]}
+	}
+	return stages, nil
+}
+
+// Setup the public quote request form
+func setupLeadRoutes(r *gin.Engine) {
+	render := func(c *gin.Context, status int, data gin.H) {
+		data["title"] = "Hire Me"
+		data["projectTypes"] = leadProjectTypes
+		data["budgets"] = leadBudgets
+		data["timelines"] = leadTimelines
+		data["seo"] = getPageSEO(c.Request.Context(), "/hire")
+		c.HTML(status, "hire.html", data)
+	}
+
+	r.GET("/hire", func(c *gin.Context) {
+		render(c, http.StatusOK, gin.H{
+			"form": Lead{},
+			"sent": c.Query("sent") == "1",
+		})
+	})
+
+	r.POST("/hire", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		if !leadRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, leadRateLimit) {
+			c.Header("Retry-After", "60")
+			render(c, http.StatusTooManyRequests, gin.H{
+				"form":    Lead{},
+				"problem": "Too many requests; please try again in a minute.",
+			})
+			return
+		}
+
+		l, problem := leadFromForm(c)
+		if problem != "" {
+			render(c, http.StatusBadRequest, gin.H{"form": l, "problem": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO leads (name, email, company, project_type, budget, timeline, details)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, l.Name, l.Email, l.Company, l.ProjectType, l.Budget, l.Timeline, l.Details)
+		if err != nil {
+			log.Printf("Error saving lead: %v", err)
+			render(c, http.StatusInternalServerError, gin.H{
+				"form":    l,
+				"problem": "Sorry, your request couldn't be saved. Please try again later.",
+			})
+			return
+		}
+
+		// Announced like a freelance inquiry from the contact form; the
+		// lead is already stored, so a failed notification isn't the
+		// visitor's problem
+		if siteFromContext(ctx).Primary() {
+			if err := routeContactMessage(ctx, 0, "freelance", l.Name, l.Email, l.Summary(), ipHash); err != nil { // from contactrouting.go
+				log.Printf("Error sending lead notification: %v", err)
+			}
+		}
+
+		log.Printf("Quote request received from %s", ipHash)
+		c.Redirect(http.StatusSeeOther, "/hire?sent=1")
+	})
+}
+
+// Setup the admin lead pipeline
+func setupLeadAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/leads", func(c *gin.Context) {
+		stages, err := getLeadPipeline(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading leads: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load leads",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-leads.html", gin.H{
+			"stages":   stages,
+			"statuses": leadStatuses,
+			"labels":   leadStatusLabels,
+			"message":  c.Query("message"),
+		})
+	})
+
+	// Move a lead along the pipeline and keep notes on it
+	adminGroup.POST("/leads/:id", func(c *gin.Context) {
+		status := c.PostForm("status")
+		if leadStatusLabels[status] == "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown lead status",
+			})
+			return
+		}
+		result, err := dbExec(c.Request.Context(), "UPDATE leads SET status = ?, notes = ?, updated_at = ? WHERE id = ?",
+			status, strings.TrimSpace(c.PostForm("notes")), time.Now(), c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating lead: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to update lead",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Lead not found"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/leads?message="+url.QueryEscape("Lead saved")+"#lead-"+c.Param("id"))
+	})
+
+	adminGroup.POST("/leads/:id/delete", func(c *gin.Context) {
+		if _, err := dbExec(c.Request.Context(), "DELETE FROM leads WHERE id = ?", c.Param("id")); err != nil {
+			log.Printf("Error deleting lead: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete lead",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/leads?message="+url.QueryEscape("Lead deleted"))
+	})
+}
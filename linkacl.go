@@ -0,0 +1,93 @@
+// linkacl.go - Referrer and country restrictions for short links
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Visitor's two-letter country code, or "" when unknown. The header
+// COUNTRY_HEADER names (like CF-IPCountry) is read only on requests that
+// came through a trusted proxy (from main.go), as anyone can send it to the
+// app directly; without it the client IP is looked up in GEOIP_DB_PATH.
+func requestCountry(c *gin.Context) string {
+	if header := os.Getenv("COUNTRY_HEADER"); header != "" && fromTrustedProxy(c) {
+		code := strings.ToUpper(strings.TrimSpace(c.GetHeader(header)))
+		// Cloudflare uses XX for unknown and T1 for Tor
+		if len(code) == 2 && code != "XX" {
+			return code
+		}
+	}
+	return geoIPCountry(c.ClientIP()) // from geoip.go
+}
+
+// Parse a list of domains, accepting URLs or bare hostnames
+func normalizeReferrerDomains(value string) []string {
+	var domains []string
+	for _, item := range splitList(strings.ReplaceAll(value, "\n", ","), ",") {
+		item = strings.ToLower(item)
+		if u, err := url.Parse(item); err == nil && u.Host != "" {
+			item = u.Hostname()
+		}
+		item = strings.TrimPrefix(strings.TrimSuffix(item, "/"), "*.")
+		if item != "" {
+			domains = append(domains, item)
+		}
+	}
+	return domains
+}
+
+// Parse a list of two-letter country codes
+func normalizeCountryCodes(value string) []string {
+	var codes []string
+	for _, item := range splitList(strings.ReplaceAll(value, " ", ","), ",") {
+		if item = strings.ToUpper(item); len(item) == 2 {
+			codes = append(codes, item)
+		}
+	}
+	return codes
+}
+
+// Whether the referrer's host is one of the domains or a subdomain of one
+func referrerAllowed(referrer string, domains []string) bool {
+	ref, err := url.Parse(referrer)
+	if err != nil || ref.Host == "" {
+		return false
+	}
+	host := strings.ToLower(ref.Hostname())
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a request against a link's restrictions; returns a reason when blocked
+func checkLinkAccess(c *gin.Context, link LinkSettings) (string, bool) {
+	if len(link.AllowedReferrers) > 0 && !referrerAllowed(c.Request.Referer(), link.AllowedReferrers) {
+		return "This link only works when followed from an approved site.", false
+	}
+
+	if len(link.BlockedCountries) > 0 {
+		country := requestCountry(c)
+		for _, blocked := range link.BlockedCountries {
+			if country == blocked {
+				return "This link is not available in your region.", false
+			}
+		}
+	}
+
+	return "", true
+}
+
+// Render the restriction page
+func renderLinkRestricted(c *gin.Context, reason string) {
+	c.HTML(http.StatusForbidden, "link-restricted.html", gin.H{
+		"message": reason,
+	})
+}
@@ -0,0 +1,45 @@
+// linkacl_test.go - Country header trust
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestCountry(t *testing.T) {
+	t.Setenv("COUNTRY_HEADER", "CF-IPCountry")
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"through proxy", "127.0.0.1:4000", map[string]string{"CF-IPCountry": "de"}, "DE"},
+		{"direct", "203.0.113.9:4000", map[string]string{"CF-IPCountry": "DE"}, ""},
+		{"other header", "127.0.0.1:4000", map[string]string{"X-Country-Code": "DE"}, ""},
+		{"unknown", "127.0.0.1:4000", map[string]string{"CF-IPCountry": "XX"}, ""},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			c.Request.Header.Set(k, v)
+		}
+		if got := requestCountry(c); got != tt.want {
+			t.Errorf("%s: requestCountry = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	t.Setenv("COUNTRY_HEADER", "")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.RemoteAddr = "127.0.0.1:4000"
+	c.Request.Header.Set("CF-IPCountry", "DE")
+	if got := requestCountry(c); got != "" {
+		t.Errorf("without COUNTRY_HEADER: requestCountry = %q, want none", got)
+	}
+}
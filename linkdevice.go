@@ -0,0 +1,49 @@
+// linkdevice.go - Device-based destinations for short links
+package main
+
+import "strings"
+
+// Device classes a link can route on
+const (
+	deviceIOS     = "ios"
+	deviceAndroid = "android"
+	deviceDesktop = "desktop"
+	deviceOther   = "other" // other mobile platforms, bots, empty user agents
+)
+
+// Classify a user agent string
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return deviceOther
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return deviceIOS
+	case strings.Contains(ua, "android"):
+		return deviceAndroid
+	case strings.Contains(ua, "mobile"), strings.Contains(ua, "bot"), strings.Contains(ua, "spider"), strings.Contains(ua, "crawl"):
+		return deviceOther
+	case strings.Contains(ua, "windows"), strings.Contains(ua, "macintosh"), strings.Contains(ua, "x11"), strings.Contains(ua, "cros"):
+		// iPadOS Safari reports itself as a Mac by default, so it lands here too
+		return deviceDesktop
+	}
+	return deviceOther
+}
+
+// Destination for a visitor's device, falling back to the link's main URL
+func (link LinkSettings) DestinationFor(userAgent string) string {
+	var destination string
+	switch deviceClass(userAgent) {
+	case deviceIOS:
+		destination = link.IOSURL
+	case deviceAndroid:
+		destination = link.AndroidURL
+	case deviceDesktop:
+		destination = link.DesktopURL
+	}
+
+	if destination == "" {
+		return link.OriginalURL
+	}
+	return destination
+}
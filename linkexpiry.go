@@ -0,0 +1,116 @@
+// linkexpiry.go - Short link expiry and purge
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lifetime choices offered when shortening, in days; 0 never expires
+var linkExpiryDays = []int{0, 1, 7, 30}
+
+// Expired links show the expired page this long before they're purged and
+// /s/:code becomes a plain 404
+const linkExpiryGrace = 7 * 24 * time.Hour
+
+const linkExpiryPurgeEvery = time.Hour
+
+// Tables whose rows belong to a short link and go with it; names are
+// interpolated into SQL
+var linkExpiryTables = []string{"click_events", "click_daily", "link_schedules", "link_variants", "og_images"}
+
+// Add the expiry column and start the purge job
+func initLinkExpiry() {
+	db.Exec(`ALTER TABLE urls ADD COLUMN expires_at DATETIME`) // Ignore error if column already exists
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_urls_expires ON urls (expires_at)`)
+
+	startWorker("link-expiry-purge", linkExpiryWorker) // from safego.go
+}
+
+// Expiry for a lifetime chosen on a form, in days; ok is false for choices
+// not offered
+func linkExpiryFromForm(value string) (expiresAt sql.NullTime, ok bool) {
+	if value == "" {
+		return sql.NullTime{}, true
+	}
+	days, err := strconv.Atoi(value)
+	for _, d := range linkExpiryDays {
+		ok = ok || (err == nil && d == days)
+	}
+	if !ok || days == 0 {
+		return sql.NullTime{}, ok
+	}
+	return sql.NullTime{Time: time.Now().AddDate(0, 0, days).Truncate(time.Second), Valid: true}, true
+}
+
+// Whether the link has passed its expiry
+func (l LinkSettings) Expired() bool {
+	return l.ExpiresAt.Valid && !time.Now().Before(l.ExpiresAt.Time)
+}
+
+// Page for a link that has expired; 410 tells crawlers it's gone for good
+func renderLinkExpired(c *gin.Context, link LinkSettings) {
+	c.Header("Cache-Control", "no-store")
+	c.HTML(http.StatusGone, "404.html", gin.H{
+		"heading": "Link Expired",
+		"message": "This short link expired on " + link.ExpiresAt.Time.Format("Jan 2, 2006") + ".",
+	})
+}
+
+func linkExpiryWorker() {
+	ticker := time.NewTicker(linkExpiryPurgeEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeExpiredLinks(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("link-expiry-purge", linkExpiryPurgeEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Delete links expired longer than the grace period, with their clicks,
+// schedules, variants and preview card
+func purgeExpiredLinks(ctx context.Context) error {
+	tx, cancel, err := dbBegin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	cutoff := time.Now().Add(-linkExpiryGrace)
+	expired := "SELECT short_code FROM urls WHERE expires_at IS NOT NULL AND expires_at <= ?"
+	for _, table := range linkExpiryTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE short_code IN ("+expired+")", cutoff); err != nil {
+			log.Printf("Error purging %s of expired links: %v", table, err)
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM urls WHERE expires_at IS NOT NULL AND expires_at <= ?", cutoff)
+	if err != nil {
+		log.Printf("Error purging expired links: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Link expiry: purged %d expired short links", n)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// links.go - Per-link settings and the admin link editor
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Short link with its optional settings
+type LinkSettings struct {
+	ShortCode   string
+	OriginalURL string
+	Clicks      int
+	CreatedAt   time.Time
+
+	// Access restrictions (from linkacl.go)
+	AllowedReferrers []string
+	BlockedCountries []string
+
+	// Per-device destinations (from linkdevice.go)
+	IOSURL     string
+	AndroidURL string
+	DesktopURL string
+
+	// Split testing (from linkvariants.go)
+	VariantAssignment string
+
+	// When the link stops working (from linkexpiry.go)
+	ExpiresAt sql.NullTime
+}
+
+// Add per-link setting columns to the urls table
+func initLinkSettings() {
+	// Ignore errors if the columns already exist
+	db.Exec(`ALTER TABLE urls ADD COLUMN allowed_referrers TEXT`)
+	db.Exec(`ALTER TABLE urls ADD COLUMN blocked_countries TEXT`)
+	db.Exec(`ALTER TABLE urls ADD COLUMN ios_url TEXT`)
+	db.Exec(`ALTER TABLE urls ADD COLUMN android_url TEXT`)
+	db.Exec(`ALTER TABLE urls ADD COLUMN desktop_url TEXT`)
+}
+
+// Load a short link's settings
+func getLinkSettings(ctx context.Context, shortCode string) (LinkSettings, error) {
+	var link LinkSettings
+	var allowedReferrers, blockedCountries string
+	err := dbQueryRow(ctx, `
+		SELECT short_code, original_url, COALESCE(clicks, 0), created_at,
+			COALESCE(allowed_referrers, ''), COALESCE(blocked_countries, ''),
+			COALESCE(ios_url, ''), COALESCE(android_url, ''), COALESCE(desktop_url, ''),
+			COALESCE(variant_assignment, 'random'), expires_at
+		FROM urls WHERE short_code = ?
+	`, shortCode).Scan(&link.ShortCode, &link.OriginalURL, &link.Clicks, &link.CreatedAt,
+		&allowedReferrers, &blockedCountries,
+		&link.IOSURL, &link.AndroidURL, &link.DesktopURL,
+		&link.VariantAssignment, &link.ExpiresAt)
+	if err != nil {
+		return link, err
+	}
+
+	link.AllowedReferrers = splitList(allowedReferrers, ",")
+	link.BlockedCountries = splitList(blockedCountries, ",")
+	return link, nil
+}
+
+// Setup admin link editor routes
+func setupLinkAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/urls/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		link, err := getLinkSettings(ctx, c.Param("code"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading link: %v", err)
+			}
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Short URL not found",
+			})
+			return
+		}
+
+		schedules, err := getLinkSchedules(ctx, link.ShortCode)
+		if err != nil {
+			log.Printf("Error loading link schedules: %v", err)
+		}
+		variants, err := getLinkVariants(ctx, link.ShortCode)
+		if err != nil {
+			log.Printf("Error loading link variants: %v", err)
+		}
+		clicks, err := getClickBreakdown(ctx, link.ShortCode, 30) // from clickevents.go
+		if err != nil {
+			log.Printf("Error loading click breakdown: %v", err)
+		}
+		totalWeight := 0
+		for _, v := range variants {
+			totalWeight += v.Weight
+		}
+
+		c.HTML(http.StatusOK, "admin-link-edit.html", gin.H{
+			"link":        link,
+			"schedules":   schedules,
+			"variants":    variants,
+			"totalWeight": totalWeight,
+			"clicks":      clicks,
+			"now":         time.Now(),
+		})
+	})
+
+	adminGroup.POST("/urls/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		code := c.Param("code")
+		originalURL := strings.TrimSpace(c.PostForm("original_url"))
+		if !isPublicLongURL(originalURL) { // from main.go
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Destination must be a public http(s) URL",
+			})
+			return
+		}
+
+		// Device destinations are optional but must be valid when given
+		deviceURLs := map[string]string{}
+		for _, field := range []string{"ios_url", "android_url", "desktop_url"} {
+			value := strings.TrimSpace(c.PostForm(field))
+			if value != "" && !isPublicLongURL(value) {
+				c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+					"error": "Device destinations must be public http(s) URLs",
+				})
+				return
+			}
+			deviceURLs[field] = value
+		}
+
+		assignment := variantRandom
+		if c.PostForm("variant_assignment") == variantSticky {
+			assignment = variantSticky
+		}
+
+		result, err := dbExec(ctx, `
+			UPDATE urls SET original_url = ?, allowed_referrers = ?, blocked_countries = ?,
+				ios_url = ?, android_url = ?, desktop_url = ?, variant_assignment = ?
+			WHERE short_code = ?
+		`, originalURL,
+			strings.Join(normalizeReferrerDomains(c.PostForm("allowed_referrers")), ","),
+			strings.Join(normalizeCountryCodes(c.PostForm("blocked_countries")), ","),
+			deviceURLs["ios_url"], deviceURLs["android_url"], deviceURLs["desktop_url"],
+			assignment, code)
+		if err != nil {
+			log.Printf("Error saving link: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save link",
+			})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Short URL not found",
+			})
+			return
+		}
+
+		log.Printf("Short URL %s updated by admin from %s", code, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/urls")
+	})
+}
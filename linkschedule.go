@@ -0,0 +1,163 @@
+// linkschedule.go - Scheduled destination swaps for short links
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Destination that takes over a short link for a period of time
+type LinkSchedule struct {
+	ID          int          `json:"id"`
+	ShortCode   string       `json:"short_code"`
+	Destination string       `json:"destination"`
+	StartsAt    time.Time    `json:"starts_at"`
+	EndsAt      sql.NullTime `json:"-"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// Layout of <input type="datetime-local">
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// Whether the schedule applies at the given time
+func (s LinkSchedule) ActiveAt(now time.Time) bool {
+	return !now.Before(s.StartsAt) && (!s.EndsAt.Valid || now.Before(s.EndsAt.Time))
+}
+
+// Initialize link schedule storage
+func initLinkSchedules() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS link_schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		short_code TEXT NOT NULL,
+		destination TEXT NOT NULL,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create link_schedules table:", err)
+	}
+}
+
+// Get a link's schedules in start order
+func getLinkSchedules(ctx context.Context, shortCode string) ([]LinkSchedule, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, short_code, destination, starts_at, ends_at, created_at
+		FROM link_schedules WHERE short_code = ?
+	`, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []LinkSchedule
+	for rows.Next() {
+		var s LinkSchedule
+		if err := rows.Scan(&s.ID, &s.ShortCode, &s.Destination, &s.StartsAt, &s.EndsAt, &s.CreatedAt); err != nil {
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+
+	// Timestamps are stored as text, so sort here rather than in SQL
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].StartsAt.Before(schedules[j].StartsAt)
+	})
+	return schedules, rows.Err()
+}
+
+// Destination scheduled for now; the most recently started schedule wins
+func scheduledDestination(ctx context.Context, shortCode string) (string, bool) {
+	schedules, err := getLinkSchedules(ctx, shortCode)
+	if err != nil {
+		log.Printf("Error loading link schedules: %v", err)
+		return "", false
+	}
+
+	now := time.Now()
+	for i := len(schedules) - 1; i >= 0; i-- {
+		if schedules[i].ActiveAt(now) {
+			return schedules[i].Destination, true
+		}
+	}
+	return "", false
+}
+
+// Setup admin link schedule routes
+func setupLinkScheduleAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/urls/:code/schedules", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		code := c.Param("code")
+		destination := strings.TrimSpace(c.PostForm("destination"))
+		if !isPublicLongURL(destination) { // from main.go
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Scheduled destination must be a public http(s) URL",
+			})
+			return
+		}
+
+		// Times are entered in the server's local time zone
+		startsAt, err := time.ParseInLocation(datetimeLocalLayout, c.PostForm("starts_at"), time.Local)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A schedule needs a start time",
+			})
+			return
+		}
+		var endsAt sql.NullTime
+		if value := c.PostForm("ends_at"); value != "" {
+			endsAt.Time, err = time.ParseInLocation(datetimeLocalLayout, value, time.Local)
+			endsAt.Valid = err == nil
+			if !endsAt.Valid || !endsAt.Time.After(startsAt) {
+				c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+					"error": "The end time must be after the start time",
+				})
+				return
+			}
+		}
+
+		if _, err := getLinkSettings(ctx, code); err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Short URL not found",
+			})
+			return
+		}
+
+		_, err = dbExec(ctx, "INSERT INTO link_schedules (short_code, destination, starts_at, ends_at) VALUES (?, ?, ?, ?)",
+			code, destination, startsAt, endsAt)
+		if err != nil {
+			log.Printf("Error saving link schedule: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save schedule",
+			})
+			return
+		}
+
+		log.Printf("Schedule added to short URL %s by admin from %s", code, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/urls/"+code)
+	})
+
+	adminGroup.DELETE("/urls/:code/schedules/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM link_schedules WHERE id = ? AND short_code = ?", c.Param("id"), c.Param("code"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+	})
+}
@@ -0,0 +1,187 @@
+// linkvariants.go - Weighted destinations for short links
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Alternative destination sharing a short link's traffic
+type LinkVariant struct {
+	ID          int       `json:"id"`
+	ShortCode   string    `json:"short_code"`
+	Destination string    `json:"destination"`
+	Weight      int       `json:"weight"`
+	Clicks      int       `json:"clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Percentage of traffic the variant receives out of a total weight
+func (v LinkVariant) SharePercent(totalWeight int) int {
+	if totalWeight <= 0 {
+		return 0
+	}
+	return v.Weight * 100 / totalWeight
+}
+
+// How visitors are assigned to variants
+const (
+	variantRandom = "random" // every click draws again
+	variantSticky = "sticky" // the same visitor always gets the same variant
+)
+
+// Initialize link variant storage
+func initLinkVariants() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS link_variants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		short_code TEXT NOT NULL,
+		destination TEXT NOT NULL,
+		weight INTEGER NOT NULL DEFAULT 1,
+		clicks INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create link_variants table:", err)
+	}
+
+	db.Exec(`ALTER TABLE urls ADD COLUMN variant_assignment TEXT DEFAULT 'random'`) // Ignore error if column already exists
+}
+
+// Get a link's variants
+func getLinkVariants(ctx context.Context, shortCode string) ([]LinkVariant, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, short_code, destination, weight, COALESCE(clicks, 0), created_at
+		FROM link_variants WHERE short_code = ?
+		ORDER BY id
+	`, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []LinkVariant
+	for rows.Next() {
+		var v LinkVariant
+		if err := rows.Scan(&v.ID, &v.ShortCode, &v.Destination, &v.Weight, &v.Clicks, &v.CreatedAt); err != nil {
+			continue
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// Pick a variant by weight; roll is in [0, total weight)
+func pickVariant(variants []LinkVariant, roll int) LinkVariant {
+	for _, v := range variants {
+		if roll < v.Weight {
+			return v
+		}
+		roll -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
+
+// Choose a variant for this request and count the click
+func chooseLinkVariant(ctx context.Context, c *gin.Context, link LinkSettings) (LinkVariant, bool) {
+	variants, err := getLinkVariants(ctx, link.ShortCode)
+	if err != nil {
+		log.Printf("Error loading link variants: %v", err)
+		return LinkVariant{}, false
+	}
+
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return LinkVariant{}, false
+	}
+
+	// Previews always resolve the same way and aren't counted
+	preview := isPreview(c)
+
+	var roll int
+	if preview {
+		roll = 0
+	} else if link.VariantAssignment == variantSticky {
+		// Same hashed visitor + user agent always lands in the same bucket
+		h := fnv.New32a()
+		h.Write([]byte(link.ShortCode + "|" + hashIP(c.ClientIP()) + "|" + c.GetHeader("User-Agent")))
+		roll = int(h.Sum32() % uint32(total))
+	} else {
+		roll = rand.Intn(total)
+	}
+
+	variant := pickVariant(variants, roll)
+	if preview {
+		return variant, true
+	}
+	safeGoRetry("variant-click", 3, func() error {
+		_, err := dbExec(context.WithoutCancel(ctx), "UPDATE link_variants SET clicks = COALESCE(clicks, 0) + 1 WHERE id = ?", variant.ID)
+		if err != nil {
+			log.Printf("Error updating variant click count: %v", err)
+		}
+		return err
+	})
+	return variant, true
+}
+
+// Setup admin link variant routes
+func setupLinkVariantAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/urls/:code/variants", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		code := c.Param("code")
+		destination := strings.TrimSpace(c.PostForm("destination"))
+		weight, err := strconv.Atoi(c.PostForm("weight"))
+		if !isPublicLongURL(destination) || err != nil || weight < 1 || weight > 1000 {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A variant needs a public http(s) destination and a weight from 1 to 1000",
+			})
+			return
+		}
+
+		if _, err := getLinkSettings(ctx, code); err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Short URL not found",
+			})
+			return
+		}
+
+		_, err = dbExec(ctx, "INSERT INTO link_variants (short_code, destination, weight) VALUES (?, ?, ?)", code, destination, weight)
+		if err != nil {
+			log.Printf("Error saving link variant: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save variant",
+			})
+			return
+		}
+
+		log.Printf("Variant added to short URL %s by admin from %s", code, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/urls/"+code)
+	})
+
+	adminGroup.DELETE("/urls/:code/variants/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM link_variants WHERE id = ? AND short_code = ?", c.Param("id"), c.Param("code"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Variant deleted"})
+	})
+}
@@ -0,0 +1,252 @@
+//go:build loadtest
+
+// loadtest.go - Synthetic data generator for performance work (dev builds only)
+//
+// Build with the loadtest tag and pass the seed flags, e.g.
+//
+//	DATABASE_PATH=./loadtest.db go run -tags loadtest . -seed-visitors 2000000 -seed-links 5000
+//
+// The generator seeds the database and exits without starting the server.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rows inserted per transaction
+const seedBatchSize = 10000
+
+var (
+	seedVisitors = flag.Int("seed-visitors", 0, "synthetic visitor rows to insert")
+	seedLinks    = flag.Int("seed-links", 0, "synthetic short links to insert")
+	seedDays     = flag.Int("seed-days", 365, "days of history to spread synthetic data over")
+	seedRandSeed = flag.Int64("seed-rand", 1, "random seed, for repeatable data sets")
+)
+
+// Weighted choice of a value
+type weighted struct {
+	value  string
+	weight int
+}
+
+// Roughly the traffic mix of a small portfolio site
+var (
+	seedPaths = []weighted{
+		{"/", 40}, {"/blog", 10}, {"/resume", 6}, {"/resume.html", 3},
+		{"/work-content", 8}, {"/education-content", 4}, {"/contact-form", 5},
+		{"/url-shortener", 4}, {"/feed.xml", 5}, {"/sitemap.xml", 2},
+	}
+	seedUserAgents = []weighted{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36", 35},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", 15},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", 20},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36", 12},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0", 8},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", 6},
+		{"curl/8.7.1", 4},
+	}
+	seedCountries = []weighted{
+		{"US", 40}, {"GB", 10}, {"CA", 8}, {"DE", 8}, {"IN", 10}, {"FR", 5},
+		{"AU", 4}, {"BR", 4}, {"NL", 3}, {"", 8},
+	}
+)
+
+// Seed synthetic data when a seed flag is given; reports whether it did,
+// in which case main exits instead of serving
+func runLoadTestSeed() bool {
+	flag.Parse()
+	if *seedVisitors <= 0 && *seedLinks <= 0 {
+		return false
+	}
+	if gin.Mode() == gin.ReleaseMode {
+		log.Fatal("Refusing to generate synthetic data in release mode")
+	}
+
+	rng := rand.New(rand.NewSource(*seedRandSeed))
+	start := time.Now()
+
+	codes, err := seedSyntheticLinks(rng, *seedLinks, *seedDays)
+	if err != nil {
+		log.Fatal("Failed to seed links:", err)
+	}
+	if err := seedSyntheticVisitors(rng, *seedVisitors, *seedDays, codes); err != nil {
+		log.Fatal("Failed to seed visitors:", err)
+	}
+
+	log.Printf("Synthetic data seeded in %s", time.Since(start).Round(time.Millisecond))
+	return true
+}
+
+// Insert links created over the period; returns their short codes, most
+// popular first, for the visitor generator to click through
+func seedSyntheticLinks(rng *rand.Rand, count, days int) ([]string, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+
+	codes := make([]string, 0, count)
+	now := time.Now().UTC()
+	for inserted := 0; inserted < count; inserted += seedBatchSize {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := tx.Prepare("INSERT OR IGNORE INTO urls (short_code, original_url, created_at, clicks) VALUES (?, ?, ?, 0)")
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		for i := inserted; i < count && i < inserted+seedBatchSize; i++ {
+			code := fmt.Sprintf("lt%06d", i)
+			created := now.Add(-time.Duration(rng.Int63n(int64(days) * int64(24*time.Hour))))
+			_, err := stmt.Exec(code, fmt.Sprintf("https://example.com/loadtest/%d?ref=%d", i, rng.Intn(1000)),
+				created.Format(sqliteTimestamp))
+			if err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return nil, err
+			}
+			codes = append(codes, code)
+		}
+
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		log.Printf("Seeded %d/%d links", min(inserted+seedBatchSize, count), count)
+	}
+	return codes, nil
+}
+
+// Insert page views with daily and weekly traffic cycles, growth over the
+// period, returning visitors and long-tailed link popularity; each /s/
+// visit is also a click event, and link click counts are updated to match
+func seedSyntheticVisitors(rng *rand.Rand, count, days int, codes []string) error {
+	if count <= 0 {
+		return nil
+	}
+
+	// Visitor pool about a third the size of the view count; Zipf makes a
+	// few heavy returners and a long tail of one-off visits
+	pool := uint64(max(count/3, 2))
+	visitorZipf := rand.NewZipf(rng, 1.1, 1, pool-1)
+	var linkZipf *rand.Zipf
+	if len(codes) > 1 {
+		linkZipf = rand.NewZipf(rng, 1.2, 1, uint64(len(codes)-1))
+	}
+
+	clicks := make(map[string]int)
+	end := time.Now().UTC()
+	for inserted := 0; inserted < count; inserted += seedBatchSize {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare("INSERT INTO visitors (hashed_ip, user_agent, path, timestamp, country) VALUES (?, ?, ?, ?, ?)")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		clickStmt, err := tx.Prepare("INSERT INTO click_events (short_code, clicked_at, country, device) VALUES (?, ?, ?, ?)")
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+
+		for i := inserted; i < count && i < inserted+seedBatchSize; i++ {
+			visitor := visitorZipf.Uint64()
+			path := pickWeighted(rng, seedPaths)
+			userAgent := pickWeighted(rng, seedUserAgents)
+			timestamp := syntheticTimestamp(rng, end, days).Format(sqliteTimestamp)
+			country := pickWeighted(rng, seedCountries)
+			if linkZipf != nil && rng.Intn(100) < 25 {
+				code := codes[linkZipf.Uint64()]
+				path = "/s/" + code
+				clicks[code]++
+				_, err = clickStmt.Exec(code, timestamp, country, deviceClass(userAgent)) // from linkdevice.go
+			}
+
+			if err == nil {
+				_, err = stmt.Exec(syntheticVisitorHash(visitor), userAgent, path, timestamp, country)
+			}
+			if err != nil {
+				stmt.Close()
+				clickStmt.Close()
+				tx.Rollback()
+				return err
+			}
+		}
+
+		stmt.Close()
+		clickStmt.Close()
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Seeded %d/%d visitors", min(inserted+seedBatchSize, count), count)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for code, n := range clicks {
+		// Like recordClick (from clickevents.go), keeping what was counted before events as the baseline
+		if _, err := tx.Exec(`
+			UPDATE urls SET clicks_baseline = COALESCE(clicks_baseline, COALESCE(clicks, 0)), clicks = COALESCE(clicks, 0) + ?
+			WHERE short_code = ?`, n, code); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Stable fake hash for a synthetic visitor, shaped like hashIP output
+func syntheticVisitorHash(visitor uint64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("loadtest-visitor-%d", visitor)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Random time in the last days, weighted towards recent days, weekdays
+// and daytime (UTC) hours
+func syntheticTimestamp(rng *rand.Rand, end time.Time, days int) time.Time {
+	for {
+		// Traffic grows over the period: density rises linearly to today
+		age := time.Duration((1 - math.Sqrt(rng.Float64())) * float64(days) * float64(24*time.Hour))
+		t := end.Add(-age)
+
+		accept := 0.35 + 0.65*math.Sin(math.Pi*float64(t.Hour())/24)
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			accept *= 0.6
+		}
+		if rng.Float64() < accept {
+			return t
+		}
+	}
+}
+
+func pickWeighted(rng *rand.Rand, choices []weighted) string {
+	total := 0
+	for _, c := range choices {
+		total += c.weight
+	}
+	roll := rng.Intn(total)
+	for _, c := range choices {
+		if roll < c.weight {
+			return c.value
+		}
+		roll -= c.weight
+	}
+	return choices[len(choices)-1].value
+}
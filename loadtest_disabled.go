@@ -0,0 +1,7 @@
+//go:build !loadtest
+
+// loadtest_disabled.go - Synthetic data generator stub for normal builds
+package main
+
+// The generator is only compiled in with the loadtest build tag (loadtest.go)
+func runLoadTestSeed() bool { return false }
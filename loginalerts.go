@@ -0,0 +1,251 @@
+// loginalerts.go - Alerts for admin sign-ins from new countries
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Review states of a new-country sign-in
+const (
+	loginAlertPending  = "pending"
+	loginAlertApproved = "approved"
+	loginAlertDenied   = "denied"
+)
+
+// How long the emailed review link works
+const loginAlertLinkLifetime = 7 * 24 * time.Hour
+
+// Sign-in from a country not seen before, as shown for review
+type LoginAlert struct {
+	ID         int
+	SessionID  string
+	Country    string
+	IPHash     string
+	UserAgent  string
+	Status     string
+	CreatedAt  time.Time
+	ResolvedAt sql.NullTime
+	ReviewURL  string
+}
+
+// Initialize storage for the countries admins sign in from
+func initLoginAlerts() {
+	createCountries := `
+	CREATE TABLE IF NOT EXISTS admin_login_countries (
+		country TEXT PRIMARY KEY,
+		first_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	createAlerts := `
+	CREATE TABLE IF NOT EXISTS admin_login_alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		country TEXT NOT NULL,
+		ip_hash TEXT,
+		user_agent TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		resolved_at DATETIME
+	)`
+
+	for _, createTable := range []string{createCountries, createAlerts} {
+		if _, err := db.Exec(createTable); err != nil {
+			log.Fatal("Failed to create login alert tables:", err)
+		}
+	}
+}
+
+// Compare a new session's country with the ones the admin has signed in
+// from. The first known country is trusted as home; a later new one is
+// emailed to the owner for review. The client IP is looked up in the GeoIP
+// database when there is one (from geoip.go), falling back to the trusted
+// CDN header (from linkacl.go); sign-ins with neither are skipped.
+func checkLoginCountry(c *gin.Context, site *Site, sessionID, ipHash string) {
+	ctx := c.Request.Context()
+	country := geoIPCountry(c.ClientIP())
+	if country == "" {
+		country = requestCountry(c)
+	}
+	if country == "" {
+		return
+	}
+
+	var known, seen int
+	err := dbQueryRow(ctx, `SELECT COUNT(*), COALESCE(SUM(country = ?), 0) FROM admin_login_countries`, country).Scan(&known, &seen)
+	if err != nil {
+		log.Printf("Error checking sign-in country: %v", err)
+		return
+	}
+	if seen > 0 {
+		return
+	}
+	if known == 0 {
+		if _, err := dbExec(ctx, "INSERT OR IGNORE INTO admin_login_countries (country) VALUES (?)", country); err != nil {
+			log.Printf("Error saving sign-in country: %v", err)
+		}
+		return
+	}
+
+	userAgent := c.GetHeader("User-Agent")
+	result, err := dbExec(ctx, `
+		INSERT INTO admin_login_alerts (session_id, country, ip_hash, user_agent) VALUES (?, ?, ?, ?)
+	`, sessionID, country, ipHash, userAgent)
+	var id int64
+	if err == nil {
+		id, err = result.LastInsertId()
+	}
+	if err != nil {
+		log.Printf("Error saving sign-in alert: %v", err)
+		return
+	}
+	recordSecurityEvent(ctx, eventLoginNewCountry, ipHash, country) // from security.go
+	log.Printf("Admin sign-in for %s from new country %s (%s)", c.Request.Host, country, ipHash)
+
+	reviewURL := buildLoginAlertURL(c, loginAlertToken(ctx, int(id), time.Now().Add(loginAlertLinkLifetime)))
+	subject := fmt.Sprintf("New admin sign-in from %s", country)
+	if !site.Primary() {
+		subject += " on " + c.Request.Host
+	}
+	body := fmt.Sprintf("Someone signed in to the admin from %s, a country not seen before for this account.\n\n"+
+		"Time: %s\nSite: %s\nBrowser: %s\nHashed IP: %s\n\n"+
+		"If this was you, approve the country so it isn't reported again. If not, end the session and change the password:\n%s\n",
+		country, time.Now().UTC().Format("January 2, 2006 15:04 UTC"), c.Request.Host, userAgent, ipHash, reviewURL)
+	alertCtx := context.WithoutCancel(ctx) // keeps the site
+	safeGo("login-alert", func() {
+		if err := sendOwnerEmail(alertCtx, emailKindLoginAlert, subject, loadSMTPSettings().User, body); err != nil { // from main.go
+			log.Printf("Error sending sign-in alert: %v", err)
+		}
+	})
+}
+
+func loginAlertToken(ctx context.Context, id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expiresAt.Unix())
+	return payload + "." + signedLinkMAC(ctx, "login-alert:", payload) // from sharelinks.go
+}
+
+// Full URL for a review token, on the same host as short links
+func buildLoginAlertURL(c *gin.Context, token string) string {
+	return strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/login-alert/" + token // from main.go
+}
+
+func getLoginAlert(ctx context.Context, id int) (LoginAlert, error) {
+	var a LoginAlert
+	err := dbQueryRow(ctx, `
+		SELECT id, session_id, country, COALESCE(ip_hash, ''), COALESCE(user_agent, ''), status, created_at, resolved_at
+		FROM admin_login_alerts WHERE id = ?
+	`, id).Scan(&a.ID, &a.SessionID, &a.Country, &a.IPHash, &a.UserAgent, &a.Status, &a.CreatedAt, &a.ResolvedAt)
+	return a, err
+}
+
+// Recent new-country sign-ins, newest first, with links to review them
+func getLoginAlerts(ctx context.Context, c *gin.Context, since time.Time) ([]LoginAlert, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, session_id, country, COALESCE(ip_hash, ''), COALESCE(user_agent, ''), status, created_at, resolved_at
+		FROM admin_login_alerts WHERE created_at >= ?
+		ORDER BY id DESC
+	`, since.UTC().Format(sqliteTimestamp))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []LoginAlert
+	for rows.Next() {
+		var a LoginAlert
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.Country, &a.IPHash, &a.UserAgent, &a.Status, &a.CreatedAt, &a.ResolvedAt); err != nil {
+			continue
+		}
+		if a.Status == loginAlertPending {
+			a.ReviewURL = buildLoginAlertURL(c, loginAlertToken(ctx, a.ID, time.Now().Add(loginAlertLinkLifetime)))
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// Approve the country, or end the session it came from
+func resolveLoginAlert(ctx context.Context, alert LoginAlert, approve bool, ipHash string) error {
+	status := loginAlertDenied
+	if approve {
+		status = loginAlertApproved
+	}
+	result, err := dbExec(ctx, "UPDATE admin_login_alerts SET status = ?, resolved_at = ? WHERE id = ? AND status = ?",
+		status, time.Now(), alert.ID, loginAlertPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil // already reviewed
+	}
+
+	site := siteFromContext(ctx)
+	if approve {
+		_, err = dbExec(ctx, "INSERT OR IGNORE INTO admin_login_countries (country) VALUES (?)", alert.Country)
+		log.Printf("Admin sign-in country %s approved from %s", alert.Country, ipHash)
+		return err
+	}
+	revokeAdminSession(site, alert.SessionID) // from admin.go
+	recordSecurityEvent(ctx, eventSessionRevoked, ipHash, fmt.Sprintf("sign-in from %s denied", alert.Country))
+	log.Printf("Admin session from %s ended from %s", alert.Country, ipHash)
+	return nil
+}
+
+// Setup the review page the alert email links to. It works without signing
+// in, as the owner may only have the email to hand; the signed link is the
+// authorization, and only a POST changes anything so mail scanners that
+// open links can't.
+func setupLoginAlertRoutes(r *gin.Engine) {
+	review := func(c *gin.Context) (LoginAlert, bool) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+
+		id, ok := parseSignedLinkToken(ctx, "login-alert:", c.Param("token")) // from sharelinks.go
+		var alert LoginAlert
+		var err error
+		if ok {
+			alert, err = getLoginAlert(ctx, id)
+		}
+		if !ok || err != nil {
+			ipHash := hashIP(c.ClientIP())
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "sign-in alert link", ipHash, time.Minute)
+			c.HTML(http.StatusNotFound, "login-alert.html", gin.H{"invalid": true})
+			return alert, false
+		}
+		return alert, true
+	}
+
+	r.GET("/login-alert/:token", func(c *gin.Context) {
+		if alert, ok := review(c); ok {
+			c.HTML(http.StatusOK, "login-alert.html", gin.H{"alert": alert})
+		}
+	})
+
+	r.POST("/login-alert/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		alert, ok := review(c)
+		if !ok {
+			return
+		}
+		action := c.PostForm("action")
+		if action != "approve" && action != "deny" {
+			c.HTML(http.StatusBadRequest, "login-alert.html", gin.H{"alert": alert})
+			return
+		}
+		if err := resolveLoginAlert(ctx, alert, action == "approve", hashIP(c.ClientIP())); err != nil {
+			log.Printf("Error resolving sign-in alert %d: %v", alert.ID, err)
+			c.HTML(http.StatusInternalServerError, "login-alert.html", gin.H{"alert": alert, "error": true})
+			return
+		}
+		alert, _ = getLoginAlert(ctx, alert.ID)
+		c.HTML(http.StatusOK, "login-alert.html", gin.H{"alert": alert})
+	})
+}
@@ -0,0 +1,191 @@
+// maillog.go - Outbound email log with delivery status and resend
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What an email was sent for
+const (
+	emailKindContact     = "contact"
+	emailKindDiagnostics = "diagnostics"
+	emailKindStatus      = "status"
+	emailKindDomain      = "domain"
+	emailKindLoginAlert  = "login-alert"
+	emailKindAutoReply   = "auto-reply" // to contact form senders
+	emailKindClientLogin = "client-login"
+)
+
+// Delivery status of a logged email
+const (
+	emailQueued  = "queued"
+	emailSent    = "sent"
+	emailFailed  = "failed"
+	emailRetried = "retried" // resent; the resend has its own entry
+)
+
+// Days email log entries (which hold message bodies) are kept
+const emailLogRetentionDays = 90
+
+// Logged outbound email
+type EmailLogEntry struct {
+	ID        int
+	Kind      string
+	Recipient string
+	Subject   string
+	ReplyTo   string
+	Body      string
+	Status    string
+	Response  string
+	RetryOf   sql.NullInt64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Initialize the email log
+func initEmailLog() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS email_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		reply_to TEXT,
+		body TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued',
+		response TEXT,
+		retry_of INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create email_log table:", err)
+	}
+
+	startWorker("email-log-cleanup", cleanupEmailLog)
+}
+
+// Remove old entries so message bodies aren't kept forever
+func cleanupEmailLog() {
+	ctx := context.Background()
+	result, err := dbExec(ctx, `DELETE FROM email_log WHERE created_at < datetime('now', ?)`,
+		fmt.Sprintf("-%d days", emailLogRetentionDays))
+	recordJobRun("email-log-cleanup", 0, err) // from diagnostics.go
+	if err != nil {
+		log.Printf("Error cleaning up email log: %v", err)
+		return
+	}
+	if rowsDeleted, _ := result.RowsAffected(); rowsDeleted > 0 {
+		log.Printf("Email log cleanup: removed %d entries older than %d days", rowsDeleted, emailLogRetentionDays)
+	}
+}
+
+// Log an email as queued, deliver it and record the outcome
+func sendLoggedEmail(ctx context.Context, kind, to, subject, replyTo, body string) error {
+	return sendEmailEntry(ctx, kind, to, subject, replyTo, body, sql.NullInt64{})
+}
+
+func sendEmailEntry(ctx context.Context, kind, to, subject, replyTo, body string, retryOf sql.NullInt64) error {
+	result, err := dbExec(ctx, `INSERT INTO email_log (kind, recipient, subject, reply_to, body, status, retry_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, kind, to, subject, replyTo, body, emailQueued, retryOf)
+	if err != nil {
+		// Losing the log entry shouldn't lose the email
+		log.Printf("Error logging email: %v", err)
+		return deliverEmail(to, subject, replyTo, body)
+	}
+	id, _ := result.LastInsertId()
+
+	sendErr := deliverEmail(to, subject, replyTo, body) // from main.go
+	status, response := emailSent, "accepted by "+loadSMTPSettings().Host
+	if sendErr != nil {
+		status, response = emailFailed, sendErr.Error()
+	}
+
+	_, err = dbExec(ctx, "UPDATE email_log SET status = ?, response = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		status, response, id)
+	if err != nil {
+		log.Printf("Error updating email log %d: %v", id, err)
+	}
+	return sendErr
+}
+
+// Get recent log entries, newest first
+func getEmailLog(ctx context.Context, status string, limit int) ([]EmailLogEntry, error) {
+	query := `SELECT id, kind, recipient, subject, COALESCE(reply_to, ''), body, status,
+		COALESCE(response, ''), retry_of, created_at, updated_at FROM email_log`
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []EmailLogEntry
+	for rows.Next() {
+		var e EmailLogEntry
+		err := rows.Scan(&e.ID, &e.Kind, &e.Recipient, &e.Subject, &e.ReplyTo, &e.Body, &e.Status,
+			&e.Response, &e.RetryOf, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Setup admin email log routes
+func setupEmailLogAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/email-log", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getEmailLog(ctx, c.Query("status"), 200)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load email log",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-email-log.html", gin.H{
+			"entries":   entries,
+			"status":    c.Query("status"),
+			"statuses":  []string{emailQueued, emailSent, emailFailed, emailRetried},
+			"retention": emailLogRetentionDays,
+		})
+	})
+
+	// Send a logged email again as a new entry
+	adminGroup.POST("/email-log/:id/resend", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var e EmailLogEntry
+		err := dbQueryRow(ctx, "SELECT id, kind, recipient, subject, COALESCE(reply_to, ''), body FROM email_log WHERE id = ?",
+			c.Param("id")).Scan(&e.ID, &e.Kind, &e.Recipient, &e.Subject, &e.ReplyTo, &e.Body)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+			return
+		}
+
+		dbExec(ctx, "UPDATE email_log SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", emailRetried, e.ID)
+		err = sendEmailEntry(ctx, e.Kind, e.Recipient, e.Subject, e.ReplyTo, e.Body, sql.NullInt64{Int64: int64(e.ID), Valid: true})
+		log.Printf("Email %d resent by admin from %s", e.ID, hashIP(c.ClientIP()))
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Resend failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email resent"})
+	})
+}
@@ -2,16 +2,22 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/smtp"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	_ "modernc.org/sqlite"
@@ -23,27 +29,183 @@ import (
 var db *sql.DB
 
 func main() {
-	// Initialize database and admin systems
-	initDB()
-	initVisitorTracking() // from admin.go
-	initAdminToken()      // from admin.go
+	// Commands like migrate and shorten run and exit (from cli.go)
+	if len(os.Args) > 1 && runCLI(os.Args[1:]) {
+		return
+	}
+
+	initApp()
 	defer db.Close()
 
-	r := gin.Default()
-	r.LoadHTMLGlob("templates/*")
+	// Dev builds with the loadtest tag can seed synthetic data instead (from loadtest.go)
+	if runLoadTestSeed() {
+		return
+	}
 
-	// Configure trusted proxies for Render.com
+	r := setupRouter()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Listening on :%s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM finish in-flight requests, then background work
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	stopBackground(ctx) // from safego.go
+}
+
+// Initialize the database and every subsystem
+func initApp() {
+	validateEnvironment() // from envcheck.go; first, so bad config stops startup
+	initDB()
+	initDegradedMode()     // from degraded.go
+	initSettings()         // from settings.go
+	initEnvironmentCheck() // from envcheck.go
+	initAdminToken()       // from admin.go
+	initAnonymizer()       // from anonymize.go; before tracking, which may migrate old rows with it
+	initVisitorTracking()  // from admin.go
+	initReanonymize()      // from reanonymize.go
+	initAPITokens()        // from api.go
+	initIdempotencyKeys()  // from idempotency.go
+	initCORS()             // from cors.go
+	initContent()          // from content.go
+	initGraphQL()          // from graphql.go
+	initResumePDF()        // from resumepdf.go
+	initBlog()             // from blog.go
+	initWebmentions()      // from webmention.go
+	initActivityPub()      // from activitypub.go
+	initSyndication()      // from syndication.go
+	initRedirects()        // from redirects.go
+	initNotFoundTracking() // from notfound.go
+	initExclusionRules()   // from trackingexclusions.go
+	initSEO()              // from seo.go
+	initLinkSettings()     // from links.go
+	initLinkSchedules()    // from linkschedule.go
+	initLinkVariants()     // from linkvariants.go
+	initClickEvents()      // from clickevents.go
+	initLinkExpiry()       // from linkexpiry.go
+	initRevisions()        // from revisions.go
+	initTrash()            // from trash.go
+	initSecurityLog()      // from security.go
+	initEmailLog()         // from maillog.go
+	initDKIM()             // from dkim.go
+	initMessages()         // from messages.go
+	initVisitorArchive()   // from archive.go
+	initPageCounters()     // from analyticsmode.go
+	initAnalyticsExport()  // from analyticsexport.go
+	initSnapshots()        // from snapshots.go
+	initShareLinks()       // from sharelinks.go
+	initExportLinks()      // from exportlinks.go
+	initSecrets()          // from secrets.go
+	initLoginAlerts()      // from loginalerts.go
+	initSnippets()         // from snippets.go
+	initBanners()          // from banners.go
+	initAvailability()     // from availability.go
+	initNow()              // from now.go
+	initBookmarks()        // from bookmarks.go
+	initReading()          // from reading.go
+	initTalks()            // from talks.go
+	initOGImages()         // from ogimage.go
+	initOGCache()          // from ogcache.go
+	initIcons()            // from icons.go
+	initOutbound()         // from outbound.go
+	initEvents()           // from events.go
+	initSavedReports()     // from savedreports.go
+	initChangelog()        // from changelog.go
+	initViewCounts()       // from viewcounts.go
+	initReactions()        // from reactions.go
+	initPolls()            // from polls.go
+	initSkills()           // from skills.go
+	initTestimonials()     // from testimonials.go
+	initLeads()            // from leads.go
+	initInvoices()         // from invoices.go
+	initClientPortal()     // from clientportal.go
+	initFileShares()       // from fileshares.go
+	initImageProxy()       // from imageproxy.go
+	initEngagement()       // from engagement.go
+	initCampaigns()        // from campaigns.go
+	initStatus()           // from status.go
+	initKeepAlive()        // from keepalive.go
+	initDomains()          // from domains.go
+	initVersion()          // from version.go
+	initBreakers()         // from breaker.go
+	initSetup()            // from setup.go
+	initSites()            // from sites.go; last, so tenants get the full schema
+}
+
+// Proxies whose forwarding headers are believed
+func trustedProxies() []string {
 	if gin.Mode() == gin.ReleaseMode {
 		// Production: Trust only Render's proxy network
-		r.SetTrustedProxies([]string{
+		return []string{
 			"10.0.0.0/8",     // Private network
 			"172.16.0.0/12",  // Docker networks
 			"192.168.0.0/16", // Private network
-		})
-	} else {
-		// Development: Trust localhost
-		r.SetTrustedProxies([]string{"127.0.0.1"})
+		}
+	}
+	// Development: Trust localhost
+	return []string{"127.0.0.1"}
+}
+
+// Whether the request reached us through one of the trusted proxies
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
 	}
+	for _, proxy := range trustedProxies() {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the router with its middleware and all routes
+func setupRouter() *gin.Engine {
+	r := gin.Default()
+
+	// Templates are parsed once per theme (from themes.go)
+	loadThemes()
+	r.HTMLRender = themeRenderer{}
+
+	// Configure trusted proxies for Render.com
+	r.SetTrustedProxies(trustedProxies())
+
+	// Pick the site (and its database) from the Host header (from sites.go)
+	r.Use(siteMiddleware())
+
+	// Turn away writes while the database is read-only (from degraded.go)
+	r.Use(degradedMiddleware())
+
+	// Recognize admin preview sessions before anything is tracked (from preview.go)
+	r.Use(previewMiddleware())
+
+	// Find announcement banners for the page (from banners.go)
+	r.Use(bannerMiddleware())
+
+	// Render with the site's theme, or the one the admin is previewing (from themes.go)
+	r.Use(themeMiddleware())
 
 	// Add visitor tracking middleware (from admin.go)
 	r.Use(visitorTrackingMiddleware())
@@ -51,27 +213,86 @@ func main() {
 	// Add https redirect for custom domain
 	r.Use(httpsRedirectMiddleware())
 
+	// Add CORS handling for /api/ routes (from cors.go)
+	r.Use(corsMiddleware())
+
+	// Per-route request body size limits (from bodylimit.go)
+	r.Use(bodyLimitMiddleware())
+
+	// Per-route cache defaults and rate limits (from routepolicy.go)
+	r.Use(routePolicyMiddleware())
+
 	r.Static("/images", "./images")
 	r.Static("/static", "./static")
+	setupThemeRoutes(r) // from themes.go
 
 	// Setup admin routes (from admin.go)
 	setupAdminRoutes(r)
 
+	// First-run setup wizard (from setup.go)
+	setupFirstRunRoutes(r)
+
+	// Setup token-authenticated API routes (from api.go)
+	setupAPIRoutes(r)
+
+	// Read-only dashboard share links (from sharelinks.go)
+	setupShareRoutes(r)
+
+	// Signed export download links (from exportlinks.go)
+	setupExportLinkRoutes(r)
+
+	// Signed invoice links for clients (from invoices.go)
+	setupInvoiceRoutes(r)
+
+	// Online invoice payment and its webhook (from stripe.go)
+	setupStripeRoutes(r)
+
+	// Client area, signed into by emailed link (from clientportal.go)
+	setupClientPortalRoutes(r)
+
+	// File drops at expiring download links (from fileshares.go)
+	setupFileShareRoutes(r)
+
+	// Resized local and allowed remote images (from imageproxy.go)
+	setupImageProxyRoutes(r)
+
+	// One-time encrypted secrets (from secrets.go)
+	setupSecretRoutes(r)
+
+	// Review links for admin sign-ins from new countries (from loginalerts.go)
+	setupLoginAlertRoutes(r)
+
 	// Your existing routes...
 	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"aboutMeContent":      AboutMe,
-			"projectOneContent":   ProjectOne,
-			"projectTwoContent":   ProjectTwo,
-			"projectThreeContent": ProjectThree,
-			"projectFourContent":  ProjectFour,
+		ctx := c.Request.Context()
+		content, err := loadSiteContent(ctx)
+		if err != nil {
+			log.Printf("Error loading site content: %v", err)
+			c.String(http.StatusInternalServerError, "Failed to load content")
+			return
+		}
+
+		// Link previews lead with the availability status (from availability.go)
+		seo := getPageSEO(ctx, "/")
+		if content.Availability != nil {
+			seo.OGDescription = availabilityDescription(content.Availability, seo.Description)
+		}
+		seo.StructuredData = append(seo.StructuredData, personStructuredData(content)) // from structureddata.go
+
+		renderView(c, http.StatusOK, "index.html", HomeView{ // from viewmodels.go
+			SEO:             seo,
+			About:           content.About,
+			Projects:        content.Projects,
+			SkillCategories: skillCategories(content.Skills), // from skills.go
+			Availability:    content.Availability,
 		})
 	})
 
 	// HTMX Contact form endpoint
 	r.GET("/contact-form", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "contact.html", gin.H{
-			"title": "Contact Me",
+			"title":        "Contact Me",
+			"inquiryTypes": inquiryTypes, // from contactrouting.go
 		})
 	})
 
@@ -84,6 +305,7 @@ func main() {
 
 	// Handle URL shortening form submission
 	r.POST("/shorten-url", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		originalURL := strings.TrimSpace(c.PostForm("originalUrl"))
 
 		// Validate URL
@@ -95,10 +317,18 @@ func main() {
 		}
 
 		// Parse and validate URL format
-		parsedURL, err := url.Parse(originalURL)
-		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		if !isPublicLongURL(originalURL) {
+			c.HTML(http.StatusOK, "url-shortener-error.html", gin.H{
+				"error": "Please enter a valid URL on the public internet, starting with http:// or https://",
+			})
+			return
+		}
+
+		// Optional lifetime (from linkexpiry.go)
+		expiresAt, ok := linkExpiryFromForm(c.PostForm("expires"))
+		if !ok {
 			c.HTML(http.StatusOK, "url-shortener-error.html", gin.H{
-				"error": "Please enter a valid URL starting with http:// or https://",
+				"error": "Please choose how long the link should work.",
 			})
 			return
 		}
@@ -112,9 +342,8 @@ func main() {
 			return
 		}
 
-		// Save to database
-		err = saveURL(shortCode, originalURL)
-		if err != nil {
+		// Save to database, with its expiry in the same transaction (from api.go)
+		if _, err := insertShortLink(ctx, shortCode, originalURL, expiresAt); err != nil {
 			log.Printf("Error saving URL: %v", err)
 			c.HTML(http.StatusOK, "url-shortener-error.html", gin.H{
 				"error": "Sorry, there was an error saving the short URL. Please try again.",
@@ -123,120 +352,184 @@ func main() {
 		}
 
 		// Build the shortened URL
-		var shortURL string
-		if gin.Mode() == gin.DebugMode || strings.Contains(c.Request.Host, "localhost") {
-			// Development - prefer HTTPS, fallback to HTTP for localhost
-			scheme := "https"
-			if strings.Contains(c.Request.Host, "localhost") && c.Request.TLS == nil {
-				scheme = "http"
-			}
-			shortURL = fmt.Sprintf("%s://%s/s/%s", scheme, c.Request.Host, shortCode)
-		} else {
-			// Production - use your custom domain
-			shortURL = fmt.Sprintf("https://zachkp.dev/s/%s", shortCode)
-		}
+		shortURL := buildShortURL(c, shortCode)
 
 		c.HTML(http.StatusOK, "url-shortener-success.html", gin.H{
 			"shortUrl":    shortURL,
 			"originalUrl": originalURL,
+			"expiresAt":   expiresAt,
+			"qrSVG":       shortLinkQRPath(shortCode, "svg"), // from qrcode.go
+			"qrPNG":       shortLinkQRPath(shortCode, "png") + "&download=1",
 		})
 	})
 
 	// Handle shortened URL redirects (with click tracking)
 	r.GET("/s/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
 		shortCode := c.Param("code")
 
-		// Get original URL and increment click count
-		originalURL, exists := getURL(shortCode)
+		// Enforce referrer/country restrictions before counting the click (from linkacl.go)
+		link, err := getLinkSettings(ctx, shortCode)
+		if err == nil {
+			// Expired links stay put until purged (from linkexpiry.go)
+			if link.Expired() {
+				renderLinkExpired(c, link)
+				return
+			}
+			if reason, ok := checkLinkAccess(c, link); !ok {
+				renderLinkRestricted(c, reason)
+				return
+			}
+		}
+
+		// Link preview crawlers get a card instead of a redirect, and
+		// aren't counted as clicks (from ogimage.go)
+		if isLinkPreviewBot(c.GetHeader("User-Agent")) {
+			if originalURL, exists := getURL(ctx, shortCode); exists {
+				renderShortLinkPreview(c, shortCode, originalURL)
+				return
+			}
+		}
+
+		originalURL, exists := getURL(ctx, shortCode)
 		if !exists {
+			recordNotFound(c)
 			c.HTML(http.StatusNotFound, "404.html", gin.H{
 				"message": "Short URL not found",
 			})
 			return
 		}
+		// Admin previews don't count as clicks (from preview.go)
+		if !isPreview(c) {
+			recordClick(c, shortCode) // from clickevents.go
+		}
+
+		// A scheduled swap overrides everything else (from linkschedule.go)
+		if scheduled, ok := scheduledDestination(ctx, shortCode); ok {
+			originalURL = scheduled
+		} else if err == nil {
+			// Weighted variants replace the main destination (from linkvariants.go)
+			if variant, ok := chooseLinkVariant(ctx, c, link); ok {
+				link.OriginalURL = variant.Destination
+			}
+			// Per-device destinations (from linkdevice.go)
+			originalURL = link.DestinationFor(c.GetHeader("User-Agent"))
+		}
 
 		c.Redirect(http.StatusFound, originalURL)
 	})
 
-	// Resume download
+	// Counted redirects for external links (from outbound.go)
+	setupOutboundRoutes(r)
+	setupEventRoutes(r)      // from events.go
+	setupEngagementRoutes(r) // from engagement.go
+	setupViewCountRoutes(r)  // from viewcounts.go
+	setupReactionRoutes(r)   // from reactions.go
+	setupPollRoutes(r)       // from polls.go
+
+	// Public status page for my other projects (from status.go)
+	setupStatusRoutes(r)
+
+	// Favicons and web app manifest (from icons.go)
+	setupIconRoutes(r)
+
+	// Link preview cards for short URLs (from ogimage.go)
+	setupOGImageRoutes(r)
+
+	// QR codes for short URLs (from qrcode.go)
+	setupQRCodeRoutes(r)
+
+	// Resume download - generated from the content tables (from resumepdf.go)
 	r.GET("/resume", func(c *gin.Context) {
-		c.Header("Content-Description", "File Transfer")
-		c.Header("Content-Transfer-Encoding", "binary")
-		c.Header("Content-Disposition", "attachment; filename=Zachariah_Kordas_Potter_Resume.pdf")
-		c.Header("Content-Type", "application/pdf")
-		c.File("./static/Zach Kordas-Potter Resume.pdf")
+		ctx := c.Request.Context()
+		pdfBytes, err := currentResumePDF(ctx)
+		if err != nil {
+			log.Printf("Error generating resume PDF, serving static copy: %v", err)
+			c.Header("Content-Description", "File Transfer")
+			c.Header("Content-Transfer-Encoding", "binary")
+			c.Header("Content-Disposition", "attachment; filename=Zachariah_Kordas_Potter_Resume.pdf")
+			c.Header("Content-Type", "application/pdf")
+			c.File(staticResumePath)
+			return
+		}
+
+		sendResumePDF(c, pdfBytes, "Zachariah_Kordas_Potter_Resume.pdf")
 	})
 
+	// Resume as JSON Resume and HTML (from resume.go)
+	setupResumeRoutes(r)
+
+	// Blog and webmention receiver (from blog.go, webmention.go)
+	setupBlogRoutes(r)
+	setupNowRoutes(r)       // from now.go
+	setupBookmarkRoutes(r)  // from bookmarks.go
+	setupReadingRoutes(r)   // from reading.go
+	setupTalkRoutes(r)      // from talks.go
+	setupChangelogRoutes(r) // from changelog.go
+	setupTimelineRoutes(r)  // from timeline.go
+	setupFeedRoutes(r)      // from feed.go
+	setupWebmentionRoutes(r)
+
+	// ActivityPub actor and WebFinger discovery (from activitypub.go)
+	setupActivityPubRoutes(r)
+
 	// Work experience content
 	r.GET("/work-content", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "work-content.html", gin.H{
-			"jobTitle":  jobTitle,
-			"company":   company,
-			"startDate": startDateWork,
-			"endDate":   endDate,
-			"logoPath":  "images/TargetLogo.jpg",
-			"bulletPoints": []string{
-				targetBullet1,
-				targetBullet2,
-				targetBullet3,
-			},
-			"jobTitle2":  jobTitle2,
-			"company2":   company2,
-			"startDate2": startDateWork2,
-			"endDate2":   endDate2,
-			"logoPath2":  "images/jasonsCateringLogo.png",
-			"bulletPoints2": []string{
-				cateringBullet1,
-				cateringBullet2,
-				cateringBullet3,
-			},
-			"jobTitle3":  jobTitle3,
-			"company3":   company3,
-			"startDate3": startDateWork3,
-			"endDate3":   endDate3,
-			"logoPath3":  "images/freelance.png",
-			"bulletPoints3": []string{
-				freelanceBullet1,
-				freelanceBullet2,
-				freelanceBullet3,
-			},
-		})
+		ctx := c.Request.Context()
+		experiences, err := getExperiences(ctx, experienceWork)
+		if err != nil {
+			log.Printf("Error loading work experience: %v", err)
+		}
+		renderView(c, http.StatusOK, "work-content.html", WorkContentView{Experiences: experiences})
 	})
 
+	// Skills, optionally one category (from skills.go)
+	setupSkillRoutes(r)
+
+	// Testimonial invites and the homepage carousel (from testimonials.go)
+	setupTestimonialRoutes(r)
+
+	// Freelance quote requests (from leads.go)
+	setupLeadRoutes(r)
+
 	// Education content
 	r.GET("/education-content", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "education-content.html", gin.H{
-			"degree":      degree,
-			"institution": institution,
-			"startDate":   startDateEdu,
-			"endDate":     endDateEdu,
-			"logoPath":    "images/WGU-logo.png",
-			"bulletPoints": []string{
-				eduBullet1,
-				eduBullet2,
-				eduBullet3,
-			},
-			"degree2":      certification,
-			"institution2": institution2,
-			"startDate2":   startDateEdu2,
-			"endDate2":     endDateEdu2,
-			"logoPath2":    "images/comptiaCert.png",
-			"bulletPoints2": []string{
-				certBullet1,
-				certBullet2,
-				certBullet3,
-			},
-		})
+		ctx := c.Request.Context()
+		education, err := getExperiences(ctx, experienceEducation)
+		if err != nil {
+			log.Printf("Error loading education: %v", err)
+		}
+		renderView(c, http.StatusOK, "education-content.html", EducationContentView{Education: education})
 	})
 
 	// Handle contact form submission
 	r.POST("/contact", func(c *gin.Context) {
-		name := c.PostForm("fullName")
-		email := c.PostForm("email")
-		message := c.PostForm("message")
+		ctx := c.Request.Context()
+		name := strings.TrimSpace(c.PostForm("fullName"))
+		email := strings.TrimSpace(c.PostForm("email"))
+		message := strings.TrimSpace(c.PostForm("message"))
+		inquiry := inquiryTypeFor(c.PostForm("inquiryType")).Name // from contactrouting.go
+
+		if problem := validateContactForm(name, email, message); problem != "" {
+			c.HTML(http.StatusOK, "contact-error.html", gin.H{
+				"error": problem,
+			})
+			return
+		}
 
-		err := sendContactEmail(name, email, message)
+		// Keep a copy in the admin inbox (from messages.go)
+		threadID, err := saveMessage(ctx, 0, messageSourceContact, inquiry, name, email, "Portfolio Contact: "+name, message)
 		if err != nil {
+			log.Printf("Error saving contact message: %v", err)
+		}
+
+		// The message is only lost if it was neither stored nor emailed.
+		// Tenant sites' messages only go to their admin inbox; the email
+		// and routing settings belong to the primary site.
+		if siteFromContext(ctx).Primary() {
+			err = routeContactMessage(ctx, threadID, inquiry, name, email, message, hashIP(c.ClientIP()))
+		}
+		if err != nil && threadID == 0 {
 			c.HTML(http.StatusOK, "contact-error.html", gin.H{
 				"error": "Sorry, there was an error sending your message. Please try again later.",
 			})
@@ -248,11 +541,13 @@ func main() {
 		})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	r.Run(":" + port)
+	// Inbound email webhook (from inbound.go)
+	setupInboundEmailRoutes(r)
+
+	// Unknown paths: redirect rules, then 404 (from redirects.go)
+	r.NoRoute(notFoundHandler)
+
+	return r
 }
 
 func httpsRedirectMiddleware() gin.HandlerFunc {
@@ -270,17 +565,32 @@ func httpsRedirectMiddleware() gin.HandlerFunc {
 	})
 }
 
+// SQLite file, from DATABASE_PATH
+func databasePath() string {
+	if path := os.Getenv("DATABASE_PATH"); path != "" {
+		return path
+	}
+	return "./urls.db"
+}
+
 // Database initialization
 func initDB() {
 	var err error
-	db, err = sql.Open("sqlite", "./urls.db")
-	if err != nil {
-		log.Fatal("Failed to open database:", err)
+	// Wait for locks instead of failing when background jobs write concurrently
+	db, err = sql.Open("sqlite", databasePath()+"?_pragma=busy_timeout(5000)")
+	if err == nil {
+		err = db.Ping()
+	}
+	if err == nil {
+		err = checkDBWritable(db) // from degraded.go
 	}
-
-	err = db.Ping()
 	if err != nil {
-		log.Fatal("Failed to ping database:", err)
+		// Serve the site read-only rather than not at all
+		log.Printf("Database %s can't be written: %v", databasePath(), err)
+		if db != nil {
+			db.Close()
+		}
+		db = openSnapshotDB(err)
 	}
 
 	createTable := `
@@ -298,16 +608,54 @@ func initDB() {
 	log.Println("Database initialized successfully")
 }
 
+// Check that a submitted URL is an absolute http(s) URL
+func isValidLongURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return false
+	}
+	return parsedURL.Scheme == "http" || parsedURL.Scheme == "https"
+}
+
+// Whether a URL may be a short link's destination: valid, and not an
+// internal address, which link previews would otherwise fetch from this
+// server (from ogimage.go). Settings like BASE_URL may point at localhost,
+// so isValidLongURL doesn't check this.
+func isPublicLongURL(rawURL string) bool {
+	return isValidLongURL(rawURL) && checkPublicURL(rawURL) == nil // from httpclient.go
+}
+
+// Build the public short URL for a code
+func buildShortURL(c *gin.Context, shortCode string) string {
+	if gin.Mode() == gin.DebugMode || strings.Contains(c.Request.Host, "localhost") {
+		// Development - prefer HTTPS, fallback to HTTP for localhost
+		scheme := "https"
+		if strings.Contains(c.Request.Host, "localhost") && c.Request.TLS == nil {
+			scheme = "http"
+		}
+		return fmt.Sprintf("%s://%s/s/%s", scheme, c.Request.Host, shortCode)
+	}
+	return siteShortURL(siteFromContext(c.Request.Context()), shortCode)
+}
+
+// Production short URL: your custom domain, or the tenant's (from sites.go)
+func siteShortURL(site *Site, shortCode string) string {
+	if !site.Primary() {
+		return fmt.Sprintf("https://%s/s/%s", site.Host, shortCode)
+	}
+	return fmt.Sprintf("%s/s/%s", siteBaseURL(), shortCode) // from seo.go
+}
+
 // Save URL to database
-func saveURL(shortCode, originalURL string) error {
-	_, err := db.Exec("INSERT INTO urls (short_code, original_url) VALUES (?, ?)", shortCode, originalURL)
+func saveURL(ctx context.Context, shortCode, originalURL string) error {
+	_, err := dbExec(ctx, "INSERT INTO urls (short_code, original_url) VALUES (?, ?)", shortCode, originalURL)
 	return err
 }
 
-// Get URL and track clicks (enhanced for admin)
-func getURL(shortCode string) (string, bool) {
+// Get a short link's destination
+func getURL(ctx context.Context, shortCode string) (string, bool) {
 	var originalURL string
-	err := db.QueryRow("SELECT original_url FROM urls WHERE short_code = ?", shortCode).Scan(&originalURL)
+	err := dbQueryRow(ctx, "SELECT original_url FROM urls WHERE short_code = ?", shortCode).Scan(&originalURL)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", false
@@ -315,15 +663,6 @@ func getURL(shortCode string) (string, bool) {
 		log.Printf("Database error: %v", err)
 		return "", false
 	}
-
-	// Increment click count in background
-	go func() {
-		_, err := db.Exec("UPDATE urls SET clicks = COALESCE(clicks, 0) + 1 WHERE short_code = ?", shortCode)
-		if err != nil {
-			log.Printf("Error updating click count: %v", err)
-		}
-	}()
-
 	return originalURL, true
 }
 
@@ -344,34 +683,128 @@ func generateShortCode() (string, error) {
 	return shortCode, nil
 }
 
-// Send contact email
-func sendContactEmail(name, email, message string) error {
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	toEmail := os.Getenv("TO_EMAIL")
+// SMTP configuration from the environment, then the settings saved by
+// setup (from setup.go), with defaults
+type smtpSettings struct {
+	Host string
+	Port string
+	User string
+	Pass string
+	To   string
+}
+
+func loadSMTPSettings() smtpSettings {
+	settings := smtpSettings{
+		Host: envOrSetting("SMTP_HOST", smtpHostSetting), // from settings.go
+		Port: envOrSetting("SMTP_PORT", smtpPortSetting),
+		User: envOrSetting("SMTP_USER", smtpUserSetting),
+		Pass: envOrSetting("SMTP_PASS", smtpPassSetting),
+		To:   envOrSetting("TO_EMAIL", smtpToSetting),
+	}
 
-	if smtpHost == "" {
-		smtpHost = "smtp.gmail.com"
+	if settings.Host == "" {
+		settings.Host = "smtp.gmail.com"
 	}
-	if smtpPort == "" {
-		smtpPort = "587"
+	if settings.Port == "" {
+		settings.Port = "587"
 	}
-	if toEmail == "" {
-		toEmail = "zachkordaspotter@gmail.com"
+	if settings.To == "" {
+		settings.To = "zachkordaspotter@gmail.com"
 	}
+	return settings
+}
 
-	if smtpUser == "" || smtpPass == "" {
+// Send a plain-text email to the site owner, recorded in the email log
+func sendOwnerEmail(ctx context.Context, kind, subject, replyTo, body string) error {
+	return sendLoggedEmail(ctx, kind, loadSMTPSettings().To, subject, replyTo, body) // from maillog.go
+}
+
+// Trips after repeated SMTP failures; failed sends stay in the email log
+// for retrying (from maillog.go)
+var smtpBreaker = newCircuitBreaker("smtp", 3, 5*time.Minute, func() error {
+	settings := loadSMTPSettings()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(settings.Host, settings.Port), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+})
+
+// Deliver a plain-text email over SMTP
+func deliverEmail(to, subject, replyTo, body string) error {
+	settings := loadSMTPSettings()
+	if settings.User == "" || settings.Pass == "" {
 		return fmt.Errorf("SMTP credentials not configured")
 	}
 
-	subject := fmt.Sprintf("Portfolio Contact: %s", name)
+	headers := map[string]string{
+		"To":       to,
+		"Subject":  subject,
+		"From":     settings.User,
+		"Reply-To": replyTo,
+		"Date":     time.Now().Format(time.RFC1123Z),
+	}
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n") + "\r\n"
+
+	var msg strings.Builder
+	if dkim != nil { // from dkim.go
+		signature, err := dkimSign(dkim, headers, body)
+		if err != nil {
+			log.Printf("DKIM signing failed, sending unsigned: %v", err)
+		}
+		msg.WriteString(signature)
+	}
+	for _, name := range []string{"To", "Subject", "From", "Reply-To", "Date"} {
+		msg.WriteString(name + ": " + headers[name] + "\r\n")
+	}
+	msg.WriteString("\r\n" + body)
+
+	auth := smtp.PlainAuth("", settings.User, settings.Pass, settings.Host)
+	return smtpBreaker.Do(func() error { // from breaker.go
+		return smtp.SendMail(settings.Host+":"+settings.Port, auth, settings.User, []string{to}, []byte(msg.String()))
+	})
+}
+
+// Contact form field limits
+const (
+	contactMaxName    = 200
+	contactMaxMessage = 10000
+)
+
+// Check contact form fields, returning a message for the visitor if invalid.
+// Name and email end up in mail headers, so line breaks are never allowed.
+func validateContactForm(name, email, message string) string {
+	switch {
+	case name == "" || email == "" || message == "":
+		return "Please fill in your name, email and message."
+	case len(name) > contactMaxName || strings.ContainsAny(name, "\r\n"):
+		return "Please enter a shorter name on a single line."
+	case strings.ContainsAny(email, "\r\n") || !isValidEmail(email):
+		return "Please enter a valid email address."
+	case len(message) > contactMaxMessage:
+		return fmt.Sprintf("Please keep your message under %d characters.", contactMaxMessage)
+	}
+	return ""
+}
+
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// Send contact email to the inquiry type's address, or the owner's; the
+// thread tag lets replies find their way back to the inbox
+func sendContactEmail(ctx context.Context, threadID int64, route ContactRoute, name, email, message string) error {
+	subject := fmt.Sprintf("Portfolio Contact (%s): %s", route.Label, name)
+	if threadID > 0 {
+		subject += " " + threadSubjectTag(threadID)
+	}
 	body := fmt.Sprintf(`
 		New contact form submission from your portfolio:
 
 		Name: %s
 		Email: %s
+		Inquiry: %s
 
 		Message:
 
@@ -379,17 +812,13 @@ func sendContactEmail(name, email, message string) error {
 
 		---
 		Sent from your zachkp.dev contact form
-		`, name, email, message)
+		`, name, email, route.Label, message)
 
-	msg := []byte("To: " + toEmail + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"From: " + smtpUser + "\r\n" +
-		"Reply-To: " + email + "\r\n" +
-		"\r\n" +
-		body + "\r\n")
-
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, smtpUser, []string{toEmail}, msg)
+	to := route.Email
+	if to == "" {
+		to = loadSMTPSettings().To
+	}
+	err := sendLoggedEmail(ctx, emailKindContact, to, subject, email, body) // from maillog.go
 	if err != nil {
 		fmt.Printf("Error sending email: %v\n", err)
 		return err
@@ -0,0 +1,265 @@
+// messages.go - Contact inbox with threaded replies
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Where a message came from
+const (
+	messageSourceContact = "contact" // contact form
+	messageSourceEmail   = "email"   // inbound email webhook
+)
+
+// Stored inbox message
+type Message struct {
+	ID          int
+	ThreadID    int
+	Source      string
+	Name        string
+	Email       string
+	Subject     string
+	Body        string
+	InquiryType string // contact form messages only, see contactrouting.go
+	Read        bool
+	CreatedAt   time.Time
+}
+
+// Thread summary for the inbox list
+type MessageThread struct {
+	Message         // first message
+	Count    int    // messages in the thread
+	Unread   int    // unread messages in the thread
+	LastFrom string // sender of the latest message
+	LastID   int    // id of the latest message, which orders the inbox
+	LastAt   time.Time
+}
+
+// Tag added to notification subjects so replies thread with the submission
+var messageThreadTag = regexp.MustCompile(`\[#(\d+)\]`)
+
+func threadSubjectTag(threadID int64) string {
+	return fmt.Sprintf("[#%d]", threadID)
+}
+
+// Initialize inbox storage
+func initMessages() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		thread_id INTEGER,
+		source TEXT NOT NULL,
+		name TEXT,
+		email TEXT,
+		subject TEXT,
+		body TEXT NOT NULL,
+		read INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create messages table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_thread ON messages (thread_id)`)
+	db.Exec(`ALTER TABLE messages ADD COLUMN inquiry_type TEXT`) // Ignore error if column already exists
+}
+
+// Store a message; threadID 0 starts a new thread. Returns the thread id.
+func saveMessage(ctx context.Context, threadID int64, source, inquiryType, name, email, subject, body string) (int64, error) {
+	var thread interface{}
+	if threadID > 0 {
+		thread = threadID
+	}
+	result, err := dbExec(ctx, "INSERT INTO messages (thread_id, source, inquiry_type, name, email, subject, body) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		thread, source, inquiryType, name, email, subject, body)
+	if err != nil {
+		return 0, err
+	}
+	if threadID > 0 {
+		return threadID, nil
+	}
+
+	// A new thread is identified by its first message
+	id, _ := result.LastInsertId()
+	_, err = dbExec(ctx, "UPDATE messages SET thread_id = ? WHERE id = ?", id, id)
+	return id, err
+}
+
+// Thread id tagged in a subject, if that thread exists
+func threadFromSubject(ctx context.Context, subject string) int64 {
+	match := messageThreadTag.FindStringSubmatch(subject)
+	if match == nil {
+		return 0
+	}
+	id, _ := strconv.ParseInt(match[1], 10, 64)
+	var exists bool
+	dbQueryRow(ctx, "SELECT COUNT(*) > 0 FROM messages WHERE thread_id = ?", id).Scan(&exists)
+	if !exists {
+		return 0
+	}
+	return id
+}
+
+const messageColumns = `id, COALESCE(thread_id, id), source, COALESCE(name, ''), COALESCE(email, ''),
+	COALESCE(subject, ''), body, COALESCE(inquiry_type, ''), COALESCE(read, 0), created_at`
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (Message, error) {
+	var m Message
+	err := row.Scan(&m.ID, &m.ThreadID, &m.Source, &m.Name, &m.Email, &m.Subject, &m.Body, &m.InquiryType, &m.Read, &m.CreatedAt)
+	return m, err
+}
+
+// Get a page of inbox threads, most recently active first: up to limit
+// threads whose latest message is older than beforeID (0 for the newest),
+// only those started about inquiryType unless it is ""
+func getMessageThreads(ctx context.Context, beforeID, limit int, inquiryType string) ([]MessageThread, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE COALESCE(thread_id, id) IN (
+			SELECT COALESCE(thread_id, id) AS thread FROM messages
+			GROUP BY thread
+			HAVING (? = 0 OR MAX(id) < ?) AND (? = '' OR MAX(COALESCE(inquiry_type, '') = ?))
+			ORDER BY MAX(id) DESC
+			LIMIT ?
+		)
+		ORDER BY id`, beforeID, beforeID, inquiryType, inquiryType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads := map[int]*MessageThread{}
+	var order []int
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			continue
+		}
+		thread, ok := threads[m.ThreadID]
+		if !ok {
+			// The first message may have been deleted; the oldest left heads the thread
+			thread = &MessageThread{Message: m}
+			threads[m.ThreadID] = thread
+			order = append(order, m.ThreadID)
+		}
+		thread.Count++
+		if !m.Read {
+			thread.Unread++
+		}
+		thread.LastFrom = m.Name
+		if thread.LastFrom == "" {
+			thread.LastFrom = m.Email
+		}
+		thread.LastID = m.ID
+		thread.LastAt = m.CreatedAt
+	}
+
+	list := make([]MessageThread, 0, len(order))
+	for _, id := range order {
+		list = append(list, *threads[id])
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastID > list[j].LastID })
+	return list, rows.Err()
+}
+
+// Get a thread's messages, oldest first
+func getThreadMessages(ctx context.Context, threadID int) ([]Message, error) {
+	rows, err := dbQuery(ctx, `SELECT `+messageColumns+` FROM messages WHERE COALESCE(thread_id, id) = ? ORDER BY id`, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Setup admin inbox routes
+func setupMessageAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/messages", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		_, after, _ := cursorParam(c) // from pagination.go
+		afterID, _ := strconv.Atoi(after)
+		inquiry := c.Query("type")
+		if inquiry != "" {
+			inquiry = inquiryTypeFor(inquiry).Name // from contactrouting.go
+		}
+		threads, err := getMessageThreads(ctx, afterID, adminPageSize+1, inquiry)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load messages",
+			})
+			return
+		}
+
+		next := ""
+		if len(threads) > adminPageSize {
+			threads = threads[:adminPageSize]
+			next = encodeCursor("", strconv.Itoa(threads[adminPageSize-1].LastID))
+		}
+		c.HTML(http.StatusOK, "admin-messages.html", gin.H{
+			"threads":      threads,
+			"pager":        cursorPager(c, next),
+			"inquiryTypes": inquiryTypes,
+			"inquiry":      inquiry,
+			"routes":       getContactRoutes(),
+			"primary":      siteFromContext(ctx).Primary(),
+			"message":      c.Query("message"),
+		})
+	})
+
+	adminGroup.GET("/messages/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		// COALESCE() has no column affinity, so compare against an integer
+		threadID, _ := strconv.Atoi(c.Param("id"))
+		messages, err := getThreadMessages(ctx, threadID)
+		if err != nil || len(messages) == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Conversation not found",
+			})
+			return
+		}
+
+		dbExec(ctx, "UPDATE messages SET read = 1 WHERE COALESCE(thread_id, id) = ?", threadID)
+		c.HTML(http.StatusOK, "admin-message-thread.html", gin.H{
+			"messages": messages,
+			"threadID": threadID,
+		})
+	})
+
+	adminGroup.DELETE("/messages/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		err := moveToTrash(ctx, "message", c.Param("id"), ipHash) // from trash.go
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error deleting message %s: %v", c.Param("id"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+
+		log.Printf("Message %s moved to trash by admin from %s", c.Param("id"), ipHash)
+		c.JSON(http.StatusOK, gin.H{"message": "Message moved to trash"})
+	})
+}
@@ -0,0 +1,163 @@
+// notfound.go - 404 tracking and broken inbound link report
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path that has returned 404, aggregated over referrers
+type NotFoundPath struct {
+	Path      string
+	Hits      int
+	LastSeen  time.Time
+	Referrers []NotFoundReferrer
+}
+
+// Referrer that sent visitors to a missing path
+type NotFoundReferrer struct {
+	Referrer string
+	Hits     int
+}
+
+// Longest path or referrer stored, so junk requests can't bloat the table
+const notFoundMaxLength = 512
+
+// Initialize 404 tracking
+func initNotFoundTracking() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS not_found_hits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL,
+		referrer TEXT NOT NULL DEFAULT '',
+		hits INTEGER DEFAULT 1,
+		first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(path, referrer)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create not_found_hits table:", err)
+	}
+}
+
+func truncateNotFound(value string) string {
+	if len(value) > notFoundMaxLength {
+		return value[:notFoundMaxLength]
+	}
+	return value
+}
+
+// Record a 404 for the current request
+func recordNotFound(c *gin.Context) {
+	ctx := c.Request.Context()
+	if isPreview(c) {
+		return
+	}
+
+	path := truncateNotFound(c.Request.URL.Path)
+
+	// Only keep the referring page, not its query string
+	referrer := ""
+	if ref, err := url.Parse(c.Request.Referer()); err == nil && ref.Host != "" {
+		referrer = truncateNotFound(ref.Scheme + "://" + ref.Host + ref.Path)
+	}
+
+	_, err := dbExec(ctx, `
+		INSERT INTO not_found_hits (path, referrer) VALUES (?, ?)
+		ON CONFLICT(path, referrer) DO UPDATE SET hits = hits + 1, last_seen = CURRENT_TIMESTAMP
+	`, path, referrer)
+	if err != nil {
+		log.Printf("Error recording 404: %v", err)
+	}
+}
+
+// Get missing paths ranked by hits, with their referrers
+func getNotFoundReport(ctx context.Context, limit int) ([]NotFoundPath, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT path, SUM(hits), MAX(last_seen)
+		FROM not_found_hits
+		WHERE path NOT IN (SELECT source_path FROM redirects)
+		GROUP BY path
+		ORDER BY SUM(hits) DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []NotFoundPath
+	for rows.Next() {
+		var p NotFoundPath
+		var lastSeen string
+		if err := rows.Scan(&p.Path, &p.Hits, &lastSeen); err != nil {
+			continue
+		}
+		// MAX() loses the column type, so parse the timestamp ourselves
+		p.LastSeen, _ = time.Parse("2006-01-02 15:04:05", lastSeen)
+		paths = append(paths, p)
+	}
+	rows.Close()
+
+	for i := range paths {
+		refRows, err := dbQuery(ctx, `
+			SELECT referrer, hits FROM not_found_hits
+			WHERE path = ? AND referrer != ''
+			ORDER BY hits DESC
+			LIMIT 5
+		`, paths[i].Path)
+		if err != nil {
+			return nil, err
+		}
+		for refRows.Next() {
+			var r NotFoundReferrer
+			if err := refRows.Scan(&r.Referrer, &r.Hits); err == nil {
+				paths[i].Referrers = append(paths[i].Referrers, r)
+			}
+		}
+		refRows.Close()
+	}
+
+	return paths, nil
+}
+
+// Setup admin 404 report routes
+func setupNotFoundAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/not-found", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		paths, err := getNotFoundReport(ctx, 100)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load 404 report",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-not-found.html", gin.H{
+			"paths": paths,
+		})
+	})
+
+	// Dismiss a path from the report
+	adminGroup.DELETE("/not-found", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		path := strings.TrimSpace(c.Query("path"))
+		if path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
+			return
+		}
+
+		_, err := dbExec(ctx, "DELETE FROM not_found_hits WHERE path = ?", path)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to dismiss path"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Path dismissed"})
+	})
+}
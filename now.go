@@ -0,0 +1,158 @@
+// now.go - /now page updates
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Short update on the now page
+type NowEntry struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"` // Markdown source
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Longest update the quick-post form accepts; longer writing belongs in a post
+const nowEntryMaxLength = 1000
+
+// Rendered HTML body (Markdown, raw HTML escaped)
+func (e NowEntry) HTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(e.Body), &buf); err != nil {
+		log.Printf("Error rendering now entry %d: %v", e.ID, err)
+		return template.HTML(template.HTMLEscapeString(e.Body))
+	}
+	return template.HTML(buf.String())
+}
+
+// Public URL of the entry
+func (e NowEntry) Permalink() string {
+	return siteBaseURL() + "/now#now-" + strconv.Itoa(e.ID) // from seo.go
+}
+
+// Feed title: the start of the first line, without Markdown emphasis
+func (e NowEntry) Title() string {
+	title, _, _ := strings.Cut(strings.TrimSpace(e.Body), "\n")
+	title = strings.TrimLeft(strings.NewReplacer("*", "", "`", "").Replace(title), "#> ")
+	if runes := []rune(title); len(runes) > 80 {
+		title = string(runes[:77]) + "..."
+	}
+	return title
+}
+
+// Initialize now page storage
+func initNow() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS now_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		body TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create now_entries table:", err)
+	}
+}
+
+// Newest entries first
+func getNowEntries(ctx context.Context, limit int) ([]NowEntry, error) {
+	rows, err := dbQuery(ctx, "SELECT id, body, created_at FROM now_entries ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []NowEntry
+	for rows.Next() {
+		var e NowEntry
+		if err := rows.Scan(&e.ID, &e.Body, &e.CreatedAt); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Setup public now page routes
+func setupNowRoutes(r *gin.Engine) {
+	r.GET("/now", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getNowEntries(ctx, 50)
+		if err != nil {
+			log.Printf("Error loading now entries: %v", err)
+		}
+		c.HTML(http.StatusOK, "now.html", gin.H{
+			"title":   "Now",
+			"entries": entries,
+			"seo":     getPageSEO(ctx, "/now"),
+		})
+	})
+}
+
+// Setup admin now page routes
+func setupNowAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/now", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		entries, err := getNowEntries(ctx, 100)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load now entries",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-now.html", gin.H{
+			"entries":   entries,
+			"maxLength": nowEntryMaxLength,
+		})
+	})
+
+	adminGroup.POST("/now", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		body := strings.TrimSpace(c.PostForm("body"))
+		if body == "" || len([]rune(body)) > nowEntryMaxLength {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "An update needs between 1 and " + strconv.Itoa(nowEntryMaxLength) + " characters",
+			})
+			return
+		}
+
+		_, err := dbExec(ctx, "INSERT INTO now_entries (body, created_at) VALUES (?, ?)", body, time.Now())
+		if err != nil {
+			log.Printf("Error saving now entry: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save update",
+			})
+			return
+		}
+
+		log.Printf("Now entry posted by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/now")
+	})
+
+	adminGroup.DELETE("/now/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM now_entries WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete update"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Update not found"})
+			return
+		}
+
+		log.Printf("Now entry %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Update deleted"})
+	})
+}
@@ -0,0 +1,183 @@
+// ogcache.go - Cached page metadata for links and bookmarks
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// How long fetched metadata is served before it's fetched again. Pages
+// that couldn't be read are retried sooner.
+const (
+	ogCacheTTL      = 7 * 24 * time.Hour
+	ogCacheErrorTTL = 6 * time.Hour
+)
+
+// The refresh worker's schedule, and how many pages it fetches per site
+// each run so a backlog doesn't hammer anyone
+const (
+	ogCacheRefreshEvery = time.Hour
+	ogCacheRefreshBatch = 25
+)
+
+// Entries no short link or bookmark points at any more are dropped after this
+const ogCacheUnusedAfter = 30 * 24 * time.Hour
+
+// Concurrent fetches of one page share a request
+var ogCacheFlights singleflight.Group
+
+// Initialize metadata cache storage
+func initOGCache() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS og_cache (
+		url TEXT PRIMARY KEY,
+		title TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		image TEXT NOT NULL DEFAULT '',
+		icon TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		fetched_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create og_cache table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_og_cache_expires ON og_cache (expires_at)`)
+
+	startWorker("og-cache-refresh", ogCacheRefreshWorker) // from safego.go
+}
+
+// Metadata for a page, from the cache when there is an entry. Stale entries
+// are still served, and refreshed in the background; pages never seen are
+// fetched now. The error is the last fetch's, when nothing was ever read.
+func cachedPageMetadata(ctx context.Context, pageURL string) (PageMetadata, error) {
+	var meta PageMetadata
+	var fetchErr string
+	var expiresAt time.Time
+	err := dbQueryRow(ctx, "SELECT title, description, image, icon, error, expires_at FROM og_cache WHERE url = ?", pageURL).
+		Scan(&meta.Title, &meta.Description, &meta.Image, &meta.Icon, &fetchErr, &expiresAt)
+	if err == sql.ErrNoRows {
+		return refreshPageMetadata(ctx, pageURL)
+	}
+	if err != nil {
+		// The cache is only an optimisation; fetch as if it weren't there
+		log.Printf("Error reading cached metadata for %s: %v", pageURL, err)
+		return fetchPageMetadata(ctx, pageURL) // from pagemeta.go
+	}
+
+	if time.Now().After(expiresAt) {
+		refreshCtx := context.WithoutCancel(ctx)
+		safeGo("og-cache refresh", func() { // from safego.go
+			refreshPageMetadata(refreshCtx, pageURL)
+		})
+	}
+	if fetchErr != "" && meta.Title == "" {
+		return meta, errors.New(fetchErr)
+	}
+	return meta, nil
+}
+
+// Fetch a page's metadata and store it. A failed fetch keeps whatever was
+// read before, and is tried again after ogCacheErrorTTL.
+func refreshPageMetadata(ctx context.Context, pageURL string) (PageMetadata, error) {
+	key := strconv.Itoa(siteFromContext(ctx).ID) + " " + pageURL // from sites.go
+	v, err, _ := ogCacheFlights.Do(key, func() (interface{}, error) {
+		now := time.Now().Truncate(time.Second)
+		meta, err := fetchPageMetadata(ctx, pageURL)
+		if err != nil {
+			_, dbErr := dbExec(ctx, `
+				INSERT INTO og_cache (url, error, fetched_at, expires_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (url) DO UPDATE SET error = excluded.error, expires_at = excluded.expires_at
+			`, pageURL, err.Error(), now, now.Add(ogCacheErrorTTL))
+			if dbErr != nil {
+				log.Printf("Error caching metadata failure for %s: %v", pageURL, dbErr)
+			}
+			return meta, err
+		}
+		_, dbErr := dbExec(ctx, `
+			INSERT OR REPLACE INTO og_cache (url, title, description, image, icon, fetched_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, pageURL, meta.Title, meta.Description, meta.Image, meta.Icon, now, now.Add(ogCacheTTL))
+		if dbErr != nil {
+			log.Printf("Error caching metadata for %s: %v", pageURL, dbErr)
+		}
+		return meta, nil
+	})
+	meta, _ := v.(PageMetadata)
+	return meta, err
+}
+
+func ogCacheRefreshWorker() {
+	ticker := time.NewTicker(ogCacheRefreshEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := refreshOGCache(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("og-cache-refresh", ogCacheRefreshEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Pages short links and bookmarks point at, that were never fetched or are
+// due again
+const ogCacheDueQuery = `
+	SELECT url FROM (
+		SELECT original_url AS url FROM urls
+		UNION SELECT url FROM bookmarks
+	) AS linked
+	WHERE NOT EXISTS (SELECT 1 FROM og_cache o WHERE o.url = linked.url AND o.expires_at > ?)
+	LIMIT ?`
+
+// Fetch a batch of missing and stale entries, and drop unused ones
+func refreshOGCache(ctx context.Context) error {
+	rows, err := dbQuery(ctx, ogCacheDueQuery, time.Now(), ogCacheRefreshBatch)
+	if err != nil {
+		log.Printf("Error finding link metadata to refresh: %v", err)
+		return err
+	}
+	var due []string
+	for rows.Next() {
+		var pageURL string
+		if err := rows.Scan(&pageURL); err == nil && isPublicLongURL(pageURL) { // from main.go
+			due = append(due, pageURL)
+		}
+	}
+	rows.Close()
+
+	for _, pageURL := range due {
+		select {
+		case <-shuttingDown:
+			return nil
+		default:
+		}
+		// Failures are stored with the entry; one unreachable page isn't a failed run
+		refreshPageMetadata(ctx, pageURL)
+	}
+
+	_, err = dbExec(ctx, `
+		DELETE FROM og_cache WHERE fetched_at < ?
+		AND url NOT IN (SELECT original_url FROM urls) AND url NOT IN (SELECT url FROM bookmarks)
+	`, time.Now().Add(-ogCacheUnusedAfter))
+	if err != nil {
+		log.Printf("Error purging unused link metadata: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// ogimage.go - Link preview cards for short URLs
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // favicon formats
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Card size recommended by the big link preview consumers
+const (
+	ogCardWidth  = 1200
+	ogCardHeight = 630
+)
+
+// Largest favicon we will download for a card
+const ogIconMaxBytes = 512 << 10
+
+// Brand colors, matching the site's dark purple theme
+var (
+	ogBackground = color.RGBA{0x00, 0x09, 0x1d, 0xff}
+	ogPanel      = color.RGBA{0x11, 0x18, 0x27, 0xff}
+	ogAccent     = color.RGBA{0xa8, 0x55, 0xf7, 0xff}
+	ogLavender   = color.RGBA{0xd8, 0xb4, 0xfe, 0xff}
+	ogMuted      = color.RGBA{0x9c, 0xa3, 0xaf, 0xff}
+)
+
+// User agents of services that fetch a link to build a preview card
+var linkPreviewBots = []string{
+	"facebookexternalhit", "twitterbot", "slackbot", "linkedinbot", "discordbot",
+	"telegrambot", "whatsapp", "mastodon", "bluesky", "skypeuripreview", "iframely",
+	"embedly", "redditbot", "pinterest", "applebot",
+}
+
+// Serializes rendering so a burst of crawlers renders each card once
+var ogCardMu sync.Mutex
+
+// Whether the request comes from a link preview crawler
+func isLinkPreviewBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, bot := range linkPreviewBots {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Initialize card cache storage
+func initOGImages() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS og_images (
+		short_code TEXT PRIMARY KEY,
+		destination TEXT NOT NULL,
+		png BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create og_images table:", err)
+	}
+}
+
+var ogFaces = sync.OnceValues(func() (map[string]font.Face, error) {
+	faces := map[string]font.Face{}
+	for _, spec := range []struct {
+		name string
+		ttf  []byte
+		size float64
+	}{
+		{"domain", gobold.TTF, 72},
+		{"title", goregular.TTF, 34},
+		{"brand", gobold.TTF, 30},
+		{"letter", gobold.TTF, 96},
+	} {
+		parsed, err := opentype.Parse(spec.ttf)
+		if err != nil {
+			return nil, err
+		}
+		face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: spec.size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		faces[spec.name] = face
+	}
+	return faces, nil
+})
+
+// Shorten text with an ellipsis until it fits the width
+func fitText(face font.Face, text string, width int) string {
+	max := fixed.I(width)
+	if font.MeasureString(face, text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if candidate := string(runes) + "…"; font.MeasureString(face, candidate) <= max {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func drawText(dst draw.Image, face font.Face, col color.Color, x, y int, text string) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// Download and decode an icon; ICO and SVG icons aren't supported and fail
+func fetchIcon(ctx context.Context, iconURL string) (image.Image, error) {
+	if err := checkPublicURL(iconURL); err != nil { // from httpclient.go
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pageMetadataClient.Do(req) // from pagemeta.go
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	img, _, err := image.Decode(io.LimitReader(resp.Body, ogIconMaxBytes))
+	return img, err
+}
+
+// Render the card: destination icon and domain, the page title, and the
+// short link under the site's branding
+func renderOGCard(icon image.Image, domain, title, shortLink string) ([]byte, error) {
+	faces, err := ogFaces()
+	if err != nil {
+		return nil, err
+	}
+
+	card := image.NewRGBA(image.Rect(0, 0, ogCardWidth, ogCardHeight))
+	draw.Draw(card, card.Bounds(), image.NewUniform(ogBackground), image.Point{}, draw.Src)
+	draw.Draw(card, image.Rect(60, 60, ogCardWidth-60, ogCardHeight-140), image.NewUniform(ogPanel), image.Point{}, draw.Src)
+	draw.Draw(card, image.Rect(60, 60, 72, ogCardHeight-140), image.NewUniform(ogAccent), image.Point{}, draw.Src)
+
+	// Icon, or the domain's initial when the site has no usable icon
+	iconBox := image.Rect(120, 120, 280, 280)
+	if icon != nil {
+		draw.CatmullRom.Scale(card, iconBox, icon, icon.Bounds(), draw.Over, nil)
+	} else {
+		draw.Draw(card, iconBox, image.NewUniform(ogAccent), image.Point{}, draw.Src)
+		letter := strings.ToUpper(string([]rune(domain + "?")[0]))
+		width := font.MeasureString(faces["letter"], letter).Round()
+		drawText(card, faces["letter"], color.White, iconBox.Min.X+(iconBox.Dx()-width)/2, iconBox.Max.Y-46, letter)
+	}
+
+	textX := iconBox.Max.X + 40
+	textWidth := ogCardWidth - 120 - textX
+	drawText(card, faces["domain"], color.White, textX, 200, fitText(faces["domain"], domain, textWidth))
+	if title != "" {
+		drawText(card, faces["title"], ogMuted, textX, 260, fitText(faces["title"], title, textWidth))
+	}
+
+	drawText(card, faces["brand"], ogLavender, 72, ogCardHeight-70, "Zach-Dev")
+	link := fitText(faces["title"], shortLink, 700)
+	drawText(card, faces["title"], ogMuted, ogCardWidth-72-font.MeasureString(faces["title"], link).Round(), ogCardHeight-70, link)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, card); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Card for a short link, from the cache unless the destination changed
+func shortLinkOGCard(ctx context.Context, shortCode, destination, shortLink string) ([]byte, error) {
+	ogCardMu.Lock()
+	defer ogCardMu.Unlock()
+
+	var cached []byte
+	err := dbQueryRow(ctx, "SELECT png FROM og_images WHERE short_code = ? AND destination = ?",
+		shortCode, destination).Scan(&cached)
+	if err == nil {
+		return cached, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	domain := strings.TrimPrefix(parsed.Hostname(), "www.")
+
+	// A page we can't read still gets a card, just without its title and icon
+	meta, err := cachedPageMetadata(ctx, destination) // from ogcache.go
+	if err != nil {
+		log.Printf("Error fetching metadata for card %s: %v", shortCode, err)
+	}
+	iconURL := meta.Icon
+	if iconURL == "" {
+		iconURL = parsed.Scheme + "://" + parsed.Host + "/favicon.ico"
+	}
+	icon, err := fetchIcon(ctx, iconURL)
+	if err != nil {
+		icon = nil
+	}
+
+	card, err := renderOGCard(icon, domain, meta.Title, strings.TrimPrefix(strings.TrimPrefix(shortLink, "https://"), "http://"))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dbExec(ctx, "INSERT OR REPLACE INTO og_images (short_code, destination, png) VALUES (?, ?, ?)",
+		shortCode, destination, card)
+	if err != nil {
+		log.Printf("Error caching card for %s: %v", shortCode, err)
+	}
+	return card, nil
+}
+
+// Page for link preview crawlers: the card and a redirect for anything
+// that renders it
+func renderShortLinkPreview(c *gin.Context, shortCode, destination string) {
+	shortLink := buildShortURL(c, shortCode) // from main.go
+	parsed, _ := url.Parse(destination)
+	domain := ""
+	if parsed != nil {
+		domain = strings.TrimPrefix(parsed.Hostname(), "www.")
+	}
+	c.HTML(http.StatusOK, "short-link-preview.html", gin.H{
+		"shortLink":   shortLink,
+		"destination": destination,
+		"domain":      domain,
+		"image":       shortLink + "/og.png",
+	})
+}
+
+// Setup the card image route
+func setupOGImageRoutes(r *gin.Engine) {
+	r.GET("/s/:code/og.png", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		shortCode := c.Param("code")
+		destination, exists := getURL(ctx, shortCode) // from main.go
+		if !exists {
+			c.String(http.StatusNotFound, "Short URL not found")
+			return
+		}
+
+		card, err := shortLinkOGCard(ctx, shortCode, destination, buildShortURL(c, shortCode))
+		if err != nil {
+			log.Printf("Error rendering card for %s: %v", shortCode, err)
+			c.String(http.StatusInternalServerError, "Failed to render image")
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Data(http.StatusOK, "image/png", card)
+	})
+}
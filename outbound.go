@@ -0,0 +1,117 @@
+// outbound.go - Counted redirects for links that leave the site
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signing key for outbound links, generated once and kept in settings so
+// links in cached pages and feeds stay valid across restarts
+const outboundSecretSetting = "outbound_link_secret"
+
+// Outbound link with its click count
+type OutboundStat struct {
+	Target        string    `json:"target"`
+	Clicks        int       `json:"clicks"`
+	LastClickedAt time.Time `json:"last_clicked_at"`
+}
+
+// Initialize outbound click storage and the signing key
+func initOutbound() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS outbound_clicks (
+		target TEXT PRIMARY KEY,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		last_clicked_at DATETIME
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create outbound_clicks table:", err)
+	}
+
+	if getSetting(outboundSecretSetting, "") == "" { // from settings.go
+		if err := setSetting(context.Background(), outboundSecretSetting, generateAdminToken()); err != nil {
+			log.Fatal("Failed to store outbound link secret:", err)
+		}
+	}
+}
+
+// Signature over a target, so /out can't be used as an open redirect
+func outboundSignature(target string) string {
+	mac := hmac.New(sha256.New, []byte(getSetting(outboundSecretSetting, "")))
+	mac.Write([]byte("outbound:" + target))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Tracked URL for an external link; the outLink template helper. Anything
+// but an absolute http(s) URL is returned unchanged.
+func outboundLink(target string) string {
+	if !isValidLongURL(target) {
+		return target
+	}
+	return "/out?" + url.Values{"u": {target}, "s": {outboundSignature(target)}}.Encode()
+}
+
+// Most clicked outbound links
+func getOutboundStats(ctx context.Context, limit int) ([]OutboundStat, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT target, clicks, last_clicked_at FROM outbound_clicks
+		ORDER BY clicks DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []OutboundStat
+	for rows.Next() {
+		var s OutboundStat
+		if err := rows.Scan(&s.Target, &s.Clicks, &s.LastClickedAt); err != nil {
+			continue
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// Setup the outbound redirect route
+func setupOutboundRoutes(r *gin.Engine) {
+	r.GET("/out", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		target := c.Query("u")
+		if !isValidLongURL(target) || !hmac.Equal([]byte(c.Query("s")), []byte(outboundSignature(target))) { // from main.go
+			c.HTML(http.StatusNotFound, "404.html", gin.H{
+				"message": "Link not found",
+			})
+			return
+		}
+
+		// Counted like page views: not for opted-out visitors or admin previews
+		if trackingStatus(c) == trackingTracked && !isPreview(c) { // from admin.go
+			clickedAt := time.Now()
+			safeGoRetry("outbound-click", 3, func() error {
+				_, err := dbExec(context.WithoutCancel(ctx), `
+					INSERT INTO outbound_clicks (target, clicks, last_clicked_at) VALUES (?, 1, ?)
+					ON CONFLICT(target) DO UPDATE SET clicks = clicks + 1, last_clicked_at = excluded.last_clicked_at
+				`, target, clickedAt)
+				if err != nil {
+					log.Printf("Error counting outbound click: %v", err)
+				}
+				return err
+			})
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, target)
+	})
+}
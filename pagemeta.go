@@ -0,0 +1,152 @@
+// pagemeta.go - Page metadata fetching
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// What a page says about itself for link previews
+type PageMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+}
+
+// Largest page we will read looking for metadata; it lives in <head>
+const pageMetadataMaxBytes = 512 << 10
+
+var pageMetadataClient = newHTTPClient("link preview", 10*time.Second) // from httpclient.go
+
+// Fetch a page and read its title, description and preview image from its
+// <title> and Open Graph tags
+func fetchPageMetadata(ctx context.Context, pageURL string) (PageMetadata, error) {
+	// Links made before destinations were checked may still point inside
+	if err := checkPublicURL(pageURL); err != nil { // from httpclient.go
+		return PageMetadata{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return PageMetadata{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := pageMetadataClient.Do(req)
+	if err != nil {
+		return PageMetadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PageMetadata{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "html") {
+		return PageMetadata{}, fmt.Errorf("not an HTML page: %s", contentType)
+	}
+
+	return parsePageMetadata(io.LimitReader(resp.Body, pageMetadataMaxBytes), resp.Request.URL), nil
+}
+
+// Read metadata from a page, preferring Open Graph tags over <title> and
+// the description meta tag; image and icon are resolved against the page URL.
+// The touch icon is preferred as it is usually a large PNG.
+func parsePageMetadata(r io.Reader, base *url.URL) PageMetadata {
+	var meta PageMetadata
+	var title, description string
+	inTitle := false
+	done := func() PageMetadata {
+		if meta.Title == "" {
+			meta.Title = strings.Join(strings.Fields(title), " ")
+		}
+		if meta.Description == "" {
+			meta.Description = description
+		}
+		return meta
+	}
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return done()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					meta.Title = content
+				case "og:description":
+					meta.Description = content
+				case "description":
+					description = content
+				case "og:image", "og:image:url":
+					if meta.Image == "" {
+						meta.Image = resolvePageMetadataURL(base, content)
+					}
+				}
+			case "link":
+				var rel, href string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "rel":
+						rel = strings.ToLower(attr.Val)
+					case "href":
+						href = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch {
+				case strings.Contains(rel, "apple-touch-icon"):
+					meta.Icon = resolvePageMetadataURL(base, href)
+				case strings.Contains(rel, "icon") && meta.Icon == "":
+					meta.Icon = resolvePageMetadataURL(base, href)
+				}
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			switch tokenizer.Token().Data {
+			case "title":
+				inTitle = false
+			case "head":
+				// Everything we want is in <head>; the body can be skipped
+				return done()
+			}
+		}
+	}
+}
+
+// Absolute http(s) URL for a reference found on the page, or empty
+func resolvePageMetadataURL(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || ref == "" {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,100 @@
+// pagination.go - Pagination for public and admin lists
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes
+const (
+	blogPostsPerPage = 10
+	adminPageSize    = 50
+)
+
+// Links to the neighbouring pages of a newest-first list, rendered by the
+// "pager" template. Page and TotalPages are only set for numbered pages.
+type Pager struct {
+	Page       int
+	TotalPages int
+	PrevURL    string // newer entries
+	NextURL    string // older entries
+}
+
+// Requested page number from ?page=, 1 when missing or invalid
+func pageNumber(c *gin.Context) int {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// Pager for a page-numbered list. The first page's URL has no ?page=, so
+// search engines see a single address for it.
+func numberedPager(c *gin.Context, page, perPage, total int) Pager {
+	p := Pager{Page: page, TotalPages: max((total+perPage-1)/perPage, 1)}
+	if page > 1 {
+		p.PrevURL = pageURL(c, "page", strconv.Itoa(page-1))
+		if page == 2 {
+			p.PrevURL = pageURL(c, "page", "")
+		}
+	}
+	if page < p.TotalPages {
+		p.NextURL = pageURL(c, "page", strconv.Itoa(page+1))
+	}
+	return p
+}
+
+// Pager for a cursor-paged list: next is the cursor after the last row
+// shown, "" at the end. Going back returns to the newest entries.
+func cursorPager(c *gin.Context, next string) Pager {
+	var p Pager
+	if c.Query("after") != "" {
+		p.PrevURL = pageURL(c, "after", "")
+	}
+	if next != "" {
+		p.NextURL = pageURL(c, "after", next)
+	}
+	return p
+}
+
+// The current URL with one query parameter replaced, or removed when empty
+func pageURL(c *gin.Context, name, value string) string {
+	query := c.Request.URL.Query()
+	query.Del(name)
+	if value != "" {
+		query.Set(name, value)
+	}
+	if encoded := query.Encode(); encoded != "" {
+		return c.Request.URL.Path + "?" + encoded
+	}
+	return c.Request.URL.Path
+}
+
+// Cursor for the row after which the next page starts: its sort value and
+// a unique tiebreaker, both as stored
+func encodeCursor(key, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "\x00" + id))
+}
+
+// Cursor from ?after=; ok is false on the first page or for a mangled cursor
+func cursorParam(c *gin.Context) (key, id string, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(c.Query("after"))
+	if err != nil || len(raw) == 0 {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), "\x00")
+}
+
+// Page title suffix for pages after the first, e.g. " (page 2)"
+func (p Pager) TitleSuffix() string {
+	if p.Page < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" (page %d)", p.Page)
+}
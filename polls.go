@@ -0,0 +1,435 @@
+// polls.go - Admin-created polls embedded in pages
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Votes each client may send per minute, across polls
+const pollRateLimit = 10
+
+const (
+	pollMaxOptions        = 10
+	pollMaxOptionLength   = 100
+	pollMaxQuestionLength = 300
+)
+
+// Poll shortcode, usable in templates and in post, page or snippet content
+var pollShortcode = regexp.MustCompile(`\[\[poll ([a-z0-9-]+)\]\]`)
+
+// Script added to pages with a poll that don't load HTMX themselves
+var pollHTMXScriptTag = []byte(`<script src="https://unpkg.com/htmx.org@1.9.10"></script>`)
+
+var pollRateLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// Poll open for votes between its start and end times
+type Poll struct {
+	ID         int
+	Slug       string
+	Question   string
+	StartsAt   time.Time
+	EndsAt     sql.NullTime
+	CreatedAt  time.Time
+	Options    []PollOption
+	TotalVotes int
+}
+
+type PollOption struct {
+	ID    int
+	Label string
+	Votes int
+}
+
+// Whether the poll takes votes at the given time
+func (p Poll) ActiveAt(now time.Time) bool {
+	return !now.Before(p.StartsAt) && (!p.EndsAt.Valid || now.Before(p.EndsAt.Time))
+}
+
+// Share of the votes, as a whole percentage
+func (p Poll) Percent(votes int) int {
+	if p.TotalVotes == 0 {
+		return 0
+	}
+	return (votes*100 + p.TotalVotes/2) / p.TotalVotes
+}
+
+// Initialize poll storage. A visitor votes once per poll, recognised by
+// their hashed IP, so at most once a day as the hash changes daily.
+func initPolls() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS polls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT NOT NULL UNIQUE,
+		question TEXT NOT NULL,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS poll_options (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		poll_id INTEGER NOT NULL,
+		label TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0
+	)`, `
+	CREATE TABLE IF NOT EXISTS poll_votes (
+		poll_id INTEGER NOT NULL,
+		option_id INTEGER NOT NULL,
+		ip_hash TEXT NOT NULL,
+		voted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (poll_id, ip_hash)
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create poll tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_poll_options_poll ON poll_options (poll_id, position)`)
+}
+
+// All polls, newest first, with their results
+func getPolls(ctx context.Context) ([]Poll, error) {
+	rows, err := dbQuery(ctx, `SELECT id, slug, question, starts_at, ends_at, created_at FROM polls`)
+	if err != nil {
+		return nil, err
+	}
+	var polls []Poll
+	for rows.Next() {
+		var p Poll
+		if err := rows.Scan(&p.ID, &p.Slug, &p.Question, &p.StartsAt, &p.EndsAt, &p.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Timestamps are stored as text, so sort here rather than in SQL
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].StartsAt.After(polls[j].StartsAt)
+	})
+	for i := range polls {
+		if err := loadPollResults(ctx, &polls[i]); err != nil {
+			return nil, err
+		}
+	}
+	return polls, nil
+}
+
+func getPollBySlug(ctx context.Context, slug string) (*Poll, error) {
+	p := &Poll{}
+	err := dbQueryRow(ctx, `
+		SELECT id, slug, question, starts_at, ends_at, created_at FROM polls WHERE slug = ?
+	`, slug).Scan(&p.ID, &p.Slug, &p.Question, &p.StartsAt, &p.EndsAt, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, loadPollResults(ctx, p)
+}
+
+// Fill in the poll's options and their vote counts
+func loadPollResults(ctx context.Context, p *Poll) error {
+	rows, err := dbQuery(ctx, `
+		SELECT o.id, o.label, COUNT(v.option_id) FROM poll_options o
+		LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.poll_id = ?
+		GROUP BY o.id ORDER BY o.position, o.id
+	`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	p.Options, p.TotalVotes = nil, 0
+	for rows.Next() {
+		var o PollOption
+		if err := rows.Scan(&o.ID, &o.Label, &o.Votes); err != nil {
+			return err
+		}
+		p.Options = append(p.Options, o)
+		p.TotalVotes += o.Votes
+	}
+	return rows.Err()
+}
+
+func hasVoted(ctx context.Context, pollID int, ipHash string) (bool, error) {
+	var n int
+	err := dbQueryRow(ctx, "SELECT COUNT(*) FROM poll_votes WHERE poll_id = ? AND ip_hash = ?", pollID, ipHash).Scan(&n)
+	return n > 0, err
+}
+
+// Record a vote for one of the poll's options; a second vote is ignored
+func castVote(ctx context.Context, p *Poll, optionID int, ipHash string) error {
+	_, err := dbExec(ctx, `
+		INSERT INTO poll_votes (poll_id, option_id, ip_hash)
+		SELECT poll_id, id, ? FROM poll_options WHERE id = ? AND poll_id = ?
+		ON CONFLICT DO NOTHING
+	`, ipHash, optionID, p.ID)
+	return err
+}
+
+// Replace poll shortcodes with placeholders that load the poll, adding
+// HTMX to pages that don't have it
+func expandPolls(page []byte) []byte {
+	if !bytes.Contains(page, []byte("[[poll ")) {
+		return page
+	}
+	page = pollShortcode.ReplaceAllFunc(page, func(match []byte) []byte {
+		slug := string(pollShortcode.FindSubmatch(match)[1])
+		return []byte(`<div class="poll" hx-get="/partials/polls/` + slug + `" hx-trigger="load" hx-swap="outerHTML"></div>`)
+	})
+	if bytes.Contains(page, []byte("htmx.org")) {
+		return page
+	}
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return page
+	}
+	out := make([]byte, 0, len(page)+len(pollHTMXScriptTag))
+	out = append(out, page[:i]...)
+	out = append(out, pollHTMXScriptTag...)
+	return append(out, page[i:]...)
+}
+
+// Parse the admin poll form; options are one per line
+func pollFromForm(c *gin.Context) (*Poll, []string, string) {
+	p := &Poll{
+		Slug:     slugify(c.PostForm("slug")), // from blog.go
+		Question: strings.TrimSpace(c.PostForm("question")),
+		StartsAt: time.Now(),
+	}
+	if p.Slug == "" {
+		p.Slug = slugify(p.Question)
+	}
+	if p.Question == "" || len(p.Question) > pollMaxQuestionLength {
+		return nil, nil, "A poll needs a question of up to 300 characters"
+	}
+
+	var options []string
+	for _, line := range strings.Split(c.PostForm("options"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			options = append(options, line)
+		}
+	}
+	if len(options) < 2 || len(options) > pollMaxOptions {
+		return nil, nil, "A poll needs between 2 and 10 options"
+	}
+	for _, o := range options {
+		if len(o) > pollMaxOptionLength {
+			return nil, nil, "Options are limited to 100 characters"
+		}
+	}
+
+	// Times are entered in the server's local time zone; no start means now
+	if value := c.PostForm("starts_at"); value != "" {
+		var err error
+		p.StartsAt, err = time.ParseInLocation(datetimeLocalLayout, value, time.Local) // from linkschedule.go
+		if err != nil {
+			return nil, nil, "Invalid start time"
+		}
+	}
+	if value := c.PostForm("ends_at"); value != "" {
+		var err error
+		p.EndsAt.Time, err = time.ParseInLocation(datetimeLocalLayout, value, time.Local)
+		p.EndsAt.Valid = err == nil
+		if !p.EndsAt.Valid || !p.EndsAt.Time.After(p.StartsAt) {
+			return nil, nil, "The end time must be after the start time"
+		}
+	}
+	return p, options, ""
+}
+
+func savePoll(ctx context.Context, p *Poll, options []string) error {
+	tx, cancel, err := dbBegin(ctx) // from dbctx.go
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO polls (slug, question, starts_at, ends_at) VALUES (?, ?, ?, ?)`,
+		p.Slug, p.Question, p.StartsAt, p.EndsAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i, label := range options {
+		if _, err := tx.Exec(`INSERT INTO poll_options (poll_id, label, position) VALUES (?, ?, ?)`, id, label, i); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Setup the poll partial and the route votes are sent to
+func setupPollRoutes(r *gin.Engine) {
+	// Voting form while the poll is open and the visitor hasn't voted,
+	// otherwise results that refresh themselves
+	render := func(c *gin.Context, p *Poll) {
+		ctx := c.Request.Context()
+		voted, err := hasVoted(ctx, p.ID, hashIP(c.ClientIP()))
+		if err != nil {
+			log.Printf("Error checking poll vote: %v", err)
+		}
+		now := time.Now()
+		c.Header("Cache-Control", "no-store")
+		c.HTML(http.StatusOK, "poll.html", gin.H{
+			"poll":     p,
+			"open":     p.ActiveAt(now),
+			"upcoming": now.Before(p.StartsAt),
+			"voted":    voted,
+		})
+	}
+
+	// Loads the poll, answering 404 itself when there's none
+	loadPoll := func(c *gin.Context) (*Poll, bool) {
+		p, err := getPollBySlug(c.Request.Context(), c.Param("slug"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading poll: %v", err)
+			}
+			c.Status(http.StatusNotFound)
+			return nil, false
+		}
+		return p, true
+	}
+
+	r.GET("/partials/polls/:slug", func(c *gin.Context) {
+		if p, ok := loadPoll(c); ok {
+			render(c, p)
+		}
+	})
+
+	r.POST("/polls/:slug/vote", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		if !pollRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, pollRateLimit) {
+			c.Header("Retry-After", "60")
+			c.String(http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		optionID, err := strconv.Atoi(c.PostForm("option"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid option")
+			return
+		}
+		p, ok := loadPoll(c)
+		if !ok {
+			return
+		}
+
+		if p.ActiveAt(time.Now()) && !isPreview(c) { // from preview.go
+			if err := castVote(ctx, p, optionID, ipHash); err != nil {
+				log.Printf("Error recording poll vote: %v", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			if err := loadPollResults(ctx, p); err != nil {
+				log.Printf("Error loading poll results: %v", err)
+			}
+		}
+		render(c, p)
+	})
+}
+
+// Setup admin poll routes
+func setupPollAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/polls", func(c *gin.Context) {
+		polls, err := getPolls(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading polls: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load polls",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-polls.html", gin.H{
+			"polls": polls,
+			"now":   time.Now(),
+		})
+	})
+
+	adminGroup.POST("/polls", func(c *gin.Context) {
+		p, options, problem := pollFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": problem,
+			})
+			return
+		}
+		if err := savePoll(c.Request.Context(), p, options); err != nil {
+			log.Printf("Error saving poll: %v", err)
+			message := "Failed to save poll"
+			if strings.Contains(err.Error(), "UNIQUE") {
+				message = "A poll with that slug already exists"
+			}
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": message,
+			})
+			return
+		}
+
+		log.Printf("Poll %s added by admin from %s", p.Slug, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/polls")
+	})
+
+	// Close a poll now, keeping its results
+	adminGroup.POST("/polls/:id/close", func(c *gin.Context) {
+		_, err := dbExec(c.Request.Context(), "UPDATE polls SET ends_at = ? WHERE id = ?", time.Now(), c.Param("id"))
+		if err != nil {
+			log.Printf("Error closing poll: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to close poll",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/polls")
+	})
+
+	adminGroup.DELETE("/polls/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		tx, cancel, err := dbBegin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete poll"})
+			return
+		}
+		defer cancel()
+		defer tx.Rollback()
+
+		for _, stmt := range []string{
+			"DELETE FROM poll_votes WHERE poll_id = ?",
+			"DELETE FROM poll_options WHERE poll_id = ?",
+			"DELETE FROM polls WHERE id = ?",
+		} {
+			if _, err := tx.Exec(stmt, c.Param("id")); err != nil {
+				log.Printf("Error deleting poll: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete poll"})
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete poll"})
+			return
+		}
+
+		log.Printf("Poll %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Poll deleted"})
+	})
+}
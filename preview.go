@@ -0,0 +1,63 @@
+// preview.go - "View as visitor" preview mode for the admin
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Preview cookie is scoped to the whole site (the admin cookie is /admin only)
+const previewCookie = "preview_mode"
+
+// Cookie value proving preview was started by the admin; changes with
+// the admin token, so preview ends when the session secret rotates
+func previewToken() string {
+	mac := hmac.New(sha256.New, []byte(currentAdminToken())) // from rotation.go
+	mac.Write([]byte("preview"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Mark preview requests so tracking and experiments can skip them
+func previewMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, err := c.Cookie(previewCookie); err == nil &&
+			subtle.ConstantTimeCompare([]byte(value), []byte(previewToken())) == 1 {
+			c.Set(previewCookie, true)
+			c.Header("X-Preview-Mode", "1")
+			c.Header("Cache-Control", "no-store")
+		}
+		c.Next()
+	}
+}
+
+// Whether the request is an admin previewing the site
+func isPreview(c *gin.Context) bool {
+	return c.GetBool(previewCookie)
+}
+
+// Setup admin preview toggle routes
+func setupPreviewAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/preview", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "admin-preview.html", gin.H{
+			"active": isPreview(c),
+		})
+	})
+
+	adminGroup.POST("/preview", func(c *gin.Context) {
+		if c.PostForm("enabled") == "1" {
+			c.SetCookie(previewCookie, previewToken(), 3600*8, "/", "", false, true)
+			log.Printf("Preview mode started by admin from %s", hashIP(c.ClientIP()))
+			c.Redirect(http.StatusSeeOther, "/")
+			return
+		}
+
+		c.SetCookie(previewCookie, "", -1, "/", "", false, true)
+		c.Redirect(http.StatusSeeOther, "/admin/preview")
+	})
+}
@@ -0,0 +1,97 @@
+// qrcode.go - QR codes for short links
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// PNG sizes in pixels; the default suits print and phone screens alike
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 128
+	qrMaxSize     = 1024
+)
+
+// Path of a short link's QR code on this site
+func shortLinkQRPath(shortCode, format string) string {
+	return "/s/" + shortCode + "/qr?format=" + format
+}
+
+// QR code as an SVG, one rect per dark module run on each row, so it scales
+// to any size without blurring
+func qrSVG(content string) ([]byte, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := q.Bitmap() // includes the quiet zone
+	n := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String()), nil
+}
+
+// Setup the QR code route
+func setupQRCodeRoutes(r *gin.Engine) {
+	// ?format=svg (the default) or png; ?size= sets the PNG's width in pixels
+	r.GET("/s/:code/qr", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		shortCode := c.Param("code")
+		if _, exists := getURL(ctx, shortCode); !exists { // from main.go
+			c.String(http.StatusNotFound, "Short URL not found")
+			return
+		}
+		shortURL := buildShortURL(c, shortCode) // from main.go
+
+		switch c.DefaultQuery("format", "svg") {
+		case "svg":
+			svg, err := qrSVG(shortURL)
+			if err != nil {
+				log.Printf("Error generating QR code for %s: %v", shortCode, err)
+				c.String(http.StatusInternalServerError, "Failed to generate QR code")
+				return
+			}
+			writeConditional(c, "image/svg+xml", svg, "public, max-age=86400") // from conditional.go
+		case "png":
+			size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(qrDefaultSize)))
+			if err != nil || size < qrMinSize || size > qrMaxSize {
+				c.String(http.StatusBadRequest, fmt.Sprintf("size must be between %d and %d", qrMinSize, qrMaxSize))
+				return
+			}
+			png, err := qrcode.Encode(shortURL, qrcode.Medium, size)
+			if err != nil {
+				log.Printf("Error generating QR code for %s: %v", shortCode, err)
+				c.String(http.StatusInternalServerError, "Failed to generate QR code")
+				return
+			}
+			if c.Query("download") == "1" {
+				c.Header("Content-Disposition", `attachment; filename="`+shortCode+`-qr.png"`)
+			}
+			writeConditional(c, "image/png", png, "public, max-age=86400")
+		default:
+			c.String(http.StatusBadRequest, "format must be svg or png")
+		}
+	})
+}
@@ -0,0 +1,288 @@
+// reactions.go - Anonymous like/clap reactions on blog posts
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reaction sends each client may make per minute, across posts
+const reactionRateLimit = 20
+
+// Days shown in the admin trends
+const reactionTrendDays = 30
+
+// A kind of reaction readers can leave
+type ReactionKind struct {
+	Name  string
+	Label string
+	Emoji string
+}
+
+var reactionKinds = []ReactionKind{
+	{"like", "Like", "❤️"},
+	{"clap", "Clap", "👏"},
+}
+
+func validReaction(name string) bool {
+	for _, k := range reactionKinds {
+		if k.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var reactionRateLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// One reaction's count on a post, and whether this visitor left it today
+type ReactionCount struct {
+	ReactionKind
+	Count   int
+	Reacted bool
+}
+
+// Reactions shown under a post
+type PostReactions struct {
+	Slug   string
+	Counts []ReactionCount
+}
+
+// Initialize reaction storage. A visitor can leave each reaction once a
+// day per post, recognised by their hashed IP, which changes daily anyway.
+func initReactions() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS post_reactions (
+		post_id INTEGER NOT NULL,
+		reaction TEXT NOT NULL,
+		day TEXT NOT NULL,
+		ip_hash TEXT NOT NULL,
+		PRIMARY KEY (post_id, reaction, day, ip_hash)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create post_reactions table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_post_reactions_day ON post_reactions (day)`)
+}
+
+// Record a reaction; repeats on the same day are ignored
+func addReaction(ctx context.Context, postID int, reaction, ipHash string) error {
+	_, err := dbExec(ctx, `
+		INSERT INTO post_reactions (post_id, reaction, day, ip_hash) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, postID, reaction, time.Now().UTC().Format("2006-01-02"), ipHash)
+	return err
+}
+
+func getPostReactions(ctx context.Context, post Post, ipHash string) (*PostReactions, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT reaction, COUNT(*), MAX(day = ? AND ip_hash = ?) FROM post_reactions
+		WHERE post_id = ? GROUP BY reaction
+	`, time.Now().UTC().Format("2006-01-02"), ipHash, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	reacted := map[string]bool{}
+	for rows.Next() {
+		var reaction string
+		var count int
+		var mine bool
+		if err := rows.Scan(&reaction, &count, &mine); err != nil {
+			return nil, err
+		}
+		counts[reaction], reacted[reaction] = count, mine
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	r := &PostReactions{Slug: post.Slug}
+	for _, k := range reactionKinds {
+		r.Counts = append(r.Counts, ReactionCount{k, counts[k.Name], reacted[k.Name]})
+	}
+	return r, nil
+}
+
+// Reactions per day across posts, and per post, over recent days
+type ReactionTrends struct {
+	Days   int
+	Total  int
+	PerDay []ReactionDay
+	Posts  []PostReactionTotal
+}
+
+type ReactionDay struct {
+	Day   string
+	Count int
+}
+
+// A post's reactions: in the trend window and all time
+type PostReactionTotal struct {
+	Slug   string
+	Title  string
+	Recent int
+	Total  int
+	ByKind map[string]int // all time
+}
+
+func getReactionTrends(ctx context.Context, days int) (*ReactionTrends, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	t := &ReactionTrends{Days: days}
+
+	rows, err := dbQuery(ctx, `SELECT day, COUNT(*) FROM post_reactions WHERE day >= ? GROUP BY day`, since)
+	if err != nil {
+		return nil, err
+	}
+	perDay := map[string]int{}
+	for rows.Next() {
+		var d ReactionDay
+		if err := rows.Scan(&d.Day, &d.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		perDay[d.Day] = d.Count
+		t.Total += d.Count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	start, _ := time.Parse("2006-01-02", since)
+	for i := 0; i < days; i++ {
+		day := start.AddDate(0, 0, i).Format("2006-01-02")
+		t.PerDay = append(t.PerDay, ReactionDay{day, perDay[day]})
+	}
+
+	rows, err = dbQuery(ctx, `
+		SELECT p.slug, p.title, r.reaction, COUNT(*), SUM(r.day >= ?) FROM post_reactions r
+		JOIN posts p ON p.id = r.post_id
+		GROUP BY r.post_id, r.reaction
+		ORDER BY p.published_at DESC, p.id DESC
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := map[string]int{} // slug to position in t.Posts
+	for rows.Next() {
+		var slug, title, reaction string
+		var total, recent int
+		if err := rows.Scan(&slug, &title, &reaction, &total, &recent); err != nil {
+			return nil, err
+		}
+		i, ok := index[slug]
+		if !ok {
+			i = len(t.Posts)
+			index[slug] = i
+			t.Posts = append(t.Posts, PostReactionTotal{Slug: slug, Title: title, ByKind: map[string]int{}})
+		}
+		p := &t.Posts[i]
+		p.Recent += recent
+		p.Total += total
+		p.ByKind[reaction] = total
+	}
+	return t, rows.Err()
+}
+
+// Height of a day's bar in the chart, as a percentage of the busiest day
+func (t *ReactionTrends) BarHeight(count int) int {
+	peak := 0
+	for _, day := range t.PerDay {
+		peak = max(peak, day.Count)
+	}
+	if peak == 0 {
+		return 0
+	}
+	return count * 100 / peak
+}
+
+// Setup the reaction partial and the route reactions are sent to
+func setupReactionRoutes(r *gin.Engine) {
+	render := func(c *gin.Context, post Post) {
+		ctx := c.Request.Context()
+		reactions, err := getPostReactions(ctx, post, hashIP(c.ClientIP()))
+		if err != nil {
+			log.Printf("Error loading reactions: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.HTML(http.StatusOK, "reactions.html", reactions)
+	}
+
+	// Loads the post, answering 404 itself when it isn't published
+	loadPost := func(c *gin.Context) (Post, bool) {
+		post, err := getPublishedPost(c.Request.Context(), c.Param("slug")) // from blog.go
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading post: %v", err)
+			}
+			c.Status(http.StatusNotFound)
+			return post, false
+		}
+		return post, true
+	}
+
+	r.GET("/partials/reactions/:slug", func(c *gin.Context) {
+		if post, ok := loadPost(c); ok {
+			render(c, post)
+		}
+	})
+
+	r.POST("/blog/:slug/reactions", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		if !reactionRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, reactionRateLimit) {
+			c.Header("Retry-After", "60")
+			c.String(http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		reaction := c.PostForm("reaction")
+		if !validReaction(reaction) {
+			c.String(http.StatusBadRequest, "invalid reaction")
+			return
+		}
+		post, ok := loadPost(c)
+		if !ok {
+			return
+		}
+
+		if !isPreview(c) { // from preview.go
+			if err := addReaction(ctx, post.ID, reaction, ipHash); err != nil {
+				log.Printf("Error recording reaction: %v", err)
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+		}
+		render(c, post)
+	})
+}
+
+// Setup the admin reaction trends page
+func setupReactionAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/reactions", func(c *gin.Context) {
+		trends, err := getReactionTrends(c.Request.Context(), reactionTrendDays)
+		if err != nil {
+			log.Printf("Error loading reaction trends: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load reactions",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-reactions.html", gin.H{
+			"trends": trends,
+			"kinds":  reactionKinds,
+		})
+	})
+}
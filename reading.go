@@ -0,0 +1,315 @@
+// reading.go - Reading list
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Reading states, in the order the public page shows them
+const (
+	readingInProgress = "reading"
+	readingFinished   = "finished"
+	readingWanted     = "want"
+)
+
+var readingStatuses = []string{readingInProgress, readingFinished, readingWanted}
+
+var readingStatusLabels = map[string]string{
+	readingInProgress: "Currently reading",
+	readingFinished:   "Finished",
+	readingWanted:     "Want to read",
+}
+
+// Kinds of reading
+var readingKinds = map[string]bool{"book": true, "article": true}
+
+// Book or article on the reading list
+type ReadingItem struct {
+	ID         int        `json:"id"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	Kind       string     `json:"kind"`
+	URL        string     `json:"url,omitempty"`
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"` // percent
+	Rating     int        `json:"rating,omitempty"`
+	Notes      string     `json:"notes,omitempty"` // Markdown source
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Rendered notes (Markdown, raw HTML escaped)
+func (r ReadingItem) NotesHTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(r.Notes), &buf); err != nil {
+		log.Printf("Error rendering reading notes %d: %v", r.ID, err)
+		return template.HTML(template.HTMLEscapeString(r.Notes))
+	}
+	return template.HTML(buf.String())
+}
+
+// Rating as filled and empty stars
+func (r ReadingItem) Stars() string {
+	return strings.Repeat("★", r.Rating) + strings.Repeat("☆", 5-r.Rating)
+}
+
+// Reading list section for one status
+type ReadingGroup struct {
+	Status string
+	Label  string
+	Items  []ReadingItem
+}
+
+// Initialize reading list storage
+func initReading() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS reading_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		author TEXT NOT NULL DEFAULT '',
+		kind TEXT NOT NULL DEFAULT 'book',
+		url TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'want',
+		progress INTEGER NOT NULL DEFAULT 0,
+		rating INTEGER NOT NULL DEFAULT 0,
+		notes TEXT NOT NULL DEFAULT '',
+		started_at DATETIME,
+		finished_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create reading_items table:", err)
+	}
+}
+
+// Reading list, newest first; an empty status means all
+func getReadingItems(ctx context.Context, status string) ([]ReadingItem, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, title, author, kind, url, status, progress, rating, notes, started_at, finished_at, updated_at
+		FROM reading_items
+		WHERE ? = '' OR status = ?
+		ORDER BY id DESC
+	`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []ReadingItem
+	for rows.Next() {
+		var r ReadingItem
+		if err := rows.Scan(&r.ID, &r.Title, &r.Author, &r.Kind, &r.URL, &r.Status, &r.Progress,
+			&r.Rating, &r.Notes, &r.StartedAt, &r.FinishedAt, &r.UpdatedAt); err != nil {
+			continue
+		}
+		items = append(items, r)
+	}
+	return items, rows.Err()
+}
+
+// Split the list by status, skipping empty sections
+func groupReadingItems(items []ReadingItem) []ReadingGroup {
+	var groups []ReadingGroup
+	for _, status := range readingStatuses {
+		group := ReadingGroup{Status: status, Label: readingStatusLabels[status]}
+		for _, r := range items {
+			if r.Status == status {
+				group.Items = append(group.Items, r)
+			}
+		}
+		if len(group.Items) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Read a reading item from the admin form; the problem is empty when valid
+func readingItemFromForm(c *gin.Context) (ReadingItem, string) {
+	r := ReadingItem{
+		Title:  strings.TrimSpace(c.PostForm("title")),
+		Author: strings.TrimSpace(c.PostForm("author")),
+		Kind:   c.DefaultPostForm("kind", "book"),
+		URL:    strings.TrimSpace(c.PostForm("url")),
+		Status: c.DefaultPostForm("status", readingWanted),
+		Notes:  strings.TrimSpace(c.PostForm("notes")),
+	}
+	r.Progress, _ = strconv.Atoi(c.DefaultPostForm("progress", "0"))
+	r.Rating, _ = strconv.Atoi(c.DefaultPostForm("rating", "0"))
+
+	switch {
+	case r.Title == "":
+		return r, "A title is required"
+	case !readingKinds[r.Kind]:
+		return r, "Unknown kind"
+	case readingStatusLabels[r.Status] == "":
+		return r, "Unknown reading status"
+	case r.URL != "" && !isValidLongURL(r.URL): // from main.go
+		return r, "The link must be a valid http:// or https:// URL"
+	case r.Progress < 0 || r.Progress > 100:
+		return r, "Progress must be between 0 and 100"
+	case r.Rating < 0 || r.Rating > 5:
+		return r, "Rating must be between 0 and 5"
+	}
+
+	// Finishing implies the whole thing was read
+	if r.Status == readingFinished {
+		r.Progress = 100
+	}
+	return r, ""
+}
+
+// Setup public reading list routes
+func setupReadingRoutes(r *gin.Engine) {
+	r.GET("/reading", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		items, err := getReadingItems(ctx, "")
+		if err != nil {
+			log.Printf("Error loading reading list: %v", err)
+		}
+		c.HTML(http.StatusOK, "reading.html", gin.H{
+			"title":  "Reading",
+			"groups": groupReadingItems(items),
+			"seo":    getPageSEO(ctx, "/reading"),
+		})
+	})
+}
+
+// Serve the reading list as JSON, optionally for one status
+func readingAPIHandler(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && readingStatusLabels[status] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reading status"})
+		return
+	}
+
+	items, err := getReadingItems(c.Request.Context(), status)
+	if err != nil {
+		log.Printf("Error loading reading list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load reading list"})
+		return
+	}
+	if items == nil {
+		items = []ReadingItem{}
+	}
+
+	writeCachedJSON(c, gin.H{"reading": items}, "300") // from contentapi.go
+}
+
+// Setup admin reading list routes
+func setupReadingAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/reading", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		items, err := getReadingItems(ctx, "")
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load reading list",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-reading.html", gin.H{
+			"items":    items,
+			"new":      ReadingItem{Kind: "book", Status: readingWanted},
+			"statuses": readingStatuses,
+			"labels":   readingStatusLabels,
+		})
+	})
+
+	adminGroup.POST("/reading", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		r, problem := readingItemFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		now := time.Now()
+		var startedAt, finishedAt *time.Time
+		if r.Status != readingWanted {
+			startedAt = &now
+		}
+		if r.Status == readingFinished {
+			finishedAt = &now
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO reading_items (title, author, kind, url, status, progress, rating, notes, started_at, finished_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, r.Title, r.Author, r.Kind, r.URL, r.Status, r.Progress, r.Rating, r.Notes, startedAt, finishedAt, now)
+		if err != nil {
+			log.Printf("Error saving reading item: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save reading item",
+			})
+			return
+		}
+
+		log.Printf("Reading item added by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/reading")
+	})
+
+	adminGroup.POST("/reading/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		r, problem := readingItemFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		// Start and finish dates are stamped the first time the status gets there
+		now := time.Now()
+		result, err := dbExec(ctx, `
+			UPDATE reading_items SET title = ?, author = ?, kind = ?, url = ?, status = ?, progress = ?, rating = ?, notes = ?,
+				started_at = CASE WHEN ? != 'want' THEN COALESCE(started_at, ?) ELSE started_at END,
+				finished_at = CASE WHEN ? = 'finished' THEN COALESCE(finished_at, ?) ELSE NULL END,
+				updated_at = ?
+			WHERE id = ?
+		`, r.Title, r.Author, r.Kind, r.URL, r.Status, r.Progress, r.Rating, r.Notes,
+			r.Status, now, r.Status, now, now, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating reading item %s: %v", c.Param("id"), err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save reading item",
+			})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Reading item not found",
+			})
+			return
+		}
+
+		log.Printf("Reading item %s updated by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/reading")
+	})
+
+	adminGroup.DELETE("/reading/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM reading_items WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reading item"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reading item not found"})
+			return
+		}
+
+		log.Printf("Reading item %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Reading item deleted"})
+	})
+}
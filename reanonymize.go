@@ -0,0 +1,355 @@
+// reanonymize.go - Background re-anonymization of stored visitors
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What a job does to each row's hashed_ip. Raw IPs are never stored, so
+// jobs can only transform existing hashes, never re-derive them; each one
+// keys a new HMAC with a random key that is thrown away when the job ends.
+const (
+	reanonymizeRekey = "rekey" // a new hash per old one: unique counts stay, links to current hashes go
+	reanonymizeDaily = "daily" // a new hash per old one and day: unique counts become daily uniques
+	reanonymizeErase = "erase" // no identifier at all
+)
+
+// Job states
+const (
+	anonymizationRunning   = "running"
+	anonymizationDone      = "done"
+	anonymizationCancelled = "cancelled"
+	anonymizationFailed    = "failed"
+)
+
+const (
+	reanonymizeBatchSize  = 500
+	reanonymizeBatchPause = 50 * time.Millisecond // lets page views in between batches
+	reanonymizeCheckEvery = time.Minute
+)
+
+// Actions offered in the admin
+var reanonymizeActions = []struct{ Name, Label string }{
+	{reanonymizeDaily, "Collapse to daily uniques"},
+	{reanonymizeRekey, "Re-key, keeping unique counts"},
+	{reanonymizeErase, "Erase identifiers"},
+}
+
+// Re-anonymization job as shown in the admin
+type AnonymizationJob struct {
+	ID         int
+	Action     string
+	Cutoff     time.Time // rows recorded before this are covered
+	Status     string
+	Total      int
+	Processed  int
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt sql.NullTime
+}
+
+// Share of the job's rows done, 0-100
+func (j AnonymizationJob) Percent() int {
+	if j.Total == 0 {
+		return 100
+	}
+	return min(j.Processed*100/j.Total, 100)
+}
+
+// Wakes the worker when a job is started
+var reanonymizeWake = make(chan struct{}, 1)
+
+// Initialize job storage and the worker, which also resumes jobs a restart
+// interrupted, from the last finished batch
+func initReanonymize() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS anonymization_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		cutoff DATETIME NOT NULL,
+		job_key TEXT,
+		status TEXT NOT NULL DEFAULT 'running',
+		total INTEGER DEFAULT 0,
+		processed INTEGER DEFAULT 0,
+		last_id INTEGER DEFAULT 0,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create anonymization_jobs table:", err)
+	}
+
+	startWorker("anonymization-jobs", anonymizationJobWorker) // from safego.go
+}
+
+func anonymizationJobWorker() {
+	ticker := time.NewTicker(reanonymizeCheckEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := runAnonymizationJobs(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("anonymization-jobs", reanonymizeCheckEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-reanonymizeWake:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Run the site's running jobs to the end, oldest first
+func runAnonymizationJobs(ctx context.Context) error {
+	rows, err := dbQuery(ctx, "SELECT id FROM anonymization_jobs WHERE status = ? ORDER BY id", anonymizationRunning)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := runAnonymizationJob(ctx, id); err != nil {
+			log.Printf("Anonymization job %d failed: %v", id, err)
+			if _, dbErr := dbExec(ctx, "UPDATE anonymization_jobs SET status = ?, error = ?, job_key = NULL, finished_at = ? WHERE id = ?",
+				anonymizationFailed, err.Error(), time.Now(), id); dbErr != nil {
+				log.Printf("Error saving anonymization job %d: %v", id, dbErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Work through a job a batch at a time, until it's done, cancelled or the
+// server shuts down
+func runAnonymizationJob(ctx context.Context, id int) error {
+	for {
+		var action, key, status string
+		var cutoff time.Time
+		var lastID int
+		err := dbQueryRow(ctx, "SELECT action, cutoff, COALESCE(job_key, ''), status, last_id FROM anonymization_jobs WHERE id = ?", id).
+			Scan(&action, &cutoff, &key, &status, &lastID)
+		if err != nil {
+			return err
+		}
+		if status != anonymizationRunning {
+			return nil // cancelled from the admin
+		}
+
+		done, err := reanonymizeBatch(ctx, id, action, key, cutoff, lastID)
+		if err != nil {
+			return err
+		}
+		if done {
+			_, err := dbExec(ctx, "UPDATE anonymization_jobs SET status = ?, job_key = NULL, finished_at = ? WHERE id = ? AND status = ?",
+				anonymizationDone, time.Now(), id, anonymizationRunning)
+			log.Printf("Anonymization job %d (%s) finished", id, action)
+			return err
+		}
+
+		select {
+		case <-time.After(reanonymizeBatchPause):
+		case <-shuttingDown:
+			return nil // resumed on the next start
+		}
+	}
+}
+
+// Rewrite the next batch of rows after lastID, saving the job's progress in
+// the same transaction so a restart never repeats or skips rows
+func reanonymizeBatch(ctx context.Context, id int, action, key string, cutoff time.Time, lastID int) (bool, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, hashed_ip, timestamp FROM visitors
+		WHERE id > ? AND timestamp < ?
+		ORDER BY id LIMIT ?
+	`, lastID, cutoff, reanonymizeBatchSize)
+	if err != nil {
+		return false, err
+	}
+	type visit struct {
+		id        int
+		hashedIP  string
+		timestamp time.Time
+	}
+	var batch []visit
+	for rows.Next() {
+		var v visit
+		if err := rows.Scan(&v.id, &v.hashedIP, &v.timestamp); err != nil {
+			rows.Close()
+			return false, err
+		}
+		batch = append(batch, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if len(batch) == 0 {
+		return true, nil
+	}
+
+	tx, err := dbFor(ctx).BeginTx(ctx, nil) // from sites.go
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, v := range batch {
+		if _, err := tx.ExecContext(ctx, "UPDATE visitors SET hashed_ip = ? WHERE id = ?", reanonymizeHash(action, key, v.hashedIP, v.timestamp), v.id); err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE anonymization_jobs SET processed = processed + ?, last_id = ? WHERE id = ?",
+		len(batch), batch[len(batch)-1].id, id)
+	if err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+// New identifier for a row under a job's action
+func reanonymizeHash(action, key, hashedIP string, timestamp time.Time) string {
+	if action == reanonymizeErase || hashedIP == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	if action == reanonymizeDaily {
+		mac.Write([]byte(timestamp.UTC().Format("2006-01-02") + ":"))
+	}
+	mac.Write([]byte(hashedIP))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Recent jobs, newest first
+func getAnonymizationJobs(ctx context.Context) ([]AnonymizationJob, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, action, cutoff, status, COALESCE(total, 0), COALESCE(processed, 0), COALESCE(error, ''), created_at, finished_at
+		FROM anonymization_jobs
+		ORDER BY id DESC LIMIT 10
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []AnonymizationJob
+	for rows.Next() {
+		var j AnonymizationJob
+		if err := rows.Scan(&j.ID, &j.Action, &j.Cutoff, &j.Status, &j.Total, &j.Processed, &j.Error, &j.CreatedAt, &j.FinishedAt); err != nil {
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Setup admin routes for starting, following and cancelling jobs. They
+// work on the current site's visitors, so every site's admin may use them.
+func setupReanonymizeAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/anonymization-jobs", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		action := c.PostForm("action")
+		days, err := strconv.Atoi(c.PostForm("older_than_days"))
+		valid := false
+		for _, a := range reanonymizeActions {
+			valid = valid || a.Name == action
+		}
+		if !valid || err != nil || days < 0 {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "An action and a number of days (0 for all rows) are required",
+			})
+			return
+		}
+
+		var running int
+		dbQueryRow(ctx, "SELECT COUNT(*) FROM anonymization_jobs WHERE status = ?", anonymizationRunning).Scan(&running)
+		if running > 0 {
+			c.HTML(http.StatusConflict, "admin-error.html", gin.H{
+				"error": "A re-anonymization job is already running",
+			})
+			return
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -days)
+		var total int
+		err = dbQueryRow(ctx, "SELECT COUNT(*) FROM visitors WHERE timestamp < ?", cutoff).Scan(&total)
+		if err == nil {
+			_, err = dbExec(ctx, "INSERT INTO anonymization_jobs (action, cutoff, job_key, total) VALUES (?, ?, ?, ?)",
+				action, cutoff, generateAdminToken(), total)
+		}
+		if err != nil {
+			log.Printf("Error starting anonymization job: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to start re-anonymization",
+			})
+			return
+		}
+
+		select {
+		case reanonymizeWake <- struct{}{}:
+		default:
+		}
+		log.Printf("Anonymization job (%s, %d rows) started by admin from %s", action, total, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape(fmt.Sprintf("Re-anonymizing %d visitor rows in the background", total)))
+	})
+
+	adminGroup.GET("/anonymization-jobs/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var j AnonymizationJob
+		err := dbQueryRow(ctx, "SELECT id, action, status, COALESCE(total, 0), COALESCE(processed, 0), COALESCE(error, '') FROM anonymization_jobs WHERE id = ?",
+			c.Param("id")).Scan(&j.ID, &j.Action, &j.Status, &j.Total, &j.Processed, &j.Error)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id": j.ID, "action": j.Action, "status": j.Status,
+			"total": j.Total, "processed": j.Processed, "percent": j.Percent(), "error": j.Error,
+		})
+	})
+
+	adminGroup.POST("/anonymization-jobs/:id/cancel", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "UPDATE anonymization_jobs SET status = ?, job_key = NULL, finished_at = ? WHERE id = ? AND status = ?",
+			anonymizationCancelled, time.Now(), c.Param("id"), anonymizationRunning)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to cancel re-anonymization",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Job not found or already finished",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Re-anonymization cancelled; rows already done stay changed"))
+	})
+}
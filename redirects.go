@@ -0,0 +1,199 @@
+// redirects.go - Admin-managed redirects for legacy and mistyped paths
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Redirect rule
+type Redirect struct {
+	ID         int          `json:"id"`
+	SourcePath string       `json:"source_path"`
+	Target     string       `json:"target"`
+	StatusCode int          `json:"status_code"`
+	Hits       int          `json:"hits"`
+	LastHitAt  sql.NullTime `json:"-"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// Initialize redirect rules
+func initRedirects() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS redirects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_path TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		target TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 301,
+		hits INTEGER DEFAULT 0,
+		last_hit_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create redirects table:", err)
+	}
+
+	// Old links to the resume
+	_, err = db.Exec(`INSERT OR IGNORE INTO redirects (source_path, target, status_code) VALUES ('/cv', '/resume', 301)`)
+	if err != nil {
+		log.Printf("Error seeding redirects: %v", err)
+	}
+}
+
+// Normalize a path for matching: leading slash, no trailing slash
+func normalizeRedirectPath(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+// Targets are site paths or absolute http(s) URLs
+func isValidRedirectTarget(target string) bool {
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return true
+	}
+	return isValidLongURL(target)
+}
+
+// Find the redirect for a path and count the hit
+func lookupRedirect(ctx context.Context, path string) (Redirect, bool) {
+	var r Redirect
+	err := dbQueryRow(ctx, "SELECT id, target, status_code FROM redirects WHERE source_path = ?",
+		normalizeRedirectPath(path)).Scan(&r.ID, &r.Target, &r.StatusCode)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up redirect: %v", err)
+		}
+		return r, false
+	}
+
+	_, err = dbExec(ctx, "UPDATE redirects SET hits = hits + 1, last_hit_at = ? WHERE id = ?", time.Now(), r.ID)
+	if err != nil {
+		log.Printf("Error counting redirect hit: %v", err)
+	}
+	return r, true
+}
+
+// NoRoute fallback: consult redirect rules before rendering 404
+func notFoundHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		if redirect, ok := lookupRedirect(ctx, c.Request.URL.Path); ok {
+			target := redirect.Target
+			if c.Request.URL.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(redirect.StatusCode, target)
+			return
+		}
+	}
+
+	recordNotFound(c) // from notfound.go
+	c.HTML(http.StatusNotFound, "404.html", gin.H{
+		"heading": "Page Not Found",
+		"message": "Nothing lives at " + c.Request.URL.Path,
+	})
+}
+
+// Setup admin redirect management routes
+func setupRedirectAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/redirects", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		rows, err := dbQuery(ctx, `
+			SELECT id, source_path, target, status_code, hits, last_hit_at, created_at
+			FROM redirects
+			ORDER BY source_path
+		`)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load redirects",
+			})
+			return
+		}
+		defer rows.Close()
+
+		var redirects []Redirect
+		for rows.Next() {
+			var r Redirect
+			err := rows.Scan(&r.ID, &r.SourcePath, &r.Target, &r.StatusCode, &r.Hits, &r.LastHitAt, &r.CreatedAt)
+			if err != nil {
+				continue
+			}
+			redirects = append(redirects, r)
+		}
+
+		// The 404 report links here with the missing path filled in
+		c.HTML(http.StatusOK, "admin-redirects.html", gin.H{
+			"redirects": redirects,
+			"source":    c.Query("source"),
+		})
+	})
+
+	// Create or replace a redirect
+	adminGroup.POST("/redirects", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		source := normalizeRedirectPath(c.PostForm("source_path"))
+		target := strings.TrimSpace(c.PostForm("target"))
+		statusCode := http.StatusMovedPermanently
+		if c.PostForm("status_code") == "302" {
+			statusCode = http.StatusFound
+		}
+
+		if source == "/" || !isValidRedirectTarget(target) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A redirect needs a source path and a target path or http(s) URL",
+			})
+			return
+		}
+		if normalizeRedirectPath(target) == source {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A redirect cannot point at itself",
+			})
+			return
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO redirects (source_path, target, status_code) VALUES (?, ?, ?)
+			ON CONFLICT(source_path) DO UPDATE SET target = excluded.target, status_code = excluded.status_code
+		`, source, target, statusCode)
+		if err != nil {
+			log.Printf("Error saving redirect: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save redirect",
+			})
+			return
+		}
+
+		log.Printf("Redirect %s -> %s (%d) saved by admin from %s", source, target, statusCode, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/redirects")
+	})
+
+	adminGroup.DELETE("/redirects/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM redirects WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete redirect"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Redirect not found"})
+			return
+		}
+
+		log.Printf("Redirect %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Redirect deleted"})
+	})
+}
@@ -0,0 +1,127 @@
+// rendererrors.go - Template execution error handling
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A template that failed to render
+type TemplateError struct {
+	Time     time.Time
+	Template string
+	Theme    string
+	Path     string
+	DataKeys []string // what the handler passed, without the values
+	Err      string
+}
+
+// How many recent errors diagnostics lists
+const templateErrorsKept = 50
+
+var (
+	templateErrorsMu sync.Mutex
+	templateErrors   []TemplateError // oldest first
+)
+
+// Names of the values a handler passed to a template; values aren't kept
+// as they may hold anything
+func templateDataKeys(data any) []string {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		// View models (from viewmodels.go)
+		keys := []string{"(" + v.Type().Name() + ")"}
+		for i := 0; i < v.NumField(); i++ {
+			keys = append(keys, v.Type().Field(i).Name)
+		}
+		return keys
+	}
+	if v.Kind() != reflect.Map {
+		return []string{fmt.Sprintf("(%T)", data)}
+	}
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, fmt.Sprint(key.Interface()))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Log and keep a template error
+func recordTemplateError(name, theme, path string, data any, err error) {
+	entry := TemplateError{
+		Time:     time.Now(),
+		Template: name,
+		Theme:    theme,
+		Path:     path,
+		DataKeys: templateDataKeys(data),
+		Err:      err.Error(),
+	}
+	log.Printf("Error rendering %s (theme %s) for %s with data keys %v: %v", name, theme, path, entry.DataKeys, err)
+
+	templateErrorsMu.Lock()
+	defer templateErrorsMu.Unlock()
+	templateErrors = append(templateErrors, entry)
+	if len(templateErrors) > templateErrorsKept {
+		templateErrors = templateErrors[len(templateErrors)-templateErrorsKept:]
+	}
+}
+
+// Recent template errors, newest first
+func recentTemplateErrors() []TemplateError {
+	templateErrorsMu.Lock()
+	defer templateErrorsMu.Unlock()
+	recent := make([]TemplateError, len(templateErrors))
+	for i, entry := range templateErrors {
+		recent[len(templateErrors)-1-i] = entry
+	}
+	return recent
+}
+
+// Answer with the 500 page in place of a page that failed to render. If
+// that fails too, plain text has to do.
+func writeRenderErrorPage(w http.ResponseWriter, tmpl *template.Template) {
+	w.Header().Set("Cache-Control", "no-store")
+	var buf bytes.Buffer
+	if tmpl != nil && tmpl.ExecuteTemplate(&buf, "500.html", nil) == nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprint(w, "Something went wrong showing this page.")
+}
+
+// Diagnostics check: errors in the last day fail it
+func checkTemplateErrors() DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Template rendering"}
+	recent := recentTemplateErrors()
+	if len(recent) == 0 {
+		check.Status, check.Detail = checkPass, "no render errors since startup"
+		return check
+	}
+	latest := recent[0]
+	if time.Since(latest.Time) > 24*time.Hour {
+		check.Status = checkPass
+		check.Detail = fmt.Sprintf("no render errors in the last day; last was %s at %s", latest.Template, latest.Time.Format(time.RFC1123))
+		return check
+	}
+	check.Status = checkFail
+	check.Detail = fmt.Sprintf("%d recent error(s), latest %s for %s: %s", len(recent), latest.Template, latest.Path, latest.Err)
+	return check
+}
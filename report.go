@@ -0,0 +1,372 @@
+// report.go - Monthly PDF report
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Rows shown in the report's top lists
+const reportTopN = 10
+
+// Figures for one month, merged from visitor rows, the aggregate counters
+// and the visitor archive
+type MonthlyReport struct {
+	Month          time.Time
+	DailyViews     []int64 // index 0 is the 1st; nil when only the monthly archive is left
+	TotalViews     int64
+	UniqueVisitors int64 // visitor rows and archive only; counters keep no visitors
+	TopPages       []PathViews
+	TopLinks       []ReportLink
+	ContactForms   int64
+	InboundEmails  int64
+	URLsCreated    int64
+}
+
+// Short link clicks in the month
+type ReportLink struct {
+	ShortCode   string
+	OriginalURL string
+	Clicks      int64
+}
+
+// Gather the report figures for the month starting at month (UTC)
+func buildMonthlyReport(ctx context.Context, month time.Time) (*MonthlyReport, error) {
+	report := &MonthlyReport{Month: month}
+	prefix := month.Format("2006-01")
+	days := month.AddDate(0, 1, -1).Day()
+
+	// Views per day from live visitor rows and both counter tables; visitor
+	// timestamps are Go time strings, so days are cut out with substr
+	rows, err := dbQuery(ctx, `
+		SELECT day, SUM(views) FROM (
+			SELECT substr(timestamp, 1, 10) AS day, COUNT(*) AS views FROM visitors
+			WHERE timestamp >= ? AND timestamp < ? GROUP BY day
+			UNION ALL
+			SELECT substr(hour, 1, 10), SUM(views) FROM page_view_counters
+			WHERE hour >= ? AND hour < ? GROUP BY substr(hour, 1, 10)
+			UNION ALL
+			SELECT day, SUM(views) FROM country_page_counts
+			WHERE day >= ? AND day < ? GROUP BY day
+		) GROUP BY day`,
+		prefix, prefix+"~", prefix, prefix+"~", prefix, prefix+"~")
+	if err != nil {
+		return nil, err
+	}
+	daily := make([]int64, days)
+	for rows.Next() {
+		var day string
+		var views int64
+		if rows.Scan(&day, &views) != nil {
+			continue
+		}
+		var d int
+		if _, err := fmt.Sscanf(day, prefix+"-%d", &d); err == nil && d >= 1 && d <= days {
+			daily[d-1] += views
+			report.TotalViews += views
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	report.DailyViews = daily
+
+	err = dbQueryRow(ctx, "SELECT COUNT(DISTINCT hashed_ip) FROM visitors WHERE timestamp >= ? AND timestamp < ?",
+		prefix, prefix+"~").Scan(&report.UniqueVisitors)
+	if err != nil {
+		return nil, err
+	}
+
+	// Archived months only have totals and top paths left (from archive.go)
+	var archivedViews, archivedUnique int64
+	err = dbQueryRow(ctx, "SELECT views, unique_visitors FROM visitor_archive WHERE month = ?", prefix).
+		Scan(&archivedViews, &archivedUnique)
+	if err == nil {
+		report.TotalViews += archivedViews
+		report.UniqueVisitors += archivedUnique
+		if archivedViews > 0 {
+			report.DailyViews = nil
+		}
+	}
+
+	pages, err := reportPathViews(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if report.TopLinks, err = reportTopLinks(ctx, pages); err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		if !strings.HasPrefix(p.Path, "/s/") && len(report.TopPages) < reportTopN {
+			report.TopPages = append(report.TopPages, p)
+		}
+	}
+
+	err = dbQueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN source = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN source = ? THEN 1 ELSE 0 END), 0)
+		FROM messages WHERE created_at >= ? AND created_at < ?`,
+		messageSourceContact, messageSourceEmail, prefix, prefix+"~").Scan(&report.ContactForms, &report.InboundEmails)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbQueryRow(ctx, "SELECT COUNT(*) FROM urls WHERE created_at >= ? AND created_at < ?",
+		prefix, prefix+"~").Scan(&report.URLsCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Views per path in the month from every source, most viewed first
+func reportPathViews(ctx context.Context, prefix string) ([]PathViews, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT path, SUM(views) FROM (
+			SELECT path, COUNT(*) AS views FROM visitors
+			WHERE timestamp >= ? AND timestamp < ? GROUP BY path
+			UNION ALL
+			SELECT path, SUM(views) FROM page_view_counters
+			WHERE hour >= ? AND hour < ? GROUP BY path
+			UNION ALL
+			SELECT path, SUM(views) FROM country_page_counts
+			WHERE day >= ? AND day < ? GROUP BY path
+			UNION ALL
+			SELECT path, views FROM visitor_archive_paths WHERE month = ?
+		) GROUP BY path ORDER BY SUM(views) DESC`,
+		prefix, prefix+"~", prefix, prefix+"~", prefix, prefix+"~", prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []PathViews
+	for rows.Next() {
+		var p PathViews
+		if rows.Scan(&p.Path, &p.Views) == nil {
+			pages = append(pages, p)
+		}
+	}
+	return pages, rows.Err()
+}
+
+// Most clicked short links, from the /s/ page views (already sorted)
+func reportTopLinks(ctx context.Context, pages []PathViews) ([]ReportLink, error) {
+	var links []ReportLink
+	for _, p := range pages {
+		code, ok := strings.CutPrefix(p.Path, "/s/")
+		if !ok || code == "" || strings.Contains(code, "/") {
+			continue
+		}
+		link := ReportLink{ShortCode: code, Clicks: p.Views}
+		err := dbQueryRow(ctx, "SELECT original_url FROM urls WHERE short_code = ?", code).Scan(&link.OriginalURL)
+		if err != nil {
+			continue // deleted or never existed
+		}
+		links = append(links, link)
+		if len(links) == reportTopN {
+			break
+		}
+	}
+	return links, nil
+}
+
+// Render the report as a PDF
+func renderReportPDF(report *MonthlyReport) ([]byte, error) {
+	title := "Site Report - " + report.Month.Format("January 2006")
+
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetMargins(18, 16, 18)
+	pdf.SetAutoPageBreak(true, 16)
+	pdf.SetTitle(title, true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Generated %s - page %d/{nb}", time.Now().UTC().Format("2006-01-02 15:04 UTC"), pdf.PageNo()),
+			"", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Core fonts are cp1252 - translate UTF-8 text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	section := func(title string) {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(0, 7, tr(title), "B", 1, "L", false, 0, "")
+		pdf.Ln(2)
+	}
+
+	// Summary figures, two per row
+	figures := []struct {
+		label string
+		value int64
+	}{
+		{"Page views", report.TotalViews},
+		{"Unique visitors", report.UniqueVisitors},
+		{"Contact form messages", report.ContactForms},
+		{"Inbound emails", report.InboundEmails},
+		{"Short links created", report.URLsCreated},
+	}
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	half := (pageWidth - left - right) / 2
+	for i, f := range figures {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.CellFormat(half*0.6, 7, tr(f.label), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetTextColor(0, 0, 0)
+		ln := 0
+		if i%2 == 1 || i == len(figures)-1 {
+			ln = 1
+		}
+		pdf.CellFormat(half*0.4, 7, fmt.Sprintf("%d", f.value), "", ln, "L", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.MultiCell(0, 4, "Unique visitors only cover views recorded in standard analytics mode; aggregate-only modes keep no visitor identifiers.", "", "L", false)
+	pdf.SetTextColor(0, 0, 0)
+
+	section("Daily Traffic")
+	if report.DailyViews == nil {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 5, "This month has been archived; only monthly totals were kept.", "", "L", false)
+	} else {
+		drawDailyChart(pdf, report.DailyViews, pageWidth-left-right)
+	}
+
+	table := func(heading, unit string, rows [][2]string) {
+		section(heading)
+		pdf.SetFont("Helvetica", "", 10)
+		if len(rows) == 0 {
+			pdf.CellFormat(0, 6, "None this month", "", 1, "L", false, 0, "")
+			return
+		}
+		width := pageWidth - left - right
+		for i, row := range rows {
+			fill := i%2 == 0
+			pdf.SetFillColor(243, 240, 250)
+			pdf.CellFormat(width-30, 6, tr(reportCellText(row[0])), "", 0, "L", fill, 0, "")
+			pdf.CellFormat(30, 6, row[1]+" "+unit, "", 1, "R", fill, 0, "")
+		}
+	}
+
+	var pageRows [][2]string
+	for _, p := range report.TopPages {
+		pageRows = append(pageRows, [2]string{p.Path, fmt.Sprintf("%d", p.Views)})
+	}
+	table("Top Pages", "views", pageRows)
+
+	var linkRows [][2]string
+	for _, l := range report.TopLinks {
+		linkRows = append(linkRows, [2]string{"/s/" + l.ShortCode + "  " + l.OriginalURL, fmt.Sprintf("%d", l.Clicks)})
+	}
+	table("Top Short Links", "clicks", linkRows)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Cut long paths and URLs to fit a table row
+func reportCellText(text string) string {
+	if runes := []rune(text); len(runes) > 90 {
+		return string(runes[:87]) + "..."
+	}
+	return text
+}
+
+// Bar chart of views per day of the month
+func drawDailyChart(pdf *gofpdf.Fpdf, daily []int64, width float64) {
+	const height = 50
+	var peak int64
+	for _, v := range daily {
+		peak = max(peak, v)
+	}
+
+	x0, y0 := pdf.GetX(), pdf.GetY()
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(x0, y0+height, x0+width, y0+height)
+	pdf.Text(x0, y0-1, fmt.Sprintf("peak %d views/day", peak))
+
+	slot := width / float64(len(daily))
+	pdf.SetFillColor(147, 112, 219)
+	for i, v := range daily {
+		x := x0 + float64(i)*slot
+		if peak > 0 && v > 0 {
+			h := float64(v) / float64(peak) * height
+			pdf.Rect(x+slot*0.15, y0+height-h, slot*0.7, h, "F")
+		}
+		if i == 0 || (i+1)%5 == 0 {
+			pdf.Text(x+slot*0.2, y0+height+4, fmt.Sprintf("%d", i+1))
+		}
+	}
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetY(y0 + height + 6)
+}
+
+// Setup the admin report export route
+func setupReportAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/export/report.pdf", serveMonthlyReport)
+}
+
+// Monthly report; ?month=YYYY-MM, defaulting to last month. Also served by
+// export links, which always get last month's.
+func serveMonthlyReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	if param := c.Query("month"); param != "" {
+		parsed, err := time.Parse("2006-01", param)
+		if err != nil || parsed.After(now) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Month must be a past or current month as YYYY-MM",
+			})
+			return
+		}
+		month = parsed
+	}
+
+	report, err := buildMonthlyReport(ctx, month)
+	if err != nil {
+		log.Printf("Error building monthly report: %v", err)
+		c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+			"error": "Failed to build report",
+		})
+		return
+	}
+	pdfBytes, err := renderReportPDF(report)
+	if err != nil {
+		log.Printf("Error rendering monthly report: %v", err)
+		c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+			"error": "Failed to render report",
+		})
+		return
+	}
+
+	log.Printf("Monthly report for %s exported by %s", month.Format("2006-01"), hashIP(c.ClientIP()))
+	c.Header("Content-Disposition", "attachment; filename=report-"+month.Format("2006-01")+".pdf")
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
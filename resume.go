@@ -0,0 +1,227 @@
+// resume.go - Resume as JSON Resume and HTML
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSON Resume schema (subset this site has data for)
+type JSONResume struct {
+	Schema    string              `json:"$schema"`
+	Basics    JSONResumeBasics    `json:"basics"`
+	Work      []JSONResumeWork    `json:"work"`
+	Education []JSONResumeEduc    `json:"education"`
+	Projects  []JSONResumeProject `json:"projects"`
+	Skills    []JSONResumeSkill   `json:"skills"`
+	Meta      JSONResumeMeta      `json:"meta"`
+}
+
+type JSONResumeBasics struct {
+	Name     string              `json:"name"`
+	Label    string              `json:"label"`
+	Email    string              `json:"email,omitempty"`
+	URL      string              `json:"url"`
+	Summary  string              `json:"summary"`
+	Profiles []JSONResumeProfile `json:"profiles"`
+}
+
+type JSONResumeProfile struct {
+	Network  string `json:"network"`
+	Username string `json:"username"`
+	URL      string `json:"url"`
+}
+
+type JSONResumeWork struct {
+	Name       string   `json:"name"`
+	Position   string   `json:"position"`
+	URL        string   `json:"url,omitempty"`
+	StartDate  string   `json:"startDate,omitempty"`
+	EndDate    string   `json:"endDate,omitempty"`
+	Highlights []string `json:"highlights"`
+}
+
+type JSONResumeEduc struct {
+	Institution string   `json:"institution"`
+	URL         string   `json:"url,omitempty"`
+	StudyType   string   `json:"studyType"`
+	StartDate   string   `json:"startDate,omitempty"`
+	EndDate     string   `json:"endDate,omitempty"`
+	Courses     []string `json:"courses"`
+}
+
+type JSONResumeProject struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	URL         string   `json:"url,omitempty"`
+	Keywords    []string `json:"keywords"`
+}
+
+// Skills are grouped by category, as the schema's examples do
+type JSONResumeSkill struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"`
+}
+
+type JSONResumeMeta struct {
+	Canonical string `json:"canonical"`
+	Version   string `json:"version"`
+}
+
+// The site's owner, as the resume and structured data (from
+// structureddata.go) describe them
+const (
+	ownerName  = "Zachariah Kordas-Potter"
+	ownerLabel = "Software Developer"
+)
+
+var ownerProfiles = []JSONResumeProfile{
+	{Network: "GitHub", Username: "Zachkp", URL: "https://github.com/Zachkp"},
+	{Network: "LinkedIn", Username: "zach-kordas-potter", URL: "https://linkedin.com/in/zach-kordas-potter"},
+}
+
+// Available /resume/html themes (templates/resume-<theme>.html)
+var resumeThemes = []string{"classic", "print"}
+
+// Convert display dates like "Aug 2023" or "Sept 2019" to ISO "2023-08"
+func resumeDate(display string) string {
+	display = strings.TrimSpace(display)
+	if display == "" || strings.EqualFold(display, present) {
+		return ""
+	}
+
+	normalized := strings.Replace(display, "Sept ", "Sep ", 1)
+	for _, layout := range []string{"Jan 2006", "January 2006", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, normalized); err == nil {
+			if layout == "2006" {
+				return t.Format("2006")
+			}
+			return t.Format("2006-01")
+		}
+	}
+	return display
+}
+
+// Build a JSON Resume document from the content tables
+func buildJSONResume(ctx context.Context) (*JSONResume, error) {
+	content, err := loadSiteContent(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	email := os.Getenv("TO_EMAIL")
+	if email == "" {
+		email = "zachkordaspotter@gmail.com"
+	}
+
+	resume := &JSONResume{
+		Schema: "https://raw.githubusercontent.com/jsonresume/resume-schema/v1.0.0/schema.json",
+		Basics: JSONResumeBasics{
+			Name:     ownerName,
+			Label:    ownerLabel,
+			Email:    email,
+			URL:      "https://zachkp.dev",
+			Summary:  strings.Join(strings.Fields(content.About), " "),
+			Profiles: ownerProfiles,
+		},
+		Meta: JSONResumeMeta{
+			Canonical: "https://zachkp.dev/resume.json",
+			Version:   "v1.0.0",
+		},
+	}
+
+	for _, e := range content.Experience {
+		resume.Work = append(resume.Work, JSONResumeWork{
+			Name:       e.Organization,
+			Position:   e.Title,
+			URL:        e.URL,
+			StartDate:  resumeDate(e.StartDate),
+			EndDate:    resumeDate(e.EndDate),
+			Highlights: e.Bullets,
+		})
+	}
+
+	for _, e := range content.Education {
+		resume.Education = append(resume.Education, JSONResumeEduc{
+			Institution: e.Organization,
+			URL:         e.URL,
+			StudyType:   e.Title,
+			StartDate:   resumeDate(e.StartDate),
+			EndDate:     resumeDate(e.EndDate),
+			Courses:     e.Bullets,
+		})
+	}
+
+	for _, p := range content.Projects {
+		resume.Projects = append(resume.Projects, JSONResumeProject{
+			Name:        p.Title,
+			Description: strings.Join(strings.Fields(p.Summary), " "),
+			URL:         p.URL,
+			Keywords:    p.Tech,
+		})
+	}
+
+	for _, category := range skillCategories(content.Skills) { // from skills.go
+		skill := JSONResumeSkill{Name: category}
+		for _, s := range content.Skills {
+			if s.Category == category {
+				skill.Keywords = append(skill.Keywords, s.Name)
+			}
+		}
+		resume.Skills = append(resume.Skills, skill)
+	}
+
+	return resume, nil
+}
+
+// Setup resume routes
+func setupResumeRoutes(r *gin.Engine) {
+	// JSON Resume document
+	r.GET("/resume.json", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		resume, err := buildJSONResume(ctx)
+		if err != nil {
+			log.Printf("Error building resume: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build resume"})
+			return
+		}
+
+		writeCachedJSON(c, resume, "300")
+	})
+
+	// Server-rendered resume with a selectable theme
+	r.GET("/resume/html", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		theme := c.DefaultQuery("theme", resumeThemes[0])
+		valid := false
+		for _, t := range resumeThemes {
+			if t == theme {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			theme = resumeThemes[0]
+		}
+
+		resume, err := buildJSONResume(ctx)
+		if err != nil {
+			log.Printf("Error building resume: %v", err)
+			c.String(http.StatusInternalServerError, "Resume is unavailable right now")
+			return
+		}
+
+		c.HTML(http.StatusOK, "resume-"+theme+".html", gin.H{
+			"resume": resume,
+			"theme":  theme,
+			"themes": resumeThemes,
+			"seo":    getPageSEO(ctx, "/resume/html"),
+		})
+	})
+}
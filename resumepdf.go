@@ -0,0 +1,280 @@
+// resumepdf.go - Server-generated resume PDF with version history
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Stored resume PDF version (without the PDF bytes)
+type ResumeVersion struct {
+	ID          int       `json:"id"`
+	ContentHash string    `json:"content_hash"`
+	Size        int       `json:"size"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Serializes generation so concurrent downloads don't create duplicate versions
+var resumeGenerateMu sync.Mutex
+
+// Fallback for when generation fails
+const staticResumePath = "./static/Zach Kordas-Potter Resume.pdf"
+
+// Initialize resume version storage
+func initResumePDF() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS resume_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content_hash TEXT NOT NULL,
+		pdf BLOB NOT NULL,
+		note TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create resume_versions table:", err)
+	}
+}
+
+// Render a JSON Resume document as a PDF
+func renderResumePDF(resume *JSONResume) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetMargins(18, 16, 18)
+	pdf.SetAutoPageBreak(true, 16)
+	pdf.SetTitle(resume.Basics.Name+" - Resume", true)
+	pdf.SetAuthor(resume.Basics.Name, true)
+	pdf.AddPage()
+
+	// Core fonts are cp1252 - translate UTF-8 text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 9, tr(resume.Basics.Name), "", 1, "L", false, 0, "")
+
+	contact := []string{resume.Basics.Label, resume.Basics.Email, resume.Basics.URL}
+	for _, p := range resume.Basics.Profiles {
+		contact = append(contact, p.URL)
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(90, 90, 90)
+	pdf.MultiCell(0, 5, tr(strings.Join(contact, "  |  ")), "", "L", false)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(2)
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.MultiCell(0, 5, tr(resume.Basics.Summary), "", "L", false)
+
+	section := func(title string) {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(0, 7, tr(title), "B", 1, "L", false, 0, "")
+		pdf.Ln(1)
+	}
+
+	entry := func(heading, sub string, bullets []string) {
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.CellFormat(0, 6, tr(heading), "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.CellFormat(0, 5, tr(sub), "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetFont("Helvetica", "", 10)
+		for _, b := range bullets {
+			pdf.SetX(22)
+			pdf.MultiCell(0, 5, tr("- "+b), "", "L", false)
+		}
+		pdf.Ln(2)
+	}
+
+	dateRange := func(start, end string) string {
+		if end == "" {
+			end = present
+		}
+		return start + " - " + end
+	}
+
+	section("Experience")
+	for _, w := range resume.Work {
+		entry(w.Position+", "+w.Name, dateRange(w.StartDate, w.EndDate), w.Highlights)
+	}
+
+	section("Education")
+	for _, e := range resume.Education {
+		sub := e.StartDate
+		if e.EndDate != "" {
+			sub += " - " + e.EndDate
+		}
+		entry(e.StudyType+", "+e.Institution, sub, e.Courses)
+	}
+
+	section("Projects")
+	for _, p := range resume.Projects {
+		entry(p.Name, strings.Join(p.Keywords, ", "), []string{p.Description})
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash of the resume data a PDF was generated from
+func resumeContentHash(resume *JSONResume) (string, error) {
+	body, err := json.Marshal(resume)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(contentETag(body), `"`), nil
+}
+
+// Generate and store a new PDF version
+func generateResumeVersion(ctx context.Context, resume *JSONResume, hash, note string) (int64, []byte, error) {
+	pdfBytes, err := renderResumePDF(resume)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result, err := dbExec(ctx, "INSERT INTO resume_versions (content_hash, pdf, note) VALUES (?, ?, ?)", hash, pdfBytes, note)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, _ := result.LastInsertId()
+	return id, pdfBytes, nil
+}
+
+// Get the current resume PDF, generating a new version if content changed
+func currentResumePDF(ctx context.Context) ([]byte, error) {
+	resume, err := buildJSONResume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := resumeContentHash(resume)
+	if err != nil {
+		return nil, err
+	}
+
+	resumeGenerateMu.Lock()
+	defer resumeGenerateMu.Unlock()
+
+	var pdfBytes []byte
+	err = dbQueryRow(ctx, `
+		SELECT pdf FROM resume_versions
+		WHERE content_hash = ?
+		ORDER BY id DESC LIMIT 1
+	`, hash).Scan(&pdfBytes)
+	if err == nil {
+		return pdfBytes, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	id, pdfBytes, err := generateResumeVersion(ctx, resume, hash, "Content changed")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Generated resume PDF version %d", id)
+	return pdfBytes, nil
+}
+
+// Send PDF bytes as a download
+func sendResumePDF(c *gin.Context, pdfBytes []byte, filename string) {
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
+
+// Get all stored versions, newest first
+func getResumeVersions(ctx context.Context) ([]ResumeVersion, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, content_hash, length(pdf), COALESCE(note, ''), created_at
+		FROM resume_versions
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []ResumeVersion
+	for rows.Next() {
+		var v ResumeVersion
+		if err := rows.Scan(&v.ID, &v.ContentHash, &v.Size, &v.Note, &v.CreatedAt); err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Setup admin resume routes
+func setupResumeAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Version history page
+	adminGroup.GET("/resume", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		versions, err := getResumeVersions(ctx)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load resume versions",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "admin-resume.html", gin.H{
+			"versions": versions,
+		})
+	})
+
+	// Force a fresh PDF from the current content
+	adminGroup.POST("/resume/regenerate", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		resume, err := buildJSONResume(ctx)
+		if err == nil {
+			var hash string
+			if hash, err = resumeContentHash(resume); err == nil {
+				resumeGenerateMu.Lock()
+				_, _, err = generateResumeVersion(ctx, resume, hash, "Regenerated by admin")
+				resumeGenerateMu.Unlock()
+			}
+		}
+		if err != nil {
+			log.Printf("Error regenerating resume: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to regenerate resume PDF",
+			})
+			return
+		}
+
+		log.Printf("Resume PDF regenerated by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/resume")
+	})
+
+	// Download a specific version
+	adminGroup.GET("/resume/versions/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var pdfBytes []byte
+		err := dbQueryRow(ctx, "SELECT pdf FROM resume_versions WHERE id = ?", c.Param("id")).Scan(&pdfBytes)
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Resume version not found",
+			})
+			return
+		}
+
+		sendResumePDF(c, pdfBytes, fmt.Sprintf("resume-v%s.pdf", c.Param("id")))
+	})
+}
@@ -0,0 +1,371 @@
+// revisions.go - Content revision history
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Editable content stored as one row of a table
+type contentKind struct {
+	Name   string // used in URLs and the revisions table
+	Label  string
+	Table  string
+	Key    string // primary key column
+	Title  string // column shown in lists
+	Fields []contentField
+}
+
+type contentField struct {
+	Column    string
+	Label     string
+	Multiline bool
+}
+
+// Snapshot of one content row, column -> stored value
+type contentSnapshot map[string]string
+
+// Stored revision
+type Revision struct {
+	ID        int
+	Kind      string
+	EntityID  string
+	Snapshot  contentSnapshot
+	IPHash    string
+	CreatedAt time.Time
+}
+
+// One line of a field diff
+type DiffLine struct {
+	Op   string // "=", "+" or "-"
+	Text string
+}
+
+// Changed field between two revisions
+type FieldDiff struct {
+	Label string
+	Lines []DiffLine
+}
+
+// Content kinds with revision history; table and column names here are
+// interpolated into SQL, so they must never come from user input
+var contentKinds = map[string]contentKind{
+	"post": {Name: "post", Label: "Post", Table: "posts", Key: "id", Title: "title", Fields: []contentField{
+		{Column: "title", Label: "Title"},
+		{Column: "slug", Label: "Slug"},
+		{Column: "summary", Label: "Summary"},
+		{Column: "body", Label: "Body (Markdown)", Multiline: true},
+		{Column: "status", Label: "Status"},
+		{Column: "meta_description", Label: "Meta description"},
+		{Column: "canonical_url", Label: "Canonical URL"},
+	}},
+	"project": {Name: "project", Label: "Project", Table: "projects", Key: "id", Title: "title", Fields: []contentField{
+		{Column: "title", Label: "Title"},
+		{Column: "slug", Label: "Slug"},
+		{Column: "summary", Label: "Summary", Multiline: true},
+		{Column: "image_path", Label: "Image path"},
+		{Column: "url", Label: "URL"},
+		{Column: "tech", Label: "Tech (comma separated)"},
+		{Column: "launched_on", Label: "Launch date (e.g. Aug 2023, shown on the timeline)"},
+		{Column: "sort_order", Label: "Sort order"},
+	}},
+	"experience": {Name: "experience", Label: "Experience", Table: "experiences", Key: "id", Title: "title", Fields: []contentField{
+		{Column: "title", Label: "Title"},
+		{Column: "organization", Label: "Organization"},
+		{Column: "start_date", Label: "Start date"},
+		{Column: "end_date", Label: "End date"},
+		{Column: "logo_path", Label: "Logo path"},
+		{Column: "url", Label: "URL"},
+		{Column: "bullets", Label: "Bullets (one per line)", Multiline: true},
+		{Column: "certification", Label: "Certification (1 for a certification, 0 otherwise)"},
+		{Column: "sort_order", Label: "Sort order"},
+	}},
+	"block": {Name: "block", Label: "Content block", Table: "content_blocks", Key: "key", Title: "key", Fields: []contentField{
+		{Column: "body", Label: "Body", Multiline: true},
+	}},
+}
+
+// Initialize revision storage
+func initRevisions() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS content_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		entity_id TEXT NOT NULL,
+		snapshot TEXT NOT NULL,
+		ip_hash TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create content_revisions table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_content_revisions_entity ON content_revisions (kind, entity_id)`)
+}
+
+// Read the current values of a content row
+func loadContentSnapshot(ctx context.Context, kind contentKind, id string) (contentSnapshot, error) {
+	columns := make([]string, len(kind.Fields))
+	values := make([]sql.NullString, len(kind.Fields))
+	dest := make([]interface{}, len(kind.Fields))
+	for i, f := range kind.Fields {
+		columns[i] = f.Column
+		dest[i] = &values[i]
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", strings.Join(columns, ", "), kind.Table, kind.Key)
+	if err := dbQueryRow(ctx, query, id).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	snapshot := contentSnapshot{}
+	for i, f := range kind.Fields {
+		snapshot[f.Column] = values[i].String
+	}
+	return snapshot, nil
+}
+
+// Write snapshot values back to a content row
+func applyContentSnapshot(ctx context.Context, kind contentKind, id string, snapshot contentSnapshot) error {
+	var sets []string
+	var args []interface{}
+	for _, f := range kind.Fields {
+		if value, ok := snapshot[f.Column]; ok {
+			sets = append(sets, f.Column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if len(sets) == 0 {
+		return fmt.Errorf("revision has no fields to restore")
+	}
+
+	args = append(args, id)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", kind.Table, strings.Join(sets, ", "), kind.Key)
+	result, err := dbExec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Record the current state of a content row, unless it matches the latest revision.
+// Call before an edit (to capture edits made elsewhere) and after it.
+func recordRevision(ctx context.Context, kindName, id, ipHash string) {
+	kind, ok := contentKinds[kindName]
+	if !ok {
+		return
+	}
+
+	snapshot, err := loadContentSnapshot(ctx, kind, id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error snapshotting %s %s: %v", kindName, id, err)
+		}
+		return
+	}
+	encoded, _ := json.Marshal(snapshot)
+
+	var latest string
+	dbQueryRow(ctx, `SELECT snapshot FROM content_revisions WHERE kind = ? AND entity_id = ? ORDER BY id DESC LIMIT 1`,
+		kindName, id).Scan(&latest)
+	if latest == string(encoded) {
+		return
+	}
+
+	_, err = dbExec(ctx, "INSERT INTO content_revisions (kind, entity_id, snapshot, ip_hash) VALUES (?, ?, ?, ?)",
+		kindName, id, string(encoded), ipHash)
+	if err != nil {
+		log.Printf("Error recording revision of %s %s: %v", kindName, id, err)
+	}
+}
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (Revision, error) {
+	var r Revision
+	var snapshot string
+	err := row.Scan(&r.ID, &r.Kind, &r.EntityID, &snapshot, &r.IPHash, &r.CreatedAt)
+	if err == nil {
+		err = json.Unmarshal([]byte(snapshot), &r.Snapshot)
+	}
+	return r, err
+}
+
+const revisionColumns = `id, kind, entity_id, snapshot, COALESCE(ip_hash, ''), created_at`
+
+// Get a content row's revisions, newest first
+func getRevisions(ctx context.Context, kindName, id string) ([]Revision, error) {
+	rows, err := dbQuery(ctx, `SELECT `+revisionColumns+` FROM content_revisions
+		WHERE kind = ? AND entity_id = ? ORDER BY id DESC LIMIT 100`, kindName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Line diff of two texts (longest common subsequence)
+func diffLines(before, after string) []DiffLine {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// lcs[i][j] = LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{Op: "=", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{Op: "+", Text: b[j]})
+	}
+	return lines
+}
+
+// Per-field diffs between two snapshots, changed fields only
+func diffSnapshots(kind contentKind, before, after contentSnapshot) []FieldDiff {
+	var diffs []FieldDiff
+	for _, f := range kind.Fields {
+		if before[f.Column] == after[f.Column] {
+			continue
+		}
+		diffs = append(diffs, FieldDiff{Label: f.Label, Lines: diffLines(before[f.Column], after[f.Column])})
+	}
+	return diffs
+}
+
+// Setup admin revision history routes
+func setupRevisionAdminRoutes(adminGroup *gin.RouterGroup) {
+	// History of one content row
+	adminGroup.GET("/revisions/:kind/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		kind, ok := contentKinds[c.Param("kind")]
+		if !ok {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Unknown content type",
+			})
+			return
+		}
+
+		revisions, err := getRevisions(ctx, kind.Name, c.Param("id"))
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load revisions",
+			})
+			return
+		}
+
+		// Compare the chosen revision (default newest) with the one before it
+		var selected *Revision
+		var diffs []FieldDiff
+		for i := range revisions {
+			if c.Query("rev") == "" || fmt.Sprint(revisions[i].ID) == c.Query("rev") {
+				selected = &revisions[i]
+				previous := contentSnapshot{}
+				if i+1 < len(revisions) {
+					previous = revisions[i+1].Snapshot
+				}
+				diffs = diffSnapshots(kind, previous, selected.Snapshot)
+				break
+			}
+		}
+
+		title := c.Param("id")
+		if current, err := loadContentSnapshot(ctx, kind, c.Param("id")); err == nil && current[kind.Title] != "" {
+			title = current[kind.Title]
+		}
+
+		c.HTML(http.StatusOK, "admin-revisions.html", gin.H{
+			"kind":      kind,
+			"entityID":  c.Param("id"),
+			"title":     title,
+			"revisions": revisions,
+			"selected":  selected,
+			"diffs":     diffs,
+		})
+	})
+
+	// Restore a revision
+	adminGroup.POST("/revisions/:kind/:id/rollback/:rev", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		kind, ok := contentKinds[c.Param("kind")]
+		if !ok {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Unknown content type",
+			})
+			return
+		}
+		id := c.Param("id")
+
+		revision, err := scanRevision(dbQueryRow(ctx, `SELECT `+revisionColumns+` FROM content_revisions
+			WHERE id = ? AND kind = ? AND entity_id = ?`, c.Param("rev"), kind.Name, id))
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Revision not found",
+			})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		recordRevision(ctx, kind.Name, id, ipHash)
+		if err := applyContentSnapshot(ctx, kind, id, revision.Snapshot); err != nil {
+			log.Printf("Error rolling back %s %s: %v", kind.Name, id, err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to restore revision (is the slug now taken by something else?)",
+			})
+			return
+		}
+		recordRevision(ctx, kind.Name, id, ipHash)
+
+		log.Printf("%s %s rolled back to revision %d by admin from %s", kind.Label, id, revision.ID, ipHash)
+		c.Redirect(http.StatusSeeOther, "/admin/revisions/"+kind.Name+"/"+id)
+	})
+}
@@ -0,0 +1,260 @@
+// rotation.go - Rotation of salts, session secrets and token lifetimes
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys are kept in site_settings, so hashes and sessions survive restarts
+// and are replaced on a schedule instead
+const (
+	ipSaltSetting                = "ip_hash_salt"
+	ipSaltDaySetting             = "ip_hash_salt_day" // UTC day the salt was made for
+	sessionSecretSetting         = "session_secret"
+	previousSessionSecretSetting = "session_secret_previous"
+	sessionSecretRotatedSetting  = "session_secret_rotated_at"
+	sessionRotationDaysSetting   = "session_secret_rotation_days"
+	apiTokenLifetimeSetting      = "api_token_lifetime_days"
+)
+
+const (
+	defaultSessionRotationDays  = 30
+	defaultAPITokenLifetimeDays = 90
+	maxPolicyDays               = 365
+	// Sessions signed with the previous secret keep working this long after
+	// a rotation: the longest an admin session lasts, so none is cut short
+	sessionSecretOverlap = adminSessionLifetime // from admin.go
+	keyRotationEvery     = 10 * time.Minute
+)
+
+// In-memory copies of the current keys; adminToken and hashingSalt (from
+// admin.go) are only read through the functions below
+var (
+	keysMu             sync.RWMutex
+	previousAdminToken string // until the overlap ends
+)
+
+// Load the persisted keys, renewing any that are due, and keep them rotated
+func initKeyRotation() {
+	if err := rotateKeys(context.Background(), time.Now()); err != nil {
+		log.Fatal("Failed to load rotating keys:", err)
+	}
+	startWorker("key-rotation", keyRotationWorker) // from safego.go
+}
+
+func keyRotationWorker() {
+	ticker := time.NewTicker(keyRotationEvery)
+	defer ticker.Stop()
+
+	for {
+		err := rotateKeys(context.Background(), time.Now())
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeSessionRevocations(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("key-rotation", keyRotationEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Renew whichever keys are due and load them into memory. The IP-hash salt
+// changes at midnight UTC, in step with daily unique visitor counts, so a
+// visitor can't be followed from one day to the next. The session secret
+// changes every few days as configured.
+func rotateKeys(ctx context.Context, now time.Time) error {
+	day := now.UTC().Format("2006-01-02")
+	if getSetting(ipSaltSetting, "") == "" || getSetting(ipSaltDaySetting, "") != day { // from settings.go
+		if err := setSetting(ctx, ipSaltSetting, generateAdminToken()); err != nil {
+			return err
+		}
+		if err := setSetting(ctx, ipSaltDaySetting, day); err != nil {
+			return err
+		}
+		log.Printf("Key rotation: new IP hash salt for %s", day)
+	}
+
+	rotatedAt, _ := time.Parse(time.RFC3339, getSetting(sessionSecretRotatedSetting, ""))
+	days := policyDays(sessionRotationDaysSetting, defaultSessionRotationDays)
+	switch {
+	case getSetting(sessionSecretSetting, "") == "":
+		if err := rotateSessionSecret(ctx, now, false); err != nil {
+			return err
+		}
+	case days > 0 && now.Sub(rotatedAt) >= time.Duration(days)*24*time.Hour:
+		if err := rotateSessionSecret(ctx, now, true); err != nil {
+			return err
+		}
+		log.Printf("Key rotation: session secret renewed after %d days", days)
+	case getSetting(previousSessionSecretSetting, "") != "" && now.Sub(rotatedAt) >= sessionSecretOverlap:
+		if err := setSetting(ctx, previousSessionSecretSetting, ""); err != nil {
+			return err
+		}
+	}
+
+	loadKeys()
+	return nil
+}
+
+// Replace the session secret. With overlap the old one keeps signed-in
+// admins signed in until their sessions end; without, everyone is signed out.
+func rotateSessionSecret(ctx context.Context, now time.Time, overlap bool) error {
+	previous := ""
+	if overlap {
+		previous = getSetting(sessionSecretSetting, "")
+	}
+	if err := setSetting(ctx, previousSessionSecretSetting, previous); err != nil {
+		return err
+	}
+	if err := setSetting(ctx, sessionSecretSetting, generateAdminToken()); err != nil {
+		return err
+	}
+	if err := setSetting(ctx, sessionSecretRotatedSetting, now.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	loadKeys()
+	return nil
+}
+
+func loadKeys() {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	hashingSalt = getSetting(ipSaltSetting, "")
+	adminToken = getSetting(sessionSecretSetting, "")
+	previousAdminToken = getSetting(previousSessionSecretSetting, "")
+}
+
+// Salt for hashIP (from admin.go)
+func currentIPSalt() string {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return hashingSalt
+}
+
+// Secret new admin sessions and previews are signed with
+func currentAdminToken() string {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return adminToken
+}
+
+// Secrets sessions are still accepted with: the current one, then the
+// previous one during the overlap after a rotation
+func acceptedAdminTokens() []string {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if previousAdminToken == "" {
+		return []string{adminToken}
+	}
+	return []string{adminToken, previousAdminToken}
+}
+
+// Days a policy setting is set to; 0 turns the policy off
+func policyDays(key string, fallback int) int {
+	days, err := strconv.Atoi(getSetting(key, strconv.Itoa(fallback)))
+	if err != nil || days < 0 {
+		return fallback
+	}
+	return days
+}
+
+// Forget revocations of sessions that have ended by themselves
+func purgeSessionRevocations(ctx context.Context) error {
+	_, err := dbExec(ctx, "DELETE FROM admin_session_revocations WHERE revoked_at < ?", time.Now().Add(-adminSessionLifetime))
+	if err != nil {
+		log.Printf("Error purging session revocations: %v", err)
+	}
+	return err
+}
+
+// Rotation state for the settings page
+type KeyRotationStatus struct {
+	SaltDay           string
+	SessionRotatedAt  time.Time
+	SessionNextAt     time.Time // zero when automatic rotation is off
+	SessionDays       int
+	InOverlap         bool
+	TokenLifetimeDays int
+}
+
+func keyRotationStatus() KeyRotationStatus {
+	s := KeyRotationStatus{
+		SaltDay:           getSetting(ipSaltDaySetting, ""),
+		SessionDays:       policyDays(sessionRotationDaysSetting, defaultSessionRotationDays),
+		InOverlap:         getSetting(previousSessionSecretSetting, "") != "",
+		TokenLifetimeDays: policyDays(apiTokenLifetimeSetting, defaultAPITokenLifetimeDays),
+	}
+	s.SessionRotatedAt, _ = time.Parse(time.RFC3339, getSetting(sessionSecretRotatedSetting, ""))
+	if s.SessionDays > 0 {
+		s.SessionNextAt = s.SessionRotatedAt.AddDate(0, 0, s.SessionDays)
+	}
+	return s
+}
+
+// Setup admin routes for the rotation policy. The keys are shared by every
+// site, so only the primary admin may change them.
+func setupKeyRotationAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/settings/rotation", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		sessionDays, err1 := strconv.Atoi(c.PostForm("session_days"))
+		tokenDays, err2 := strconv.Atoi(c.PostForm("token_days"))
+		if err1 != nil || err2 != nil || sessionDays < 0 || tokenDays < 0 || sessionDays > maxPolicyDays || tokenDays > maxPolicyDays {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Rotation periods must be between 0 and 365 days",
+			})
+			return
+		}
+
+		err := setSetting(ctx, sessionRotationDaysSetting, strconv.Itoa(sessionDays))
+		if err == nil {
+			err = setSetting(ctx, apiTokenLifetimeSetting, strconv.Itoa(tokenDays))
+		}
+		if err != nil {
+			log.Printf("Error saving rotation policy: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save rotation policy",
+			})
+			return
+		}
+
+		log.Printf("Rotation policy set to %d/%d days by admin from %s", sessionDays, tokenDays, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Rotation policy saved"))
+	})
+
+	adminGroup.POST("/settings/rotation/session-secret", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		signOut := c.PostForm("sign_out") == "on"
+		if err := rotateSessionSecret(ctx, time.Now(), !signOut); err != nil {
+			log.Printf("Error rotating session secret: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to rotate session secret",
+			})
+			return
+		}
+
+		ipHash := hashIP(c.ClientIP())
+		detail := "session secret rotated"
+		if signOut {
+			detail += ", all sessions ended"
+		}
+		recordSecurityEvent(ctx, eventSessionRevoked, ipHash, detail) // from security.go
+		log.Printf("Session secret rotated by admin from %s", ipHash)
+		if signOut {
+			c.Redirect(http.StatusSeeOther, "/admin/login")
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Session secret rotated; current sessions stay signed in until they end"))
+	})
+}
@@ -0,0 +1,189 @@
+// routepolicy.go - Per-path middleware policies
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How the shared middleware treats a path. Prefix rules match every path
+// below Path, and a ":name" segment matches any one segment, as in the
+// router. The zero value is an ordinary page: tracked, with banners, the
+// default body limit, no CORS, no rate limit, and cross-site writes refused.
+type routePolicy struct {
+	Path   string
+	Prefix bool
+
+	Untracked bool // not a page view (from admin.go)
+	NoBanners bool // announcement banners aren't added (from banners.go)
+	CORS      bool // cross-origin requests answered (from cors.go)
+
+	BodyLimit     int64  // largest request body; 0 for defaultBodyLimit (from bodylimit.go)
+	ErrorTemplate string // htmx partial for the middleware's errors, given "error"
+
+	CacheControl    string // default Cache-Control; handlers may set their own
+	RateLimit       int    // requests per minute per visitor, 0 for none
+	ReadOnlyAllowed bool   // writes allowed while the database is read-only (from degraded.go)
+
+	// Writes from other sites allowed: webhooks, server-to-server posts and
+	// the API, which authenticates by token rather than cookie
+	CSRFExempt bool
+}
+
+// Checked in order, first match wins, so a specific path needs every
+// setting of the prefix it sits under
+var routePolicies = []routePolicy{
+	// Public forms
+	{Path: "/shorten-url", BodyLimit: 4 << 10, ErrorTemplate: "url-shortener-error.html", RateLimit: 20},
+	{Path: "/contact", BodyLimit: 64 << 10, ErrorTemplate: "contact-error.html"},
+	{Path: "/hire", BodyLimit: 64 << 10},
+	{Path: "/secret", BodyLimit: secretMaxCiphertext + 1<<10}, // from secrets.go
+	{Path: "/webmention", BodyLimit: 8 << 10, CSRFExempt: true},
+	{Path: "/blog/", Prefix: true, BodyLimit: 2 << 10}, // reactions
+	{Path: "/polls/", Prefix: true, BodyLimit: 2 << 10},
+	{Path: "/testimonials/submit/", Prefix: true, Untracked: true, NoBanners: true, BodyLimit: 16 << 10}, // token link
+	{Path: "/testimonials/", Prefix: true, BodyLimit: 16 << 10},
+
+	// Links carrying a token, and the client area; kept out of visitors
+	// and analytics exports so the token isn't stored or sent anywhere
+	{Path: "/share/", Prefix: true, Untracked: true},
+	{Path: "/secret/", Prefix: true, Untracked: true, NoBanners: true},
+	{Path: "/download/", Prefix: true, Untracked: true, NoBanners: true},    // from exportlinks.go
+	{Path: "/login-alert/", Prefix: true, Untracked: true, NoBanners: true}, // from loginalerts.go
+	{Path: "/invoices/", Prefix: true, Untracked: true},
+	{Path: "/files/", Prefix: true, Untracked: true, BodyLimit: 4 << 10},
+	{Path: "/client", Untracked: true},
+	{Path: "/client/", Prefix: true, Untracked: true, BodyLimit: 4 << 10},
+
+	// Redirects and images, counted separately or not pages at all
+	{Path: "/s/:code/qr", Untracked: true, NoBanners: true},     // from qrcode.go
+	{Path: "/s/:code/og.png", Untracked: true, NoBanners: true}, // from ogimage.go
+	{Path: "/s/", Prefix: true, NoBanners: true},
+	{Path: "/out", Untracked: true}, // from outbound.go
+	{Path: "/img", Untracked: true}, // from imageproxy.go
+	{Path: "/favicon", Prefix: true, Untracked: true},
+	{Path: "/icons/", Prefix: true, Untracked: true, NoBanners: true}, // from icons.go
+	{Path: "/site.webmanifest", Untracked: true, NoBanners: true},
+	{Path: "/privacy", Prefix: true, Untracked: true},
+	{Path: "/static/", Prefix: true, Untracked: true, NoBanners: true, CacheControl: "public, max-age=3600"},
+	{Path: "/images/", Prefix: true, Untracked: true, NoBanners: true, CacheControl: "public, max-age=3600"},
+	{Path: "/themes/", Prefix: true, Untracked: true, NoBanners: true, CacheControl: "public, max-age=3600"}, // from themes.go
+
+	// Machine endpoints
+	{Path: "/ap/inbox", NoBanners: true, BodyLimit: 1 << 20, CSRFExempt: true},
+	{Path: "/ap/", Prefix: true, NoBanners: true},
+	{Path: "/.well-known/", Prefix: true, NoBanners: true},
+	{Path: "/stripe/webhook", BodyLimit: 256 << 10, CSRFExempt: true},
+	{Path: "/inbound/email", BodyLimit: inboundMaxBytes, CSRFExempt: true}, // from inbound.go
+
+	// API; the beacons aren't page views
+	{Path: "/api/event", Untracked: true, NoBanners: true, CORS: true, BodyLimit: 2 << 10, CSRFExempt: true},
+	{Path: "/api/engagement", Untracked: true, NoBanners: true, CORS: true, BodyLimit: 2 << 10, CSRFExempt: true},
+	{Path: "/api/v1/quick", NoBanners: true, CORS: true, BodyLimit: 8 << 10, CSRFExempt: true},
+	{Path: "/api/v1/links", NoBanners: true, CORS: true, BodyLimit: 8 << 10, CSRFExempt: true},
+	{Path: "/api/v1/shorten", NoBanners: true, CORS: true, BodyLimit: 8 << 10, CSRFExempt: true},
+	{Path: "/api/v1/graphql", NoBanners: true, CORS: true, BodyLimit: 64 << 10, CSRFExempt: true},
+	{Path: "/api/", Prefix: true, NoBanners: true, CORS: true, CSRFExempt: true},
+
+	// Admin; uploads get room for the file (from fileshares.go, clientportal.go, talks.go)
+	{Path: "/admin/login", Untracked: true, NoBanners: true, BodyLimit: 4 << 10, CacheControl: "no-store", ReadOnlyAllowed: true},
+	{Path: "/admin/logout", Untracked: true, NoBanners: true, CacheControl: "no-store", ReadOnlyAllowed: true},
+	{Path: "/admin/files", Untracked: true, NoBanners: true, BodyLimit: fileShareMaxBytes + 64<<10, CacheControl: "no-store"},
+	{Path: "/admin/clients", Prefix: true, Untracked: true, NoBanners: true, BodyLimit: clientFileMaxBytes + 64<<10, CacheControl: "no-store"},
+	{Path: "/admin/talks", Prefix: true, Untracked: true, NoBanners: true, BodyLimit: talkSlidesMaxBytes + 64<<10, CacheControl: "no-store"},
+	{Path: "/admin/", Prefix: true, Untracked: true, NoBanners: true, BodyLimit: 8 << 20, CacheControl: "no-store"}, // post bodies, content edits
+	{Path: "/admin", NoBanners: true},
+}
+
+// Policy for a path; an ordinary page's when no rule matches
+func routePolicyFor(path string) routePolicy {
+	for _, policy := range routePolicies {
+		if policy.matches(path) {
+			return policy
+		}
+	}
+	return routePolicy{Path: path}
+}
+
+func (p routePolicy) matches(path string) bool {
+	if !strings.Contains(p.Path, "/:") {
+		return path == p.Path || (p.Prefix && strings.HasPrefix(path, p.Path))
+	}
+	want, got := strings.Split(p.Path, "/"), strings.Split(path, "/")
+	if len(got) < len(want) || (len(got) > len(want) && !p.Prefix) {
+		return false
+	}
+	for i, segment := range want {
+		if strings.HasPrefix(segment, ":") {
+			if got[i] == "" {
+				return false
+			}
+		} else if segment != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Rate limits count per site, rule and visitor
+type routeRateKey struct {
+	SiteID int
+	Path   string
+	IPHash string
+}
+
+var routeRateLimiter = newRateLimiter[routeRateKey]() // from api.go
+
+// Whether a request writes on behalf of another site. Browsers send
+// Sec-Fetch-Site; older ones only Origin. Requests with neither come from
+// scripts and servers, which can't ride on a visitor's cookies.
+func crossSiteWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// Apply a path's CSRF check, cache default and rate limit
+func routePolicyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := routePolicyFor(c.Request.URL.Path)
+		if !policy.CSRFExempt && crossSiteWrite(c.Request) {
+			c.String(http.StatusForbidden, "Cross-site request refused")
+			c.Abort()
+			return
+		}
+		if policy.CacheControl != "" {
+			c.Header("Cache-Control", policy.CacheControl)
+		}
+
+		if policy.RateLimit > 0 {
+			key := routeRateKey{siteFromContext(c.Request.Context()).ID, policy.Path, hashIP(c.ClientIP())}
+			if !routeRateLimiter.Allow(key, policy.RateLimit) {
+				const message = "Too many requests. Please wait a minute and try again."
+				c.Header("Retry-After", "60")
+				if policy.ErrorTemplate != "" && c.GetHeader("HX-Request") == "true" {
+					// htmx fragments only swap in on 200, like the forms' other errors
+					c.HTML(http.StatusOK, policy.ErrorTemplate, gin.H{"error": message})
+				} else {
+					c.String(http.StatusTooManyRequests, message)
+				}
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,118 @@
+// routepolicy_test.go - Route policy lookups
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Paths whose URL carries a token must never be stored as a page view
+func TestTokenPathsUntracked(t *testing.T) {
+	paths := []string{
+		"/share/1.2.abc",
+		"/secret/abc123",
+		"/download/1.2.abc",
+		"/login-alert/1.2.abc",
+		"/testimonials/submit/1.2.abc",
+		"/invoices/1.2.abc",
+		"/files/1.2.abc",
+		"/client/auth/1.2.abc",
+	}
+	for _, path := range paths {
+		if !routePolicyFor(path).Untracked {
+			t.Errorf("%s is tracked", path)
+		}
+	}
+}
+
+// Files pages load aren't page views of their own
+func TestAssetPathsUntracked(t *testing.T) {
+	paths := []string{
+		"/static/css/style.css",
+		"/themes/paper/style.css",
+		"/favicon.ico",
+		"/icons/apple-touch-icon.png",
+		"/site.webmanifest",
+		"/s/abc123/qr",
+		"/s/abc123/og.png",
+	}
+	for _, path := range paths {
+		if !routePolicyFor(path).Untracked {
+			t.Errorf("%s is tracked", path)
+		}
+	}
+}
+
+func TestRoutePolicyForSpecificBeforePrefix(t *testing.T) {
+	if limit := routePolicyFor("/testimonials/submit/1.2.abc").BodyLimit; limit != 16<<10 {
+		t.Errorf("testimonial form body limit = %d, want %d", limit, 16<<10)
+	}
+	if routePolicyFor("/testimonials/").Untracked {
+		t.Error("testimonials page is untracked")
+	}
+	if routePolicyFor("/secret").Untracked {
+		t.Error("secret form is untracked")
+	}
+}
+
+func TestRoutePolicyForParams(t *testing.T) {
+	tests := []struct {
+		path      string
+		untracked bool
+	}{
+		{"/s/abc123/qr", true},
+		{"/s/abc123/og.png", true},
+		{"/s/abc123", false}, // the redirect is counted
+		{"/s//qr", false},
+		{"/s/abc123/qr/extra", false},
+		{"/s/qr", false},
+	}
+	for _, tt := range tests {
+		policy := routePolicyFor(tt.path)
+		if policy.Untracked != tt.untracked || !policy.NoBanners {
+			t.Errorf("%s: untracked %v, banners %v; want untracked %v, no banners", tt.path, policy.Untracked, !policy.NoBanners, tt.untracked)
+		}
+	}
+}
+
+func TestCrossSiteWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"same origin", "POST", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "https://example.com"}, false},
+		{"typed in", "POST", map[string]string{"Sec-Fetch-Site": "none"}, false},
+		{"cross-site", "POST", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, true},
+		{"sibling subdomain", "POST", map[string]string{"Sec-Fetch-Site": "same-site"}, true},
+		{"cross-site read", "GET", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"origin only, same host", "POST", map[string]string{"Origin": "https://example.com"}, false},
+		{"origin only, other host", "POST", map[string]string{"Origin": "https://evil.example"}, true},
+		{"opaque origin", "POST", map[string]string{"Origin": "null"}, true},
+		{"no browser headers", "POST", nil, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "https://example.com/contact", nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		if got := crossSiteWrite(req); got != tt.want {
+			t.Errorf("%s: crossSiteWrite = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Endpoints other sites and servers post to, which don't use cookies
+func TestCSRFExemptions(t *testing.T) {
+	for _, path := range []string{"/ap/inbox", "/webmention", "/stripe/webhook", "/inbound/email", "/api/event", "/api/v1/shorten", "/api/v1/bookmarks"} {
+		if !routePolicyFor(path).CSRFExempt {
+			t.Errorf("%s refuses cross-site posts", path)
+		}
+	}
+	for _, path := range []string{"/contact", "/shorten-url", "/admin/login", "/admin/settings", "/client/login", "/secret/abc123", "/setup"} {
+		if routePolicyFor(path).CSRFExempt {
+			t.Errorf("%s accepts cross-site posts", path)
+		}
+	}
+}
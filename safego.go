@@ -0,0 +1,103 @@
+// safego.go - Panic-safe background goroutines, drained on shutdown
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Longest graceful shutdown waits for requests and background work
+const shutdownTimeout = 15 * time.Second
+
+var (
+	backgroundTasks sync.WaitGroup
+
+	// Closed when shutdown starts; long-running workers return on it
+	shuttingDown     = make(chan struct{})
+	shuttingDownOnce sync.Once
+)
+
+var errTaskPanicked = errors.New("task panicked")
+
+// Run fn in the background, logging a panic instead of crashing the server
+func safeGo(name string, fn func()) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		runRecovered(name, fn)
+	}()
+}
+
+// Set for CLI commands, which run once and exit (from cli.go)
+var workersDisabled bool
+
+// Start a long-running worker or startup job from an init function; CLI
+// commands don't start them
+func startWorker(name string, fn func()) {
+	if workersDisabled {
+		return
+	}
+	safeGo(name, fn)
+}
+
+// Run fn in the background, retrying with backoff while it returns an error
+// or panics, up to attempts tries; retries stop once shutdown starts
+func safeGoRetry(name string, attempts int, fn func() error) {
+	safeGo(name, func() {
+		delay := time.Second
+		for attempt := 1; ; attempt++ {
+			var err error
+			if runRecovered(name, func() { err = fn() }) {
+				err = errTaskPanicked
+			}
+			if err == nil {
+				return
+			}
+			if attempt >= attempts {
+				log.Printf("Background task %s failed after %d attempts: %v", name, attempt, err)
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-shuttingDown:
+				log.Printf("Background task %s abandoned at shutdown: %v", name, err)
+				return
+			}
+			delay *= 2
+		}
+	})
+}
+
+// Call fn, recovering and logging a panic; reports whether it panicked
+func runRecovered(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Background task %s panicked: %v\n%s", name, r, debug.Stack())
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
+
+// Tell workers to stop and wait for background work, until ctx expires
+func stopBackground(ctx context.Context) {
+	shuttingDownOnce.Do(func() { close(shuttingDown) })
+
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Background tasks finished")
+	case <-ctx.Done():
+		log.Println("Shutdown timed out waiting for background tasks")
+	}
+}
@@ -0,0 +1,399 @@
+// savedreports.go - Saved report widgets for the admin dashboard
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reportMaxDays   = 365
+	reportMaxRows   = 20 // groups shown by a widget, biggest first
+	reportNameLimit = 100
+)
+
+// A saved report: what to count, how to group and filter it, and how the
+// dashboard widget draws it. Widgets belong to the site's admin, in
+// sort_order, half or full width.
+type SavedReport struct {
+	ID        int
+	Name      string
+	Metric    string
+	GroupBy   string
+	Filter    string // matched against the metric's filter column; empty for all
+	Days      int
+	Chart     string
+	SortOrder int
+	Wide      bool
+}
+
+// Something a report can count, with the groupings it offers. Source is a
+// table expression with a day column, the grouping columns and n, the
+// count each row contributes; only these fixed fragments ever reach SQL.
+type ReportMetric struct {
+	Name        string
+	Label       string
+	FilterLabel string
+	source      string
+	since       string // condition selecting rows from a day on, one placeholder
+	filter      string // condition on the source with one placeholder
+	groupings   []ReportGrouping
+}
+
+type ReportGrouping struct {
+	Name   string
+	Label  string
+	column string
+}
+
+var dayGrouping = ReportGrouping{"day", "Day", "day"}
+
+var reportMetrics = []ReportMetric{
+	{
+		Name: "views", Label: "Page views", FilterLabel: "Path starts with",
+		source: `(SELECT substr(timestamp, 1, 10) AS day, timestamp, path, COALESCE(country, '') AS country, 1 AS n FROM visitors)`,
+		since:  `timestamp >= ?`, // so the timestamp index is used
+		filter: `path LIKE ? || '%'`,
+		groupings: []ReportGrouping{
+			dayGrouping, {"path", "Page", "path"}, {"country", "Country", "country"},
+		},
+	},
+	{
+		Name: "clicks", Label: "Short link clicks", FilterLabel: "Short code",
+		// Daily aggregates plus the events not compacted yet (from clickevents.go)
+		source: `(SELECT day, short_code, country, referrer_domain, device, clicks AS n FROM click_daily
+			UNION ALL
+			SELECT substr(clicked_at, 1, 10), short_code, country, referrer_domain, device, 1 FROM click_events
+			WHERE clicked_at >= (SELECT COALESCE(date(MAX(day), '+1 day'), '') FROM click_daily))`,
+		since:  `day >= ?`,
+		filter: `short_code = ?`,
+		groupings: []ReportGrouping{
+			dayGrouping, {"link", "Link", "short_code"}, {"country", "Country", "country"},
+			{"referrer", "Referrer", "referrer_domain"}, {"device", "Device", "device"},
+		},
+	},
+	{
+		Name: "events", Label: "Custom events", FilterLabel: "Event name",
+		source: `(SELECT day, name, label, path, count AS n FROM events)`, // from events.go
+		since:  `day >= ?`,
+		filter: `name = ?`,
+		groupings: []ReportGrouping{
+			dayGrouping, {"event", "Event", "name"}, {"label", "Label", "label"}, {"path", "Page", "path"},
+		},
+	},
+}
+
+// How a widget draws its result
+var reportCharts = []struct{ Name, Label string }{
+	{"bars", "Bar chart"},
+	{"table", "Table"},
+	{"number", "Total only"},
+}
+
+func findReportMetric(name string) (ReportMetric, bool) {
+	for _, m := range reportMetrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return ReportMetric{}, false
+}
+
+func (m ReportMetric) grouping(name string) (ReportGrouping, bool) {
+	for _, g := range m.groupings {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return ReportGrouping{}, false
+}
+
+// Groupings offered for each metric, for the report form
+func (m ReportMetric) Groupings() []ReportGrouping {
+	return m.groupings
+}
+
+// Initialize saved report storage
+func initSavedReports() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS saved_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		group_by TEXT NOT NULL,
+		filter TEXT NOT NULL DEFAULT '',
+		days INTEGER NOT NULL DEFAULT 30,
+		chart TEXT NOT NULL DEFAULT 'bars',
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		wide INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create saved_reports table:", err)
+	}
+}
+
+func getSavedReports(ctx context.Context) ([]SavedReport, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, name, metric, group_by, filter, days, chart, sort_order, wide
+		FROM saved_reports ORDER BY sort_order, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []SavedReport
+	for rows.Next() {
+		var r SavedReport
+		if err := rows.Scan(&r.ID, &r.Name, &r.Metric, &r.GroupBy, &r.Filter, &r.Days, &r.Chart, &r.SortOrder, &r.Wide); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// One group of a report and its count
+type ReportRow struct {
+	Key   string
+	Value int64
+}
+
+// A report as run, with its rows ready to draw
+type SavedReportResult struct {
+	SavedReport
+	MetricLabel  string
+	GroupLabel   string
+	Total        int64
+	Rows         []ReportRow
+	Error        string
+	maxRowsValue int64
+}
+
+// Width of a row's bar, as a percentage of the largest
+func (r SavedReportResult) BarWidth(value int64) int64 {
+	if r.maxRowsValue == 0 {
+		return 0
+	}
+	return value * 100 / r.maxRowsValue
+}
+
+// Run a saved report. By day, every day in the range is listed oldest
+// first; otherwise the largest groups come first.
+func runSavedReport(ctx context.Context, report SavedReport) SavedReportResult {
+	result := SavedReportResult{SavedReport: report}
+	metric, ok := findReportMetric(report.Metric)
+	if !ok {
+		result.Error = "unknown metric " + report.Metric
+		return result
+	}
+	grouping, ok := metric.grouping(report.GroupBy)
+	if !ok {
+		result.Error = "unknown grouping " + report.GroupBy
+		return result
+	}
+	result.MetricLabel, result.GroupLabel = metric.Label, grouping.Label
+
+	since := time.Now().UTC().AddDate(0, 0, -report.Days+1).Format("2006-01-02")
+	where := " WHERE " + metric.since
+	args := []any{since}
+	if report.Filter != "" {
+		where += " AND " + metric.filter
+		args = append(args, report.Filter)
+	}
+	query := "SELECT " + grouping.column + ", SUM(n) FROM " + metric.source + where + " GROUP BY 1"
+	if grouping != dayGrouping {
+		query += " ORDER BY 2 DESC, 1 LIMIT " + strconv.Itoa(reportMaxRows)
+	}
+
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error running report %d: %v", report.ID, err)
+		result.Error = "the report could not be run"
+		return result
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var row ReportRow
+		var key sql.NullString
+		if err := rows.Scan(&key, &row.Value); err != nil {
+			result.Error = "the report could not be run"
+			return result
+		}
+		row.Key = key.String
+		if row.Key == "" {
+			row.Key = "(none)"
+		}
+		counts[row.Key] += row.Value
+		result.Rows = append(result.Rows, row)
+	}
+
+	if grouping == dayGrouping {
+		result.Rows = nil
+		start, _ := time.Parse("2006-01-02", since)
+		for i := 0; i < report.Days; i++ {
+			day := start.AddDate(0, 0, i).Format("2006-01-02")
+			result.Rows = append(result.Rows, ReportRow{day, counts[day]})
+		}
+	}
+	for _, row := range result.Rows {
+		result.Total += row.Value
+		result.maxRowsValue = max(result.maxRowsValue, row.Value)
+	}
+	if grouping != dayGrouping {
+		// Only the largest groups are listed, so the total needs its own query
+		query = "SELECT COALESCE(SUM(n), 0) FROM " + metric.source + where
+		if err := dbQueryRow(ctx, query, args...).Scan(&result.Total); err != nil {
+			log.Printf("Error totalling report %d: %v", report.ID, err)
+		}
+	}
+	return result
+}
+
+// Every saved report, run, for the dashboard (from statscache.go, so
+// polling tabs share the work)
+func getDashboardReports(ctx context.Context) ([]SavedReportResult, error) {
+	return cachedDashboardQuery(ctx, "reports", func(ctx context.Context) ([]SavedReportResult, error) {
+		reports, err := getSavedReports(ctx)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]SavedReportResult, len(reports))
+		for i, r := range reports {
+			results[i] = runSavedReport(ctx, r)
+		}
+		return results, nil
+	})
+}
+
+// Read and check a report from the admin form
+func savedReportFromForm(c *gin.Context) (SavedReport, string) {
+	r := SavedReport{
+		Name:    strings.TrimSpace(c.PostForm("name")),
+		Metric:  c.PostForm("metric"),
+		GroupBy: c.PostForm("group_by"),
+		Filter:  strings.TrimSpace(c.PostForm("filter")),
+		Chart:   c.PostForm("chart"),
+		Wide:    c.PostForm("wide") == "on",
+	}
+	if r.Name == "" || len(r.Name) > reportNameLimit {
+		return r, "A report needs a name of up to 100 characters"
+	}
+	metric, ok := findReportMetric(r.Metric)
+	if !ok {
+		return r, "Choose what the report counts"
+	}
+	if _, ok := metric.grouping(r.GroupBy); !ok {
+		return r, metric.Label + " can't be grouped that way"
+	}
+	validChart := false
+	for _, chart := range reportCharts {
+		validChart = validChart || chart.Name == r.Chart
+	}
+	if !validChart {
+		return r, "Choose how the report is shown"
+	}
+	var err error
+	if r.Days, err = strconv.Atoi(c.PostForm("days")); err != nil || r.Days < 1 || r.Days > reportMaxDays {
+		return r, "The period must be between 1 and 365 days"
+	}
+	if r.SortOrder, err = strconv.Atoi(c.DefaultPostForm("sort_order", "0")); err != nil {
+		return r, "Position must be a number"
+	}
+	return r, ""
+}
+
+// Setup admin routes for saved reports
+func setupSavedReportAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/reports", func(c *gin.Context) {
+		reports, err := getSavedReports(c.Request.Context())
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load reports",
+			})
+			return
+		}
+		// The form edits the report picked from the list, or adds one
+		editing := SavedReport{Metric: "views", GroupBy: "day", Days: 30, Chart: "bars"}
+		for _, r := range reports {
+			if strconv.Itoa(r.ID) == c.Query("edit") {
+				editing = r
+			}
+		}
+		c.HTML(http.StatusOK, "admin-reports.html", gin.H{
+			"reports": reports,
+			"editing": editing,
+			"metrics": reportMetrics,
+			"charts":  reportCharts,
+			"message": c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/reports", func(c *gin.Context) {
+		r, problem := savedReportFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+		_, err := dbExec(c.Request.Context(), `
+			INSERT INTO saved_reports (name, metric, group_by, filter, days, chart, sort_order, wide)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, r.Name, r.Metric, r.GroupBy, r.Filter, r.Days, r.Chart, r.SortOrder, r.Wide)
+		if err != nil {
+			log.Printf("Error saving report: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save report",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/reports?message="+url.QueryEscape("Report added to the dashboard"))
+	})
+
+	adminGroup.POST("/reports/:id", func(c *gin.Context) {
+		r, problem := savedReportFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+		result, err := dbExec(c.Request.Context(), `
+			UPDATE saved_reports SET name = ?, metric = ?, group_by = ?, filter = ?, days = ?, chart = ?, sort_order = ?, wide = ?
+			WHERE id = ?
+		`, r.Name, r.Metric, r.GroupBy, r.Filter, r.Days, r.Chart, r.SortOrder, r.Wide, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating report: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save report",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Report not found"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/reports?message="+url.QueryEscape("Report saved"))
+	})
+
+	adminGroup.POST("/reports/:id/delete", func(c *gin.Context) {
+		if _, err := dbExec(c.Request.Context(), "DELETE FROM saved_reports WHERE id = ?", c.Param("id")); err != nil {
+			log.Printf("Error deleting report: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete report",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/reports?message="+url.QueryEscape("Report removed from the dashboard"))
+	})
+}
@@ -0,0 +1,191 @@
+// secrets.go - One-time secret sharing
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Secrets are AES-GCM encrypted in the browser with a key that stays in the
+// link's #fragment, which browsers never send. The ciphertext is the IV
+// followed by the sealed message, base64url encoded.
+const (
+	secretMaxCiphertext = 64 << 10 // encoded size, about 48 KB of text
+	secretMinCiphertext = 12 + 16  // IV and GCM tag around an empty message
+	secretRateLimit     = 10       // secrets created per client per minute
+	secretCleanupEvery  = time.Hour
+)
+
+// Lifetime choices, in hours
+var secretDurations = []int{1, 24, 168}
+
+var secretRateLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+// Initialize secret storage and the expiry cleanup
+func initSecrets() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS secrets (
+		code TEXT PRIMARY KEY,
+		ciphertext TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create secrets table:", err)
+	}
+
+	startWorker("secret-cleanup", secretCleanupWorker) // from safego.go
+}
+
+func secretCleanupWorker() {
+	ticker := time.NewTicker(secretCleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeExpiredSecrets(ctx); siteErr != nil {
+				err = siteErr
+			}
+		})
+		recordJobRun("secret-cleanup", secretCleanupEvery, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Delete secrets nobody opened in time
+func purgeExpiredSecrets(ctx context.Context) error {
+	result, err := dbExec(ctx, "DELETE FROM secrets WHERE expires_at <= ?", time.Now())
+	if err != nil {
+		log.Printf("Error purging expired secrets: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Secret cleanup: removed %d expired secrets", n)
+	}
+	return nil
+}
+
+// Whether a secret is still waiting to be read, without reading it
+func secretExists(ctx context.Context, code string) bool {
+	var exists int
+	err := dbQueryRow(ctx, "SELECT 1 FROM secrets WHERE code = ? AND expires_at > ?", code, time.Now()).Scan(&exists)
+	return err == nil
+}
+
+// Take a secret's ciphertext, deleting it in the same statement so two
+// readers can't both get it
+func takeSecret(ctx context.Context, code string) (string, error) {
+	var ciphertext string
+	err := dbQueryRow(ctx, "DELETE FROM secrets WHERE code = ? AND expires_at > ? RETURNING ciphertext",
+		code, time.Now()).Scan(&ciphertext)
+	return ciphertext, err
+}
+
+// Whether a submitted value has the shape of our ciphertext. The server
+// can't check it's really encrypted, only that it isn't obviously plain text.
+func validCiphertext(value string) bool {
+	if len(value) > secretMaxCiphertext {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	return err == nil && len(raw) >= secretMinCiphertext
+}
+
+// Setup the public secret sharing routes
+func setupSecretRoutes(r *gin.Engine) {
+	r.GET("/secret", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "secret.html", gin.H{
+			"title":     "Share a secret",
+			"durations": secretDurations,
+		})
+	})
+
+	r.POST("/secret", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if !secretRateLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, hashIP(c.ClientIP())}, secretRateLimit) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many secrets, try again in a minute"})
+			return
+		}
+
+		var req struct {
+			Ciphertext string `json:"ciphertext"`
+			Hours      int    `json:"hours"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || !validCiphertext(req.Ciphertext) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The secret is missing or too long"})
+			return
+		}
+		valid := false
+		for _, hours := range secretDurations {
+			valid = valid || hours == req.Hours
+		}
+		if !valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Choose one of the offered expiry times"})
+			return
+		}
+
+		code, err := generateShortCode() // from main.go
+		if err == nil {
+			expiresAt := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+			_, err = dbExec(ctx, "INSERT INTO secrets (code, ciphertext, expires_at) VALUES (?, ?, ?)", code, req.Ciphertext, expiresAt)
+		}
+		if err != nil {
+			log.Printf("Error saving secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Sorry, the secret couldn't be saved. Please try again."})
+			return
+		}
+
+		// The browser adds the #key; the server never sees it
+		c.JSON(http.StatusCreated, gin.H{
+			"url": strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/secret/" + code,
+		})
+	})
+
+	// Opening the link only offers to reveal, so link previews and
+	// scanners that follow it don't use up the secret
+	r.GET("/secret/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+
+		status := http.StatusOK
+		exists := secretExists(ctx, c.Param("code"))
+		if !exists {
+			status = http.StatusNotFound
+		}
+		c.HTML(status, "secret.html", gin.H{
+			"title":  "A secret for you",
+			"code":   c.Param("code"),
+			"exists": exists,
+		})
+	})
+
+	r.POST("/secret/:code", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		ciphertext, err := takeSecret(ctx, c.Param("code"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error reading secret: %v", err)
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "This secret has already been read or has expired"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ciphertext": ciphertext})
+	})
+}
@@ -0,0 +1,103 @@
+// securesettings.go - Settings encrypted at rest
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Values are stored as this prefix and base64 of the nonce followed by the
+// AES-GCM sealed value, so a database backup alone doesn't give the keys away
+const encryptedSettingPrefix = "enc:v1:"
+
+var errNoSettingsKey = errors.New("SETTINGS_ENCRYPTION_KEY is not set")
+
+// AES-256 key from SETTINGS_ENCRYPTION_KEY. Any passphrase works; it's
+// hashed to the key size. Changing it makes stored values unreadable.
+func settingsEncryptionKey() ([]byte, error) {
+	passphrase := os.Getenv("SETTINGS_ENCRYPTION_KEY")
+	if passphrase == "" {
+		return nil, errNoSettingsKey
+	}
+	key := sha256.Sum256([]byte(passphrase))
+	return key[:], nil
+}
+
+// Whether encrypted settings can be saved
+func settingsEncryptionConfigured() bool {
+	_, err := settingsEncryptionKey()
+	return err == nil
+}
+
+func settingsCipher() (cipher.AEAD, error) {
+	key, err := settingsEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Store a setting encrypted. The key name is sealed with the value, so a
+// value copied to another key won't decrypt. Empty clears the setting.
+func setEncryptedSetting(ctx context.Context, key, value string) error {
+	if value == "" {
+		return setSetting(ctx, key, "") // from settings.go
+	}
+	gcm, err := settingsCipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(key))
+	return setSetting(ctx, key, encryptedSettingPrefix+base64.StdEncoding.EncodeToString(sealed))
+}
+
+// Read an encrypted setting, "" when it was never set
+func getEncryptedSetting(key string) (string, error) {
+	stored := getSetting(key, "")
+	if stored == "" {
+		return "", nil
+	}
+	encoded, ok := strings.CutPrefix(stored, encryptedSettingPrefix)
+	if !ok {
+		return "", errors.New("setting " + key + " isn't encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := settingsCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("setting " + key + " is truncated")
+	}
+	value, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(key))
+	if err != nil {
+		return "", errors.New("setting " + key + " can't be decrypted; was SETTINGS_ENCRYPTION_KEY changed?")
+	}
+	return string(value), nil
+}
+
+// Environment variable if set, otherwise the encrypted setting
+func envOrEncryptedSetting(env, key string) (string, error) {
+	if value := os.Getenv(env); value != "" {
+		return value, nil
+	}
+	return getEncryptedSetting(key)
+}
@@ -0,0 +1,235 @@
+// security.go - Security audit log and login lockout
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Security event types
+const (
+	eventLoginFailed      = "login_failed"
+	eventLockout          = "lockout"
+	eventSessionCreated   = "session_created"
+	eventLogout           = "logout"
+	eventTokenInvalid     = "token_invalid"
+	eventTokenRateLimited = "token_rate_limited"
+	eventTokenCreated     = "token_created"
+	eventTokenRevoked     = "token_revoked"
+	eventExportLinkIssued = "export_link_issued"
+	eventExportLinkUsed   = "export_link_used"
+	eventLoginNewCountry  = "login_new_country"
+	eventSessionRevoked   = "session_revoked"
+	eventSQLQuery         = "sql_query"
+)
+
+// Failed logins allowed per hashed IP before it is locked out
+const (
+	loginFailureLimit  = 5
+	loginLockoutWindow = 15 * time.Minute
+)
+
+// Days of history shown on the security panel
+const securityReportDays = 30
+
+// Layout of CURRENT_TIMESTAMP, used for range queries on security_events
+const sqliteTimestamp = "2006-01-02 15:04:05"
+
+// Recorded security event
+type SecurityEvent struct {
+	ID        int
+	Event     string
+	IPHash    string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// Failed logins from one hashed IP
+type FailedLoginSource struct {
+	IPHash   string
+	Count    int
+	LastSeen time.Time
+}
+
+// Initialize the security audit log
+func initSecurityLog() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS security_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event TEXT NOT NULL,
+		ip_hash TEXT,
+		detail TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create security_events table:", err)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events (created_at)`)
+}
+
+// Record a security event in the audit log
+func recordSecurityEvent(ctx context.Context, event, ipHash, detail string) {
+	_, err := dbExec(ctx, "INSERT INTO security_events (event, ip_hash, detail) VALUES (?, ?, ?)", event, ipHash, detail)
+	if err != nil {
+		log.Printf("Error recording security event %s: %v", event, err)
+	}
+}
+
+var (
+	securityThrottleMu sync.Mutex
+	securityThrottle   = map[string]time.Time{}
+)
+
+// Record an event at most once per key and interval, so floods
+// (bad tokens, rate-limited clients) can't fill the table
+func recordSecurityEventThrottled(ctx context.Context, event, ipHash, detail, key string, interval time.Duration) {
+	securityThrottleMu.Lock()
+	key = event + "|" + key
+	if last, ok := securityThrottle[key]; ok && time.Since(last) < interval {
+		securityThrottleMu.Unlock()
+		return
+	}
+	securityThrottle[key] = time.Now()
+	for k, last := range securityThrottle {
+		if time.Since(last) > time.Hour {
+			delete(securityThrottle, k)
+		}
+	}
+	securityThrottleMu.Unlock()
+
+	recordSecurityEvent(ctx, event, ipHash, detail)
+}
+
+// Count one hashed IP's events of a type since a time
+func countSecurityEvents(ctx context.Context, event, ipHash string, since time.Time) int {
+	var count int
+	dbQueryRow(ctx, "SELECT COUNT(*) FROM security_events WHERE event = ? AND ip_hash = ? AND created_at >= ?",
+		event, ipHash, since.UTC().Format(sqliteTimestamp)).Scan(&count)
+	return count
+}
+
+// Whether a hashed IP has too many recent failed logins
+func loginLockedOut(ctx context.Context, ipHash string) bool {
+	return countSecurityEvents(ctx, eventLoginFailed, ipHash, time.Now().Add(-loginLockoutWindow)) >= loginFailureLimit
+}
+
+// Record a failed login, locking the IP out once it hits the limit
+func recordFailedLogin(ctx context.Context, ipHash string) {
+	recordSecurityEvent(ctx, eventLoginFailed, ipHash, "")
+	if countSecurityEvents(ctx, eventLoginFailed, ipHash, time.Now().Add(-loginLockoutWindow)) == loginFailureLimit {
+		log.Printf("Admin login locked out for %s after %d failures", ipHash, loginFailureLimit)
+		recordSecurityEvent(ctx, eventLockout, ipHash, loginLockoutWindow.String())
+	}
+}
+
+// Get security events since a time, newest first
+func getSecurityEvents(ctx context.Context, since time.Time) ([]SecurityEvent, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, event, COALESCE(ip_hash, ''), COALESCE(detail, ''), created_at
+		FROM security_events WHERE created_at >= ?
+		ORDER BY id DESC
+	`, since.UTC().Format(sqliteTimestamp))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SecurityEvent
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Event, &e.IPHash, &e.Detail, &e.CreatedAt); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Setup admin security panel routes
+func setupSecurityAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/security", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		events, err := getSecurityEvents(ctx, time.Now().AddDate(0, 0, -securityReportDays))
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load security events",
+			})
+			return
+		}
+
+		counts := map[string]int{}
+		sources := map[string]*FailedLoginSource{}
+		var lockouts, sessions, tokenEvents []SecurityEvent
+		for _, e := range events {
+			counts[e.Event]++
+			switch e.Event {
+			case eventLoginFailed:
+				source, ok := sources[e.IPHash]
+				if !ok {
+					// Events are newest first, so the first one seen is the latest
+					source = &FailedLoginSource{IPHash: e.IPHash, LastSeen: e.CreatedAt}
+					sources[e.IPHash] = source
+				}
+				source.Count++
+			case eventLockout:
+				lockouts = append(lockouts, e)
+			case eventSessionCreated, eventLoginNewCountry, eventSessionRevoked:
+				sessions = append(sessions, e)
+			case eventTokenInvalid, eventTokenRateLimited, eventTokenCreated, eventTokenRevoked,
+				eventExportLinkIssued, eventExportLinkUsed, eventSQLQuery:
+				tokenEvents = append(tokenEvents, e)
+			}
+		}
+
+		var failedSources []FailedLoginSource
+		for _, source := range sources {
+			failedSources = append(failedSources, *source)
+		}
+		sort.Slice(failedSources, func(i, j int) bool { return failedSources[i].Count > failedSources[j].Count })
+
+		loginAlerts, err := getLoginAlerts(ctx, c, time.Now().AddDate(0, 0, -securityReportDays)) // from loginalerts.go
+		if err != nil {
+			log.Printf("Error loading sign-in alerts: %v", err)
+		}
+
+		c.HTML(http.StatusOK, "admin-security.html", gin.H{
+			"days":          securityReportDays,
+			"loginAlerts":   loginAlerts,
+			"counts":        counts,
+			"failedSources": failedSources,
+			"lockouts":      lockouts,
+			"sessions":      sessions,
+			"tokenEvents":   tokenEvents,
+		})
+	})
+
+	adminGroup.GET("/security/export.csv", serveSecurityEventsExport)
+}
+
+// Download recent security events as CSV; also served by export links
+func serveSecurityEventsExport(c *gin.Context) {
+	ctx := c.Request.Context()
+	events, err := getSecurityEvents(ctx, time.Now().AddDate(0, 0, -securityReportDays))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to load security events")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="security-events.csv"`)
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"time", "event", "ip_hash", "detail"})
+	for _, e := range events {
+		w.Write([]string{e.CreatedAt.UTC().Format(time.RFC3339), e.Event, e.IPHash, e.Detail})
+	}
+	w.Flush()
+}
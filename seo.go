@@ -0,0 +1,188 @@
+// seo.go - Per-page and per-post search engine controls
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values rendered by the "meta" template partial
+type SEOMeta struct {
+	Description   string
+	OGDescription string // link preview text when it differs from Description
+	Canonical     string
+	NoIndex       bool
+	PrevURL       string // neighbouring pages of a paged list
+	NextURL       string
+
+	StructuredData []interface{} // schema.org JSON-LD objects (from structureddata.go)
+}
+
+// Site pages whose SEO settings can be edited in the admin
+type SEOPage struct {
+	Path  string
+	Label string
+	SEOMeta
+}
+
+// Used when neither BASE_URL nor setup (from setup.go) gives a base URL
+const defaultSiteBaseURL = "https://zachkp.dev"
+
+// Public URL of the primary site, without a trailing slash
+func siteBaseURL() string {
+	if base := envOrSetting("BASE_URL", baseURLSetting); base != "" { // from settings.go
+		return strings.TrimRight(base, "/")
+	}
+	return defaultSiteBaseURL
+}
+
+// Editable pages, in admin display order
+var seoPages = []SEOPage{
+	{Path: "/", Label: "Home"},
+	{Path: "/blog", Label: "Blog"},
+	{Path: "/now", Label: "Now"},
+	{Path: "/bookmarks", Label: "Bookmarks"},
+	{Path: "/reading", Label: "Reading"},
+	{Path: "/talks", Label: "Talks"},
+	{Path: "/changelog", Label: "Changelog"},
+	{Path: "/timeline", Label: "Timeline"},
+	{Path: "/hire", Label: "Hire Me"},
+	{Path: "/projects/status", Label: "Project Status"},
+	{Path: "/resume/html", Label: "Resume (HTML)"},
+}
+
+// Initialize page SEO storage
+func initSEO() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS page_seo (
+		path TEXT PRIMARY KEY,
+		meta_description TEXT,
+		canonical_url TEXT,
+		noindex INTEGER DEFAULT 0
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create page_seo table:", err)
+	}
+}
+
+// Get SEO settings for a page, defaulting the canonical URL to the page itself
+func getPageSEO(ctx context.Context, path string) SEOMeta {
+	meta := SEOMeta{}
+	err := dbQueryRow(ctx, `
+		SELECT COALESCE(meta_description, ''), COALESCE(canonical_url, ''), noindex
+		FROM page_seo WHERE path = ?
+	`, path).Scan(&meta.Description, &meta.Canonical, &meta.NoIndex)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading SEO for %s: %v", path, err)
+	}
+
+	if meta.Canonical == "" {
+		meta.Canonical = siteBaseURL() + path
+	}
+	meta.setBreadcrumbs(path, "")
+	return meta
+}
+
+// Replace the page's breadcrumb trail; title names a page that isn't one of
+// the editable ones
+func (m *SEOMeta) setBreadcrumbs(path, title string) {
+	kept := m.StructuredData[:0]
+	for _, data := range m.StructuredData {
+		if _, ok := data.(*JSONLDBreadcrumbList); !ok {
+			kept = append(kept, data)
+		}
+	}
+	m.StructuredData = kept
+	if crumbs := breadcrumbStructuredData(path, title); crumbs != nil {
+		m.StructuredData = append(m.StructuredData, crumbs)
+	}
+}
+
+// SEO settings for a post, falling back to its summary and permalink
+func (p Post) SEO() SEOMeta {
+	meta := SEOMeta{
+		Description: p.MetaDescription,
+		Canonical:   p.CanonicalURL,
+		NoIndex:     p.NoIndex,
+	}
+	if meta.Description == "" {
+		meta.Description = p.Summary
+	}
+	if meta.Canonical == "" {
+		meta.Canonical = p.Permalink()
+	}
+	if p.Status == postPublished {
+		meta.StructuredData = append(meta.StructuredData, postStructuredData(p, meta.Description))
+	}
+	meta.setBreadcrumbs("/blog/"+p.Slug, p.Title)
+	return meta
+}
+
+// Canonical overrides must be absolute http(s) URLs
+func validCanonicalURL(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || !isValidLongURL(value) {
+		return ""
+	}
+	return value
+}
+
+// Setup admin page SEO routes
+func setupSEOAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/seo", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		pages := make([]SEOPage, len(seoPages))
+		for i, page := range seoPages {
+			page.SEOMeta = getPageSEO(ctx, page.Path)
+			// Show the override only, not the default
+			if page.Canonical == siteBaseURL()+page.Path {
+				page.Canonical = ""
+			}
+			pages[i] = page
+		}
+
+		c.HTML(http.StatusOK, "admin-seo.html", gin.H{
+			"pages": pages,
+		})
+	})
+
+	adminGroup.POST("/seo", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		path := c.PostForm("path")
+		known := false
+		for _, page := range seoPages {
+			if page.Path == path {
+				known = true
+			}
+		}
+		if !known {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown page",
+			})
+			return
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO page_seo (path, meta_description, canonical_url, noindex) VALUES (?, ?, ?, ?)
+			ON CONFLICT(path) DO UPDATE SET meta_description = excluded.meta_description,
+				canonical_url = excluded.canonical_url, noindex = excluded.noindex
+		`, path, strings.TrimSpace(c.PostForm("meta_description")), validCanonicalURL(c.PostForm("canonical_url")), c.PostForm("noindex") == "on")
+		if err != nil {
+			log.Printf("Error saving page SEO: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save SEO settings",
+			})
+			return
+		}
+
+		log.Printf("SEO for %s updated by admin from %s", path, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/seo")
+	})
+}
@@ -0,0 +1,78 @@
+// settings.go - Runtime site settings
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+var (
+	settingsMu    sync.RWMutex
+	settingsCache = map[string]string{}
+)
+
+// Initialize the settings table and load it into memory
+func initSettings() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS site_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create site_settings table:", err)
+	}
+
+	rows, err := db.Query("SELECT key, value FROM site_settings")
+	if err != nil {
+		log.Fatal("Failed to load site settings:", err)
+	}
+	defer rows.Close()
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for rows.Next() {
+		var key, value string
+		if rows.Scan(&key, &value) == nil {
+			settingsCache[key] = value
+		}
+	}
+}
+
+// Get a setting from memory, or fallback when it was never set
+func getSetting(key, fallback string) string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if value, ok := settingsCache[key]; ok {
+		return value
+	}
+	return fallback
+}
+
+// Store a setting; it takes effect immediately
+func setSetting(ctx context.Context, key, value string) error {
+	_, err := dbExec(ctx, `
+		INSERT INTO site_settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, key, value)
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	settingsCache[key] = value
+	settingsMu.Unlock()
+	return nil
+}
+
+// Environment variable if set, otherwise the stored setting
+func envOrSetting(env, key string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return getSetting(key, "")
+}
@@ -0,0 +1,265 @@
+// setup.go - First-run setup wizard
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Settings written by setup. Environment variables still take precedence
+// over them, so existing deployments behave as before.
+const (
+	setupCompletedSetting    = "setup_completed"
+	adminUsernameSetting     = "admin_username"
+	adminPasswordHashSetting = "admin_password_hash"
+	baseURLSetting           = "base_url"
+	smtpHostSetting          = "smtp_host"
+	smtpPortSetting          = "smtp_port"
+	smtpUserSetting          = "smtp_user"
+	smtpPassSetting          = "smtp_pass"
+	smtpToSetting            = "smtp_to"
+)
+
+// Code from the startup log the wizard asks for, so nobody else can claim
+// a fresh deployment before its owner opens it
+var setupCode string
+
+// Serializes setup so two submissions can't both complete it
+var setupMu sync.Mutex
+
+// Values collected by the wizard or `zach-dev setup`
+type SetupConfig struct {
+	BaseURL       string
+	AdminUsername string
+	AdminPassword string
+	SMTPHost      string
+	SMTPPort      string
+	SMTPUser      string
+	SMTPPass      string
+	ToEmail       string
+	AnalyticsMode string
+	Engagement    bool
+}
+
+// Whether the deployment still needs setup: no admin credentials in the
+// environment and setup never completed
+func setupNeeded() bool {
+	return os.Getenv("ADMIN_USERNAME") == "" && os.Getenv("ADMIN_PASSWORD") == "" &&
+		getSetting(setupCompletedSetting, "") == "" // from settings.go
+}
+
+// Create the setup code when the deployment hasn't been set up
+func initSetup() {
+	if !setupNeeded() {
+		return
+	}
+	setupCode = generateAdminToken()[:12] // from admin.go
+	log.Printf("First run: open /setup and enter setup code %s, or run `zach-dev setup`", setupCode)
+}
+
+// Whether the primary admin signs in with the account saved by setup
+// rather than the environment's
+func usesSetupCredentials() bool {
+	return os.Getenv("ADMIN_USERNAME") == "" && os.Getenv("ADMIN_PASSWORD") == "" &&
+		getSetting(adminPasswordHashSetting, "") != ""
+}
+
+// Check credentials against the account saved by setup
+func checkSetupAdminLogin(username, password string) bool {
+	passwordOK := checkSitePassword(getSetting(adminPasswordHashSetting, ""), password) // from sites.go
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(getSetting(adminUsernameSetting, ""))) == 1
+	return passwordOK && userOK
+}
+
+// Trim the values and return a problem to show, or ""
+func (cfg *SetupConfig) normalize() string {
+	cfg.BaseURL = strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	cfg.AdminUsername = strings.TrimSpace(cfg.AdminUsername)
+	cfg.SMTPHost = strings.TrimSpace(cfg.SMTPHost)
+	cfg.SMTPPort = strings.TrimSpace(cfg.SMTPPort)
+	cfg.SMTPUser = strings.TrimSpace(cfg.SMTPUser)
+	cfg.ToEmail = strings.TrimSpace(cfg.ToEmail)
+
+	if !isValidLongURL(cfg.BaseURL) { // from main.go
+		return "Base URL must be an absolute http(s) URL such as https://example.com"
+	}
+	if parsed, _ := url.Parse(cfg.BaseURL); parsed.Path != "" || parsed.RawQuery != "" {
+		return "Base URL can't have a path or query"
+	}
+	if cfg.AdminUsername == "" {
+		return "Admin username is required"
+	}
+	if len(cfg.AdminPassword) < 12 {
+		return "The admin password must be at least 12 characters"
+	}
+	if cfg.SMTPPort != "" {
+		if port, err := strconv.Atoi(cfg.SMTPPort); err != nil || port < 1 || port > 65535 {
+			return "SMTP port must be a number between 1 and 65535"
+		}
+	}
+	if (cfg.SMTPUser == "") != (cfg.SMTPPass == "") {
+		return "Enter both the SMTP username and password, or neither"
+	}
+	if cfg.ToEmail != "" {
+		if _, err := mail.ParseAddress(cfg.ToEmail); err != nil {
+			return "Notification email address isn't valid"
+		}
+	}
+	if cfg.AnalyticsMode != analyticsStandard && !aggregateOnlyMode(cfg.AnalyticsMode) { // from analyticsmode.go
+		return "Unknown analytics mode"
+	}
+	return ""
+}
+
+// Save the configuration, marking setup complete last so a failed save can
+// be retried
+func applySetup(ctx context.Context, cfg SetupConfig) error {
+	passwordHash, err := hashSitePassword(cfg.AdminPassword) // from sites.go
+	if err != nil {
+		return err
+	}
+	engagement := ""
+	if cfg.Engagement {
+		engagement = "on"
+	}
+
+	settings := [][2]string{
+		{baseURLSetting, cfg.BaseURL},
+		{adminUsernameSetting, cfg.AdminUsername},
+		{adminPasswordHashSetting, passwordHash},
+		{analyticsModeSetting, cfg.AnalyticsMode},
+		{engagementSetting, engagement},
+	}
+	// Empty SMTP fields keep the defaults in loadSMTPSettings
+	for _, s := range [][2]string{
+		{smtpHostSetting, cfg.SMTPHost},
+		{smtpPortSetting, cfg.SMTPPort},
+		{smtpUserSetting, cfg.SMTPUser},
+		{smtpPassSetting, cfg.SMTPPass},
+		{smtpToSetting, cfg.ToEmail},
+	} {
+		if s[1] != "" {
+			settings = append(settings, s)
+		}
+	}
+	settings = append(settings, [2]string{setupCompletedSetting, time.Now().UTC().Format(time.RFC3339)})
+
+	ctx = withSite(ctx, primarySite) // settings live in the main database
+	for _, s := range settings {
+		if err := setSetting(ctx, s[0], s[1]); err != nil {
+			return fmt.Errorf("saving %s: %w", s[0], err)
+		}
+	}
+	setupCode = ""
+	return nil
+}
+
+// Wizard values to start from: the current settings, with the base URL
+// guessed from the request
+func defaultSetupConfig(c *gin.Context) SetupConfig {
+	scheme := "https"
+	if c.Request.TLS == nil && strings.Contains(c.Request.Host, "localhost") {
+		scheme = "http"
+	}
+	smtp := loadSMTPSettings() // from main.go
+	return SetupConfig{
+		BaseURL:       getSetting(baseURLSetting, scheme+"://"+c.Request.Host),
+		AdminUsername: "admin",
+		SMTPHost:      smtp.Host,
+		SMTPPort:      smtp.Port,
+		SMTPUser:      smtp.User,
+		ToEmail:       envOrSetting("TO_EMAIL", smtpToSetting), // not the built-in address
+		AnalyticsMode: analyticsMode(),
+		Engagement:    engagementEnabled(), // from engagement.go
+	}
+}
+
+// Setup the first-run wizard, which answers 404 once setup is complete
+func setupFirstRunRoutes(r *gin.Engine) {
+	setup := r.Group("/setup")
+	setup.Use(primarySiteOnlyMiddleware()) // from sites.go
+	setup.Use(func(c *gin.Context) {
+		if !setupNeeded() {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	})
+
+	setup.GET("", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "admin-setup.html", gin.H{
+			"config": defaultSetupConfig(c),
+		})
+	})
+
+	setup.POST("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ipHash := hashIP(c.ClientIP())
+		cfg := SetupConfig{
+			BaseURL:       c.PostForm("base_url"),
+			AdminUsername: c.PostForm("admin_username"),
+			AdminPassword: c.PostForm("admin_password"),
+			SMTPHost:      c.PostForm("smtp_host"),
+			SMTPPort:      c.PostForm("smtp_port"),
+			SMTPUser:      c.PostForm("smtp_user"),
+			SMTPPass:      c.PostForm("smtp_pass"),
+			ToEmail:       c.PostForm("to_email"),
+			AnalyticsMode: c.PostForm("analytics_mode"),
+			Engagement:    c.PostForm("engagement") == "on",
+		}
+		showProblem := func(status int, problem string) {
+			cfg.AdminPassword, cfg.SMTPPass = "", ""
+			c.HTML(status, "admin-setup.html", gin.H{"config": cfg, "error": problem})
+		}
+
+		// Wrong codes count as failed logins, so guessing gets locked out (from security.go)
+		if loginLockedOut(ctx, ipHash) {
+			showProblem(http.StatusTooManyRequests, "Too many failed attempts, try again later")
+			return
+		}
+		code := strings.TrimSpace(c.PostForm("setup_code"))
+		if subtle.ConstantTimeCompare([]byte(code), []byte(setupCode)) != 1 {
+			recordFailedLogin(ctx, ipHash)
+			log.Printf("Wrong setup code from %s", ipHash)
+			showProblem(http.StatusUnauthorized, "Wrong setup code; it's printed in the server log at startup")
+			return
+		}
+		if problem := cfg.normalize(); problem != "" {
+			showProblem(http.StatusBadRequest, problem)
+			return
+		}
+		if c.PostForm("admin_password_confirm") != cfg.AdminPassword {
+			showProblem(http.StatusBadRequest, "The passwords don't match")
+			return
+		}
+
+		setupMu.Lock()
+		defer setupMu.Unlock()
+		if !setupNeeded() {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err := applySetup(ctx, cfg); err != nil {
+			log.Printf("Error saving setup: %v", err)
+			showProblem(http.StatusInternalServerError, "Failed to save the configuration")
+			return
+		}
+
+		// Sign the new admin straight in
+		startAdminSession(c, primarySite, ipHash, "setup") // from admin.go
+		log.Printf("Setup completed from %s; admin %s created", ipHash, cfg.AdminUsername)
+		c.Redirect(http.StatusSeeOther, "/admin/dashboard")
+	})
+}
@@ -0,0 +1,279 @@
+// sharelinks.go - Signed read-only dashboard links
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signing key, kept in site_settings so links survive restarts
+const shareLinkSecretSetting = "share_link_secret"
+
+// Expiry choices offered in the admin, in days
+var shareLinkDurations = []int{1, 7, 30, 90}
+
+// Share link as shown in the admin
+type ShareLink struct {
+	ID         int
+	Label      string
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+	URL        string
+}
+
+// Whether the link still opens the dashboard
+func (l ShareLink) Active() bool {
+	return !l.RevokedAt.Valid && time.Now().Before(l.ExpiresAt)
+}
+
+// Initialize share link storage and the signing key
+func initShareLinks() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		label TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create share_links table:", err)
+	}
+
+	if getSetting(shareLinkSecretSetting, "") == "" { // from settings.go
+		if err := setSetting(context.Background(), shareLinkSecretSetting, generateAdminToken()); err != nil {
+			log.Fatal("Failed to store share link secret:", err)
+		}
+	}
+}
+
+// Token for a link: id, expiry and a MAC over both, so a token can't be
+// made up or have its expiry extended
+func shareLinkToken(ctx context.Context, id int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expiresAt.Unix())
+	return payload + "." + shareLinkMAC(ctx, payload)
+}
+
+func shareLinkMAC(ctx context.Context, payload string) string {
+	return signedLinkMAC(ctx, "share-link:", payload)
+}
+
+// MAC for a signed link token; the prefix keeps one kind of link from
+// being accepted as another. Link ids are per site database, so tenants'
+// tokens also cover the site and can't be replayed on another host
+// (from sites.go).
+func signedLinkMAC(ctx context.Context, prefix, payload string) string {
+	if site := siteFromContext(ctx); !site.Primary() {
+		prefix += "site-" + strconv.Itoa(site.ID) + ":"
+	}
+	mac := hmac.New(sha256.New, []byte(getSetting(shareLinkSecretSetting, "")))
+	mac.Write([]byte(prefix + payload))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Check a token's signature and expiry, then that the link wasn't revoked;
+// returns the link id
+func verifyShareLinkToken(ctx context.Context, token string) (int, bool) {
+	id, ok := parseSignedLinkToken(ctx, "share-link:", token)
+	if !ok {
+		return 0, false
+	}
+
+	var revoked int
+	err := dbQueryRow(ctx, "SELECT revoked_at IS NOT NULL FROM share_links WHERE id = ?", id).Scan(&revoked)
+	if err != nil || revoked == 1 {
+		return 0, false
+	}
+	return id, true
+}
+
+// Check an "id.expiry.mac" token's signature and expiry; returns the id
+func parseSignedLinkToken(ctx context.Context, prefix, token string) (int, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if subtle.ConstantTimeCompare([]byte(parts[2]), []byte(signedLinkMAC(ctx, prefix, payload))) != 1 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= expiry {
+		return 0, false
+	}
+	return id, true
+}
+
+// Full URL for a share token, on the same host as short links
+func buildShareURL(c *gin.Context, token string) string {
+	return strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/share/" + token // from main.go
+}
+
+// All share links, newest first
+func getShareLinks(ctx context.Context, c *gin.Context) ([]ShareLink, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, label, expires_at, revoked_at, created_at, last_used_at
+		FROM share_links
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		var l ShareLink
+		if err := rows.Scan(&l.ID, &l.Label, &l.ExpiresAt, &l.RevokedAt, &l.CreatedAt, &l.LastUsedAt); err != nil {
+			continue
+		}
+		if l.Active() {
+			l.URL = buildShareURL(c, shareLinkToken(ctx, l.ID, l.ExpiresAt))
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Setup the public read-only dashboard route
+func setupShareRoutes(r *gin.Engine) {
+	r.GET("/share/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+
+		id, ok := verifyShareLinkToken(ctx, c.Param("token"))
+		if !ok {
+			ipHash := hashIP(c.ClientIP())
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "share link", ipHash, time.Minute)
+			c.HTML(http.StatusNotFound, "share-expired.html", gin.H{})
+			return
+		}
+
+		stats, err := getAdminStats(ctx)
+		if err != nil {
+			log.Printf("Error loading shared stats: %v", err)
+			c.HTML(http.StatusInternalServerError, "share-expired.html", gin.H{"error": true})
+			return
+		}
+		var aggregates *AggregateStats
+		mode := analyticsMode()
+		if aggregateOnlyMode(mode) {
+			if aggregates, err = getAggregateStats(ctx); err != nil {
+				log.Printf("Error loading shared aggregate stats: %v", err)
+			}
+		}
+		var weekly []SnapshotDelta
+		if latest, previous, err := getLatestSnapshots(ctx); err == nil && latest != nil {
+			weekly = snapshotDeltas(latest, previous)
+		}
+
+		usedAt := time.Now()
+		safeGoRetry("share-link-usage", 3, func() error {
+			_, err := dbExec(context.WithoutCancel(ctx), "UPDATE share_links SET last_used_at = ? WHERE id = ?", usedAt, id)
+			return err
+		})
+
+		c.HTML(http.StatusOK, "share-dashboard.html", gin.H{
+			"stats":      stats,
+			"aggregates": aggregates,
+			"weekly":     weekly,
+		})
+	})
+}
+
+// Setup admin share link management routes
+func setupShareLinkAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/share-links", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		links, err := getShareLinks(ctx, c)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load share links",
+			})
+			return
+		}
+
+		exportLinks, err := getExportLinks(ctx, c) // from exportlinks.go
+		if err != nil {
+			log.Printf("Error loading export links: %v", err)
+		}
+
+		c.HTML(http.StatusOK, "admin-share-links.html", gin.H{
+			"links":           links,
+			"durations":       shareLinkDurations,
+			"exportLinks":     exportLinks,
+			"exports":         exportDownloads,
+			"exportDurations": exportLinkDurations,
+			"message":         c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/share-links", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		label := strings.TrimSpace(c.PostForm("label"))
+		days, err := strconv.Atoi(c.PostForm("days"))
+		if label == "" || err != nil || days < 1 || days > shareLinkDurations[len(shareLinkDurations)-1] {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A label and a valid expiry are required",
+			})
+			return
+		}
+
+		expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour).Truncate(time.Second)
+		if _, err := dbExec(ctx, "INSERT INTO share_links (label, expires_at) VALUES (?, ?)", label, expiresAt); err != nil {
+			log.Printf("Error creating share link: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to create share link",
+			})
+			return
+		}
+
+		log.Printf("Dashboard share link %q created by admin from %s", label, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/share-links?message="+url.QueryEscape("Share link created for "+label))
+	})
+
+	adminGroup.POST("/share-links/:id/revoke", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "UPDATE share_links SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", c.Param("id"))
+		if err != nil {
+			log.Printf("Error revoking share link: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to revoke share link",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Share link not found or already revoked",
+			})
+			return
+		}
+
+		log.Printf("Dashboard share link %s revoked by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/share-links?message="+url.QueryEscape("Share link revoked"))
+	})
+}
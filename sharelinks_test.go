@@ -0,0 +1,124 @@
+// sharelinks_test.go - Signed link token checks
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Use a known share link secret for the test
+func withShareLinkSecret(t *testing.T) {
+	t.Helper()
+	settingsMu.Lock()
+	previous, had := settingsCache[shareLinkSecretSetting]
+	settingsCache[shareLinkSecretSetting] = "share-link-test-secret"
+	settingsMu.Unlock()
+	t.Cleanup(func() {
+		settingsMu.Lock()
+		defer settingsMu.Unlock()
+		if had {
+			settingsCache[shareLinkSecretSetting] = previous
+		} else {
+			delete(settingsCache, shareLinkSecretSetting)
+		}
+	})
+}
+
+// Point db at an in-memory database with an active link (id 1) and a
+// revoked one (id 2), returned for a test site to use
+func withTestShareLinks(t *testing.T) *sql.DB {
+	t.Helper()
+	testDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB.SetMaxOpenConns(1) // each connection would get its own database
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+		testDB.Close()
+	})
+
+	initShareLinks()
+	expiresAt := time.Now().Add(time.Hour)
+	if _, err := db.Exec("INSERT INTO share_links (label, expires_at) VALUES ('active', ?), ('revoked', ?)", expiresAt, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE share_links SET revoked_at = CURRENT_TIMESTAMP WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	return testDB
+}
+
+func signedTestToken(ctx context.Context, prefix string, id int, expiresAt time.Time) string {
+	payload := strconv.Itoa(id) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + signedLinkMAC(ctx, prefix, payload)
+}
+
+func TestParseSignedLinkToken(t *testing.T) {
+	withShareLinkSecret(t)
+	primary := context.Background()
+	tenant := withSite(primary, &Site{ID: 7, Host: "tenant.example"})
+	later := time.Now().Add(time.Hour)
+	valid := signedTestToken(primary, "share-link:", 42, later)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		prefix string
+		token  string
+		id     int
+		ok     bool
+	}{
+		{"valid", primary, "share-link:", valid, 42, true},
+		{"valid on a tenant", tenant, "share-link:", signedTestToken(tenant, "share-link:", 5, later), 5, true},
+		{"expired", primary, "share-link:", signedTestToken(primary, "share-link:", 42, time.Now().Add(-time.Second)), 0, false},
+		{"tampered id", primary, "share-link:", "43." + parts[1] + "." + parts[2], 0, false},
+		{"tampered expiry", primary, "share-link:", parts[0] + "." + strconv.FormatInt(later.Add(24*time.Hour).Unix(), 10) + "." + parts[2], 0, false},
+		{"tampered mac", primary, "share-link:", parts[0] + "." + parts[1] + "." + strings.Repeat("0", len(parts[2])), 0, false},
+		// Tokens replayed where they weren't issued: another kind of link,
+		// or another site
+		{"replayed as another kind", primary, "export-link:", valid, 0, false},
+		{"replayed on another site", tenant, "share-link:", valid, 0, false},
+		{"replayed from a tenant", primary, "share-link:", signedTestToken(tenant, "share-link:", 5, later), 0, false},
+		{"too few parts", primary, "share-link:", parts[0] + "." + parts[2], 0, false},
+		{"too many parts", primary, "share-link:", valid + ".x", 0, false},
+		{"empty", primary, "share-link:", "", 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := parseSignedLinkToken(tt.ctx, tt.prefix, tt.token)
+		if ok != tt.ok || id != tt.id {
+			t.Errorf("%s: parseSignedLinkToken = %d, %v; want %d, %v", tt.name, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestVerifyShareLinkToken(t *testing.T) {
+	withShareLinkSecret(t)
+	ctx := withSite(context.Background(), &Site{db: withTestShareLinks(t)})
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		id    int
+		ok    bool
+	}{
+		{"active", shareLinkToken(ctx, 1, later), 1, true},
+		{"revoked", shareLinkToken(ctx, 2, later), 0, false},
+		{"unknown link", shareLinkToken(ctx, 3, later), 0, false},
+		{"export link token", signedTestToken(ctx, "export-link:", 1, later), 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := verifyShareLinkToken(ctx, tt.token)
+		if ok != tt.ok || id != tt.id {
+			t.Errorf("%s: verifyShareLinkToken = %d, %v; want %d, %v", tt.name, id, ok, tt.id, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,540 @@
+// sites.go - Multi-tenant hosting by Host header
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Site served by this deployment. The primary site (ID 0) is the original
+// portfolio: it uses the main database and the environment's admin
+// credentials, and answers any host no tenant claims. Tenants get their own
+// SQLite file with the same schema, so content, links and analytics never
+// mix between sites.
+type Site struct {
+	ID            int
+	Host          string
+	Name          string
+	AdminUsername string
+	CreatedAt     time.Time
+
+	passwordHash string
+	db           *sql.DB
+}
+
+// Whether this is the original portfolio site
+func (s *Site) Primary() bool {
+	return s.ID == 0
+}
+
+// Tenant overview for the super-admin page
+type SiteSummary struct {
+	*Site
+	ViewsThisWeek int64
+	Posts         int64
+	Links         int64
+	Messages      int64
+	Error         string
+}
+
+// Password hashing for tenant admins
+const sitePasswordIterations = 600000
+
+var siteHostPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+var (
+	sitesMu     sync.RWMutex
+	sitesByHost = map[string]*Site{}
+	primarySite = &Site{Name: "Primary site"}
+)
+
+type siteContextKey struct{}
+
+// Tenant databases, from SITES_DATA_DIR (next to the main database by default)
+func sitesDataDir() string {
+	if dir := os.Getenv("SITES_DATA_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(databasePath()), "sites")
+}
+
+// Initialize the sites table and open every tenant database; runs last in
+// initApp so the tenants get the complete schema
+func initSites() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS sites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		admin_username TEXT NOT NULL,
+		admin_password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create sites table:", err)
+	}
+	primarySite.db = db
+
+	rows, err := db.Query("SELECT id, host, name, admin_username, admin_password_hash, created_at FROM sites")
+	if err != nil {
+		log.Fatal("Failed to load sites:", err)
+	}
+	var sites []*Site
+	for rows.Next() {
+		s := &Site{}
+		if err := rows.Scan(&s.ID, &s.Host, &s.Name, &s.AdminUsername, &s.passwordHash, &s.CreatedAt); err != nil {
+			log.Fatal("Failed to load sites:", err)
+		}
+		sites = append(sites, s)
+	}
+	rows.Close()
+
+	for _, s := range sites {
+		if s.db, err = openSiteDB(s.ID); err != nil {
+			// Keep the other sites up; this host answers with the primary site until a restart
+			log.Printf("Failed to open database for site %s, not serving it: %v", s.Host, err)
+			continue
+		}
+		sitesByHost[s.Host] = s
+	}
+	if len(sites) > 0 {
+		log.Printf("Serving %d tenant sites", len(sites))
+	}
+}
+
+// Open a tenant database and bring its schema up to date
+func openSiteDB(id int) (*sql.DB, error) {
+	if err := os.MkdirAll(sitesDataDir(), 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(sitesDataDir(), fmt.Sprintf("site-%d.db", id))
+	siteDB, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if err := syncSiteSchema(siteDB); err != nil {
+		siteDB.Close()
+		return nil, err
+	}
+	return siteDB, nil
+}
+
+// Copy the main database's tables and indexes into a tenant database, and
+// add any columns the main schema has gained since the tenant was created
+func syncSiteSchema(siteDB *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT type, name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' AND name != 'sites' AND tbl_name != 'sites'
+		ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END`)
+	if err != nil {
+		return err
+	}
+	type schemaObject struct{ kind, name, sql string }
+	var objects []schemaObject
+	for rows.Next() {
+		var o schemaObject
+		if err := rows.Scan(&o.kind, &o.name, &o.sql); err != nil {
+			rows.Close()
+			return err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+
+	for _, o := range objects {
+		var exists int
+		siteDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = ? AND name = ?", o.kind, o.name).Scan(&exists)
+		if exists == 0 {
+			if _, err := siteDB.Exec(o.sql); err != nil {
+				return fmt.Errorf("creating %s %s: %w", o.kind, o.name, err)
+			}
+			continue
+		}
+		if o.kind == "table" {
+			if err := addMissingColumns(siteDB, o.name); err != nil {
+				return fmt.Errorf("updating table %s: %w", o.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Add columns that exist on the main database's table but not the tenant's
+func addMissingColumns(siteDB *sql.DB, table string) error {
+	type column struct {
+		name, kind string
+		notNull    bool
+		dflt       sql.NullString
+	}
+	columns := func(conn *sql.DB) ([]column, error) {
+		rows, err := conn.Query(fmt.Sprintf("SELECT name, type, \"notnull\", dflt_value FROM pragma_table_info('%s')", table))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var cols []column
+		for rows.Next() {
+			var c column
+			if err := rows.Scan(&c.name, &c.kind, &c.notNull, &c.dflt); err != nil {
+				return nil, err
+			}
+			cols = append(cols, c)
+		}
+		return cols, rows.Err()
+	}
+
+	want, err := columns(db)
+	if err != nil {
+		return err
+	}
+	have, err := columns(siteDB)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, c := range have {
+		existing[c.name] = true
+	}
+
+	for _, c := range want {
+		if existing[c.name] {
+			continue
+		}
+		// SQLite can't add a column with a non-constant default such as
+		// CURRENT_TIMESTAMP, so those are added without one
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.kind)
+		if c.dflt.Valid && !strings.Contains(strings.ToUpper(c.dflt.String), "CURRENT_") {
+			stmt += " DEFAULT " + c.dflt.String
+			if c.notNull {
+				stmt += " NOT NULL"
+			}
+		}
+		if _, err := siteDB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Site for a request's Host header; hosts no tenant claims get the primary site
+func siteForHost(host string) *Site {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+	if s, ok := sitesByHost[host]; ok {
+		return s
+	}
+	return primarySite
+}
+
+// Site a tenant's counters are flushed to, if it still exists
+func siteByID(id int) *Site {
+	if id == 0 {
+		return primarySite
+	}
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+	for _, s := range sitesByHost {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Every site, primary first, for background jobs that run per database
+func allSites() []*Site {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+	sites := []*Site{primarySite}
+	for _, s := range sitesByHost {
+		sites = append(sites, s)
+	}
+	tenants := sites[1:]
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return sites
+}
+
+// Attach a site to a context; the db helpers in dbctx.go use its database
+func withSite(ctx context.Context, s *Site) context.Context {
+	return context.WithValue(ctx, siteContextKey{}, s)
+}
+
+// Site the context belongs to; the primary site when none is attached
+func siteFromContext(ctx context.Context) *Site {
+	if s, ok := ctx.Value(siteContextKey{}).(*Site); ok && s != nil {
+		return s
+	}
+	return primarySite
+}
+
+// Database for the context's site
+func dbFor(ctx context.Context) *sql.DB {
+	if s := siteFromContext(ctx); s.db != nil {
+		return s.db
+	}
+	return db
+}
+
+// Run fn once per site, with the site attached to its context
+func forEachSite(fn func(ctx context.Context)) {
+	for _, s := range allSites() {
+		fn(withSite(context.Background(), s))
+	}
+}
+
+// Resolve the site from the Host header before anything touches the database
+func siteMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := siteForHost(c.Request.Host)
+		c.Request = c.Request.WithContext(withSite(c.Request.Context(), s))
+		c.Next()
+	}
+}
+
+// Admin session token for a site; tenants' tokens are derived from the
+// primary one, so rotating the session secret rotates every site's
+func siteAdminToken(s *Site) string {
+	return deriveSiteAdminToken(s, currentAdminToken()) // from rotation.go
+}
+
+// Tokens a site's sessions are accepted with, current first
+func siteAdminTokens(s *Site) []string {
+	var tokens []string
+	for _, secret := range acceptedAdminTokens() {
+		tokens = append(tokens, deriveSiteAdminToken(s, secret))
+	}
+	return tokens
+}
+
+func deriveSiteAdminToken(s *Site, secret string) string {
+	if s.Primary() {
+		return secret
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("site-admin:" + strconv.Itoa(s.ID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hash a tenant admin password as pbkdf2-sha256$iterations$salt$hash
+func hashSitePassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, sitePasswordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", sitePasswordIterations, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+// Check a tenant admin's credentials
+func (s *Site) checkAdminLogin(username, password string) bool {
+	passwordOK := checkSitePassword(s.passwordHash, password)
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.AdminUsername)) == 1
+	return passwordOK && userOK
+}
+
+// Check a password against a hash from hashSitePassword
+func checkSitePassword(passwordHash, password string) bool {
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	salt, saltErr := hex.DecodeString(parts[2])
+	want, wantErr := hex.DecodeString(parts[3])
+	if err != nil || saltErr != nil || wantErr != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// Add a tenant and create its database
+func createSite(ctx context.Context, host, name, username, password string) (*Site, error) {
+	passwordHash, err := hashSitePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	result, err := dbExec(ctx, `
+		INSERT INTO sites (host, name, admin_username, admin_password_hash) VALUES (?, ?, ?, ?)
+	`, host, name, username, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+
+	s := &Site{ID: int(id), Host: host, Name: name, AdminUsername: username, CreatedAt: time.Now(), passwordHash: passwordHash}
+	if s.db, err = openSiteDB(s.ID); err != nil {
+		dbExec(ctx, "DELETE FROM sites WHERE id = ?", id)
+		return nil, err
+	}
+
+	sitesMu.Lock()
+	sitesByHost[host] = s
+	sitesMu.Unlock()
+	return s, nil
+}
+
+// Stop serving a tenant; its database file is left on disk
+func removeSite(ctx context.Context, id int) (*Site, error) {
+	s := siteByID(id)
+	if s == nil || s.Primary() {
+		return nil, sql.ErrNoRows
+	}
+	if _, err := dbExec(ctx, "DELETE FROM sites WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+
+	sitesMu.Lock()
+	delete(sitesByHost, s.Host)
+	sitesMu.Unlock()
+	s.db.Close()
+	return s, nil
+}
+
+// Per-site figures for the super-admin page, each read from the site's own database
+func getSiteSummaries(ctx context.Context) []SiteSummary {
+	since := time.Now().UTC().AddDate(0, 0, -7)
+	var summaries []SiteSummary
+	for _, s := range allSites() {
+		summary := SiteSummary{Site: s}
+		siteCtx := withSite(ctx, s)
+		err := dbQueryRow(siteCtx, `
+			SELECT
+				(SELECT COUNT(*) FROM visitors WHERE timestamp >= ?) +
+				(SELECT COALESCE(SUM(views), 0) FROM page_view_counters WHERE hour >= ?) +
+				(SELECT COALESCE(SUM(views), 0) FROM country_page_counts WHERE day >= ?),
+				(SELECT COUNT(*) FROM posts),
+				(SELECT COUNT(*) FROM urls),
+				(SELECT COUNT(*) FROM messages)`,
+			since.Format(sqliteTimestamp), since.Format("2006-01-02 15:00"), since.Format("2006-01-02")).
+			Scan(&summary.ViewsThisWeek, &summary.Posts, &summary.Links, &summary.Messages)
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Only the primary site's admin may manage sites and deployment-wide settings
+func superAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !siteFromContext(c.Request.Context()).Primary() {
+			c.HTML(http.StatusForbidden, "admin-error.html", gin.H{
+				"error": "Only the primary site's admin can do this",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Public routes that only make sense for the primary site answer 404 elsewhere
+func primarySiteOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !siteFromContext(c.Request.Context()).Primary() {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Setup super-admin site management routes
+func setupSiteAdminRoutes(adminGroup *gin.RouterGroup) {
+	sites := adminGroup.Group("/sites")
+	sites.Use(superAdminMiddleware())
+
+	sites.GET("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.HTML(http.StatusOK, "admin-sites.html", gin.H{
+			"sites":   getSiteSummaries(ctx),
+			"dataDir": sitesDataDir(),
+			"message": c.Query("message"),
+		})
+	})
+
+	sites.POST("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		host := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c.PostForm("host"))), "www.")
+		name := strings.TrimSpace(c.PostForm("name"))
+		username := strings.TrimSpace(c.PostForm("admin_username"))
+		password := c.PostForm("admin_password")
+
+		var problem string
+		switch {
+		case !siteHostPattern.MatchString(host):
+			problem = "Enter a host name such as friend.example.com"
+		case name == "" || username == "":
+			problem = "Site name and admin username are required"
+		case len(password) < 12:
+			problem = "The admin password must be at least 12 characters"
+		}
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		s, err := createSite(ctx, host, name, username, password)
+		if err != nil {
+			log.Printf("Error creating site %s: %v", host, err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to create site (is the host already in use?)",
+			})
+			return
+		}
+
+		log.Printf("Site %s (%d) created by admin from %s", s.Host, s.ID, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/sites?message="+url.QueryEscape("Site "+s.Host+" created"))
+	})
+
+	sites.POST("/:id/remove", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id, _ := strconv.Atoi(c.Param("id"))
+		s, err := removeSite(ctx, id)
+		if err != nil {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Site not found",
+			})
+			return
+		}
+
+		log.Printf("Site %s (%d) removed by admin from %s", s.Host, s.ID, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/sites?message="+url.QueryEscape("Site "+s.Host+" removed; its database file was kept"))
+	})
+}
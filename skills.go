@@ -0,0 +1,312 @@
+// skills.go - Skills and technologies matrix
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A skill or technology, with how well and how long I've used it
+type Skill struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Category    string   `json:"category"`
+	Proficiency int      `json:"proficiency"` // 1 to 5, see skillLevels
+	Years       int      `json:"years"`
+	Projects    []string `json:"projects"` // project slugs
+	SortOrder   int      `json:"sort_order"`
+}
+
+// Proficiency labels, from 1
+var skillLevels = []string{"Familiar", "Working knowledge", "Proficient", "Advanced", "Expert"}
+
+// Label for the skill's proficiency
+func (s Skill) Level() string {
+	if s.Proficiency < 1 || s.Proficiency > len(skillLevels) {
+		return ""
+	}
+	return skillLevels[s.Proficiency-1]
+}
+
+// Proficiency as filled and empty steps, for drawing a meter
+func (s Skill) Meter() []bool {
+	steps := make([]bool, len(skillLevels))
+	for i := range steps {
+		steps[i] = i < s.Proficiency
+	}
+	return steps
+}
+
+// A proficiency choice in the admin form
+type SkillLevel struct {
+	Value int
+	Label string
+}
+
+func skillLevelOptions() []SkillLevel {
+	var options []SkillLevel
+	for i, label := range skillLevels {
+		options = append(options, SkillLevel{i + 1, label})
+	}
+	return options
+}
+
+// Whether the skill lists the project
+func (s Skill) UsedIn(slug string) bool {
+	for _, p := range s.Projects {
+		if p == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// Categories given to technologies when seeding from project tech lists
+var skillSeedCategories = map[string]string{
+	"Golang": "Languages", "Python": "Languages", "HTML": "Languages",
+	"Gin": "Frameworks", "htmx": "Frameworks", "alpine.js": "Frameworks", "Tailwindcss": "Frameworks",
+	"Flask": "Frameworks", "Bubbletea": "Frameworks", "Lipgloss": "Frameworks", "Cobra-cli": "Frameworks",
+	"Pandas": "Data", "Scikit-learn": "Data", "Matplotlib": "Data",
+}
+
+// Initialize skill storage, seeding it from the projects' tech lists on first run
+func initSkills() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS skills (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		category TEXT NOT NULL,
+		proficiency INTEGER NOT NULL DEFAULT 3,
+		years INTEGER NOT NULL DEFAULT 0,
+		projects TEXT NOT NULL DEFAULT '',
+		sort_order INTEGER NOT NULL DEFAULT 0
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create skills table:", err)
+	}
+
+	seedSkills()
+}
+
+func seedSkills() {
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM skills").Scan(&count)
+	if count > 0 {
+		return
+	}
+
+	rows, err := db.Query("SELECT slug, COALESCE(tech, '') FROM projects ORDER BY sort_order, id")
+	if err != nil {
+		log.Printf("Error loading projects to seed skills: %v", err)
+		return
+	}
+	var names []string
+	usedIn := map[string][]string{}
+	for rows.Next() {
+		var slug, tech string
+		if rows.Scan(&slug, &tech) != nil {
+			continue
+		}
+		for _, name := range splitList(tech, ",") { // from content.go
+			if usedIn[name] == nil {
+				names = append(names, name)
+			}
+			usedIn[name] = append(usedIn[name], slug)
+		}
+	}
+	rows.Close()
+	for i, name := range names {
+		category := skillSeedCategories[name]
+		if category == "" {
+			category = "Tools"
+		}
+		_, err := db.Exec(`INSERT OR IGNORE INTO skills (name, category, projects, sort_order) VALUES (?, ?, ?, ?)`,
+			name, category, strings.Join(usedIn[name], ","), i)
+		if err != nil {
+			log.Printf("Error seeding skill %s: %v", name, err)
+		}
+	}
+	log.Printf("Seeded %d skills", len(names))
+}
+
+// Skills in display order, all or from one category
+func getSkills(ctx context.Context, category string) ([]Skill, error) {
+	query := `SELECT id, name, category, proficiency, years, projects, sort_order FROM skills`
+	var args []interface{}
+	if category != "" {
+		query += ` WHERE category = ?`
+		args = append(args, category)
+	}
+	rows, err := dbQuery(ctx, query+` ORDER BY sort_order, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var skills []Skill
+	for rows.Next() {
+		var s Skill
+		var projects string
+		if err := rows.Scan(&s.ID, &s.Name, &s.Category, &s.Proficiency, &s.Years, &projects, &s.SortOrder); err != nil {
+			continue
+		}
+		s.Projects = splitList(projects, ",")
+		skills = append(skills, s)
+	}
+	return skills, rows.Err()
+}
+
+// Categories in the order their first skill is shown
+func skillCategories(skills []Skill) []string {
+	var categories []string
+	seen := map[string]bool{}
+	for _, s := range skills {
+		if !seen[s.Category] {
+			seen[s.Category] = true
+			categories = append(categories, s.Category)
+		}
+	}
+	return categories
+}
+
+// Parse the admin skill form
+func skillFromForm(c *gin.Context) (Skill, string) {
+	s := Skill{
+		Name:     strings.TrimSpace(c.PostForm("name")),
+		Category: strings.TrimSpace(c.PostForm("category")),
+		Projects: c.PostFormArray("projects"), // checked project slugs
+	}
+	if s.Name == "" || s.Category == "" {
+		return s, "A skill needs a name and a category"
+	}
+	var err error
+	if s.Proficiency, err = strconv.Atoi(c.PostForm("proficiency")); err != nil || s.Proficiency < 1 || s.Proficiency > len(skillLevels) {
+		return s, "Choose a proficiency"
+	}
+	if s.Years, err = strconv.Atoi(c.DefaultPostForm("years", "0")); err != nil || s.Years < 0 || s.Years > 60 {
+		return s, "Years must be a number from 0 to 60"
+	}
+	if s.SortOrder, err = strconv.Atoi(c.DefaultPostForm("sort_order", "0")); err != nil {
+		return s, "Sort order must be a number"
+	}
+	return s, ""
+}
+
+// Setup the public skills partial, filtered by ?category=
+func setupSkillRoutes(r *gin.Engine) {
+	r.GET("/skills-content", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		skills, err := getSkills(ctx, c.Query("category"))
+		if err != nil {
+			log.Printf("Error loading skills: %v", err)
+		}
+		projects, err := getProjects(ctx)
+		if err != nil {
+			log.Printf("Error loading projects: %v", err)
+		}
+		projectTitles := map[string]string{}
+		for _, p := range projects {
+			projectTitles[p.Slug] = p.Title
+		}
+		renderView(c, http.StatusOK, "skills-content.html", SkillsContentView{ // from viewmodels.go
+			Skills:        skills,
+			ProjectTitles: projectTitles,
+		})
+	})
+}
+
+// Setup admin skill routes
+func setupSkillAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/skills", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		skills, err := getSkills(ctx, "")
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load skills",
+			})
+			return
+		}
+		projects, err := getProjects(ctx)
+		if err != nil {
+			log.Printf("Error loading projects: %v", err)
+		}
+
+		// The form edits the skill picked from the list, or adds one
+		editing := Skill{Proficiency: 3, SortOrder: len(skills)}
+		for _, s := range skills {
+			if strconv.Itoa(s.ID) == c.Query("edit") {
+				editing = s
+			}
+		}
+		c.HTML(http.StatusOK, "admin-skills.html", gin.H{
+			"skills":     skills,
+			"editing":    editing,
+			"categories": skillCategories(skills),
+			"levels":     skillLevelOptions(),
+			"projects":   projects,
+			"message":    c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/skills", func(c *gin.Context) {
+		s, problem := skillFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+		_, err := dbExec(c.Request.Context(), `
+			INSERT INTO skills (name, category, proficiency, years, projects, sort_order) VALUES (?, ?, ?, ?, ?, ?)
+		`, s.Name, s.Category, s.Proficiency, s.Years, strings.Join(s.Projects, ","), s.SortOrder)
+		if err != nil {
+			log.Printf("Error saving skill: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save skill (is there one with that name already?)",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/skills?message="+url.QueryEscape("Skill added"))
+	})
+
+	adminGroup.POST("/skills/:id", func(c *gin.Context) {
+		s, problem := skillFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+		result, err := dbExec(c.Request.Context(), `
+			UPDATE skills SET name = ?, category = ?, proficiency = ?, years = ?, projects = ?, sort_order = ?
+			WHERE id = ?
+		`, s.Name, s.Category, s.Proficiency, s.Years, strings.Join(s.Projects, ","), s.SortOrder, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating skill: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save skill (is there one with that name already?)",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Skill not found"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/skills?message="+url.QueryEscape("Skill saved"))
+	})
+
+	adminGroup.POST("/skills/:id/delete", func(c *gin.Context) {
+		if _, err := dbExec(c.Request.Context(), "DELETE FROM skills WHERE id = ?", c.Param("id")); err != nil {
+			log.Printf("Error deleting skill: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete skill",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/skills?message="+url.QueryEscape("Skill deleted"))
+	})
+}
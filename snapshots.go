@@ -0,0 +1,217 @@
+// snapshots.go - Weekly metric snapshots
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Key figures for one week (Monday to Monday, UTC)
+type WeeklySnapshot struct {
+	Week           string // Monday, YYYY-MM-DD
+	Views          int64
+	UniqueVisitors int64
+	Clicks         int64
+	TotalClicks    int64 // urls.clicks total when taken, to diff the next week against
+	Messages       int64
+	URLsCreated    int64
+	TakenAt        time.Time
+}
+
+// One figure on the dashboard card, this week against the week before
+type SnapshotDelta struct {
+	Label    string
+	Current  int64
+	Previous int64
+	Link     string
+}
+
+// Change as shown on the card: +34%, -10%, or the raw difference when
+// there is nothing to take a percentage of
+func (d SnapshotDelta) Change() string {
+	switch {
+	case d.Current == d.Previous:
+		return "no change"
+	case d.Previous == 0:
+		return fmt.Sprintf("+%d", d.Current)
+	}
+	return fmt.Sprintf("%+d%%", (d.Current-d.Previous)*100/d.Previous)
+}
+
+func (d SnapshotDelta) Up() bool {
+	return d.Current > d.Previous
+}
+
+// Initialize the snapshots table
+func initSnapshots() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS snapshots (
+		week TEXT PRIMARY KEY,
+		views INTEGER NOT NULL DEFAULT 0,
+		unique_visitors INTEGER NOT NULL DEFAULT 0,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		total_clicks INTEGER NOT NULL DEFAULT 0,
+		messages INTEGER NOT NULL DEFAULT 0,
+		urls_created INTEGER NOT NULL DEFAULT 0,
+		taken_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create snapshots table:", err)
+	}
+
+	startWorker("weekly-snapshot", snapshotWorker) // from safego.go
+}
+
+// Check daily for a finished week without a snapshot
+func snapshotWorker() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := takeWeeklySnapshot(ctx, time.Now().UTC()); siteErr != nil {
+				log.Printf("Error taking weekly snapshot for %s: %v", siteFromContext(ctx).Name, siteErr)
+				err = siteErr
+			}
+		})
+		recordJobRun("weekly-snapshot", 24*time.Hour, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Monday 00:00 UTC of the week containing t
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// Snapshot the last finished week before now, unless it already has one
+func takeWeeklySnapshot(ctx context.Context, now time.Time) error {
+	end := weekStart(now)
+	start := end.AddDate(0, 0, -7)
+	week := start.Format("2006-01-02")
+
+	var exists int
+	err := dbQueryRow(ctx, "SELECT 1 FROM snapshots WHERE week = ?", week).Scan(&exists)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	s := WeeklySnapshot{Week: week}
+	from, to := start.Format("2006-01-02"), end.Format("2006-01-02")
+
+	// Visitor timestamps and the counter periods all start YYYY-MM-DD, so
+	// day strings bound them (see analyticsmode.go)
+	err = dbQueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM visitors WHERE timestamp >= ? AND timestamp < ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM page_view_counters WHERE hour >= ? AND hour < ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM country_page_counts WHERE day >= ? AND day < ?)`,
+		from, to, from, to, from, to).Scan(&s.Views)
+	if err != nil {
+		return err
+	}
+	err = dbQueryRow(ctx, "SELECT COUNT(DISTINCT hashed_ip) FROM visitors WHERE timestamp >= ? AND timestamp < ?",
+		from, to).Scan(&s.UniqueVisitors)
+	if err != nil {
+		return err
+	}
+	err = dbQueryRow(ctx, "SELECT COUNT(*) FROM messages WHERE created_at >= ? AND created_at < ?",
+		from, to).Scan(&s.Messages)
+	if err != nil {
+		return err
+	}
+	err = dbQueryRow(ctx, "SELECT COUNT(*) FROM urls WHERE created_at >= ? AND created_at < ?",
+		from, to).Scan(&s.URLsCreated)
+	if err != nil {
+		return err
+	}
+
+	// urls.clicks is a running total, so the week's clicks are the change
+	// since the previous snapshot; without one, fall back to tracked /s/ views
+	err = dbQueryRow(ctx, "SELECT COALESCE(SUM(clicks), 0) FROM urls").Scan(&s.TotalClicks)
+	if err != nil {
+		return err
+	}
+	var previousTotal int64
+	err = dbQueryRow(ctx, "SELECT total_clicks FROM snapshots WHERE week < ? ORDER BY week DESC LIMIT 1", week).Scan(&previousTotal)
+	switch {
+	case err == nil:
+		s.Clicks = max(s.TotalClicks-previousTotal, 0)
+	case err == sql.ErrNoRows:
+		err = dbQueryRow(ctx, "SELECT COUNT(*) FROM visitors WHERE path LIKE '/s/%' AND timestamp >= ? AND timestamp < ?",
+			from, to).Scan(&s.Clicks)
+		if err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	_, err = dbExec(ctx, `
+		INSERT OR IGNORE INTO snapshots (week, views, unique_visitors, clicks, total_clicks, messages, urls_created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.Week, s.Views, s.UniqueVisitors, s.Clicks, s.TotalClicks, s.Messages, s.URLsCreated)
+	if err == nil {
+		log.Printf("Weekly snapshot taken for week of %s", week)
+	}
+	return err
+}
+
+// The two most recent snapshots, newest first; previous is nil until a
+// second week has been snapshotted, and both are nil before the first
+func getLatestSnapshots(ctx context.Context) (latest, previous *WeeklySnapshot, err error) {
+	rows, err := dbQuery(ctx, `
+		SELECT week, views, unique_visitors, clicks, total_clicks, messages, urls_created, taken_at
+		FROM snapshots ORDER BY week DESC LIMIT 2`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*WeeklySnapshot
+	for rows.Next() {
+		var s WeeklySnapshot
+		err := rows.Scan(&s.Week, &s.Views, &s.UniqueVisitors, &s.Clicks, &s.TotalClicks, &s.Messages, &s.URLsCreated, &s.TakenAt)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, &s)
+	}
+	if len(snapshots) > 0 {
+		latest = snapshots[0]
+	}
+	if len(snapshots) > 1 {
+		previous = snapshots[1]
+	}
+	return latest, previous, rows.Err()
+}
+
+// Figures for the dashboard card, comparing the latest week with the one
+// before (zero when there is no earlier snapshot)
+func snapshotDeltas(latest, previous *WeeklySnapshot) []SnapshotDelta {
+	if previous == nil {
+		previous = &WeeklySnapshot{}
+	}
+	return []SnapshotDelta{
+		{"Views", latest.Views, previous.Views, "/admin/visitors"},
+		{"Visitors", latest.UniqueVisitors, previous.UniqueVisitors, "/admin/visitors"},
+		{"Clicks", latest.Clicks, previous.Clicks, "/admin/urls"},
+		{"New links", latest.URLsCreated, previous.URLsCreated, "/admin/urls"},
+		{"Messages", latest.Messages, previous.Messages, "/admin/messages"},
+	}
+}
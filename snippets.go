@@ -0,0 +1,316 @@
+// snippets.go - Admin-edited snippets for template slots
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+	"golang.org/x/net/html"
+)
+
+// Snippet shown wherever its slot shortcode appears
+type Snippet struct {
+	Name      string    `json:"name"`
+	Format    string    `json:"format"`
+	Body      string    `json:"body"` // source as entered
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	snippetMarkdown = "markdown"
+	snippetHTML     = "html"
+)
+
+// Slot shortcode, usable in templates and in post or page content
+var slotShortcode = regexp.MustCompile(`\[\[slot ([a-z0-9-]+)\]\]`)
+
+var snippetNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Initialize snippet storage
+func initSnippets() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS snippets (
+		name TEXT PRIMARY KEY,
+		format TEXT NOT NULL DEFAULT 'markdown',
+		body TEXT NOT NULL DEFAULT '',
+		html TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create snippets table:", err)
+	}
+}
+
+// Render a snippet's source to sanitized HTML; done once, on save
+func renderSnippet(format, body string) string {
+	source := body
+	if format == snippetMarkdown {
+		var buf bytes.Buffer
+		if err := goldmark.Convert([]byte(body), &buf); err != nil {
+			log.Printf("Error rendering snippet: %v", err)
+			return html.EscapeString(body)
+		}
+		source = buf.String()
+	}
+	return sanitizeSnippetHTML(source)
+}
+
+// Elements a snippet may use, with their allowed attributes; class and
+// title are allowed everywhere
+var snippetAllowedTags = map[string][]string{
+	"a": {"href", "target"}, "abbr": nil, "b": nil, "blockquote": nil, "br": nil,
+	"code": nil, "div": nil, "em": nil, "h2": nil, "h3": nil, "h4": nil, "hr": nil,
+	"i": nil, "img": {"src", "alt", "width", "height"}, "li": nil, "ol": nil, "p": nil,
+	"pre": nil, "s": nil, "small": nil, "span": nil, "strong": nil, "u": nil, "ul": nil,
+}
+
+// Elements dropped together with everything inside them
+var snippetDroppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "textarea": true, "select": true, "svg": true, "math": true,
+}
+
+// Keep only allowlisted elements and attributes, and links to http(s),
+// mailto or site paths; everything else is escaped or dropped
+func sanitizeSnippetHTML(source string) string {
+	var out strings.Builder
+	var open []string // allowed elements still open, closed at the end
+	skipping := 0     // depth inside a dropped element
+
+	z := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skipping == 0 {
+				out.WriteString(html.EscapeString(tok.Data))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if snippetDroppedTags[tok.Data] {
+				if tt == html.StartTagToken {
+					skipping++
+				}
+				continue
+			}
+			allowed, ok := snippetAllowedTags[tok.Data]
+			if skipping > 0 || !ok {
+				continue
+			}
+			out.WriteString("<" + tok.Data)
+			for _, attr := range tok.Attr {
+				if !snippetAttrAllowed(attr, allowed) {
+					continue
+				}
+				out.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			if tok.Data == "a" {
+				out.WriteString(` rel="noopener"`)
+			}
+			out.WriteString(">")
+			if tt == html.StartTagToken && tok.Data != "br" && tok.Data != "hr" && tok.Data != "img" {
+				open = append(open, tok.Data)
+			}
+
+		case html.EndTagToken:
+			if snippetDroppedTags[tok.Data] {
+				if skipping > 0 {
+					skipping--
+				}
+				continue
+			}
+			if skipping > 0 {
+				continue
+			}
+			// Close up to the matching element so the output stays balanced
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					out.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+func snippetAttrAllowed(attr html.Attribute, allowed []string) bool {
+	if attr.Namespace != "" {
+		return false
+	}
+	switch attr.Key {
+	case "class", "title":
+		return true
+	case "href", "src":
+		return isSafeSnippetURL(attr.Val)
+	}
+	for _, key := range allowed {
+		if key == attr.Key {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeSnippetURL(value string) bool {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	switch {
+	case strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//"):
+		return true
+	case strings.HasPrefix(value, "#"):
+		return true
+	case strings.HasPrefix(lower, "mailto:"):
+		return true
+	}
+	return isValidLongURL(value) // from main.go
+}
+
+// Replace slot shortcodes with the site's enabled snippets; unknown or
+// disabled slots render as nothing
+func expandSlots(ctx context.Context, page []byte) []byte {
+	if !bytes.Contains(page, []byte("[[slot ")) {
+		return page
+	}
+
+	snippets := map[string]string{}
+	rows, err := dbQuery(ctx, "SELECT name, html FROM snippets WHERE enabled = 1")
+	if err != nil {
+		log.Printf("Error loading snippets: %v", err)
+	} else {
+		for rows.Next() {
+			var name, rendered string
+			if rows.Scan(&name, &rendered) == nil {
+				snippets[name] = rendered
+			}
+		}
+		rows.Close()
+	}
+
+	return slotShortcode.ReplaceAllFunc(page, func(match []byte) []byte {
+		name := string(slotShortcode.FindSubmatch(match)[1])
+		if rendered, ok := snippets[name]; ok {
+			return []byte(`<div class="snippet snippet-` + name + `">` + rendered + `</div>`)
+		}
+		return nil
+	})
+}
+
+// All snippets, by name
+func getSnippets(ctx context.Context) ([]Snippet, error) {
+	rows, err := dbQuery(ctx, "SELECT name, format, body, enabled, updated_at FROM snippets ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		var s Snippet
+		if err := rows.Scan(&s.Name, &s.Format, &s.Body, &s.Enabled, &s.UpdatedAt); err != nil {
+			continue
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets, rows.Err()
+}
+
+// Setup admin snippet routes
+func setupSnippetAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/snippets", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		snippets, err := getSnippets(ctx)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load snippets",
+			})
+			return
+		}
+
+		// Editing fills the form with an existing snippet
+		editing := Snippet{Format: snippetMarkdown, Enabled: true}
+		for _, s := range snippets {
+			if s.Name == c.Query("edit") {
+				editing = s
+			}
+		}
+
+		c.HTML(http.StatusOK, "admin-snippets.html", gin.H{
+			"snippets": snippets,
+			"editing":  editing,
+		})
+	})
+
+	// Create or replace a snippet
+	adminGroup.POST("/snippets", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		name := strings.ToLower(strings.TrimSpace(c.PostForm("name")))
+		format := c.PostForm("format")
+		body := c.PostForm("body")
+		enabled := c.PostForm("enabled") == "1"
+
+		if !snippetNamePattern.MatchString(name) {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Snippet names use lowercase letters, digits and dashes",
+			})
+			return
+		}
+		if format != snippetHTML {
+			format = snippetMarkdown
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO snippets (name, format, body, html, enabled, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET format = excluded.format, body = excluded.body,
+				html = excluded.html, enabled = excluded.enabled, updated_at = excluded.updated_at
+		`, name, format, body, renderSnippet(format, body), enabled, time.Now())
+		if err != nil {
+			log.Printf("Error saving snippet: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save snippet",
+			})
+			return
+		}
+
+		log.Printf("Snippet %s saved by admin from %s", name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/snippets")
+	})
+
+	adminGroup.DELETE("/snippets/:name", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM snippets WHERE name = ?", c.Param("name"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete snippet"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Snippet not found"})
+			return
+		}
+
+		log.Printf("Snippet %s deleted by admin from %s", c.Param("name"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Snippet deleted"})
+	})
+}
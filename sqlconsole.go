@@ -0,0 +1,232 @@
+// sqlconsole.go - Read-only SQL console for ad-hoc analytics questions
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sqlConsoleMaxRows     = 500   // shown on the page
+	sqlConsoleMaxCSVRows  = 10000 // in a CSV export
+	sqlConsoleTimeout     = 5 * time.Second
+	sqlConsoleMaxQuery    = 10000 // characters
+	sqlConsoleAuditLength = 1000  // characters of the query kept in the audit log
+	sqlConsoleHistory     = 20
+)
+
+// Result of a console query; values are formatted for display
+type SQLResult struct {
+	Columns   []string
+	Rows      [][]string
+	Truncated bool // more rows than the limit
+	Duration  time.Duration
+}
+
+// Check a console query is a single SELECT (or WITH ... SELECT) statement,
+// returning it without a trailing semicolon
+func validateConsoleQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	if query == "" {
+		return "", errors.New("Enter a query")
+	}
+	if len(query) > sqlConsoleMaxQuery {
+		return "", fmt.Errorf("Queries are limited to %d characters", sqlConsoleMaxQuery)
+	}
+	if strings.Contains(query, ";") {
+		return "", errors.New("Only one statement can be run at a time")
+	}
+	first := strings.ToUpper(strings.Fields(query)[0])
+	if first != "SELECT" && first != "WITH" {
+		return "", errors.New("Only SELECT statements can be run")
+	}
+	return query, nil
+}
+
+// Run a query on the site's database, on a connection of its own set to
+// query_only, so even a statement that gets past validateConsoleQuery
+// can't write. The connection is thrown away afterwards instead of going
+// back to the pool read-only.
+func runConsoleQuery(ctx context.Context, query string, maxRows int) (*SQLResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, sqlConsoleTimeout)
+	defer cancel()
+
+	conn, err := dbFor(ctx).Conn(ctx) // from sites.go
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer conn.Raw(func(any) error { return driver.ErrBadConn })
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, consoleQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	result := &SQLResult{}
+	if result.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	values := make([]any, len(result.Columns))
+	pointers := make([]any, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = formatSQLValue(v)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, consoleQueryError(ctx, err)
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond)
+	return result, nil
+}
+
+// Report a query stopped by the timeout as such, not as the interrupt error
+func consoleQueryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("Query took longer than %s and was stopped", sqlConsoleTimeout)
+	}
+	return err
+}
+
+func formatSQLValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Record a console query in the security audit log
+func auditConsoleQuery(c *gin.Context, query, action string) {
+	detail := query
+	if len(detail) > sqlConsoleAuditLength {
+		detail = detail[:sqlConsoleAuditLength] + "..."
+	}
+	ipHash := hashIP(c.ClientIP())
+	recordSecurityEvent(c.Request.Context(), eventSQLQuery, ipHash, detail) // from security.go
+	log.Printf("SQL console %s by admin from %s", action, ipHash)
+}
+
+// Recently run queries, newest first, for running again
+func getConsoleHistory(ctx context.Context) ([]SecurityEvent, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, event, COALESCE(ip_hash, ''), COALESCE(detail, ''), created_at
+		FROM security_events WHERE event = ?
+		ORDER BY id DESC LIMIT ?
+	`, eventSQLQuery, sqlConsoleHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []SecurityEvent
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Event, &e.IPHash, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+// Setup the admin SQL console routes
+func setupSQLConsoleAdminRoutes(adminGroup *gin.RouterGroup) {
+	render := func(c *gin.Context, status int, query string, result *SQLResult, queryErr error) {
+		history, err := getConsoleHistory(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading SQL console history: %v", err)
+		}
+		data := gin.H{
+			"query":   query,
+			"result":  result,
+			"history": history,
+			"maxRows": sqlConsoleMaxRows,
+			"timeout": sqlConsoleTimeout,
+		}
+		if queryErr != nil {
+			data["error"] = queryErr.Error()
+		}
+		c.HTML(status, "admin-sql.html", data)
+	}
+
+	adminGroup.GET("/sql", func(c *gin.Context) {
+		render(c, http.StatusOK, c.Query("q"), nil, nil)
+	})
+
+	adminGroup.POST("/sql", func(c *gin.Context) {
+		query, err := validateConsoleQuery(c.PostForm("query"))
+		if err != nil {
+			render(c, http.StatusBadRequest, c.PostForm("query"), nil, err)
+			return
+		}
+
+		auditConsoleQuery(c, query, "query")
+		result, err := runConsoleQuery(c.Request.Context(), query, sqlConsoleMaxRows)
+		if err != nil {
+			render(c, http.StatusBadRequest, query, nil, err)
+			return
+		}
+		render(c, http.StatusOK, query, result, nil)
+	})
+
+	adminGroup.POST("/sql/export.csv", func(c *gin.Context) {
+		query, err := validateConsoleQuery(c.PostForm("query"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		auditConsoleQuery(c, query, "CSV export")
+		result, err := runConsoleQuery(c.Request.Context(), query, sqlConsoleMaxCSVRows)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="query-`+strconv.FormatInt(time.Now().Unix(), 10)+`.csv"`)
+		w := csv.NewWriter(c.Writer)
+		w.Write(result.Columns)
+		w.WriteAll(result.Rows)
+		if result.Truncated {
+			w.Write([]string{fmt.Sprintf("(truncated at %d rows)", sqlConsoleMaxCSVRows)})
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,43 @@
+// sqlconsole_test.go - Console query validation
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConsoleQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string // the query as it's run; empty when rejected
+	}{
+		{"select", "SELECT * FROM urls", "SELECT * FROM urls"},
+		{"lower case", "select count(*) from visitors", "select count(*) from visitors"},
+		{"trailing semicolon", "  SELECT 1;  ", "SELECT 1"},
+		{"with", "WITH t AS (SELECT 1) SELECT * FROM t", "WITH t AS (SELECT 1) SELECT * FROM t"},
+		{"leading newline", "\nSELECT 1", "SELECT 1"},
+		{"empty", "", ""},
+		{"only semicolon", ";", ""},
+		{"update", "UPDATE urls SET clicks = 0", ""},
+		{"delete", "DELETE FROM urls", ""},
+		{"pragma", "PRAGMA writable_schema = ON", ""},
+		{"attach", "ATTACH DATABASE '/tmp/x.db' AS x", ""},
+		// A second statement tacked onto a valid one
+		{"stacked statement", "SELECT 1; DROP TABLE urls", ""},
+		{"stacked after semicolon", "SELECT 1;; DELETE FROM urls;", ""},
+		{"too long", "SELECT '" + strings.Repeat("a", sqlConsoleMaxQuery) + "'", ""},
+	}
+	for _, tt := range tests {
+		got, err := validateConsoleQuery(tt.query)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: %q accepted as %q", tt.name, tt.query, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: validateConsoleQuery = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
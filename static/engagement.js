@@ -0,0 +1,60 @@
+// static/engagement.js - Engagement pings: visible time and scroll depth for
+// this page, under a random id kept only for this tab's session
+(function () {
+    var key = 'engagement-session';
+    var session = sessionStorage.getItem(key);
+    if (!session) {
+        var bytes = new Uint8Array(16);
+        crypto.getRandomValues(bytes);
+        session = Array.prototype.map.call(bytes, function (b) { return ('0' + b.toString(16)).slice(-2); }).join('');
+        sessionStorage.setItem(key, session);
+    }
+
+    var visibleMs = 0;
+    var visibleSince = document.visibilityState === 'visible' ? Date.now() : null;
+    var depth = 0;
+
+    function measureScroll() {
+        var doc = document.documentElement;
+        var scrollable = doc.scrollHeight - window.innerHeight;
+        var percent = scrollable > 0 ? (window.scrollY / scrollable) * 100 : 100;
+        depth = Math.max(depth, Math.floor(percent / 25) * 25);
+    }
+
+    function visibleSeconds() {
+        var total = visibleMs + (visibleSince ? Date.now() - visibleSince : 0);
+        return Math.round(total / 1000);
+    }
+
+    function ping() {
+        var body = new URLSearchParams({
+            session: session,
+            path: location.pathname,
+            visible: String(visibleSeconds()),
+            scroll: String(depth)
+        });
+        if (!navigator.sendBeacon || !navigator.sendBeacon('/api/engagement', body)) {
+            fetch('/api/engagement', { method: 'POST', body: body, keepalive: true }).catch(function () {});
+        }
+    }
+
+    document.addEventListener('visibilitychange', function () {
+        if (document.visibilityState === 'visible') {
+            visibleSince = Date.now();
+        } else {
+            if (visibleSince) {
+                visibleMs += Date.now() - visibleSince;
+                visibleSince = null;
+            }
+            ping();
+        }
+    });
+    window.addEventListener('scroll', measureScroll, { passive: true });
+    measureScroll();
+
+    setInterval(function () {
+        if (visibleSince) {
+            ping();
+        }
+    }, 15000);
+})();
@@ -0,0 +1,102 @@
+// static/secret.js - Encrypts one-time secrets in the browser and decrypts
+// them on reveal. The AES-GCM key travels in the link's #fragment, which is
+// never sent to the server.
+(function () {
+    function toBase64URL(bytes) {
+        var binary = '';
+        bytes.forEach(function (b) { binary += String.fromCharCode(b); });
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function fromBase64URL(text) {
+        var binary = atob(text.replace(/-/g, '+').replace(/_/g, '/'));
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function showError(message) {
+        var error = document.getElementById('secret-error');
+        error.textContent = message;
+        error.hidden = false;
+    }
+
+    function readError(response) {
+        return response.json().then(function (body) {
+            throw new Error(body.error || 'Something went wrong');
+        }, function () {
+            throw new Error('Something went wrong (' + response.status + ')');
+        });
+    }
+
+    var form = document.getElementById('secret-form');
+    if (form) {
+        form.addEventListener('submit', function (event) {
+            event.preventDefault();
+            document.getElementById('secret-error').hidden = true;
+            var text = document.getElementById('secret-text').value;
+            var hours = parseInt(document.getElementById('secret-hours').value, 10);
+            var iv = crypto.getRandomValues(new Uint8Array(12));
+            var key, rawKey;
+
+            crypto.subtle.generateKey({ name: 'AES-GCM', length: 256 }, true, ['encrypt']).then(function (k) {
+                key = k;
+                return crypto.subtle.exportKey('raw', key);
+            }).then(function (raw) {
+                rawKey = new Uint8Array(raw);
+                return crypto.subtle.encrypt({ name: 'AES-GCM', iv: iv }, key, new TextEncoder().encode(text));
+            }).then(function (sealed) {
+                var payload = new Uint8Array(iv.length + sealed.byteLength);
+                payload.set(iv);
+                payload.set(new Uint8Array(sealed), iv.length);
+                return fetch('/secret', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ ciphertext: toBase64URL(payload), hours: hours })
+                });
+            }).then(function (response) {
+                return response.ok ? response.json() : readError(response);
+            }).then(function (body) {
+                document.getElementById('secret-text').value = '';
+                document.getElementById('secret-link').value = body.url + '#' + toBase64URL(rawKey);
+                document.getElementById('secret-result').hidden = false;
+            }).catch(function (err) {
+                showError(err.message);
+            });
+        });
+    }
+
+    var reveal = document.getElementById('secret-reveal');
+    if (reveal) {
+        document.getElementById('secret-reveal-button').addEventListener('click', function () {
+            var button = this;
+            var keyText = location.hash.slice(1);
+            if (!keyText) {
+                showError('This link is missing its key; ask for the full link, including the part after #.');
+                return;
+            }
+            button.disabled = true;
+
+            fetch('/secret/' + encodeURIComponent(reveal.dataset.code), { method: 'POST' }).then(function (response) {
+                return response.ok ? response.json() : readError(response);
+            }).then(function (body) {
+                var payload = fromBase64URL(body.ciphertext);
+                return crypto.subtle.importKey('raw', fromBase64URL(keyText), 'AES-GCM', false, ['decrypt']).then(function (key) {
+                    return crypto.subtle.decrypt({ name: 'AES-GCM', iv: payload.slice(0, 12) }, key, payload.slice(12));
+                }).catch(function () {
+                    throw new Error('The secret couldn\'t be decrypted; the link may be incomplete. It has now been deleted.');
+                });
+            }).then(function (plain) {
+                var output = document.getElementById('secret-plaintext');
+                output.value = new TextDecoder().decode(plain);
+                output.hidden = false;
+                button.hidden = true;
+                history.replaceState(null, '', location.pathname);
+            }).catch(function (err) {
+                showError(err.message);
+            });
+        });
+    }
+})();
@@ -0,0 +1,63 @@
+// statscache.go - Caching for heavy dashboard queries
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Dashboard numbers may be this stale. Several tabs polling the dashboard,
+// the stats API and share links all read the same result in that time.
+const dashboardCacheTTL = 5 * time.Second
+
+var (
+	dashboardFlights singleflight.Group
+	dashboardCacheMu sync.Mutex
+	dashboardCache   = make(map[string]dashboardCacheEntry)
+)
+
+type dashboardCacheEntry struct {
+	value    any
+	loadedAt time.Time
+}
+
+// Run a dashboard query once for everyone asking at the same time, and
+// reuse its result for a few seconds. Results are per site and shared, so
+// callers must not modify them.
+func cachedDashboardQuery[T any](ctx context.Context, name string, load func(context.Context) (T, error)) (T, error) {
+	key := strconv.Itoa(siteFromContext(ctx).ID) + ":" + name // from sites.go
+
+	dashboardCacheMu.Lock()
+	entry, ok := dashboardCache[key]
+	dashboardCacheMu.Unlock()
+	if ok && time.Since(entry.loadedAt) < dashboardCacheTTL {
+		return entry.value.(T), nil
+	}
+
+	value, err, _ := dashboardFlights.Do(key, func() (any, error) {
+		// Shared by every waiting request, so one of them giving up
+		// doesn't fail the others
+		value, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return value, err
+		}
+		dashboardCacheMu.Lock()
+		defer dashboardCacheMu.Unlock()
+		for k, e := range dashboardCache {
+			if time.Since(e.loadedAt) >= dashboardCacheTTL {
+				delete(dashboardCache, k)
+			}
+		}
+		dashboardCache[key] = dashboardCacheEntry{value, time.Now()}
+		return value, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return value.(T), nil
+}
@@ -0,0 +1,380 @@
+// status.go - Uptime checks and public status page
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often every project is checked
+const statusCheckInterval = 5 * time.Minute
+
+// Failed checks in a row before a project counts as down, so one slow
+// response doesn't send an alert
+const statusDownAfter = 2
+
+// Check history kept for uptime figures
+const statusHistoryDays = 30
+
+// Most of a response body searched for the expected text
+const statusBodyMaxBytes = 256 << 10
+
+// Project states
+const (
+	statusUnknown = "unknown" // not checked yet
+	statusUp      = "up"
+	statusDown    = "down"
+)
+
+var statusClient = &http.Client{Timeout: 15 * time.Second}
+
+// Monitored project and its latest result
+type StatusProject struct {
+	ID             int
+	Name           string
+	URL            string
+	ExpectedStatus int
+	ExpectedText   string
+	Status         string
+	StatusSince    *time.Time
+	LastCheckedAt  *time.Time
+	LatencyMs      int
+	LastError      string
+	Failures       int
+	Checks         int     // over the history window
+	Uptime         float64 // percent of Checks that passed
+}
+
+// Uptime as "99.95%", or "-" before the first check
+func (p StatusProject) UptimePercent() string {
+	if p.Checks == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(p.Uptime, 'f', 2, 64) + "%"
+}
+
+// Initialize status check storage and start the checker
+func initStatus() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS status_projects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		expected_status INTEGER NOT NULL DEFAULT 200,
+		expected_text TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'unknown',
+		status_since DATETIME,
+		last_checked_at DATETIME,
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		failures INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS status_checks (
+		project_id INTEGER NOT NULL,
+		checked_at DATETIME NOT NULL,
+		up INTEGER NOT NULL,
+		latency_ms INTEGER NOT NULL
+	)`,
+		`CREATE INDEX IF NOT EXISTS idx_status_checks_project ON status_checks(project_id, checked_at)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create status tables:", err)
+		}
+	}
+
+	startWorker("status-checks", statusWorker) // from safego.go
+}
+
+// Check every site's projects on an interval
+func statusWorker() {
+	ticker := time.NewTicker(statusCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := runStatusChecks(ctx); siteErr != nil {
+				log.Printf("Error running status checks for %s: %v", siteFromContext(ctx).Name, siteErr)
+				err = siteErr
+			}
+		})
+		recordJobRun("status-checks", statusCheckInterval, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Projects with their uptime over the history window
+func getStatusProjects(ctx context.Context) ([]StatusProject, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT p.id, p.name, p.url, p.expected_status, p.expected_text, p.status, p.status_since,
+			p.last_checked_at, p.latency_ms, p.last_error, p.failures,
+			COUNT(c.up), COALESCE(AVG(c.up) * 100, 0)
+		FROM status_projects p
+		LEFT JOIN status_checks c ON c.project_id = p.id AND c.checked_at >= ?
+		GROUP BY p.id ORDER BY p.name COLLATE NOCASE
+	`, time.Now().AddDate(0, 0, -statusHistoryDays))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []StatusProject
+	for rows.Next() {
+		var p StatusProject
+		err := rows.Scan(&p.ID, &p.Name, &p.URL, &p.ExpectedStatus, &p.ExpectedText, &p.Status, &p.StatusSince,
+			&p.LastCheckedAt, &p.LatencyMs, &p.LastError, &p.Failures, &p.Checks, &p.Uptime)
+		if err != nil {
+			continue
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// Request a project's URL and compare the response with its expectations;
+// problem is empty when it passed
+func checkStatusProject(ctx context.Context, p StatusProject) (latency time.Duration, problem string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("User-Agent", "zachkp.dev status check")
+
+	started := time.Now()
+	resp, err := statusClient.Do(req)
+	if err != nil {
+		return time.Since(started), err.Error()
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, statusBodyMaxBytes))
+	latency = time.Since(started)
+
+	if resp.StatusCode != p.ExpectedStatus {
+		return latency, fmt.Sprintf("status %d, expected %d", resp.StatusCode, p.ExpectedStatus)
+	}
+	if err != nil {
+		return latency, "reading response: " + err.Error()
+	}
+	if p.ExpectedText != "" && !bytes.Contains(body, []byte(p.ExpectedText)) {
+		return latency, fmt.Sprintf("response doesn't contain %q", p.ExpectedText)
+	}
+	return latency, ""
+}
+
+// Check every project once, record the results and notify the owner when
+// a project goes down or comes back
+func runStatusChecks(ctx context.Context) error {
+	projects, err := getStatusProjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range projects {
+		latency, problem := checkStatusProject(ctx, p)
+		now := time.Now()
+
+		failures, status := 0, statusUp
+		if problem != "" {
+			failures, status = p.Failures+1, p.Status
+			if failures >= statusDownAfter {
+				status = statusDown
+			}
+		}
+		since := p.StatusSince
+		if status != p.Status {
+			since = &now
+		}
+
+		_, err := dbExec(ctx, "INSERT INTO status_checks (project_id, checked_at, up, latency_ms) VALUES (?, ?, ?, ?)",
+			p.ID, now, problem == "", latency.Milliseconds())
+		if err == nil {
+			_, err = dbExec(ctx, `
+				UPDATE status_projects SET status = ?, status_since = ?, last_checked_at = ?, latency_ms = ?,
+					last_error = ?, failures = ? WHERE id = ?
+			`, status, since, now, latency.Milliseconds(), problem, failures, p.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if status != p.Status && (status == statusDown || p.Status == statusDown) {
+			notifyStatusChange(ctx, p, status, problem)
+		}
+	}
+
+	_, err = dbExec(ctx, "DELETE FROM status_checks WHERE checked_at < ?", time.Now().AddDate(0, 0, -statusHistoryDays))
+	return err
+}
+
+// Email the owner about a project going down or recovering
+func notifyStatusChange(ctx context.Context, p StatusProject, status, problem string) {
+	subject := fmt.Sprintf("%s is down", p.Name)
+	body := fmt.Sprintf("%s (%s) failed %d checks in a row.\n\nLast error: %s\n", p.Name, p.URL, statusDownAfter, problem)
+	if status == statusUp {
+		subject = fmt.Sprintf("%s is back up", p.Name)
+		body = fmt.Sprintf("%s (%s) is responding again.\n", p.Name, p.URL)
+		if p.StatusSince != nil {
+			body += fmt.Sprintf("\nIt was down for %s.\n", time.Since(*p.StatusSince).Round(time.Minute))
+		}
+	}
+
+	if err := sendOwnerEmail(ctx, emailKindStatus, subject, loadSMTPSettings().User, body); err != nil { // from main.go
+		log.Printf("Error sending status notification for %s: %v", p.Name, err)
+	}
+}
+
+// Project from the admin form, or a problem to show
+func statusProjectFromForm(c *gin.Context) (StatusProject, string) {
+	p := StatusProject{
+		Name:         strings.TrimSpace(c.PostForm("name")),
+		URL:          strings.TrimSpace(c.PostForm("url")),
+		ExpectedText: strings.TrimSpace(c.PostForm("expected_text")),
+	}
+	if p.Name == "" {
+		return p, "Name is required"
+	}
+	if !isValidLongURL(p.URL) { // from main.go
+		return p, "URL must be an absolute http(s) URL"
+	}
+	p.ExpectedStatus = http.StatusOK
+	if raw := strings.TrimSpace(c.PostForm("expected_status")); raw != "" {
+		code, err := strconv.Atoi(raw)
+		if err != nil || code < 100 || code > 599 {
+			return p, "Expected status must be an HTTP status code"
+		}
+		p.ExpectedStatus = code
+	}
+	return p, ""
+}
+
+// Setup the public status page
+func setupStatusRoutes(r *gin.Engine) {
+	r.GET("/projects/status", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		projects, err := getStatusProjects(ctx)
+		if err != nil {
+			log.Printf("Error loading status projects: %v", err)
+		}
+
+		down := 0
+		for _, p := range projects {
+			if p.Status == statusDown {
+				down++
+			}
+		}
+		c.HTML(http.StatusOK, "projects-status.html", gin.H{
+			"title":      "Project Status",
+			"projects":   projects,
+			"down":       down,
+			"days":       statusHistoryDays,
+			"seo":        getPageSEO(ctx, "/projects/status"),
+			"viewCounts": viewCountsEnabled(), // from viewcounts.go
+		})
+	})
+}
+
+// Setup admin status routes
+func setupStatusAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/status", func(c *gin.Context) {
+		projects, err := getStatusProjects(c.Request.Context())
+		if err != nil {
+			log.Printf("Error loading status projects: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load projects",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-status.html", gin.H{
+			"projects": projects,
+			"interval": statusCheckInterval,
+			"message":  c.Query("message"),
+		})
+	})
+
+	adminGroup.POST("/status", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		p, problem := statusProjectFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, "INSERT INTO status_projects (name, url, expected_status, expected_text) VALUES (?, ?, ?, ?)",
+			p.Name, p.URL, p.ExpectedStatus, p.ExpectedText)
+		if err != nil {
+			log.Printf("Error saving status project: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save project",
+			})
+			return
+		}
+
+		log.Printf("Status project %s added by admin from %s", p.Name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/status")
+	})
+
+	// Edit expectations; the status and history are kept
+	adminGroup.POST("/status/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		p, problem := statusProjectFromForm(c)
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, "UPDATE status_projects SET name = ?, url = ?, expected_status = ?, expected_text = ? WHERE id = ?",
+			p.Name, p.URL, p.ExpectedStatus, p.ExpectedText, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating status project: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save project",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/status")
+	})
+
+	// Run the checks now instead of waiting for the next interval
+	adminGroup.POST("/status/check", func(c *gin.Context) {
+		message := "Checked every project"
+		if err := runStatusChecks(c.Request.Context()); err != nil {
+			log.Printf("Error running status checks: %v", err)
+			message = "Checks failed: " + err.Error()
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/status?message="+url.QueryEscape(message))
+	})
+
+	adminGroup.DELETE("/status/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM status_projects WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		dbExec(ctx, "DELETE FROM status_checks WHERE project_id = ?", c.Param("id"))
+
+		log.Printf("Status project %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Project deleted"})
+	})
+}
@@ -0,0 +1,338 @@
+// stripe.go - Stripe Checkout invoice payments
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Encrypted settings holding the keys (from securesettings.go); the
+// STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET environment variables win
+const (
+	stripeSecretKeySetting     = "stripe_secret_key"
+	stripeWebhookSecretSetting = "stripe_webhook_secret"
+)
+
+const stripeAPIBase = "https://api.stripe.com/v1"
+
+// Oldest webhook signature timestamp accepted, against replays
+const stripeMaxSignatureAge = 5 * time.Minute
+
+// Checkout sessions each client may start per minute
+const stripeCheckoutRateLimit = 5
+
+var stripeCheckoutLimiter = newRateLimiter[eventRateKey]() // from api.go, events.go
+
+var stripeClient = newHTTPClient("stripe", 15*time.Second) // from httpclient.go
+
+func stripeSecretKey() (string, error) {
+	return envOrEncryptedSetting("STRIPE_SECRET_KEY", stripeSecretKeySetting)
+}
+
+func stripeWebhookSecret() (string, error) {
+	return envOrEncryptedSetting("STRIPE_WEBHOOK_SECRET", stripeWebhookSecretSetting)
+}
+
+// How online payment is set up, for the admin
+type StripeStatus struct {
+	Enabled        bool // a secret key is available
+	WebhookEnabled bool // payments are marked automatically
+	FromEnv        bool // keys come from the environment, not the form
+	Encryption     bool // keys can be saved in the admin
+	WebhookURL     string
+	Problem        string
+}
+
+func getStripeStatus(c *gin.Context) StripeStatus {
+	status := StripeStatus{
+		FromEnv:    os.Getenv("STRIPE_SECRET_KEY") != "" || os.Getenv("STRIPE_WEBHOOK_SECRET") != "",
+		Encryption: settingsEncryptionConfigured(),
+		WebhookURL: strings.TrimSuffix(buildShortURL(c, ""), "/s/") + "/stripe/webhook", // from main.go
+	}
+	key, err := stripeSecretKey()
+	if err == nil {
+		var secret string
+		secret, err = stripeWebhookSecret()
+		status.WebhookEnabled = secret != ""
+	}
+	if err != nil {
+		status.Problem = err.Error()
+	}
+	status.Enabled = key != ""
+	return status
+}
+
+// Link that starts online payment of an unpaid invoice, "" when Stripe
+// isn't set up. token is the invoice's signed link token.
+func invoicePayURL(c *gin.Context, inv Invoice, token string) string {
+	if inv.PaidAt.Valid {
+		return ""
+	}
+	if key, err := stripeSecretKey(); err != nil || key == "" {
+		return ""
+	}
+	return buildInvoiceURL(c, token) + "/pay" // from invoices.go
+}
+
+// Start a Checkout session for an invoice's total; returns its id and the
+// page to send the client to. Clients come back to returnURL either way.
+func createStripeCheckout(ctx context.Context, key string, inv Invoice, returnURL string) (string, string, error) {
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"success_url":                            {returnURL},
+		"cancel_url":                             {returnURL},
+		"client_reference_id":                    {strconv.Itoa(inv.ID)},
+		"metadata[invoice_id]":                   {strconv.Itoa(inv.ID)},
+		"metadata[site]":                         {strconv.Itoa(siteFromContext(ctx).ID)},
+		"line_items[0][quantity]":                {"1"},
+		"line_items[0][price_data][currency]":    {strings.ToLower(inv.Currency)},
+		"line_items[0][price_data][unit_amount]": {strconv.FormatInt(inv.TotalCents(), 10)},
+		"line_items[0][price_data][product_data][name]": {"Invoice " + inv.Number()},
+	}
+	if inv.ClientEmail != "" {
+		form.Set("customer_email", inv.ClientEmail)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeAPIBase+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Retrying a request that timed out doesn't start a second session
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("invoice-%d-%d-%d", siteFromContext(ctx).ID, inv.ID, time.Now().Unix()/60))
+
+	resp, err := stripeClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var session struct {
+		ID    string `json:"id"`
+		URL   string `json:"url"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&session); err != nil {
+		return "", "", fmt.Errorf("stripe returned %d: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 || session.URL == "" {
+		return "", "", fmt.Errorf("stripe returned %d: %s", resp.StatusCode, session.Error.Message)
+	}
+	return session.ID, session.URL, nil
+}
+
+// Check a Stripe-Signature header ("t=...,v1=...") against the raw body
+func verifyStripeSignature(header string, body []byte, secret string) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > stripeMaxSignatureAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := []byte(hex.EncodeToString(mac.Sum(nil)))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// The parts of a Checkout session event we read
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID            string            `json:"id"`
+			PaymentStatus string            `json:"payment_status"`
+			Metadata      map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// Setup the client's pay link and Stripe's webhook
+func setupStripeRoutes(r *gin.Engine) {
+	r.GET("/invoices/:token/pay", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+		ipHash := hashIP(c.ClientIP())
+
+		if !stripeCheckoutLimiter.Allow(eventRateKey{siteFromContext(ctx).ID, ipHash}, stripeCheckoutRateLimit) {
+			c.Header("Retry-After", "60")
+			c.String(http.StatusTooManyRequests, "Too many requests; please try again in a minute.")
+			return
+		}
+
+		id, ok := parseSignedLinkToken(ctx, "invoice:", c.Param("token")) // from sharelinks.go
+		var inv Invoice
+		var err error
+		if ok {
+			inv, err = getInvoice(ctx, id) // from invoices.go
+		}
+		if !ok || err != nil {
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "invoice pay link", ipHash, time.Minute) // from security.go
+			c.String(http.StatusNotFound, "This invoice link has expired or the invoice was withdrawn.")
+			return
+		}
+
+		invoiceURL := buildInvoiceURL(c, c.Param("token"))
+		if inv.PaidAt.Valid {
+			c.Redirect(http.StatusSeeOther, invoiceURL)
+			return
+		}
+		key, err := stripeSecretKey()
+		if err != nil || key == "" {
+			if err != nil {
+				log.Printf("Error reading the Stripe key: %v", err)
+			}
+			c.String(http.StatusNotFound, "Online payment isn't available for this invoice; please use the payment details on it.")
+			return
+		}
+
+		sessionID, checkoutURL, err := createStripeCheckout(ctx, key, inv, invoiceURL)
+		if err != nil {
+			log.Printf("Error starting Stripe checkout for invoice %d: %v", inv.ID, err)
+			c.String(http.StatusBadGateway, "Online payment is unavailable right now; please try again later or use the payment details on the invoice.")
+			return
+		}
+		if _, err := dbExec(ctx, "UPDATE invoices SET stripe_session_id = ? WHERE id = ?", sessionID, inv.ID); err != nil {
+			log.Printf("Error saving Stripe session for invoice %d: %v", inv.ID, err)
+		}
+		c.Redirect(http.StatusSeeOther, checkoutURL)
+	})
+
+	// Stripe retries until it gets a 2xx, so anything we can't use is still
+	// acknowledged; only bad signatures and our own failures aren't
+	r.POST("/stripe/webhook", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		secret, err := stripeWebhookSecret()
+		if err != nil || secret == "" {
+			if err != nil {
+				log.Printf("Error reading the Stripe webhook secret: %v", err)
+			}
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		if !verifyStripeSignature(c.GetHeader("Stripe-Signature"), body, secret) {
+			ipHash := hashIP(c.ClientIP())
+			recordSecurityEventThrottled(ctx, eventTokenInvalid, ipHash, "stripe webhook", ipHash, time.Minute)
+			c.Status(http.StatusBadRequest)
+			return
+		}
+
+		var event stripeEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		session := event.Data.Object
+		paid := (event.Type == "checkout.session.completed" && session.PaymentStatus == "paid") ||
+			event.Type == "checkout.session.async_payment_succeeded"
+		// Every site's endpoint hears every payment; each takes its own
+		if !paid || session.Metadata["site"] != strconv.Itoa(siteFromContext(ctx).ID) {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		id, err := strconv.Atoi(session.Metadata["invoice_id"])
+		if err != nil {
+			c.Status(http.StatusOK)
+			return
+		}
+		_, err = dbExec(ctx, "UPDATE invoices SET paid_at = COALESCE(paid_at, ?), paid_via = 'stripe', stripe_session_id = ? WHERE id = ?",
+			time.Now(), session.ID, id)
+		if err != nil {
+			log.Printf("Error marking invoice %d paid: %v", id, err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Invoice %d paid through Stripe (%s)", id, event.ID)
+		c.Status(http.StatusOK)
+	})
+}
+
+// Setup the admin form for the Stripe keys
+func setupStripeAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Like other deployment-wide settings, the keys belong to the primary site
+	adminGroup.POST("/invoices/stripe", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		fields := [][2]string{
+			{stripeSecretKeySetting, strings.TrimSpace(c.PostForm("secret_key"))},
+			{stripeWebhookSecretSetting, strings.TrimSpace(c.PostForm("webhook_secret"))},
+		}
+		message := "Stripe keys saved"
+		if c.PostForm("remove") == "1" {
+			fields[0][1], fields[1][1], message = "", "", "Stripe keys removed"
+		} else {
+			problem := ""
+			switch {
+			case !settingsEncryptionConfigured():
+				problem = "Set SETTINGS_ENCRYPTION_KEY before saving keys here, so they're stored encrypted"
+			case fields[0][1] != "" && !strings.HasPrefix(fields[0][1], "sk_") && !strings.HasPrefix(fields[0][1], "rk_"):
+				problem = "The secret key starts with sk_ or rk_"
+			case fields[1][1] != "" && !strings.HasPrefix(fields[1][1], "whsec_"):
+				problem = "The webhook signing secret starts with whsec_"
+			}
+			if problem != "" {
+				c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+				return
+			}
+		}
+
+		for _, field := range fields {
+			// Keys aren't shown again, so an empty field keeps the saved one
+			if field[1] == "" && c.PostForm("remove") != "1" {
+				continue
+			}
+			if err := setEncryptedSetting(ctx, field[0], field[1]); err != nil {
+				log.Printf("Error saving Stripe settings: %v", err)
+				c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+					"error": "Failed to save the Stripe keys",
+				})
+				return
+			}
+		}
+
+		log.Printf("Stripe keys changed by admin from %s", hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/invoices?message="+url.QueryEscape(message)+"#stripe")
+	})
+}
@@ -0,0 +1,61 @@
+// stripe_test.go - Stripe webhook signature checks
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The v1 signature Stripe sends: an HMAC of "timestamp.body"
+func stripeV1(secret string, at time.Time, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stripeTimestamp(at) + "." + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func stripeTimestamp(at time.Time) string {
+	return strconv.FormatInt(at.Unix(), 10)
+}
+
+// A Stripe-Signature header for a body signed at a time
+func stripeSignature(secret string, at time.Time, body string) string {
+	return "t=" + stripeTimestamp(at) + ",v1=" + stripeV1(secret, at, body)
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	const secret = "whsec_test"
+	const body = `{"id":"evt_1","type":"checkout.session.completed"}`
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		ok     bool
+	}{
+		{"valid", stripeSignature(secret, now, body), body, true},
+		{"valid with spaces", "t=" + stripeTimestamp(now) + ", v1=" + stripeV1(secret, now, body), body, true},
+		{"second of two signatures", "t=" + stripeTimestamp(now) + ",v1=deadbeef,v1=" + stripeV1(secret, now, body), body, true},
+		{"slightly early clock", stripeSignature(secret, now.Add(time.Minute), body), body, true},
+		{"expired", stripeSignature(secret, now.Add(-stripeMaxSignatureAge-time.Minute), body), body, false},
+		{"too far ahead", stripeSignature(secret, now.Add(stripeMaxSignatureAge+time.Minute), body), body, false},
+		{"tampered body", stripeSignature(secret, now, body), `{"id":"evt_1","type":"checkout.session.expired"}`, false},
+		{"wrong secret", stripeSignature("whsec_other", now, body), body, false},
+		// A captured request replayed later with a fresh timestamp: the
+		// signature covers the old one
+		{"replayed with new timestamp", "t=" + stripeTimestamp(now) + ",v1=" + stripeV1(secret, now.Add(-time.Hour), body), body, false},
+		{"replayed unchanged", stripeSignature(secret, now.Add(-time.Hour), body), body, false},
+		{"no timestamp", "v1=" + stripeV1(secret, now, body), body, false},
+		{"no signature", "t=" + stripeTimestamp(now), body, false},
+		{"empty", "", body, false},
+	}
+	for _, tt := range tests {
+		if got := verifyStripeSignature(tt.header, []byte(tt.body), secret); got != tt.ok {
+			t.Errorf("%s: verifyStripeSignature = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// structureddata.go - schema.org JSON-LD structured data
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const schemaContext = "https://schema.org"
+
+type JSONLDPerson struct {
+	Context     string               `json:"@context,omitempty"`
+	Type        string               `json:"@type"`
+	Name        string               `json:"name"`
+	JobTitle    string               `json:"jobTitle,omitempty"`
+	URL         string               `json:"url"`
+	Description string               `json:"description,omitempty"`
+	SameAs      []string             `json:"sameAs,omitempty"`
+	WorksFor    []JSONLDOrganization `json:"worksFor,omitempty"`
+	AlumniOf    []JSONLDOrganization `json:"alumniOf,omitempty"`
+	KnowsAbout  []string             `json:"knowsAbout,omitempty"`
+}
+
+type JSONLDOrganization struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type JSONLDBlogPosting struct {
+	Context          string       `json:"@context"`
+	Type             string       `json:"@type"`
+	Headline         string       `json:"headline"`
+	Description      string       `json:"description,omitempty"`
+	URL              string       `json:"url"`
+	MainEntityOfPage string       `json:"mainEntityOfPage"`
+	DatePublished    string       `json:"datePublished,omitempty"`
+	DateModified     string       `json:"dateModified"`
+	WordCount        int          `json:"wordCount"`
+	Author           JSONLDPerson `json:"author"`
+}
+
+type JSONLDBreadcrumbList struct {
+	Context         string           `json:"@context"`
+	Type            string           `json:"@type"`
+	ItemListElement []JSONLDListItem `json:"itemListElement"`
+}
+
+type JSONLDListItem struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Item     string `json:"item"`
+}
+
+// The owner as a Person, for the homepage; jobs without an end date are
+// current employers, and certifications aren't schools
+func personStructuredData(content *SiteContent) JSONLDPerson {
+	person := ownerPerson()
+	person.Context = schemaContext
+	person.Description = strings.Join(strings.Fields(content.About), " ")
+	for _, e := range content.Experience {
+		if resumeDate(e.EndDate) == "" { // from resume.go
+			person.WorksFor = append(person.WorksFor, JSONLDOrganization{Type: "Organization", Name: e.Organization, URL: e.URL})
+		}
+	}
+	for _, e := range content.Education {
+		if e.Certification {
+			continue
+		}
+		person.AlumniOf = append(person.AlumniOf, JSONLDOrganization{Type: "EducationalOrganization", Name: e.Organization, URL: e.URL})
+	}
+	for _, s := range content.Skills {
+		person.KnowsAbout = append(person.KnowsAbout, s.Name)
+	}
+	return person
+}
+
+// The owner as little more than a name, for posts' author
+func ownerPerson() JSONLDPerson {
+	person := JSONLDPerson{Type: "Person", Name: ownerName, JobTitle: ownerLabel, URL: siteBaseURL()}
+	for _, p := range ownerProfiles {
+		person.SameAs = append(person.SameAs, p.URL)
+	}
+	return person
+}
+
+// A published post as a BlogPosting
+func postStructuredData(p Post, description string) JSONLDBlogPosting {
+	posting := JSONLDBlogPosting{
+		Context:          schemaContext,
+		Type:             "BlogPosting",
+		Headline:         p.Title,
+		Description:      description,
+		URL:              p.Permalink(),
+		MainEntityOfPage: p.Permalink(),
+		DateModified:     p.UpdatedAt.UTC().Format(time.RFC3339),
+		WordCount:        len(strings.Fields(p.Body)),
+		Author:           ownerPerson(),
+	}
+	if p.PublishedAt.Valid {
+		posting.DatePublished = p.PublishedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return posting
+}
+
+// Trail from the homepage to path, named from the editable pages' labels;
+// title names the last step when it isn't one of them. Nil for the
+// homepage and anything with nothing in between.
+func breadcrumbStructuredData(path, title string) *JSONLDBreadcrumbList {
+	list := &JSONLDBreadcrumbList{Context: schemaContext, Type: "BreadcrumbList"}
+	add := func(name, crumbPath string) {
+		list.ItemListElement = append(list.ItemListElement, JSONLDListItem{
+			Type: "ListItem", Position: len(list.ItemListElement) + 1, Name: name, Item: siteBaseURL() + crumbPath,
+		})
+	}
+
+	add("Home", "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range segments {
+		crumbPath := "/" + strings.Join(segments[:i+1], "/")
+		if label := seoPageLabel(crumbPath); label != "" {
+			add(label, crumbPath)
+		} else if i == len(segments)-1 && title != "" {
+			add(title, crumbPath)
+		}
+	}
+	if len(list.ItemListElement) < 2 {
+		return nil
+	}
+	return list
+}
+
+// Admin label of an editable page, or ""
+func seoPageLabel(path string) string {
+	for _, page := range seoPages { // from seo.go
+		if page.Path == path && path != "/" {
+			return page.Label
+		}
+	}
+	return ""
+}
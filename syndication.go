@@ -0,0 +1,389 @@
+// syndication.go - Cross-post new blog posts to other platforms (POSSE)
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A platform posts can be syndicated to
+type syndicationTarget struct {
+	Name        string
+	Enabled     func() bool
+	DefaultText string // text/template over syndicationData
+	Post        func(post Post, text string) (remoteURL string, err error)
+}
+
+// Fields available to per-target templates
+type syndicationData struct {
+	Title   string
+	Summary string
+	URL     string
+	Body    string
+}
+
+// Syndication attempt as shown in the admin
+type SyndicationResult struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	Target    string    `json:"target"`
+	Status    string    `json:"status"`
+	RemoteURL string    `json:"remote_url"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var syndicationClient = &http.Client{Timeout: 20 * time.Second}
+
+// Configured via environment:
+//
+//	MASTODON_INSTANCE, MASTODON_TOKEN
+//	BLUESKY_HANDLE, BLUESKY_APP_PASSWORD
+//	DEVTO_API_KEY
+//
+// Each target's text can be overridden with SYNDICATE_<TARGET>_TEMPLATE.
+var syndicationTargets = []syndicationTarget{
+	{
+		Name:        "mastodon",
+		Enabled:     func() bool { return os.Getenv("MASTODON_INSTANCE") != "" && os.Getenv("MASTODON_TOKEN") != "" },
+		DefaultText: "{{.Title}}\n\n{{.Summary}}\n\n{{.URL}}",
+		Post:        postToMastodon,
+	},
+	{
+		Name:        "bluesky",
+		Enabled:     func() bool { return os.Getenv("BLUESKY_HANDLE") != "" && os.Getenv("BLUESKY_APP_PASSWORD") != "" },
+		DefaultText: "{{.Title}}\n\n{{.URL}}",
+		Post:        postToBluesky,
+	},
+	{
+		Name:        "devto",
+		Enabled:     func() bool { return os.Getenv("DEVTO_API_KEY") != "" },
+		DefaultText: "{{.Body}}",
+		Post:        postToDevTo,
+	},
+}
+
+// One breaker per target, so a platform that's down fails fast; failed
+// posts can be retried from the admin once it's back
+var syndicationBreakers = map[string]*circuitBreaker{}
+
+// Initialize syndication result tracking and register the publish hook
+func initSyndication() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS syndications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		target TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		remote_url TEXT,
+		error TEXT,
+		attempts INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME,
+		UNIQUE(post_id, target)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create syndications table:", err)
+	}
+
+	for _, target := range syndicationTargets {
+		syndicationBreakers[target.Name] = newCircuitBreaker("syndication-"+target.Name, 3, 15*time.Minute, nil) // from breaker.go
+	}
+
+	var enabled []string
+	for _, t := range syndicationTargets {
+		if t.Enabled() {
+			enabled = append(enabled, t.Name)
+		}
+	}
+	if len(enabled) > 0 {
+		log.Printf("Syndication enabled for: %s", strings.Join(enabled, ", "))
+	}
+
+	registerPublishHook("syndication", syndicatePost)
+}
+
+// Render a target's template for a post
+func syndicationText(target syndicationTarget, post Post) (string, error) {
+	source := os.Getenv("SYNDICATE_" + strings.ToUpper(target.Name) + "_TEMPLATE")
+	if source == "" {
+		source = target.DefaultText
+	}
+	// Environment values can't easily hold newlines
+	source = strings.ReplaceAll(source, `\n`, "\n")
+
+	tmpl, err := template.New(target.Name).Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, syndicationData{
+		Title:   post.Title,
+		Summary: post.Summary,
+		URL:     post.Permalink(),
+		Body:    post.Body,
+	})
+	return strings.TrimSpace(buf.String()), err
+}
+
+// Cross-post to every enabled target
+func syndicatePost(post Post) {
+	for _, target := range syndicationTargets {
+		if target.Enabled() {
+			syndicateTo(target, post)
+		}
+	}
+}
+
+// Cross-post to one target and record the result
+func syndicateTo(target syndicationTarget, post Post) {
+	ctx := context.Background()
+	_, err := dbExec(ctx, `
+		INSERT INTO syndications (post_id, target, status) VALUES (?, ?, 'pending')
+		ON CONFLICT(post_id, target) DO UPDATE SET status = 'pending', error = NULL
+	`, post.ID, target.Name)
+	if err != nil {
+		log.Printf("Error recording syndication: %v", err)
+		return
+	}
+
+	text, err := syndicationText(target, post)
+	remoteURL := ""
+	if err == nil {
+		err = syndicationBreakers[target.Name].Do(func() error {
+			remoteURL, err = target.Post(post, text)
+			return err
+		})
+	}
+
+	status, errText := "posted", ""
+	if err != nil {
+		status, errText = "failed", err.Error()
+		log.Printf("Syndication of post %d to %s failed: %v", post.ID, target.Name, err)
+	} else {
+		log.Printf("Syndicated post %d to %s: %s", post.ID, target.Name, remoteURL)
+	}
+
+	_, err = dbExec(ctx, `
+		UPDATE syndications SET status = ?, remote_url = ?, error = ?, attempts = attempts + 1, updated_at = ?
+		WHERE post_id = ? AND target = ?
+	`, status, remoteURL, errText, time.Now(), post.ID, target.Name)
+	if err != nil {
+		log.Printf("Error recording syndication result: %v", err)
+	}
+}
+
+// POST JSON and decode a JSON response
+func syndicationRequest(method, endpoint string, headers map[string]string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := syndicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if out != nil {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+func postToMastodon(post Post, text string) (string, error) {
+	instance := strings.TrimRight(os.Getenv("MASTODON_INSTANCE"), "/")
+	var status struct {
+		URL string `json:"url"`
+	}
+	err := syndicationRequest(http.MethodPost, instance+"/api/v1/statuses", map[string]string{
+		"Authorization":   "Bearer " + os.Getenv("MASTODON_TOKEN"),
+		"Idempotency-Key": fmt.Sprintf("zachkp-post-%d", post.ID),
+	}, map[string]string{"status": text, "visibility": "public"}, &status)
+	return status.URL, err
+}
+
+func postToBluesky(post Post, text string) (string, error) {
+	const pds = "https://bsky.social/xrpc"
+
+	var session struct {
+		AccessJwt string `json:"accessJwt"`
+		DID       string `json:"did"`
+		Handle    string `json:"handle"`
+	}
+	err := syndicationRequest(http.MethodPost, pds+"/com.atproto.server.createSession", nil, map[string]string{
+		"identifier": os.Getenv("BLUESKY_HANDLE"),
+		"password":   os.Getenv("BLUESKY_APP_PASSWORD"),
+	}, &session)
+	if err != nil {
+		return "", err
+	}
+
+	// Bluesky posts are capped at 300 characters
+	if runes := []rune(text); len(runes) > 300 {
+		text = string(runes[:297]) + "..."
+	}
+
+	var created struct {
+		URI string `json:"uri"`
+	}
+	err = syndicationRequest(http.MethodPost, pds+"/com.atproto.repo.createRecord", map[string]string{
+		"Authorization": "Bearer " + session.AccessJwt,
+	}, map[string]interface{}{
+		"repo":       session.DID,
+		"collection": "app.bsky.feed.post",
+		"record": map[string]interface{}{
+			"$type":     "app.bsky.feed.post",
+			"text":      text,
+			"createdAt": time.Now().UTC().Format(time.RFC3339),
+			"embed": map[string]interface{}{
+				"$type": "app.bsky.embed.external",
+				"external": map[string]string{
+					"uri":         post.Permalink(),
+					"title":       post.Title,
+					"description": post.Summary,
+				},
+			},
+		},
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+
+	// at://did/app.bsky.feed.post/rkey -> web URL
+	rkey := created.URI[strings.LastIndex(created.URI, "/")+1:]
+	return fmt.Sprintf("https://bsky.app/profile/%s/post/%s", session.Handle, rkey), nil
+}
+
+func postToDevTo(post Post, text string) (string, error) {
+	var article struct {
+		URL string `json:"url"`
+	}
+	err := syndicationRequest(http.MethodPost, "https://dev.to/api/articles", map[string]string{
+		"api-key": os.Getenv("DEVTO_API_KEY"),
+	}, map[string]interface{}{
+		"article": map[string]interface{}{
+			"title":         post.Title,
+			"body_markdown": text,
+			"published":     true,
+			"canonical_url": post.Permalink(),
+			"description":   post.Summary,
+		},
+	}, &article)
+	return article.URL, err
+}
+
+// Setup admin syndication routes
+func setupSyndicationAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/syndication", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		rows, err := dbQuery(ctx, `
+			SELECT s.id, s.post_id, COALESCE(p.title, '(deleted)'), s.target, s.status,
+				COALESCE(s.remote_url, ''), COALESCE(s.error, ''), s.attempts, s.created_at
+			FROM syndications s
+			LEFT JOIN posts p ON p.id = s.post_id
+			ORDER BY s.created_at DESC
+			LIMIT 200
+		`)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load syndication results",
+			})
+			return
+		}
+		defer rows.Close()
+
+		var results []SyndicationResult
+		for rows.Next() {
+			var r SyndicationResult
+			err := rows.Scan(&r.ID, &r.PostID, &r.PostTitle, &r.Target, &r.Status, &r.RemoteURL, &r.Error, &r.Attempts, &r.CreatedAt)
+			if err != nil {
+				continue
+			}
+			results = append(results, r)
+		}
+
+		var targets []gin.H
+		for _, t := range syndicationTargets {
+			targets = append(targets, gin.H{"name": t.Name, "enabled": t.Enabled()})
+		}
+
+		c.HTML(http.StatusOK, "admin-syndication.html", gin.H{
+			"results": results,
+			"targets": targets,
+		})
+	})
+
+	// Retry a failed syndication. Only failed ones: retrying one that's
+	// pending or posted would post the same entry twice.
+	adminGroup.POST("/syndication/:id/retry", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var postID int
+		var targetName, status string
+		err := dbQueryRow(ctx, "SELECT post_id, target, status FROM syndications WHERE id = ?", c.Param("id")).Scan(&postID, &targetName, &status)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Syndication not found"})
+			return
+		}
+		if status != "failed" {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Syndication is %s; only failed ones can be retried", status)})
+			return
+		}
+
+		post, err := scanPost(dbQueryRow(ctx, `SELECT `+postColumns+` FROM posts WHERE id = ?`, postID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post no longer exists"})
+			return
+		}
+
+		for _, target := range syndicationTargets {
+			if target.Name != targetName {
+				continue
+			}
+			// Claim the row, so a second click can't start another retry
+			result, err := dbExec(ctx, "UPDATE syndications SET status = 'pending', error = NULL WHERE id = ? AND status = 'failed'", c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start retry"})
+				return
+			}
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Syndication is already being retried"})
+				return
+			}
+			safeGo("syndicate-"+target.Name, func() { syndicateTo(target, post) })
+			c.JSON(http.StatusAccepted, gin.H{"message": "Retry started"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target is no longer configured"})
+	})
+}
@@ -2,6 +2,7 @@
 module.exports = {
   content: [
     "./templates/**/*.html",
+    "./themes/**/*.html",
     "./static/**/*.{js,css}",
     "./**/*.go"
   ],
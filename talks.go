@@ -0,0 +1,371 @@
+// talks.go - Conference talks and slides
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+)
+
+// Talk page (without the slide bytes)
+type Talk struct {
+	ID          int       `json:"id"`
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Event       string    `json:"event"`
+	GivenOn     string    `json:"given_on"` // YYYY-MM-DD
+	Description string    `json:"description"`
+	VideoURL    string    `json:"video_url,omitempty"`
+	SlidesName  string    `json:"slides_name,omitempty"`
+	SlidesSize  int       `json:"slides_size"`
+	Views       int       `json:"views"`
+	Downloads   int       `json:"downloads"`
+	GoClicks    int       `json:"go_clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Largest slide deck accepted, see routePolicies (from routepolicy.go)
+const talkSlidesMaxBytes = 40 << 20
+
+// Rendered description (Markdown, raw HTML escaped)
+func (t Talk) DescriptionHTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(t.Description), &buf); err != nil {
+		log.Printf("Error rendering talk %d: %v", t.ID, err)
+		return template.HTML(template.HTMLEscapeString(t.Description))
+	}
+	return template.HTML(buf.String())
+}
+
+// Date the talk was given, for display
+func (t Talk) Date() string {
+	given, err := time.Parse("2006-01-02", t.GivenOn)
+	if err != nil {
+		return t.GivenOn
+	}
+	return given.Format("January 2, 2006")
+}
+
+// Short link announced on the title slide
+func (t Talk) GoLink() string {
+	return siteBaseURL() + "/go/" + t.Slug // from seo.go
+}
+
+// Initialize talk storage
+func initTalks() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS talks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT NOT NULL UNIQUE,
+		title TEXT NOT NULL,
+		event TEXT NOT NULL DEFAULT '',
+		given_on TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		video_url TEXT NOT NULL DEFAULT '',
+		slides BLOB,
+		slides_name TEXT NOT NULL DEFAULT '',
+		views INTEGER NOT NULL DEFAULT 0,
+		downloads INTEGER NOT NULL DEFAULT 0,
+		go_clicks INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create talks table:", err)
+	}
+}
+
+const talkColumns = `id, slug, title, event, given_on, description, video_url, slides_name,
+	COALESCE(length(slides), 0), views, downloads, go_clicks, created_at`
+
+func scanTalk(row interface{ Scan(...interface{}) error }) (Talk, error) {
+	var t Talk
+	err := row.Scan(&t.ID, &t.Slug, &t.Title, &t.Event, &t.GivenOn, &t.Description, &t.VideoURL, &t.SlidesName,
+		&t.SlidesSize, &t.Views, &t.Downloads, &t.GoClicks, &t.CreatedAt)
+	return t, err
+}
+
+// Talks, most recently given first
+func getTalks(ctx context.Context) ([]Talk, error) {
+	rows, err := dbQuery(ctx, "SELECT "+talkColumns+" FROM talks ORDER BY given_on DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var talks []Talk
+	for rows.Next() {
+		t, err := scanTalk(rows)
+		if err != nil {
+			continue
+		}
+		talks = append(talks, t)
+	}
+	return talks, rows.Err()
+}
+
+// Get a talk by slug
+func getTalk(ctx context.Context, slug string) (Talk, error) {
+	return scanTalk(dbQueryRow(ctx, "SELECT "+talkColumns+" FROM talks WHERE slug = ?", slug))
+}
+
+// Bump one of a talk's counters in the background
+func countTalk(ctx context.Context, id int, column string) {
+	safeGoRetry("talk-"+column, 3, func() error {
+		_, err := dbExec(context.WithoutCancel(ctx), "UPDATE talks SET "+column+" = "+column+" + 1 WHERE id = ?", id)
+		if err != nil {
+			log.Printf("Error counting talk %s: %v", column, err)
+		}
+		return err
+	})
+}
+
+// Read the uploaded slide deck, if any; the problem is empty when valid
+func talkSlidesFromForm(c *gin.Context) (interface{}, string, string) {
+	file, header, err := c.Request.FormFile("slides")
+	if err == http.ErrMissingFile {
+		return nil, "", "" // untyped nil, stored as NULL
+	}
+	if err != nil {
+		return nil, "", "Could not read the uploaded slides"
+	}
+	defer file.Close()
+
+	if header.Size > talkSlidesMaxBytes {
+		return nil, "", "Slides must be at most 40 MB"
+	}
+	slides, err := io.ReadAll(io.LimitReader(file, talkSlidesMaxBytes+1))
+	if err != nil || len(slides) > talkSlidesMaxBytes {
+		return nil, "", "Could not read the uploaded slides"
+	}
+	if !bytes.HasPrefix(slides, []byte("%PDF-")) {
+		return nil, "", "Slides must be a PDF"
+	}
+	return slides, header.Filename, ""
+}
+
+// Talk dates come from a date input
+func isValidTalkDate(value string) bool {
+	_, err := time.Parse("2006-01-02", value)
+	return err == nil
+}
+
+// Read a talk from the admin form; the problem is empty when valid
+func talkFromForm(c *gin.Context) (Talk, string) {
+	t := Talk{
+		Title:       strings.TrimSpace(c.PostForm("title")),
+		Event:       strings.TrimSpace(c.PostForm("event")),
+		GivenOn:     strings.TrimSpace(c.PostForm("given_on")),
+		Description: strings.TrimSpace(c.PostForm("description")),
+		VideoURL:    strings.TrimSpace(c.PostForm("video_url")),
+	}
+	t.Slug = slugify(c.PostForm("slug")) // from blog.go
+	if t.Slug == "" {
+		t.Slug = slugify(t.Title)
+	}
+
+	switch {
+	case t.Title == "" || t.Slug == "":
+		return t, "A talk needs a title"
+	case t.GivenOn != "" && !isValidTalkDate(t.GivenOn):
+		return t, "Invalid date"
+	case t.VideoURL != "" && !isValidLongURL(t.VideoURL): // from main.go
+		return t, "The video link must be a valid http:// or https:// URL"
+	}
+	return t, ""
+}
+
+// Setup public talk routes
+func setupTalkRoutes(r *gin.Engine) {
+	r.GET("/talks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		talks, err := getTalks(ctx)
+		if err != nil {
+			log.Printf("Error loading talks: %v", err)
+		}
+		c.HTML(http.StatusOK, "talks.html", gin.H{
+			"title": "Talks",
+			"talks": talks,
+			"seo":   getPageSEO(ctx, "/talks"),
+		})
+	})
+
+	r.GET("/talks/:slug", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		talk, err := getTalk(ctx, c.Param("slug"))
+		if err != nil {
+			recordNotFound(c) // from notfound.go
+			c.HTML(http.StatusNotFound, "404.html", gin.H{
+				"message": "Talk not found",
+			})
+			return
+		}
+
+		// Admin previews don't count (from preview.go)
+		if !isPreview(c) {
+			countTalk(ctx, talk.ID, "views")
+		}
+
+		seo := getPageSEO(ctx, "/talks/"+talk.Slug)
+		if seo.Description == "" && talk.Event != "" {
+			seo.Description = talk.Title + " at " + talk.Event
+		}
+		seo.setBreadcrumbs("/talks/"+talk.Slug, talk.Title)
+		c.HTML(http.StatusOK, "talk.html", gin.H{
+			"title": talk.Title,
+			"talk":  talk,
+			"seo":   seo,
+		})
+	})
+
+	// Slides are shown inline by the page's viewer; ?download=1 saves them
+	// and is what the download counter measures
+	r.GET("/talks/:slug/slides.pdf", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var id int
+		var slides []byte
+		err := dbQueryRow(ctx, "SELECT id, slides FROM talks WHERE slug = ? AND slides IS NOT NULL",
+			c.Param("slug")).Scan(&id, &slides)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading talk slides: %v", err)
+			}
+			c.String(http.StatusNotFound, "Slides not found")
+			return
+		}
+
+		disposition := "inline"
+		if c.Query("download") == "1" {
+			disposition = "attachment"
+			if !isPreview(c) {
+				countTalk(ctx, id, "downloads")
+			}
+		}
+		c.Header("Content-Disposition", disposition+`; filename="`+c.Param("slug")+`.pdf"`)
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.Data(http.StatusOK, "application/pdf", slides)
+	})
+
+	// go/talk-name, as read off a title slide
+	r.GET("/go/:name", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		talk, err := getTalk(ctx, slugify(c.Param("name")))
+		if err != nil {
+			recordNotFound(c)
+			c.HTML(http.StatusNotFound, "404.html", gin.H{
+				"message": "Link not found",
+			})
+			return
+		}
+		countTalk(ctx, talk.ID, "go_clicks")
+		c.Redirect(http.StatusFound, "/talks/"+talk.Slug)
+	})
+}
+
+// Setup admin talk routes
+func setupTalkAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/talks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		talks, err := getTalks(ctx)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load talks",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-talks.html", gin.H{
+			"talks": talks,
+			"new":   Talk{},
+		})
+	})
+
+	adminGroup.POST("/talks", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		t, problem := talkFromForm(c)
+		slides, name, slidesProblem := talkSlidesFromForm(c)
+		if problem == "" {
+			problem = slidesProblem
+		}
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		_, err := dbExec(ctx, `
+			INSERT INTO talks (slug, title, event, given_on, description, video_url, slides, slides_name)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, t.Slug, t.Title, t.Event, t.GivenOn, t.Description, t.VideoURL, slides, name)
+		if err != nil {
+			log.Printf("Error saving talk: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save talk (is the slug already taken?)",
+			})
+			return
+		}
+
+		log.Printf("Talk %s added by admin from %s", t.Slug, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/talks")
+	})
+
+	// Edit details; a new upload replaces the slides, otherwise they're kept
+	adminGroup.POST("/talks/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		t, problem := talkFromForm(c)
+		slides, name, slidesProblem := talkSlidesFromForm(c)
+		if problem == "" {
+			problem = slidesProblem
+		}
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{"error": problem})
+			return
+		}
+
+		result, err := dbExec(ctx, `
+			UPDATE talks SET slug = ?, title = ?, event = ?, given_on = ?, description = ?, video_url = ?,
+				slides = COALESCE(?, slides), slides_name = CASE WHEN ? != '' THEN ? ELSE slides_name END
+			WHERE id = ?
+		`, t.Slug, t.Title, t.Event, t.GivenOn, t.Description, t.VideoURL, slides, name, name, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating talk %s: %v", c.Param("id"), err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save talk (is the slug already taken?)",
+			})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{
+				"error": "Talk not found",
+			})
+			return
+		}
+
+		log.Printf("Talk %s updated by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/talks")
+	})
+
+	adminGroup.DELETE("/talks/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM talks WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete talk"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Talk not found"})
+			return
+		}
+
+		log.Printf("Talk %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Talk deleted"})
+	})
+}
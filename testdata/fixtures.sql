@@ -0,0 +1,20 @@
+-- Fixture data for the integration tests (integration_test.go)
+
+INSERT INTO urls (short_code, original_url, clicks) VALUES
+	('fixone', 'https://example.com/one', 12),
+	('fixtwo', 'https://example.com/two', 3),
+	('fixdead', 'https://example.com/gone', 0);
+
+INSERT INTO visitors (hashed_ip, user_agent, path, timestamp) VALUES
+	('visitor-a', 'Mozilla/5.0 (X11; Linux x86_64)', '/', datetime('now', '-1 hour')),
+	('visitor-a', 'Mozilla/5.0 (X11; Linux x86_64)', '/blog', datetime('now', '-50 minutes')),
+	('visitor-b', 'Mozilla/5.0 (iPhone)', '/', datetime('now', '-2 days')),
+	('visitor-c', 'curl/8.0', '/s/fixone', datetime('now', '-10 days'));
+
+INSERT INTO posts (slug, title, summary, body, status, published_at) VALUES
+	('hello-fixtures', 'Hello, fixtures', 'A published post', 'Published body text.', 'published', datetime('now', '-3 days')),
+	('unfinished-draft', 'Unfinished draft', 'Not public yet', 'Draft body text.', 'draft', NULL);
+
+INSERT INTO redirects (source_path, target, status_code) VALUES
+	('/old-blog', '/blog', 301),
+	('/temporary', 'https://example.com/elsewhere', 302);
@@ -0,0 +1,369 @@
+// testimonials.go - Testimonials from former colleagues
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testimonialPending  = "pending"
+	testimonialApproved = "approved"
+	testimonialRejected = "rejected"
+)
+
+const (
+	testimonialInviteDays = 30   // how long an invite link works
+	testimonialMaxLength  = 2000 // characters in a testimonial
+)
+
+// A testimonial as submitted through an invite
+type Testimonial struct {
+	ID           int
+	Name         string
+	Role         string // job title and company, as they'd like it shown
+	Relationship string // how we worked together
+	Body         string
+	Status       string
+	CreatedAt    time.Time
+}
+
+// An invite link sent to one person; it takes a single submission
+type TestimonialInvite struct {
+	ID        int
+	Name      string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+// Whether the invite link still opens the form
+func (i TestimonialInvite) Open() bool {
+	return !i.UsedAt.Valid && time.Now().Before(i.ExpiresAt)
+}
+
+// Initialize testimonial storage
+func initTestimonials() {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS testimonials (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		invite_id INTEGER,
+		name TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT '',
+		relationship TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS testimonial_invites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create testimonial tables:", err)
+		}
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_testimonials_status ON testimonials (status)`)
+}
+
+// Testimonials with a status, oldest first
+func getTestimonials(ctx context.Context, status string) ([]Testimonial, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, name, role, relationship, body, status, created_at FROM testimonials
+		WHERE status = ? ORDER BY created_at, id
+	`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var testimonials []Testimonial
+	for rows.Next() {
+		var t Testimonial
+		if err := rows.Scan(&t.ID, &t.Name, &t.Role, &t.Relationship, &t.Body, &t.Status, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		testimonials = append(testimonials, t)
+	}
+	return testimonials, rows.Err()
+}
+
+func getTestimonialInvites(ctx context.Context) ([]TestimonialInvite, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT id, name, expires_at, used_at, created_at FROM testimonial_invites
+		ORDER BY created_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []TestimonialInvite
+	for rows.Next() {
+		var i TestimonialInvite
+		if err := rows.Scan(&i.ID, &i.Name, &i.ExpiresAt, &i.UsedAt, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, i)
+	}
+	return invites, rows.Err()
+}
+
+// Look up an invite by the token in its link. Tokens are stored hashed,
+// like API tokens (from api.go).
+func lookupTestimonialInvite(ctx context.Context, token string) (TestimonialInvite, error) {
+	var i TestimonialInvite
+	err := dbQueryRow(ctx, `
+		SELECT id, name, expires_at, used_at, created_at FROM testimonial_invites WHERE token_hash = ?
+	`, hashAPIToken(token)).Scan(&i.ID, &i.Name, &i.ExpiresAt, &i.UsedAt, &i.CreatedAt)
+	return i, err
+}
+
+// Store a submission and use up its invite, unless someone got there first
+func submitTestimonial(ctx context.Context, inviteID int, t Testimonial) (bool, error) {
+	tx, cancel, err := dbBegin(ctx) // from dbctx.go
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE testimonial_invites SET used_at = ? WHERE id = ? AND used_at IS NULL AND expires_at > ?
+	`, time.Now(), inviteID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO testimonials (invite_id, name, role, relationship, body, status) VALUES (?, ?, ?, ?, ?, ?)
+	`, inviteID, t.Name, t.Role, t.Relationship, t.Body, testimonialPending)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Setup the invite form and the homepage carousel partial
+func setupTestimonialRoutes(r *gin.Engine) {
+	// Loads the invite, answering itself when the link doesn't work
+	loadInvite := func(c *gin.Context) (TestimonialInvite, bool) {
+		invite, err := lookupTestimonialInvite(c.Request.Context(), c.Param("token"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error loading testimonial invite: %v", err)
+			}
+			c.HTML(http.StatusNotFound, "testimonial-submit.html", gin.H{
+				"error": "This link isn't valid. Please check you copied all of it.",
+			})
+			return invite, false
+		}
+		if !invite.Open() {
+			c.HTML(http.StatusGone, "testimonial-submit.html", gin.H{
+				"error": "This link has already been used or has expired. Thank you all the same!",
+			})
+			return invite, false
+		}
+		return invite, true
+	}
+
+	r.GET("/testimonials/submit/:token", func(c *gin.Context) {
+		if invite, ok := loadInvite(c); ok {
+			c.Header("Referrer-Policy", "no-referrer")
+			c.HTML(http.StatusOK, "testimonial-submit.html", gin.H{
+				"invite":    invite,
+				"form":      Testimonial{Name: invite.Name},
+				"maxLength": testimonialMaxLength,
+			})
+		}
+	})
+
+	r.POST("/testimonials/submit/:token", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		invite, ok := loadInvite(c)
+		if !ok {
+			return
+		}
+
+		t := Testimonial{
+			Name:         strings.TrimSpace(c.PostForm("name")),
+			Role:         strings.TrimSpace(c.PostForm("role")),
+			Relationship: strings.TrimSpace(c.PostForm("relationship")),
+			Body:         strings.TrimSpace(c.PostForm("body")),
+		}
+		problem := ""
+		switch {
+		case t.Name == "" || t.Body == "":
+			problem = "Please fill in your name and the testimonial."
+		case len([]rune(t.Body)) > testimonialMaxLength:
+			problem = "The testimonial can be at most " + strconv.Itoa(testimonialMaxLength) + " characters."
+		case len(t.Name) > 100 || len(t.Role) > 200 || len(t.Relationship) > 200:
+			problem = "Your name, role and how we worked together need to be shorter."
+		}
+		if problem != "" {
+			c.HTML(http.StatusBadRequest, "testimonial-submit.html", gin.H{
+				"invite":    invite,
+				"form":      t,
+				"maxLength": testimonialMaxLength,
+				"problem":   problem,
+			})
+			return
+		}
+
+		saved, err := submitTestimonial(ctx, invite.ID, t)
+		if err != nil {
+			log.Printf("Error saving testimonial: %v", err)
+			c.HTML(http.StatusInternalServerError, "testimonial-submit.html", gin.H{
+				"error": "Sorry, your testimonial couldn't be saved. Please try again later.",
+			})
+			return
+		}
+		if !saved {
+			c.HTML(http.StatusGone, "testimonial-submit.html", gin.H{
+				"error": "This link has already been used or has expired. Thank you all the same!",
+			})
+			return
+		}
+
+		log.Printf("Testimonial submitted through invite %d", invite.ID)
+		c.HTML(http.StatusOK, "testimonial-submit.html", gin.H{
+			"submitted": true,
+		})
+	})
+
+	r.GET("/partials/testimonials", func(c *gin.Context) {
+		testimonials, err := getTestimonials(c.Request.Context(), testimonialApproved)
+		if err != nil {
+			log.Printf("Error loading testimonials: %v", err)
+		}
+		c.HTML(http.StatusOK, "testimonials.html", gin.H{
+			"testimonials": testimonials,
+		})
+	})
+}
+
+// Setup admin testimonial routes: invites and moderation
+func setupTestimonialAdminRoutes(adminGroup *gin.RouterGroup) {
+	render := func(c *gin.Context, status int, extra gin.H) {
+		ctx := c.Request.Context()
+		data := gin.H{"message": c.Query("message")}
+		var err error
+		for _, s := range []string{testimonialPending, testimonialApproved, testimonialRejected} {
+			if data[s], err = getTestimonials(ctx, s); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			data["invites"], err = getTestimonialInvites(ctx)
+		}
+		if err != nil {
+			log.Printf("Error loading testimonials: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load testimonials",
+			})
+			return
+		}
+		for key, value := range extra {
+			data[key] = value
+		}
+		c.HTML(status, "admin-testimonials.html", data)
+	}
+
+	adminGroup.GET("/testimonials", func(c *gin.Context) {
+		render(c, http.StatusOK, nil)
+	})
+
+	// The link is shown once, on the page this answers with; only its
+	// hash is kept
+	adminGroup.POST("/testimonials/invites", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "An invite needs the name of who it's for",
+			})
+			return
+		}
+
+		token := generateAdminToken() // from admin.go
+		expiresAt := time.Now().AddDate(0, 0, testimonialInviteDays).Truncate(time.Second)
+		_, err := dbExec(ctx, "INSERT INTO testimonial_invites (name, token_hash, expires_at) VALUES (?, ?, ?)",
+			name, hashAPIToken(token), expiresAt)
+		if err != nil {
+			log.Printf("Error creating testimonial invite: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to create invite",
+			})
+			return
+		}
+
+		log.Printf("Testimonial invite for %q created by admin from %s", name, hashIP(c.ClientIP()))
+		render(c, http.StatusCreated, gin.H{
+			"inviteName": name,
+			"inviteLink": siteBaseURL() + "/testimonials/submit/" + token, // from seo.go
+		})
+	})
+
+	adminGroup.POST("/testimonials/invites/:id/delete", func(c *gin.Context) {
+		_, err := dbExec(c.Request.Context(), "DELETE FROM testimonial_invites WHERE id = ?", c.Param("id"))
+		if err != nil {
+			log.Printf("Error deleting testimonial invite: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete invite",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/testimonials?message="+url.QueryEscape("Invite deleted"))
+	})
+
+	adminGroup.POST("/testimonials/:id/status", func(c *gin.Context) {
+		status := c.PostForm("status")
+		if status != testimonialPending && status != testimonialApproved && status != testimonialRejected {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown testimonial status",
+			})
+			return
+		}
+		result, err := dbExec(c.Request.Context(), "UPDATE testimonials SET status = ? WHERE id = ?", status, c.Param("id"))
+		if err != nil {
+			log.Printf("Error updating testimonial: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to update testimonial",
+			})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.HTML(http.StatusNotFound, "admin-error.html", gin.H{"error": "Testimonial not found"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/testimonials?message="+url.QueryEscape("Testimonial "+status))
+	})
+
+	adminGroup.POST("/testimonials/:id/delete", func(c *gin.Context) {
+		_, err := dbExec(c.Request.Context(), "DELETE FROM testimonials WHERE id = ?", c.Param("id"))
+		if err != nil {
+			log.Printf("Error deleting testimonial: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to delete testimonial",
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/admin/testimonials?message="+url.QueryEscape("Testimonial deleted"))
+	})
+}
@@ -0,0 +1,383 @@
+// themes.go - Selectable themes
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Themes shipped in the binary. Each directory under themes/ is one theme:
+//
+//	theme.json         {"title": "...", "description": "..."} (optional)
+//	templates/*.html   public templates replacing those in templates/
+//	static/styles.css  CSS bundle replacing /static/styles.css
+//	static/...         anything else the theme links to, served under /themes/<name>/
+//
+// Admin templates can't be overridden, so a broken theme never locks the
+// admin out. Directories under THEMES_DIR use the same layout and take
+// precedence over built-in themes of the same name.
+//
+//go:embed themes
+var embeddedThemes embed.FS
+
+const defaultThemeName = "default"
+
+// Cookie holding the theme an admin is previewing, signed like the preview cookie
+const themePreviewCookie = "theme_preview"
+
+// Selectable theme
+type Theme struct {
+	Name        string // directory name, used in settings and URLs
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Source      string // "built in", "embedded" or the directory it was loaded from
+	Overrides   []string
+
+	files         fs.FS // theme directory; nil for the default theme
+	hasStylesheet bool
+}
+
+// URL of the theme's CSS bundle
+func (t *Theme) StylesheetURL() string {
+	if t.hasStylesheet {
+		return "/themes/" + t.Name + "/styles.css"
+	}
+	return "/static/styles.css"
+}
+
+var (
+	themesMu       sync.RWMutex
+	themes         = map[string]*Theme{}
+	themeTemplates = map[string]*template.Template{}
+)
+
+// Find every theme and parse its templates. The default theme failing to
+// parse is fatal, like LoadHTMLGlob; other broken themes are skipped.
+func loadThemes() {
+	found := map[string]*Theme{
+		defaultThemeName: {
+			Name:        defaultThemeName,
+			Title:       "Default",
+			Description: "The templates and stylesheet in this repository",
+			Source:      "built in",
+		},
+	}
+
+	if sub, err := fs.Sub(embeddedThemes, "themes"); err == nil {
+		addThemesFrom(found, sub, "embedded")
+	}
+	if dir := os.Getenv("THEMES_DIR"); dir != "" {
+		addThemesFrom(found, os.DirFS(dir), dir)
+	}
+
+	parsed := map[string]*template.Template{}
+	for name, theme := range found {
+		tmpl, err := parseThemeTemplates(theme)
+		if err != nil {
+			if name == defaultThemeName {
+				log.Fatal("Failed to parse templates:", err)
+			}
+			log.Printf("Skipping theme %s: %v", name, err)
+			delete(found, name)
+			continue
+		}
+		parsed[name] = tmpl
+	}
+
+	themesMu.Lock()
+	themes = found
+	themeTemplates = parsed
+	themesMu.Unlock()
+	if len(found) > 1 {
+		log.Printf("Loaded %d themes", len(found))
+	}
+}
+
+// Add the theme directories found in fsys
+func addThemesFrom(found map[string]*Theme, fsys fs.FS, source string) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		log.Printf("Error reading themes from %s: %v", source, err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == defaultThemeName || strings.HasPrefix(name, ".") {
+			continue
+		}
+		files, _ := fs.Sub(fsys, name)
+		theme := &Theme{Name: name, Title: name, Source: source, files: files}
+		if data, err := fs.ReadFile(files, "theme.json"); err == nil {
+			if err := json.Unmarshal(data, theme); err != nil {
+				log.Printf("Skipping theme %s: bad theme.json: %v", name, err)
+				continue
+			}
+		}
+		if _, err := fs.Stat(files, "static/styles.css"); err == nil {
+			theme.hasStylesheet = true
+		}
+		templates, _ := fs.ReadDir(files, "templates")
+		for _, t := range templates {
+			if !t.IsDir() && path.Ext(t.Name()) == ".html" && !strings.HasPrefix(t.Name(), "admin-") {
+				theme.Overrides = append(theme.Overrides, t.Name())
+			}
+		}
+		found[name] = theme
+	}
+}
+
+// The repository's templates with the theme's overrides parsed over them
+func parseThemeTemplates(theme *Theme) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"themeStylesheet": theme.StylesheetURL,
+		"outLink":         outboundLink,          // from outbound.go
+		"imgURL":          imageProxyLink,        // from imageproxy.go
+		"allowedImgURL":   imageProxyAllowedLink, // from imageproxy.go
+	}
+	tmpl, err := template.New("").Funcs(funcs).ParseGlob("templates/*")
+	if err != nil || len(theme.Overrides) == 0 {
+		return tmpl, err
+	}
+	overrides := make([]string, len(theme.Overrides))
+	for i, name := range theme.Overrides {
+		overrides[i] = "templates/" + name
+	}
+	return tmpl.ParseFS(theme.files, overrides...)
+}
+
+// Every loaded theme, default first
+func listThemes() []*Theme {
+	themesMu.RLock()
+	defer themesMu.RUnlock()
+	list := make([]*Theme, 0, len(themes))
+	for _, t := range themes {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Name == defaultThemeName) != (list[j].Name == defaultThemeName) {
+			return list[i].Name == defaultThemeName
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func themeByName(name string) (*Theme, bool) {
+	themesMu.RLock()
+	defer themesMu.RUnlock()
+	t, ok := themes[name]
+	return t, ok
+}
+
+// Each site picks its own theme. The choice is kept in the primary
+// database with the other settings, since only it is cached in memory.
+func themeSettingKey(s *Site) string {
+	if s.Primary() {
+		return "theme"
+	}
+	return "theme:site-" + strconv.Itoa(s.ID)
+}
+
+// Theme the context's site uses; the default when its theme has gone away
+func activeThemeName(ctx context.Context) string {
+	name := getSetting(themeSettingKey(siteFromContext(ctx)), defaultThemeName) // from settings.go
+	if _, ok := themeByName(name); !ok {
+		return defaultThemeName
+	}
+	return name
+}
+
+// Cookie value letting this site's admin preview a theme
+func themePreviewToken(s *Site, name string) string {
+	mac := hmac.New(sha256.New, []byte(siteAdminToken(s))) // from sites.go
+	mac.Write([]byte("theme-preview:" + name))
+	return name + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Theme from a valid preview cookie
+func previewThemeName(c *gin.Context) (string, bool) {
+	value, err := c.Cookie(themePreviewCookie)
+	if err != nil {
+		return "", false
+	}
+	name, _, _ := strings.Cut(value, ".")
+	if subtle.ConstantTimeCompare([]byte(value), []byte(themePreviewToken(siteFromContext(c.Request.Context()), name))) != 1 {
+		return "", false
+	}
+	if _, ok := themeByName(name); !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// Response writer carrying the theme to render with and the request's
+// context; gin hands renderers only the writer, not the request
+type themedWriter struct {
+	gin.ResponseWriter
+	theme string
+	ctx   context.Context
+	path  string // for render error reports (from rendererrors.go)
+}
+
+// Pick the request's theme: the one being previewed, else the site's
+func themeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		theme, previewing := previewThemeName(c)
+		if previewing {
+			c.Header("Cache-Control", "no-store")
+		} else {
+			theme = activeThemeName(c.Request.Context())
+		}
+		c.Writer = &themedWriter{ResponseWriter: c.Writer, theme: theme, ctx: c.Request.Context(), path: c.Request.URL.Path}
+		c.Next()
+	}
+}
+
+// gin HTML renderer that uses the template set of the request's theme
+type themeRenderer struct{}
+
+func (themeRenderer) Instance(name string, data any) render.Render {
+	return themedHTML{name: name, data: data}
+}
+
+type themedHTML struct {
+	name string
+	data any
+}
+
+func (r themedHTML) Render(w http.ResponseWriter) error {
+	name, path := defaultThemeName, ""
+	tw, themed := w.(*themedWriter)
+	if themed {
+		name, path = tw.theme, tw.path
+	}
+
+	themesMu.RLock()
+	tmpl, ok := themeTemplates[name]
+	if !ok {
+		tmpl = themeTemplates[defaultThemeName]
+	}
+	theme := themes[name]
+	themesMu.RUnlock()
+
+	// Pick up template edits without a restart while developing, as gin does
+	if gin.IsDebugging() && theme != nil {
+		if fresh, err := parseThemeTemplates(theme); err == nil {
+			tmpl = fresh
+		} else {
+			recordTemplateError(r.name, name, path, r.data, err)
+			writeRenderErrorPage(w, tmpl)
+			return err
+		}
+	}
+
+	// Rendered in full first so slot and poll shortcodes can be filled in
+	// (from snippets.go, polls.go), banners added (from banners.go) and the
+	// favicon link swapped for generated icons (from icons.go)
+	// Nothing is written until the page has rendered, so a failure gets the
+	// 500 page rather than half a page (from rendererrors.go)
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, r.name, r.data); err != nil {
+		recordTemplateError(r.name, name, path, r.data, err)
+		writeRenderErrorPage(w, tmpl)
+		return err
+	}
+	page := buf.Bytes()
+	if themed && !strings.HasPrefix(r.name, "admin-") {
+		page = expandSlots(tw.ctx, page)
+		page = expandPolls(page)
+		page = insertBanners(tw.ctx, tmpl, page)
+		page = insertIconLinks(tw.ctx, page)
+		page = insertEngagementScript(page) // from engagement.go
+	}
+	page = insertDegradedNotice(tw.ctx, page, strings.HasPrefix(r.name, "admin-")) // from degraded.go
+	if strings.HasPrefix(r.name, "admin-") && r.name != "admin-login.html" && r.name != "admin-setup.html" {
+		page = insertAdminFooter(page) // from version.go
+	}
+	r.WriteContentType(w)
+	_, err := w.Write(page)
+	return err
+}
+
+func (r themedHTML) WriteContentType(w http.ResponseWriter) {
+	render.HTML{}.WriteContentType(w)
+}
+
+// Setup theme asset routes; themes' CSS and other static files
+func setupThemeRoutes(r *gin.Engine) {
+	r.GET("/themes/:theme/*filepath", func(c *gin.Context) {
+		theme, ok := themeByName(c.Param("theme"))
+		if !ok || theme.files == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		static, err := fs.Sub(theme.files, "static")
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.FileFromFS(strings.TrimPrefix(c.Param("filepath"), "/"), http.FS(static))
+	})
+}
+
+// Setup admin theme routes
+func setupThemeAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.POST("/settings/theme", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		site := siteFromContext(ctx)
+		name := c.PostForm("theme")
+		if _, ok := themeByName(name); !ok {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "Unknown theme",
+			})
+			return
+		}
+
+		if err := setSetting(withSite(ctx, primarySite), themeSettingKey(site), name); err != nil {
+			log.Printf("Error saving theme: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save theme",
+			})
+			return
+		}
+
+		c.SetCookie(themePreviewCookie, "", -1, "/", "", false, true)
+		log.Printf("Theme set to %s by admin from %s", name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Theme set to "+name))
+	})
+
+	// Preview a theme across the public site without switching visitors to it
+	adminGroup.POST("/settings/theme/preview", func(c *gin.Context) {
+		site := siteFromContext(c.Request.Context())
+		name := c.PostForm("theme")
+		if _, ok := themeByName(name); !ok || c.PostForm("stop") == "1" {
+			c.SetCookie(themePreviewCookie, "", -1, "/", "", false, true)
+			c.Redirect(http.StatusSeeOther, "/admin/settings")
+			return
+		}
+
+		c.SetCookie(themePreviewCookie, themePreviewToken(site, name), 3600, "/", "", false, true)
+		log.Printf("Theme %s previewed by admin from %s", name, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/")
+	})
+}
@@ -0,0 +1,203 @@
+// timeline.go - Chronological timeline of work, education and projects
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Entries per page; later ones load as the reader scrolls
+const timelinePageSize = 10
+
+// A kind of timeline entry, which the page can be filtered to
+type TimelineKind struct {
+	Name  string
+	Label string
+}
+
+var timelineKinds = []TimelineKind{
+	{"work", "Work"},
+	{"education", "Education"},
+	{"certification", "Certifications"},
+	{"project", "Projects"},
+}
+
+// Kind the page is filtered to by ?kind=, "" for everything
+func timelineKindFilter(c *gin.Context) string {
+	for _, k := range timelineKinds {
+		if k.Name == c.Query("kind") {
+			return k.Name
+		}
+	}
+	return ""
+}
+
+// One dated event from the content tables
+type TimelineEntry struct {
+	Kind     string
+	Title    string
+	Subtitle string // organization, or a project's tech
+	Start    string // display dates as entered, like "Aug 2023"
+	End      string // empty for project launches
+	URL      string
+	Date     time.Time // start, for ordering; zero when it can't be read
+}
+
+// Label of the entry's kind
+func (e TimelineEntry) KindLabel() string {
+	for _, k := range timelineKinds {
+		if k.Name == e.Kind {
+			return k.Label
+		}
+	}
+	return e.Kind
+}
+
+// Date range shown with the entry
+func (e TimelineEntry) Dates() string {
+	if e.End == "" || e.End == e.Start {
+		return e.Start
+	}
+	return e.Start + " – " + e.End
+}
+
+// Read a display date like "Sept 2019"
+func timelineDate(display string) time.Time {
+	iso := resumeDate(display) // from resume.go
+	for _, layout := range []string{"2006-01", "2006"} {
+		if t, err := time.Parse(layout, iso); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Timeline entries newest first, all or of one kind. Projects without a
+// launch date aren't on the timeline.
+func getTimeline(ctx context.Context, kind string) ([]TimelineEntry, error) {
+	var entries []TimelineEntry
+	for _, experienceKind := range []string{experienceWork, experienceEducation} {
+		experiences, err := getExperiences(ctx, experienceKind)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range experiences {
+			entryKind := e.Kind
+			if e.Certification {
+				entryKind = "certification"
+			}
+			entries = append(entries, TimelineEntry{
+				Kind:     entryKind,
+				Title:    e.Title,
+				Subtitle: e.Organization,
+				Start:    e.StartDate,
+				End:      e.EndDate,
+				URL:      e.URL,
+				Date:     timelineDate(e.StartDate),
+			})
+		}
+	}
+
+	projects, err := getProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		if p.LaunchedOn == "" {
+			continue
+		}
+		entries = append(entries, TimelineEntry{
+			Kind:     "project",
+			Title:    p.Title,
+			Subtitle: strings.Join(p.Tech, ", "),
+			Start:    p.LaunchedOn,
+			URL:      p.URL,
+			Date:     timelineDate(p.LaunchedOn),
+		})
+	}
+
+	if kind != "" {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if e.Kind == kind {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+	// Undated entries go last, in the order they were entered
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.After(entries[j].Date)
+	})
+	return entries, nil
+}
+
+// A page of the timeline, and where the next one loads from
+type TimelinePage struct {
+	Entries  []TimelineEntry
+	MoreURL  string // full page, for readers without JavaScript
+	MoreHTMX string // just the entries
+}
+
+// The entries from ?offset=, for ?kind=
+func timelinePage(c *gin.Context) (TimelinePage, error) {
+	kind := timelineKindFilter(c)
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	entries, err := getTimeline(c.Request.Context(), kind)
+	if err != nil {
+		return TimelinePage{}, err
+	}
+	var page TimelinePage
+	if offset < len(entries) {
+		page.Entries = entries[offset:min(offset+timelinePageSize, len(entries))]
+	}
+	if next := offset + timelinePageSize; next < len(entries) {
+		query := url.Values{"offset": {strconv.Itoa(next)}}
+		if kind != "" {
+			query.Set("kind", kind)
+		}
+		page.MoreURL = "/timeline?" + query.Encode()
+		page.MoreHTMX = "/timeline/entries?" + query.Encode()
+	}
+	return page, nil
+}
+
+// Setup the public timeline page and the partial later entries load from
+func setupTimelineRoutes(r *gin.Engine) {
+	r.GET("/timeline", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		page, err := timelinePage(c)
+		if err != nil {
+			log.Printf("Error loading timeline: %v", err)
+		}
+		c.HTML(http.StatusOK, "timeline.html", gin.H{
+			"title": "Timeline",
+			"kinds": timelineKinds,
+			"kind":  timelineKindFilter(c),
+			"page":  page,
+			"seo":   getPageSEO(ctx, "/timeline"),
+		})
+	})
+
+	r.GET("/timeline/entries", func(c *gin.Context) {
+		page, err := timelinePage(c)
+		if err != nil {
+			log.Printf("Error loading timeline: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.HTML(http.StatusOK, "timeline-entries", page)
+	})
+}
@@ -0,0 +1,249 @@
+// trackingexclusions.go - Admin-managed tracking exclusion rules
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What a rule's pattern is matched against
+const (
+	exclusionPath = "path" // glob as in path.Match; a trailing * matches the rest of the path
+	exclusionUA   = "ua"   // text found anywhere in the User-Agent, any case
+	exclusionIP   = "ip"   // client IP, stored hashed
+)
+
+// Rule excluding matching requests from tracking, like an uptime monitor's
+// or the owner's own
+type TrackingExclusion struct {
+	ID        int
+	Kind      string
+	Pattern   string
+	Note      string
+	CreatedAt time.Time
+
+	hits *atomic.Int64 // since the rules were last loaded
+}
+
+// Requests the rule has matched since it was loaded
+func (e TrackingExclusion) Hits() int64 {
+	if e.hits == nil {
+		return 0
+	}
+	return e.hits.Load()
+}
+
+// IP rules are keyed with their own secret rather than hashIP, whose salt
+// rotates daily (from rotation.go), so a rule keeps matching the same address
+const trackingExclusionKeySetting = "tracking_exclusion_key"
+
+// Rules per site, loaded on first use and dropped whenever the admin
+// changes them, so edits take effect on the next request
+var (
+	trackingExclusionsMu sync.RWMutex
+	trackingExclusions   = map[int][]TrackingExclusion{}
+)
+
+// Initialize the exclusion rules table and the IP key
+func initExclusionRules() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS tracking_exclusions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (kind, pattern)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create tracking_exclusions table:", err)
+	}
+
+	if getSetting(trackingExclusionKeySetting, "") == "" { // from settings.go
+		if err := setSetting(context.Background(), trackingExclusionKeySetting, generateAdminToken()); err != nil {
+			log.Fatal("Failed to store tracking exclusion key:", err)
+		}
+	}
+}
+
+// Hash an IP for an exclusion rule
+func exclusionIPHash(ip string) string {
+	mac := hmac.New(sha256.New, []byte(getSetting(trackingExclusionKeySetting, "")))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// The site's rules, from memory when they've been loaded
+func loadTrackingExclusions(ctx context.Context) []TrackingExclusion {
+	siteID := siteFromContext(ctx).ID // from sites.go
+	trackingExclusionsMu.RLock()
+	rules, ok := trackingExclusions[siteID]
+	trackingExclusionsMu.RUnlock()
+	if ok {
+		return rules
+	}
+
+	rows, err := dbQuery(ctx, "SELECT id, kind, pattern, note, created_at FROM tracking_exclusions ORDER BY kind, pattern")
+	if err != nil {
+		// Count visits as usual; the next request tries again
+		log.Printf("Error loading tracking exclusions: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := TrackingExclusion{hits: new(atomic.Int64)}
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Pattern, &r.Note, &r.CreatedAt); err != nil {
+			continue
+		}
+		rules = append(rules, r)
+	}
+
+	trackingExclusionsMu.Lock()
+	trackingExclusions[siteID] = rules
+	trackingExclusionsMu.Unlock()
+	return rules
+}
+
+// Reload the site's rules on their next use
+func invalidateTrackingExclusions(ctx context.Context) {
+	trackingExclusionsMu.Lock()
+	delete(trackingExclusions, siteFromContext(ctx).ID)
+	trackingExclusionsMu.Unlock()
+}
+
+// Whether a path matches a path rule's glob
+func exclusionPathMatch(pattern, requestPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[") {
+		return strings.HasPrefix(requestPath, prefix)
+	}
+	matched, _ := path.Match(pattern, requestPath)
+	return matched
+}
+
+// Whether a rule excludes the request; the IP is hashed only if an IP rule
+// needs it
+func trackingExcluded(c *gin.Context, requestPath string) bool {
+	rules := loadTrackingExclusions(c.Request.Context())
+	if len(rules) == 0 {
+		return false
+	}
+
+	userAgent := strings.ToLower(c.GetHeader("User-Agent"))
+	ipHash := ""
+	for _, rule := range rules {
+		matched := false
+		switch rule.Kind {
+		case exclusionPath:
+			matched = exclusionPathMatch(rule.Pattern, requestPath)
+		case exclusionUA:
+			matched = strings.Contains(userAgent, strings.ToLower(rule.Pattern))
+		case exclusionIP:
+			if ipHash == "" {
+				ipHash = exclusionIPHash(c.ClientIP())
+			}
+			matched = rule.Pattern == ipHash
+		}
+		if matched {
+			rule.hits.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// Check a submitted rule and turn it into what's stored; IPs become hashes
+func normalizeTrackingExclusion(kind, pattern string) (string, bool) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || len(pattern) > 500 {
+		return "", false
+	}
+	switch kind {
+	case exclusionPath:
+		if !strings.HasPrefix(pattern, "/") {
+			return "", false
+		}
+		_, err := path.Match(pattern, "/")
+		return pattern, err == nil
+	case exclusionUA:
+		return pattern, true
+	case exclusionIP:
+		ip := net.ParseIP(pattern)
+		if ip == nil {
+			return "", false
+		}
+		return exclusionIPHash(ip.String()), true
+	}
+	return "", false
+}
+
+// Setup admin routes for the exclusion rules
+func setupTrackingExclusionAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/tracking-exclusions", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "admin-tracking-exclusions.html", gin.H{
+			"exclusions": loadTrackingExclusions(c.Request.Context()),
+			"myIPHash":   exclusionIPHash(c.ClientIP()),
+			"myIP":       c.ClientIP(),
+		})
+	})
+
+	adminGroup.POST("/tracking-exclusions", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		kind := c.PostForm("kind")
+		pattern, ok := normalizeTrackingExclusion(kind, c.PostForm("pattern"))
+		if !ok {
+			c.HTML(http.StatusBadRequest, "admin-error.html", gin.H{
+				"error": "A rule needs a path glob starting with /, User-Agent text, or an IP address",
+			})
+			return
+		}
+		note := strings.TrimSpace(c.PostForm("note"))
+
+		_, err := dbExec(ctx, `
+			INSERT INTO tracking_exclusions (kind, pattern, note) VALUES (?, ?, ?)
+			ON CONFLICT(kind, pattern) DO UPDATE SET note = excluded.note
+		`, kind, pattern, note)
+		if err != nil {
+			log.Printf("Error saving tracking exclusion: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save tracking exclusion",
+			})
+			return
+		}
+		invalidateTrackingExclusions(ctx)
+
+		log.Printf("Tracking exclusion (%s) %s saved by admin from %s", kind, pattern, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/tracking-exclusions")
+	})
+
+	adminGroup.DELETE("/tracking-exclusions/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM tracking_exclusions WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tracking exclusion"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tracking exclusion not found"})
+			return
+		}
+		invalidateTrackingExclusions(ctx)
+
+		log.Printf("Tracking exclusion %s deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Tracking exclusion deleted"})
+	})
+}
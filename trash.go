@@ -0,0 +1,313 @@
+// trash.go - Soft-delete trash with restore and scheduled purge
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Table whose rows go to the trash instead of being deleted
+type trashKind struct {
+	Label string
+	Table string
+	Key   string // primary key column
+	Title string // column shown in the trash list
+}
+
+// Table and column names here are interpolated into SQL
+var trashKinds = map[string]trashKind{
+	"url":     {Label: "Short URL", Table: "urls", Key: "short_code", Title: "original_url"},
+	"post":    {Label: "Post", Table: "posts", Key: "id", Title: "title"},
+	"message": {Label: "Message", Table: "messages", Key: "id", Title: "subject"},
+}
+
+// Trashed row
+type TrashItem struct {
+	ID        int
+	Kind      string
+	EntityID  string
+	Title     string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+}
+
+// Days trashed rows are kept before being purged for good
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Initialize trash storage and start the purge job
+func initTrash() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS trash (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		entity_id TEXT NOT NULL,
+		title TEXT,
+		row_data TEXT NOT NULL,
+		ip_hash TEXT,
+		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create trash table:", err)
+	}
+
+	startWorker("trash-purge", trashPurgeWorker)
+}
+
+// Move a row to the trash; returns sql.ErrNoRows if it doesn't exist
+func moveToTrash(ctx context.Context, kindName, id, ipHash string) error {
+	kind, ok := trashKinds[kindName]
+	if !ok {
+		return fmt.Errorf("unknown trash kind %q", kindName)
+	}
+
+	rows, err := dbQuery(ctx, fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", kind.Table, kind.Key), id)
+	if err != nil {
+		return err
+	}
+	columns, _ := rows.Columns()
+	if !rows.Next() {
+		rows.Close()
+		return sql.ErrNoRows
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{}
+	title := ""
+	for i, column := range columns {
+		value := values[i]
+		switch v := value.(type) {
+		case time.Time:
+			// Same layout as CURRENT_TIMESTAMP so the restored row reads back alike
+			value = v.UTC().Format("2006-01-02 15:04:05")
+		case []byte:
+			value = string(v)
+		}
+		data[column] = value
+		if column == kind.Title {
+			title = fmt.Sprint(value)
+		}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	tx, cancel, err := dbBegin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO trash (kind, entity_id, title, row_data, ip_hash) VALUES (?, ?, ?, ?, ?)",
+		kindName, id, title, string(encoded), ipHash)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", kind.Table, kind.Key), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Put a trashed row back where it came from
+func restoreFromTrash(ctx context.Context, trashID string) (TrashItem, error) {
+	var item TrashItem
+	var rowData string
+	err := dbQueryRow(ctx, "SELECT id, kind, entity_id, row_data FROM trash WHERE id = ?", trashID).
+		Scan(&item.ID, &item.Kind, &item.EntityID, &rowData)
+	if err != nil {
+		return item, err
+	}
+	kind, ok := trashKinds[item.Kind]
+	if !ok {
+		return item, fmt.Errorf("unknown trash kind %q", item.Kind)
+	}
+
+	// Keep numbers exact rather than round-tripping through float64
+	decoder := json.NewDecoder(bytes.NewReader([]byte(rowData)))
+	decoder.UseNumber()
+	data := map[string]interface{}{}
+	if err := decoder.Decode(&data); err != nil {
+		return item, err
+	}
+
+	var columns, placeholders []string
+	var args []interface{}
+	for column, value := range data {
+		columns = append(columns, column)
+		placeholders = append(placeholders, "?")
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				value = i
+			} else {
+				value, _ = n.Float64()
+			}
+		}
+		args = append(args, value)
+	}
+
+	tx, cancel, err := dbBegin(ctx)
+	if err != nil {
+		return item, err
+	}
+	defer cancel()
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", kind.Table,
+		strings.Join(columns, ", "), strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return item, err
+	}
+	if _, err := tx.Exec("DELETE FROM trash WHERE id = ?", item.ID); err != nil {
+		return item, err
+	}
+	return item, tx.Commit()
+}
+
+// Get trashed rows, newest first
+func getTrashItems(ctx context.Context) ([]TrashItem, error) {
+	rows, err := dbQuery(ctx, "SELECT id, kind, entity_id, COALESCE(title, ''), deleted_at FROM trash ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retention := trashRetention()
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&item.ID, &item.Kind, &item.EntityID, &item.Title, &item.DeletedAt); err != nil {
+			continue
+		}
+		item.PurgeAt = item.DeletedAt.Add(retention)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Permanently delete trashed rows older than the retention window
+func purgeTrash(ctx context.Context) error {
+	items, err := getTrashItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	purged := 0
+	for _, item := range items {
+		if time.Now().Before(item.PurgeAt) {
+			continue
+		}
+		if _, err := dbExec(ctx, "DELETE FROM trash WHERE id = ?", item.ID); err != nil {
+			return err
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("Purged %d item(s) from the trash", purged)
+	}
+	return nil
+}
+
+// Background worker purging expired trash
+func trashPurgeWorker() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		forEachSite(func(ctx context.Context) { // from sites.go
+			if siteErr := purgeTrash(ctx); siteErr != nil {
+				log.Printf("Error purging trash for %s: %v", siteFromContext(ctx).Name, siteErr)
+				err = siteErr
+			}
+		})
+		recordJobRun("trash-purge", time.Hour, err)
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Setup admin trash routes
+func setupTrashAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/trash", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		items, err := getTrashItems(ctx)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load trash",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-trash.html", gin.H{
+			"items":     items,
+			"kinds":     trashKinds,
+			"retention": int(trashRetention().Hours() / 24),
+		})
+	})
+
+	adminGroup.POST("/trash/:id/restore", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		item, err := restoreFromTrash(ctx, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error restoring trash item %s: %v", c.Param("id"), err)
+			c.JSON(http.StatusConflict, gin.H{"error": "Failed to restore (has the slug or short code been reused?)"})
+			return
+		}
+
+		log.Printf("%s %s restored from trash by admin from %s", trashKinds[item.Kind].Label, item.EntityID, hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Restored"})
+	})
+
+	adminGroup.DELETE("/trash/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM trash WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete item"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+			return
+		}
+
+		log.Printf("Trash item %s permanently deleted by admin from %s", c.Param("id"), hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": "Permanently deleted"})
+	})
+}
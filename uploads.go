@@ -0,0 +1,44 @@
+// uploads.go - Admin file uploads and downloads
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Read an uploaded file from a form field, at most maxBytes; returns its
+// contents, a safe name for it, and a problem that's empty when valid
+func uploadFromForm(c *gin.Context, field string, maxBytes int64) ([]byte, string, string) {
+	file, header, err := c.Request.FormFile(field)
+	if err != nil {
+		return nil, "", "Choose a file to upload"
+	}
+	defer file.Close()
+
+	if header.Size > maxBytes {
+		return nil, "", fmt.Sprintf("Files must be at most %d MB", maxBytes>>20)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil || int64(len(data)) > maxBytes {
+		return nil, "", "Could not read the uploaded file"
+	}
+	// Browsers may send a full path, and Windows ones with backslashes
+	name := strings.TrimSpace(filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/")))
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return data, name, ""
+}
+
+// Send a stored file as a download, never rendered in the site's origin
+func serveDownload(c *gin.Context, filename string, data []byte) {
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
@@ -0,0 +1,276 @@
+// version.go - Build info and release checks
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Set at build time, e.g. in the Dockerfile:
+//
+//	go build -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds from a git checkout fill them in from the embedded VCS info.
+var (
+	buildCommit string
+	buildTime   string
+)
+
+// How often GitHub is asked for the latest release
+const releaseCheckInterval = 6 * time.Hour
+
+// GitHub repository whose releases are checked, "owner/name"
+const defaultReleaseRepo = "Zachkp/zach-dev"
+
+var releaseClient = newHTTPClient("release check", 15*time.Second) // from httpclient.go
+
+// Trips when GitHub keeps failing; the last good release check is kept meanwhile
+var githubBreaker = newCircuitBreaker("github", 3, 30*time.Minute, func() error {
+	resp, err := releaseClient.Get("https://api.github.com/zen")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+	return nil
+})
+
+// What the running binary was built from
+type BuildInfo struct {
+	Commit    string     // full SHA; "" when unknown
+	Time      *time.Time // build time, or the commit time when not stamped
+	Modified  bool       // built from a tree with uncommitted changes
+	GoVersion string
+}
+
+// First 7 characters of the commit, or "unknown"
+func (b BuildInfo) ShortCommit() string {
+	if len(b.Commit) < 7 {
+		return "unknown"
+	}
+	return b.Commit[:7]
+}
+
+// Latest release and how it compares with the running build
+type ReleaseCheck struct {
+	Tag             string
+	URL             string
+	PublishedAt     time.Time
+	UpdateAvailable bool
+	CheckedAt       time.Time
+	Error           string
+}
+
+var (
+	releaseCheckMu sync.Mutex
+	releaseCheck   *ReleaseCheck
+)
+
+// Build info from the linker flags, then the binary's VCS stamp, then the
+// commit Render sets in the environment
+var currentBuild = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{Commit: buildCommit, GoVersion: runtime.Version()}
+	if t, err := time.Parse(time.RFC3339, buildTime); err == nil {
+		info.Time = &t
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if t, err := time.Parse(time.RFC3339, s.Value); err == nil && info.Time == nil {
+					info.Time = &t
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = os.Getenv("RENDER_GIT_COMMIT")
+	}
+	return info
+})
+
+// Repository checked for releases; GITHUB_REPO overrides it for forks
+func releaseRepo() string {
+	if repo := os.Getenv("GITHUB_REPO"); repo != "" {
+		return repo
+	}
+	return defaultReleaseRepo
+}
+
+// Start the release checker
+func initVersion() {
+	build := currentBuild()
+	log.Printf("Running build %s (%s)", build.ShortCommit(), build.GoVersion)
+	startWorker("release-check", releaseCheckWorker) // from safego.go
+}
+
+func releaseCheckWorker() {
+	ticker := time.NewTicker(releaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		check := checkLatestRelease(context.Background())
+		if previous := latestReleaseCheck(); check.Error != "" && previous != nil && previous.Tag != "" {
+			// Keep showing the last release GitHub reported
+			fallback := *previous
+			fallback.Error = check.Error
+			check = &fallback
+		}
+		releaseCheckMu.Lock()
+		releaseCheck = check
+		releaseCheckMu.Unlock()
+
+		var err error
+		if check.Error != "" {
+			err = fmt.Errorf("%s", check.Error)
+		}
+		recordJobRun("release-check", releaseCheckInterval, err) // from diagnostics.go
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// Latest release check, or nil before the first one finishes
+func latestReleaseCheck() *ReleaseCheck {
+	releaseCheckMu.Lock()
+	defer releaseCheckMu.Unlock()
+	return releaseCheck
+}
+
+func githubGet(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.github.com/repos/"+releaseRepo()+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	var resp *http.Response
+	err = githubBreaker.Do(func() error { // from breaker.go
+		resp, err = releaseClient.Do(req)
+		if err == nil && resp.StatusCode >= 500 {
+			resp.Body.Close()
+			return fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub returned status %d for %s", resp.StatusCode, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Compare the latest release with the running build. With a known commit
+// GitHub says whether the build contains the release; otherwise a release
+// published after the build time counts as newer.
+func checkLatestRelease(ctx context.Context) *ReleaseCheck {
+	check := &ReleaseCheck{CheckedAt: time.Now()}
+	var release struct {
+		TagName     string    `json:"tag_name"`
+		HTMLURL     string    `json:"html_url"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+	if err := githubGet(ctx, "/releases/latest", &release); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Tag, check.URL, check.PublishedAt = release.TagName, release.HTMLURL, release.PublishedAt
+
+	build := currentBuild()
+	if build.Commit != "" {
+		var comparison struct {
+			Status string `json:"status"` // ahead, behind, diverged or identical
+		}
+		err := githubGet(ctx, "/compare/"+url.PathEscape(release.TagName)+"..."+build.Commit, &comparison)
+		if err == nil {
+			check.UpdateAvailable = comparison.Status == "behind" || comparison.Status == "diverged"
+			return check
+		}
+		log.Printf("Release check: can't compare %s with %s: %v", release.TagName, build.ShortCommit(), err)
+	}
+	if build.Time != nil {
+		check.UpdateAvailable = release.PublishedAt.After(*build.Time)
+	}
+	return check
+}
+
+// Diagnostics entry for the build and release check
+func checkBuild() DiagnosticCheck {
+	build := currentBuild()
+	check := DiagnosticCheck{Name: "Build", Status: checkPass}
+	check.Detail = "commit " + build.ShortCommit()
+	if build.Modified {
+		check.Detail += " (modified)"
+	}
+	if build.Time != nil {
+		check.Detail += ", built " + build.Time.UTC().Format("Jan 2, 2006 15:04 UTC")
+	}
+
+	switch release := latestReleaseCheck(); {
+	case release == nil:
+	case release.Error != "":
+		check.Detail += "; release check failed: " + release.Error
+	case release.UpdateAvailable:
+		check.Status = checkFail
+		check.Detail += "; release " + release.Tag + " is newer"
+	default:
+		check.Detail += "; up to date with " + release.Tag
+	}
+	return check
+}
+
+// Footer with the build and any available update, added to admin pages
+func insertAdminFooter(page []byte) []byte {
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return page
+	}
+
+	build := currentBuild()
+	footer := `<footer class="max-w-7xl mx-auto px-4 sm:px-6 lg:px-8 py-6 text-xs text-gray-500">`
+	if build.Commit != "" {
+		footer += fmt.Sprintf(`Build <a href="https://github.com/%s/commit/%s" class="font-mono hover:text-purple-300">%s</a>`,
+			template.HTMLEscapeString(releaseRepo()), template.HTMLEscapeString(build.Commit), build.ShortCommit())
+	} else {
+		footer += `Build <span class="font-mono">unknown</span>`
+	}
+	if build.Time != nil {
+		footer += " &middot; built " + build.Time.UTC().Format("Jan 2, 2006 15:04 UTC")
+	}
+	if release := latestReleaseCheck(); release != nil && release.UpdateAvailable {
+		footer += fmt.Sprintf(` &middot; <a href="%s" class="text-yellow-400 hover:text-yellow-300">Update available: %s</a>`,
+			template.HTMLEscapeString(release.URL), template.HTMLEscapeString(release.Tag))
+	}
+	footer += "</footer>"
+
+	out := make([]byte, 0, len(page)+len(footer))
+	out = append(out, page[:i]...)
+	out = append(out, footer...)
+	return append(out, page[i:]...)
+}
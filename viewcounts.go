@@ -0,0 +1,159 @@
+// viewcounts.go - Public view count badges
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether public pages show view counts, "on" or unset
+const viewCountsSetting = "public_view_counts"
+
+// Counts shown publicly may be this stale
+const viewCountTTL = 10 * time.Minute
+
+// Counts remembered before the cache is started over
+const viewCountCacheLimit = 1000
+
+// Cached view counts by site and path
+var (
+	viewCountsMu sync.Mutex
+	viewCounts   = make(map[string]viewCountEntry)
+)
+
+type viewCountEntry struct {
+	count    ViewCount
+	loadedAt time.Time
+}
+
+// All-time views of one page
+type ViewCount struct {
+	Path  string
+	Views int64
+}
+
+// Badge text, e.g. "1,234 views"
+func (v ViewCount) Label() string {
+	digits := strconv.FormatInt(v.Views, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	if v.Views == 1 {
+		return b.String() + " view"
+	}
+	return b.String() + " views"
+}
+
+// Initialize indexes for looking views up by path
+func initViewCounts() {
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_visitors_path ON visitors (path)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_page_view_counters_path ON page_view_counters (path)`)
+}
+
+// Whether view count badges are shown
+func viewCountsEnabled() bool {
+	return getSetting(viewCountsSetting, "") == "on" // from settings.go
+}
+
+// Pages that may show a badge: blog posts and the project status page
+func viewCountPath(path string) bool {
+	if path == "/projects/status" {
+		return true
+	}
+	slug, ok := strings.CutPrefix(path, "/blog/")
+	return ok && slug != "" && !strings.Contains(slug, "/")
+}
+
+// Views of a path across every analytics mode: archived months, the
+// aggregate counters and the visitor rows not archived yet. Cached, since
+// badges are requested on every page view.
+func getViewCount(ctx context.Context, path string) (ViewCount, error) {
+	key := strconv.Itoa(siteFromContext(ctx).ID) + ":" + path // from sites.go
+
+	viewCountsMu.Lock()
+	entry, ok := viewCounts[key]
+	viewCountsMu.Unlock()
+	if ok && time.Since(entry.loadedAt) < viewCountTTL {
+		return entry.count, nil
+	}
+
+	count := ViewCount{Path: path}
+	err := dbQueryRow(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(views), 0) FROM visitor_archive_paths WHERE path = ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM page_view_counters WHERE path = ?) +
+			(SELECT COALESCE(SUM(views), 0) FROM country_page_counts WHERE path = ?) +
+			(SELECT COUNT(*) FROM visitors WHERE path = ?)`,
+		path, path, path, path).Scan(&count.Views)
+	if err != nil {
+		return count, err
+	}
+
+	viewCountsMu.Lock()
+	if len(viewCounts) >= viewCountCacheLimit {
+		clear(viewCounts)
+	}
+	viewCounts[key] = viewCountEntry{count, time.Now()}
+	viewCountsMu.Unlock()
+	return count, nil
+}
+
+// Setup the HTMX partial the badges are loaded from, so cached pages
+// still show a current count
+func setupViewCountRoutes(r *gin.Engine) {
+	r.GET("/partials/views", func(c *gin.Context) {
+		path := c.Query("path")
+		if !viewCountsEnabled() || !viewCountPath(path) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		count, err := getViewCount(c.Request.Context(), path)
+		if err != nil {
+			log.Printf("Error loading view count for %s: %v", path, err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.HTML(http.StatusOK, "view-count.html", count)
+	})
+}
+
+// Setup the admin switch for view count badges
+func setupViewCountAdminRoutes(adminGroup *gin.RouterGroup) {
+	// Like the analytics mode, the switch applies to every site
+	adminGroup.POST("/settings/view-counts", superAdminMiddleware(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		value := ""
+		if c.PostForm("enabled") == "on" {
+			value = "on"
+		}
+
+		if err := setSetting(ctx, viewCountsSetting, value); err != nil {
+			log.Printf("Error saving view count setting: %v", err)
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to save view count setting",
+			})
+			return
+		}
+
+		state := "off"
+		if value != "" {
+			state = value
+		}
+		log.Printf("Public view counts turned %s by admin from %s", state, hashIP(c.ClientIP()))
+		c.Redirect(http.StatusSeeOther, "/admin/settings?message="+url.QueryEscape("Public view counts turned "+state))
+	})
+}
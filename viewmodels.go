@@ -0,0 +1,120 @@
+// viewmodels.go - Typed view models for templates
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// With a struct, a template naming a field the handler doesn't provide fails
+// to render (and gets the 500 page, from rendererrors.go) where a gin.H would
+// quietly give it nothing. Fields tagged view:"required" must also be set,
+// which is checked in debug mode so a handler that forgets one fails during
+// development rather than rendering an empty section. So far the homepage
+// and its htmx sections have view models; the templates still taking a gin.H
+// are listed in viewModelsPending (viewmodels_test.go), and new templates
+// can't join them.
+//
+// Themes overriding these templates use the field names below. Overrides
+// written before the view models used the keys in the legacy tags, so a
+// theme's own copy of one of these templates gets a map with both.
+
+// index.html
+type HomeView struct {
+	SEO             SEOMeta       `legacy:"seo"`
+	About           string        `legacy:"aboutMeContent" view:"required"`
+	Projects        []Project     `legacy:"projects"`
+	SkillCategories []string      `legacy:"skillCategories"`
+	Availability    *Availability `legacy:"availability"` // from availability.go; nil hides the badge
+}
+
+// work-content.html
+type WorkContentView struct {
+	Experiences []Experience `legacy:"experiences"`
+}
+
+// education-content.html
+type EducationContentView struct {
+	Education []Experience `legacy:"education"`
+}
+
+// skills-content.html
+type SkillsContentView struct {
+	Skills        []Skill           `legacy:"skills"`
+	ProjectTitles map[string]string `legacy:"projectTitles" view:"required"` // project slug to title, for the project badges
+}
+
+// Templates rendered from a view model, and the model each takes
+var viewModels = map[string]reflect.Type{
+	"index.html":             reflect.TypeOf(HomeView{}),
+	"work-content.html":      reflect.TypeOf(WorkContentView{}),
+	"education-content.html": reflect.TypeOf(EducationContentView{}),
+	"skills-content.html":    reflect.TypeOf(SkillsContentView{}),
+}
+
+// Check a view is the model its template takes, with every required field set
+func checkView(name string, view any) error {
+	want, ok := viewModels[name]
+	if !ok {
+		return fmt.Errorf("%s has no view model", name)
+	}
+	v := reflect.ValueOf(view)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type() != want {
+		return fmt.Errorf("%s takes a %s, got %T", name, want.Name(), view)
+	}
+
+	var missing []string
+	for i := 0; i < want.NumField(); i++ {
+		field := want.Field(i)
+		if field.Tag.Get("view") == "required" && v.Field(i).IsZero() {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing required field(s) %s", want.Name(), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// A view as a map holding each field under its name and its legacy key, for
+// theme overrides of either age
+func legacyViewData(view any) gin.H {
+	v := reflect.Indirect(reflect.ValueOf(view))
+	data := gin.H{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		data[field.Name] = v.Field(i).Interface()
+		if legacy := field.Tag.Get("legacy"); legacy != "" {
+			data[legacy] = v.Field(i).Interface()
+		}
+	}
+	return data
+}
+
+// Render a template from its view model. In debug mode a view that fails
+// checkView is reported like a template error instead of being rendered.
+func renderView(c *gin.Context, status int, name string, view any) {
+	theme := defaultThemeName
+	if tw, ok := c.Writer.(*themedWriter); ok { // from themes.go
+		theme = tw.theme
+	}
+	if gin.IsDebugging() {
+		if err := checkView(name, view); err != nil {
+			recordTemplateError(name, theme, c.Request.URL.Path, view, err) // from rendererrors.go
+			c.HTML(http.StatusInternalServerError, "500.html", nil)
+			return
+		}
+	}
+	if t, ok := themeByName(theme); ok && oneOf(name, t.Overrides) { // from leads.go
+		c.HTML(status, name, legacyViewData(view))
+		return
+	}
+	c.HTML(status, name, view)
+}
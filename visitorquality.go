@@ -0,0 +1,61 @@
+// visitorquality.go - Visitor data-quality report
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rows given a random hash by the visitor table migration before it hashed
+// real IPs: printf('%016x', abs(random()) % 1000000000) is always below
+// 0x3B9ACA00, so the first eight digits are zero, which a real hash has a
+// one in four billion chance of
+const syntheticHashCondition = `length(hashed_ip) = 16 AND hashed_ip LIKE '00000000%'`
+
+// How many visits carry an identifier that doesn't stand for a visitor
+type VisitorDataQuality struct {
+	Total        int64
+	Synthetic    int64 // random per-row hashes from the old migration
+	FirstDay     string
+	LastDay      string
+	Unidentified int64 // no identifier, by strategy or because none was kept
+}
+
+func getVisitorDataQuality(ctx context.Context) (VisitorDataQuality, error) {
+	var q VisitorDataQuality
+	err := dbQueryRow(ctx, `
+		SELECT COUNT(*),
+			COALESCE(SUM(`+syntheticHashCondition+`), 0),
+			COALESCE(SUM(hashed_ip = ''), 0)
+		FROM visitors
+	`).Scan(&q.Total, &q.Synthetic, &q.Unidentified)
+	if err != nil || q.Synthetic == 0 {
+		return q, err
+	}
+	err = dbQueryRow(ctx, `
+		SELECT substr(MIN(timestamp), 1, 10), substr(MAX(timestamp), 1, 10)
+		FROM visitors WHERE `+syntheticHashCondition).Scan(&q.FirstDay, &q.LastDay)
+	return q, err
+}
+
+// Flag synthetic hashes, which count every such visit as a new visitor
+func checkVisitorDataQuality(ctx context.Context) DiagnosticCheck {
+	check := DiagnosticCheck{Name: "Visitor data quality"}
+	q, err := getVisitorDataQuality(ctx)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	if q.Synthetic > 0 {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%d of %d visits (%s to %s) have random hashes from an old migration, so each counts as a unique visitor",
+			q.Synthetic, q.Total, q.FirstDay, q.LastDay)
+		return check
+	}
+	check.Status = checkPass
+	check.Detail = fmt.Sprintf("%d visits, none with synthetic hashes", q.Total)
+	if q.Unidentified > 0 {
+		check.Detail += fmt.Sprintf("; %d without an identifier", q.Unidentified)
+	}
+	return check
+}
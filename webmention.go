@@ -0,0 +1,281 @@
+// webmention.go - Webmention receiver
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+)
+
+// Received webmention
+type Webmention struct {
+	ID         int          `json:"id"`
+	Source     string       `json:"source"`
+	Target     string       `json:"target"`
+	Status     string       `json:"status"`
+	Title      string       `json:"title"`
+	CreatedAt  time.Time    `json:"created_at"`
+	VerifiedAt sql.NullTime `json:"-"`
+}
+
+// Mention lifecycle: pending -> verified -> approved/rejected, or invalid
+const (
+	mentionPending  = "pending"
+	mentionVerified = "verified"
+	mentionApproved = "approved"
+	mentionRejected = "rejected"
+	mentionInvalid  = "invalid"
+)
+
+// Largest source page we will read when verifying
+const webmentionMaxSourceBytes = 1 << 20
+
+var webmentionClient = newHTTPClient("webmention", 10*time.Second) // from httpclient.go
+
+// Initialize webmention storage
+func initWebmentions() {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS webmentions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		title TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		verified_at DATETIME,
+		UNIQUE(source, target)
+	)`
+
+	_, err := db.Exec(createTable)
+	if err != nil {
+		log.Fatal("Failed to create webmentions table:", err)
+	}
+}
+
+// Targets must be pages on this site
+func isOwnURL(target *url.URL, requestHost string) bool {
+	host := strings.ToLower(target.Hostname())
+	return host == "zachkp.dev" || host == "www.zachkp.dev" || strings.EqualFold(target.Host, requestHost)
+}
+
+// Fetch the source page and confirm it links to the target
+func verifyWebmention(ctx context.Context, id int64, source, target string) {
+	status := mentionInvalid
+	title := ""
+
+	// The guarded client refuses internal addresses, on redirects and at
+	// dial time too, so a mention can't make us probe the local network
+	fetchCtx, cancel := context.WithTimeout(ctx, webmentionClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, source, nil)
+	var resp *http.Response
+	if err == nil {
+		resp, err = webmentionClient.Do(req)
+	}
+	if err != nil {
+		log.Printf("Webmention %d: error fetching source: %v", id, err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			var found bool
+			found, title = sourceLinksTo(io.LimitReader(resp.Body, webmentionMaxSourceBytes), target)
+			if found {
+				status = mentionVerified
+			}
+		} else {
+			log.Printf("Webmention %d: source returned %d", id, resp.StatusCode)
+		}
+	}
+
+	_, err = dbExec(ctx, "UPDATE webmentions SET status = ?, title = ?, verified_at = ? WHERE id = ?",
+		status, title, time.Now(), id)
+	if err != nil {
+		log.Printf("Error updating webmention %d: %v", id, err)
+	}
+}
+
+// Scan HTML for a link to target, also returning the page title
+func sourceLinksTo(r io.Reader, target string) (bool, string) {
+	found := false
+	title := ""
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return found, strings.TrimSpace(title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "title" {
+				inTitle = true
+			}
+			for _, attr := range token.Attr {
+				if (attr.Key == "href" || attr.Key == "src") && strings.TrimRight(attr.Val, "/") == strings.TrimRight(target, "/") {
+					found = true
+				}
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+// Get approved mentions of a page
+func getApprovedWebmentions(ctx context.Context, target string) ([]Webmention, error) {
+	return queryWebmentions(ctx, `
+		SELECT id, source, target, status, COALESCE(title, ''), created_at, verified_at
+		FROM webmentions
+		WHERE target = ? AND status = 'approved'
+		ORDER BY created_at
+	`, target)
+}
+
+func queryWebmentions(ctx context.Context, query string, args ...interface{}) ([]Webmention, error) {
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []Webmention
+	for rows.Next() {
+		var m Webmention
+		if err := rows.Scan(&m.ID, &m.Source, &m.Target, &m.Status, &m.Title, &m.CreatedAt, &m.VerifiedAt); err != nil {
+			continue
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+// Setup public webmention endpoint
+func setupWebmentionRoutes(r *gin.Engine) {
+	r.POST("/webmention", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		source := strings.TrimSpace(c.PostForm("source"))
+		target := strings.TrimSpace(c.PostForm("target"))
+
+		sourceURL, err := url.Parse(source)
+		if err != nil || !isPublicLongURL(source) { // from main.go
+			c.String(http.StatusBadRequest, "source must be a public http(s) URL")
+			return
+		}
+		targetURL, err := url.Parse(target)
+		if err != nil || !isValidLongURL(target) {
+			c.String(http.StatusBadRequest, "target must be an http(s) URL")
+			return
+		}
+		if !isOwnURL(targetURL, c.Request.Host) {
+			c.String(http.StatusBadRequest, "target is not on this site")
+			return
+		}
+		if sourceURL.String() == targetURL.String() {
+			c.String(http.StatusBadRequest, "source and target must differ")
+			return
+		}
+
+		// Re-sending a mention re-verifies it (the source may have changed)
+		_, err = dbExec(ctx, `
+			INSERT INTO webmentions (source, target, status) VALUES (?, ?, 'pending')
+			ON CONFLICT(source, target) DO UPDATE SET status = 'pending'
+		`, source, target)
+		if err != nil {
+			log.Printf("Error storing webmention: %v", err)
+			c.String(http.StatusInternalServerError, "could not store webmention")
+			return
+		}
+
+		var id int64
+		dbQueryRow(ctx, "SELECT id FROM webmentions WHERE source = ? AND target = ?", source, target).Scan(&id)
+		safeGo("webmention-verify", func() { verifyWebmention(context.WithoutCancel(ctx), id, source, target) })
+
+		c.String(http.StatusAccepted, "webmention accepted for verification")
+	})
+}
+
+// Setup admin moderation routes
+func setupWebmentionAdminRoutes(adminGroup *gin.RouterGroup) {
+	adminGroup.GET("/webmentions", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		mentions, err := queryWebmentions(ctx, `
+			SELECT id, source, target, status, COALESCE(title, ''), created_at, verified_at
+			FROM webmentions
+			ORDER BY created_at DESC
+			LIMIT 200
+		`)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin-error.html", gin.H{
+				"error": "Failed to load webmentions",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "admin-webmentions.html", gin.H{
+			"mentions": mentions,
+		})
+	})
+
+	// Approve a verified mention, or reject one. Only mentions whose source
+	// was found linking here can be approved; pending and invalid ones would
+	// otherwise show unchecked third-party pages on the site.
+	adminGroup.POST("/webmentions/:id/:action", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var status, from string
+		switch c.Param("action") {
+		case "approve":
+			status, from = mentionApproved, "status = 'verified'"
+		case "reject":
+			status, from = mentionRejected, "status != 'invalid'"
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown action"})
+			return
+		}
+
+		result, err := dbExec(ctx, "UPDATE webmentions SET status = ? WHERE id = ? AND "+from, status, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webmention"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			var current string
+			if err := dbQueryRow(ctx, "SELECT status FROM webmentions WHERE id = ?", c.Param("id")).Scan(&current); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Webmention not found"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Webmention is %s and can't be %s", current, status)})
+			return
+		}
+
+		log.Printf("Webmention %s %s by admin from %s", c.Param("id"), status, hashIP(c.ClientIP()))
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Webmention %s", status)})
+	})
+
+	adminGroup.DELETE("/webmentions/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := dbExec(ctx, "DELETE FROM webmentions WHERE id = ?", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webmention"})
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webmention not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Webmention deleted"})
+	})
+}